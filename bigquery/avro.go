@@ -0,0 +1,382 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+// This file decodes the Avro rows returned by the BigQuery Storage API. Only
+// the subset of Avro that BigQuery uses for its rows is supported.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// avroType is a node of a parsed Avro schema.
+type avroType struct {
+	typ      string      // a primitive type name, "record", "array" or "union"
+	logical  string      // the logicalType attribute, if any
+	sqlType  string      // the sqlType attribute that BigQuery adds, if any
+	scale    int         // the scale of a decimal
+	fields   []avroField // the fields of a record
+	items    *avroType   // the items of an array
+	branches []*avroType // the branches of a union
+}
+
+type avroField struct {
+	name string
+	typ  *avroType
+}
+
+// parseAvroSchema parses an Avro schema in its JSON form.
+func parseAvroSchema(s string) (*avroType, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, fmt.Errorf("bigquery: parsing Avro schema: %v", err)
+	}
+	return parseAvroType(v, map[string]*avroType{})
+}
+
+func parseAvroType(v interface{}, named map[string]*avroType) (*avroType, error) {
+	switch v := v.(type) {
+	case string:
+		switch v {
+		case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+			return &avroType{typ: v}, nil
+		}
+		if t, ok := named[v]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("bigquery: unsupported Avro type %q", v)
+	case []interface{}:
+		t := &avroType{typ: "union"}
+		for _, b := range v {
+			bt, err := parseAvroType(b, named)
+			if err != nil {
+				return nil, err
+			}
+			t.branches = append(t.branches, bt)
+		}
+		return t, nil
+	case map[string]interface{}:
+		typ, _ := v["type"].(string)
+		t := &avroType{typ: typ}
+		t.logical, _ = v["logicalType"].(string)
+		t.sqlType, _ = v["sqlType"].(string)
+		switch typ {
+		case "record":
+			if name, ok := v["name"].(string); ok {
+				named[name] = t
+			}
+			fields, _ := v["fields"].([]interface{})
+			for _, f := range fields {
+				fm, ok := f.(map[string]interface{})
+				if !ok {
+					return nil, errors.New("bigquery: invalid field in Avro record schema")
+				}
+				name, _ := fm["name"].(string)
+				ft, err := parseAvroType(fm["type"], named)
+				if err != nil {
+					return nil, err
+				}
+				t.fields = append(t.fields, avroField{name: name, typ: ft})
+			}
+		case "array":
+			items, err := parseAvroType(v["items"], named)
+			if err != nil {
+				return nil, err
+			}
+			t.items = items
+		case "bytes":
+			if scale, ok := v["scale"].(float64); ok {
+				t.scale = int(scale)
+			}
+		default:
+			// A primitive type with attributes, or a nested definition such
+			// as {"type": {"type": "record", ...}}.
+			if typ == "" {
+				return parseAvroType(v["type"], named)
+			}
+			pt, err := parseAvroType(typ, named)
+			if err != nil {
+				return nil, err
+			}
+			pt.logical, pt.sqlType = t.logical, t.sqlType
+			return pt, nil
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("bigquery: invalid Avro schema node %v", v)
+}
+
+// avroToSchema returns the BigQuery schema of rows with the Avro record type t.
+func avroToSchema(t *avroType) (Schema, error) {
+	if t.typ != "record" {
+		return nil, fmt.Errorf("bigquery: Avro rows have type %q, want a record", t.typ)
+	}
+	var s Schema
+	for _, f := range t.fields {
+		fs := &FieldSchema{Name: f.name, Required: true}
+		ft := f.typ
+		if ft.typ == "union" {
+			nt, err := nonNullBranch(ft)
+			if err != nil {
+				return nil, fmt.Errorf("bigquery: field %s: %v", f.name, err)
+			}
+			ft = nt
+			fs.Required = false
+		}
+		if ft.typ == "array" {
+			ft = ft.items
+			fs.Repeated = true
+			fs.Required = false
+		}
+		switch ft.typ {
+		case "string":
+			switch {
+			case strings.EqualFold(ft.sqlType, "GEOGRAPHY"):
+				fs.Type = GeographyFieldType
+			case ft.logical == "datetime" || strings.EqualFold(ft.sqlType, "DATETIME"):
+				fs.Type = DateTimeFieldType
+			default:
+				fs.Type = StringFieldType
+			}
+		case "bytes":
+			if ft.logical == "decimal" {
+				fs.Type = NumericFieldType
+			} else {
+				fs.Type = BytesFieldType
+			}
+		case "long", "int":
+			switch ft.logical {
+			case "timestamp-micros", "timestamp-millis":
+				fs.Type = TimestampFieldType
+			case "time-micros", "time-millis":
+				fs.Type = TimeFieldType
+			case "date":
+				fs.Type = DateFieldType
+			default:
+				fs.Type = IntegerFieldType
+			}
+		case "double", "float":
+			fs.Type = FloatFieldType
+		case "boolean":
+			fs.Type = BooleanFieldType
+		case "record":
+			nested, err := avroToSchema(ft)
+			if err != nil {
+				return nil, err
+			}
+			fs.Type = RecordFieldType
+			fs.Schema = nested
+		default:
+			return nil, fmt.Errorf("bigquery: field %s has unsupported Avro type %q", f.name, ft.typ)
+		}
+		s = append(s, fs)
+	}
+	return s, nil
+}
+
+// nonNullBranch returns the branch of a nullable union that is not null.
+func nonNullBranch(t *avroType) (*avroType, error) {
+	if len(t.branches) == 2 {
+		if t.branches[0].typ == "null" {
+			return t.branches[1], nil
+		}
+		if t.branches[1].typ == "null" {
+			return t.branches[0], nil
+		}
+	}
+	return nil, errors.New("unsupported Avro union")
+}
+
+// decodeAvroRows decodes the concatenated binary Avro records in data, which
+// have the record type t and the schema returned by avroToSchema(t).
+func decodeAvroRows(t *avroType, schema Schema, data []byte) ([][]Value, error) {
+	d := &avroDecoder{buf: data}
+	var rows [][]Value
+	for len(d.buf) > 0 {
+		row, err := d.record(t, schema)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+var errAvroTruncated = errors.New("bigquery: truncated Avro data")
+
+// avroDecoder decodes Avro binary data.
+type avroDecoder struct {
+	buf []byte
+}
+
+func (d *avroDecoder) record(t *avroType, schema Schema) ([]Value, error) {
+	row := make([]Value, len(t.fields))
+	for i, f := range t.fields {
+		v, err := d.value(f.typ, schema[i])
+		if err != nil {
+			return nil, err
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// value decodes a value of type t, which is the Avro type of the field fs.
+func (d *avroDecoder) value(t *avroType, fs *FieldSchema) (Value, error) {
+	switch t.typ {
+	case "null":
+		return nil, nil
+	case "union":
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(t.branches) {
+			return nil, fmt.Errorf("bigquery: invalid Avro union branch %d", i)
+		}
+		return d.value(t.branches[i], fs)
+	case "array":
+		var vals []Value
+		for {
+			n, err := d.long()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return vals, nil
+			}
+			if n < 0 {
+				// A negative count is followed by the size of the block in bytes.
+				n = -n
+				if _, err := d.long(); err != nil {
+					return nil, err
+				}
+			}
+			for ; n > 0; n-- {
+				v, err := d.value(t.items, fs)
+				if err != nil {
+					return nil, err
+				}
+				vals = append(vals, v)
+			}
+		}
+	case "record":
+		return d.record(t, fs.Schema)
+	case "boolean":
+		if len(d.buf) < 1 {
+			return nil, errAvroTruncated
+		}
+		b := d.buf[0] != 0
+		d.buf = d.buf[1:]
+		return b, nil
+	case "int", "long":
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		switch t.logical {
+		// Split the seconds from the fraction, since the nanoseconds since
+		// the epoch overflow an int64 after the year 2262.
+		case "timestamp-micros":
+			return time.Unix(n/1e6, (n%1e6)*1e3).UTC(), nil
+		case "timestamp-millis":
+			return time.Unix(n/1e3, (n%1e3)*1e6).UTC(), nil
+		case "time-micros":
+			return civil.TimeOf(time.Unix(n/1e6, (n%1e6)*1e3).UTC()), nil
+		case "time-millis":
+			return civil.TimeOf(time.Unix(n/1e3, (n%1e3)*1e6).UTC()), nil
+		case "date":
+			return civil.DateOf(time.Unix(n*24*60*60, 0).UTC()), nil
+		}
+		return n, nil
+	case "float":
+		if len(d.buf) < 4 {
+			return nil, errAvroTruncated
+		}
+		f := math.Float32frombits(binary.LittleEndian.Uint32(d.buf))
+		d.buf = d.buf[4:]
+		return float64(f), nil
+	case "double":
+		if len(d.buf) < 8 {
+			return nil, errAvroTruncated
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+		return f, nil
+	case "bytes":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if t.logical == "decimal" {
+			return decimalToRat(b, t.scale), nil
+		}
+		return append([]byte(nil), b...), nil
+	case "string":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		s := string(b)
+		if fs.Type == DateTimeFieldType {
+			return civil.ParseDateTime(strings.Replace(s, " ", "T", 1))
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("bigquery: unsupported Avro type %q", t.typ)
+}
+
+// long decodes a zig-zag encoded variable-length integer.
+func (d *avroDecoder) long() (int64, error) {
+	n, size := binary.Varint(d.buf)
+	if size <= 0 {
+		return 0, errAvroTruncated
+	}
+	d.buf = d.buf[size:]
+	return n, nil
+}
+
+func (d *avroDecoder) bytes() ([]byte, error) {
+	n, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || int64(len(d.buf)) < n {
+		return nil, errAvroTruncated
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+// decimalToRat converts the big-endian two's-complement unscaled value b of
+// an Avro decimal with the given scale to a big.Rat.
+func decimalToRat(b []byte, scale int) *big.Rat {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	den := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	return new(big.Rat).SetFrac(n, den)
+}
@@ -0,0 +1,398 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+// This file encodes rows as an Avro object container file, for loading them
+// with a ValueSource. The Avro types are those that BigQuery reads with
+// logical types enabled.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// avroBlockSize is the number of encoded bytes after which a block of rows
+// is written.
+const avroBlockSize = 64 << 10
+
+// avroWriter writes rows as an Avro object container file.
+type avroWriter struct {
+	w      *bytes.Buffer
+	schema Schema
+	sync   [16]byte
+	block  bytes.Buffer // the encoded rows of the current block
+	count  int64        // the number of rows in block
+}
+
+// newAvroWriter writes the header of an Avro file of rows with the schema to
+// w, and returns a writer for the rows.
+func newAvroWriter(w *bytes.Buffer, schema Schema) (*avroWriter, error) {
+	as, err := json.Marshal(avroRecordSchema("Root", schema))
+	if err != nil {
+		return nil, err
+	}
+	aw := &avroWriter{w: w, schema: schema}
+	rngMu.Lock()
+	rng.Read(aw.sync[:])
+	rngMu.Unlock()
+
+	w.WriteString("Obj\x01")
+	// The file metadata is a map with one block.
+	avroLong(w, 2)
+	avroString(w, "avro.schema")
+	avroBytes(w, as)
+	avroString(w, "avro.codec")
+	avroString(w, "null")
+	avroLong(w, 0)
+	w.Write(aw.sync[:])
+	return aw, nil
+}
+
+// write encodes a row, which maps the names of the schema's fields to their
+// values, as returned by ValueSaver.Save.
+func (aw *avroWriter) write(row map[string]Value) error {
+	if err := avroRecord(&aw.block, aw.schema, row); err != nil {
+		return err
+	}
+	aw.count++
+	if aw.block.Len() >= avroBlockSize {
+		return aw.flush()
+	}
+	return nil
+}
+
+// flush writes the current block, if it has any rows.
+func (aw *avroWriter) flush() error {
+	if aw.count == 0 {
+		return nil
+	}
+	avroLong(aw.w, aw.count)
+	avroLong(aw.w, int64(aw.block.Len()))
+	aw.block.WriteTo(aw.w)
+	aw.w.Write(aw.sync[:])
+	aw.count = 0
+	return nil
+}
+
+// avroRecordSchema returns the Avro schema of a record with the fields of s,
+// in its JSON form. Nested records are named after their path, since Avro
+// names must be unique.
+func avroRecordSchema(name string, s Schema) map[string]interface{} {
+	var fields []interface{}
+	for _, fs := range s {
+		var t interface{}
+		switch fs.Type {
+		case StringFieldType:
+			t = "string"
+		case GeographyFieldType:
+			t = map[string]interface{}{"type": "string", "sqlType": "GEOGRAPHY"}
+		case BytesFieldType:
+			t = "bytes"
+		case IntegerFieldType:
+			t = "long"
+		case FloatFieldType:
+			t = "double"
+		case BooleanFieldType:
+			t = "boolean"
+		case TimestampFieldType:
+			t = map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"}
+		case DateFieldType:
+			t = map[string]interface{}{"type": "int", "logicalType": "date"}
+		case TimeFieldType:
+			t = map[string]interface{}{"type": "long", "logicalType": "time-micros"}
+		case DateTimeFieldType:
+			t = map[string]interface{}{"type": "string", "logicalType": "datetime"}
+		case NumericFieldType:
+			t = map[string]interface{}{
+				"type":        "bytes",
+				"logicalType": "decimal",
+				"precision":   NumericPrecisionDigits,
+				"scale":       NumericScaleDigits,
+			}
+		case RecordFieldType:
+			t = avroRecordSchema(name+"__"+fs.Name, fs.Schema)
+		default:
+			t = "string"
+		}
+		switch {
+		case fs.Repeated:
+			t = map[string]interface{}{"type": "array", "items": t}
+		case !fs.Required:
+			t = []interface{}{"null", t}
+		}
+		fields = append(fields, map[string]interface{}{"name": fs.Name, "type": t})
+	}
+	return map[string]interface{}{"type": "record", "name": name, "fields": fields}
+}
+
+func avroRecord(b *bytes.Buffer, s Schema, row map[string]Value) error {
+	for _, fs := range s {
+		if err := avroFieldValue(b, fs, row[fs.Name]); err != nil {
+			return fmt.Errorf("bigquery: field %s: %v", fs.Name, err)
+		}
+	}
+	return nil
+}
+
+func avroFieldValue(b *bytes.Buffer, fs *FieldSchema, v Value) error {
+	v = avroUnwrapNull(v)
+	if fs.Repeated {
+		if v == nil {
+			avroLong(b, 0)
+			return nil
+		}
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array || rv.Type() == typeOfByteSlice {
+			return fmt.Errorf("repeated field has a value of type %T", v)
+		}
+		if n := rv.Len(); n > 0 {
+			avroLong(b, int64(n))
+			for i := 0; i < n; i++ {
+				e := avroUnwrapNull(rv.Index(i).Interface())
+				if e == nil {
+					return fmt.Errorf("repeated field has a NULL element")
+				}
+				if err := avroValue(b, fs, e); err != nil {
+					return err
+				}
+			}
+		}
+		avroLong(b, 0)
+		return nil
+	}
+	if fs.Required {
+		if v == nil {
+			return fmt.Errorf("required field is NULL")
+		}
+	} else {
+		// The union of null and the field's type.
+		if v == nil {
+			avroLong(b, 0)
+			return nil
+		}
+		avroLong(b, 1)
+	}
+	return avroValue(b, fs, v)
+}
+
+// avroUnwrapNull returns the value of a Null type, such as NullInt64, or nil
+// if it is not valid. Other values are returned unchanged, except nil
+// pointers, which are returned as nil.
+func avroUnwrapNull(v Value) Value {
+	switch n := v.(type) {
+	case NullInt64:
+		if n.Valid {
+			return n.Int64
+		}
+	case NullString:
+		if n.Valid {
+			return n.StringVal
+		}
+	case NullGeography:
+		if n.Valid {
+			return n.GeographyVal
+		}
+	case NullFloat64:
+		if n.Valid {
+			return n.Float64
+		}
+	case NullBool:
+		if n.Valid {
+			return n.Bool
+		}
+	case NullTimestamp:
+		if n.Valid {
+			return n.Timestamp
+		}
+	case NullDate:
+		if n.Valid {
+			return n.Date
+		}
+	case NullTime:
+		if n.Valid {
+			return n.Time
+		}
+	case NullDateTime:
+		if n.Valid {
+			return n.DateTime
+		}
+	default:
+		if rv := reflect.ValueOf(v); v != nil && rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		return v
+	}
+	return nil
+}
+
+// avroValue encodes a non-NULL value of the type of fs.
+func avroValue(b *bytes.Buffer, fs *FieldSchema, v Value) error {
+	bad := func() error {
+		return fmt.Errorf("cannot encode a value of type %T as %s", v, fs.Type)
+	}
+	switch fs.Type {
+	case StringFieldType, GeographyFieldType:
+		s, ok := v.(string)
+		if !ok {
+			return bad()
+		}
+		avroString(b, s)
+	case BytesFieldType:
+		bs, ok := v.([]byte)
+		if !ok {
+			return bad()
+		}
+		avroBytes(b, bs)
+	case IntegerFieldType:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			avroLong(b, rv.Int())
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			avroLong(b, int64(rv.Uint()))
+		default:
+			return bad()
+		}
+	case FloatFieldType:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Float32 && rv.Kind() != reflect.Float64 {
+			return bad()
+		}
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(rv.Float()))
+		b.Write(buf[:])
+	case BooleanFieldType:
+		x, ok := v.(bool)
+		if !ok {
+			return bad()
+		}
+		if x {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case TimestampFieldType:
+		t, ok := v.(time.Time)
+		if !ok {
+			return bad()
+		}
+		avroLong(b, timestampMicros(t))
+	case DateFieldType:
+		d, ok := v.(civil.Date)
+		if !ok {
+			return bad()
+		}
+		avroLong(b, dateDays(d))
+	case TimeFieldType:
+		var t civil.Time
+		switch x := v.(type) {
+		case civil.Time:
+			t = x
+		case string:
+			// Inserter's format; see CivilTimeString.
+			var err error
+			if t, err = civil.ParseTime(x); err != nil {
+				return err
+			}
+		default:
+			return bad()
+		}
+		avroLong(b, timeMicros(t))
+	case DateTimeFieldType:
+		switch x := v.(type) {
+		case civil.DateTime:
+			avroString(b, CivilDateTimeString(x))
+		case string:
+			avroString(b, x)
+		default:
+			return bad()
+		}
+	case NumericFieldType:
+		var r *big.Rat
+		switch x := v.(type) {
+		case *big.Rat:
+			r = x
+		case string:
+			// Inserter's format; see NumericString.
+			var ok bool
+			if r, ok = new(big.Rat).SetString(x); !ok {
+				return fmt.Errorf("invalid NUMERIC value %q", x)
+			}
+		default:
+			return bad()
+		}
+		avroBytes(b, ratToDecimal(r, NumericScaleDigits))
+	case RecordFieldType:
+		m, ok := v.(map[string]Value)
+		if !ok {
+			return bad()
+		}
+		return avroRecord(b, fs.Schema, m)
+	default:
+		return fmt.Errorf("unsupported type %s", fs.Type)
+	}
+	return nil
+}
+
+// ratToDecimal returns the big-endian two's-complement unscaled value of r
+// as an Avro decimal with the given scale, rounded like NumericString. It is
+// the inverse of decimalToRat.
+func ratToDecimal(r *big.Rat, scale int) []byte {
+	s := strings.Replace(r.FloatString(scale), ".", "", 1)
+	n, _ := new(big.Int).SetString(s, 10)
+	if n.Sign() >= 0 {
+		b := n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+	// The two's complement of n is the complement of -n-1.
+	m := new(big.Int).Neg(n)
+	m.Sub(m, big.NewInt(1))
+	b := m.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	for i := range b {
+		b[i] = ^b[i]
+	}
+	return b
+}
+
+// avroLong writes a zig-zag encoded variable-length integer.
+func avroLong(b *bytes.Buffer, n int64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutVarint(buf[:], n)])
+}
+
+func avroBytes(b *bytes.Buffer, bs []byte) {
+	avroLong(b, int64(len(bs)))
+	b.Write(bs)
+}
+
+func avroString(b *bytes.Buffer, s string) {
+	avroLong(b, int64(len(s)))
+	b.WriteString(s)
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+)
+
+// avroEncoder writes Avro binary data, for tests.
+type avroEncoder struct {
+	buf []byte
+}
+
+func (e *avroEncoder) long(n int64) *avroEncoder {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutVarint(b[:], n)]...)
+	return e
+}
+
+func (e *avroEncoder) bytes(b []byte) *avroEncoder {
+	e.long(int64(len(b)))
+	e.buf = append(e.buf, b...)
+	return e
+}
+
+func (e *avroEncoder) str(s string) *avroEncoder { return e.bytes([]byte(s)) }
+
+func (e *avroEncoder) double(f float64) *avroEncoder {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	e.buf = append(e.buf, b[:]...)
+	return e
+}
+
+func (e *avroEncoder) boolean(b bool) *avroEncoder {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+	return e
+}
+
+const testAvroSchema = `{
+  "type": "record",
+  "name": "__root__",
+  "fields": [
+    {"name": "name", "type": "string"},
+    {"name": "num", "type": ["null", "long"]},
+    {"name": "score", "type": ["null", "double"]},
+    {"name": "ok", "type": ["null", "boolean"]},
+    {"name": "b", "type": ["null", "bytes"]},
+    {"name": "ts", "type": ["null", {"type": "long", "logicalType": "timestamp-micros"}]},
+    {"name": "d", "type": ["null", {"type": "int", "logicalType": "date"}]},
+    {"name": "t", "type": ["null", {"type": "long", "logicalType": "time-micros"}]},
+    {"name": "dt", "type": ["null", {"type": "string", "logicalType": "datetime"}]},
+    {"name": "n", "type": ["null", {"type": "bytes", "logicalType": "decimal", "precision": 38, "scale": 9}]},
+    {"name": "g", "type": ["null", {"type": "string", "sqlType": "GEOGRAPHY"}]},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "rec", "type": ["null", {"type": "record", "name": "__rec", "fields": [{"name": "x", "type": ["null", "long"]}]}]}
+  ]
+}`
+
+func TestAvroToSchema(t *testing.T) {
+	at, err := parseAvroSchema(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := avroToSchema(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Schema{
+		{Name: "name", Type: StringFieldType, Required: true},
+		{Name: "num", Type: IntegerFieldType},
+		{Name: "score", Type: FloatFieldType},
+		{Name: "ok", Type: BooleanFieldType},
+		{Name: "b", Type: BytesFieldType},
+		{Name: "ts", Type: TimestampFieldType},
+		{Name: "d", Type: DateFieldType},
+		{Name: "t", Type: TimeFieldType},
+		{Name: "dt", Type: DateTimeFieldType},
+		{Name: "n", Type: NumericFieldType},
+		{Name: "g", Type: GeographyFieldType},
+		{Name: "tags", Type: StringFieldType, Repeated: true},
+		{Name: "rec", Type: RecordFieldType, Schema: Schema{{Name: "x", Type: IntegerFieldType}}},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestDecodeAvroRows(t *testing.T) {
+	at, err := parseAvroSchema(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := avroToSchema(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2020, 3, 4, 5, 6, 7, 8000, time.UTC)
+	e := &avroEncoder{}
+	// A row with all the values set.
+	e.str("a")
+	e.long(1).long(-42)
+	e.long(1).double(1.5)
+	e.long(1).boolean(true)
+	e.long(1).bytes([]byte{1, 2})
+	e.long(1).long(ts.UnixNano() / 1000)
+	e.long(1).long(18325) // 2020-03-04
+	e.long(1).long(((5*60+6)*60+7)*1e6 + 8)
+	e.long(1).str("2020-03-04T05:06:07.000008")
+	// -1.5 is -1500000000 unscaled.
+	e.long(1).bytes(big.NewInt(-1500000000 + 1<<32).Bytes())
+	e.long(1).str("POINT(1 2)")
+	e.long(-2).long(4).str("x").str("y").long(0) // a block with its size
+	e.long(1).long(1).long(7)
+	// A row with all the nullable values NULL.
+	e.str("b")
+	for i := 0; i < 10; i++ {
+		e.long(0)
+	}
+	e.long(0)
+	e.long(0)
+
+	got, err := decodeAvroRows(at, schema, e.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]Value{
+		{
+			"a", int64(-42), 1.5, true, []byte{1, 2}, ts,
+			civil.Date{Year: 2020, Month: 3, Day: 4},
+			civil.Time{Hour: 5, Minute: 6, Second: 7, Nanosecond: 8000},
+			civil.DateTime{Date: civil.Date{Year: 2020, Month: 3, Day: 4}, Time: civil.Time{Hour: 5, Minute: 6, Second: 7, Nanosecond: 8000}},
+			big.NewRat(-3, 2),
+			"POINT(1 2)",
+			[]Value{"x", "y"},
+			[]Value{int64(7)},
+		},
+		{"b", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, []Value(nil), nil},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+
+	// Truncated data is an error.
+	if _, err := decodeAvroRows(at, schema, e.buf[:len(e.buf)-1]); err == nil {
+		t.Error("decoding truncated rows succeeded, want error")
+	}
+}
+
+func TestDecodeAvroTimesFarFromEpoch(t *testing.T) {
+	const schema = `{
+  "type": "record",
+  "name": "__root__",
+  "fields": [
+    {"name": "us", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "ms", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "t", "type": {"type": "long", "logicalType": "time-micros"}},
+    {"name": "tm", "type": {"type": "int", "logicalType": "time-millis"}},
+    {"name": "d", "type": {"type": "int", "logicalType": "date"}}
+  ]
+}`
+	at, err := parseAvroSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := avroToSchema(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The nanoseconds since the epoch of these times overflow an int64.
+	max := time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.UTC)
+	min := time.Date(1, 1, 1, 0, 0, 0, 1000, time.UTC)
+	e := &avroEncoder{}
+	e.long(timestampMicros(max))
+	e.long(max.Unix()*1e3 + 999)
+	e.long(timeMicros(civil.TimeOf(max)))
+	e.long((23*60*60+59*60+59)*1e3 + 999)
+	e.long(dateDays(civil.DateOf(max)))
+	e.long(timestampMicros(min))
+	e.long(min.Unix() * 1e3)
+	e.long(0)
+	e.long(0)
+	e.long(dateDays(civil.DateOf(min)))
+	got, err := decodeAvroRows(at, s, e.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]Value{
+		{
+			max,
+			time.Date(9999, 12, 31, 23, 59, 59, 999000000, time.UTC),
+			civil.Time{Hour: 23, Minute: 59, Second: 59, Nanosecond: 999999000},
+			civil.Time{Hour: 23, Minute: 59, Second: 59, Nanosecond: 999000000},
+			civil.Date{Year: 9999, Month: 12, Day: 31},
+		},
+		{
+			min,
+			time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC),
+			civil.Time{},
+			civil.Time{},
+			civil.Date{Year: 1, Month: 1, Day: 1},
+		},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"time"
 
+	bqstorage "cloud.google.com/go/bigquery/storage/apiv1beta1"
 	"cloud.google.com/go/internal"
 	"cloud.google.com/go/internal/version"
 	gax "github.com/googleapis/gax-go/v2"
@@ -52,6 +53,8 @@ type Client struct {
 
 	projectID string
 	bqs       *bq.Service
+	rc        *bqstorage.BigQueryStorageClient // set by EnableStorageReadClient
+	cg        *queryCostGuard                  // set by SetQueryCostPolicy
 }
 
 // NewClient constructs a new Client which can perform BigQuery operations.
@@ -77,6 +80,9 @@ func NewClient(ctx context.Context, projectID string, opts ...option.ClientOptio
 // Close should be called when the client is no longer needed.
 // It need not be called at program exit.
 func (c *Client) Close() error {
+	if c.rc != nil {
+		return c.rc.Close()
+	}
 	return nil
 }
 
@@ -0,0 +1,798 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+// This file holds the table data: its representation, and the tabledata
+// methods and load and copy jobs that read and write it.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// Rows are stored as slices of values, one for each field of the schema, in
+// order. A row may be shorter than the schema if fields were added to the
+// schema after it was written; the missing values are NULL. The values of
+// each field type are:
+//
+//	STRING, GEOGRAPHY         string
+//	BYTES                     []byte
+//	INTEGER                   int64
+//	FLOAT                     float64
+//	BOOLEAN                   bool
+//	TIMESTAMP                 time.Time, in UTC
+//	DATE                      civil.Date
+//	TIME                      civil.Time
+//	DATETIME                  civil.DateTime
+//	NUMERIC                   *big.Rat
+//	RECORD                    []interface{}
+//
+// A repeated field holds an []interface{} of the values of its type. NULL is
+// nil.
+
+var fieldTypes = map[string]bool{
+	"STRING": true, "BYTES": true, "INTEGER": true, "INT64": true,
+	"FLOAT": true, "FLOAT64": true, "BOOLEAN": true, "BOOL": true,
+	"TIMESTAMP": true, "DATE": true, "TIME": true, "DATETIME": true,
+	"NUMERIC": true, "GEOGRAPHY": true, "RECORD": true, "STRUCT": true,
+}
+
+// fieldType returns the canonical name of the type of f.
+func fieldType(f *bq.TableFieldSchema) string {
+	switch f.Type {
+	case "INT64":
+		return "INTEGER"
+	case "FLOAT64":
+		return "FLOAT"
+	case "BOOL":
+		return "BOOLEAN"
+	case "STRUCT":
+		return "RECORD"
+	}
+	return f.Type
+}
+
+func validateSchema(fields []*bq.TableFieldSchema) error {
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if f.Name == "" {
+			return invalid("Empty field name")
+		}
+		name := strings.ToLower(f.Name)
+		if seen[name] {
+			return invalid("Duplicate field name %s", f.Name)
+		}
+		seen[name] = true
+		if !fieldTypes[f.Type] {
+			return invalid("Invalid field type %q for field %s", f.Type, f.Name)
+		}
+		switch f.Mode {
+		case "", "NULLABLE", "REQUIRED", "REPEATED":
+		default:
+			return invalid("Invalid field mode %q for field %s", f.Mode, f.Name)
+		}
+		if fieldType(f) == "RECORD" {
+			if len(f.Fields) == 0 {
+				return invalid("Field %s is type RECORD but has no schema", f.Name)
+			}
+			if err := validateSchema(f.Fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkSchemaUpdate checks that the schema update from old to new is allowed:
+// it may only add fields that are not required, and relax required fields.
+func checkSchemaUpdate(old, new []*bq.TableFieldSchema) error {
+	if err := validateSchema(new); err != nil {
+		return err
+	}
+	for _, of := range old {
+		nf := lookupField(new, of.Name)
+		if nf == nil {
+			return invalid("Provided Schema does not match Table. Field %s is missing in new schema", of.Name)
+		}
+		if fieldType(nf) != fieldType(of) {
+			return invalid("Provided Schema does not match Table. Field %s has changed type from %s to %s", of.Name, of.Type, nf.Type)
+		}
+		if (of.Mode == "REPEATED") != (nf.Mode == "REPEATED") || (of.Mode != "REQUIRED" && nf.Mode == "REQUIRED") {
+			return invalid("Provided Schema does not match Table. Field %s has changed mode from %s to %s", of.Name, mode(of), mode(nf))
+		}
+		if err := checkSchemaUpdate(of.Fields, nf.Fields); err != nil {
+			return err
+		}
+	}
+	for _, nf := range new {
+		if lookupField(old, nf.Name) == nil && nf.Mode == "REQUIRED" {
+			return invalid("Provided Schema does not match Table. Cannot add required field %s", nf.Name)
+		}
+	}
+	return nil
+}
+
+func mode(f *bq.TableFieldSchema) string {
+	if f.Mode == "" {
+		return "NULLABLE"
+	}
+	return f.Mode
+}
+
+// sameSchema reports whether the schemas have the same fields, so that rows
+// of one are valid rows of the other.
+func sameSchema(a, b []*bq.TableFieldSchema) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i].Name, b[i].Name) || fieldType(a[i]) != fieldType(b[i]) || mode(a[i]) != mode(b[i]) {
+			return false
+		}
+		if !sameSchema(a[i].Fields, b[i].Fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupField returns the field with the given name, which is not case
+// sensitive, or nil.
+func lookupField(fields []*bq.TableFieldSchema, name string) *bq.TableFieldSchema {
+	if i := fieldIndex(fields, name); i >= 0 {
+		return fields[i]
+	}
+	return nil
+}
+
+func fieldIndex(fields []*bq.TableFieldSchema, name string) int {
+	for i, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func schemaFields(md *bq.Table) []*bq.TableFieldSchema {
+	if md.Schema == nil {
+		return nil
+	}
+	return md.Schema.Fields
+}
+
+// convertRecord converts a JSON object, as sent to insertAll or in a
+// newline-delimited JSON file, to a row of the schema.
+func convertRecord(fields []*bq.TableFieldSchema, m map[string]interface{}, ignoreUnknown bool) ([]interface{}, error) {
+	row := make([]interface{}, len(fields))
+	for name, v := range m {
+		i := fieldIndex(fields, name)
+		if i < 0 {
+			if ignoreUnknown {
+				continue
+			}
+			return nil, errorf(http.StatusBadRequest, "invalid", "no such field: %s.", name)
+		}
+		cv, err := convertValue(fields[i], v, ignoreUnknown)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = cv
+	}
+	for i, f := range fields {
+		if row[i] == nil && f.Mode == "REQUIRED" {
+			return nil, invalid("Missing required field: %s.", f.Name)
+		}
+	}
+	return row, nil
+}
+
+// convertValue converts a JSON value to a value of the field.
+func convertValue(f *bq.TableFieldSchema, v interface{}, ignoreUnknown bool) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if f.Mode == "REPEATED" {
+		vs, ok := v.([]interface{})
+		if !ok {
+			return nil, invalid("Array specified for non-repeated field: %s.", f.Name)
+		}
+		elem := *f
+		elem.Mode = "REQUIRED"
+		var res []interface{}
+		for _, e := range vs {
+			if e == nil {
+				return nil, invalid("Field %s: NULL in array", f.Name)
+			}
+			ce, err := convertValue(&elem, e, ignoreUnknown)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, ce)
+		}
+		return res, nil
+	}
+	if fieldType(f) == "RECORD" {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, invalid("This field: %s is not a record.", f.Name)
+		}
+		return convertRecord(f.Fields, m, ignoreUnknown)
+	}
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return nil, invalid("Cannot convert value to %s for field %s", f.Type, f.Name)
+	}
+	cv, err := parseValue(fieldType(f), s)
+	if err != nil {
+		return nil, invalid("Cannot convert value %q to %s for field %s", s, f.Type, f.Name)
+	}
+	return cv, nil
+}
+
+// parseValue parses the string form of a value of a non-record type.
+func parseValue(typ, s string) (interface{}, error) {
+	switch typ {
+	case "STRING", "GEOGRAPHY":
+		return s, nil
+	case "BYTES":
+		return base64.StdEncoding.DecodeString(s)
+	case "INTEGER":
+		return strconv.ParseInt(s, 10, 64)
+	case "FLOAT":
+		return strconv.ParseFloat(s, 64)
+	case "BOOLEAN":
+		return strconv.ParseBool(s)
+	case "TIMESTAMP":
+		return parseTimestamp(s)
+	case "DATE":
+		return civil.ParseDate(s)
+	case "TIME":
+		return civil.ParseTime(s)
+	case "DATETIME":
+		return civil.ParseDateTime(strings.Replace(s, " ", "T", 1))
+	case "NUMERIC":
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid NUMERIC %q", s)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown type %s", typ)
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 Z07:00",
+	"2006-01-02 15:04:05.999999999 MST",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseTimestamp parses a timestamp in one of the forms that BigQuery
+// accepts, or as a number of seconds since the epoch. Timestamps have
+// microsecond precision.
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Truncate(time.Microsecond), nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	secs := math.Floor(f)
+	micros := math.Floor((f-secs)*1e6 + 0.5)
+	return time.Unix(int64(secs), int64(micros)*1000).UTC(), nil
+}
+
+// cell returns the value of a field in the form of the tabledata.list and
+// jobs.getQueryResults responses.
+func cell(f *bq.TableFieldSchema, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if f.Mode == "REPEATED" {
+		elem := *f
+		elem.Mode = "REQUIRED"
+		cells := []interface{}{}
+		for _, e := range v.([]interface{}) {
+			cells = append(cells, map[string]interface{}{"v": cell(&elem, e)})
+		}
+		return cells
+	}
+	if fieldType(f) == "RECORD" {
+		return map[string]interface{}{"f": cells(f.Fields, v.([]interface{}))}
+	}
+	return formatValue(v)
+}
+
+func cells(fields []*bq.TableFieldSchema, row []interface{}) []*bq.TableCell {
+	var cs []*bq.TableCell
+	for i, f := range fields {
+		cs = append(cs, &bq.TableCell{V: cell(f, get(row, i))})
+	}
+	return cs
+}
+
+// get returns the value of the ith field of the row, which is NULL if the
+// field was added after the row was written.
+func get(row []interface{}, i int) interface{} {
+	if i < len(row) {
+		return row[i]
+	}
+	return nil
+}
+
+// formatValue returns the string form of a value of a non-record type, as
+// the service returns it.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return strconv.FormatFloat(float64(v.UnixNano()/1e3)/1e6, 'E', -1, 64)
+	case civil.Date:
+		return v.String()
+	case civil.Time:
+		return v.String()
+	case civil.DateTime:
+		return v.String()
+	case *big.Rat:
+		s := v.FloatString(9)
+		s = strings.TrimRight(s, "0")
+		return strings.TrimSuffix(s, ".")
+	}
+	return fmt.Sprint(v)
+}
+
+// valueSize returns the number of bytes of a value, as BigQuery counts them
+// for the bytes processed by queries.
+func valueSize(f *bq.TableFieldSchema, v interface{}) int64 {
+	if v == nil {
+		return 0
+	}
+	if f.Mode == "REPEATED" {
+		elem := *f
+		elem.Mode = "REQUIRED"
+		var n int64
+		for _, e := range v.([]interface{}) {
+			n += valueSize(&elem, e)
+		}
+		return n
+	}
+	switch fieldType(f) {
+	case "RECORD":
+		var n int64
+		for i, sf := range f.Fields {
+			n += valueSize(sf, get(v.([]interface{}), i))
+		}
+		return n
+	case "STRING", "GEOGRAPHY":
+		return 2 + int64(len(v.(string)))
+	case "BYTES":
+		return 2 + int64(len(v.([]byte)))
+	case "BOOLEAN":
+		return 1
+	case "NUMERIC":
+		return 16
+	}
+	return 8
+}
+
+// insertAll implements tabledata.insertAll.
+func (s *Server) insertAll(project, datasetID, tableID string, req *bq.TableDataInsertAllRequest) (*bq.TableDataInsertAllResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.table(project, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	if req.TemplateSuffix != "" {
+		// Insert into the table named by the suffix, creating it with the
+		// schema of the template table if necessary.
+		ref := &bq.TableReference{ProjectId: project, DatasetId: datasetID, TableId: tableID + req.TemplateSuffix}
+		if st, err := s.table(project, datasetID, ref.TableId); err == nil {
+			t = st
+		} else if t, err = s.createTable(ref, &bq.Table{Schema: t.md.Schema}); err != nil {
+			return nil, err
+		}
+	}
+	fields := schemaFields(t.md)
+	if len(fields) == 0 {
+		return nil, invalid("Table %s has no schema", t.md.Id)
+	}
+	res := &bq.TableDataInsertAllResponse{Kind: "bigquery#tableDataInsertAllResponse"}
+	var rows [][]interface{}
+	var ids []string
+	failed := map[int]bool{}
+	for i, r := range req.Rows {
+		m := map[string]interface{}{}
+		for k, v := range r.Json {
+			m[k] = v
+		}
+		row, err := convertRecord(fields, m, req.IgnoreUnknownValues)
+		if err != nil {
+			failed[i] = true
+			res.InsertErrors = append(res.InsertErrors, &bq.TableDataInsertAllResponseInsertErrors{
+				Index:  int64(i),
+				Errors: []*bq.ErrorProto{errorProto(err)},
+			})
+			continue
+		}
+		rows = append(rows, row)
+		ids = append(ids, r.InsertId)
+	}
+	if len(res.InsertErrors) > 0 && !req.SkipInvalidRows {
+		// No rows are inserted if any is invalid.
+		for i := range req.Rows {
+			if !failed[i] {
+				res.InsertErrors = append(res.InsertErrors, &bq.TableDataInsertAllResponseInsertErrors{
+					Index:  int64(i),
+					Errors: []*bq.ErrorProto{{Reason: "stopped"}},
+				})
+			}
+		}
+		return res, nil
+	}
+	for i, row := range rows {
+		if id := ids[i]; id != "" {
+			if t.insertIDs[id] {
+				continue
+			}
+			t.insertIDs[id] = true
+		}
+		t.rows = append(t.rows, row)
+	}
+	return res, nil
+}
+
+// listRows implements tabledata.list.
+func (s *Server) listRows(project, datasetID, tableID string, q url.Values) (*bq.TableDataList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.table(project, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	rows, pageToken, err := page(t.rows, q)
+	if err != nil {
+		return nil, err
+	}
+	res := &bq.TableDataList{
+		Kind:      "bigquery#tableDataList",
+		Etag:      t.md.Etag,
+		TotalRows: int64(len(t.rows)),
+		PageToken: pageToken,
+	}
+	res.Rows = tableRows(schemaFields(t.md), rows)
+	return res, nil
+}
+
+// page returns the rows of the page requested by the startIndex, maxResults
+// and pageToken parameters, and the token of the next page.
+func page(rows [][]interface{}, q url.Values) ([][]interface{}, string, error) {
+	start := 0
+	if tok := q.Get("pageToken"); tok != "" {
+		n, err := strconv.Atoi(tok)
+		if err != nil || n < 0 {
+			return nil, "", invalid("Invalid page token %q", tok)
+		}
+		start = n
+	} else if si := q.Get("startIndex"); si != "" {
+		n, err := strconv.Atoi(si)
+		if err != nil || n < 0 {
+			return nil, "", invalid("Invalid startIndex %q", si)
+		}
+		start = n
+	}
+	if start > len(rows) {
+		start = len(rows)
+	}
+	end := len(rows)
+	if mr := q.Get("maxResults"); mr != "" {
+		n, err := strconv.Atoi(mr)
+		if err != nil || n < 0 {
+			return nil, "", invalid("Invalid maxResults %q", mr)
+		}
+		if start+n < end {
+			end = start + n
+		}
+	}
+	var tok string
+	if end < len(rows) {
+		tok = strconv.Itoa(end)
+	}
+	return rows[start:end], tok, nil
+}
+
+func tableRows(fields []*bq.TableFieldSchema, rows [][]interface{}) []*bq.TableRow {
+	var res []*bq.TableRow
+	for _, row := range rows {
+		res = append(res, &bq.TableRow{F: cells(fields, row)})
+	}
+	return res
+}
+
+// writeTable writes rows to the table ref according to the dispositions,
+// creating it with the given schema if necessary. If schema is nil, the
+// table must exist. s.mu must be held.
+func (s *Server) writeTable(ref *bq.TableReference, schema *bq.TableSchema, rows [][]interface{}, createDisposition, writeDisposition string) error {
+	t, err := s.table(ref.ProjectId, ref.DatasetId, ref.TableId)
+	if err != nil {
+		if he, ok := err.(*httpError); !ok || he.code != http.StatusNotFound || createDisposition == "CREATE_NEVER" {
+			return err
+		}
+		if schema == nil {
+			return invalid("No schema specified on job or table.")
+		}
+		md := &bq.Table{Schema: schema}
+		if t, err = s.createTable(ref, md); err != nil {
+			return err
+		}
+	}
+	switch writeDisposition {
+	case "WRITE_TRUNCATE":
+		t.rows = nil
+		if schema != nil {
+			t.md.Schema = schema
+		}
+	case "WRITE_EMPTY":
+		if len(t.rows) > 0 {
+			return errorf(http.StatusConflict, "duplicate", "Already Exists: Table %s", t.md.Id)
+		}
+		if schema != nil {
+			t.md.Schema = schema
+		}
+	default:
+		if schema != nil && !sameSchema(schemaFields(t.md), schema.Fields) {
+			return invalid("Provided Schema does not match Table %s", t.md.Id)
+		}
+	}
+	t.rows = append(t.rows, rows...)
+	t.md.LastModifiedTime = uint64(millis(time.Now()))
+	return nil
+}
+
+// runLoad runs a load job of data. s.mu must be held.
+func (s *Server) runLoad(project string, conf *bq.JobConfigurationLoad, data []byte, stats *bq.JobStatistics) error {
+	ref := conf.DestinationTable
+	if ref == nil {
+		return invalid("Load configuration must specify destination table")
+	}
+	if ref.ProjectId == "" {
+		ref.ProjectId = project
+	}
+	schema := conf.Schema
+	fields := schemaFields(&bq.Table{Schema: schema})
+	if len(fields) == 0 {
+		if t, err := s.table(ref.ProjectId, ref.DatasetId, ref.TableId); err == nil {
+			fields = schemaFields(t.md)
+		}
+	}
+	if len(fields) == 0 {
+		if conf.Autodetect {
+			return invalid("Schema auto-detection is not supported by the fake")
+		}
+		return invalid("No schema specified on job or table.")
+	}
+	if err := validateSchema(fields); err != nil {
+		return err
+	}
+	var rows [][]interface{}
+	var err error
+	switch conf.SourceFormat {
+	case "", "CSV":
+		rows, err = readCSV(fields, conf, data)
+	case "NEWLINE_DELIMITED_JSON":
+		rows, err = readJSON(fields, conf, data)
+	default:
+		return invalid("Source format %s is not supported by the fake", conf.SourceFormat)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.writeTable(ref, schema, rows, conf.CreateDisposition, conf.WriteDisposition); err != nil {
+		return err
+	}
+	stats.Load = &bq.JobStatistics3{
+		InputFiles:     1,
+		InputFileBytes: int64(len(data)),
+		OutputRows:     int64(len(rows)),
+	}
+	return nil
+}
+
+// badRecords counts the bad records of a load, and fails it when there are
+// more than the maximum.
+type badRecords struct {
+	max, n int64
+}
+
+func (b *badRecords) add(line int, err error) error {
+	b.n++
+	if b.n > b.max {
+		return invalid("Error while reading data, error message: line %d: %v", line, err)
+	}
+	return nil
+}
+
+func readCSV(fields []*bq.TableFieldSchema, conf *bq.JobConfigurationLoad, data []byte) ([][]interface{}, error) {
+	for _, f := range fields {
+		if fieldType(f) == "RECORD" || f.Mode == "REPEATED" {
+			return nil, invalid("CSV files cannot hold field %s of type %s and mode %s", f.Name, f.Type, mode(f))
+		}
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	switch d := conf.FieldDelimiter; d {
+	case "", ",":
+	case "\\t", "tab":
+		r.Comma = '\t'
+	default:
+		r.Comma = []rune(d)[0]
+	}
+	bad := badRecords{max: conf.MaxBadRecords}
+	var rows [][]interface{}
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, invalid("Error while reading data, error message: %v", err)
+		}
+		if int64(line) <= conf.SkipLeadingRows {
+			continue
+		}
+		row, err := csvRow(fields, conf, rec)
+		if err != nil {
+			if err := bad.add(line, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func csvRow(fields []*bq.TableFieldSchema, conf *bq.JobConfigurationLoad, rec []string) ([]interface{}, error) {
+	if len(rec) > len(fields) || (len(rec) < len(fields) && !conf.AllowJaggedRows) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(fields), len(rec))
+	}
+	row := make([]interface{}, len(fields))
+	for i, f := range fields {
+		if i >= len(rec) {
+			continue
+		}
+		s := rec[i]
+		if s == conf.NullMarker && (s != "" || fieldType(f) != "STRING") {
+			if f.Mode == "REQUIRED" {
+				return nil, fmt.Errorf("missing required field %s", f.Name)
+			}
+			continue
+		}
+		v, err := parseValue(fieldType(f), s)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %q as %s for field %s", s, f.Type, f.Name)
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+func readJSON(fields []*bq.TableFieldSchema, conf *bq.JobConfigurationLoad, data []byte) ([][]interface{}, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 100<<20)
+	bad := badRecords{max: conf.MaxBadRecords}
+	var rows [][]interface{}
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		d := json.NewDecoder(bytes.NewReader(sc.Bytes()))
+		d.UseNumber()
+		var m map[string]interface{}
+		if err := d.Decode(&m); err != nil {
+			if err := bad.add(line, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		row, err := convertRecord(fields, m, conf.IgnoreUnknownValues)
+		if err != nil {
+			if err := bad.add(line, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, invalid("Error while reading data, error message: %v", err)
+	}
+	return rows, nil
+}
+
+// runCopy runs a copy job. s.mu must be held.
+func (s *Server) runCopy(project string, conf *bq.JobConfigurationTableCopy) error {
+	srcs := conf.SourceTables
+	if conf.SourceTable != nil {
+		srcs = append([]*bq.TableReference{conf.SourceTable}, srcs...)
+	}
+	if len(srcs) == 0 || conf.DestinationTable == nil {
+		return invalid("Copy configuration must specify source and destination tables")
+	}
+	var schema *bq.TableSchema
+	var rows [][]interface{}
+	for _, ref := range srcs {
+		if ref.ProjectId == "" {
+			ref.ProjectId = project
+		}
+		t, err := s.table(ref.ProjectId, ref.DatasetId, ref.TableId)
+		if err != nil {
+			return err
+		}
+		if schema == nil {
+			schema = t.md.Schema
+		} else if !sameSchema(schema.Fields, schemaFields(t.md)) {
+			return invalid("Source tables of a copy must have the same schema")
+		}
+		rows = append(rows, t.rows...)
+	}
+	dst := conf.DestinationTable
+	if dst.ProjectId == "" {
+		dst.ProjectId = project
+	}
+	wd := conf.WriteDisposition
+	if wd == "" {
+		wd = "WRITE_EMPTY"
+	}
+	return s.writeTable(dst, schema, rows, conf.CreateDisposition, wd)
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest_test
+
+import (
+	"context"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/bqtest"
+)
+
+func ExampleNewServer() {
+	ctx := context.Background()
+	// Start a fake server running locally.
+	srv := bqtest.NewServer()
+	defer srv.Close()
+	// Use the server's options when creating a bigquery client.
+	client, err := bigquery.NewClient(ctx, "project", srv.ClientOptions()...)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer client.Close()
+	_ = client // TODO: Use the client.
+}
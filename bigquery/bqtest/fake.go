@@ -0,0 +1,614 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bqtest provides a fake BigQuery service for testing. It implements
+// a simplified form of the BigQuery REST API in memory, suitable for unit
+// tests of code that uses the bigquery package.
+//
+// The fake supports:
+//   - datasets and tables: insert, get, list, patch and delete
+//   - tabledata: insertAll, with insert ID deduplication, and list
+//   - load jobs from a bigquery.ReaderSource, in CSV or newline-delimited JSON
+//   - copy jobs
+//   - query jobs and dry runs for a subset of standard SQL: see Server for
+//     details
+//
+// Jobs run synchronously when they are inserted. The fake may behave
+// differently from the actual service in ways in which the service is
+// non-deterministic or unspecified, and it does not enforce quotas, access
+// controls or most limits.
+//
+// This package is EXPERIMENTAL and is subject to change without notice.
+//
+// See the example for usage.
+package bqtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Server is a fake BigQuery server.
+//
+// Queries may have the form
+//
+//	SELECT select_list FROM table [WHERE condition] [ORDER BY column [ASC|DESC], ...] [LIMIT n]
+//
+// where select_list is * or a comma-separated list of column names, each
+// optionally followed by AS alias, or COUNT(*). The table is written as
+// `project.dataset.table`, dataset.table, or a table of the query's default
+// dataset. The condition is a conjunction (AND) of comparisons of a column with
+// a literal or a named query parameter, using =, !=, <>, <, <=, > or >=, and
+// of IS [NOT] NULL tests. Only top-level columns can be used.
+type Server struct {
+	// URL is the URL of the server.
+	URL string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	datasets map[string]*dataset // keyed by "project:dataset"
+	jobs     map[string]*job     // keyed by "project:job"
+	uploads  map[string]*upload  // keyed by upload ID
+	nextID   int
+}
+
+type dataset struct {
+	md     *bq.Dataset
+	tables map[string]*table
+	etag   int
+}
+
+type table struct {
+	md        *bq.Table
+	rows      [][]interface{} // see convertValue for the representation
+	insertIDs map[string]bool
+	etag      int
+}
+
+type job struct {
+	md *bq.Job
+}
+
+// upload is an unfinished resumable upload of the data of a load job.
+type upload struct {
+	project string
+	job     *bq.Job
+	data    []byte
+}
+
+// NewServer creates a new fake server running in the current process.
+func NewServer() *Server {
+	s := &Server{
+		datasets: map[string]*dataset{},
+		jobs:     map[string]*job{},
+		uploads:  map[string]*upload{},
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// ClientOptions returns the options that make a bigquery.Client use the
+// server, for use with bigquery.NewClient.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.URL + "/bigquery/v2/"),
+		option.WithHTTPClient(s.srv.Client()),
+	}
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// httpError is an error with an HTTP status and a BigQuery error reason.
+type httpError struct {
+	code    int
+	reason  string
+	message string
+}
+
+func (e *httpError) Error() string { return e.message }
+
+func errorf(code int, reason, format string, args ...interface{}) error {
+	return &httpError{code: code, reason: reason, message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return errorf(http.StatusNotFound, "notFound", "Not found: "+format, args...)
+}
+
+func invalid(format string, args ...interface{}) error {
+	return errorf(http.StatusBadRequest, "invalid", format, args...)
+}
+
+// errorProto returns the description of err in a job status or an insert
+// error.
+func errorProto(err error) *bq.ErrorProto {
+	if he, ok := err.(*httpError); ok {
+		return &bq.ErrorProto{Reason: he.reason, Message: he.message}
+	}
+	return &bq.ErrorProto{Reason: "invalid", Message: err.Error()}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	he, ok := err.(*httpError)
+	if !ok {
+		he = &httpError{code: http.StatusBadRequest, reason: "invalid", message: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(he.code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": googleapi.Error{
+			Code:    he.code,
+			Message: he.message,
+			Errors:  []googleapi.ErrorItem{{Reason: he.reason, Message: he.message}},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeBody decodes the JSON request body into v, keeping numbers as
+// json.Number so that integers are not rounded.
+func decodeBody(r *http.Request, v interface{}) error {
+	d := json.NewDecoder(r.Body)
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return invalid("bad request body: %v", err)
+	}
+	return nil
+}
+
+// serveHTTP routes the requests of the BigQuery REST API.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	upload := false
+	switch {
+	case strings.HasPrefix(path, "/upload/bigquery/v2/"):
+		path = strings.TrimPrefix(path, "/upload/bigquery/v2/")
+		upload = true
+	case strings.HasPrefix(path, "/bigquery/v2/"):
+		path = strings.TrimPrefix(path, "/bigquery/v2/")
+	default:
+		writeError(w, notFound("URL %s", r.URL.Path))
+		return
+	}
+	p := strings.Split(strings.Trim(path, "/"), "/")
+	if len(p) < 3 || p[0] != "projects" {
+		writeError(w, notFound("URL %s", r.URL.Path))
+		return
+	}
+	project := p[1]
+	q := r.URL.Query()
+
+	if upload {
+		if len(p) == 3 && p[2] == "jobs" && r.Method == "POST" {
+			s.serveUpload(w, r, project)
+		} else {
+			writeError(w, notFound("URL %s", r.URL.Path))
+		}
+		return
+	}
+
+	var res interface{}
+	var err error
+	m := r.Method
+	switch {
+	case len(p) == 3 && p[2] == "datasets" && m == "GET":
+		res, err = s.listDatasets(project, q.Get("all") == "true")
+	case len(p) == 3 && p[2] == "datasets" && m == "POST":
+		var ds bq.Dataset
+		if err = decodeBody(r, &ds); err == nil {
+			res, err = s.insertDataset(project, &ds)
+		}
+	case len(p) == 4 && p[2] == "datasets":
+		res, err = s.serveDataset(r, project, p[3])
+	case len(p) == 5 && p[2] == "datasets" && p[4] == "tables" && m == "GET":
+		res, err = s.listTables(project, p[3])
+	case len(p) == 5 && p[2] == "datasets" && p[4] == "tables" && m == "POST":
+		var t bq.Table
+		if err = decodeBody(r, &t); err == nil {
+			res, err = s.insertTable(project, p[3], &t)
+		}
+	case len(p) == 6 && p[2] == "datasets" && p[4] == "tables":
+		res, err = s.serveTable(r, project, p[3], p[5])
+	case len(p) == 7 && p[2] == "datasets" && p[4] == "tables" && p[6] == "insertAll" && m == "POST":
+		var req bq.TableDataInsertAllRequest
+		if err = decodeBody(r, &req); err == nil {
+			res, err = s.insertAll(project, p[3], p[5], &req)
+		}
+	case len(p) == 7 && p[2] == "datasets" && p[4] == "tables" && p[6] == "data" && m == "GET":
+		res, err = s.listRows(project, p[3], p[5], q)
+	case len(p) == 3 && p[2] == "jobs" && m == "POST":
+		var j bq.Job
+		if err = decodeBody(r, &j); err == nil {
+			res, err = s.insertJob(project, &j, nil)
+		}
+	case len(p) == 3 && p[2] == "jobs" && m == "GET":
+		res, err = s.listJobs(project)
+	case len(p) == 4 && p[2] == "jobs" && m == "GET":
+		res, err = s.getJob(project, p[3])
+	case len(p) == 5 && p[2] == "jobs" && p[4] == "cancel" && m == "POST":
+		var j *bq.Job
+		if j, err = s.getJob(project, p[3]); err == nil {
+			res = &bq.JobCancelResponse{Job: j}
+		}
+	case len(p) == 4 && p[2] == "queries" && m == "GET":
+		res, err = s.getQueryResults(project, p[3], q)
+	default:
+		err = errorf(http.StatusNotFound, "notFound", "Not found: %s %s", m, r.URL.Path)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, res)
+}
+
+func (s *Server) serveDataset(r *http.Request, project, id string) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ds, err := s.dataset(project, id)
+		if err != nil {
+			return nil, err
+		}
+		return ds.md, nil
+	case "PATCH", "PUT":
+		var md bq.Dataset
+		if err := decodeBody(r, &md); err != nil {
+			return nil, err
+		}
+		return s.patchDataset(project, id, &md, r.Header.Get("If-Match"))
+	case "DELETE":
+		return nil, s.deleteDataset(project, id, r.URL.Query().Get("deleteContents") == "true")
+	}
+	return nil, errorf(http.StatusMethodNotAllowed, "invalid", "method %s not allowed", r.Method)
+}
+
+func (s *Server) serveTable(r *http.Request, project, datasetID, id string) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		t, err := s.table(project, datasetID, id)
+		if err != nil {
+			return nil, err
+		}
+		md := *t.md
+		md.NumRows = uint64(len(t.rows))
+		for _, row := range t.rows {
+			for i, f := range schemaFields(t.md) {
+				md.NumBytes += valueSize(f, get(row, i))
+			}
+		}
+		return &md, nil
+	case "PATCH", "PUT":
+		var md bq.Table
+		if err := decodeBody(r, &md); err != nil {
+			return nil, err
+		}
+		return s.patchTable(project, datasetID, id, &md, r.Header.Get("If-Match"))
+	case "DELETE":
+		return nil, s.deleteTable(project, datasetID, id)
+	}
+	return nil, errorf(http.StatusMethodNotAllowed, "invalid", "method %s not allowed", r.Method)
+}
+
+// dataset returns the dataset, or a notFound error. s.mu must be held.
+func (s *Server) dataset(project, id string) (*dataset, error) {
+	ds, ok := s.datasets[project+":"+id]
+	if !ok {
+		return nil, notFound("Dataset %s:%s", project, id)
+	}
+	return ds, nil
+}
+
+// table returns the table, or a notFound error. s.mu must be held.
+func (s *Server) table(project, datasetID, id string) (*table, error) {
+	ds, err := s.dataset(project, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := ds.tables[id]
+	if !ok {
+		return nil, notFound("Table %s:%s.%s", project, datasetID, id)
+	}
+	return t, nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / 1e6
+}
+
+func (s *Server) insertDataset(project string, md *bq.Dataset) (*bq.Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createDataset(project, md)
+}
+
+// createDataset creates a dataset. s.mu must be held.
+func (s *Server) createDataset(project string, md *bq.Dataset) (*bq.Dataset, error) {
+	ref := md.DatasetReference
+	if ref == nil || ref.DatasetId == "" {
+		return nil, invalid("Dataset ID must be specified")
+	}
+	if ref.ProjectId == "" {
+		ref.ProjectId = project
+	}
+	key := ref.ProjectId + ":" + ref.DatasetId
+	if _, ok := s.datasets[key]; ok {
+		return nil, errorf(http.StatusConflict, "duplicate", "Already Exists: Dataset %s", key)
+	}
+	now := millis(time.Now())
+	md.Id = key
+	md.Kind = "bigquery#dataset"
+	md.CreationTime = now
+	md.LastModifiedTime = now
+	if md.Location == "" {
+		md.Location = "US"
+	}
+	ds := &dataset{md: md, tables: map[string]*table{}, etag: 1}
+	md.Etag = strconv.Itoa(ds.etag)
+	s.datasets[key] = ds
+	return md, nil
+}
+
+func (s *Server) listDatasets(project string, all bool) (*bq.DatasetList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &bq.DatasetList{Kind: "bigquery#datasetList"}
+	for _, ds := range s.datasets {
+		md := ds.md
+		// Datasets whose names begin with an underscore, such as those of
+		// anonymous query results, are hidden.
+		if md.DatasetReference.ProjectId != project || (!all && strings.HasPrefix(md.DatasetReference.DatasetId, "_")) {
+			continue
+		}
+		res.Datasets = append(res.Datasets, &bq.DatasetListDatasets{
+			DatasetReference: md.DatasetReference,
+			FriendlyName:     md.FriendlyName,
+			Id:               md.Id,
+			Kind:             md.Kind,
+			Labels:           md.Labels,
+			Location:         md.Location,
+		})
+	}
+	sort.Slice(res.Datasets, func(i, j int) bool { return res.Datasets[i].Id < res.Datasets[j].Id })
+	return res, nil
+}
+
+func (s *Server) patchDataset(project, id string, md *bq.Dataset, etag string) (*bq.Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, err := s.dataset(project, id)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" && etag != ds.md.Etag {
+		return nil, errorf(http.StatusPreconditionFailed, "conditionNotMet", "Precondition check failed.")
+	}
+	old := ds.md
+	if md.Description != "" || contains(md.ForceSendFields, "Description") {
+		old.Description = md.Description
+	}
+	if md.FriendlyName != "" || contains(md.ForceSendFields, "FriendlyName") {
+		old.FriendlyName = md.FriendlyName
+	}
+	if md.DefaultTableExpirationMs != 0 || contains(md.NullFields, "DefaultTableExpirationMs") {
+		old.DefaultTableExpirationMs = md.DefaultTableExpirationMs
+	}
+	if md.Access != nil {
+		old.Access = md.Access
+	}
+	old.Labels = patchLabels(old.Labels, md.Labels, md.NullFields)
+	ds.etag++
+	old.Etag = strconv.Itoa(ds.etag)
+	old.LastModifiedTime = millis(time.Now())
+	return old, nil
+}
+
+func (s *Server) deleteDataset(project, id string, deleteContents bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, err := s.dataset(project, id)
+	if err != nil {
+		return err
+	}
+	if len(ds.tables) > 0 && !deleteContents {
+		return errorf(http.StatusBadRequest, "resourceInUse", "Dataset %s:%s is still in use", project, id)
+	}
+	delete(s.datasets, project+":"+id)
+	return nil
+}
+
+func (s *Server) insertTable(project, datasetID string, md *bq.Table) (*bq.Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref := md.TableReference
+	if ref == nil || ref.TableId == "" {
+		return nil, invalid("Table ID must be specified")
+	}
+	ref.ProjectId, ref.DatasetId = project, datasetID
+	if _, err := s.createTable(ref, md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// createTable creates a table with the metadata md. s.mu must be held.
+func (s *Server) createTable(ref *bq.TableReference, md *bq.Table) (*table, error) {
+	ds, err := s.dataset(ref.ProjectId, ref.DatasetId)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ds.tables[ref.TableId]; ok {
+		return nil, errorf(http.StatusConflict, "duplicate", "Already Exists: Table %s:%s.%s", ref.ProjectId, ref.DatasetId, ref.TableId)
+	}
+	if md.Schema != nil {
+		if err := validateSchema(md.Schema.Fields); err != nil {
+			return nil, err
+		}
+	}
+	now := millis(time.Now())
+	md.TableReference = ref
+	md.Id = fmt.Sprintf("%s:%s.%s", ref.ProjectId, ref.DatasetId, ref.TableId)
+	md.Kind = "bigquery#table"
+	md.CreationTime = now
+	md.LastModifiedTime = uint64(now)
+	md.Location = ds.md.Location
+	if md.Type == "" {
+		md.Type = "TABLE"
+		if md.View != nil {
+			md.Type = "VIEW"
+		}
+	}
+	if md.ExpirationTime == 0 && ds.md.DefaultTableExpirationMs != 0 {
+		md.ExpirationTime = now + ds.md.DefaultTableExpirationMs
+	}
+	t := &table{md: md, insertIDs: map[string]bool{}, etag: 1}
+	md.Etag = strconv.Itoa(t.etag)
+	ds.tables[ref.TableId] = t
+	return t, nil
+}
+
+func (s *Server) listTables(project, datasetID string) (*bq.TableList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, err := s.dataset(project, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	res := &bq.TableList{Kind: "bigquery#tableList"}
+	for _, t := range ds.tables {
+		md := t.md
+		res.Tables = append(res.Tables, &bq.TableListTables{
+			CreationTime:   md.CreationTime,
+			ExpirationTime: md.ExpirationTime,
+			FriendlyName:   md.FriendlyName,
+			Id:             md.Id,
+			Kind:           md.Kind,
+			Labels:         md.Labels,
+			TableReference: md.TableReference,
+			Type:           md.Type,
+		})
+	}
+	sort.Slice(res.Tables, func(i, j int) bool { return res.Tables[i].Id < res.Tables[j].Id })
+	res.TotalItems = int64(len(res.Tables))
+	return res, nil
+}
+
+func (s *Server) patchTable(project, datasetID, id string, md *bq.Table, etag string) (*bq.Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.table(project, datasetID, id)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" && etag != t.md.Etag {
+		return nil, errorf(http.StatusPreconditionFailed, "conditionNotMet", "Precondition check failed.")
+	}
+	old := t.md
+	if md.Schema != nil {
+		var oldFields []*bq.TableFieldSchema
+		if old.Schema != nil {
+			oldFields = old.Schema.Fields
+		}
+		if err := checkSchemaUpdate(oldFields, md.Schema.Fields); err != nil {
+			return nil, err
+		}
+		old.Schema = md.Schema
+	}
+	if md.Description != "" || contains(md.ForceSendFields, "Description") {
+		old.Description = md.Description
+	}
+	if md.FriendlyName != "" || contains(md.ForceSendFields, "FriendlyName") {
+		old.FriendlyName = md.FriendlyName
+	}
+	if md.ExpirationTime != 0 || contains(md.ForceSendFields, "ExpirationTime") {
+		old.ExpirationTime = md.ExpirationTime
+	}
+	if md.View != nil {
+		old.View = md.View
+	}
+	if md.TimePartitioning != nil {
+		old.TimePartitioning = md.TimePartitioning
+	}
+	if md.EncryptionConfiguration != nil {
+		old.EncryptionConfiguration = md.EncryptionConfiguration
+	}
+	old.Labels = patchLabels(old.Labels, md.Labels, md.NullFields)
+	t.etag++
+	old.Etag = strconv.Itoa(t.etag)
+	old.LastModifiedTime = uint64(millis(time.Now()))
+	return old, nil
+}
+
+func (s *Server) deleteTable(project, datasetID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.table(project, datasetID, id); err != nil {
+		return err
+	}
+	delete(s.datasets[project+":"+datasetID].tables, id)
+	return nil
+}
+
+// patchLabels applies the labels of a patch to old. Labels to delete are
+// sent as null fields "Labels.name".
+func patchLabels(old, labels map[string]string, nullFields []string) map[string]string {
+	if old == nil && (len(labels) > 0 || len(nullFields) > 0) {
+		old = map[string]string{}
+	}
+	for k, v := range labels {
+		old[k] = v
+	}
+	for _, f := range nullFields {
+		if strings.HasPrefix(f, "Labels.") {
+			delete(old, strings.TrimPrefix(f, "Labels."))
+		}
+	}
+	return old
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
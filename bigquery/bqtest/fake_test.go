@@ -0,0 +1,465 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+func newFake(t *testing.T) (*bigquery.Client, *Server) {
+	srv := NewServer()
+	c, err := bigquery.NewClient(context.Background(), "P", srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srv
+}
+
+var testSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "num", Type: bigquery.IntegerFieldType},
+	{Name: "score", Type: bigquery.FloatFieldType},
+}
+
+func mustCreateTable(t *testing.T, c *bigquery.Client, dataset, table string, schema bigquery.Schema) *bigquery.Table {
+	ctx := context.Background()
+	ds := c.Dataset(dataset)
+	if _, err := ds.Metadata(ctx); err != nil {
+		if err := ds.Create(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tab := ds.Table(table)
+	if err := tab.Create(ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
+		t.Fatal(err)
+	}
+	return tab
+}
+
+func readRows(t *testing.T, it *bigquery.RowIterator) [][]bigquery.Value {
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		err := it.Next(&row)
+		if err == iterator.Done {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func errCode(err error) int {
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code
+	}
+	return 0
+}
+
+func TestDatasets(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newFake(t)
+	defer srv.Close()
+
+	ds := c.Dataset("d1")
+	if err := ds.Create(ctx, &bigquery.DatasetMetadata{Description: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Create(ctx, nil); errCode(err) != http.StatusConflict {
+		t.Errorf("creating a duplicate dataset: got %v, want 409", err)
+	}
+	if err := c.Dataset("d2").Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	md, err := ds.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Description != "first" || md.Location != "US" {
+		t.Errorf("got %+v", md)
+	}
+
+	got, err := ds.Update(ctx, bigquery.DatasetMetadataToUpdate{Description: "updated"}, md.ETag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "updated" {
+		t.Errorf("got description %q, want %q", got.Description, "updated")
+	}
+	if _, err := ds.Update(ctx, bigquery.DatasetMetadataToUpdate{Description: "stale"}, md.ETag); errCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("update with a stale etag: got %v, want 412", err)
+	}
+
+	var ids []string
+	it := c.Datasets(ctx)
+	for {
+		d, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, d.DatasetID)
+	}
+	if want := []string{"d1", "d2"}; !testutil.Equal(ids, want) {
+		t.Errorf("got datasets %v, want %v", ids, want)
+	}
+
+	mustCreateTable(t, c, "d1", "t", testSchema)
+	if err := ds.Delete(ctx); errCode(err) != http.StatusBadRequest {
+		t.Errorf("deleting a non-empty dataset: got %v, want 400", err)
+	}
+	if err := ds.DeleteWithContents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Metadata(ctx); errCode(err) != http.StatusNotFound {
+		t.Errorf("got %v, want 404", err)
+	}
+}
+
+func TestTables(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newFake(t)
+	defer srv.Close()
+
+	tab := mustCreateTable(t, c, "d", "t", testSchema)
+	mustCreateTable(t, c, "d", "u", testSchema)
+	if err := tab.Create(ctx, &bigquery.TableMetadata{Schema: testSchema}); errCode(err) != http.StatusConflict {
+		t.Errorf("creating a duplicate table: got %v, want 409", err)
+	}
+	md, err := tab.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testutil.Equal(md.Schema, testSchema) {
+		t.Errorf("got schema %v, want %v", md.Schema, testSchema)
+	}
+
+	var ids []string
+	it := c.Dataset("d").Tables(ctx)
+	for {
+		tt, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tt.TableID)
+	}
+	if want := []string{"t", "u"}; !testutil.Equal(ids, want) {
+		t.Errorf("got tables %v, want %v", ids, want)
+	}
+
+	// Fields can be added and relaxed, but not removed.
+	schema := append(bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	}, testSchema[1:]...)
+	schema = append(schema, &bigquery.FieldSchema{Name: "extra", Type: bigquery.BooleanFieldType})
+	md, err = tab.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema, Description: "d"}, md.ETag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testutil.Equal(md.Schema, schema) || md.Description != "d" {
+		t.Errorf("got %+v", md)
+	}
+	if _, err := tab.Update(ctx, bigquery.TableMetadataToUpdate{Schema: testSchema[:1]}, ""); errCode(err) != http.StatusBadRequest {
+		t.Errorf("removing fields: got %v, want 400", err)
+	}
+
+	if err := tab.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tab.Metadata(ctx); errCode(err) != http.StatusNotFound {
+		t.Errorf("got %v, want 404", err)
+	}
+}
+
+type allTypes struct {
+	S   string
+	B   []byte
+	I   int64
+	F   float64
+	Bo  bool
+	Ts  time.Time
+	D   civil.Date
+	T   civil.Time
+	Dt  civil.DateTime
+	N   *big.Rat
+	Is  []int64
+	Rec struct {
+		X string
+		Y []string
+	}
+}
+
+func TestInsertAndList(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newFake(t)
+	defer srv.Close()
+
+	schema, err := bigquery.InferSchema(allTypes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema = schema.Relax()
+	tab := mustCreateTable(t, c, "d", "t", schema)
+	ts := time.Date(2020, 3, 4, 5, 6, 7, 123456000, time.UTC)
+	row := allTypes{
+		S:  "a",
+		B:  []byte("bytes"),
+		I:  -7,
+		F:  2.5,
+		Bo: true,
+		Ts: ts,
+		D:  civil.DateOf(ts),
+		T:  civil.TimeOf(ts),
+		Dt: civil.DateTimeOf(ts),
+		N:  big.NewRat(3, 4),
+		Is: []int64{1, 2},
+	}
+	row.Rec.X = "x"
+	row.Rec.Y = []string{"y", "z"}
+	ins := tab.Inserter()
+	for _, id := range []string{"id1", "id1"} {
+		if err := ins.Put(ctx, &bigquery.StructSaver{Struct: row, InsertID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// NULLs.
+	nulls := make([]bigquery.Value, len(schema))
+	nulls[0] = "b"
+	if err := ins.Put(ctx, &bigquery.ValuesSaver{Schema: schema, Row: nulls}); err != nil {
+		t.Fatal(err.(bigquery.PutMultiError)[0].Errors[0])
+	}
+
+	got := readRows(t, tab.Read(ctx))
+	want := [][]bigquery.Value{
+		{
+			"a", []byte("bytes"), int64(-7), 2.5, true, ts, row.D, row.T, row.Dt, big.NewRat(3, 4),
+			[]bigquery.Value{int64(1), int64(2)},
+			[]bigquery.Value{"x", []bigquery.Value{"y", "z"}},
+		},
+		{"b", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("rows: -got +want:\n%s", diff)
+	}
+
+	// Pages.
+	it := tab.Read(ctx)
+	it.PageInfo().MaxSize = 1
+	if got := readRows(t, it); len(got) != 2 {
+		t.Errorf("got %d rows reading with page size 1, want 2", len(got))
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newFake(t)
+	defer srv.Close()
+
+	tab := mustCreateTable(t, c, "d", "t", testSchema)
+	rows := []*bigquery.ValuesSaver{
+		{Schema: testSchema, Row: []bigquery.Value{"a", 1, 1.5}},
+		{Schema: testSchema, Row: []bigquery.Value{nil, 2, 2.5}}, // missing required field
+		{Schema: testSchema, Row: []bigquery.Value{"c", "x", 3.5}},
+	}
+	err := tab.Inserter().Put(ctx, rows)
+	pme, ok := err.(bigquery.PutMultiError)
+	if !ok || len(pme) != 3 {
+		t.Fatalf("got %v, want a PutMultiError for 3 rows", err)
+	}
+	if got := readRows(t, tab.Read(ctx)); len(got) != 0 {
+		t.Errorf("got %d rows, want none", len(got))
+	}
+
+	ins := tab.Inserter()
+	ins.SkipInvalidRows = true
+	err = ins.Put(ctx, rows)
+	if pme, ok := err.(bigquery.PutMultiError); !ok || len(pme) != 2 || pme[0].RowIndex != 1 || pme[1].RowIndex != 2 {
+		t.Fatalf("got %v, want errors for rows 1 and 2", err)
+	}
+	if got := readRows(t, tab.Read(ctx)); len(got) != 1 {
+		t.Errorf("got %d rows, want 1", len(got))
+	}
+}
+
+func TestTemplateSuffix(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newFake(t)
+	defer srv.Close()
+
+	tab := mustCreateTable(t, c, "d", "t", testSchema)
+	ins := tab.Inserter()
+	ins.TableTemplateSuffix = "_2020"
+	if err := ins.Put(ctx, &bigquery.ValuesSaver{Schema: testSchema, Row: []bigquery.Value{"a", 1, 1.5}}); err != nil {
+		t.Fatal(err)
+	}
+	md, err := c.Dataset("d").Table("t_2020").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.NumRows != 1 || !testutil.Equal(md.Schema, testSchema) {
+		t.Errorf("got %d rows and schema %v, want 1 row and schema %v", md.NumRows, md.Schema, testSchema)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newFake(t)
+	defer srv.Close()
+
+	if err := c.Dataset("d").Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	tab := c.Dataset("d").Table("t")
+	load := func(src bigquery.LoadSource, wd bigquery.TableWriteDisposition) error {
+		l := tab.LoaderFrom(src)
+		l.WriteDisposition = wd
+		j, err := l.Run(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := j.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+
+	csv := bigquery.NewReaderSource(strings.NewReader("name,num,score\na,1,1.5\nb,,2.5\n"))
+	csv.Schema = testSchema
+	csv.SkipLeadingRows = 1
+	if err := load(csv, ""); err != nil {
+		t.Fatal(err)
+	}
+	js := bigquery.NewReaderSource(strings.NewReader(`{"name": "c", "num": 3}` + "\n" + `{"name": "d", "score": 4.5}` + "\n"))
+	js.SourceFormat = bigquery.JSON
+	if err := load(js, bigquery.WriteAppend); err != nil {
+		t.Fatal(err)
+	}
+	got := readRows(t, tab.Read(ctx))
+	want := [][]bigquery.Value{
+		{"a", int64(1), 1.5},
+		{"b", nil, 2.5},
+		{"c", int64(3), nil},
+		{"d", nil, 4.5},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("-got +want:\n%s", diff)
+	}
+
+	bad := bigquery.NewReaderSource(strings.NewReader("e,x,1\n"))
+	if err := load(bad, bigquery.WriteAppend); err == nil {
+		t.Error("loading a bad row succeeded")
+	}
+	if err := load(bigquery.NewReaderSource(strings.NewReader("e,5,1\n")), bigquery.WriteEmpty); err == nil {
+		t.Error("loading into a non-empty table with WriteEmpty succeeded")
+	}
+	if err := load(bigquery.NewReaderSource(strings.NewReader("e,5,1\n")), bigquery.WriteTruncate); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRows(t, tab.Read(ctx)); !testutil.Equal(got, [][]bigquery.Value{{"e", int64(5), 1.0}}) {
+		t.Errorf("after truncating: got %v", got)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	defer srv.Close()
+	bqs, err := bq.NewService(ctx, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bqs.Datasets.Insert("P", &bq.Dataset{DatasetReference: &bq.DatasetReference{DatasetId: "d"}}).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload enough data for several chunks of the minimum size.
+	const n = 100000
+	data := strings.Repeat("abcdefg\n", n)
+	job := &bq.Job{Configuration: &bq.JobConfiguration{Load: &bq.JobConfigurationLoad{
+		DestinationTable: &bq.TableReference{ProjectId: "P", DatasetId: "d", TableId: "t"},
+		Schema:           &bq.TableSchema{Fields: []*bq.TableFieldSchema{{Name: "s", Type: "STRING"}}},
+	}}}
+	res, err := bqs.Jobs.Insert("P", job).Media(strings.NewReader(data), googleapi.ChunkSize(googleapi.MinUploadChunkSize)).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status.ErrorResult != nil {
+		t.Fatal(res.Status.ErrorResult.Message)
+	}
+	if got := res.Statistics.Load.OutputRows; got != n {
+		t.Errorf("got %d rows, want %d", got, n)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newFake(t)
+	defer srv.Close()
+
+	src1 := mustCreateTable(t, c, "d", "s1", testSchema)
+	src2 := mustCreateTable(t, c, "d", "s2", testSchema)
+	put := func(tab *bigquery.Table, name string) {
+		if err := tab.Inserter().Put(ctx, &bigquery.ValuesSaver{Schema: testSchema, Row: []bigquery.Value{name, 1, 1.0}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(src1, "a")
+	put(src2, "b")
+
+	dst := c.Dataset("d").Table("dst")
+	copy := func() error {
+		j, err := dst.CopierFrom(src1, src2).Run(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := j.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+	if err := copy(); err != nil {
+		t.Fatal(err)
+	}
+	got := readRows(t, dst.Read(ctx))
+	want := [][]bigquery.Value{{"a", int64(1), 1.0}, {"b", int64(1), 1.0}}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("-got +want:\n%s", diff)
+	}
+	// The default write disposition of copies is WriteEmpty.
+	if err := copy(); err == nil {
+		t.Error("copying to a non-empty table succeeded")
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// anonymousDataset is the dataset of the tables of query results that have
+// no destination table. Like the service's, it is hidden.
+const anonymousDataset = "_bqtest_anonymous"
+
+// serveUpload serves the insertion of a job with media, which is always a
+// load job. The media is sent either with the job in a multipart request, or
+// in a resumable upload: a request with the job that returns the URL of the
+// upload in its Location header, followed by requests with chunks of the
+// media.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, project string) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("upload_id") != "":
+		s.serveUploadChunk(w, r, q.Get("upload_id"))
+
+	case q.Get("uploadType") == "resumable":
+		var j bq.Job
+		if err := decodeBody(r, &j); err != nil {
+			writeError(w, err)
+			return
+		}
+		s.mu.Lock()
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &upload{project: project, job: &j}
+		s.mu.Unlock()
+		v := url.Values{"uploadType": {"resumable"}, "upload_id": {id}}
+		w.Header().Set("Location", s.URL+r.URL.Path+"?"+v.Encode())
+		w.WriteHeader(http.StatusOK)
+
+	case q.Get("uploadType") == "multipart":
+		j, data, err := readMultipart(r)
+		if err == nil {
+			j, err = s.insertJob(project, j, data)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, j)
+
+	default:
+		writeError(w, invalid("Unsupported upload type %q", q.Get("uploadType")))
+	}
+}
+
+func (s *Server) serveUploadChunk(w http.ResponseWriter, r *http.Request, id string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, invalid("reading upload: %v", err))
+		return
+	}
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	if ok {
+		u.data = append(u.data, data...)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, notFound("Upload %s", id))
+		return
+	}
+	// The Content-Range of a chunk is "bytes first-last/*", or
+	// "bytes first-last/total" or "bytes */total" for the final chunk.
+	if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.data)-1))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	j, err := s.insertJob(u.project, u.job, u.data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, j)
+}
+
+// readMultipart reads the job and media of a multipart upload.
+func readMultipart(r *http.Request) (*bq.Job, []byte, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, invalid("bad Content-Type: %v", err)
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, nil, invalid("reading job: %v", err)
+	}
+	var j bq.Job
+	d := json.NewDecoder(part)
+	d.UseNumber()
+	if err := d.Decode(&j); err != nil {
+		return nil, nil, invalid("bad job: %v", err)
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		return nil, nil, invalid("reading media: %v", err)
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		return nil, nil, invalid("reading media: %v", err)
+	}
+	return &j, data, nil
+}
+
+// insertJob inserts and runs a job. The data is the media of a load job.
+// Jobs run to completion before insertJob returns; an error in a job is
+// recorded in its status, and is not returned.
+func (s *Server) insertJob(project string, md *bq.Job, data []byte) (*bq.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conf := md.Configuration
+	if conf == nil {
+		return nil, invalid("Job configuration must be specified")
+	}
+	ref := md.JobReference
+	if ref == nil {
+		ref = &bq.JobReference{}
+		md.JobReference = ref
+	}
+	if ref.ProjectId == "" {
+		ref.ProjectId = project
+	}
+	if ref.Location == "" {
+		ref.Location = "US"
+	}
+	now := millis(time.Now())
+	md.Kind = "bigquery#job"
+	md.Statistics = &bq.JobStatistics{CreationTime: now, StartTime: now}
+	if conf.DryRun {
+		// Dry runs are not recorded, and have no job ID.
+		if conf.Query == nil {
+			return nil, invalid("Only query jobs can be dry runs")
+		}
+		ref.JobId = ""
+		res, err := s.runQuery(project, conf.Query, true)
+		if err != nil {
+			return nil, err
+		}
+		md.Statistics.TotalBytesProcessed = res.bytes
+		md.Statistics.Query = &bq.JobStatistics2{
+			TotalBytesProcessed: res.bytes,
+			StatementType:       "SELECT",
+			Schema:              &bq.TableSchema{Fields: res.fields},
+		}
+		md.Status = &bq.JobStatus{State: "DONE"}
+		return md, nil
+	}
+	if ref.JobId == "" {
+		s.nextID++
+		ref.JobId = fmt.Sprintf("job_%d", s.nextID)
+	}
+	key := ref.ProjectId + ":" + ref.JobId
+	if _, ok := s.jobs[key]; ok {
+		return nil, errorf(http.StatusConflict, "duplicate", "Already Exists: Job %s", key)
+	}
+	md.Id = key
+
+	var err error
+	switch {
+	case conf.Load != nil:
+		err = s.runLoad(project, conf.Load, data, md.Statistics)
+	case conf.Copy != nil:
+		err = s.runCopy(project, conf.Copy)
+	case conf.Query != nil:
+		err = s.runQueryJob(md)
+	case conf.Extract != nil:
+		err = invalid("Extract jobs are not supported by the fake")
+	default:
+		err = invalid("Job configuration must specify a job type")
+	}
+	md.Statistics.EndTime = millis(time.Now())
+	md.Status = &bq.JobStatus{State: "DONE"}
+	if err != nil {
+		ep := errorProto(err)
+		md.Status.ErrorResult = ep
+		md.Status.Errors = []*bq.ErrorProto{ep}
+	}
+	s.jobs[key] = &job{md: md}
+	return md, nil
+}
+
+// runQueryJob runs a query job, writing its result to its destination table.
+// s.mu must be held.
+func (s *Server) runQueryJob(md *bq.Job) error {
+	conf := md.Configuration.Query
+	project := md.JobReference.ProjectId
+	res, err := s.runQuery(project, conf, false)
+	if err != nil {
+		return err
+	}
+	billed := int64(0)
+	if res.bytes > 0 {
+		// Queries are billed by the megabyte, for at least 10 MB.
+		billed = (res.bytes + 1<<20 - 1) &^ (1<<20 - 1)
+		if billed < 10<<20 {
+			billed = 10 << 20
+		}
+	}
+	if conf.MaximumBytesBilled > 0 && billed > conf.MaximumBytesBilled {
+		return errorf(http.StatusBadRequest, "bytesBilledLimitExceeded",
+			"Query exceeded limit for bytes billed: %d. %d or higher required.", conf.MaximumBytesBilled, billed)
+	}
+	dst := conf.DestinationTable
+	cd, wd := conf.CreateDisposition, conf.WriteDisposition
+	if dst == nil {
+		if _, err := s.dataset(project, anonymousDataset); err != nil {
+			if _, err := s.createDataset(project, &bq.Dataset{
+				DatasetReference: &bq.DatasetReference{ProjectId: project, DatasetId: anonymousDataset},
+				Location:         md.JobReference.Location,
+			}); err != nil {
+				return err
+			}
+		}
+		dst = &bq.TableReference{ProjectId: project, DatasetId: anonymousDataset, TableId: "anon_" + md.JobReference.JobId}
+		conf.DestinationTable = dst
+		cd, wd = "", "WRITE_TRUNCATE"
+	} else if dst.ProjectId == "" {
+		dst.ProjectId = project
+	}
+	if wd == "" {
+		wd = "WRITE_EMPTY"
+	}
+	if err := s.writeTable(dst, &bq.TableSchema{Fields: res.fields}, res.rows, cd, wd); err != nil {
+		return err
+	}
+	var referenced []*bq.TableReference
+	if res.ref != nil {
+		referenced = append(referenced, res.ref)
+	}
+	md.Statistics.TotalBytesProcessed = res.bytes
+	md.Statistics.Query = &bq.JobStatistics2{
+		TotalBytesProcessed: res.bytes,
+		TotalBytesBilled:    billed,
+		StatementType:       "SELECT",
+		Schema:              &bq.TableSchema{Fields: res.fields},
+		ReferencedTables:    referenced,
+		QueryPlan:           res.plan,
+	}
+	return nil
+}
+
+func (s *Server) getJob(project, id string) (*bq.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[project+":"+id]
+	if !ok {
+		return nil, notFound("Job %s:%s", project, id)
+	}
+	return j.md, nil
+}
+
+func (s *Server) listJobs(project string) (*bq.JobList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &bq.JobList{Kind: "bigquery#jobList"}
+	for _, j := range s.jobs {
+		md := j.md
+		if md.JobReference.ProjectId != project {
+			continue
+		}
+		res.Jobs = append(res.Jobs, &bq.JobListJobs{
+			Configuration: md.Configuration,
+			ErrorResult:   md.Status.ErrorResult,
+			Id:            md.Id,
+			JobReference:  md.JobReference,
+			Kind:          md.Kind,
+			State:         md.Status.State,
+			Statistics:    md.Statistics,
+			Status:        md.Status,
+		})
+	}
+	sort.Slice(res.Jobs, func(i, j int) bool { return res.Jobs[i].Id < res.Jobs[j].Id })
+	return res, nil
+}
+
+// getQueryResults implements jobs.getQueryResults. It returns the error of a
+// failed job.
+func (s *Server) getQueryResults(project, id string, q url.Values) (*bq.GetQueryResultsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[project+":"+id]
+	if !ok {
+		return nil, notFound("Job %s:%s", project, id)
+	}
+	md := j.md
+	if md.Configuration.Query == nil {
+		return nil, invalid("Job %s is not a query", md.Id)
+	}
+	if ep := md.Status.ErrorResult; ep != nil {
+		return nil, errorf(http.StatusBadRequest, ep.Reason, "%s", ep.Message)
+	}
+	ref := md.Configuration.Query.DestinationTable
+	t, err := s.table(ref.ProjectId, ref.DatasetId, ref.TableId)
+	if err != nil {
+		return nil, err
+	}
+	rows, pageToken, err := page(t.rows, q)
+	if err != nil {
+		return nil, err
+	}
+	return &bq.GetQueryResultsResponse{
+		Kind:                "bigquery#getQueryResultsResponse",
+		JobReference:        md.JobReference,
+		JobComplete:         true,
+		Schema:              t.md.Schema,
+		TotalRows:           uint64(len(t.rows)),
+		TotalBytesProcessed: md.Statistics.TotalBytesProcessed,
+		Rows:                tableRows(schemaFields(t.md), rows),
+		PageToken:           pageToken,
+	}, nil
+}
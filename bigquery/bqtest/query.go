@@ -0,0 +1,1076 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+// This file holds the parser and evaluator of the subset of standard SQL
+// that the fake supports.
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"cloud.google.com/go/civil"
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// A query is a parsed SELECT statement.
+type query struct {
+	items   []selectItem
+	from    []string // the parts of the table name; nil if there is no FROM
+	alias   string   // the alias of the table
+	where   []predicate
+	orderBy []orderKey
+	limit   int64 // -1 if there is no LIMIT
+}
+
+type selectItem struct {
+	star  bool
+	count bool // COUNT(*)
+	e     expr
+	alias string
+}
+
+// An expr is a column reference, a literal or a query parameter.
+type expr struct {
+	path  []string    // the column path, for a column reference
+	param string      // the parameter name, for a parameter
+	lit   interface{} // the value of a literal or parameter
+	field *bq.TableFieldSchema
+
+	index []int // the field indexes of the path, once resolved
+}
+
+type predicate struct {
+	left, right expr
+	op          string // a comparison operator, "IS NULL" or "IS NOT NULL"
+}
+
+type orderKey struct {
+	e    expr
+	desc bool
+}
+
+func (e expr) String() string {
+	switch {
+	case e.path != nil:
+		return strings.Join(e.path, ".")
+	case e.param != "":
+		return "@" + e.param
+	case e.lit == nil:
+		return "NULL"
+	case fieldType(e.field) == "STRING":
+		return strconv.Quote(e.lit.(string))
+	}
+	return formatValue(e.lit)
+}
+
+func (p predicate) String() string {
+	if p.op == "IS NULL" || p.op == "IS NOT NULL" {
+		return p.left.String() + " " + p.op
+	}
+	return p.left.String() + " " + p.op + " " + p.right.String()
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuoted // a backquoted identifier
+	tokString
+	tokNumber
+	tokParam
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	s    string
+}
+
+func tokenize(sql string) ([]token, error) {
+	var toks []token
+	rs := []rune(sql)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-', r == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			toks = append(toks, token{tokIdent, string(rs[i:j])})
+			i = j
+		case r == '@':
+			j := i + 1
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			if j == i+1 {
+				return nil, invalid("Syntax error: unexpected @")
+			}
+			toks = append(toks, token{tokParam, string(rs[i+1 : j])})
+			i = j
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.' || rs[j] == 'e' || rs[j] == 'E' ||
+				((rs[j] == '+' || rs[j] == '-') && (rs[j-1] == 'e' || rs[j-1] == 'E'))) {
+				j++
+			}
+			toks = append(toks, token{tokNumber, string(rs[i:j])})
+			i = j
+		case r == '`' || r == '\'' || r == '"':
+			var buf strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+					switch rs[j] {
+					case 'n':
+						buf.WriteRune('\n')
+					case 't':
+						buf.WriteRune('\t')
+					default:
+						buf.WriteRune(rs[j])
+					}
+					continue
+				}
+				buf.WriteRune(rs[j])
+			}
+			if j == len(rs) {
+				return nil, invalid("Syntax error: unclosed %c", r)
+			}
+			kind := tokString
+			if r == '`' {
+				kind = tokQuoted
+			}
+			toks = append(toks, token{kind, buf.String()})
+			i = j + 1
+		default:
+			s := string(r)
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "!=", "<>", "<=", ">=":
+					s = two
+				}
+			}
+			if !strings.Contains(",()*=<>!.;-", s[:1]) {
+				return nil, invalid("Syntax error: unexpected character %q", r)
+			}
+			toks = append(toks, token{tokSymbol, s})
+			i += len(s)
+		}
+	}
+	return append(toks, token{kind: tokEOF}), nil
+}
+
+// keywords are the reserved words that cannot be implicit aliases.
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true,
+	"ORDER": true, "BY": true, "ASC": true, "DESC": true, "LIMIT": true,
+	"IS": true, "NOT": true, "NULL": true, "TRUE": true, "FALSE": true,
+	"AS": true, "GROUP": true, "HAVING": true, "JOIN": true, "UNION": true,
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is the keyword kw.
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.s, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// symbol consumes the next token if it is the symbol s.
+func (p *parser) symbol(s string) bool {
+	if t := p.peek(); t.kind == tokSymbol && t.s == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	near := t.s
+	if t.kind == tokEOF {
+		near = "end of input"
+	}
+	return invalid("Syntax error: "+format+" near %q", append(args, near)...)
+}
+
+// identifier consumes an identifier, which may be backquoted.
+func (p *parser) identifier() (string, bool) {
+	t := p.peek()
+	if t.kind == tokQuoted || (t.kind == tokIdent && !keywords[strings.ToUpper(t.s)]) {
+		p.pos++
+		return t.s, true
+	}
+	return "", false
+}
+
+func parseQuery(sql string) (*query, error) {
+	toks, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	q := &query{limit: -1}
+	if !p.keyword("SELECT") {
+		return nil, p.errorf("expected SELECT")
+	}
+	for {
+		var item selectItem
+		switch {
+		case p.symbol("*"):
+			item.star = true
+		case p.keyword("COUNT"):
+			if !p.symbol("(") || !p.symbol("*") || !p.symbol(")") {
+				return nil, p.errorf("only COUNT(*) is supported")
+			}
+			item.count = true
+		default:
+			if item.e, err = p.operand(); err != nil {
+				return nil, err
+			}
+		}
+		if !item.star {
+			if p.keyword("AS") {
+				if item.alias, _ = p.identifier(); item.alias == "" {
+					return nil, p.errorf("expected alias")
+				}
+			} else {
+				item.alias, _ = p.identifier()
+			}
+		}
+		q.items = append(q.items, item)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if p.keyword("FROM") {
+		for {
+			name, ok := p.identifier()
+			if !ok {
+				return nil, p.errorf("expected table name")
+			}
+			q.from = append(q.from, strings.Split(name, ".")...)
+			if !p.symbol(".") {
+				break
+			}
+		}
+		if p.keyword("AS") {
+			if q.alias, _ = p.identifier(); q.alias == "" {
+				return nil, p.errorf("expected alias")
+			}
+		} else {
+			q.alias, _ = p.identifier()
+		}
+	}
+	if p.keyword("WHERE") {
+		for {
+			pr, err := p.predicate()
+			if err != nil {
+				return nil, err
+			}
+			q.where = append(q.where, pr)
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+	if p.keyword("ORDER") {
+		if !p.keyword("BY") {
+			return nil, p.errorf("expected BY")
+		}
+		for {
+			var k orderKey
+			if k.e, err = p.operand(); err != nil {
+				return nil, err
+			}
+			if k.e.path == nil {
+				return nil, invalid("ORDER BY supports only column names")
+			}
+			if p.keyword("DESC") {
+				k.desc = true
+			} else {
+				p.keyword("ASC")
+			}
+			q.orderBy = append(q.orderBy, k)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if p.keyword("LIMIT") {
+		t := p.next()
+		n, err := strconv.ParseInt(t.s, 10, 64)
+		if t.kind != tokNumber || err != nil || n < 0 {
+			return nil, invalid("Syntax error: LIMIT expects a non-negative integer literal")
+		}
+		q.limit = n
+	}
+	p.symbol(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected input; the fake supports only simple queries:")
+	}
+	return q, nil
+}
+
+func (p *parser) predicate() (predicate, error) {
+	var pr predicate
+	var err error
+	if pr.left, err = p.operand(); err != nil {
+		return pr, err
+	}
+	if p.keyword("IS") {
+		pr.op = "IS NULL"
+		if p.keyword("NOT") {
+			pr.op = "IS NOT NULL"
+		}
+		if !p.keyword("NULL") {
+			return pr, p.errorf("expected NULL")
+		}
+		return pr, nil
+	}
+	t := p.next()
+	switch t.s {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		if t.kind != tokSymbol {
+			return pr, p.errorf("expected comparison operator")
+		}
+		pr.op = t.s
+	default:
+		p.pos--
+		return pr, p.errorf("expected comparison operator")
+	}
+	if pr.right, err = p.operand(); err != nil {
+		return pr, err
+	}
+	return pr, nil
+}
+
+// operand parses a column reference, literal or parameter.
+func (p *parser) operand() (expr, error) {
+	neg := p.symbol("-")
+	t := p.peek()
+	if neg && t.kind != tokNumber {
+		return expr{}, p.errorf("expected number")
+	}
+	switch t.kind {
+	case tokNumber:
+		p.pos++
+		s := t.s
+		if neg {
+			s = "-" + s
+		}
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return expr{lit: n, field: &bq.TableFieldSchema{Type: "INTEGER"}}, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return expr{}, invalid("Syntax error: invalid number %q", s)
+		}
+		return expr{lit: f, field: &bq.TableFieldSchema{Type: "FLOAT"}}, nil
+	case tokString:
+		p.pos++
+		return expr{lit: t.s, field: &bq.TableFieldSchema{Type: "STRING"}}, nil
+	case tokParam:
+		p.pos++
+		return expr{param: t.s}, nil
+	}
+	switch {
+	case p.keyword("TRUE"):
+		return expr{lit: true, field: &bq.TableFieldSchema{Type: "BOOLEAN"}}, nil
+	case p.keyword("FALSE"):
+		return expr{lit: false, field: &bq.TableFieldSchema{Type: "BOOLEAN"}}, nil
+	case p.keyword("NULL"):
+		return expr{}, nil
+	}
+	var path []string
+	for {
+		name, ok := p.identifier()
+		if !ok {
+			return expr{}, p.errorf("expected expression")
+		}
+		path = append(path, name)
+		if !p.symbol(".") {
+			break
+		}
+	}
+	return expr{path: path}, nil
+}
+
+// A queryResult is the result of running a query.
+type queryResult struct {
+	fields []*bq.TableFieldSchema
+	rows   [][]interface{}
+	bytes  int64 // the bytes processed
+	ref    *bq.TableReference
+	plan   []*bq.ExplainQueryStage
+}
+
+// runQuery parses and runs the query of conf. If dryRun is true, the result
+// has no rows. s.mu must be held.
+func (s *Server) runQuery(project string, conf *bq.JobConfigurationQuery, dryRun bool) (*queryResult, error) {
+	if conf.UseLegacySql != nil && *conf.UseLegacySql {
+		return nil, invalid("Legacy SQL is not supported by the fake")
+	}
+	q, err := parseQuery(conf.Query)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]*bq.QueryParameter{}
+	for _, p := range conf.QueryParameters {
+		params[strings.ToLower(p.Name)] = p
+	}
+	res := &queryResult{}
+	var src []*bq.TableFieldSchema
+	rows := [][]interface{}{nil}
+	if q.from != nil {
+		ref, err := tableRef(project, conf.DefaultDataset, q.from)
+		if err != nil {
+			return nil, err
+		}
+		t, err := s.table(ref.ProjectId, ref.DatasetId, ref.TableId)
+		if err != nil {
+			return nil, err
+		}
+		res.ref, src, rows = ref, schemaFields(t.md), t.rows
+	}
+	r := &resolver{fields: src, table: q.from, alias: q.alias, params: params, used: map[int]bool{}}
+	if err := r.resolve(q); err != nil {
+		return nil, err
+	}
+	res.fields = r.output
+	for _, row := range rows {
+		for i := range r.used {
+			res.bytes += valueSize(src[i], get(row, i))
+		}
+	}
+	res.plan = queryPlan(q, res)
+	if q.from != nil {
+		res.plan[0].RecordsRead = int64(len(rows))
+	}
+	if dryRun {
+		return res, nil
+	}
+
+	var matched [][]interface{}
+	for _, row := range rows {
+		if matches(q.where, row) {
+			matched = append(matched, row)
+		}
+	}
+	if r.aggregate {
+		var out []interface{}
+		for range q.items {
+			out = append(out, int64(len(matched)))
+		}
+		res.rows = [][]interface{}{out}
+		if q.limit == 0 {
+			res.rows = nil
+		}
+		res.plan[0].RecordsWritten = int64(len(matched))
+		return res, nil
+	}
+	if len(q.orderBy) > 0 {
+		sort.SliceStable(matched, func(i, j int) bool {
+			for _, k := range q.orderBy {
+				c := compareValues(k.e.eval(matched[i]), k.e.eval(matched[j]))
+				if c != 0 {
+					return (c < 0) != k.desc
+				}
+			}
+			return false
+		})
+	}
+	if q.limit >= 0 && int64(len(matched)) > q.limit {
+		matched = matched[:q.limit]
+	}
+	for _, row := range matched {
+		var out []interface{}
+		for _, item := range q.items {
+			if item.star {
+				for i := range src {
+					out = append(out, get(row, i))
+				}
+				continue
+			}
+			out = append(out, item.e.eval(row))
+		}
+		res.rows = append(res.rows, out)
+	}
+	res.plan[0].RecordsWritten = int64(len(res.rows))
+	return res, nil
+}
+
+// tableRef returns the reference to the table with the given name parts.
+func tableRef(project string, defaultDataset *bq.DatasetReference, parts []string) (*bq.TableReference, error) {
+	switch len(parts) {
+	case 3:
+		return &bq.TableReference{ProjectId: parts[0], DatasetId: parts[1], TableId: parts[2]}, nil
+	case 2:
+		return &bq.TableReference{ProjectId: project, DatasetId: parts[0], TableId: parts[1]}, nil
+	case 1:
+		if defaultDataset == nil {
+			return nil, invalid("Table name %q missing dataset while no default dataset is set in the request.", parts[0])
+		}
+		p := defaultDataset.ProjectId
+		if p == "" {
+			p = project
+		}
+		return &bq.TableReference{ProjectId: p, DatasetId: defaultDataset.DatasetId, TableId: parts[0]}, nil
+	}
+	return nil, invalid("Invalid table name %q", strings.Join(parts, "."))
+}
+
+// A resolver resolves the names of a query.
+type resolver struct {
+	fields    []*bq.TableFieldSchema
+	table     []string
+	alias     string
+	params    map[string]*bq.QueryParameter
+	used      map[int]bool // the indexes of the columns that the query reads
+	output    []*bq.TableFieldSchema
+	aggregate bool
+}
+
+func (r *resolver) resolve(q *query) error {
+	names := map[string]bool{}
+	addOutput := func(f *bq.TableFieldSchema) error {
+		if names[strings.ToLower(f.Name)] {
+			return invalid("Duplicate column names in the result are not supported. Found duplicate(s): %s", f.Name)
+		}
+		names[strings.ToLower(f.Name)] = true
+		r.output = append(r.output, f)
+		return nil
+	}
+	for i := range q.items {
+		item := &q.items[i]
+		switch {
+		case item.star:
+			if q.from == nil {
+				return invalid("SELECT * must have a FROM clause")
+			}
+			for j, f := range r.fields {
+				r.used[j] = true
+				if err := addOutput(f); err != nil {
+					return err
+				}
+			}
+			continue
+		case item.count:
+			r.aggregate = true
+			item.e.field = &bq.TableFieldSchema{Type: "INTEGER"}
+		default:
+			if err := r.resolveExpr(&item.e); err != nil {
+				return err
+			}
+		}
+		f := *item.e.field
+		f.Fields = item.e.field.Fields
+		switch {
+		case item.alias != "":
+			f.Name = item.alias
+		case item.e.path != nil:
+			f.Name = item.e.path[len(item.e.path)-1]
+		default:
+			f.Name = fmt.Sprintf("f%d_", i)
+		}
+		if f.Mode != "REPEATED" {
+			f.Mode = "NULLABLE"
+		}
+		if f.Type == "" {
+			f.Type = "INTEGER" // the type of NULL
+		}
+		if err := addOutput(&f); err != nil {
+			return err
+		}
+	}
+	if r.aggregate {
+		for _, item := range q.items {
+			if !item.count {
+				return invalid("SELECT list expression %s is neither grouped nor aggregated", item.e)
+			}
+		}
+		if len(q.orderBy) > 0 {
+			return invalid("ORDER BY is not supported with COUNT(*) by the fake")
+		}
+	}
+	for i := range q.where {
+		pr := &q.where[i]
+		if err := r.resolveExpr(&pr.left); err != nil {
+			return err
+		}
+		if pr.op == "IS NULL" || pr.op == "IS NOT NULL" {
+			continue
+		}
+		if err := r.resolveExpr(&pr.right); err != nil {
+			return err
+		}
+		if err := checkComparison(pr); err != nil {
+			return err
+		}
+	}
+	for i := range q.orderBy {
+		k := &q.orderBy[i]
+		if len(k.e.path) == 1 {
+			// The key may name a column of the result.
+			for _, item := range q.items {
+				name := item.alias
+				if name == "" && item.e.path != nil {
+					name = item.e.path[len(item.e.path)-1]
+				}
+				if item.e.path != nil && strings.EqualFold(name, k.e.path[0]) {
+					k.e = item.e
+					break
+				}
+			}
+		}
+		if err := r.resolveExpr(&k.e); err != nil {
+			return err
+		}
+		if k.e.field.Mode == "REPEATED" || !comparable(k.e.field) {
+			return invalid("ORDER BY does not support expressions of type %s", typeString(k.e.field))
+		}
+	}
+	return nil
+}
+
+// resolveExpr resolves a column reference to the field indexes of its path,
+// or a parameter to its value.
+func (r *resolver) resolveExpr(e *expr) error {
+	if e.param != "" {
+		p, ok := r.params[strings.ToLower(e.param)]
+		if !ok {
+			return invalid("Query parameter '%s' not found", e.param)
+		}
+		v, f, err := paramValue(p.ParameterType, p.ParameterValue)
+		if err != nil {
+			return invalid("Query parameter '%s': %v", e.param, err)
+		}
+		e.lit, e.field = v, f
+		return nil
+	}
+	if e.path == nil {
+		return nil
+	}
+	path := e.path
+	if len(path) > 1 && lookupField(r.fields, path[0]) == nil &&
+		(strings.EqualFold(path[0], r.alias) || (len(r.table) > 0 && strings.EqualFold(path[0], r.table[len(r.table)-1]))) {
+		path = path[1:]
+	}
+	fields := r.fields
+	e.index = nil
+	for i, name := range path {
+		j := fieldIndex(fields, name)
+		if j < 0 {
+			return invalid("Unrecognized name: %s", strings.Join(path[:i+1], "."))
+		}
+		if i == 0 {
+			r.used[j] = true
+		}
+		f := fields[j]
+		e.index = append(e.index, j)
+		e.field = f
+		if i < len(path)-1 {
+			if f.Mode == "REPEATED" || fieldType(f) != "RECORD" {
+				return invalid("Cannot access field %s on a value with type %s", path[i+1], typeString(f))
+			}
+			fields = f.Fields
+		}
+	}
+	return nil
+}
+
+// paramValue converts a query parameter to a value and its type.
+func paramValue(pt *bq.QueryParameterType, pv *bq.QueryParameterValue) (interface{}, *bq.TableFieldSchema, error) {
+	if pt == nil {
+		return nil, nil, fmt.Errorf("missing type")
+	}
+	if pt.Type == "ARRAY" {
+		_, ef, err := paramValue(pt.ArrayType, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		f := *ef
+		f.Mode = "REPEATED"
+		if pv == nil {
+			return nil, &f, nil
+		}
+		var vs []interface{}
+		for _, av := range pv.ArrayValues {
+			v, _, err := paramValue(pt.ArrayType, av)
+			if err != nil {
+				return nil, nil, err
+			}
+			vs = append(vs, v)
+		}
+		return vs, &f, nil
+	}
+	f := &bq.TableFieldSchema{Type: pt.Type}
+	f.Type = fieldType(f)
+	if !fieldTypes[f.Type] || f.Type == "RECORD" {
+		return nil, nil, fmt.Errorf("type %s is not supported by the fake", pt.Type)
+	}
+	if pv == nil {
+		return nil, f, nil
+	}
+	v, err := parseValue(f.Type, pv.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, f, nil
+}
+
+func typeString(f *bq.TableFieldSchema) string {
+	t := fieldType(f)
+	if t == "RECORD" {
+		t = "STRUCT"
+	}
+	if f.Mode == "REPEATED" {
+		return "ARRAY<" + t + ">"
+	}
+	return t
+}
+
+func comparable(f *bq.TableFieldSchema) bool {
+	t := fieldType(f)
+	return t != "RECORD" && t != "GEOGRAPHY"
+}
+
+func numeric(f *bq.TableFieldSchema) bool {
+	switch fieldType(f) {
+	case "INTEGER", "FLOAT", "NUMERIC":
+		return true
+	}
+	return false
+}
+
+// checkComparison checks the types of the operands of a comparison, and
+// coerces string literals to the type of the other operand.
+func checkComparison(pr *predicate) error {
+	l, r := &pr.left, &pr.right
+	if l.field == nil || r.field == nil {
+		return nil // NULL compares with anything
+	}
+	coerce := func(lit, other *expr) error {
+		if lit.path != nil || lit.param != "" || fieldType(lit.field) != "STRING" || lit.lit == nil {
+			return nil
+		}
+		switch t := fieldType(other.field); t {
+		case "TIMESTAMP", "DATE", "TIME", "DATETIME", "NUMERIC":
+			if other.field.Mode == "REPEATED" {
+				return nil
+			}
+			v, err := parseValue(t, lit.lit.(string))
+			if err != nil {
+				return invalid("Could not cast literal %q to type %s", lit.lit, t)
+			}
+			lit.lit, lit.field = v, &bq.TableFieldSchema{Type: t}
+		}
+		return nil
+	}
+	if err := coerce(l, r); err != nil {
+		return err
+	}
+	if err := coerce(r, l); err != nil {
+		return err
+	}
+	lf, rf := l.field, r.field
+	ok := lf.Mode != "REPEATED" && rf.Mode != "REPEATED" && comparable(lf) && comparable(rf) &&
+		(fieldType(lf) == fieldType(rf) || (numeric(lf) && numeric(rf)))
+	if !ok {
+		return invalid("No matching signature for operator %s for argument types: %s, %s", pr.op, typeString(lf), typeString(rf))
+	}
+	return nil
+}
+
+// eval returns the value of the expression in the row.
+func (e expr) eval(row []interface{}) interface{} {
+	if e.path == nil {
+		return e.lit
+	}
+	var v interface{} = row
+	for _, i := range e.index {
+		if v == nil {
+			return nil
+		}
+		v = get(v.([]interface{}), i)
+	}
+	return v
+}
+
+func matches(where []predicate, row []interface{}) bool {
+	for _, pr := range where {
+		l := pr.left.eval(row)
+		switch pr.op {
+		case "IS NULL":
+			if l != nil {
+				return false
+			}
+			continue
+		case "IS NOT NULL":
+			if l == nil {
+				return false
+			}
+			continue
+		}
+		r := pr.right.eval(row)
+		if l == nil || r == nil {
+			return false
+		}
+		c := compareValues(l, r)
+		var ok bool
+		switch pr.op {
+		case "=":
+			ok = c == 0
+		case "!=", "<>":
+			ok = c != 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues compares two non-repeated values of comparable types. NULL
+// is less than any other value.
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	if ra, ok := toRat(a); ok {
+		rb, _ := toRat(b)
+		if fa, ok := a.(float64); ok {
+			if fb, ok := b.(float64); ok {
+				return compareFloats(fa, fb)
+			}
+		}
+		if ra == nil || rb == nil {
+			// NaN or infinity.
+			fa, _ := toFloat(a)
+			fb, _ := toFloat(b)
+			return compareFloats(fa, fb)
+		}
+		return ra.Cmp(rb)
+	}
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case bool:
+		return compareInts(boolInt(a), boolInt(b.(bool)))
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+		return 0
+	case civil.Date:
+		return compareDates(a, b.(civil.Date))
+	case civil.Time:
+		return compareTimes(a, b.(civil.Time))
+	case civil.DateTime:
+		b := b.(civil.DateTime)
+		if c := compareDates(a.Date, b.Date); c != 0 {
+			return c
+		}
+		return compareTimes(a.Time, b.Time)
+	}
+	return 0
+}
+
+// toRat converts a numeric value to a big.Rat. It returns nil, true for a
+// float that is not finite.
+func toRat(v interface{}) (*big.Rat, bool) {
+	switch v := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(v), true
+	case float64:
+		return new(big.Rat).SetFloat64(v), true
+	case *big.Rat:
+		return v, true
+	}
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func compareDates(a, b civil.Date) int {
+	return compareInts(a.DaysSince(b), 0)
+}
+
+func compareTimes(a, b civil.Time) int {
+	for _, c := range [][2]int{{a.Hour, b.Hour}, {a.Minute, b.Minute}, {a.Second, b.Second}, {a.Nanosecond, b.Nanosecond}} {
+		if d := compareInts(c[0], c[1]); d != 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// queryPlan returns a plan for the query, in the form of the service's
+// query plans: an input stage that reads and filters the table, followed by
+// an output stage if the query sorts or aggregates.
+func queryPlan(q *query, res *queryResult) []*bq.ExplainQueryStage {
+	var cols []string
+	for _, f := range res.fields {
+		cols = append(cols, f.Name)
+	}
+	read := &bq.ExplainQueryStep{Kind: "READ", Substeps: []string{strings.Join(cols, ", ")}}
+	if res.ref != nil {
+		read.Substeps = append(read.Substeps, fmt.Sprintf("FROM %s.%s", res.ref.DatasetId, res.ref.TableId))
+	}
+	if len(q.where) > 0 {
+		var conds []string
+		for _, pr := range q.where {
+			conds = append(conds, pr.String())
+		}
+		read.Substeps = append(read.Substeps, "WHERE "+strings.Join(conds, " AND "))
+	}
+	input := &bq.ExplainQueryStage{
+		Id:                      0,
+		Name:                    "S00: Input",
+		Status:                  "COMPLETE",
+		ParallelInputs:          1,
+		CompletedParallelInputs: 1,
+		Steps: []*bq.ExplainQueryStep{
+			read,
+			{Kind: "WRITE", Substeps: []string{"TO __stage00_output"}},
+		},
+	}
+	plan := []*bq.ExplainQueryStage{input}
+	var steps []*bq.ExplainQueryStep
+	for _, item := range q.items {
+		if item.count {
+			steps = append(steps, &bq.ExplainQueryStep{Kind: "AGGREGATE", Substeps: []string{"COUNT_STAR()"}})
+			break
+		}
+	}
+	if len(q.orderBy) > 0 {
+		var keys []string
+		for _, k := range q.orderBy {
+			key := k.e.String()
+			if k.desc {
+				key += " DESC"
+			}
+			keys = append(keys, key)
+		}
+		sub := strings.Join(keys, ", ")
+		if q.limit >= 0 {
+			sub += fmt.Sprintf(" LIMIT %d", q.limit)
+		}
+		steps = append(steps, &bq.ExplainQueryStep{Kind: "SORT", Substeps: []string{sub}})
+	}
+	if len(steps) > 0 {
+		steps = append([]*bq.ExplainQueryStep{{Kind: "READ", Substeps: []string{"FROM __stage00_output"}}}, steps...)
+		steps = append(steps, &bq.ExplainQueryStep{Kind: "WRITE", Substeps: []string{"TO __stage01_output"}})
+		plan = append(plan, &bq.ExplainQueryStage{
+			Id:                      1,
+			Name:                    "S01: Output",
+			Status:                  "COMPLETE",
+			InputStages:             []int64{0},
+			ParallelInputs:          1,
+			CompletedParallelInputs: 1,
+			Steps:                   steps,
+		})
+	}
+	return plan
+}
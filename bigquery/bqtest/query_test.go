@@ -0,0 +1,207 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+)
+
+var peopleSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType},
+	{Name: "age", Type: bigquery.IntegerFieldType},
+	{Name: "born", Type: bigquery.DateFieldType},
+	{Name: "score", Type: bigquery.FloatFieldType},
+	{Name: "info", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "city", Type: bigquery.StringFieldType},
+	}},
+}
+
+func newPeople(t *testing.T) (*bigquery.Client, *Server) {
+	c, srv := newFake(t)
+	tab := mustCreateTable(t, c, "d", "people", peopleSchema)
+	var rows []*bigquery.ValuesSaver
+	for _, r := range [][]bigquery.Value{
+		{"ann", 31, civil.Date{Year: 1989, Month: 1, Day: 2}, 7.5, []bigquery.Value{"Paris"}},
+		{"bob", 25, civil.Date{Year: 1995, Month: 3, Day: 4}, nil, []bigquery.Value{"Oslo"}},
+		{"cat", 40, civil.Date{Year: 1980, Month: 5, Day: 6}, 9.0, nil},
+		{"dan", nil, nil, 3.0, []bigquery.Value{"Rome"}},
+	} {
+		rows = append(rows, &bigquery.ValuesSaver{Schema: peopleSchema, Row: r})
+	}
+	if err := tab.Inserter().Put(context.Background(), rows); err != nil {
+		t.Fatal(err)
+	}
+	return c, srv
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newPeople(t)
+	defer srv.Close()
+
+	for _, test := range []struct {
+		sql    string
+		params []bigquery.QueryParameter
+		want   [][]bigquery.Value
+	}{
+		{
+			sql: "SELECT name FROM d.people",
+			want: [][]bigquery.Value{
+				{"ann"}, {"bob"}, {"cat"}, {"dan"},
+			},
+		},
+		{
+			sql: "SELECT * FROM `P.d.people` WHERE name = 'bob'",
+			want: [][]bigquery.Value{
+				{"bob", int64(25), civil.Date{Year: 1995, Month: 3, Day: 4}, nil, []bigquery.Value{"Oslo"}},
+			},
+		},
+		{
+			sql:  "SELECT name, age AS years FROM d.people WHERE age >= 30 AND score IS NOT NULL ORDER BY years DESC",
+			want: [][]bigquery.Value{{"cat", int64(40)}, {"ann", int64(31)}},
+		},
+		{
+			sql:  "select p.name from d.people p where p.born < '1990-01-01' order by p.born limit 1",
+			want: [][]bigquery.Value{{"cat"}},
+		},
+		{
+			sql:  "SELECT name, info.city FROM d.people WHERE info.city <> 'Oslo' ORDER BY city DESC",
+			want: [][]bigquery.Value{{"dan", "Rome"}, {"ann", "Paris"}},
+		},
+		{
+			sql:  "SELECT name FROM d.people ORDER BY age DESC, name",
+			want: [][]bigquery.Value{{"cat"}, {"ann"}, {"bob"}, {"dan"}},
+		},
+		{
+			sql:    "SELECT name FROM d.people WHERE age > @min AND score < @max",
+			params: []bigquery.QueryParameter{{Name: "min", Value: 20}, {Name: "max", Value: 8.0}},
+			want:   [][]bigquery.Value{{"ann"}},
+		},
+		{
+			sql:  "SELECT COUNT(*) AS n FROM d.people WHERE score > 5",
+			want: [][]bigquery.Value{{int64(2)}},
+		},
+		{
+			sql:  "SELECT 17, 'x' AS s, TRUE",
+			want: [][]bigquery.Value{{int64(17), "x", true}},
+		},
+		{
+			sql:  "SELECT name FROM d.people WHERE age > 100",
+			want: nil,
+		},
+	} {
+		q := c.Query(test.sql)
+		q.Parameters = test.params
+		it, err := q.Read(ctx)
+		if err != nil {
+			t.Errorf("%s: %v", test.sql, err)
+			continue
+		}
+		got := readRows(t, it)
+		if diff := testutil.Diff(got, test.want); diff != "" {
+			t.Errorf("%s: -got +want:\n%s", test.sql, diff)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newPeople(t)
+	defer srv.Close()
+
+	for _, test := range []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT nope FROM d.people", "Unrecognized name: nope"},
+		{"SELECT name FROM d.nope", "Not found: Table P:d.nope"},
+		{"SELECT name FROM people", "no default dataset"},
+		{"SELECT name FROM d.people WHERE age = 'x'", "No matching signature for operator ="},
+		{"SELECT name FROM d.people WHERE born = 'x'", "Could not cast literal"},
+		{"SELECT name FROM d.people WHERE age IS NULL OR age > 3", "Syntax error"},
+		{"SELECT name, COUNT(*) FROM d.people", "neither grouped nor aggregated"},
+		{"SELECT name, name FROM d.people", "Duplicate column names"},
+		{"SELECT name FROM d.people WHERE age > @x", "Query parameter 'x' not found"},
+		{"SELECT name FROM d.people ORDER BY info", "ORDER BY does not support"},
+		{"SELECT name FROM d.people LIMIT x", "LIMIT expects"},
+		{"UPDATE d.people SET age = 1", "expected SELECT"},
+	} {
+		_, err := c.Query(test.sql).Read(ctx)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one containing %q", test.sql, err, test.want)
+		}
+	}
+
+	q := c.Query("SELECT name FROM [d.people]")
+	q.UseLegacySQL = true
+	if _, err := q.Read(ctx); err == nil {
+		t.Error("legacy SQL succeeded")
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newPeople(t)
+	defer srv.Close()
+
+	// Default dataset and destination table.
+	dst := c.Dataset("d").Table("dst")
+	q := c.Query("SELECT name FROM people WHERE age < 30")
+	q.DefaultDatasetID = "d"
+	q.Dst = dst
+	job, err := q.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err != nil {
+		t.Fatal(err)
+	}
+	qs := status.Statistics.Details.(*bigquery.QueryStatistics)
+	if qs.TotalBytesProcessed == 0 || len(qs.QueryPlan) == 0 {
+		t.Errorf("got statistics %+v, want bytes processed and a plan", qs)
+	}
+	if got := readRows(t, dst.Read(ctx)); !testutil.Equal(got, [][]bigquery.Value{{"bob"}}) {
+		t.Errorf("got destination rows %v", got)
+	}
+
+	// Dry run.
+	q = c.Query("SELECT name, age FROM d.people")
+	q.DryRun = true
+	job, err = q.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// name: 2 bytes + 3 bytes for each of 4 rows; age: 8 bytes for 3 rows.
+	if got, want := job.LastStatus().Statistics.TotalBytesProcessed, int64(4*5+3*8); got != want {
+		t.Errorf("dry run: got %d bytes processed, want %d", got, want)
+	}
+
+	// Bytes billed.
+	q = c.Query("SELECT name FROM d.people")
+	q.MaxBytesBilled = 1000
+	if _, err := q.Read(ctx); err == nil || !strings.Contains(err.Error(), "bytes billed") {
+		t.Errorf("got %v, want an error about bytes billed", err)
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	gax "github.com/googleapis/gax-go/v2"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/support/bundler"
+)
+
+const (
+	// MaxInsertRequestRows is the maximum number of rows in a streaming
+	// insert request.
+	MaxInsertRequestRows = 10000
+
+	// MaxInsertRequestBytes is the maximum size of a streaming insert
+	// request, in bytes.
+	MaxInsertRequestBytes = 10 << 20
+
+	// insertRequestOverhead bounds the bytes of a streaming insert request
+	// other than its rows, and insertRowOverhead those of a row other than
+	// its insert ID and values.
+	insertRequestOverhead = 1 << 10
+	insertRowOverhead     = 32
+)
+
+// ErrOversizedRow indicates that a row is too large to be inserted.
+var ErrOversizedRow = bundler.ErrOversizedItem
+
+var errBufferedInserterClosed = errors.New("bigquery: BufferedInserter is closed")
+
+// bufferedInsertBackoff is the backoff between attempts to insert rows. It
+// is a variable so that tests can change it.
+var bufferedInsertBackoff = gax.Backoff{
+	Initial:    1 * time.Second,
+	Max:        32 * time.Second,
+	Multiplier: 2,
+}
+
+// BufferedInsertSettings control the batching and retrying of rows by a
+// BufferedInserter.
+type BufferedInsertSettings struct {
+	// Insert a non-empty batch after this delay has passed.
+	DelayThreshold time.Duration
+
+	// Insert a batch when it has this many rows. The maximum is
+	// MaxInsertRequestRows.
+	CountThreshold int
+
+	// Insert a batch when its size in bytes reaches this value. Batches are
+	// never larger than MaxInsertRequestBytes.
+	ByteThreshold int
+
+	// The number of goroutines that insert batches concurrently.
+	//
+	// Defaults to a multiple of GOMAXPROCS.
+	NumGoroutines int
+
+	// The maximum time that the inserter will spend on a batch, including
+	// retries.
+	Timeout time.Duration
+
+	// The maximum number of times that the inserter attempts to insert a row
+	// that fails with a temporary error, such as a backend error, before
+	// giving up on it.
+	MaxAttempts int
+
+	// The maximum number of bytes of rows that the inserter will keep in
+	// memory. Put blocks while the limit is reached.
+	//
+	// Defaults to DefaultBufferedInsertSettings.BufferedByteLimit.
+	BufferedByteLimit int
+}
+
+// DefaultBufferedInsertSettings holds the default values for
+// BufferedInserters' settings.
+var DefaultBufferedInsertSettings = BufferedInsertSettings{
+	DelayThreshold:    1 * time.Second,
+	CountThreshold:    500,
+	ByteThreshold:     1e6,
+	Timeout:           5 * time.Minute,
+	MaxAttempts:       5,
+	BufferedByteLimit: 10 * MaxInsertRequestBytes,
+}
+
+// A FailedRow is a row that a BufferedInserter could not insert.
+type FailedRow struct {
+	// The values of the row, as returned by the Save method of its
+	// ValueSaver.
+	Row map[string]Value
+
+	// The insert ID of the row, if any.
+	InsertID string
+
+	// Err is the error of the last attempt to insert the row. It is a
+	// MultiError of *Errors if the service rejected the row, or the error of
+	// the request.
+	Err error
+}
+
+// A BufferedInserter does streaming inserts into a BigQuery table in the
+// background. Put adds rows to a buffer, which is sent in batches when it
+// reaches the thresholds of the inserter's settings. Batches are split to
+// keep them under the limits of streaming insert requests.
+//
+// Rows that fail with temporary errors are retried; the rest of their batch
+// is not resent. Rows that fail permanently, or too many times, are passed to
+// DeadLetter.
+//
+// A BufferedInserter is safe for concurrent use. Call Close when done with
+// it to insert the remaining rows.
+// It is EXPERIMENTAL and subject to change or removal without notice.
+type BufferedInserter struct {
+	t *Table
+
+	// IgnoreUnknownValues causes values not matching the schema to be
+	// ignored. The default value is false, which causes records containing
+	// such values to fail.
+	IgnoreUnknownValues bool
+
+	// TableTemplateSuffix has the same meaning as for Inserter.
+	TableTemplateSuffix string
+
+	// Settings for batching and retrying rows. All changes must be made
+	// before the first call to Put. The default is
+	// DefaultBufferedInsertSettings.
+	Settings BufferedInsertSettings
+
+	// DeadLetter, if non-nil, is called with the rows that could not be
+	// inserted. It may be called concurrently from several goroutines. If
+	// DeadLetter is nil, the failures are reported by Close.
+	DeadLetter func(rows []FailedRow)
+
+	// Put holds mu for reading while it waits for room in the buffer, so
+	// that Close cannot flush the bundler before Put has added its rows.
+	mu      sync.RWMutex
+	closed  bool
+	bundler *bundler.Bundler
+
+	// failMu guards the failures, which are recorded by the bundler's
+	// handlers. It is separate from mu because the buffer only has room for
+	// the rows of Put once the handlers return.
+	failMu   sync.Mutex
+	failures int   // the number of failed rows, if DeadLetter is nil
+	firstErr error // the first of their errors
+}
+
+// BufferedInserter returns a BufferedInserter that appends rows to t.
+// It may optionally be further configured before its Put method is called.
+func (t *Table) BufferedInserter() *BufferedInserter {
+	return &BufferedInserter{t: t, Settings: DefaultBufferedInsertSettings}
+}
+
+// Put adds one or more rows to the inserter's buffer. The src argument is as
+// for Inserter.Put. The rows are saved by the time Put returns.
+//
+// Put blocks while the buffer is full, until there is room for the rows or
+// ctx is done. It returns ErrOversizedRow if a row is too large for a
+// streaming insert request; the rows before it are still inserted. Errors in
+// inserting the rows are not returned by Put: see DeadLetter.
+func (b *BufferedInserter) Put(ctx context.Context, src interface{}) error {
+	savers, err := valueSavers(src)
+	if err != nil {
+		return err
+	}
+	rows := make([]*bq.TableDataInsertAllRequestRows, len(savers))
+	sizes := make([]int, len(savers))
+	for i, saver := range savers {
+		if rows[i], err = newInsertRow(saver); err != nil {
+			return err
+		}
+		js, err := json.Marshal(rows[i].Json)
+		if err != nil {
+			return err
+		}
+		sizes[i] = len(js) + len(rows[i].InsertId) + insertRowOverhead
+	}
+	b.initBundler()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errBufferedInserterClosed
+	}
+	for i, row := range rows {
+		if err := b.bundler.AddWait(ctx, row, sizes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush inserts the buffered rows, and returns once they have been inserted
+// or have failed.
+func (b *BufferedInserter) Flush() {
+	b.mu.RLock()
+	bd := b.bundler
+	b.mu.RUnlock()
+	if bd != nil {
+		bd.Flush()
+	}
+}
+
+// Close inserts the buffered rows and stops the inserter. It returns once
+// all rows have been inserted or have failed. If DeadLetter is nil, it returns
+// an error if any row failed.
+func (b *BufferedInserter) Close() error {
+	b.mu.Lock()
+	noop := b.closed || b.bundler == nil
+	b.closed = true
+	b.mu.Unlock()
+	if !noop {
+		b.bundler.Flush()
+	}
+	b.failMu.Lock()
+	defer b.failMu.Unlock()
+	if b.failures > 0 {
+		return fmt.Errorf("bigquery: %d rows could not be inserted; the first error was: %v", b.failures, b.firstErr)
+	}
+	return nil
+}
+
+func (b *BufferedInserter) initBundler() {
+	b.mu.RLock()
+	noop := b.closed || b.bundler != nil
+	b.mu.RUnlock()
+	if noop {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Must re-check, since we released the lock.
+	if b.closed || b.bundler != nil {
+		return
+	}
+
+	timeout := b.Settings.Timeout
+	b.bundler = bundler.NewBundler(&bq.TableDataInsertAllRequestRows{}, func(items interface{}) {
+		ctx := context.Background()
+		if timeout != 0 {
+			var cancel func()
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		b.insert(ctx, items.([]*bq.TableDataInsertAllRequestRows))
+	})
+	b.bundler.DelayThreshold = b.Settings.DelayThreshold
+	b.bundler.BundleCountThreshold = b.Settings.CountThreshold
+	if b.bundler.BundleCountThreshold <= 0 || b.bundler.BundleCountThreshold > MaxInsertRequestRows {
+		b.bundler.BundleCountThreshold = MaxInsertRequestRows
+	}
+	b.bundler.BundleByteThreshold = b.Settings.ByteThreshold
+	b.bundler.BundleByteLimit = MaxInsertRequestBytes - insertRequestOverhead
+	bufferedByteLimit := DefaultBufferedInsertSettings.BufferedByteLimit
+	if b.Settings.BufferedByteLimit > 0 {
+		bufferedByteLimit = b.Settings.BufferedByteLimit
+	}
+	b.bundler.BufferedByteLimit = bufferedByteLimit
+	if b.Settings.NumGoroutines > 0 {
+		b.bundler.HandlerLimit = b.Settings.NumGoroutines
+	} else {
+		b.bundler.HandlerLimit = 4 * runtime.GOMAXPROCS(0)
+	}
+}
+
+// insert inserts a batch of rows. It retries the rows that fail with
+// temporary errors, and reports the rows that fail permanently.
+func (b *BufferedInserter) insert(ctx context.Context, rows []*bq.TableDataInsertAllRequestRows) {
+	maxAttempts := b.Settings.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	backoff := bufferedInsertBackoff
+	var failed []FailedRow
+	for attempt := 1; len(rows) > 0; attempt++ {
+		last := attempt >= maxAttempts
+		req := &bq.TableDataInsertAllRequest{
+			Rows:                rows,
+			TemplateSuffix:      b.TableTemplateSuffix,
+			IgnoreUnknownValues: b.IgnoreUnknownValues,
+			// Insert the valid rows even if some are invalid, so that only
+			// the failed rows need to be retried.
+			SkipInvalidRows: true,
+		}
+		call := b.t.c.bqs.Tabledata.InsertAll(b.t.ProjectID, b.t.DatasetID, b.t.TableID, req).Context(ctx)
+		setClientHeader(call.Header())
+		res, err := call.Do()
+		var retry []*bq.TableDataInsertAllRequestRows
+		switch {
+		case err != nil && (last || !retryableError(err)):
+			for _, r := range rows {
+				failed = append(failed, failedRow(r, err))
+			}
+		case err != nil:
+			retry = rows
+		default:
+			for _, ie := range res.InsertErrors {
+				if ie.Index < 0 || int(ie.Index) >= len(rows) {
+					continue
+				}
+				r := rows[ie.Index]
+				if !last && retryableInsertErrors(ie.Errors) {
+					retry = append(retry, r)
+					continue
+				}
+				var errs MultiError
+				for _, e := range ie.Errors {
+					errs = append(errs, bqToError(e))
+				}
+				failed = append(failed, failedRow(r, errs))
+			}
+		}
+		rows = retry
+		if len(rows) == 0 {
+			break
+		}
+		if err := gax.Sleep(ctx, backoff.Pause()); err != nil {
+			for _, r := range rows {
+				failed = append(failed, failedRow(r, err))
+			}
+			break
+		}
+	}
+	if len(failed) > 0 {
+		b.fail(failed)
+	}
+}
+
+// retryableInsertErrors reports whether the errors of a row are all
+// temporary. Rows that are not inserted because another row in the request
+// is invalid have the reason "stopped".
+func retryableInsertErrors(errs []*bq.ErrorProto) bool {
+	if len(errs) == 0 {
+		return false
+	}
+	for _, e := range errs {
+		switch e.Reason {
+		case "backendError", "rateLimitExceeded", "internalError", "timeout", "stopped":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func failedRow(r *bq.TableDataInsertAllRequestRows, err error) FailedRow {
+	row := make(map[string]Value, len(r.Json))
+	for k, v := range r.Json {
+		row[k] = v
+	}
+	return FailedRow{Row: row, InsertID: r.InsertId, Err: err}
+}
+
+func (b *BufferedInserter) fail(rows []FailedRow) {
+	if b.DeadLetter != nil {
+		b.DeadLetter(rows)
+		return
+	}
+	b.failMu.Lock()
+	defer b.failMu.Unlock()
+	if b.failures == 0 {
+		b.firstErr = rows[0].Err
+	}
+	b.failures += len(rows)
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	gax "github.com/googleapis/gax-go/v2"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+// insertAllServer serves insertAll requests with a function that returns the
+// insert errors of a row, which it is given with the number of times that it
+// has been sent.
+type insertAllServer struct {
+	rowErrors func(name string, attempt int) []*bq.ErrorProto
+	notFound  bool // fail all requests with a 404
+
+	mu       sync.Mutex
+	sizes    []int          // the number of rows in each request
+	attempts map[string]int // by row name
+	inserted []string
+}
+
+func (s *insertAllServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.notFound || !strings.HasSuffix(r.URL.Path, "/insertAll") {
+		http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+		return
+	}
+	var req bq.TableDataInsertAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes = append(s.sizes, len(req.Rows))
+	var res bq.TableDataInsertAllResponse
+	for i, row := range req.Rows {
+		name := row.Json["name"].(string)
+		s.attempts[name]++
+		var errs []*bq.ErrorProto
+		if s.rowErrors != nil {
+			errs = s.rowErrors(name, s.attempts[name])
+		}
+		if len(errs) > 0 {
+			res.InsertErrors = append(res.InsertErrors, &bq.TableDataInsertAllResponseInsertErrors{Index: int64(i), Errors: errs})
+			continue
+		}
+		s.inserted = append(s.inserted, name)
+	}
+	json.NewEncoder(w).Encode(&res)
+}
+
+func newBufferedInserterTest(t *testing.T, s *insertAllServer) (*BufferedInserter, func()) {
+	prev := bufferedInsertBackoff
+	bufferedInsertBackoff = gax.Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+	s.attempts = map[string]int{}
+	srv := httptest.NewServer(s)
+	c, err := NewClient(context.Background(), "P", option.WithEndpoint(srv.URL), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := c.Dataset("d").Table("t").BufferedInserter()
+	b.Settings.DelayThreshold = time.Hour
+	b.Settings.NumGoroutines = 1
+	return b, func() {
+		srv.Close()
+		bufferedInsertBackoff = prev
+	}
+}
+
+func putNames(t *testing.T, b *BufferedInserter, names ...string) {
+	for _, name := range names {
+		if err := b.Put(context.Background(), testSaver{row: map[string]Value{"name": name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBufferedInserterBatches(t *testing.T) {
+	s := &insertAllServer{}
+	b, cleanup := newBufferedInserterTest(t, s)
+	defer cleanup()
+	b.Settings.CountThreshold = 3
+
+	putNames(t, b, "a", "b", "c", "d", "e", "f", "g")
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 3, 1}; !testutil.Equal(s.sizes, want) {
+		t.Errorf("got request sizes %v, want %v", s.sizes, want)
+	}
+	if got, want := len(s.inserted), 7; got != want {
+		t.Errorf("got %d rows inserted, want %d", got, want)
+	}
+	if err := b.Put(context.Background(), testSaver{row: map[string]Value{"name": "h"}}); err != errBufferedInserterClosed {
+		t.Errorf("Put after Close: got %v, want %v", err, errBufferedInserterClosed)
+	}
+}
+
+func TestBufferedInserterRetries(t *testing.T) {
+	s := &insertAllServer{
+		rowErrors: func(name string, attempt int) []*bq.ErrorProto {
+			switch {
+			case name == "flaky" && attempt == 1:
+				return []*bq.ErrorProto{{Reason: "backendError"}}
+			case name == "down":
+				return []*bq.ErrorProto{{Reason: "backendError"}}
+			case name == "bad":
+				return []*bq.ErrorProto{{Reason: "invalid", Location: "name"}}
+			}
+			return nil
+		},
+	}
+	b, cleanup := newBufferedInserterTest(t, s)
+	defer cleanup()
+	b.Settings.MaxAttempts = 3
+	var mu sync.Mutex
+	var failed []FailedRow
+	b.DeadLetter = func(rows []FailedRow) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, rows...)
+	}
+
+	putNames(t, b, "ok", "flaky", "bad", "down")
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Only the failed rows are retried.
+	if want := []int{4, 2, 1}; !testutil.Equal(s.sizes, want) {
+		t.Errorf("got request sizes %v, want %v", s.sizes, want)
+	}
+	sort.Strings(s.inserted)
+	if want := []string{"flaky", "ok"}; !testutil.Equal(s.inserted, want) {
+		t.Errorf("got inserted rows %v, want %v", s.inserted, want)
+	}
+	if got, want := s.attempts["down"], 3; got != want {
+		t.Errorf("got %d attempts to insert a row that always fails, want %d", got, want)
+	}
+
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Row["name"].(string) < failed[j].Row["name"].(string) })
+	if len(failed) != 2 {
+		t.Fatalf("got %d failed rows, want 2", len(failed))
+	}
+	for i, want := range []struct{ name, reason string }{{"bad", "invalid"}, {"down", "backendError"}} {
+		f := failed[i]
+		errs, ok := f.Err.(MultiError)
+		if f.Row["name"] != want.name || f.InsertID == "" || !ok || len(errs) != 1 || errs[0].(*Error).Reason != want.reason {
+			t.Errorf("got failed row %+v, want row %s with reason %s", f, want.name, want.reason)
+		}
+	}
+}
+
+func TestBufferedInserterRequestError(t *testing.T) {
+	s := &insertAllServer{notFound: true}
+	b, cleanup := newBufferedInserterTest(t, s)
+	defer cleanup()
+
+	putNames(t, b, "a", "b")
+	err := b.Close()
+	if err == nil || !strings.Contains(err.Error(), "2 rows could not be inserted") {
+		t.Errorf("got %v, want an error for 2 rows", err)
+	}
+}
+
+// Rows that fail while Put waits for room in the buffer must not block Put.
+func TestBufferedInserterFailuresWhileFull(t *testing.T) {
+	s := &insertAllServer{notFound: true}
+	b, cleanup := newBufferedInserterTest(t, s)
+	defer cleanup()
+	b.Settings.CountThreshold = 1
+	b.Settings.BufferedByteLimit = 100 // room for one row
+
+	done := make(chan error, 1)
+	go func() {
+		for _, name := range []string{"a", "b", "c", "d"} {
+			if err := b.Put(context.Background(), testSaver{row: map[string]Value{"name": name}}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- b.Close()
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "4 rows could not be inserted") {
+			t.Errorf("got %v, want an error for 4 rows", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Put or Close is blocked")
+	}
+}
@@ -113,6 +113,33 @@ Query.Read is just a convenience method that combines Query.Run and Job.Read.
     }
     // Proceed with iteration as above.
 
+Large results are read faster with the BigQuery Storage API. Enable it on the
+client, and Job.Read will use it for them:
+
+    if err := client.EnableStorageReadClient(ctx); err != nil {
+        // TODO: Handle error.
+    }
+
+Table.StorageRead reads a table with the Storage API, optionally selecting
+columns and filtering rows on the server:
+
+    it := table.StorageRead(ctx, &bigquery.StorageReadOptions{
+        SelectedFields: []string{"name", "num"},
+        RowRestriction: "num > 10",
+    })
+
+To limit the cost of the queries that a client runs, give it a QueryCostPolicy.
+The client then estimates the bytes that each query will process with a dry
+run, and refuses to run queries that are over the policy's limits:
+
+    client.SetQueryCostPolicy(&bigquery.QueryCostPolicy{
+        MaxBytesPerQuery:  10 << 30,
+        MaxBytesPerClient: 100 << 30,
+    })
+
+After a query has run, RenderQueryPlan prints the stages of its plan and its
+timeline, from the statistics in the job's status.
+
 Datasets and Tables
 
 You can refer to datasets in the client's project with the Dataset method, and
@@ -194,6 +221,28 @@ Having constructed a schema, you can create a table with it like so:
         // TODO: Handle error.
     }
 
+To evolve the schema of an existing table, compare it with the new schema.
+DiffSchemas reports the differences between two schemas, and SchemaUpdate
+turns those that BigQuery allows, new nullable fields and relaxed required
+fields, into an update:
+
+    md, err := table.Metadata(ctx)
+    if err != nil {
+        // TODO: Handle error.
+    }
+    update, disallowed := bigquery.SchemaUpdate(md.Schema, schema3)
+    if len(disallowed) > 0 {
+        // TODO: Handle the changes that an update cannot make.
+    }
+    if update.Schema != nil {
+        if _, err := table.Update(ctx, update, md.ETag); err != nil {
+            // TODO: Handle error.
+        }
+    }
+
+Schema.ToJSONFields writes a schema in the JSON format of the bq command-line
+tool's schema files, which SchemaFromJSON reads.
+
 Copying
 
 You can copy one or more tables to another table. Begin by constructing a Copier
@@ -237,6 +286,18 @@ wish:
         time.Sleep(pollInterval)
     }
 
+To follow the progress of a job while waiting for it, use Job.WaitWithProgress.
+It calls a function with the job's progress after each poll, and cancels the job
+if the function returns an error:
+
+    status, err = job.WaitWithProgress(ctx, func(p *bigquery.JobProgress) error {
+        fmt.Printf("%d of %d stages complete\n", p.CompletedStages, p.Stages)
+        if p.SlotMillis > maxSlotMillis {
+            return errors.New("job is too expensive")
+        }
+        return nil
+    })
+
 Loading and Uploading
 
 There are two ways to populate a table with this package: load the data from a Google Cloud Storage
@@ -252,6 +313,13 @@ it as well, and call its Run method.
     job, err = loader.Run(ctx)
     // Poll the job for completion if desired, as above.
 
+To load Go values without writing them to a file first, use a ValueSource.
+It encodes the values as JSON, or Avro, while they are uploaded:
+
+    src := bigquery.NewChannelSource(itemsChan) // a chan *Item
+    src.Schema = schema
+    job, err = myDataset.Table("dest").LoaderFrom(src).Run(ctx)
+
 To upload, first define a type that implements the ValueSaver interface, which has a single method named Save.
 Then create an Uploader, and call its Put method with a slice of values.
 
@@ -285,6 +353,22 @@ directly and the schema will be inferred:
         // TODO: Handle error.
     }
 
+To stream many rows, use a BufferedInserter instead. It batches the rows of
+Put calls in the background, retries rows that fail with temporary errors, and
+passes rows that cannot be inserted to its DeadLetter function:
+
+    bi := table.BufferedInserter()
+    bi.DeadLetter = func(rows []bigquery.FailedRow) {
+        // TODO: Handle the rows.
+    }
+    if err := bi.Put(ctx, items2); err != nil {
+        // TODO: Handle error.
+    }
+    // Insert any buffered rows.
+    if err := bi.Close(); err != nil {
+        // TODO: Handle error.
+    }
+
 Extracting
 
 If you've been following so far, extracting data from a BigQuery table
@@ -296,6 +380,24 @@ Extractor, then optionally configure it, and lastly call its Run method.
     job, err = extractor.Run(ctx)
     // Poll the job for completion if desired, as above.
 
+To save rows to a local file instead, pass a RowIterator to WriteCSV, WriteJSON
+or WriteParquet:
+
+    f, err := os.Create("results.parquet")
+    if err != nil {
+        // TODO: Handle error.
+    }
+    it, err = q.Read(ctx)
+    if err != nil {
+        // TODO: Handle error.
+    }
+    if err := bigquery.WriteParquet(f, it); err != nil {
+        // TODO: Handle error.
+    }
+    if err := f.Close(); err != nil {
+        // TODO: Handle error.
+    }
+
 Errors
 
 Errors returned by this client are often of the type [`googleapi.Error`](https://godoc.org/google.golang.org/api/googleapi#Error).
@@ -16,6 +16,7 @@ package bigquery_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -186,6 +187,22 @@ func ExampleRowIterator_Next() {
 	}
 }
 
+func ExampleWriteCSV() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	q := client.Query("select name, num from t1")
+	it, err := q.Read(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	if err := bigquery.WriteCSV(os.Stdout, it); err != nil {
+		// TODO: Handle error.
+	}
+}
+
 func ExampleRowIterator_Next_struct() {
 	ctx := context.Background()
 	client, err := bigquery.NewClient(ctx, "project-id")
@@ -256,6 +273,76 @@ func ExampleJob_Wait() {
 	}
 }
 
+func ExampleJob_WaitWithProgress() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	q := client.Query("select name, num from t1")
+	job, err := q.Run(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	status, err := job.WaitWithProgress(ctx, func(p *bigquery.JobProgress) error {
+		fmt.Printf("%d/%d stages complete, %d slot-ms\n", p.CompletedStages, p.Stages, p.SlotMillis)
+		if p.SlotMillis > 10*60*60*1000 {
+			// Cancel the job.
+			return errors.New("query used more than ten slot-hours")
+		}
+		return nil
+	})
+	if err != nil {
+		// TODO: Handle error.
+	}
+	if status.Err() != nil {
+		// TODO: Handle error.
+	}
+}
+
+func ExampleClient_SetQueryCostPolicy() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	client.SetQueryCostPolicy(&bigquery.QueryCostPolicy{
+		MaxBytesPerQuery:  10 << 30,  // 10 GiB
+		MaxBytesPerClient: 100 << 30, // 100 GiB
+		OnEstimate: func(ctx context.Context, e bigquery.QueryEstimate) error {
+			fmt.Printf("query will process %d bytes\n", e.TotalBytesProcessed)
+			return nil
+		},
+	})
+	_, err = client.Query("select name, num from t1").Read(ctx)
+	if _, ok := err.(*bigquery.QueryCostError); ok {
+		// TODO: Handle a query that is too expensive to run.
+	}
+	if err != nil {
+		// TODO: Handle error.
+	}
+	// TODO: Iterate over the results.
+}
+
+func ExampleRenderQueryPlan() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	job, err := client.JobFromID(ctx, "job-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	if qs, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+		fmt.Print(bigquery.RenderQueryPlan(qs))
+	}
+}
+
 func ExampleJob_Config() {
 	ctx := context.Background()
 	client, err := bigquery.NewClient(ctx, "project-id")
@@ -737,6 +824,32 @@ func (i *Item) Save() (map[string]bigquery.Value, string, error) {
 	}, "", nil
 }
 
+func ExampleNewChannelSource() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	items := make(chan *Item)
+	go func() {
+		defer close(items)
+		// TODO: Send the items to load.
+		items <- &Item{Name: "n1", Size: 32.6, Count: 7}
+	}()
+	src := bigquery.NewChannelSource(items)
+	job, err := client.Dataset("my_dataset").Table("my_table").LoaderFrom(src).Run(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	if status.Err() != nil {
+		// TODO: Handle error.
+	}
+}
+
 func ExampleInserter_Put() {
 	ctx := context.Background()
 	client, err := bigquery.NewClient(ctx, "project-id")
@@ -755,6 +868,31 @@ func ExampleInserter_Put() {
 	}
 }
 
+func ExampleBufferedInserter() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	bi := client.Dataset("my_dataset").Table("my_table").BufferedInserter()
+	bi.Settings.DelayThreshold = 5 * time.Second
+	bi.DeadLetter = func(rows []bigquery.FailedRow) {
+		for _, r := range rows {
+			fmt.Fprintf(os.Stderr, "could not insert row %v: %v\n", r.Row, r.Err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		item := &Item{Name: fmt.Sprintf("n%d", i), Size: float64(i), Count: i}
+		if err := bi.Put(ctx, item); err != nil {
+			// TODO: Handle error.
+		}
+	}
+	// Close inserts the rows that are still buffered.
+	if err := bi.Close(); err != nil {
+		// TODO: Handle error.
+	}
+}
+
 var schema bigquery.Schema
 
 func ExampleInserter_Put_structSaver() {
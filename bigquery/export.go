@@ -0,0 +1,358 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+// This file writes the rows of a RowIterator to local files. Values are
+// formatted as BigQuery formats them when it exports tables.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"google.golang.org/api/iterator"
+)
+
+// forEachRow calls f with each row of it, after calling start with the
+// schema of the rows. It returns the first error of it, start or f.
+func forEachRow(it *RowIterator, start func(Schema) error, f func([]Value) error) error {
+	started := false
+	for {
+		var row []Value
+		err := it.Next(&row)
+		if !started && (err == nil || err == iterator.Done) {
+			// The schema is available after the first call to Next, even if
+			// there are no rows.
+			if err := start(it.Schema); err != nil {
+				return err
+			}
+			started = true
+		}
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+}
+
+// WriteCSV writes the rows of it to w as CSV, starting with a header of the
+// column names.
+//
+// Since CSV has no nested values, each field of a RECORD column has its own
+// column, named "record.field". A REPEATED column, including a repeated
+// RECORD, is written in a single column as a JSON array, as WriteJSON
+// writes it. NULL values are written as empty fields. The other values are
+// formatted as in the CSV files that BigQuery exports: BYTES values are
+// base64-encoded, TIMESTAMP values are written like "2006-01-02
+// 15:04:05.999999 UTC", and NUMERIC values are written without trailing
+// zeros.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+func WriteCSV(w io.Writer, it *RowIterator) error {
+	cw := csv.NewWriter(w)
+	var schema Schema
+	err := forEachRow(it, func(s Schema) error {
+		schema = s
+		var header []string
+		csvColumns(schema, "", func(prefix string, fs *FieldSchema) {
+			header = append(header, prefix+fs.Name)
+		})
+		if len(header) == 0 {
+			return nil
+		}
+		return cw.Write(header)
+	}, func(row []Value) error {
+		var rec []string
+		if err := csvFields(&rec, schema, row); err != nil {
+			return err
+		}
+		return cw.Write(rec)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvColumns calls f with each field that has a CSV column, and the prefix of
+// its column name.
+func csvColumns(schema Schema, prefix string, f func(prefix string, fs *FieldSchema)) {
+	for _, fs := range schema {
+		if fs.Type == RecordFieldType && !fs.Repeated {
+			csvColumns(fs.Schema, prefix+fs.Name+".", f)
+			continue
+		}
+		f(prefix, fs)
+	}
+}
+
+// csvFields appends the CSV fields of a row, or of a nested record, to rec. A
+// nil row is a NULL record.
+func csvFields(rec *[]string, schema Schema, row []Value) error {
+	if row != nil && len(row) != len(schema) {
+		return fmt.Errorf("bigquery: row has %d values, but the schema has %d fields", len(row), len(schema))
+	}
+	for i, fs := range schema {
+		var v Value
+		if row != nil {
+			v = row[i]
+		}
+		switch {
+		case fs.Type == RecordFieldType && !fs.Repeated:
+			nested, ok := v.([]Value)
+			if !ok && v != nil {
+				return fmt.Errorf("bigquery: field %s: record has type %T, want []Value", fs.Name, v)
+			}
+			if err := csvFields(rec, fs.Schema, nested); err != nil {
+				return err
+			}
+		case fs.Repeated:
+			var buf bytes.Buffer
+			if err := writeJSONValue(&buf, fs, v); err != nil {
+				return err
+			}
+			*rec = append(*rec, buf.String())
+		case v == nil:
+			*rec = append(*rec, "")
+		default:
+			s, err := formatExportValue(fs, v)
+			if err != nil {
+				return err
+			}
+			*rec = append(*rec, s)
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the rows of it to w as newline-delimited JSON, with an
+// object for each row. The fields of the objects are in the order of the
+// schema, and NULL values are written as null.
+//
+// RECORD values are written as objects, and REPEATED values as arrays.
+// INTEGER, FLOAT and BOOLEAN values are written as JSON numbers and booleans,
+// except for the FLOAT values NaN, Infinity and -Infinity, which JSON
+// cannot represent and are written as strings. The other values are written
+// as strings, formatted as by WriteCSV.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+func WriteJSON(w io.Writer, it *RowIterator) error {
+	bw := bufio.NewWriter(w)
+	var schema Schema
+	var buf bytes.Buffer
+	err := forEachRow(it, func(s Schema) error {
+		schema = s
+		return nil
+	}, func(row []Value) error {
+		buf.Reset()
+		if err := writeJSONRecord(&buf, schema, row); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err := bw.Write(buf.Bytes())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeJSONRecord(buf *bytes.Buffer, schema Schema, row []Value) error {
+	if len(row) != len(schema) {
+		return fmt.Errorf("bigquery: row has %d values, but the schema has %d fields", len(row), len(schema))
+	}
+	buf.WriteByte('{')
+	for i, fs := range schema {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, fs.Name)
+		buf.WriteByte(':')
+		if err := writeJSONValue(buf, fs, row[i]); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// writeJSONValue writes the value v of the field fs, which may be repeated.
+func writeJSONValue(buf *bytes.Buffer, fs *FieldSchema, v Value) error {
+	if v == nil {
+		if fs.Repeated {
+			// The service returns an empty REPEATED field as NULL.
+			buf.WriteString("[]")
+		} else {
+			buf.WriteString("null")
+		}
+		return nil
+	}
+	if fs.Repeated {
+		vals, ok := v.([]Value)
+		if !ok {
+			return fmt.Errorf("bigquery: field %s: repeated value has type %T, want []Value", fs.Name, v)
+		}
+		buf.WriteByte('[')
+		for i, e := range vals {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONScalar(buf, fs, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+	return writeJSONScalar(buf, fs, v)
+}
+
+// writeJSONScalar writes a single value of the field fs, ignoring whether it
+// is repeated.
+func writeJSONScalar(buf *bytes.Buffer, fs *FieldSchema, v Value) error {
+	if v == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	switch fs.Type {
+	case RecordFieldType:
+		row, ok := v.([]Value)
+		if !ok {
+			return fmt.Errorf("bigquery: field %s: record has type %T, want []Value", fs.Name, v)
+		}
+		return writeJSONRecord(buf, fs.Schema, row)
+	case IntegerFieldType, BooleanFieldType:
+		s, err := formatExportValue(fs, v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+		return nil
+	case FloatFieldType:
+		s, err := formatExportValue(fs, v)
+		if err != nil {
+			return err
+		}
+		if f := v.(float64); math.IsNaN(f) || math.IsInf(f, 0) {
+			writeJSONString(buf, s)
+		} else {
+			buf.WriteString(s)
+		}
+		return nil
+	}
+	s, err := formatExportValue(fs, v)
+	if err != nil {
+		return err
+	}
+	writeJSONString(buf, s)
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s) // a string always marshals
+	buf.Write(b)
+}
+
+// exportTimestampFormat is the format of TIMESTAMP values in the files that
+// BigQuery exports.
+const exportTimestampFormat = "2006-01-02 15:04:05.999999 UTC"
+
+// formatExportValue formats a non-NULL value of a field that is not a RECORD,
+// as BigQuery formats it in the files that it exports.
+func formatExportValue(fs *FieldSchema, v Value) (string, error) {
+	ok := true
+	var s string
+	switch fs.Type {
+	case StringFieldType, GeographyFieldType:
+		s, ok = v.(string)
+	case BytesFieldType:
+		var b []byte
+		b, ok = v.([]byte)
+		s = base64.StdEncoding.EncodeToString(b)
+	case IntegerFieldType:
+		var n int64
+		n, ok = v.(int64)
+		s = strconv.FormatInt(n, 10)
+	case FloatFieldType:
+		var f float64
+		f, ok = v.(float64)
+		switch {
+		case math.IsNaN(f):
+			s = "NaN"
+		case math.IsInf(f, 1):
+			s = "Infinity"
+		case math.IsInf(f, -1):
+			s = "-Infinity"
+		default:
+			s = strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case BooleanFieldType:
+		var b bool
+		b, ok = v.(bool)
+		s = strconv.FormatBool(b)
+	case TimestampFieldType:
+		var t time.Time
+		t, ok = v.(time.Time)
+		s = t.UTC().Format(exportTimestampFormat)
+	case DateFieldType:
+		var d civil.Date
+		d, ok = v.(civil.Date)
+		s = d.String()
+	case TimeFieldType:
+		var t civil.Time
+		t, ok = v.(civil.Time)
+		s = CivilTimeString(t)
+	case DateTimeFieldType:
+		var dt civil.DateTime
+		dt, ok = v.(civil.DateTime)
+		s = CivilDateTimeString(dt)
+	case NumericFieldType:
+		var r *big.Rat
+		r, ok = v.(*big.Rat)
+		if ok {
+			s = trimNumeric(NumericString(r))
+		}
+	default:
+		return "", fmt.Errorf("bigquery: field %s has unsupported type %s", fs.Name, fs.Type)
+	}
+	if !ok {
+		return "", fmt.Errorf("bigquery: field %s: %s value has type %T", fs.Name, fs.Type, v)
+	}
+	return s, nil
+}
+
+// trimNumeric removes the trailing zeros of a NUMERIC value formatted by
+// NumericString, and its decimal point if it has no fractional digits.
+func trimNumeric(s string) string {
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
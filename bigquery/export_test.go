@@ -0,0 +1,144 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// valuesIterator returns a RowIterator over rows with the schema.
+func valuesIterator(schema Schema, rows [][]Value) *RowIterator {
+	pf := &pageFetcherStub{
+		fetchResponses: map[string]fetchResponse{
+			"": {result: &fetchPageResult{rows: rows, schema: schema, totalRows: uint64(len(rows))}},
+		},
+	}
+	return newRowIterator(context.Background(), nil, pf.fetchPage)
+}
+
+var (
+	exportSchema = Schema{
+		{Name: "s", Type: StringFieldType},
+		{Name: "b", Type: BytesFieldType},
+		{Name: "i", Type: IntegerFieldType, Required: true},
+		{Name: "f", Type: FloatFieldType},
+		{Name: "ok", Type: BooleanFieldType},
+		{Name: "ts", Type: TimestampFieldType},
+		{Name: "d", Type: DateFieldType},
+		{Name: "t", Type: TimeFieldType},
+		{Name: "dt", Type: DateTimeFieldType},
+		{Name: "n", Type: NumericFieldType},
+		{Name: "tags", Type: StringFieldType, Repeated: true},
+		{Name: "rec", Type: RecordFieldType, Schema: Schema{
+			{Name: "x", Type: IntegerFieldType},
+			{Name: "y", Type: StringFieldType},
+		}},
+		{Name: "recs", Type: RecordFieldType, Repeated: true, Schema: Schema{
+			{Name: "x", Type: IntegerFieldType},
+		}},
+	}
+
+	exportRows = [][]Value{
+		{
+			"a,\"b\"",
+			[]byte{1, 2, 3},
+			int64(7),
+			2.5,
+			true,
+			time.Date(2020, 3, 4, 5, 6, 7, 800000000, time.UTC),
+			civil.Date{Year: 2020, Month: 3, Day: 4},
+			civil.Time{Hour: 5, Minute: 6, Second: 7, Nanosecond: 8000},
+			civil.DateTime{Date: civil.Date{Year: 2020, Month: 3, Day: 4}, Time: civil.Time{Hour: 5}},
+			big.NewRat(-5, 4),
+			[]Value{"x", "y"},
+			[]Value{int64(1), "one"},
+			[]Value{[]Value{int64(2)}, []Value{nil}},
+		},
+		{nil, nil, int64(0), math.Inf(-1), nil, nil, nil, nil, nil, nil, nil, nil, nil},
+	}
+)
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, valuesIterator(exportSchema, exportRows)); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"s,b,i,f,ok,ts,d,t,dt,n,tags,rec.x,rec.y,recs",
+		`"a,""b""",AQID,7,2.5,true,2020-03-04 05:06:07.8 UTC,2020-03-04,05:06:07.000008,2020-03-04 05:00:00,-1.25,"[""x"",""y""]",1,one,"[{""x"":2},{""x"":null}]"`,
+		",,0,-Infinity,,,,,,,[],,,[]",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, valuesIterator(exportSchema, exportRows)); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		`{"s":"a,\"b\"","b":"AQID","i":7,"f":2.5,"ok":true,"ts":"2020-03-04 05:06:07.8 UTC","d":"2020-03-04",` +
+			`"t":"05:06:07.000008","dt":"2020-03-04 05:00:00","n":"-1.25","tags":["x","y"],"rec":{"x":1,"y":"one"},` +
+			`"recs":[{"x":2},{"x":null}]}`,
+		`{"s":null,"b":null,"i":0,"f":"-Infinity","ok":null,"ts":null,"d":null,"t":null,"dt":null,"n":null,` +
+			`"tags":[],"rec":null,"recs":[]}`,
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteCSVNoRows(t *testing.T) {
+	var buf bytes.Buffer
+	schema := Schema{{Name: "a", Type: StringFieldType}, {Name: "b", Type: IntegerFieldType}}
+	if err := WriteCSV(&buf, valuesIterator(schema, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "a,b\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	schema := Schema{{Name: "a", Type: IntegerFieldType}}
+	for _, test := range []struct {
+		desc string
+		it   *RowIterator
+	}{
+		{"wrong type", valuesIterator(schema, [][]Value{{"1"}})},
+		{"wrong length", valuesIterator(schema, [][]Value{{int64(1), int64(2)}})},
+		{"fetch error", newRowIterator(context.Background(), nil, (&pageFetcherStub{
+			fetchResponses: map[string]fetchResponse{"": {err: errors.New("bang")}},
+		}).fetchPage)},
+	} {
+		var buf bytes.Buffer
+		if err := WriteJSON(&buf, test.it); err == nil {
+			t.Errorf("%s: got nil, want error", test.desc)
+		}
+	}
+}
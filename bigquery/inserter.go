@@ -201,27 +201,36 @@ func (u *Inserter) newInsertRequest(savers []ValueSaver) (*bq.TableDataInsertAll
 		SkipInvalidRows:     u.SkipInvalidRows,
 	}
 	for _, saver := range savers {
-		row, insertID, err := saver.Save()
+		row, err := newInsertRow(saver)
 		if err != nil {
 			return nil, err
 		}
-		if insertID == NoDedupeID {
-			insertID = ""
-		} else if insertID == "" {
-			insertID = randomIDFn()
-		}
-		m := make(map[string]bq.JsonValue)
-		for k, v := range row {
-			m[k] = bq.JsonValue(v)
-		}
-		req.Rows = append(req.Rows, &bq.TableDataInsertAllRequestRows{
-			InsertId: insertID,
-			Json:     m,
-		})
+		req.Rows = append(req.Rows, row)
 	}
 	return req, nil
 }
 
+// newInsertRow saves a row for an insertAll request.
+func newInsertRow(saver ValueSaver) (*bq.TableDataInsertAllRequestRows, error) {
+	row, insertID, err := saver.Save()
+	if err != nil {
+		return nil, err
+	}
+	if insertID == NoDedupeID {
+		insertID = ""
+	} else if insertID == "" {
+		insertID = randomIDFn()
+	}
+	m := make(map[string]bq.JsonValue)
+	for k, v := range row {
+		m[k] = bq.JsonValue(v)
+	}
+	return &bq.TableDataInsertAllRequestRows{
+		InsertId: insertID,
+		Json:     m,
+	}, nil
+}
+
 func handleInsertErrors(ierrs []*bq.TableDataInsertAllResponseInsertErrors, rows []*bq.TableDataInsertAllRequestRows) error {
 	if len(ierrs) == 0 {
 		return nil
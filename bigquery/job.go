@@ -273,6 +273,12 @@ func (j *Job) Wait(ctx context.Context) (js *JobStatus, err error) {
 
 // Read fetches the results of a query job.
 // If j is not a query job, Read returns an error.
+//
+// If the client's Storage API is enabled with EnableStorageReadClient, large
+// results are read with it, and the iterator's page token is ignored. The
+// results are read from a single stream, in order, unless the job's last
+// status, as returned by Status or Wait, has a query plan showing that they
+// are unordered; the rows are then returned in no particular order.
 func (j *Job) Read(ctx context.Context) (ri *RowIterator, err error) {
 	ctx = trace.StartSpan(ctx, "cloud.google.com/go/bigquery.Job.Read")
 	defer func() { trace.EndSpan(ctx, err) }()
@@ -298,6 +304,22 @@ func (j *Job) read(ctx context.Context, waitForQuery func(context.Context, strin
 		return nil, errors.New("bigquery: query job missing destination table")
 	}
 	dt := bqToTable(destTable, j.c)
+	if j.c.rc != nil && totalRows >= storageReadMinRows {
+		// Read large results with the Storage API. Ordered results are read
+		// from a single stream to keep their order. Without a query plan in
+		// the last status of the job, the results are assumed to be ordered.
+		var (
+			opts StorageReadOptions
+			qs   *QueryStatistics
+		)
+		if js := j.lastStatus; js != nil && js.Statistics != nil {
+			qs, _ = js.Statistics.Details.(*QueryStatistics)
+		}
+		if qs == nil || hasOrderedOutput(qs) {
+			opts.MaxStreams = 1
+		}
+		pf = newStorageReader(j.c, opts, totalRows).fetch
+	}
 	if totalRows == 0 {
 		pf = nil
 	}
@@ -0,0 +1,161 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/internal"
+	"cloud.google.com/go/internal/trace"
+	gax "github.com/googleapis/gax-go/v2"
+)
+
+// jobProgressBackoff is the backoff between polls of WaitWithProgress. It
+// grows more slowly than that of Wait, so that progress is reported often.
+var jobProgressBackoff = gax.Backoff{
+	Initial:    1 * time.Second,
+	Multiplier: 1.5,
+	Max:        10 * time.Second,
+}
+
+// JobProgress describes the progress of a job, as reported by
+// Job.WaitWithProgress after each poll of the job's status.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+type JobProgress struct {
+	// The status of the job. Status.Statistics holds the job's statistics,
+	// if the service has reported them.
+	Status *JobStatus
+
+	// The time that the job has been running, or has run if it is done. It is
+	// zero if the job has not started.
+	Elapsed time.Duration
+
+	// The number of stages in the plan of a query job, and the number of those
+	// that are complete. The plan is updated while the query runs. Both are
+	// zero for other jobs.
+	Stages          int
+	CompletedStages int
+
+	// The number of bytes processed by the job so far. For a load job, it is
+	// the size of the loaded data (LoadStatistics.OutputBytes).
+	BytesProcessed int64
+
+	// The slot-milliseconds consumed by a query job so far.
+	SlotMillis int64
+
+	// The increase of BytesProcessed and SlotMillis since the previous poll.
+	BytesProcessedDelta int64
+	SlotMillisDelta     int64
+
+	// The samples of the timeline of a query job that are new since the
+	// previous poll.
+	NewTimeline []*QueryTimelineSample
+}
+
+// WaitWithProgress is like Wait, but it polls the status of the job for any
+// kind of job, and calls f with the job's progress after each poll, including
+// the last one, when the job is done.
+//
+// If f returns an error while the job is running, WaitWithProgress cancels
+// the job, and returns the error without waiting for the cancellation to take
+// effect. Use this to stop a job when a condition on its progress is met, such
+// as consuming too many slot-milliseconds. If the job cannot be cancelled,
+// WaitWithProgress returns the error of Cancel instead.
+//
+// As for Wait, callers must check both the returned error and status.Err().
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+func (j *Job) WaitWithProgress(ctx context.Context, f func(*JobProgress) error) (js *JobStatus, err error) {
+	ctx = trace.StartSpan(ctx, "cloud.google.com/go/bigquery.Job.WaitWithProgress")
+	defer func() { trace.EndSpan(ctx, err) }()
+
+	return j.waitWithProgress(ctx, jobProgressBackoff, j.Status, j.Cancel, f)
+}
+
+func (j *Job) waitWithProgress(ctx context.Context, backoff gax.Backoff, status func(context.Context) (*JobStatus, error), cancel func(context.Context) error, f func(*JobProgress) error) (*JobStatus, error) {
+	var (
+		w  progressWatcher
+		js *JobStatus
+	)
+	err := internal.Retry(ctx, backoff, func() (stop bool, err error) {
+		js, err = status(ctx)
+		if err != nil {
+			return true, err
+		}
+		if err := f(w.update(js, time.Now())); err != nil {
+			if !js.Done() {
+				if cerr := cancel(ctx); cerr != nil {
+					return true, cerr
+				}
+			}
+			return true, err
+		}
+		return js.Done(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return js, nil
+}
+
+// progressWatcher computes the progress of a job from its successive
+// statuses.
+type progressWatcher struct {
+	bytesProcessed int64
+	slotMillis     int64
+	elapsed        time.Duration // of the last timeline sample
+}
+
+// update returns the progress of the job with status js, at time now.
+func (w *progressWatcher) update(js *JobStatus, now time.Time) *JobProgress {
+	p := &JobProgress{Status: js}
+	if s := js.Statistics; s != nil {
+		if !s.StartTime.IsZero() {
+			end := s.EndTime
+			if end.IsZero() {
+				end = now
+			}
+			p.Elapsed = end.Sub(s.StartTime)
+		}
+		p.BytesProcessed = s.TotalBytesProcessed
+		switch d := s.Details.(type) {
+		case *QueryStatistics:
+			p.SlotMillis = d.SlotMillis
+			p.Stages = len(d.QueryPlan)
+			for _, st := range d.QueryPlan {
+				if st.Status == "COMPLETE" {
+					p.CompletedStages++
+				}
+			}
+			for _, ts := range d.Timeline {
+				if ts.Elapsed > w.elapsed {
+					p.NewTimeline = append(p.NewTimeline, ts)
+				}
+			}
+			if n := len(p.NewTimeline); n > 0 {
+				w.elapsed = p.NewTimeline[n-1].Elapsed
+			}
+		case *LoadStatistics:
+			p.BytesProcessed = d.OutputBytes
+		}
+	}
+	p.BytesProcessedDelta = p.BytesProcessed - w.bytesProcessed
+	p.SlotMillisDelta = p.SlotMillis - w.slotMillis
+	w.bytesProcessed = p.BytesProcessed
+	w.slotMillis = p.SlotMillis
+	return p
+}
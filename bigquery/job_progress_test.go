@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	gax "github.com/googleapis/gax-go/v2"
+)
+
+var testProgressBackoff = gax.Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+
+// statusStub returns the statuses in order, repeating the last one.
+type statusStub struct {
+	statuses []*JobStatus
+	calls    int
+	cancels  int
+}
+
+func (s *statusStub) status(context.Context) (*JobStatus, error) {
+	js := s.statuses[len(s.statuses)-1]
+	if s.calls < len(s.statuses) {
+		js = s.statuses[s.calls]
+	}
+	s.calls++
+	return js, nil
+}
+
+func (s *statusStub) cancel(context.Context) error {
+	s.cancels++
+	return nil
+}
+
+func queryStatus(state State, bytes, slotMillis int64, stages []string, timeline ...time.Duration) *JobStatus {
+	start := time.Unix(1000, 0)
+	qs := &QueryStatistics{TotalBytesProcessed: bytes, SlotMillis: slotMillis}
+	for _, s := range stages {
+		qs.QueryPlan = append(qs.QueryPlan, &ExplainQueryStage{Status: s})
+	}
+	for _, e := range timeline {
+		qs.Timeline = append(qs.Timeline, &QueryTimelineSample{Elapsed: e})
+	}
+	js := &JobStatus{State: state, Statistics: &JobStatistics{
+		StartTime:           start,
+		TotalBytesProcessed: bytes,
+		Details:             qs,
+	}}
+	if state == Done {
+		js.Statistics.EndTime = start.Add(5 * time.Second)
+	}
+	return js
+}
+
+func TestWaitWithProgress(t *testing.T) {
+	stub := &statusStub{statuses: []*JobStatus{
+		{State: Pending},
+		queryStatus(Running, 100, 10, []string{"COMPLETE", "RUNNING"}, time.Second),
+		queryStatus(Running, 300, 25, []string{"COMPLETE", "RUNNING"}, time.Second, 2*time.Second, 3*time.Second),
+		queryStatus(Done, 300, 40, []string{"COMPLETE", "COMPLETE"}, time.Second, 2*time.Second, 3*time.Second),
+	}}
+	type summary struct {
+		State                     State
+		Stages, CompletedStages   int
+		BytesProcessed, BytesDiff int64
+		SlotMillis, SlotDiff      int64
+		NewTimeline               []time.Duration
+	}
+	var got []summary
+	j := &Job{}
+	js, err := j.waitWithProgress(context.Background(), testProgressBackoff, stub.status, stub.cancel, func(p *JobProgress) error {
+		s := summary{
+			State:           p.Status.State,
+			Stages:          p.Stages,
+			CompletedStages: p.CompletedStages,
+			BytesProcessed:  p.BytesProcessed,
+			BytesDiff:       p.BytesProcessedDelta,
+			SlotMillis:      p.SlotMillis,
+			SlotDiff:        p.SlotMillisDelta,
+		}
+		for _, ts := range p.NewTimeline {
+			s.NewTimeline = append(s.NewTimeline, ts.Elapsed)
+		}
+		if p.Status.Done() && p.Elapsed != 5*time.Second {
+			t.Errorf("Elapsed: got %v, want 5s", p.Elapsed)
+		}
+		got = append(got, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !js.Done() {
+		t.Errorf("got state %v, want Done", js.State)
+	}
+	want := []summary{
+		{State: Pending},
+		{Running, 2, 1, 100, 100, 10, 10, []time.Duration{time.Second}},
+		{Running, 2, 1, 300, 200, 25, 15, []time.Duration{2 * time.Second, 3 * time.Second}},
+		{Done, 2, 2, 300, 0, 40, 15, nil},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+	if stub.cancels != 0 {
+		t.Errorf("job was cancelled %d times", stub.cancels)
+	}
+}
+
+func TestWaitWithProgressLoad(t *testing.T) {
+	stub := &statusStub{statuses: []*JobStatus{
+		{State: Running, Statistics: &JobStatistics{Details: &LoadStatistics{OutputBytes: 10}}},
+		{State: Done, Statistics: &JobStatistics{Details: &LoadStatistics{OutputBytes: 25}}},
+	}}
+	var deltas []int64
+	_, err := (&Job{}).waitWithProgress(context.Background(), testProgressBackoff, stub.status, stub.cancel, func(p *JobProgress) error {
+		deltas = append(deltas, p.BytesProcessedDelta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(deltas, []int64{10, 15}); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestWaitWithProgressCancel(t *testing.T) {
+	stub := &statusStub{statuses: []*JobStatus{
+		queryStatus(Running, 0, 100, nil),
+		queryStatus(Running, 0, 2000, nil),
+		queryStatus(Done, 0, 3000, nil),
+	}}
+	tooExpensive := errors.New("too expensive")
+	_, err := (&Job{}).waitWithProgress(context.Background(), testProgressBackoff, stub.status, stub.cancel, func(p *JobProgress) error {
+		if p.SlotMillis > 1000 {
+			return tooExpensive
+		}
+		return nil
+	})
+	if err != tooExpensive {
+		t.Errorf("got %v, want %v", err, tooExpensive)
+	}
+	if stub.cancels != 1 || stub.calls != 2 {
+		t.Errorf("got %d cancels after %d polls, want 1 after 2", stub.cancels, stub.calls)
+	}
+
+	// A job that is done is not cancelled.
+	stub = &statusStub{statuses: []*JobStatus{queryStatus(Done, 0, 3000, nil)}}
+	_, err = (&Job{}).waitWithProgress(context.Background(), testProgressBackoff, stub.status, stub.cancel, func(p *JobProgress) error {
+		return tooExpensive
+	})
+	if err != tooExpensive || stub.cancels != 0 {
+		t.Errorf("got %v and %d cancels, want %v and none", err, stub.cancels, tooExpensive)
+	}
+}
+
+func TestWaitWithProgressStatusError(t *testing.T) {
+	bang := errors.New("bang")
+	status := func(context.Context) (*JobStatus, error) { return nil, bang }
+	_, err := (&Job{}).waitWithProgress(context.Background(), testProgressBackoff, status, nil, func(*JobProgress) error {
+		t.Error("f was called")
+		return nil
+	})
+	if err != bang {
+		t.Errorf("got %v, want %v", err, bang)
+	}
+}
@@ -119,8 +119,9 @@ type Loader struct {
 // A LoadSource represents a source of data that can be loaded into
 // a BigQuery table.
 //
-// This package defines two LoadSources: GCSReference, for Google Cloud Storage
-// objects, and ReaderSource, for data read from an io.Reader.
+// This package defines three LoadSources: GCSReference, for Google Cloud Storage
+// objects, ReaderSource, for data read from an io.Reader, and ValueSource, for
+// Go values.
 type LoadSource interface {
 	// populates config, returns media
 	populateLoadConfig(*bq.JobConfigurationLoad) io.Reader
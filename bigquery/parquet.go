@@ -0,0 +1,711 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+// This file writes rows as a Parquet file. Only what is needed to write
+// BigQuery rows is supported: values are PLAIN-encoded and uncompressed, each
+// column chunk has a single data page, and levels are RLE-encoded. The file
+// metadata is encoded with the Thrift compact protocol, which is implemented
+// here for the structures of the Parquet format.
+//
+// See https://github.com/apache/parquet-format for the format.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// parquetRowGroupBytes is the number of bytes of encoded values after which
+// the buffered rows are written as a row group.
+const parquetRowGroupBytes = 32 << 20
+
+// Parquet physical types.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+	parquetFixed     = 7
+)
+
+// Parquet converted types.
+const (
+	parquetUTF8            = 0
+	parquetDecimal         = 5
+	parquetDate            = 6
+	parquetTimeMicros      = 8
+	parquetTimestampMicros = 10
+)
+
+// Parquet field repetition types.
+const (
+	parquetRequired = 0
+	parquetOptional = 1
+	parquetRepeated = 2
+)
+
+// Parquet encodings.
+const (
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// parquetNumericBytes is the size of the FIXED_LEN_BYTE_ARRAY of a NUMERIC
+// value, which is enough for 38 decimal digits.
+const parquetNumericBytes = 16
+
+// WriteParquet writes the rows of it to w as a Parquet file.
+//
+// RECORD columns are written as groups, and REPEATED columns as repeated
+// fields. NULLABLE columns are optional, and REQUIRED columns are required.
+// The columns have these types:
+//
+//	STRING, GEOGRAPHY   BYTE_ARRAY (UTF8)
+//	BYTES               BYTE_ARRAY
+//	INTEGER             INT64
+//	FLOAT               DOUBLE
+//	BOOLEAN             BOOLEAN
+//	TIMESTAMP           INT64 (TIMESTAMP_MICROS, adjusted to UTC)
+//	DATE                INT32 (DATE)
+//	TIME                INT64 (TIME_MICROS)
+//	DATETIME            INT64 (TIMESTAMP_MICROS, not adjusted to UTC)
+//	NUMERIC             FIXED_LEN_BYTE_ARRAY(16) (DECIMAL(38, 9))
+//
+// The rows are buffered in memory, and written in row groups of about 32 MB
+// of values. The file is not compressed.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+func WriteParquet(w io.Writer, it *RowIterator) error {
+	var pw *parquetWriter
+	err := forEachRow(it, func(s Schema) error {
+		pw = newParquetWriter(w, s)
+		return pw.start()
+	}, func(row []Value) error {
+		return pw.write(row)
+	})
+	if err != nil {
+		return err
+	}
+	return pw.close()
+}
+
+// parquetNode is a field of the schema of a Parquet file.
+type parquetNode struct {
+	fs       *FieldSchema
+	rep      int // the repetition level of the field's values
+	children []*parquetNode
+	col      *parquetColumn // for a leaf field
+}
+
+// parquetColumn holds the buffered values of a leaf field.
+type parquetColumn struct {
+	fs             *FieldSchema
+	path           []string
+	physical       int32
+	maxDef, maxRep int
+	defs, reps     []int
+	values         bytes.Buffer
+	bools          []bool // the values of a BOOLEAN column
+}
+
+type parquetChunk struct {
+	col       *parquetColumn
+	numValues int
+	offset    int64 // the offset of the data page
+	size      int64 // the size of the data page, with its header
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int64
+}
+
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	schema    Schema
+	roots     []*parquetNode
+	cols      []*parquetColumn
+	rows      int64 // the number of buffered rows
+	rowGroups []parquetRowGroup
+}
+
+func newParquetWriter(w io.Writer, schema Schema) *parquetWriter {
+	pw := &parquetWriter{w: w, schema: schema}
+	pw.roots = pw.nodes(schema, nil, 0, 0)
+	return pw
+}
+
+// nodes returns the nodes of the fields of s, whose parent has the path,
+// definition level def and repetition level rep.
+func (pw *parquetWriter) nodes(s Schema, path []string, def, rep int) []*parquetNode {
+	var nodes []*parquetNode
+	for _, fs := range s {
+		n := &parquetNode{fs: fs, rep: rep}
+		d := def
+		switch {
+		case fs.Repeated:
+			d++
+			n.rep++
+		case !fs.Required:
+			d++
+		}
+		p := append(append([]string(nil), path...), fs.Name)
+		if fs.Type == RecordFieldType {
+			n.children = pw.nodes(fs.Schema, p, d, n.rep)
+		} else {
+			n.col = &parquetColumn{fs: fs, path: p, physical: parquetPhysicalType(fs.Type), maxDef: d, maxRep: n.rep}
+			pw.cols = append(pw.cols, n.col)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func parquetPhysicalType(t FieldType) int32 {
+	switch t {
+	case IntegerFieldType, TimestampFieldType, TimeFieldType, DateTimeFieldType:
+		return parquetInt64
+	case FloatFieldType:
+		return parquetDouble
+	case BooleanFieldType:
+		return parquetBoolean
+	case DateFieldType:
+		return parquetInt32
+	case NumericFieldType:
+		return parquetFixed
+	default:
+		return parquetByteArray
+	}
+}
+
+func (pw *parquetWriter) writeBytes(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+func (pw *parquetWriter) start() error {
+	return pw.writeBytes([]byte("PAR1"))
+}
+
+// write buffers a row, and writes the buffered rows as a row group if they
+// are large enough.
+func (pw *parquetWriter) write(row []Value) error {
+	if err := pw.shredRecord(pw.roots, row, 0, 0); err != nil {
+		return err
+	}
+	pw.rows++
+	size := 0
+	for _, c := range pw.cols {
+		size += c.values.Len() + len(c.bools)/8
+	}
+	if size >= parquetRowGroupBytes {
+		return pw.flush()
+	}
+	return nil
+}
+
+// shredRecord splits the values of a record into its columns, with the
+// given repetition and definition levels.
+func (pw *parquetWriter) shredRecord(nodes []*parquetNode, row []Value, r, d int) error {
+	if len(row) != len(nodes) {
+		return fmt.Errorf("bigquery: row has %d values, but the schema has %d fields", len(row), len(nodes))
+	}
+	for i, n := range nodes {
+		if err := pw.shredField(n, row[i], r, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pw *parquetWriter) shredField(n *parquetNode, v Value, r, d int) error {
+	fs := n.fs
+	switch {
+	case fs.Repeated:
+		var vals []Value
+		if v != nil {
+			var ok bool
+			if vals, ok = v.([]Value); !ok {
+				return fmt.Errorf("bigquery: field %s: repeated value has type %T, want []Value", fs.Name, v)
+			}
+		}
+		if len(vals) == 0 {
+			n.addNull(r, d)
+			return nil
+		}
+		for i, e := range vals {
+			if e == nil {
+				return fmt.Errorf("bigquery: field %s: repeated value has a NULL element", fs.Name)
+			}
+			ri := r
+			if i > 0 {
+				ri = n.rep
+			}
+			if err := pw.shredValue(n, e, ri, d+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case !fs.Required:
+		if v == nil {
+			n.addNull(r, d)
+			return nil
+		}
+		return pw.shredValue(n, v, r, d+1)
+	default:
+		if v == nil {
+			return fmt.Errorf("bigquery: field %s: required value is NULL", fs.Name)
+		}
+		return pw.shredValue(n, v, r, d)
+	}
+}
+
+func (pw *parquetWriter) shredValue(n *parquetNode, v Value, r, d int) error {
+	if n.col == nil {
+		rec, ok := v.([]Value)
+		if !ok {
+			return fmt.Errorf("bigquery: field %s: record has type %T, want []Value", n.fs.Name, v)
+		}
+		return pw.shredRecord(n.children, rec, r, d)
+	}
+	if err := n.col.add(v); err != nil {
+		return err
+	}
+	n.col.reps = append(n.col.reps, r)
+	n.col.defs = append(n.col.defs, d)
+	return nil
+}
+
+// addNull adds a NULL, or a missing repeated value, to each column of the
+// field.
+func (n *parquetNode) addNull(r, d int) {
+	if n.col != nil {
+		n.col.reps = append(n.col.reps, r)
+		n.col.defs = append(n.col.defs, d)
+		return
+	}
+	for _, c := range n.children {
+		c.addNull(r, d)
+	}
+}
+
+// add encodes a non-NULL value of the column.
+func (c *parquetColumn) add(v Value) error {
+	var buf [8]byte
+	ok := true
+	switch c.fs.Type {
+	case StringFieldType, GeographyFieldType:
+		var s string
+		if s, ok = v.(string); ok {
+			binary.LittleEndian.PutUint32(buf[:4], uint32(len(s)))
+			c.values.Write(buf[:4])
+			c.values.WriteString(s)
+		}
+	case BytesFieldType:
+		var b []byte
+		if b, ok = v.([]byte); ok {
+			binary.LittleEndian.PutUint32(buf[:4], uint32(len(b)))
+			c.values.Write(buf[:4])
+			c.values.Write(b)
+		}
+	case BooleanFieldType:
+		var b bool
+		if b, ok = v.(bool); ok {
+			c.bools = append(c.bools, b)
+		}
+	case DateFieldType:
+		var d civil.Date
+		if d, ok = v.(civil.Date); ok {
+			binary.LittleEndian.PutUint32(buf[:4], uint32(dateDays(d)))
+			c.values.Write(buf[:4])
+		}
+	case NumericFieldType:
+		var r *big.Rat
+		if r, ok = v.(*big.Rat); ok {
+			b := ratToDecimal(r, NumericScaleDigits)
+			if len(b) > parquetNumericBytes {
+				return fmt.Errorf("bigquery: field %s: NUMERIC value %s is too large", c.fs.Name, NumericString(r))
+			}
+			// Sign-extend the two's-complement value.
+			pad := byte(0)
+			if b[0]&0x80 != 0 {
+				pad = 0xff
+			}
+			for i := len(b); i < parquetNumericBytes; i++ {
+				c.values.WriteByte(pad)
+			}
+			c.values.Write(b)
+		}
+	default:
+		var n int64
+		switch c.fs.Type {
+		case IntegerFieldType:
+			n, ok = v.(int64)
+		case FloatFieldType:
+			var f float64
+			f, ok = v.(float64)
+			n = int64(math.Float64bits(f))
+		case TimestampFieldType:
+			var t time.Time
+			t, ok = v.(time.Time)
+			n = timestampMicros(t)
+		case TimeFieldType:
+			var t civil.Time
+			t, ok = v.(civil.Time)
+			n = timeMicros(t)
+		case DateTimeFieldType:
+			var dt civil.DateTime
+			dt, ok = v.(civil.DateTime)
+			n = timestampMicros(dt.In(time.UTC))
+		default:
+			return fmt.Errorf("bigquery: field %s has unsupported type %s", c.fs.Name, c.fs.Type)
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(n))
+		c.values.Write(buf[:])
+	}
+	if !ok {
+		return fmt.Errorf("bigquery: field %s: %s value has type %T", c.fs.Name, c.fs.Type, v)
+	}
+	return nil
+}
+
+// timestampMicros returns the number of microseconds from the Unix epoch to t.
+func timestampMicros(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+// dateDays returns the number of days from the Unix epoch to d.
+func dateDays(d civil.Date) int64 {
+	return d.In(time.UTC).Unix() / (24 * 60 * 60)
+}
+
+// timeMicros returns the number of microseconds from midnight to t, rounded
+// to the nearest microsecond.
+func timeMicros(t civil.Time) int64 {
+	secs := int64(t.Hour*60*60 + t.Minute*60 + t.Second)
+	return secs*1e6 + int64(t.Nanosecond+500)/1e3
+}
+
+// flush writes the buffered rows as a row group.
+func (pw *parquetWriter) flush() error {
+	if pw.rows == 0 {
+		return nil
+	}
+	rg := parquetRowGroup{numRows: pw.rows}
+	for _, c := range pw.cols {
+		var page bytes.Buffer
+		if c.maxRep > 0 {
+			writeParquetLevels(&page, c.reps, c.maxRep)
+		}
+		if c.maxDef > 0 {
+			writeParquetLevels(&page, c.defs, c.maxDef)
+		}
+		if c.physical == parquetBoolean {
+			packed := make([]byte, (len(c.bools)+7)/8)
+			for i, b := range c.bools {
+				if b {
+					packed[i/8] |= 1 << uint(i%8)
+				}
+			}
+			page.Write(packed)
+		} else {
+			page.Write(c.values.Bytes())
+		}
+
+		var t thriftWriter
+		t.begin()
+		t.fieldI32(1, 0) // DATA_PAGE
+		t.fieldI32(2, int32(page.Len()))
+		t.fieldI32(3, int32(page.Len()))
+		t.fieldStruct(5, func() {
+			t.fieldI32(1, int32(len(c.defs)))
+			t.fieldI32(2, parquetPlain)
+			t.fieldI32(3, parquetRLE)
+			t.fieldI32(4, parquetRLE)
+		})
+		t.end()
+
+		chunk := parquetChunk{col: c, numValues: len(c.defs), offset: pw.offset}
+		if err := pw.writeBytes(t.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.writeBytes(page.Bytes()); err != nil {
+			return err
+		}
+		chunk.size = pw.offset - chunk.offset
+		rg.chunks = append(rg.chunks, chunk)
+
+		c.reps, c.defs, c.bools = c.reps[:0], c.defs[:0], c.bools[:0]
+		c.values.Reset()
+	}
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.rows = 0
+	return nil
+}
+
+// writeParquetLevels writes repetition or definition levels with the RLE
+// encoding, preceded by their length.
+func writeParquetLevels(b *bytes.Buffer, levels []int, max int) {
+	width := (bits.Len(uint(max)) + 7) / 8
+	var enc bytes.Buffer
+	var vbuf [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		// An RLE run: its length shifted left by one, then its value.
+		enc.Write(vbuf[:binary.PutUvarint(vbuf[:], uint64(j-i)<<1)])
+		for k := 0; k < width; k++ {
+			enc.WriteByte(byte(levels[i] >> uint(8*k)))
+		}
+		i = j
+	}
+	var lbuf [4]byte
+	binary.LittleEndian.PutUint32(lbuf[:], uint32(enc.Len()))
+	b.Write(lbuf[:])
+	enc.WriteTo(b)
+}
+
+// close writes the remaining rows and the file metadata.
+func (pw *parquetWriter) close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	var numRows int64
+	for _, rg := range pw.rowGroups {
+		numRows += rg.numRows
+	}
+
+	var t thriftWriter
+	t.begin()
+	t.fieldI32(1, 1) // version
+	var elems []func()
+	elems = append(elems, func() {
+		t.fieldString(4, "schema")
+		t.fieldI32(5, int32(len(pw.roots)))
+	})
+	var addElems func(nodes []*parquetNode)
+	addElems = func(nodes []*parquetNode) {
+		for _, n := range nodes {
+			n := n
+			elems = append(elems, func() { writeParquetSchemaElement(&t, n) })
+			addElems(n.children)
+		}
+	}
+	addElems(pw.roots)
+	t.fieldList(2, thriftStruct, len(elems))
+	for _, e := range elems {
+		t.begin()
+		e()
+		t.end()
+	}
+	t.fieldI64(3, numRows)
+	t.fieldList(4, thriftStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		t.begin()
+		t.fieldList(1, thriftStruct, len(rg.chunks))
+		var total int64
+		for _, ch := range rg.chunks {
+			total += ch.size
+			t.begin()
+			t.fieldI64(2, ch.offset)
+			t.fieldStruct(3, func() {
+				t.fieldI32(1, ch.col.physical)
+				t.fieldList(2, thriftI32, 2)
+				t.i32(parquetPlain)
+				t.i32(parquetRLE)
+				t.fieldList(3, thriftBinary, len(ch.col.path))
+				for _, p := range ch.col.path {
+					t.binary([]byte(p))
+				}
+				t.fieldI32(4, 0) // UNCOMPRESSED
+				t.fieldI64(5, int64(ch.numValues))
+				t.fieldI64(6, ch.size)
+				t.fieldI64(7, ch.size)
+				t.fieldI64(9, ch.offset)
+			})
+			t.end()
+		}
+		t.fieldI64(2, total)
+		t.fieldI64(3, rg.numRows)
+		t.end()
+	}
+	t.fieldString(6, "cloud.google.com/go/bigquery")
+	t.end()
+
+	if err := pw.writeBytes(t.buf.Bytes()); err != nil {
+		return err
+	}
+	var tail [8]byte
+	binary.LittleEndian.PutUint32(tail[:4], uint32(t.buf.Len()))
+	copy(tail[4:], "PAR1")
+	return pw.writeBytes(tail[:])
+}
+
+// writeParquetSchemaElement writes the fields of the SchemaElement of a node.
+func writeParquetSchemaElement(t *thriftWriter, n *parquetNode) {
+	fs := n.fs
+	if n.col != nil {
+		t.fieldI32(1, n.col.physical)
+		if n.col.physical == parquetFixed {
+			t.fieldI32(2, parquetNumericBytes)
+		}
+	}
+	switch {
+	case fs.Repeated:
+		t.fieldI32(3, parquetRepeated)
+	case fs.Required:
+		t.fieldI32(3, parquetRequired)
+	default:
+		t.fieldI32(3, parquetOptional)
+	}
+	t.fieldString(4, fs.Name)
+	if n.col == nil {
+		t.fieldI32(5, int32(len(n.children)))
+		return
+	}
+	switch fs.Type {
+	case StringFieldType, GeographyFieldType:
+		t.fieldI32(6, parquetUTF8)
+	case TimestampFieldType:
+		t.fieldI32(6, parquetTimestampMicros)
+	case DateFieldType:
+		t.fieldI32(6, parquetDate)
+	case TimeFieldType:
+		t.fieldI32(6, parquetTimeMicros)
+	case NumericFieldType:
+		t.fieldI32(6, parquetDecimal)
+		t.fieldI32(7, NumericScaleDigits)
+		t.fieldI32(8, NumericPrecisionDigits)
+	case DateTimeFieldType:
+		// A local timestamp has a logical type, but no converted type.
+		t.fieldStruct(10, func() {
+			t.fieldStruct(8, func() { // TIMESTAMP
+				t.fieldBool(1, false) // isAdjustedToUTC
+				t.fieldStruct(2, func() {
+					t.fieldStruct(2, func() {}) // MICROS
+				})
+			})
+		})
+	}
+}
+
+// Thrift compact protocol types.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes values with the Thrift compact protocol.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // the ID of the last field of each open struct
+}
+
+// begin starts a struct.
+func (t *thriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+// end ends a struct.
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) varint(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func (t *thriftWriter) i32(n int32) { t.varint(int64(n)) }
+
+func (t *thriftWriter) binary(b []byte) {
+	var vb [binary.MaxVarintLen64]byte
+	t.buf.Write(vb[:binary.PutUvarint(vb[:], uint64(len(b)))])
+	t.buf.Write(b)
+}
+
+func (t *thriftWriter) fieldI32(id int16, n int32) {
+	t.field(id, thriftI32)
+	t.i32(n)
+}
+
+func (t *thriftWriter) fieldI64(id int16, n int64) {
+	t.field(id, thriftI64)
+	t.varint(n)
+}
+
+func (t *thriftWriter) fieldBool(id int16, b bool) {
+	if b {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) fieldString(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary([]byte(s))
+}
+
+// fieldStruct writes a struct field, whose fields are written by f.
+func (t *thriftWriter) fieldStruct(id int16, f func()) {
+	t.field(id, thriftStruct)
+	t.begin()
+	f()
+	t.end()
+}
+
+// fieldList writes the header of a list field with n elements, which must be
+// written next. Struct elements are written between begin and end.
+func (t *thriftWriter) fieldList(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	var vb [binary.MaxVarintLen64]byte
+	t.buf.Write(vb[:binary.PutUvarint(vb[:], uint64(n))])
+}
@@ -0,0 +1,405 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+	"github.com/google/go-cmp/cmp"
+)
+
+// thriftReader decodes Thrift compact protocol structs generically: a struct
+// is a map from field IDs to values, which are int64, bool, []byte,
+// []interface{} or nested structs.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	c := r.b[r.pos]
+	r.pos++
+	return c
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	n, k := binary.Uvarint(r.b[r.pos:])
+	r.pos += k
+	return n
+}
+
+func (r *thriftReader) varint() int64 {
+	n, k := binary.Varint(r.b[r.pos:])
+	r.pos += k
+	return n
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	m := map[int16]interface{}{}
+	var id int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return m
+		}
+		if d := int16(h >> 4); d != 0 {
+			id += d
+		} else {
+			id = int16(r.varint())
+		}
+		m[id] = r.value(h & 0xf)
+	}
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		b := r.b[r.pos : r.pos+n]
+		r.pos += n
+		return b
+	case thriftList:
+		h := r.byte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		l := []interface{}{}
+		for i := 0; i < n; i++ {
+			if h&0xf == thriftTrue {
+				// Booleans in lists are one byte each.
+				l = append(l, r.byte() == 1)
+				continue
+			}
+			l = append(l, r.value(h&0xf))
+		}
+		return l
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic(fmt.Sprintf("unknown Thrift type %d", typ))
+}
+
+// parquetTestColumn is a decoded column chunk.
+type parquetTestColumn struct {
+	reps, defs []int
+	values     []interface{}
+}
+
+// readParquetFile decodes a Parquet file written by WriteParquet, returning
+// its metadata and its columns by dotted path, with the values of each row
+// group appended.
+func readParquetFile(t *testing.T, b []byte) (map[int16]interface{}, map[string]*parquetTestColumn) {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte("PAR1")) || !bytes.HasSuffix(b, []byte("PAR1")) {
+		t.Fatal("missing magic number")
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	r := &thriftReader{b: b[len(b)-8-n : len(b)-8]}
+	md := r.readStruct()
+	if r.pos != n {
+		t.Fatalf("read %d bytes of metadata, want %d", r.pos, n)
+	}
+
+	// Find the maximum levels of the columns from the schema.
+	type levels struct{ def, rep int }
+	maxLevels := map[string]levels{}
+	elems := md[2].([]interface{})
+	var walk func(i int, prefix string, l levels) int
+	walk = func(i int, prefix string, l levels) int {
+		e := elems[i].(map[int16]interface{})
+		i++
+		switch e[3].(int64) {
+		case parquetOptional:
+			l.def++
+		case parquetRepeated:
+			l.def++
+			l.rep++
+		}
+		path := prefix + string(e[4].([]byte))
+		if c, ok := e[5]; ok {
+			for k := 0; k < int(c.(int64)); k++ {
+				i = walk(i, path+".", l)
+			}
+		} else {
+			maxLevels[path] = l
+		}
+		return i
+	}
+	root := elems[0].(map[int16]interface{})
+	for i, k := 1, 0; k < int(root[5].(int64)); k++ {
+		i = walk(i, "", levels{})
+	}
+
+	cols := map[string]*parquetTestColumn{}
+	for _, rg := range md[4].([]interface{}) {
+		for _, cc := range rg.(map[int16]interface{})[1].([]interface{}) {
+			cmd := cc.(map[int16]interface{})[3].(map[int16]interface{})
+			var path []string
+			for _, p := range cmd[3].([]interface{}) {
+				path = append(path, string(p.([]byte)))
+			}
+			name := strings.Join(path, ".")
+			col := cols[name]
+			if col == nil {
+				col = &parquetTestColumn{}
+				cols[name] = col
+			}
+			off := int(cmd[9].(int64))
+			pr := &thriftReader{b: b, pos: off}
+			ph := pr.readStruct()
+			page := b[pr.pos : pr.pos+int(ph[3].(int64))]
+			numValues := int(ph[5].(map[int16]interface{})[1].(int64))
+			if got, want := pr.pos+len(page)-off, int(cmd[7].(int64)); got != want {
+				t.Fatalf("%s: chunk has %d bytes, metadata says %d", name, got, want)
+			}
+			ml := maxLevels[name]
+			reps, page := readParquetLevels(t, page, numValues, ml.rep)
+			defs, page := readParquetLevels(t, page, numValues, ml.def)
+			col.reps = append(col.reps, reps...)
+			col.defs = append(col.defs, defs...)
+			for i, d := range defs {
+				if d < ml.def {
+					continue
+				}
+				var v interface{}
+				switch cmd[1].(int64) {
+				case parquetBoolean:
+					v = page[i/8]>>uint(i%8)&1 == 1
+				case parquetInt32:
+					v = int32(binary.LittleEndian.Uint32(page))
+					page = page[4:]
+				case parquetInt64:
+					v = int64(binary.LittleEndian.Uint64(page))
+					page = page[8:]
+				case parquetDouble:
+					v = math.Float64frombits(binary.LittleEndian.Uint64(page))
+					page = page[8:]
+				case parquetByteArray:
+					n := int(binary.LittleEndian.Uint32(page))
+					v = string(page[4 : 4+n])
+					page = page[4+n:]
+				case parquetFixed:
+					v = decimalToRat(page[:parquetNumericBytes], NumericScaleDigits)
+					page = page[parquetNumericBytes:]
+				}
+				col.values = append(col.values, v)
+			}
+		}
+	}
+	return md, cols
+}
+
+// readParquetLevels decodes n RLE-encoded levels from the start of b, and
+// returns them with the rest of b.
+func readParquetLevels(t *testing.T, b []byte, n, max int) ([]int, []byte) {
+	t.Helper()
+	levels := make([]int, 0, n)
+	if max == 0 {
+		for i := 0; i < n; i++ {
+			levels = append(levels, 0)
+		}
+		return levels, b
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	enc, rest := b[4:4+size], b[4+size:]
+	width := (bits.Len(uint(max)) + 7) / 8
+	for len(enc) > 0 {
+		h, k := binary.Uvarint(enc)
+		enc = enc[k:]
+		if h&1 != 0 {
+			t.Fatal("unexpected bit-packed run")
+		}
+		v := 0
+		for i := 0; i < width; i++ {
+			v |= int(enc[i]) << uint(8*i)
+		}
+		enc = enc[width:]
+		for i := 0; i < int(h>>1); i++ {
+			levels = append(levels, v)
+		}
+	}
+	if len(levels) != n {
+		t.Fatalf("got %d levels, want %d", len(levels), n)
+	}
+	return levels, rest
+}
+
+func TestWriteParquet(t *testing.T) {
+	schema := Schema{
+		{Name: "id", Type: IntegerFieldType, Required: true},
+		{Name: "name", Type: StringFieldType},
+		{Name: "links", Type: RecordFieldType, Repeated: true, Schema: Schema{
+			{Name: "url", Type: StringFieldType, Required: true},
+			{Name: "codes", Type: IntegerFieldType, Repeated: true},
+		}},
+	}
+	rows := [][]Value{
+		{int64(1), "a", []Value{
+			[]Value{"u1", []Value{int64(10), int64(11)}},
+			[]Value{"u2", nil},
+		}},
+		{int64(2), nil, nil},
+		{int64(3), "c", []Value{
+			[]Value{"u3", []Value{int64(30)}},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, valuesIterator(schema, rows)); err != nil {
+		t.Fatal(err)
+	}
+	md, cols := readParquetFile(t, buf.Bytes())
+	if got := md[3].(int64); got != 3 {
+		t.Errorf("num_rows: got %d, want 3", got)
+	}
+	want := map[string]*parquetTestColumn{
+		"id": {
+			reps:   []int{0, 0, 0},
+			defs:   []int{0, 0, 0},
+			values: []interface{}{int64(1), int64(2), int64(3)},
+		},
+		"name": {
+			reps:   []int{0, 0, 0},
+			defs:   []int{1, 0, 1},
+			values: []interface{}{"a", "c"},
+		},
+		"links.url": {
+			reps:   []int{0, 1, 0, 0},
+			defs:   []int{1, 1, 0, 1},
+			values: []interface{}{"u1", "u2", "u3"},
+		},
+		"links.codes": {
+			reps:   []int{0, 2, 1, 0, 0},
+			defs:   []int{2, 2, 1, 0, 2},
+			values: []interface{}{int64(10), int64(11), int64(30)},
+		},
+	}
+	if diff := testutil.Diff(cols, want, cmp.AllowUnexported(parquetTestColumn{})); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestWriteParquetTypes(t *testing.T) {
+	schema := Schema{
+		{Name: "b", Type: BytesFieldType},
+		{Name: "f", Type: FloatFieldType},
+		{Name: "ok", Type: BooleanFieldType, Repeated: true},
+		{Name: "ts", Type: TimestampFieldType},
+		{Name: "d", Type: DateFieldType},
+		{Name: "t", Type: TimeFieldType},
+		{Name: "dt", Type: DateTimeFieldType},
+		{Name: "n", Type: NumericFieldType},
+	}
+	rows := [][]Value{
+		{
+			[]byte("xy"),
+			1.5,
+			[]Value{true, false, true},
+			time.Date(2020, 1, 2, 0, 0, 1, 2000, time.UTC),
+			civil.Date{Year: 1970, Month: 1, Day: 3},
+			civil.Time{Hour: 1, Second: 2, Nanosecond: 3000},
+			civil.DateTime{Date: civil.Date{Year: 1970, Month: 1, Day: 2}, Time: civil.Time{Second: 1}},
+			big.NewRat(-123456789, 1000),
+		},
+		{nil, nil, nil, nil, nil, nil, nil, big.NewRat(1, 2)},
+	}
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, valuesIterator(schema, rows)); err != nil {
+		t.Fatal(err)
+	}
+	md, cols := readParquetFile(t, buf.Bytes())
+	got := map[string][]interface{}{}
+	for name, c := range cols {
+		got[name] = c.values
+	}
+	want := map[string][]interface{}{
+		"b":  {"xy"},
+		"f":  {1.5},
+		"ok": {true, false, true},
+		"ts": {int64(1577923201000002)},
+		"d":  {int32(2)},
+		"t":  {int64(3602000003)},
+		"dt": {int64(86401000000)},
+		"n":  {big.NewRat(-123456789, 1000), big.NewRat(1, 2)},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+
+	// Check the annotations of the columns.
+	elems := md[2].([]interface{})
+	n := elems[8].(map[int16]interface{})
+	if n[1] != int64(parquetFixed) || n[2] != int64(16) || n[6] != int64(parquetDecimal) ||
+		n[7] != int64(NumericScaleDigits) || n[8] != int64(NumericPrecisionDigits) {
+		t.Errorf("NUMERIC schema element: %v", n)
+	}
+	dt := elems[7].(map[int16]interface{})
+	ts := dt[10].(map[int16]interface{})[8].(map[int16]interface{})
+	if ts[1] != false {
+		t.Errorf("DATETIME is adjusted to UTC: %v", dt)
+	}
+}
+
+func TestWriteParquetRowGroups(t *testing.T) {
+	schema := Schema{{Name: "s", Type: StringFieldType, Required: true}}
+	long := strings.Repeat("x", parquetRowGroupBytes/2)
+	rows := [][]Value{{long}, {long}, {"a"}}
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, valuesIterator(schema, rows)); err != nil {
+		t.Fatal(err)
+	}
+	md, cols := readParquetFile(t, buf.Bytes())
+	if got := len(md[4].([]interface{})); got != 2 {
+		t.Errorf("got %d row groups, want 2", got)
+	}
+	if got := len(cols["s"].values); got != 3 {
+		t.Errorf("got %d values, want 3", got)
+	}
+}
+
+func TestWriteParquetErrors(t *testing.T) {
+	for _, test := range []struct {
+		desc   string
+		schema Schema
+		row    []Value
+	}{
+		{"required NULL", Schema{{Name: "a", Type: IntegerFieldType, Required: true}}, []Value{nil}},
+		{"NULL element", Schema{{Name: "a", Type: IntegerFieldType, Repeated: true}}, []Value{[]Value{nil}}},
+		{"wrong type", Schema{{Name: "a", Type: IntegerFieldType}}, []Value{"1"}},
+		{"NUMERIC too large", Schema{{Name: "a", Type: NumericFieldType}},
+			[]Value{new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), 128))}},
+	} {
+		var buf bytes.Buffer
+		if err := WriteParquet(&buf, valuesIterator(test.schema, [][]Value{test.row})); err == nil {
+			t.Errorf("%s: got nil, want error", test.desc)
+		}
+	}
+}
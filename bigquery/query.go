@@ -296,17 +296,34 @@ func (c *Client) Query(q string) *Query {
 	}
 }
 
-// Run initiates a query job.
+// Run initiates a query job. If the client has a QueryCostPolicy, the query
+// is first checked against it with a dry run.
 func (q *Query) Run(ctx context.Context) (j *Job, err error) {
 	ctx = trace.StartSpan(ctx, "cloud.google.com/go/bigquery.Query.Run")
 	defer func() { trace.EndSpan(ctx, err) }()
 
+	g := q.client.cg
+	if g == nil || g.policy == nil || q.DryRun {
+		g = nil
+	}
+	var charged int64
+	if g != nil {
+		charged, err = g.check(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+	}
 	job, err := q.newJob()
-	if err != nil {
-		return nil, err
+	if err == nil && g != nil {
+		g.setMaxBytesBilled(job, q)
+	}
+	if err == nil {
+		j, err = q.client.insertJob(ctx, job, nil)
 	}
-	j, err = q.client.insertJob(ctx, job, nil)
 	if err != nil {
+		if g != nil {
+			g.refund(charged)
+		}
 		return nil, err
 	}
 	return j, nil
@@ -0,0 +1,203 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// A QueryCostPolicy limits the bytes processed by the queries of a client.
+// When a client has a policy, Query.Run and Query.Read first run each query
+// as a dry run to estimate the bytes that it will process, and return a
+// *QueryCostError without running the query if the estimate is over a limit.
+//
+// Dry runs are free, but each one is an extra request before every query.
+// Queries that are themselves dry runs are not checked.
+type QueryCostPolicy struct {
+	// MaxBytesPerQuery is the maximum estimated number of bytes that a single
+	// query may process. If zero, there is no per-query limit.
+	MaxBytesPerQuery int64
+
+	// MaxBytesPerClient is the maximum number of bytes that all the queries
+	// run by the client may process together. Each query that runs is
+	// charged its estimate, which is not always equal to the bytes that it
+	// actually processes. If zero, there is no client limit.
+	MaxBytesPerClient int64
+
+	// SetMaxBytesBilled makes the policy set the MaxBytesBilled of queries
+	// that don't set it to MaxBytesPerQuery, so that the service also enforces
+	// the limit, against the bytes actually billed. Note that the service
+	// bills at least 10 MB for a query that processes any bytes.
+	SetMaxBytesBilled bool
+
+	// OnEstimate, if non-nil, is called with the estimate of each query
+	// before the limits are checked. If it returns an error, the query is not
+	// run and Query.Run returns the error.
+	OnEstimate func(ctx context.Context, e QueryEstimate) error
+}
+
+// A QueryEstimate is the estimated cost of a query, which is passed to the
+// OnEstimate function of a QueryCostPolicy.
+type QueryEstimate struct {
+	// Query is the query that is about to run.
+	Query *Query
+
+	// TotalBytesProcessed is the estimated number of bytes that the query
+	// will process.
+	TotalBytesProcessed int64
+
+	// Accuracy is the accuracy of the estimate: "UNKNOWN", "PRECISE",
+	// "LOWER_BOUND" or "UPPER_BOUND".
+	Accuracy string
+
+	// ClientBytes is the number of bytes charged to the client by the
+	// queries that it ran before this one.
+	ClientBytes int64
+}
+
+// A QueryCostError is returned by Query.Run and Query.Read when the estimated
+// bytes processed by a query are over a limit of the client's QueryCostPolicy.
+type QueryCostError struct {
+	// TotalBytesProcessed is the estimated number of bytes that the query
+	// would process.
+	TotalBytesProcessed int64
+
+	// Limit is the limit that the query is over.
+	Limit int64
+
+	// PerClient reports whether Limit is the MaxBytesPerClient of the policy,
+	// rather than its MaxBytesPerQuery.
+	PerClient bool
+
+	// ClientBytes is the number of bytes already charged to the client.
+	ClientBytes int64
+}
+
+func (e *QueryCostError) Error() string {
+	if e.PerClient {
+		return fmt.Sprintf("bigquery: query would process an estimated %d bytes, but only %d of the client's %d bytes remain",
+			e.TotalBytesProcessed, e.Limit-e.ClientBytes, e.Limit)
+	}
+	return fmt.Sprintf("bigquery: query would process an estimated %d bytes, over the limit of %d bytes per query",
+		e.TotalBytesProcessed, e.Limit)
+}
+
+// queryCostGuard enforces a QueryCostPolicy for a client.
+type queryCostGuard struct {
+	policy *QueryCostPolicy // nil if the client has no policy
+
+	mu    sync.Mutex
+	bytes int64 // bytes charged to the client
+}
+
+// SetQueryCostPolicy makes the client check the queries that it runs against
+// the policy. A nil policy removes the client's policy. The bytes already
+// charged to the client are kept when the policy is changed.
+//
+// SetQueryCostPolicy must be called before the client is used concurrently.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+func (c *Client) SetQueryCostPolicy(p *QueryCostPolicy) {
+	g := &queryCostGuard{}
+	if p != nil {
+		pc := *p
+		g.policy = &pc
+	}
+	if c.cg != nil {
+		g.bytes = c.cg.charged()
+	}
+	c.cg = g
+}
+
+// QueryBytesCharged returns the number of bytes charged to the client by the
+// queries that it ran under a QueryCostPolicy.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+func (c *Client) QueryBytesCharged() int64 {
+	if c.cg == nil {
+		return 0
+	}
+	return c.cg.charged()
+}
+
+func (g *queryCostGuard) charged() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.bytes
+}
+
+// check estimates the bytes that q will process with a dry run, and checks
+// them against the policy. If the query may run, check charges the estimate
+// to the client and returns it, so that it can be refunded if the query
+// fails to start.
+func (g *queryCostGuard) check(ctx context.Context, q *Query) (int64, error) {
+	dq := *q
+	dq.DryRun = true
+	job, err := dq.newJob()
+	if err != nil {
+		return 0, err
+	}
+	j, err := q.client.insertJob(ctx, job, nil)
+	if err != nil {
+		return 0, err
+	}
+	var est QueryEstimate
+	est.Query = q
+	if s := j.LastStatus().Statistics; s != nil {
+		est.TotalBytesProcessed = s.TotalBytesProcessed
+		if qs, ok := s.Details.(*QueryStatistics); ok {
+			est.TotalBytesProcessed = qs.TotalBytesProcessed
+			est.Accuracy = qs.TotalBytesProcessedAccuracy
+		}
+	}
+	est.ClientBytes = g.charged()
+	if g.policy.OnEstimate != nil {
+		if err := g.policy.OnEstimate(ctx, est); err != nil {
+			return 0, err
+		}
+	}
+	n := est.TotalBytesProcessed
+	if max := g.policy.MaxBytesPerQuery; max > 0 && n > max {
+		return 0, &QueryCostError{TotalBytesProcessed: n, Limit: max, ClientBytes: est.ClientBytes}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if max := g.policy.MaxBytesPerClient; max > 0 && g.bytes+n > max {
+		return 0, &QueryCostError{TotalBytesProcessed: n, Limit: max, PerClient: true, ClientBytes: g.bytes}
+	}
+	g.bytes += n
+	return n, nil
+}
+
+// refund returns bytes charged by check to the client.
+func (g *queryCostGuard) refund(n int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.bytes -= n
+}
+
+// setMaxBytesBilled sets the maximum bytes billed of the query job to the
+// policy's per-query limit, if the policy asks for it and the query doesn't
+// set its own.
+func (g *queryCostGuard) setMaxBytesBilled(job *bq.Job, q *Query) {
+	if !g.policy.SetMaxBytesBilled || g.policy.MaxBytesPerQuery <= 0 || q.MaxBytesBilled != 0 {
+		return
+	}
+	job.Configuration.Query.MaximumBytesBilled = g.policy.MaxBytesPerQuery
+}
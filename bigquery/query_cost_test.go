@@ -0,0 +1,200 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/bigquery/bqtest"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const costTestQuery = "SELECT name FROM d.t"
+
+// newCostTestClient returns a client of a fake server with a table d.t, the
+// estimated bytes processed by costTestQuery, and a function that closes the
+// server.
+func newCostTestClient(t *testing.T) (*Client, int64, func()) {
+	ctx := context.Background()
+	srv := bqtest.NewServer()
+	c, err := NewClient(ctx, "P", srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Dataset("d").Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	tab := c.Dataset("d").Table("t")
+	schema := Schema{{Name: "name", Type: StringFieldType}}
+	if err := tab.Create(ctx, &TableMetadata{Schema: schema}); err != nil {
+		t.Fatal(err)
+	}
+	rows := []*ValuesSaver{
+		{Schema: schema, Row: []Value{"alice"}},
+		{Schema: schema, Row: []Value{"bob"}},
+	}
+	if err := tab.Inserter().Put(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	q := c.Query(costTestQuery)
+	q.DryRun = true
+	job, err := q.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := job.LastStatus().Statistics.TotalBytesProcessed
+	if n <= 0 {
+		t.Fatalf("dry run estimate is %d bytes", n)
+	}
+	return c, n, srv.Close
+}
+
+// countJobs returns the number of jobs of the client's project.
+func countJobs(t *testing.T, c *Client) int {
+	n := 0
+	it := c.Jobs(context.Background())
+	for {
+		_, err := it.Next()
+		if err == iterator.Done {
+			return n
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+}
+
+func TestQueryCostPolicyPerQuery(t *testing.T) {
+	ctx := context.Background()
+	c, n, done := newCostTestClient(t)
+	defer done()
+	c.SetQueryCostPolicy(&QueryCostPolicy{MaxBytesPerQuery: n - 1})
+	_, err := c.Query(costTestQuery).Read(ctx)
+	ce, ok := err.(*QueryCostError)
+	if !ok {
+		t.Fatalf("got error %v, want a *QueryCostError", err)
+	}
+	want := QueryCostError{TotalBytesProcessed: n, Limit: n - 1}
+	if *ce != want {
+		t.Errorf("got %+v, want %+v", *ce, want)
+	}
+	if got := countJobs(t, c); got != 0 {
+		t.Errorf("%d jobs were run, want none", got)
+	}
+
+	// Dry runs are not checked.
+	q := c.Query(costTestQuery)
+	q.DryRun = true
+	if _, err := q.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetQueryCostPolicy(&QueryCostPolicy{MaxBytesPerQuery: n})
+	if _, err := c.Query(costTestQuery).Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.QueryBytesCharged(); got != n {
+		t.Errorf("QueryBytesCharged = %d, want %d", got, n)
+	}
+
+	c.SetQueryCostPolicy(nil)
+	if _, err := c.Query(costTestQuery).Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.QueryBytesCharged(); got != n {
+		t.Errorf("without a policy, QueryBytesCharged = %d, want %d", got, n)
+	}
+}
+
+func TestQueryCostPolicyPerClient(t *testing.T) {
+	ctx := context.Background()
+	c, n, done := newCostTestClient(t)
+	defer done()
+	c.SetQueryCostPolicy(&QueryCostPolicy{MaxBytesPerClient: 2*n + n/2})
+	for i := 0; i < 2; i++ {
+		if _, err := c.Query(costTestQuery).Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := c.Query(costTestQuery).Run(ctx)
+	ce, ok := err.(*QueryCostError)
+	if !ok {
+		t.Fatalf("got error %v, want a *QueryCostError", err)
+	}
+	want := QueryCostError{TotalBytesProcessed: n, Limit: 2*n + n/2, PerClient: true, ClientBytes: 2 * n}
+	if *ce != want {
+		t.Errorf("got %+v, want %+v", *ce, want)
+	}
+	if got := c.QueryBytesCharged(); got != 2*n {
+		t.Errorf("QueryBytesCharged = %d, want %d", got, 2*n)
+	}
+	if got := countJobs(t, c); got != 2 {
+		t.Errorf("%d jobs were run, want 2", got)
+	}
+}
+
+func TestQueryCostPolicyOnEstimate(t *testing.T) {
+	ctx := context.Background()
+	c, n, done := newCostTestClient(t)
+	defer done()
+	var got []QueryEstimate
+	veto := errors.New("veto")
+	c.SetQueryCostPolicy(&QueryCostPolicy{
+		OnEstimate: func(_ context.Context, e QueryEstimate) error {
+			got = append(got, e)
+			if len(got) > 1 {
+				return veto
+			}
+			return nil
+		},
+	})
+	if _, err := c.Query(costTestQuery).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Query(costTestQuery).Run(ctx); err != veto {
+		t.Fatalf("got error %v, want %v", err, veto)
+	}
+	if len(got) != 2 {
+		t.Fatalf("OnEstimate was called %d times, want 2", len(got))
+	}
+	for i, e := range got {
+		if e.Query == nil || e.Query.Q != costTestQuery || e.TotalBytesProcessed != n || e.ClientBytes != int64(i)*n {
+			t.Errorf("estimate %d: got %+v", i, e)
+		}
+	}
+}
+
+func TestQueryCostPolicySetMaxBytesBilled(t *testing.T) {
+	ctx := context.Background()
+	c, n, done := newCostTestClient(t)
+	defer done()
+	c.SetQueryCostPolicy(&QueryCostPolicy{MaxBytesPerQuery: n, SetMaxBytesBilled: true})
+	// The service bills at least 10 MB, which is over the limit.
+	_, err := c.Query(costTestQuery).Read(ctx)
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 400 {
+		t.Fatalf("got error %v, want a 400 error", err)
+	}
+
+	// A query's own MaxBytesBilled is kept.
+	q := c.Query(costTestQuery)
+	q.MaxBytesBilled = 10 << 20
+	if _, err := q.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+	"time"
+)
+
+// RenderQueryPlan returns the query plan and timeline of a completed query
+// job as text, for reading after the fact. Get the statistics from the
+// job's status:
+//
+//	status, err := job.Wait(ctx)
+//	// ...
+//	if qs, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+//		fmt.Print(bigquery.RenderQueryPlan(qs))
+//	}
+//
+// The plan is written as a graph of its stages, starting with the final
+// stage. The stages that a stage reads from are written below it, indented
+// and marked with "<-"; a stage that is read by several stages is written in
+// full only once. Each stage is followed by its record counts, its times
+// relative to the start of the first stage, the ratios of the time spent
+// waiting, reading, computing and writing by its average and slowest shards,
+// and its steps. The timeline samples, if any, follow the plan as a table.
+func RenderQueryPlan(qs *QueryStatistics) string {
+	if qs == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "bytes processed: %s, billed: %s, slot time: %v",
+		formatBytes(qs.TotalBytesProcessed), formatBytes(qs.TotalBytesBilled),
+		time.Duration(qs.SlotMillis)*time.Millisecond)
+	if qs.CacheHit {
+		buf.WriteString(" (cache hit)")
+	}
+	buf.WriteString("\n")
+
+	r := &planRenderer{
+		buf:    &buf,
+		stages: map[int64]*ExplainQueryStage{},
+		done:   map[int64]bool{},
+	}
+	inputs := map[int64]bool{}
+	for _, s := range qs.QueryPlan {
+		r.stages[s.ID] = s
+		for _, id := range s.InputStages {
+			inputs[id] = true
+		}
+		if !s.StartTime.IsZero() && (r.start.IsZero() || s.StartTime.Before(r.start)) {
+			r.start = s.StartTime
+		}
+	}
+	// The final stages are those that no other stage reads from. Render the
+	// latest first, which is where the query's results are written.
+	for i := len(qs.QueryPlan) - 1; i >= 0; i-- {
+		if s := qs.QueryPlan[i]; !inputs[s.ID] {
+			r.render(s, "", "")
+		}
+	}
+	// A cycle of stages, which a well-formed plan never has, leaves stages
+	// that are not reachable from a final stage.
+	for _, s := range qs.QueryPlan {
+		if !r.done[s.ID] {
+			r.render(s, "", "")
+		}
+	}
+
+	if len(qs.Timeline) > 0 {
+		buf.WriteString("timeline:\n")
+		tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  elapsed\tactive\tpending\tcompleted\tslot time")
+		for _, t := range qs.Timeline {
+			fmt.Fprintf(tw, "  %v\t%d\t%d\t%d\t%v\n", t.Elapsed, t.ActiveUnits, t.PendingUnits,
+				t.CompletedUnits, time.Duration(t.SlotMillis)*time.Millisecond)
+		}
+		tw.Flush()
+	}
+	return buf.String()
+}
+
+type planRenderer struct {
+	buf    *bytes.Buffer
+	stages map[int64]*ExplainQueryStage
+	done   map[int64]bool // stages already rendered
+	start  time.Time      // start time of the earliest stage
+}
+
+// render writes stage s. The first line is prefixed by first, and the other
+// lines by indent.
+func (r *planRenderer) render(s *ExplainQueryStage, first, indent string) {
+	name := s.Name
+	if name == "" {
+		name = fmt.Sprintf("stage %d", s.ID)
+	}
+	if r.done[s.ID] {
+		fmt.Fprintf(r.buf, "%s%s (see above)\n", first, name)
+		return
+	}
+	r.done[s.ID] = true
+
+	fmt.Fprintf(r.buf, "%s%s", first, name)
+	if s.Status != "" {
+		fmt.Fprintf(r.buf, " [%s]", s.Status)
+	}
+	r.buf.WriteString("\n")
+	line := func(format string, args ...interface{}) {
+		r.buf.WriteString(indent + "  ")
+		fmt.Fprintf(r.buf, format, args...)
+		r.buf.WriteString("\n")
+	}
+
+	records := fmt.Sprintf("records: %d read, %d written", s.RecordsRead, s.RecordsWritten)
+	if s.ParallelInputs > 0 {
+		records += fmt.Sprintf("; parallel inputs: %d of %d done", s.CompletedParallelInputs, s.ParallelInputs)
+	}
+	if s.ShuffleOutputBytes > 0 {
+		records += "; shuffled: " + formatBytes(s.ShuffleOutputBytes)
+		if s.ShuffleOutputBytesSpilled > 0 {
+			records += fmt.Sprintf(" (%s spilled)", formatBytes(s.ShuffleOutputBytesSpilled))
+		}
+	}
+	line("%s", records)
+	if !s.StartTime.IsZero() && !s.EndTime.IsZero() {
+		line("time: +%v to +%v (%v)", s.StartTime.Sub(r.start), s.EndTime.Sub(r.start), s.EndTime.Sub(s.StartTime))
+	}
+	if s.WaitRatioMax+s.ReadRatioMax+s.ComputeRatioMax+s.WriteRatioMax > 0 {
+		line("avg/max: wait %.2f/%.2f, read %.2f/%.2f, compute %.2f/%.2f, write %.2f/%.2f",
+			s.WaitRatioAvg, s.WaitRatioMax, s.ReadRatioAvg, s.ReadRatioMax,
+			s.ComputeRatioAvg, s.ComputeRatioMax, s.WriteRatioAvg, s.WriteRatioMax)
+	}
+	for _, st := range s.Steps {
+		line("%s", st.Kind)
+		for _, sub := range st.Substeps {
+			line("  %s", sub)
+		}
+	}
+	for _, id := range s.InputStages {
+		in, ok := r.stages[id]
+		if !ok {
+			fmt.Fprintf(r.buf, "%s  <- stage %d (missing)\n", indent, id)
+			continue
+		}
+		r.render(in, indent+"  <- ", indent+"     ")
+	}
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+func formatBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n) / 1024
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", f, units[i])
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderQueryPlan(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	qs := &QueryStatistics{
+		TotalBytesProcessed: 3 << 20,
+		TotalBytesBilled:    10 << 20,
+		SlotMillis:          1500,
+		QueryPlan: []*ExplainQueryStage{
+			{
+				ID:             0,
+				Name:           "S00: Input",
+				Status:         "COMPLETE",
+				RecordsRead:    100,
+				RecordsWritten: 10,
+				ParallelInputs: 2, CompletedParallelInputs: 2,
+				ShuffleOutputBytes: 2048,
+				StartTime:          start,
+				EndTime:            start.Add(300 * time.Millisecond),
+				WaitRatioAvg:       0.1, WaitRatioMax: 0.2,
+				ReadRatioAvg: 0.5, ReadRatioMax: 1,
+				Steps: []*ExplainQueryStep{
+					{Kind: "READ", Substeps: []string{"$1, $2", "FROM d.t"}},
+					{Kind: "WRITE", Substeps: []string{"$1, $2", "TO __stage00_output"}},
+				},
+			},
+			{
+				ID:             1,
+				Name:           "S01: Lookup",
+				Status:         "COMPLETE",
+				RecordsRead:    5,
+				RecordsWritten: 5,
+				StartTime:      start,
+				EndTime:        start.Add(100 * time.Millisecond),
+			},
+			{
+				ID:             2,
+				Name:           "S02: Join",
+				Status:         "COMPLETE",
+				InputStages:    []int64{0, 1},
+				RecordsRead:    15,
+				RecordsWritten: 3,
+				StartTime:      start.Add(300 * time.Millisecond),
+				EndTime:        start.Add(400 * time.Millisecond),
+			},
+			{
+				ID:             3,
+				Name:           "S03: Output",
+				Status:         "COMPLETE",
+				InputStages:    []int64{2, 1, 7},
+				RecordsRead:    3,
+				RecordsWritten: 3,
+			},
+		},
+		Timeline: []*QueryTimelineSample{
+			{Elapsed: 200 * time.Millisecond, ActiveUnits: 3, PendingUnits: 1, CompletedUnits: 2, SlotMillis: 600},
+			{Elapsed: 400 * time.Millisecond, CompletedUnits: 4, SlotMillis: 1500},
+		},
+	}
+	want := strings.Join([]string{
+		`bytes processed: 3.0 MiB, billed: 10.0 MiB, slot time: 1.5s`,
+		`S03: Output [COMPLETE]`,
+		`  records: 3 read, 3 written`,
+		`  <- S02: Join [COMPLETE]`,
+		`       records: 15 read, 3 written`,
+		`       time: +300ms to +400ms (100ms)`,
+		`       <- S00: Input [COMPLETE]`,
+		`            records: 100 read, 10 written; parallel inputs: 2 of 2 done; shuffled: 2.0 KiB`,
+		`            time: +0s to +300ms (300ms)`,
+		`            avg/max: wait 0.10/0.20, read 0.50/1.00, compute 0.00/0.00, write 0.00/0.00`,
+		`            READ`,
+		`              $1, $2`,
+		`              FROM d.t`,
+		`            WRITE`,
+		`              $1, $2`,
+		`              TO __stage00_output`,
+		`       <- S01: Lookup [COMPLETE]`,
+		`            records: 5 read, 5 written`,
+		`            time: +0s to +100ms (100ms)`,
+		`  <- S01: Lookup (see above)`,
+		`  <- stage 7 (missing)`,
+		`timeline:`,
+		`  elapsed  active  pending  completed  slot time`,
+		`  200ms    3       1        2          600ms`,
+		`  400ms    0       0        4          1.5s`,
+		``,
+	}, "\n")
+	if got := RenderQueryPlan(qs); got != want {
+		t.Errorf("RenderQueryPlan mismatch\n got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := RenderQueryPlan(nil); got != "" {
+		t.Errorf("RenderQueryPlan(nil) = %q, want empty", got)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for _, test := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+	} {
+		if got := formatBytes(test.n); got != test.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", test.n, got, test.want)
+		}
+	}
+}
@@ -454,8 +454,8 @@ func hasRecursiveType(t reflect.Type, seen *typeList) (bool, error) {
 // bigQuerySchemaJSONField is an individual field in a JSON BigQuery table schema definition
 // (as generated by https://github.com/GoogleCloudPlatform/protoc-gen-bq-schema).
 type bigQueryJSONField struct {
-	Description string              `json:"description"`
-	Fields      []bigQueryJSONField `json:"fields"`
+	Description string              `json:"description,omitempty"`
+	Fields      []bigQueryJSONField `json:"fields,omitempty"`
 	Mode        string              `json:"mode"`
 	Name        string              `json:"name"`
 	Type        string              `json:"type"`
@@ -509,6 +509,30 @@ func SchemaFromJSON(schemaJSON []byte) (Schema, error) {
 	return convertSchemaFromJSON(bigQuerySchema)
 }
 
+// ToJSONFields returns the schema as a JSON BigQuery table schema definition,
+// the format read by SchemaFromJSON and by the bq command-line tool's
+// schema files.
+func (s Schema) ToJSONFields() ([]byte, error) {
+	return json.MarshalIndent(convertSchemaToJSON(s), "", "  ")
+}
+
+func convertSchemaToJSON(s Schema) []bigQueryJSONField {
+	fields := []bigQueryJSONField{}
+	for _, f := range s {
+		jf := bigQueryJSONField{
+			Description: f.Description,
+			Mode:        fieldMode(f),
+			Name:        f.Name,
+			Type:        string(f.Type),
+		}
+		if len(f.Schema) > 0 {
+			jf.Fields = convertSchemaToJSON(f.Schema)
+		}
+		fields = append(fields, jf)
+	}
+	return fields
+}
+
 type noStructError struct {
 	typ reflect.Type
 }
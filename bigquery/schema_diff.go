@@ -0,0 +1,219 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SchemaChangeKind is the kind of a SchemaChange.
+type SchemaChangeKind int
+
+const (
+	// FieldAdded is the addition of a field.
+	FieldAdded SchemaChangeKind = iota
+	// FieldRemoved is the removal of a field.
+	FieldRemoved
+	// FieldTypeChanged is a change of the type of a field.
+	FieldTypeChanged
+	// FieldModeChanged is a change of whether a field is repeated or
+	// required.
+	FieldModeChanged
+)
+
+func (k SchemaChangeKind) String() string {
+	switch k {
+	case FieldAdded:
+		return "added"
+	case FieldRemoved:
+		return "removed"
+	case FieldTypeChanged:
+		return "type changed"
+	case FieldModeChanged:
+		return "mode changed"
+	}
+	return fmt.Sprintf("SchemaChangeKind(%d)", int(k))
+}
+
+// A SchemaChange is a difference between two schemas, as reported by
+// DiffSchemas.
+type SchemaChange struct {
+	Kind SchemaChangeKind
+
+	// Path holds the names of the field and of the RECORD fields that
+	// contain it, outermost first.
+	Path []string
+
+	// Old is the field in the old schema, and New the field in the new
+	// schema. Old is nil for an added field, and New for a removed one.
+	Old, New *FieldSchema
+}
+
+func (c SchemaChange) String() string {
+	name := strings.Join(c.Path, ".")
+	switch c.Kind {
+	case FieldAdded:
+		return fmt.Sprintf("%s: added %s %s", name, fieldMode(c.New), c.New.Type)
+	case FieldRemoved:
+		return fmt.Sprintf("%s: removed", name)
+	case FieldTypeChanged:
+		return fmt.Sprintf("%s: type changed from %s to %s", name, c.Old.Type, c.New.Type)
+	case FieldModeChanged:
+		return fmt.Sprintf("%s: mode changed from %s to %s", name, fieldMode(c.Old), fieldMode(c.New))
+	}
+	return name + ": " + c.Kind.String()
+}
+
+// Allowed reports whether BigQuery allows the change to the schema of an
+// existing table. It allows adding fields that are not required, and
+// relaxing required fields to nullable.
+func (c SchemaChange) Allowed() bool {
+	switch c.Kind {
+	case FieldAdded:
+		return !c.New.Required || c.New.Repeated
+	case FieldModeChanged:
+		return fieldMode(c.Old) == "REQUIRED" && fieldMode(c.New) == "NULLABLE"
+	}
+	return false
+}
+
+// fieldMode returns the mode of the field in the form of the BigQuery API.
+func fieldMode(f *FieldSchema) string {
+	switch {
+	case f.Repeated:
+		return "REPEATED"
+	case f.Required:
+		return "REQUIRED"
+	}
+	return "NULLABLE"
+}
+
+// DiffSchemas returns the changes that turn the old schema into the new one,
+// such as those between the schema of a table and the schema inferred from a
+// Go struct. Fields are matched by name, ignoring case as BigQuery does. The
+// changes within RECORD fields are reported for each of their fields, except
+// for RECORD fields that are added, removed, or changed to another type.
+//
+// The changes to the fields of the old schema come first, in their order,
+// followed by the added fields in the order of the new schema. Changes to
+// descriptions and to the order of the fields are not reported.
+func DiffSchemas(old, new Schema) []SchemaChange {
+	return diffSchemas(nil, old, new)
+}
+
+func diffSchemas(path []string, old, new Schema) []SchemaChange {
+	var changes []SchemaChange
+	fieldPath := func(name string) []string {
+		return append(append([]string(nil), path...), name)
+	}
+	for _, of := range old {
+		nf := lookupFieldSchema(new, of.Name)
+		p := fieldPath(of.Name)
+		switch {
+		case nf == nil:
+			changes = append(changes, SchemaChange{Kind: FieldRemoved, Path: p, Old: of})
+		case of.Type != nf.Type:
+			changes = append(changes, SchemaChange{Kind: FieldTypeChanged, Path: p, Old: of, New: nf})
+		default:
+			if fieldMode(of) != fieldMode(nf) {
+				changes = append(changes, SchemaChange{Kind: FieldModeChanged, Path: p, Old: of, New: nf})
+			}
+			if of.Type == RecordFieldType {
+				changes = append(changes, diffSchemas(p, of.Schema, nf.Schema)...)
+			}
+		}
+	}
+	for _, nf := range new {
+		if lookupFieldSchema(old, nf.Name) == nil {
+			changes = append(changes, SchemaChange{Kind: FieldAdded, Path: fieldPath(nf.Name), New: nf})
+		}
+	}
+	return changes
+}
+
+// lookupFieldSchema returns the field of s with the given name, ignoring
+// case, or nil.
+func lookupFieldSchema(s Schema, name string) *FieldSchema {
+	for _, f := range s {
+		if strings.EqualFold(f.Name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// SchemaUpdate returns the metadata update that evolves the schema of a
+// table from old toward new, by applying the changes between them that
+// BigQuery allows: it adds the new fields that are not required, and relaxes
+// the fields that are no longer required. It also returns the changes that
+// are not allowed, which the update does not make. If there are no allowed
+// changes, the Schema of the update is nil.
+//
+// Use it to update a table to the schema of a Go struct, for example:
+//
+//	md, err := table.Metadata(ctx)
+//	// TODO: Handle error.
+//	schema, err := bigquery.InferSchema(Item{})
+//	// TODO: Handle error.
+//	update, disallowed := bigquery.SchemaUpdate(md.Schema, schema)
+//	if len(disallowed) > 0 {
+//		// TODO: Handle the changes that must be made by other means.
+//	}
+//	if update.Schema != nil {
+//		_, err = table.Update(ctx, update, md.ETag)
+//		// TODO: Handle error.
+//	}
+func SchemaUpdate(old, new Schema) (update TableMetadataToUpdate, disallowed []SchemaChange) {
+	var schema Schema
+	for _, c := range DiffSchemas(old, new) {
+		if !c.Allowed() {
+			disallowed = append(disallowed, c)
+			continue
+		}
+		if schema == nil {
+			schema = copySchema(old)
+		}
+		parent := &schema
+		for _, name := range c.Path[:len(c.Path)-1] {
+			parent = &lookupFieldSchema(*parent, name).Schema
+		}
+		switch c.Kind {
+		case FieldAdded:
+			*parent = append(*parent, copyFieldSchema(c.New))
+		case FieldModeChanged:
+			lookupFieldSchema(*parent, c.Old.Name).Required = false
+		}
+	}
+	update.Schema = schema
+	return update, disallowed
+}
+
+func copySchema(s Schema) Schema {
+	if s == nil {
+		return nil
+	}
+	c := make(Schema, len(s))
+	for i, f := range s {
+		c[i] = copyFieldSchema(f)
+	}
+	return c
+}
+
+func copyFieldSchema(f *FieldSchema) *FieldSchema {
+	c := *f
+	c.Schema = copySchema(f.Schema)
+	return &c
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"testing"
+
+	"cloud.google.com/go/internal/testutil"
+)
+
+var (
+	diffOld = Schema{
+		{Name: "name", Type: StringFieldType, Required: true},
+		{Name: "age", Type: IntegerFieldType, Required: true},
+		{Name: "gone", Type: BooleanFieldType},
+		{Name: "score", Type: IntegerFieldType},
+		{Name: "address", Type: RecordFieldType, Required: true, Schema: Schema{
+			{Name: "city", Type: StringFieldType, Required: true},
+			{Name: "zip", Type: StringFieldType},
+		}},
+	}
+	diffNew = Schema{
+		{Name: "Name", Type: StringFieldType, Required: true},
+		{Name: "age", Type: IntegerFieldType},
+		{Name: "score", Type: FloatFieldType},
+		{Name: "address", Type: RecordFieldType, Schema: Schema{
+			{Name: "city", Type: StringFieldType, Repeated: true},
+			{Name: "zip", Type: StringFieldType},
+			{Name: "country", Type: StringFieldType},
+		}},
+		{Name: "email", Type: StringFieldType},
+		{Name: "id", Type: IntegerFieldType, Required: true},
+	}
+)
+
+func TestDiffSchemas(t *testing.T) {
+	var got []string
+	for _, c := range DiffSchemas(diffOld, diffNew) {
+		s := c.String()
+		if c.Allowed() {
+			s += " (allowed)"
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"age: mode changed from REQUIRED to NULLABLE (allowed)",
+		"gone: removed",
+		"score: type changed from INTEGER to FLOAT",
+		"address: mode changed from REQUIRED to NULLABLE (allowed)",
+		"address.city: mode changed from REQUIRED to REPEATED",
+		"address.country: added NULLABLE STRING (allowed)",
+		"email: added NULLABLE STRING (allowed)",
+		"id: added REQUIRED INTEGER",
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("-got +want:\n%s", diff)
+	}
+	if got := DiffSchemas(diffOld, diffOld); len(got) != 0 {
+		t.Errorf("diff of a schema with itself: got %v, want none", got)
+	}
+}
+
+func TestSchemaUpdate(t *testing.T) {
+	update, disallowed := SchemaUpdate(diffOld, diffNew)
+	want := Schema{
+		{Name: "name", Type: StringFieldType, Required: true},
+		{Name: "age", Type: IntegerFieldType},
+		{Name: "gone", Type: BooleanFieldType},
+		{Name: "score", Type: IntegerFieldType},
+		{Name: "address", Type: RecordFieldType, Schema: Schema{
+			{Name: "city", Type: StringFieldType, Required: true},
+			{Name: "zip", Type: StringFieldType},
+			{Name: "country", Type: StringFieldType},
+		}},
+		{Name: "email", Type: StringFieldType},
+	}
+	if diff := testutil.Diff(update.Schema, want); diff != "" {
+		t.Errorf("schema: -got +want:\n%s", diff)
+	}
+	if got, want := len(disallowed), 4; got != want {
+		t.Errorf("got %d disallowed changes, want %d: %v", got, want, disallowed)
+	}
+	// The old schema is not modified.
+	if !diffOld[1].Required || len(diffOld[4].Schema) != 2 {
+		t.Error("SchemaUpdate modified the old schema")
+	}
+
+	update, disallowed = SchemaUpdate(diffNew, diffNew)
+	if update.Schema != nil || disallowed != nil {
+		t.Errorf("got %v, %v, want no update", update.Schema, disallowed)
+	}
+}
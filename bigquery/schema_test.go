@@ -1132,3 +1132,66 @@ func TestSchemaFromJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestSchemaToJSONFields(t *testing.T) {
+	schema := Schema{
+		{Name: "name", Type: StringFieldType, Required: true, Description: "the name"},
+		{Name: "tags", Type: StringFieldType, Repeated: true},
+		{Name: "address", Type: RecordFieldType, Schema: Schema{
+			{Name: "city", Type: StringFieldType},
+			{Name: "zip", Type: IntegerFieldType, Required: true},
+		}},
+	}
+	want := `[
+  {
+    "description": "the name",
+    "mode": "REQUIRED",
+    "name": "name",
+    "type": "STRING"
+  },
+  {
+    "mode": "REPEATED",
+    "name": "tags",
+    "type": "STRING"
+  },
+  {
+    "fields": [
+      {
+        "mode": "NULLABLE",
+        "name": "city",
+        "type": "STRING"
+      },
+      {
+        "mode": "REQUIRED",
+        "name": "zip",
+        "type": "INTEGER"
+      }
+    ],
+    "mode": "NULLABLE",
+    "name": "address",
+    "type": "RECORD"
+  }
+]`
+	got, err := schema.ToJSONFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	rt, err := SchemaFromJSON(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(rt, schema); diff != "" {
+		t.Errorf("round trip: -got +want:\n%s", diff)
+	}
+
+	got, err = Schema(nil).ToJSONFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "[]" {
+		t.Errorf("empty schema: got %s, want []", got)
+	}
+}
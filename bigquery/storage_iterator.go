@@ -0,0 +1,311 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	bqstorage "cloud.google.com/go/bigquery/storage/apiv1beta1"
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/option"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1beta1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storageReadMinRows is the number of rows from which Job.Read reads query
+// results with the Storage API, if it is enabled. Smaller results are read
+// faster with tabledata.list, which needs no read session.
+const storageReadMinRows = 10000
+
+// storageReadMaxAttempts is the number of times in a row that a stream is
+// read after transient errors without returning any rows, before the read
+// fails.
+const storageReadMaxAttempts = 5
+
+// storageReadBackoff is the backoff between the attempts to read a stream.
+var storageReadBackoff = gax.Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        10 * time.Second,
+	Multiplier: 2,
+}
+
+var errStorageReadNotEnabled = errors.New("bigquery: the Storage API is not enabled; call Client.EnableStorageReadClient")
+
+// EnableStorageReadClient makes the client read data with the BigQuery
+// Storage API, which is faster than the tabledata.list method for large
+// amounts of data. Once it is enabled, Table.StorageRead can be used, and
+// Job.Read and Query.Read use the Storage API for large query results.
+//
+// The options are those of the Storage API client; they are usually the same
+// as those passed to NewClient. Reading with the Storage API is billed
+// separately; see https://cloud.google.com/bigquery/pricing#storage-api.
+//
+// EnableStorageReadClient must be called before the client is used
+// concurrently.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+func (c *Client) EnableStorageReadClient(ctx context.Context, opts ...option.ClientOption) error {
+	if c.rc != nil {
+		return errors.New("bigquery: the Storage API is already enabled")
+	}
+	rc, err := bqstorage.NewBigQueryStorageClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("bigquery: constructing Storage API client: %v", err)
+	}
+	c.rc = rc
+	return nil
+}
+
+// StorageReadOptions controls a read of a table with the Storage API.
+//
+// The rows are always read in the Avro format. The Arrow format of the
+// Storage API is out of the scope of this feature and cannot be selected.
+type StorageReadOptions struct {
+	// SelectedFields are the names of the columns to read. Nested fields can
+	// be selected as "record.field". If empty, all the columns are read.
+	SelectedFields []string
+
+	// RowRestriction is a SQL boolean expression that the rows must satisfy,
+	// such as "num > 10 AND name = 'foo'". If empty, all the rows are read.
+	RowRestriction string
+
+	// MaxStreams is the maximum number of streams that are read in parallel.
+	// If zero, the Storage API chooses the number of streams. The order of
+	// the rows is only preserved if MaxStreams is 1.
+	MaxStreams int
+}
+
+// StorageRead returns an iterator over the rows of the table that reads them
+// with the BigQuery Storage API, which must be enabled with
+// Client.EnableStorageReadClient. The rows are read in the Avro format; the
+// Arrow format is not supported. The streams of the read are read in
+// parallel, so the rows are returned in no particular order unless
+// opts.MaxStreams is 1. opts may be nil.
+//
+// The iterator's StartIndex, page size and page token are ignored. The
+// iterator reads ahead of the calls to Next; cancel ctx to stop it if it is
+// not read to the end.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+func (t *Table) StorageRead(ctx context.Context, opts *StorageReadOptions) *RowIterator {
+	if opts == nil {
+		opts = &StorageReadOptions{}
+	}
+	return newRowIterator(ctx, t, newStorageReader(t.c, *opts, 0).fetch)
+}
+
+// storageReader reads a table with the Storage API. Its fetch method is a
+// pageFetcher that returns the rows of the streams in the order they arrive.
+type storageReader struct {
+	c         *Client
+	opts      StorageReadOptions
+	totalRows uint64
+
+	mu      sync.Mutex
+	started bool
+	// avroSchema is derived from the Avro schema of the read session, and is
+	// only used to decode the rows. The iterator's schema, if any, is the
+	// schema of the table or query, which Avro does not describe exactly.
+	avroSchema Schema
+	pages      chan storagePage
+	cancel     func()
+}
+
+// storagePage holds the rows of a response of a stream, or an error.
+type storagePage struct {
+	rows [][]Value
+	err  error
+}
+
+func newStorageReader(c *Client, opts StorageReadOptions, totalRows uint64) *storageReader {
+	return &storageReader{c: c, opts: opts, totalRows: totalRows}
+}
+
+func (r *storageReader) fetch(ctx context.Context, t *Table, schema Schema, _ uint64, _ int64, _ string) (*fetchPageResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		if err := r.start(ctx, t); err != nil {
+			return nil, err
+		}
+		r.started = true
+	}
+	if schema == nil {
+		schema = r.avroSchema
+	}
+	res := &fetchPageResult{schema: schema, totalRows: r.totalRows}
+	p, ok := <-r.pages
+	if !ok {
+		// All the streams have been read.
+		return res, nil
+	}
+	if p.err != nil {
+		r.cancel()
+		return nil, p.err
+	}
+	res.rows = p.rows
+	// Any non-empty token makes the iterator fetch the next page.
+	res.pageToken = "storage"
+	return res, nil
+}
+
+// start creates the read session and starts reading its streams.
+func (r *storageReader) start(ctx context.Context, t *Table) error {
+	if r.c.rc == nil {
+		return errStorageReadNotEnabled
+	}
+	req := &storagepb.CreateReadSessionRequest{
+		Parent: "projects/" + r.c.projectID,
+		TableReference: &storagepb.TableReference{
+			ProjectId: t.ProjectID,
+			DatasetId: t.DatasetID,
+			TableId:   t.TableID,
+		},
+		RequestedStreams: int32(r.opts.MaxStreams),
+		Format:           storagepb.DataFormat_AVRO,
+	}
+	if len(r.opts.SelectedFields) > 0 || r.opts.RowRestriction != "" {
+		req.ReadOptions = &storagepb.TableReadOptions{
+			SelectedFields: r.opts.SelectedFields,
+			RowRestriction: r.opts.RowRestriction,
+		}
+	}
+	session, err := r.c.rc.CreateReadSession(ctx, req)
+	if err != nil {
+		return err
+	}
+	as := session.GetAvroSchema()
+	if as == nil {
+		return errors.New("bigquery: read session has no Avro schema")
+	}
+	avroType, err := parseAvroSchema(as.Schema)
+	if err != nil {
+		return err
+	}
+	r.avroSchema, err = avroToSchema(avroType)
+	if err != nil {
+		return err
+	}
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.pages = make(chan storagePage, len(session.Streams))
+	var wg sync.WaitGroup
+	for _, s := range session.Streams {
+		wg.Add(1)
+		go func(s *storagepb.Stream) {
+			defer wg.Done()
+			if err := r.readStream(ctx, s, avroType); err != nil {
+				select {
+				case r.pages <- storagePage{err: err}:
+				case <-ctx.Done():
+				}
+			}
+		}(s)
+	}
+	go func() {
+		wg.Wait()
+		close(r.pages)
+	}()
+	return nil
+}
+
+// readStream reads the rows of a stream and sends them to r.pages. It resumes
+// the stream where it stopped after transient errors, and gives up after
+// storageReadMaxAttempts attempts in a row that return no rows.
+func (r *storageReader) readStream(ctx context.Context, s *storagepb.Stream, avroType *avroType) error {
+	var offset int64
+	bo := storageReadBackoff
+	attempts := 0
+	for {
+		start := offset
+		err := r.readStreamFrom(ctx, s, &offset, avroType)
+		if err == nil {
+			return nil
+		}
+		if c := status.Code(err); c != codes.Unavailable && c != codes.ResourceExhausted {
+			return err
+		}
+		if offset > start {
+			// The stream made progress: start the backoff and the count of
+			// attempts over.
+			bo = storageReadBackoff
+			attempts = 0
+		}
+		attempts++
+		if attempts >= storageReadMaxAttempts {
+			return err
+		}
+		if err := gax.Sleep(ctx, bo.Pause()); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *storageReader) readStreamFrom(ctx context.Context, s *storagepb.Stream, offset *int64, avroType *avroType) error {
+	rs, err := r.c.rc.ReadRows(ctx, &storagepb.ReadRowsRequest{
+		ReadPosition: &storagepb.StreamPosition{Stream: s, Offset: *offset},
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := rs.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if res.GetArrowRecordBatch() != nil {
+			// The session is created with the Avro format.
+			return errors.New("bigquery: unexpected Arrow record batch in an Avro read session")
+		}
+		rows, err := decodeAvroRows(avroType, r.avroSchema, res.GetAvroRows().GetSerializedBinaryRows())
+		if err != nil {
+			return err
+		}
+		*offset += int64(len(rows))
+		if len(rows) == 0 {
+			continue
+		}
+		select {
+		case r.pages <- storagePage{rows: rows}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// hasOrderedOutput reports whether the results of a query are ordered, which
+// must then be read from a single stream to keep their order. They are ordered
+// if the last stage of the query plan, which writes the results, sorts them.
+// Without a plan, the results are assumed to be ordered.
+func hasOrderedOutput(qs *QueryStatistics) bool {
+	if len(qs.QueryPlan) == 0 {
+		return true
+	}
+	for _, step := range qs.QueryPlan[len(qs.QueryPlan)-1].Steps {
+		if step.Kind == "SORT" {
+			return true
+		}
+	}
+	return false
+}
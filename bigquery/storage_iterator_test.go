@@ -0,0 +1,346 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	gax "github.com/googleapis/gax-go/v2"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStorageServer serves a table with the columns name STRING and num
+// INTEGER, whose rows are split over streams.
+type fakeStorageServer struct {
+	storagepb.BigQueryStorageServer
+
+	streams [][]string // the names in the rows of each stream; num is the row's index
+
+	mu         sync.Mutex
+	sessionReq *storagepb.CreateReadSessionRequest
+	offsets    []int64 // the offsets of the ReadRows requests
+	failed     bool
+	flaky      bool // fail after each row of stream 0
+	down       bool // fail all the reads of stream 0
+}
+
+func (s *fakeStorageServer) CreateReadSession(ctx context.Context, req *storagepb.CreateReadSessionRequest) (*storagepb.ReadSession, error) {
+	s.mu.Lock()
+	s.sessionReq = req
+	s.mu.Unlock()
+	rs := &storagepb.ReadSession{
+		Name: "session",
+		Schema: &storagepb.ReadSession_AvroSchema{AvroSchema: &storagepb.AvroSchema{Schema: `{
+			"type": "record", "name": "__root__",
+			"fields": [{"name": "name", "type": "string"}, {"name": "num", "type": ["null", "long"]}]
+		}`}},
+	}
+	n := len(s.streams)
+	if req.RequestedStreams > 0 && int(req.RequestedStreams) < n {
+		n = int(req.RequestedStreams)
+	}
+	for i := 0; i < n; i++ {
+		rs.Streams = append(rs.Streams, &storagepb.Stream{Name: fmt.Sprint(i)})
+	}
+	return rs, nil
+}
+
+func (s *fakeStorageServer) ReadRows(req *storagepb.ReadRowsRequest, stream storagepb.BigQueryStorage_ReadRowsServer) error {
+	pos := req.ReadPosition
+	s.mu.Lock()
+	s.offsets = append(s.offsets, pos.Offset)
+	single := s.sessionReq.RequestedStreams == 1
+	flaky, down := s.flaky, s.down
+	s.mu.Unlock()
+	var i int
+	fmt.Sscan(pos.Stream.Name, &i)
+	if i == 0 && down {
+		return status.Error(codes.Unavailable, "down")
+	}
+	var names []string
+	if single {
+		// A single stream holds all the rows.
+		for _, st := range s.streams {
+			names = append(names, st...)
+		}
+	} else {
+		names = s.streams[i]
+	}
+	// Send one row per response, and fail once in the middle of stream 0.
+	for j := int(pos.Offset); j < len(names); j++ {
+		s.mu.Lock()
+		fail := i == 0 && j == 1 && !s.failed
+		if fail {
+			s.failed = true
+		}
+		fail = fail || (i == 0 && flaky && j > int(pos.Offset))
+		s.mu.Unlock()
+		if fail {
+			return status.Error(codes.Unavailable, "try again")
+		}
+		e := &avroEncoder{}
+		e.str(names[j]).long(1).long(int64(j))
+		if err := stream.Send(&storagepb.ReadRowsResponse{
+			Rows:     &storagepb.ReadRowsResponse_AvroRows{AvroRows: &storagepb.AvroRows{SerializedBinaryRows: e.buf, RowCount: 1}},
+			RowCount: 1,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newStorageTestClient(t *testing.T, s *fakeStorageServer) (*Client, func()) {
+	srv, err := testutil.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagepb.RegisterBigQueryStorageServer(srv.Gsrv, s)
+	srv.Start()
+	c := &Client{projectID: "project-id"}
+	err = c.EnableStorageReadClient(context.Background(),
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		srv.Close()
+	}
+}
+
+func sortValues(rows [][]Value) {
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0].(string) < rows[j][0].(string) })
+}
+
+func TestStorageRead(t *testing.T) {
+	s := &fakeStorageServer{streams: [][]string{{"a", "b", "c"}, {"d"}, {"e", "f"}}}
+	c, cleanup := newStorageTestClient(t, s)
+	defer cleanup()
+
+	opts := &StorageReadOptions{SelectedFields: []string{"name", "num"}, RowRestriction: "num < 10"}
+	it := c.Dataset("dataset-id").Table("table-id").StorageRead(context.Background(), opts)
+	got, ok := collectValues(t, it)
+	if !ok {
+		return
+	}
+	sortValues(got)
+	want := [][]Value{
+		{"a", int64(0)}, {"b", int64(1)}, {"c", int64(2)},
+		{"d", int64(0)},
+		{"e", int64(0)}, {"f", int64(1)},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+	wantSchema := Schema{
+		{Name: "name", Type: StringFieldType, Required: true},
+		{Name: "num", Type: IntegerFieldType},
+	}
+	if diff := testutil.Diff(it.Schema, wantSchema); diff != "" {
+		t.Errorf("schema: got=-, want=+:\n%s", diff)
+	}
+
+	req := s.sessionReq
+	if req.Parent != "projects/project-id" || req.TableReference.DatasetId != "dataset-id" || req.TableReference.TableId != "table-id" {
+		t.Errorf("bad session request %v", req)
+	}
+	if got := req.ReadOptions.GetRowRestriction(); got != opts.RowRestriction {
+		t.Errorf("got row restriction %q, want %q", got, opts.RowRestriction)
+	}
+	if got := req.ReadOptions.GetSelectedFields(); len(got) != 2 {
+		t.Errorf("got selected fields %q, want %q", got, opts.SelectedFields)
+	}
+	// Stream 0 failed after its first row, and was resumed from offset 1.
+	var resumed bool
+	for _, o := range s.offsets {
+		if o == 1 {
+			resumed = true
+		}
+	}
+	if !resumed {
+		t.Errorf("stream was not resumed after a transient error; offsets %v", s.offsets)
+	}
+}
+
+func TestStorageReadRetries(t *testing.T) {
+	defer func(bo gax.Backoff) { storageReadBackoff = bo }(storageReadBackoff)
+	storageReadBackoff = gax.Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+
+	// A stream that fails after each row is read to the end, as each attempt
+	// makes progress.
+	var names []string
+	for i := 0; i < 2*storageReadMaxAttempts; i++ {
+		names = append(names, fmt.Sprint(i))
+	}
+	s := &fakeStorageServer{streams: [][]string{names}, flaky: true}
+	c, cleanup := newStorageTestClient(t, s)
+	defer cleanup()
+	it := c.Dataset("dataset-id").Table("table-id").StorageRead(context.Background(), nil)
+	if got, ok := collectValues(t, it); ok && len(got) != len(names) {
+		t.Errorf("flaky stream: got %d rows, want %d", len(got), len(names))
+	}
+
+	// A stream that is down fails the read after storageReadMaxAttempts.
+	s = &fakeStorageServer{streams: [][]string{{"a"}}, down: true}
+	c, cleanup = newStorageTestClient(t, s)
+	defer cleanup()
+	it = c.Dataset("dataset-id").Table("table-id").StorageRead(context.Background(), nil)
+	var vals []Value
+	if err := it.Next(&vals); status.Code(err) != codes.Unavailable {
+		t.Errorf("down stream: got %v, want code Unavailable", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := len(s.offsets); got != storageReadMaxAttempts {
+		t.Errorf("down stream: read %d times, want %d", got, storageReadMaxAttempts)
+	}
+}
+
+func TestStorageReadNotEnabled(t *testing.T) {
+	c := &Client{projectID: "project-id"}
+	it := c.Dataset("dataset-id").Table("table-id").StorageRead(context.Background(), nil)
+	var vals []Value
+	if err := it.Next(&vals); err != errStorageReadNotEnabled {
+		t.Errorf("got %v, want %v", err, errStorageReadNotEnabled)
+	}
+}
+
+func TestJobReadWithStorage(t *testing.T) {
+	ctx := context.Background()
+	s := &fakeStorageServer{streams: [][]string{{"a", "b"}, {"c"}}}
+	c, cleanup := newStorageTestClient(t, s)
+	defer cleanup()
+
+	// The schema of the query is kept, rather than the one derived from the
+	// Avro schema of the read session, which has no descriptions.
+	querySchema := Schema{
+		{Name: "name", Type: StringFieldType, Required: true, Description: "the name"},
+		{Name: "num", Type: IntegerFieldType, Description: "the number"},
+	}
+	waitForLargeQuery := func(context.Context, string) (Schema, uint64, error) {
+		return querySchema, storageReadMinRows, nil
+	}
+	// The job's last status, with the steps of the last stage of its plan.
+	status := func(steps ...string) *JobStatus {
+		last := &ExplainQueryStage{Name: "S01: Output"}
+		for _, k := range steps {
+			last.Steps = append(last.Steps, &ExplainQueryStep{Kind: k})
+		}
+		return &JobStatus{State: Done, Statistics: &JobStatistics{Details: &QueryStatistics{
+			QueryPlan: []*ExplainQueryStage{
+				{Name: "S00: Input", Steps: []*ExplainQueryStep{{Kind: "SORT"}}},
+				last,
+			},
+		}}}
+	}
+	for _, test := range []struct {
+		desc        string
+		status      *JobStatus
+		wantStreams int32
+	}{
+		{"unordered", status("READ", "WRITE"), 0},
+		{"ordered", status("READ", "SORT", "WRITE"), 1},
+		{"no status", nil, 1},
+		{"no statistics", &JobStatus{State: Done}, 1},
+	} {
+		job := &Job{
+			projectID:  "project-id",
+			jobID:      "job-id",
+			c:          c,
+			lastStatus: test.status,
+			config: &bq.JobConfiguration{
+				Query: &bq.JobConfigurationQuery{
+					Query: "SELECT * FROM t",
+					DestinationTable: &bq.TableReference{
+						ProjectId: "project-id",
+						DatasetId: "dataset-id",
+						TableId:   "table-id",
+					},
+				},
+			},
+		}
+		// The tabledata.list page fetcher must not be used.
+		pf := func(context.Context, *Table, Schema, uint64, int64, string) (*fetchPageResult, error) {
+			t.Fatal("read query results with tabledata.list")
+			return nil, nil
+		}
+		it, err := job.read(ctx, waitForLargeQuery, pf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for {
+			var vals []Value
+			err := it.Next(&vals)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		if n != 3 {
+			t.Errorf("%s: got %d rows, want 3", test.desc, n)
+		}
+		if diff := testutil.Diff(it.Schema, querySchema); diff != "" {
+			t.Errorf("%s: schema: got=-, want=+:\n%s", test.desc, diff)
+		}
+		if it.TotalRows != storageReadMinRows {
+			t.Errorf("%s: got TotalRows %d, want %d", test.desc, it.TotalRows, storageReadMinRows)
+		}
+		if got := s.sessionReq.RequestedStreams; got != test.wantStreams {
+			t.Errorf("%s: requested %d streams, want %d", test.desc, got, test.wantStreams)
+		}
+	}
+}
+
+func TestHasOrderedOutput(t *testing.T) {
+	stage := func(kinds ...string) *ExplainQueryStage {
+		s := &ExplainQueryStage{}
+		for _, k := range kinds {
+			s.Steps = append(s.Steps, &ExplainQueryStep{Kind: k})
+		}
+		return s
+	}
+	for _, test := range []struct {
+		desc string
+		plan []*ExplainQueryStage
+		want bool
+	}{
+		{"no plan", nil, true},
+		{"unordered", []*ExplainQueryStage{stage("READ", "SORT"), stage("READ", "WRITE")}, false},
+		{"ordered", []*ExplainQueryStage{stage("READ"), stage("READ", "SORT", "LIMIT", "WRITE")}, true},
+	} {
+		if got := hasOrderedOutput(&QueryStatistics{QueryPlan: test.plan}); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.desc, got, test.want)
+		}
+	}
+}
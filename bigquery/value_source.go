@@ -0,0 +1,222 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/iterator"
+)
+
+// valueSourceChunkSize is the number of encoded bytes that a ValueSource
+// buffers before they are uploaded.
+const valueSourceChunkSize = 64 << 10
+
+// A ValueSource is a source for a load operation that encodes Go values as
+// they are uploaded, without writing them to a file first. The values may be
+// ValueSavers, structs or struct pointers, as for Inserter.Put, and are
+// converted to rows in the same way.
+//
+// The values are encoded as newline-delimited JSON, unless SourceFormat is
+// Avro. The rows are encoded with Schema, which should be the schema of the
+// destination table. If Schema is nil, it is taken from the first value:
+// the schema of a ValuesSaver or StructSaver, or the schema inferred from the
+// type of a struct. A JSON ValueSource also accepts other ValueSavers without
+// a schema, whose rows are written as they are.
+//
+// Schema is only sent with the load job for JSON data, and only if it is set.
+// Set it when the destination table does not exist. An Avro ValueSource sets
+// LoadConfig.UseAvroLogicalTypes, so that TIMESTAMP, DATE and TIME values are
+// loaded as such.
+//
+// The other options of the FileConfig, which are for CSV data, are ignored.
+//
+// This feature is EXPERIMENTAL and subject to change or removal without notice.
+type ValueSource struct {
+	next func() (interface{}, error)
+	FileConfig
+}
+
+// NewValueSource creates a ValueSource that gets its values by calling next
+// until it returns iterator.Done. If next returns another error, the upload
+// of the data fails and Loader.Run returns the error.
+func NewValueSource(next func() (interface{}, error)) *ValueSource {
+	return &ValueSource{next: next}
+}
+
+// NewChannelSource creates a ValueSource that receives its values from ch,
+// which must be a channel, until ch is closed. The elements of ch may be of
+// any type that NewValueSource accepts, such as a struct type.
+// NewChannelSource panics if ch is not a channel that can be received from.
+func NewChannelSource(ch interface{}) *ValueSource {
+	cv := reflect.ValueOf(ch)
+	if cv.Kind() != reflect.Chan || cv.Type().ChanDir()&reflect.RecvDir == 0 {
+		panic(fmt.Sprintf("bigquery: NewChannelSource: %T is not a receivable channel", ch))
+	}
+	return NewValueSource(func() (interface{}, error) {
+		v, ok := cv.Recv()
+		if !ok {
+			return nil, iterator.Done
+		}
+		return v.Interface(), nil
+	})
+}
+
+func (s *ValueSource) populateLoadConfig(lc *bq.JobConfigurationLoad) io.Reader {
+	s.FileConfig.populateLoadConfig(lc)
+	enc := &valueEncoder{
+		next:   s.next,
+		schema: s.Schema,
+		format: s.SourceFormat,
+	}
+	switch s.SourceFormat {
+	case "", JSON:
+		enc.format = JSON
+	case Avro:
+		lc.Schema = nil
+		lc.UseAvroLogicalTypes = true
+	default:
+		enc.err = fmt.Errorf("bigquery: ValueSource cannot encode %s data", s.SourceFormat)
+	}
+	lc.SourceFormat = string(enc.format)
+	return enc
+}
+
+// valueEncoder is an io.Reader of the encoded values of a ValueSource. It
+// encodes the values as they are read.
+type valueEncoder struct {
+	next   func() (interface{}, error)
+	schema Schema
+	format DataFormat
+	avro   *avroWriter // created with the first row
+	buf    bytes.Buffer
+	err    error // io.EOF after the last row
+}
+
+func (e *valueEncoder) Read(p []byte) (int, error) {
+	for e.buf.Len() == 0 && e.err == nil {
+		e.err = e.fill()
+	}
+	if e.buf.Len() > 0 {
+		return e.buf.Read(p)
+	}
+	return 0, e.err
+}
+
+// fill encodes values into the buffer until it holds a chunk. It returns
+// io.EOF after the values have run out.
+func (e *valueEncoder) fill() error {
+	for e.buf.Len() < valueSourceChunkSize {
+		x, err := e.next()
+		if err == iterator.Done {
+			if err := e.finish(); err != nil {
+				return err
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		row, err := e.save(x)
+		if err != nil {
+			return err
+		}
+		if err := e.encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save converts a value to a row with a ValueSaver, and takes the schema from
+// the first value if there is none.
+func (e *valueEncoder) save(x interface{}) (map[string]Value, error) {
+	if ss, ok := x.(*StructSaver); ok && ss.Schema == nil && e.schema != nil {
+		x = &StructSaver{Schema: e.schema, InsertID: ss.InsertID, Struct: ss.Struct}
+	} else if _, ok := x.(ValueSaver); !ok && e.schema != nil {
+		x = &StructSaver{Schema: e.schema, Struct: x}
+	}
+	saver, ok, err := toValueSaver(x)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("bigquery: value of type %T is not a ValueSaver, struct or struct pointer", x)
+	}
+	if e.schema == nil {
+		switch s := saver.(type) {
+		case *StructSaver:
+			e.schema = s.Schema
+		case *ValuesSaver:
+			e.schema = s.Schema
+		}
+	}
+	row, _, err := saver.Save()
+	if row == nil && err == nil {
+		// A nil struct pointer saves no values.
+		row = map[string]Value{}
+	}
+	return row, err
+}
+
+func (e *valueEncoder) encode(row map[string]Value) error {
+	if e.format == JSON {
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		e.buf.Write(b)
+		e.buf.WriteByte('\n')
+		return nil
+	}
+	if err := e.startAvro(); err != nil {
+		return err
+	}
+	return e.avro.write(row)
+}
+
+// finish writes the end of the encoded data, after the last row.
+func (e *valueEncoder) finish() error {
+	if e.format != Avro {
+		return nil
+	}
+	// An Avro file has a header even if it has no rows.
+	if err := e.startAvro(); err != nil {
+		return err
+	}
+	return e.avro.flush()
+}
+
+// startAvro writes the header of the Avro data, if it hasn't been written.
+func (e *valueEncoder) startAvro() error {
+	if e.avro != nil {
+		return nil
+	}
+	if e.schema == nil {
+		return errors.New("bigquery: ValueSource needs a Schema to encode Avro data")
+	}
+	w, err := newAvroWriter(&e.buf, e.schema)
+	if err != nil {
+		return err
+	}
+	e.avro = w
+	return nil
+}
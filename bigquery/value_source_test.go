@@ -0,0 +1,368 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery/bqtest"
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/iterator"
+)
+
+// sliceSource returns a ValueSource of the values.
+func sliceSource(vals ...interface{}) *ValueSource {
+	return NewValueSource(func() (interface{}, error) {
+		if len(vals) == 0 {
+			return nil, iterator.Done
+		}
+		v := vals[0]
+		vals = vals[1:]
+		return v, nil
+	})
+}
+
+func readSource(t *testing.T, src *ValueSource) ([]byte, *bq.JobConfigurationLoad) {
+	lc := &bq.JobConfigurationLoad{}
+	data, err := ioutil.ReadAll(src.populateLoadConfig(lc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, lc
+}
+
+type vsItem struct {
+	Name   string
+	Amount *big.Rat
+	At     civil.DateTime
+	Clock  civil.Time
+	Area   NullGeography
+	Tags   []string
+}
+
+func TestValueSourceJSON(t *testing.T) {
+	items := []*vsItem{
+		{
+			Name:   "a",
+			Amount: big.NewRat(3, 2),
+			At:     civil.DateTime{Date: civil.Date{Year: 2020, Month: 1, Day: 2}, Time: civil.Time{Hour: 3, Minute: 4, Second: 5}},
+			Clock:  civil.Time{Hour: 3, Minute: 4, Second: 5, Nanosecond: 6000},
+			Area:   NullGeography{GeographyVal: "POINT(1 2)", Valid: true},
+			Tags:   []string{"x", "y"},
+		},
+		{Name: "b"},
+	}
+	var vals []interface{}
+	for _, it := range items {
+		vals = append(vals, it)
+	}
+	data, lc := readSource(t, sliceSource(vals...))
+	if lc.SourceFormat != string(JSON) || lc.Schema != nil {
+		t.Errorf("got format %q and schema %v, want JSON and no schema", lc.SourceFormat, lc.Schema)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(items) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(items), data)
+	}
+	want := `{"Amount":"1.500000000","Area":"POINT(1 2)","At":"2020-01-02 03:04:05","Clock":"03:04:05.000006","Name":"a","Tags":["x","y"]}`
+	if lines[0] != want {
+		t.Errorf("got\n%s\nwant\n%s", lines[0], want)
+	}
+	// The rows are encoded as the Inserter encodes them.
+	for i, it := range items {
+		saver, _, err := toValueSaver(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		row, err := newInsertRow(saver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(row.Json)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines[i] != string(b) {
+			t.Errorf("row %d: got %s, Inserter sends %s", i, lines[i], b)
+		}
+	}
+
+	// A Schema is sent with the job, and used for structs.
+	src := sliceSource(vsItem{Name: "c", Tags: []string{"z"}})
+	src.Schema = Schema{{Name: "name", Type: StringFieldType}}
+	data, lc = readSource(t, src)
+	if got, want := string(data), `{"name":"c"}`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if lc.Schema == nil || len(lc.Schema.Fields) != 1 {
+		t.Errorf("got schema %v, want the source's schema", lc.Schema)
+	}
+}
+
+var vsAvroSchema = Schema{
+	{Name: "s", Type: StringFieldType, Required: true},
+	{Name: "b", Type: BytesFieldType},
+	{Name: "i", Type: IntegerFieldType},
+	{Name: "f", Type: FloatFieldType},
+	{Name: "ok", Type: BooleanFieldType},
+	{Name: "ts", Type: TimestampFieldType},
+	{Name: "d", Type: DateFieldType},
+	{Name: "t", Type: TimeFieldType},
+	{Name: "dt", Type: DateTimeFieldType},
+	{Name: "n", Type: NumericFieldType, Repeated: true},
+	{Name: "g", Type: GeographyFieldType},
+	{Name: "rec", Type: RecordFieldType, Schema: Schema{
+		{Name: "x", Type: IntegerFieldType, Required: true},
+		{Name: "inner", Type: RecordFieldType, Repeated: true, Schema: Schema{
+			{Name: "y", Type: StringFieldType},
+		}},
+	}},
+}
+
+// readAvroFile decodes an Avro object container file written by avroWriter.
+func readAvroFile(t *testing.T, data []byte) (Schema, [][]Value) {
+	if !bytes.HasPrefix(data, []byte("Obj\x01")) {
+		t.Fatalf("data does not start with the Avro magic: %q", data)
+	}
+	d := &avroDecoder{buf: data[4:]}
+	meta := map[string]string{}
+	for {
+		n, err := d.long()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		for ; n > 0; n-- {
+			k, err := d.bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := d.bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta[string(k)] = string(v)
+		}
+	}
+	if meta["avro.codec"] != "null" {
+		t.Errorf("got codec %q", meta["avro.codec"])
+	}
+	sync := d.buf[:16]
+	d.buf = d.buf[16:]
+	at, err := parseAvroSchema(meta["avro.schema"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := avroToSchema(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]Value
+	for len(d.buf) > 0 {
+		count, err := d.long()
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := d.bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		brows, err := decodeAvroRows(at, schema, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(brows)) != count {
+			t.Errorf("block has %d rows, want %d", len(brows), count)
+		}
+		rows = append(rows, brows...)
+		if !bytes.HasPrefix(d.buf, sync) {
+			t.Fatal("block is not followed by the sync marker")
+		}
+		d.buf = d.buf[16:]
+	}
+	return schema, rows
+}
+
+func TestValueSourceAvro(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	date := civil.Date{Year: 1969, Month: 12, Day: 30}
+	tm := civil.Time{Hour: 23, Minute: 59, Second: 58, Nanosecond: 7000}
+	dt := civil.DateTime{Date: civil.Date{Year: 2020, Month: 3, Day: 4}, Time: civil.Time{Hour: 5}}
+	big1, _ := new(big.Rat).SetString("-12345678901234567890.123456789")
+	rows := [][]Value{
+		{
+			"str", []byte("bytes"), int64(-7), 2.5, true, ts, date, tm, dt,
+			[]Value{big.NewRat(3, 2), big.NewRat(-1, 1), big1, new(big.Rat)},
+			"POINT(1 2)",
+			[]Value{int64(1), []Value{[]Value{"y1"}, []Value{nil}}},
+		},
+		{"only", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+	}
+	var vals []interface{}
+	for _, r := range rows {
+		vals = append(vals, &ValuesSaver{Schema: vsAvroSchema, Row: r})
+	}
+	src := sliceSource(vals...)
+	src.SourceFormat = Avro
+	src.Schema = vsAvroSchema
+	data, lc := readSource(t, src)
+	if lc.SourceFormat != string(Avro) || !lc.UseAvroLogicalTypes || lc.Schema != nil {
+		t.Errorf("got format %q, logical types %t and schema %v; want Avro, true and no schema",
+			lc.SourceFormat, lc.UseAvroLogicalTypes, lc.Schema)
+	}
+	gotSchema, got := readAvroFile(t, data)
+	if diff := testutil.Diff(gotSchema, vsAvroSchema); diff != "" {
+		t.Errorf("schema: -got, +want:\n%s", diff)
+	}
+	want := [][]Value{
+		rows[0],
+		{"only", nil, nil, nil, nil, nil, nil, nil, nil, []Value(nil), nil, nil},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("rows: -got, +want:\n%s", diff)
+	}
+}
+
+func TestValueSourceAvroBlocks(t *testing.T) {
+	type row struct{ N int64 }
+	const n = 20000
+	i := 0
+	src := NewValueSource(func() (interface{}, error) {
+		if i == n {
+			return nil, iterator.Done
+		}
+		i++
+		return row{int64(i)}, nil
+	})
+	src.SourceFormat = Avro
+	data, _ := readSource(t, src)
+	_, rows := readAvroFile(t, data)
+	if len(rows) != n {
+		t.Fatalf("got %d rows, want %d", len(rows), n)
+	}
+	for i, r := range rows {
+		if r[0] != int64(i+1) {
+			t.Fatalf("row %d is %v", i, r)
+		}
+	}
+
+	// Without values, there is no schema.
+	src = sliceSource()
+	src.SourceFormat = Avro
+	if _, err := ioutil.ReadAll(src.populateLoadConfig(&bq.JobConfigurationLoad{})); err == nil {
+		t.Error("got nil, want error")
+	}
+}
+
+func TestValueSourceErrors(t *testing.T) {
+	for _, test := range []struct {
+		src  *ValueSource
+		want string
+	}{
+		{sliceSource(3), "is not a ValueSaver"},
+		{sliceSource(&ValuesSaver{Schema: Schema{{Name: "s", Type: StringFieldType}}, Row: []Value{1, 2}}), "schema does not match"},
+		{&ValueSource{next: sliceSource().next, FileConfig: FileConfig{SourceFormat: CSV}}, "cannot encode CSV"},
+		{func() *ValueSource {
+			s := sliceSource(&ValuesSaver{Schema: Schema{{Name: "i", Type: IntegerFieldType, Required: true}}, Row: []Value{nil}})
+			s.SourceFormat = Avro
+			return s
+		}(), "field i: required field is NULL"},
+		{NewValueSource(func() (interface{}, error) { return nil, errors.New("boom") }), "boom"},
+	} {
+		_, err := ioutil.ReadAll(test.src.populateLoadConfig(&bq.JobConfigurationLoad{}))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("got %v, want an error containing %q", err, test.want)
+		}
+	}
+}
+
+func TestValueSourceLoad(t *testing.T) {
+	ctx := context.Background()
+	srv := bqtest.NewServer()
+	defer srv.Close()
+	c, err := NewClient(ctx, "P", srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Dataset("d").Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	type item struct {
+		Name string
+		Num  int64
+	}
+	schema, err := InferSchema(item{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan item)
+	go func() {
+		for i := 0; i < 3; i++ {
+			ch <- item{Name: fmt.Sprint("n", i), Num: int64(i)}
+		}
+		close(ch)
+	}()
+	src := NewChannelSource(ch)
+	src.Schema = schema
+	tab := c.Dataset("d").Table("t")
+	job, err := tab.LoaderFrom(src).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err != nil {
+		t.Fatal(err)
+	}
+	it := tab.Read(ctx)
+	var got [][]Value
+	for {
+		var row []Value
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	want := [][]Value{{"n0", int64(0)}, {"n1", int64(1)}, {"n2", int64(2)}}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("-got, +want:\n%s", diff)
+	}
+
+	_, err = tab.LoaderFrom(NewValueSource(func() (interface{}, error) {
+		return nil, errors.New("no more items")
+	})).Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "no more items") {
+		t.Errorf("got %v, want the source's error", err)
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanner
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// DefaultMaxMutationsPerCommit is the default value of
+// BulkWriterConfig.MaxMutationsPerCommit. It is the maximum number of
+// mutations that Cloud Spanner accepts in a single commit.
+const DefaultMaxMutationsPerCommit = 20000
+
+// BulkWriterConfig configures a BulkWriter.
+type BulkWriterConfig struct {
+	// MaxMutationsPerCommit is the maximum number of mutations in a single
+	// commit, counted as described in the documentation of BulkWriter.
+	//
+	// Cloud Spanner also counts the changes to the secondary indexes of a
+	// table as mutations, which a BulkWriter cannot see. If the tables that
+	// are written have secondary indexes, set MaxMutationsPerCommit to a
+	// value below DefaultMaxMutationsPerCommit that leaves room for them.
+	//
+	// Defaults to DefaultMaxMutationsPerCommit.
+	MaxMutationsPerCommit int
+
+	// MaxConcurrentCommits is the maximum number of commits that are in
+	// progress at the same time. Write blocks while this many commits are in
+	// progress and the current batch is full.
+	//
+	// Defaults to 4.
+	MaxConcurrentCommits int
+
+	// KeyColumns, if non-nil, maps the names of tables, as they are given to
+	// mutations, to the names of their primary key columns, in order. The
+	// mutations of a row of these tables that are written one after the
+	// other are kept in the same commit, even if they are written in
+	// separate calls to Write: when a batch is full, the calls to Write at
+	// its end that write a row of the next call are moved to the next batch.
+	// If they do not fit in a batch with the next call, they are committed
+	// in the full batch, and the next call waits for that commit to finish
+	// before it starts a new batch.
+	//
+	// The row of an insert, update, insert-or-update or replace is found
+	// from the values of its key columns, and the rows of a delete are the
+	// keys of its KeySet; key ranges are not grouped. Mutations of a row
+	// that are separated by mutations of other rows may still be committed
+	// in different batches.
+	KeyColumns map[string][]string
+
+	// ApplyOptions are passed to Client.Apply for each commit.
+	ApplyOptions []ApplyOption
+
+	// OnCommit, if non-nil, is called with the result of each commit. It is
+	// called from the goroutine that ran the commit, so it may be called
+	// concurrently.
+	OnCommit func(*BulkCommitResult)
+}
+
+// BulkCommitResult is the result of committing one batch of mutations
+// written to a BulkWriter.
+type BulkCommitResult struct {
+	// Mutations are the mutations in the batch, in the order in which they
+	// were written.
+	Mutations []*Mutation
+
+	// CommitTimestamp is the commit timestamp of the batch, if it was
+	// committed successfully.
+	CommitTimestamp time.Time
+
+	// Err is the error that the commit returned, if any. None of the
+	// mutations in the batch have been applied if Err is non-nil.
+	Err error
+}
+
+// A BulkWriter writes an unbounded number of mutations to a database, in as
+// many commits as needed to stay under the limit that Cloud Spanner puts on
+// the number of mutations in a single commit.
+//
+// The mutations are counted as Cloud Spanner counts them: an insert, update,
+// insert-or-update or replace counts as one mutation for each of the columns
+// that it writes, and a delete counts as one mutation for each of the keys
+// and key ranges in its KeySet.
+//
+// Mutations are grouped into batches in the order in which they are written.
+// The mutations passed to a single call of Write are always committed in the
+// same batch, so to keep the mutations of one row together, for example an
+// insert followed by an update of the same key, write them in one call, or
+// set BulkWriterConfig.KeyColumns.
+// Different batches are committed concurrently and independently: if a commit
+// fails, the other batches are still committed, and batches may be committed
+// in a different order than the one in which they were written. Set
+// MaxConcurrentCommits to 1 to commit the batches in order.
+//
+// A BulkWriter is safe for concurrent use. Call Close when done with it.
+type BulkWriter struct {
+	c      *Client
+	ctx    context.Context
+	config BulkWriterConfig
+
+	// sem has a value for each commit in progress.
+	sem chan struct{}
+	// wg counts the commits in progress.
+	wg sync.WaitGroup
+
+	// mu protects the following fields.
+	mu sync.Mutex
+	// batch holds the mutations that have not yet been committed.
+	batch []*Mutation
+	// calls holds the number of mutations of each call to Write in batch.
+	calls []int
+	// batchSize is the number of mutations in batch, as counted by Spanner.
+	batchSize int
+	// err is the first error returned by a commit since the last Flush.
+	err error
+	// closed is true after Close has been called.
+	closed bool
+}
+
+// NewBulkWriter returns a BulkWriter that writes mutations to the database of
+// the client. The context is used for all commits made by the writer;
+// canceling it makes the pending and future commits fail.
+func (c *Client) NewBulkWriter(ctx context.Context, config BulkWriterConfig) *BulkWriter {
+	if config.MaxMutationsPerCommit <= 0 {
+		config.MaxMutationsPerCommit = DefaultMaxMutationsPerCommit
+	}
+	if config.MaxConcurrentCommits <= 0 {
+		config.MaxConcurrentCommits = 4
+	}
+	return &BulkWriter{
+		c:      c,
+		ctx:    ctx,
+		config: config,
+		sem:    make(chan struct{}, config.MaxConcurrentCommits),
+	}
+}
+
+// errBulkWriterClosed returns error for writing to a closed BulkWriter.
+func errBulkWriterClosed() error {
+	return spannerErrorf(codes.FailedPrecondition, "BulkWriter is closed")
+}
+
+// errTooManyMutations returns error for a group of mutations that is too
+// large for a single commit.
+func errTooManyMutations(n, max int) error {
+	return spannerErrorf(codes.InvalidArgument,
+		"a group of %d mutations exceeds BulkWriterConfig.MaxMutationsPerCommit (%d)", n, max)
+}
+
+// Write adds mutations to the writer. All the mutations passed to one call of
+// Write are committed in the same batch.
+//
+// Write commits the current batch when adding the mutations would make it
+// exceed MaxMutationsPerCommit. It blocks if MaxConcurrentCommits commits are
+// already in progress. It returns an error if the writer is closed, if its
+// context is done, or if the mutations are too many for a single commit. The
+// errors of the commits themselves are reported by Flush and Close, and to
+// BulkWriterConfig.OnCommit.
+func (w *BulkWriter) Write(ms ...*Mutation) error {
+	n := 0
+	for _, m := range ms {
+		n += mutationCount(m)
+	}
+	max := w.config.MaxMutationsPerCommit
+	if n > max {
+		return errTooManyMutations(n, max)
+	}
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return errBulkWriterClosed()
+		}
+		if w.batchSize+n <= max {
+			w.addLocked(ms, n)
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+
+		// The batch is full. Wait for a commit slot without holding w.mu, so
+		// that Flush, Close and the other calls to Write are not blocked
+		// behind this one.
+		if err := w.acquire(); err != nil {
+			return err
+		}
+		w.mu.Lock()
+		if w.closed || w.batchSize+n <= max {
+			// The writer was closed, or another call committed the batch,
+			// while this one waited: start over.
+			w.mu.Unlock()
+			w.release()
+			continue
+		}
+		// Keep the end of the batch that writes the rows of ms with ms, in
+		// the next batch.
+		carry, calls := w.rowTailLocked(ms)
+		carrySize := 0
+		for _, m := range carry {
+			carrySize += mutationCount(m)
+		}
+		if carrySize+n > max {
+			// ms does not fit in a batch with the mutations of its rows.
+			// Commit them in this batch, and wait for the commit to finish,
+			// so that ms is committed after them.
+			done := w.commitLocked()
+			w.mu.Unlock()
+			<-done
+			continue
+		}
+		w.batch = w.batch[:len(w.batch)-len(carry)]
+		w.calls = w.calls[:len(w.calls)-len(calls)]
+		w.batchSize -= carrySize
+		w.commitLocked()
+		w.batch = append(w.batch, carry...)
+		w.calls = append(w.calls, calls...)
+		w.batchSize += carrySize
+		w.addLocked(ms, n)
+		w.mu.Unlock()
+		return nil
+	}
+}
+
+// addLocked adds the n mutations of a call to Write to the batch. w.mu must
+// be held.
+func (w *BulkWriter) addLocked(ms []*Mutation, n int) {
+	w.batch = append(w.batch, ms...)
+	w.calls = append(w.calls, len(ms))
+	w.batchSize += n
+}
+
+// rowTailLocked returns the calls to Write at the end of the batch that write
+// a row of ms, or of a later call, and the number of mutations of each of
+// those calls. It returns nothing if KeyColumns is nil. w.mu must be held.
+func (w *BulkWriter) rowTailLocked(ms []*Mutation) (carry []*Mutation, calls []int) {
+	if w.config.KeyColumns == nil {
+		return nil, nil
+	}
+	rows := map[string]bool{}
+	addRows := func(ms []*Mutation) {
+		for _, m := range ms {
+			for _, r := range w.rowKeys(m) {
+				rows[r] = true
+			}
+		}
+	}
+	addRows(ms)
+	end, k := len(w.batch), len(w.calls)
+	for ; k > 0; k-- {
+		call := w.batch[end-w.calls[k-1] : end]
+		shared := false
+		for _, m := range call {
+			for _, r := range w.rowKeys(m) {
+				shared = shared || rows[r]
+			}
+		}
+		if !shared {
+			break
+		}
+		addRows(call)
+		end -= len(call)
+	}
+	// Copy the tail, since the batch is truncated and reused.
+	carry = append([]*Mutation(nil), w.batch[end:]...)
+	calls = append([]int(nil), w.calls[k:]...)
+	return carry, calls
+}
+
+// rowKeys returns the rows of the tables in KeyColumns that m writes, as
+// their table names and keys.
+func (w *BulkWriter) rowKeys(m *Mutation) []string {
+	cols, ok := w.config.KeyColumns[m.table]
+	if !ok {
+		return nil
+	}
+	if m.op == opDelete {
+		var rows []string
+		var add func(ks KeySet)
+		add = func(ks KeySet) {
+			switch ks := ks.(type) {
+			case union:
+				for _, s := range ks {
+					add(s)
+				}
+			case Key:
+				rows = append(rows, m.table+ks.String())
+			}
+		}
+		add(m.keySet)
+		return rows
+	}
+	key := make(Key, len(cols))
+	for i, c := range cols {
+		j := -1
+		for k, mc := range m.columns {
+			if strings.EqualFold(mc, c) {
+				j = k
+				break
+			}
+		}
+		if j < 0 {
+			return nil
+		}
+		key[i] = m.values[j]
+	}
+	return []string{m.table + key.String()}
+}
+
+// acquire waits for a commit slot. It must not be called with w.mu held.
+func (w *BulkWriter) acquire() error {
+	select {
+	case w.sem <- struct{}{}:
+		return nil
+	case <-w.ctx.Done():
+		return toSpannerError(w.ctx.Err())
+	}
+}
+
+// release releases a commit slot.
+func (w *BulkWriter) release() {
+	<-w.sem
+}
+
+// commitLocked starts committing the current batch, with a commit slot that
+// the caller acquired and that is released when the commit finishes. It
+// returns a channel that is closed when the commit finishes. w.mu must be
+// held.
+func (w *BulkWriter) commitLocked() <-chan struct{} {
+	done := make(chan struct{})
+	if len(w.batch) == 0 {
+		w.release()
+		close(done)
+		return done
+	}
+	batch := w.batch
+	w.batch = nil
+	w.calls = nil
+	w.batchSize = 0
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer close(done)
+		ts, err := w.c.Apply(w.ctx, batch, w.config.ApplyOptions...)
+		w.release()
+		if err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+		}
+		if w.config.OnCommit != nil {
+			w.config.OnCommit(&BulkCommitResult{Mutations: batch, CommitTimestamp: ts, Err: err})
+		}
+	}()
+	return done
+}
+
+// Flush commits the current batch and waits for all commits in progress to
+// finish. It returns the first error returned by a commit since the last call
+// to Flush.
+func (w *BulkWriter) Flush() error {
+	err := w.acquire()
+	if err == nil {
+		w.mu.Lock()
+		w.commitLocked()
+		w.mu.Unlock()
+	}
+	w.wg.Wait()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err == nil {
+		err = w.err
+	}
+	w.err = nil
+	return err
+}
+
+// Close closes the writer and flushes it. Calling Write after Close returns
+// an error. Close returns the same error as Flush.
+func (w *BulkWriter) Close() error {
+	// Close before flushing, so that no Write can add mutations that the
+	// flush would miss.
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	return w.Flush()
+}
+
+// mutationCount returns the number of mutations that Cloud Spanner counts for
+// m, not including the changes to secondary indexes.
+func mutationCount(m *Mutation) int {
+	if m.op == opDelete {
+		return keySetCount(m.keySet)
+	}
+	return len(m.columns)
+}
+
+// keySetCount returns the number of keys and key ranges in ks.
+func keySetCount(ks KeySet) int {
+	switch ks := ks.(type) {
+	case union:
+		n := 0
+		for _, s := range ks {
+			n += keySetCount(s)
+		}
+		return n
+	case nil:
+		return 0
+	default:
+		// A Key, a KeyRange or all keys.
+		return 1
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanner
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "cloud.google.com/go/spanner/internal/testutil"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMutationCount(t *testing.T) {
+	for _, test := range []struct {
+		m    *Mutation
+		want int
+	}{
+		{Insert("T", []string{"a", "b", "c"}, []interface{}{1, 2, 3}), 3},
+		{InsertOrUpdate("T", []string{"a"}, []interface{}{1}), 1},
+		{Update("T", []string{"a", "b"}, []interface{}{1, 2}), 2},
+		{Replace("T", []string{"a", "b"}, []interface{}{1, 2}), 2},
+		{Delete("T", Key{1}), 1},
+		{Delete("T", KeyRange{Start: Key{1}, End: Key{5}}), 1},
+		{Delete("T", AllKeys()), 1},
+		{Delete("T", KeySets(Key{1}, Key{2}, KeyRange{Start: Key{3}, End: Key{5}})), 3},
+		{Delete("T", KeySets()), 0},
+	} {
+		if got := mutationCount(test.m); got != test.want {
+			t.Errorf("mutationCount(%+v) = %d, want %d", test.m, got, test.want)
+		}
+	}
+}
+
+// committedBatches returns the number of mutations in each commit received by
+// the server.
+func committedBatches(server InMemSpannerServer) []int {
+	var batches []int
+	for _, req := range drainRequestsFromServer(server) {
+		if commit, ok := req.(*sppb.CommitRequest); ok {
+			batches = append(batches, len(commit.Mutations))
+		}
+	}
+	return batches
+}
+
+func TestBulkWriter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+
+	var (
+		mu      sync.Mutex
+		results []*BulkCommitResult
+	)
+	w := client.NewBulkWriter(ctx, BulkWriterConfig{
+		MaxMutationsPerCommit: 7,
+		MaxConcurrentCommits:  1,
+		OnCommit: func(r *BulkCommitResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, r)
+		},
+	})
+	row := func(i int) *Mutation {
+		return Insert("Accounts", []string{"AccountId", "Nickname", "Balance"}, []interface{}{int64(i), "a", int64(0)})
+	}
+	// Each row counts as 3 mutations, so a commit holds at most 2 rows.
+	for i := 0; i < 5; i++ {
+		if err := w.Write(row(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A group of mutations is not split across commits.
+	if err := w.Write(row(5), Update("Accounts", []string{"AccountId", "Balance"}, []interface{}{int64(5), int64(10)})); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(Delete("Accounts", Key{int64(0)})); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := committedBatches(server.TestSpanner), []int{2, 2, 1, 3}; !testEqual(got, want) {
+		t.Errorf("mutations per commit = %v, want %v", got, want)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d commit results, want 4", len(results))
+	}
+	for i, r := range results {
+		if r.Err != nil || r.CommitTimestamp.IsZero() {
+			t.Errorf("commit %d: got timestamp %v and error %v, want a timestamp and no error", i, r.CommitTimestamp, r.Err)
+		}
+	}
+
+	if err := w.Write(row(6)); ErrCode(err) != codes.FailedPrecondition {
+		t.Errorf("Write after Close returned %v, want FailedPrecondition", err)
+	}
+}
+
+func TestBulkWriterErrors(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+
+	w := client.NewBulkWriter(ctx, BulkWriterConfig{MaxMutationsPerCommit: 2, MaxConcurrentCommits: 1})
+	defer w.Close()
+	tooMany := Insert("Accounts", []string{"AccountId", "Nickname", "Balance"}, []interface{}{int64(1), "a", int64(0)})
+	if err := w.Write(tooMany); ErrCode(err) != codes.InvalidArgument {
+		t.Errorf("Write of too many mutations returned %v, want InvalidArgument", err)
+	}
+
+	server.TestSpanner.PutExecutionTime(MethodCommitTransaction, SimulatedExecutionTime{
+		Errors: []error{status.Error(codes.FailedPrecondition, "constraint violation")},
+	})
+	for i := 0; i < 4; i++ {
+		if err := w.Write(Delete("Accounts", Key{int64(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the first commit fails.
+	if err := w.Flush(); ErrCode(err) != codes.FailedPrecondition {
+		t.Errorf("Flush returned %v, want FailedPrecondition", err)
+	}
+	if got, want := committedBatches(server.TestSpanner), []int{2, 2}; !testEqual(got, want) {
+		t.Errorf("mutations per commit = %v, want %v", got, want)
+	}
+	// The error is only reported once.
+	if err := w.Flush(); err != nil {
+		t.Errorf("second Flush returned %v, want nil", err)
+	}
+}
+
+func TestBulkWriterKeyColumns(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+
+	w := client.NewBulkWriter(ctx, BulkWriterConfig{
+		MaxMutationsPerCommit: 7,
+		MaxConcurrentCommits:  1,
+		KeyColumns:            map[string][]string{"Accounts": {"AccountId"}},
+	})
+	row := func(i int) *Mutation {
+		return Insert("Accounts", []string{"AccountId", "Nickname", "Balance"}, []interface{}{int64(i), "a", int64(0)})
+	}
+	update := func(i int) *Mutation {
+		return Update("Accounts", []string{"Balance", "accountid"}, []interface{}{int64(10), int64(i)})
+	}
+	for _, m := range []*Mutation{
+		row(1),
+		row(2),
+		// Moves the insert of row 2 to the next batch.
+		update(2),
+		row(3),
+		Delete("Accounts", Key{int64(1)}),
+		Delete("Accounts", KeySets(Key{int64(3)}, Key{int64(4)})),
+		// Moves the delete of rows 3 and 4 to the next batch, but not the
+		// insert of row 3, which is separated from it by another row.
+		row(4),
+	} {
+		if err := w.Write(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The mutations of row 4 no longer fit in a single commit: the batch is
+	// committed with the insert of row 4, and the updates start a new one.
+	if err := w.Write(update(4), update(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := committedBatches(server.TestSpanner), []int{1, 2, 2, 2, 2}; !testEqual(got, want) {
+		t.Errorf("mutations per commit = %v, want %v", got, want)
+	}
+}
+
+// A Write whose mutations do not fit in a batch with the mutations of the
+// same rows waits for the commit of those mutations.
+func TestBulkWriterKeyColumnsWait(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+	server.TestSpanner.PutExecutionTime(MethodCommitTransaction, SimulatedExecutionTime{
+		MinimumExecutionTime: 50 * time.Millisecond,
+	})
+
+	var (
+		mu      sync.Mutex
+		results []*BulkCommitResult
+	)
+	w := client.NewBulkWriter(ctx, BulkWriterConfig{
+		MaxMutationsPerCommit: 4,
+		KeyColumns:            map[string][]string{"Accounts": {"AccountId"}},
+		OnCommit: func(r *BulkCommitResult) {
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		},
+	})
+	insert := Insert("Accounts", []string{"AccountId", "Nickname", "Balance"}, []interface{}{int64(1), "a", int64(0)})
+	if err := w.Write(insert); err != nil {
+		t.Fatal(err)
+	}
+	// The update fits in a batch on its own, but not with the insert.
+	update := Update("Accounts", []string{"AccountId", "Balance"}, []interface{}{int64(1), int64(10)})
+	if err := w.Write(update); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(results) != 1 || len(results[0].Mutations) != 1 || results[0].Mutations[0] != insert {
+		t.Errorf("Write returned before the commit of the insert; commits: %v", results)
+	}
+	mu.Unlock()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := committedBatches(server.TestSpanner), []int{1, 1}; !testEqual(got, want) {
+		t.Errorf("mutations per commit = %v, want %v", got, want)
+	}
+}
+
+// A Write that waits for a commit slot does not hold the writer's lock.
+func TestBulkWriterWaitForSlot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+	server.TestSpanner.PutExecutionTime(MethodCommitTransaction, SimulatedExecutionTime{
+		MinimumExecutionTime: time.Second,
+	})
+
+	w := client.NewBulkWriter(ctx, BulkWriterConfig{MaxMutationsPerCommit: 1, MaxConcurrentCommits: 1})
+	for i := 0; i < 2; i++ {
+		if err := w.Write(Delete("Accounts", Key{int64(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first batch is being committed; this Write waits for it.
+	written := make(chan error, 1)
+	go func() { written <- w.Write(Delete("Accounts", Key{int64(2)})) }()
+	time.Sleep(50 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		w.mu.Lock()
+		w.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-written:
+		t.Error("Write did not wait for the commit")
+	case <-time.After(500 * time.Millisecond):
+		t.Error("the writer's lock is held while Write waits for a commit slot")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil && ErrCode(err) != codes.FailedPrecondition {
+		t.Errorf("Write returned %v, want nil or FailedPrecondition", err)
+	}
+}
+
+// A Write during Close is either committed or returns an error.
+func TestBulkWriterWriteDuringClose(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+
+	var (
+		w        *BulkWriter
+		once     sync.Once
+		writeErr error
+	)
+	w = client.NewBulkWriter(ctx, BulkWriterConfig{
+		// Write again while Close waits for the first commit.
+		OnCommit: func(*BulkCommitResult) {
+			once.Do(func() { writeErr = w.Write(Delete("Accounts", Key{int64(2)})) })
+		},
+	})
+	if err := w.Write(Delete("Accounts", Key{int64(1)})); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	committed := 0
+	for _, n := range committedBatches(server.TestSpanner) {
+		committed += n
+	}
+	if writeErr == nil && committed != 2 {
+		t.Errorf("Write during Close succeeded, but %d of 2 mutations were committed", committed)
+	}
+}
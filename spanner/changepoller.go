@@ -0,0 +1,229 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/internal/trace"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// DefaultChangePollInterval is the default time between the polls of a
+	// ChangePoller.
+	DefaultChangePollInterval = time.Second
+
+	// DefaultChangePollStaleness is the default staleness of the reads of a
+	// ChangePoller.
+	DefaultChangePollStaleness = 15 * time.Second
+)
+
+// ChangePollerConfig configures a ChangePoller.
+type ChangePollerConfig struct {
+	// Table is the name of the table to poll. Required.
+	Table string
+
+	// CommitTimestampColumn is the name of a TIMESTAMP column of the table
+	// with the allow_commit_timestamp option, which is set to
+	// spanner.CommitTimestamp each time a row is written. Required.
+	CommitTimestampColumn string
+
+	// Columns are the columns to read. If empty, all the columns of the table
+	// are read.
+	Columns []string
+
+	// Start is the initial watermark: the poller returns the rows whose
+	// commit timestamp is after Start. Use the Watermark of a previous poller
+	// to resume polling where it stopped.
+	Start time.Time
+
+	// PollInterval is the time that Run waits between polls. Defaults to
+	// DefaultChangePollInterval.
+	PollInterval time.Duration
+
+	// Staleness is how far in the past the reads are done. Stale reads are
+	// faster than strong reads and do not block on in-flight writes, at the
+	// cost of seeing the changes later. Defaults to
+	// DefaultChangePollStaleness.
+	Staleness time.Duration
+}
+
+// A ChangePoller polls a table for the rows that were written since a
+// watermark, using a commit timestamp column of the table.
+//
+// Each poll reads the table at a single timestamp, with a stale read. As
+// Cloud Spanner assigns commit timestamps that are later than any read that
+// was done before the commit, the read sees every row committed at or before
+// its timestamp, and all transactions that are still in flight will commit
+// with a later timestamp. The timestamp of the read is thus a safe watermark:
+// the next poll returns the rows committed after it, and no change is missed.
+//
+// This guarantee only holds if the commit timestamp column is always written
+// with spanner.CommitTimestamp. Deleted rows are not returned.
+//
+// A ChangePoller is safe to use concurrently, but polls are not meant to
+// overlap.
+//
+// This API is EXPERIMENTAL and subject to change or removal without notice.
+type ChangePoller struct {
+	client   *Client
+	sql      string
+	interval time.Duration
+	tb       TimestampBound
+
+	mu        sync.Mutex
+	watermark time.Time
+}
+
+// NewChangePoller returns a ChangePoller for the table and commit timestamp
+// column in config.
+func (c *Client) NewChangePoller(config ChangePollerConfig) (*ChangePoller, error) {
+	if config.Table == "" || config.CommitTimestampColumn == "" {
+		return nil, spannerErrorf(codes.InvalidArgument, "ChangePollerConfig requires Table and CommitTimestampColumn")
+	}
+	names := append([]string{config.Table, config.CommitTimestampColumn}, config.Columns...)
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, "`\n") {
+			return nil, spannerErrorf(codes.InvalidArgument, "ChangePollerConfig: invalid identifier %q", name)
+		}
+	}
+	cols := "*"
+	if len(config.Columns) > 0 {
+		quoted := make([]string, len(config.Columns))
+		for i, col := range config.Columns {
+			quoted[i] = quoteIdentifier(col)
+		}
+		cols = strings.Join(quoted, ", ")
+	}
+	p := &ChangePoller{
+		client: c,
+		sql: fmt.Sprintf("SELECT %s FROM %s WHERE %s > @watermark ORDER BY %[3]s",
+			cols, quoteIdentifier(config.Table), quoteIdentifier(config.CommitTimestampColumn)),
+		interval:  config.PollInterval,
+		tb:        ExactStaleness(config.Staleness),
+		watermark: config.Start,
+	}
+	if p.interval <= 0 {
+		p.interval = DefaultChangePollInterval
+	}
+	if config.Staleness <= 0 {
+		p.tb = ExactStaleness(DefaultChangePollStaleness)
+	}
+	return p, nil
+}
+
+// quoteIdentifier quotes a table or column name with backticks, so that names
+// that are reserved keywords can be used in a query.
+func quoteIdentifier(name string) string {
+	return "`" + name + "`"
+}
+
+// Watermark returns the commit timestamp up to which all the changes have
+// been returned. Save it and pass it as ChangePollerConfig.Start to resume
+// polling later.
+func (p *ChangePoller) Watermark() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.watermark
+}
+
+// Poll reads the rows that were committed after the watermark, in commit
+// timestamp order, and advances the watermark past them. It returns no rows
+// if there were no changes.
+//
+// Poll advances the watermark before the caller processes the rows, so a
+// caller that saves the watermark should do it only after processing them.
+func (p *ChangePoller) Poll(ctx context.Context) (rows []*Row, err error) {
+	ctx = trace.StartSpan(ctx, "cloud.google.com/go/spanner.ChangePoller.Poll")
+	defer func() { trace.EndSpan(ctx, err) }()
+	rows, rts, err := p.poll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.advance(rts)
+	return rows, nil
+}
+
+// Run polls the table every PollInterval and calls f with each changed row,
+// in commit timestamp order, until ctx is done or f returns an error. It
+// returns the error of f or ctx, or the first error of a poll.
+//
+// The watermark advances once f has been called for all the rows of a poll,
+// so the changes are delivered at least once: if Run stops in the middle of a
+// poll, polling again from the watermark returns the rows of that poll again.
+func (p *ChangePoller) Run(ctx context.Context, f func(*Row) error) error {
+	for {
+		rows, rts, err := p.poll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if err := f(r); err != nil {
+				return err
+			}
+		}
+		p.advance(rts)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// poll reads the changed rows and returns them with the timestamp of the read.
+func (p *ChangePoller) poll(ctx context.Context) ([]*Row, time.Time, error) {
+	stmt := Statement{
+		SQL:    p.sql,
+		Params: map[string]interface{}{"watermark": p.Watermark()},
+	}
+	// A multi-use transaction returns its read timestamp when it begins, even
+	// if the query returns no rows.
+	txn := p.client.ReadOnlyTransaction().WithTimestampBound(p.tb)
+	defer txn.Close()
+	iter := txn.Query(ctx, stmt)
+	defer iter.Stop()
+	var rows []*Row
+	for {
+		r, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		rows = append(rows, r)
+	}
+	rts, err := txn.Timestamp()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return rows, rts, nil
+}
+
+// advance moves the watermark to t, if t is after it.
+func (p *ChangePoller) advance(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.After(p.watermark) {
+		p.watermark = t
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "cloud.google.com/go/spanner/internal/testutil"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// putChangedRows makes the mock server return a row for each id in ids for
+// the query of a change poller of the Albums table.
+func putChangedRows(server *MockedSpannerInMemTestServer, ids ...string) {
+	rs := &sppb.ResultSet{
+		Metadata: &sppb.ResultSetMetadata{
+			RowType: &sppb.StructType{
+				Fields: []*sppb.StructType_Field{{Name: "AlbumId", Type: &sppb.Type{Code: sppb.TypeCode_INT64}}},
+			},
+		},
+	}
+	for _, id := range ids {
+		rs.Rows = append(rs.Rows, &proto3.ListValue{Values: []*proto3.Value{stringProto(id)}})
+	}
+	server.TestSpanner.PutStatementResult(
+		"SELECT `AlbumId` FROM `Albums` WHERE `LastUpdateTime` > @watermark ORDER BY `LastUpdateTime`",
+		&StatementResult{Type: StatementResultResultSet, ResultSet: rs})
+}
+
+func newTestChangePoller(t *testing.T, client *Client, start time.Time) *ChangePoller {
+	p, err := client.NewChangePoller(ChangePollerConfig{
+		Table:                 "Albums",
+		CommitTimestampColumn: "LastUpdateTime",
+		Columns:               []string{"AlbumId"},
+		Start:                 start,
+		PollInterval:          time.Millisecond,
+		Staleness:             10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestChangePollerPoll(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+	putChangedRows(server, "1", "2")
+
+	start := time.Unix(1000, 0).UTC()
+	p := newTestChangePoller(t, client, start)
+	rows, err := p.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	first := p.Watermark()
+	if !first.After(start) {
+		t.Fatalf("watermark %v did not advance past %v", first, start)
+	}
+
+	var begins, queries int
+	for _, req := range drainRequestsFromServer(server.TestSpanner) {
+		switch req := req.(type) {
+		case *sppb.BeginTransactionRequest:
+			begins++
+			if got := req.Options.GetReadOnly().GetExactStaleness().GetSeconds(); got != 10 {
+				t.Errorf("got staleness %ds, want 10s", got)
+			}
+		case *sppb.ExecuteSqlRequest:
+			queries++
+			if got, want := req.Params.Fields["watermark"].GetStringValue(), start.Format(time.RFC3339Nano); got != want {
+				t.Errorf("got watermark param %q, want %q", got, want)
+			}
+		}
+	}
+	if begins != 1 || queries != 1 {
+		t.Errorf("got %d BeginTransaction and %d ExecuteSql requests, want 1 of each", begins, queries)
+	}
+
+	// The next poll starts from the new watermark.
+	putChangedRows(server)
+	rows, err = p.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("got %d rows, want none", len(rows))
+	}
+	for _, req := range drainRequestsFromServer(server.TestSpanner) {
+		if req, ok := req.(*sppb.ExecuteSqlRequest); ok {
+			if got, want := req.Params.Fields["watermark"].GetStringValue(), first.Format(time.RFC3339Nano); got != want {
+				t.Errorf("got watermark param %q, want %q", got, want)
+			}
+		}
+	}
+	if p.Watermark().Before(first) {
+		t.Errorf("watermark moved back from %v to %v", first, p.Watermark())
+	}
+}
+
+func TestChangePollerRun(t *testing.T) {
+	t.Parallel()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+	putChangedRows(server, "1", "2", "3")
+
+	// Run stops when f fails, without advancing the watermark past the rows
+	// of the poll.
+	start := time.Unix(1000, 0).UTC()
+	p := newTestChangePoller(t, client, start)
+	errStop := errors.New("stop")
+	var ids []int64
+	err := p.Run(context.Background(), func(r *Row) error {
+		var id int64
+		if err := r.Columns(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		if id == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("Run returned %v, want %v", err, errStop)
+	}
+	if len(ids) != 2 {
+		t.Errorf("got ids %v, want [1 2]", ids)
+	}
+	if !p.Watermark().Equal(start) {
+		t.Errorf("watermark advanced to %v after a failed poll", p.Watermark())
+	}
+
+	// Run stops when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	var n int
+	err = p.Run(ctx, func(r *Row) error {
+		n++
+		if n == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("Run returned %v, want %v", err, context.Canceled)
+	}
+	if !p.Watermark().After(start) {
+		t.Errorf("watermark did not advance after a complete poll")
+	}
+}
+
+func TestChangePollerConfigErrors(t *testing.T) {
+	t.Parallel()
+	_, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+	for _, config := range []ChangePollerConfig{
+		{},
+		{Table: "Albums"},
+		{CommitTimestampColumn: "LastUpdateTime"},
+		{Table: "Albums`; DELETE", CommitTimestampColumn: "LastUpdateTime"},
+		{Table: "Albums", CommitTimestampColumn: "LastUpdateTime", Columns: []string{""}},
+	} {
+		if _, err := client.NewChangePoller(config); ErrCode(err) != codes.InvalidArgument {
+			t.Errorf("NewChangePoller(%+v) returned %v, want code InvalidArgument", config, err)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	"cloud.google.com/go/internal/trace"
 	vkit "cloud.google.com/go/spanner/apiv1"
+	"github.com/googleapis/gax-go/v2"
 	"google.golang.org/api/option"
 	sppb "google.golang.org/genproto/googleapis/spanner/v1"
 	"google.golang.org/grpc"
@@ -194,6 +195,22 @@ func (c *Client) Close() {
 	c.sc.close()
 }
 
+// SessionPoolStats returns a snapshot of the state of the client's session
+// pool.
+// It is EXPERIMENTAL and subject to change or removal without notice.
+func (c *Client) SessionPoolStats() SessionPoolStats {
+	return c.idleSessions.stats()
+}
+
+// CheckedOutSessions returns the sessions that are currently checked out of
+// the client's session pool, in the order in which they were checked out,
+// together with the stack trace of the goroutine that checked them out. It
+// returns nil unless SessionPoolConfig.TrackSessionHandles is true.
+// It is EXPERIMENTAL and subject to change or removal without notice.
+func (c *Client) CheckedOutSessions() []CheckedOutSession {
+	return c.idleSessions.checkedOutSessions()
+}
+
 // Single provides a read-only snapshot transaction optimized for the case
 // where only a single read or query is needed.  This is more efficient than
 // using ReadOnlyTransaction() for a single read or query.
@@ -336,23 +353,38 @@ func checkNestedTxn(ctx context.Context) error {
 // returns an error other than ABORTED, ReadWriteTransaction will abort the
 // transaction and return the error.
 //
-// To limit the number of retries, set a deadline on the Context rather than
-// using a fixed limit on the number of attempts. ReadWriteTransaction will
-// retry as needed until that deadline is met.
+// To limit the number of retries, set a deadline on the Context, or use
+// ReadWriteTransactionWithOptions.
 //
 // See https://godoc.org/cloud.google.com/go/spanner#ReadWriteTransaction for
 // more details.
 func (c *Client) ReadWriteTransaction(ctx context.Context, f func(context.Context, *ReadWriteTransaction) error) (commitTimestamp time.Time, err error) {
+	return c.ReadWriteTransactionWithOptions(ctx, f)
+}
+
+// ReadWriteTransactionWithOptions is like ReadWriteTransaction, but its
+// retries are controlled by opts.
+//
+// Pass the TransactionMaxAttempts or TransactionMaxElapsedTime options to
+// limit the number of attempts. When a limit set by an option is reached,
+// ReadWriteTransactionWithOptions returns the last ABORTED error. The delay
+// between attempts can be changed with TransactionBackoff, and
+// TransactionRetryHook observes the retries, for example to record them.
+func (c *Client) ReadWriteTransactionWithOptions(ctx context.Context, f func(context.Context, *ReadWriteTransaction) error, opts ...TransactionOption) (commitTimestamp time.Time, err error) {
 	ctx = trace.StartSpan(ctx, "cloud.google.com/go/spanner.ReadWriteTransaction")
 	defer func() { trace.EndSpan(ctx, err) }()
 	if err := checkNestedTxn(ctx); err != nil {
 		return time.Time{}, err
 	}
+	to := &transactionOption{backoff: DefaultRetryBackoff}
+	for _, opt := range opts {
+		opt(to)
+	}
 	var (
 		ts time.Time
 		sh *sessionHandle
 	)
-	err = runWithRetryOnAborted(ctx, func(ctx context.Context) error {
+	err = runWithRetryPolicyOnAborted(ctx, to, func(ctx context.Context) error {
 		var (
 			err error
 			t   *ReadWriteTransaction
@@ -388,6 +420,76 @@ func (c *Client) ReadWriteTransaction(ctx context.Context, f func(context.Contex
 	return ts, err
 }
 
+// transactionOption controls the behavior of
+// Client.ReadWriteTransactionWithOptions.
+type transactionOption struct {
+	// maxAttempts is the maximum number of attempts, or 0 for no limit.
+	maxAttempts int
+	// maxElapsedTime is the time after which the transaction is not retried,
+	// or 0 for no limit.
+	maxElapsedTime time.Duration
+	// backoff computes the delays between attempts, unless Cloud Spanner
+	// returns a delay with the ABORTED error.
+	backoff gax.Backoff
+	// onRetry, if not nil, is called before each retry.
+	onRetry func(TransactionRetry)
+}
+
+// A TransactionOption is an optional argument to
+// ReadWriteTransactionWithOptions.
+type TransactionOption func(*transactionOption)
+
+// TransactionRetry describes the retry of an aborted read-write transaction.
+type TransactionRetry struct {
+	// Attempt is the number of the attempt that was aborted, starting at 1.
+	Attempt int
+	// Err is the ABORTED error that ended the attempt.
+	Err error
+	// Delay is the time that the transaction waits before the next attempt.
+	Delay time.Duration
+}
+
+// TransactionMaxAttempts returns a TransactionOption that limits the number of
+// times that ReadWriteTransactionWithOptions calls its function to n. A value
+// of zero or less means no limit, which is the default.
+func TransactionMaxAttempts(n int) TransactionOption {
+	return func(to *transactionOption) {
+		to.maxAttempts = n
+	}
+}
+
+// TransactionMaxElapsedTime returns a TransactionOption that stops
+// ReadWriteTransactionWithOptions from retrying an aborted transaction if the
+// next attempt would start more than d after the first one. Unlike a deadline
+// on the Context, it does not interrupt an attempt that is in progress. A value
+// of zero or less means no limit, which is the default.
+func TransactionMaxElapsedTime(d time.Duration) TransactionOption {
+	return func(to *transactionOption) {
+		to.maxElapsedTime = d
+	}
+}
+
+// TransactionBackoff returns a TransactionOption that sets the backoff used
+// to compute the delay before ReadWriteTransactionWithOptions retries an
+// aborted transaction. A delay returned by Cloud Spanner with the ABORTED error
+// takes precedence over the backoff. The default is DefaultRetryBackoff.
+func TransactionBackoff(bo gax.Backoff) TransactionOption {
+	return func(to *transactionOption) {
+		to.backoff = bo
+	}
+}
+
+// TransactionRetryHook returns a TransactionOption that makes
+// ReadWriteTransactionWithOptions call f each time it retries an aborted
+// transaction, before it waits for the retry delay. f is not called for the
+// attempt that reaches a limit set by TransactionMaxAttempts or
+// TransactionMaxElapsedTime.
+func TransactionRetryHook(f func(TransactionRetry)) TransactionOption {
+	return func(to *transactionOption) {
+		to.onRetry = f
+	}
+}
+
 // applyOption controls the behavior of Client.Apply.
 type applyOption struct {
 	// If atLeastOnce == true, Client.Apply will execute the mutations on Cloud
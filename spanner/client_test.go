@@ -26,6 +26,7 @@ import (
 
 	itestutil "cloud.google.com/go/internal/testutil"
 	. "cloud.google.com/go/spanner/internal/testutil"
+	"github.com/googleapis/gax-go/v2"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
@@ -550,6 +551,102 @@ func testReadWriteTransaction(t *testing.T, executionTimes map[string]SimulatedE
 	return nil
 }
 
+func TestClient_ReadWriteTransactionMaxAttempts(t *testing.T) {
+	t.Parallel()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+	server.TestSpanner.PutExecutionTime(MethodCommitTransaction, SimulatedExecutionTime{
+		Errors: []error{
+			status.Error(codes.Aborted, "Aborted"),
+			status.Error(codes.Aborted, "Aborted"),
+			status.Error(codes.Aborted, "Aborted"),
+		},
+	})
+	var attempts int
+	var retries []TransactionRetry
+	_, err := client.ReadWriteTransactionWithOptions(context.Background(), func(ctx context.Context, tx *ReadWriteTransaction) error {
+		attempts++
+		return tx.BufferWrite([]*Mutation{Insert("Accounts", []string{"AccountId"}, []interface{}{int64(1)})})
+	},
+		TransactionMaxAttempts(2),
+		TransactionBackoff(gax.Backoff{Initial: time.Millisecond, Max: time.Millisecond}),
+		TransactionRetryHook(func(r TransactionRetry) { retries = append(retries, r) }),
+	)
+	if ErrCode(err) != codes.Aborted {
+		t.Fatalf("got error %v, want code Aborted", err)
+	}
+	if !strings.Contains(ErrDesc(err), "after 2 attempts") {
+		t.Errorf("error description %q does not mention the attempts", ErrDesc(err))
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+	if len(retries) != 1 || retries[0].Attempt != 1 || ErrCode(retries[0].Err) != codes.Aborted {
+		t.Errorf("got retries %+v, want one retry of attempt 1", retries)
+	}
+}
+
+func TestClient_ReadWriteTransactionMaxElapsedTime(t *testing.T) {
+	t.Parallel()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+	server.TestSpanner.PutExecutionTime(MethodCommitTransaction, SimulatedExecutionTime{
+		Errors: []error{status.Error(codes.Aborted, "Aborted")},
+	})
+	var attempts int
+	start := time.Now()
+	_, err := client.ReadWriteTransactionWithOptions(context.Background(), func(ctx context.Context, tx *ReadWriteTransaction) error {
+		attempts++
+		return nil
+	},
+		TransactionMaxElapsedTime(50*time.Millisecond),
+		TransactionBackoff(gax.Backoff{Initial: time.Minute, Max: time.Minute}),
+	)
+	if ErrCode(err) != codes.Aborted {
+		t.Fatalf("got error %v, want code Aborted", err)
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, want 1", attempts)
+	}
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("ReadWriteTransactionWithOptions waited %v for a retry it should not make", d)
+	}
+}
+
+func TestClient_ReadWriteTransactionRetryHook(t *testing.T) {
+	t.Parallel()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+	server.TestSpanner.PutExecutionTime(MethodCommitTransaction, SimulatedExecutionTime{
+		Errors: []error{
+			status.Error(codes.Aborted, "Aborted"),
+			status.Error(codes.Aborted, "Aborted"),
+		},
+	})
+	var retries []TransactionRetry
+	_, err := client.ReadWriteTransactionWithOptions(context.Background(), func(ctx context.Context, tx *ReadWriteTransaction) error {
+		return nil
+	},
+		TransactionMaxAttempts(3),
+		TransactionBackoff(gax.Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond}),
+		TransactionRetryHook(func(r TransactionRetry) { retries = append(retries, r) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 2 {
+		t.Fatalf("got %d retries, want 2", len(retries))
+	}
+	for i, r := range retries {
+		if r.Attempt != i+1 {
+			t.Errorf("retry %d: got attempt %d, want %d", i, r.Attempt, i+1)
+		}
+		if r.Delay > 2*time.Millisecond {
+			t.Errorf("retry %d: got delay %v, want at most 2ms", i, r.Delay)
+		}
+	}
+}
+
 func TestClient_ApplyAtLeastOnce(t *testing.T) {
 	t.Parallel()
 	server, client, teardown := setupMockedTestServer(t)
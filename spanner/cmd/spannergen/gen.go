@@ -0,0 +1,268 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"cloud.google.com/go/spanner/spansql"
+)
+
+// generate returns the Go source of package pkg for the tables in ddl.
+func generate(ddl spansql.DDL, pkg string) ([]byte, error) {
+	var body bytes.Buffer
+	imports := map[string]bool{
+		"context":                     true,
+		"cloud.google.com/go/spanner": true,
+	}
+	names := map[string]string{} // Go name to table name
+	for _, stmt := range ddl.List {
+		ct, ok := stmt.(spansql.CreateTable)
+		if !ok {
+			continue
+		}
+		t, err := newTable(&ct)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range []string{t.GoName, t.GoName + "Table", t.GoName + "Columns", t.GoName + "Key", "Read" + t.GoName} {
+			if other, ok := names[n]; ok {
+				return nil, fmt.Errorf("tables %s and %s both need the Go name %s", other, ct.Name, n)
+			}
+			names[n] = ct.Name
+		}
+		for _, c := range t.Columns {
+			if imp := c.importPath(); imp != "" {
+				imports[imp] = true
+			}
+		}
+		t.write(&body)
+	}
+	if _, ok := names["RowReader"]; ok {
+		return nil, fmt.Errorf("table %s needs the Go name RowReader", names["RowReader"])
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by spannergen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	var paths []string
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	buf.WriteString("import (\n")
+	for _, p := range paths {
+		fmt.Fprintf(&buf, "\t%q\n", p)
+	}
+	buf.WriteString(")\n\n")
+	buf.WriteString(`// RowReader reads a single row. It is implemented by the transactions of
+// package spanner, such as *spanner.ReadOnlyTransaction and
+// *spanner.ReadWriteTransaction.
+type RowReader interface {
+	ReadRow(ctx context.Context, table string, key spanner.Key, columns []string) (*spanner.Row, error)
+}
+`)
+	buf.Write(body.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v", err)
+	}
+	return src, nil
+}
+
+// A table is a table of the schema, with the Go names of its parts.
+type table struct {
+	Name    string
+	GoName  string
+	Columns []*column
+	Key     []*column
+}
+
+// A column is a column of a table, with its Go name and type.
+type column struct {
+	Name    string
+	GoName  string
+	VarName string // the name of the column as a function parameter
+	Type    spansql.Type
+	NotNull bool
+}
+
+func newTable(ct *spansql.CreateTable) (*table, error) {
+	t := &table{Name: ct.Name, GoName: exported(ct.Name)}
+	byName := map[string]*column{}
+	goNames := map[string]string{}
+	for _, cd := range ct.Columns {
+		c := &column{
+			Name:    cd.Name,
+			GoName:  exported(cd.Name),
+			VarName: unexported(cd.Name),
+			Type:    cd.Type,
+			NotNull: cd.NotNull,
+		}
+		if other, ok := goNames[c.GoName]; ok {
+			return nil, fmt.Errorf("table %s: columns %s and %s both have the Go name %s", ct.Name, other, cd.Name, c.GoName)
+		}
+		if c.GoName == "Key" || c.GoName == "Insert" || c.GoName == "InsertOrUpdate" {
+			return nil, fmt.Errorf("table %s: the Go name of column %s conflicts with a generated method", ct.Name, cd.Name)
+		}
+		goNames[c.GoName] = cd.Name
+		byName[strings.ToLower(cd.Name)] = c
+		t.Columns = append(t.Columns, c)
+	}
+	for _, kp := range ct.PrimaryKey {
+		c, ok := byName[strings.ToLower(kp.Column)]
+		if !ok {
+			return nil, fmt.Errorf("table %s: unknown primary key column %s", ct.Name, kp.Column)
+		}
+		t.Key = append(t.Key, c)
+	}
+	return t, nil
+}
+
+func (t *table) write(buf *bytes.Buffer) {
+	recv := "r"
+	p := func(format string, args ...interface{}) { fmt.Fprintf(buf, format, args...) }
+
+	p("\n// %s is a row of the %s table.\n", t.GoName, t.Name)
+	p("type %s struct {\n", t.GoName)
+	for _, c := range t.Columns {
+		p("\t%s %s `spanner:%q`\n", c.GoName, c.goType(), c.Name)
+	}
+	p("}\n\n")
+
+	p("// %sTable is the name of the %[1]s table.\n", t.GoName)
+	p("const %sTable = %q\n\n", t.GoName, t.Name)
+
+	p("// %sColumns are the columns of the %s table, in the order of the fields of %[1]s.\n", t.GoName, t.Name)
+	p("var %sColumns = []string{", t.GoName)
+	for i, c := range t.Columns {
+		if i > 0 {
+			p(", ")
+		}
+		p("%q", c.Name)
+	}
+	p("}\n\n")
+
+	var params, args, fields []string
+	for _, c := range t.Key {
+		params = append(params, c.VarName+" "+c.goType())
+		args = append(args, c.VarName)
+		fields = append(fields, recv+"."+c.GoName)
+	}
+	p("// %sKey returns the primary key of a row of the %s table.\n", t.GoName, t.Name)
+	p("func %sKey(%s) spanner.Key {\n", t.GoName, strings.Join(params, ", "))
+	p("\treturn spanner.Key{%s}\n}\n\n", strings.Join(args, ", "))
+
+	p("// Key returns the primary key of %s.\n", recv)
+	p("func (%s *%s) Key() spanner.Key {\n", recv, t.GoName)
+	p("\treturn spanner.Key{%s}\n}\n\n", strings.Join(fields, ", "))
+
+	p("// Read%s reads the row of the %s table with the given key.\n", t.GoName, t.Name)
+	p("func Read%s(ctx context.Context, rr RowReader, key spanner.Key) (*%[1]s, error) {\n", t.GoName)
+	p("\trow, err := rr.ReadRow(ctx, %sTable, key, %[1]sColumns)\n", t.GoName)
+	p("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	p("\t%s := &%s{}\n", recv, t.GoName)
+	p("\tif err := row.ToStruct(%s); err != nil {\n\t\treturn nil, err\n\t}\n", recv)
+	p("\treturn %s, nil\n}\n\n", recv)
+
+	var vals []string
+	for _, c := range t.Columns {
+		vals = append(vals, recv+"."+c.GoName)
+	}
+	for _, op := range []string{"Insert", "InsertOrUpdate"} {
+		p("// %s returns a mutation that writes %s to the %s table with spanner.%[1]s.\n", op, recv, t.Name)
+		p("func (%s *%s) %s() *spanner.Mutation {\n", recv, t.GoName, op)
+		p("\treturn spanner.%s(%sTable, %[2]sColumns, []interface{}{%s})\n}\n\n", op, t.GoName, strings.Join(vals, ", "))
+	}
+}
+
+// goType returns the Go type of the column. NOT NULL columns have plain Go
+// types, and nullable ones have the Null types of package spanner. The
+// elements of arrays may always be NULL.
+func (c *column) goType() string {
+	var plain, null string
+	switch c.Type.Base {
+	case spansql.Bool:
+		plain, null = "bool", "spanner.NullBool"
+	case spansql.Int64:
+		plain, null = "int64", "spanner.NullInt64"
+	case spansql.Float64:
+		plain, null = "float64", "spanner.NullFloat64"
+	case spansql.String:
+		plain, null = "string", "spanner.NullString"
+	case spansql.Bytes:
+		// A nil []byte is NULL.
+		plain, null = "[]byte", "[]byte"
+	case spansql.Date:
+		plain, null = "civil.Date", "spanner.NullDate"
+	case spansql.Timestamp:
+		plain, null = "time.Time", "spanner.NullTime"
+	default:
+		// Unknown types can be read and written as generic column values.
+		plain, null = "spanner.GenericColumnValue", "spanner.GenericColumnValue"
+	}
+	if c.Type.Array {
+		return "[]" + null
+	}
+	if c.NotNull {
+		return plain
+	}
+	return null
+}
+
+// importPath returns the path of the package, other than spanner, that the
+// Go type of the column needs, if any.
+func (c *column) importPath() string {
+	switch t := c.goType(); {
+	case strings.HasSuffix(t, "civil.Date"):
+		return "cloud.google.com/go/civil"
+	case strings.HasSuffix(t, "time.Time"):
+		return "time"
+	}
+	return ""
+}
+
+// exported returns name as an exported Go identifier.
+func exported(name string) string {
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[n:]
+}
+
+// reserved holds the names that parameters of generated functions must not
+// shadow.
+var reserved = map[string]bool{
+	"ctx": true, "rr": true, "context": true, "spanner": true, "civil": true, "time": true,
+}
+
+// unexported returns name as an unexported Go identifier that is not a
+// keyword.
+func unexported(name string) string {
+	r, n := utf8.DecodeRuneInString(name)
+	s := string(unicode.ToLower(r)) + name[n:]
+	if token.Lookup(s).IsKeyword() || reserved[s] {
+		s += "_"
+	}
+	return s
+}
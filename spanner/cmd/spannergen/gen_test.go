@@ -0,0 +1,131 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/spanner/spansql"
+)
+
+const testSchema = `
+CREATE TABLE Singers (
+  SingerId INT64 NOT NULL,
+  FirstName STRING(1024),
+  LastName STRING(1024) NOT NULL,
+  SingerInfo BYTES(MAX),
+  Birthday DATE NOT NULL,
+  Updated TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp = true),
+) PRIMARY KEY (SingerId);
+
+CREATE TABLE Albums (
+  SingerId INT64 NOT NULL,
+  AlbumId INT64 NOT NULL,
+  type STRING(MAX),
+  Ratings ARRAY<FLOAT64>,
+) PRIMARY KEY (SingerId, AlbumId DESC),
+  INTERLEAVE IN PARENT Singers ON DELETE CASCADE;
+
+CREATE INDEX AlbumsByType ON Albums(type);
+`
+
+func TestGenerate(t *testing.T) {
+	ddl, err := spansql.ParseDDL(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(ddl, "music")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "music.go", src, 0); err != nil {
+		t.Fatalf("generated code does not parse: %v\n%s", err, src)
+	}
+	got := string(src)
+	for _, want := range []string{
+		"// Code generated by spannergen. DO NOT EDIT.",
+		"package music",
+		`"cloud.google.com/go/civil"`,
+		`"time"`,
+		"type Singers struct {",
+		"SingerId   int64              `spanner:\"SingerId\"`",
+		"FirstName  spanner.NullString `spanner:\"FirstName\"`",
+		"LastName   string             `spanner:\"LastName\"`",
+		"SingerInfo []byte             `spanner:\"SingerInfo\"`",
+		"Birthday   civil.Date         `spanner:\"Birthday\"`",
+		"Updated    time.Time          `spanner:\"Updated\"`",
+		`const SingersTable = "Singers"`,
+		`var SingersColumns = []string{"SingerId", "FirstName", "LastName", "SingerInfo", "Birthday", "Updated"}`,
+		"func SingersKey(singerId int64) spanner.Key {",
+		"func ReadSingers(ctx context.Context, rr RowReader, key spanner.Key) (*Singers, error) {",
+		"func (r *Singers) InsertOrUpdate() *spanner.Mutation {",
+		"Type     spanner.NullString    `spanner:\"type\"`",
+		"Ratings  []spanner.NullFloat64 `spanner:\"Ratings\"`",
+		"func AlbumsKey(singerId int64, albumId int64) spanner.Key {",
+		"return spanner.Key{r.SingerId, r.AlbumId}",
+		"return spanner.Insert(AlbumsTable, AlbumsColumns, []interface{}{r.SingerId, r.AlbumId, r.Type, r.Ratings})",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("generated code does not contain %q", want)
+		}
+	}
+	if strings.Contains(got, "AlbumsByType") {
+		t.Error("generated code for a CREATE INDEX statement")
+	}
+	if t.Failed() {
+		t.Logf("generated code:\n%s", src)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, schema := range []string{
+		// Columns whose Go names collide.
+		`CREATE TABLE T (a INT64, A INT64) PRIMARY KEY (a)`,
+		// A column whose Go name collides with a method.
+		`CREATE TABLE T (Key INT64) PRIMARY KEY (Key)`,
+		// Tables whose Go names collide.
+		`CREATE TABLE t (A INT64) PRIMARY KEY (A); CREATE TABLE T (A INT64) PRIMARY KEY (A)`,
+		// A primary key column that is not in the table.
+		`CREATE TABLE T (A INT64) PRIMARY KEY (B)`,
+	} {
+		ddl, err := spansql.ParseDDL(schema)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", schema, err)
+		}
+		if _, err := generate(ddl, "p"); err == nil {
+			t.Errorf("generate(%q) succeeded, want error", schema)
+		}
+	}
+}
+
+func TestUnexported(t *testing.T) {
+	for in, want := range map[string]string{
+		"SingerId": "singerId",
+		"type":     "type_",
+		"Func":     "func_",
+		"ctx":      "ctx_",
+		"Time":     "time_",
+		"x":        "x",
+	} {
+		if got := unexported(in); got != want {
+			t.Errorf("unexported(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
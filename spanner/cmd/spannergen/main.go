@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Command spannergen generates Go code for the tables of a Cloud Spanner schema.
+
+Usage:
+
+	spannergen [-package name] [-o file] schema.sql
+
+It reads the DDL statements in the schema file, and for each CREATE TABLE
+statement it writes a struct type named after the table, with a field for each
+column, that can be used with Row.ToStruct and the struct mutation functions.
+Alongside the struct, it writes the names of the table and its columns, a
+function that constructs the primary key of a row, a function that reads a row
+by its key, and methods that return Insert and InsertOrUpdate mutations for a
+row.
+
+NOT NULL columns have plain Go types, such as int64 or string, and nullable
+columns have the spanner.NullInt64 family of types. The other DDL statements
+in the file are ignored.
+
+The generated code is written to standard output, or to the file named by -o.
+A typical use is in a go:generate directive:
+
+	//go:generate spannergen -package db -o tables.go schema.sql
+
+This command is EXPERIMENTAL and subject to change or removal without notice.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"cloud.google.com/go/spanner/spansql"
+)
+
+var (
+	pkgName = flag.String("package", "db", "name of the package of the generated code")
+	outFile = flag.String("o", "", "file to write the generated code to, instead of standard output")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("spannergen: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: spannergen [-package name] [-o file] schema.sql\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	b, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ddl, err := spansql.ParseDDL(string(b))
+	if err != nil {
+		log.Fatalf("parsing %s: %v", flag.Arg(0), err)
+	}
+	src, err := generate(ddl, *pkgName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *outFile == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = ioutil.WriteFile(*outFile, src, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -304,6 +304,16 @@ mutations, which will all be executed at the end of the transaction:
         return nil
     })
 
+By default, aborted transactions are retried until the Context is done. Pass
+TransactionOption values to ReadWriteTransactionWithOptions to limit the
+retries or to observe them:
+
+    _, err := client.ReadWriteTransactionWithOptions(ctx, f,
+        spanner.TransactionMaxAttempts(3),
+        spanner.TransactionRetryHook(func(r spanner.TransactionRetry) {
+            log.Printf("attempt %d aborted: %v", r.Attempt, r.Err)
+        }))
+
 
 Structs
 
@@ -332,6 +342,33 @@ slice of pointers to a Go struct type can be used to specify an array of
 NULL-able STRUCT values.
 
 
+Custom Types
+
+A Go type that is not supported directly can implement Encoder to convert
+itself to a supported type when it is written, and Decoder to convert itself
+from one when it is read. For example, a money amount stored as an INT64
+number of cents:
+
+    type Amount struct{ cents int64 }
+
+    func (a Amount) EncodeSpanner() (interface{}, error) {
+        return a.cents, nil
+    }
+
+    func (a *Amount) DecodeSpanner(input interface{}) error {
+        cents, ok := input.(int64)
+        if !ok {
+            return fmt.Errorf("unexpected type %T for Amount", input)
+        }
+        a.cents = cents
+        return nil
+    }
+
+Such types can then be used as Statement parameters, in mutations (including
+as fields of structs passed to InsertStruct and related functions), and as
+destinations for Row.Column and Row.ToStruct.
+
+
 DML and Partitioned DML
 
 Spanner supports DML statements like INSERT, UPDATE and DELETE. Use
@@ -351,5 +388,20 @@ This client has been instrumented to use OpenCensus tracing
 (http://opencensus.io). To enable tracing, see "Enabling Tracing for a Program"
 at https://godoc.org/go.opencensus.io/trace. OpenCensus tracing requires Go 1.8
 or higher.
+
+
+Session Pool Metrics
+
+The client records the state of its session pool as OpenCensus stats; see
+OpenSessionCountView and the other views in this package. The number of open
+sessions is recorded each time it changes; the number of idle and in-use
+sessions and of waiting requests is sampled once a minute. To register the
+views, call view.Register, for example:
+
+    view.Register(spanner.OpenSessionCountView, spanner.InUseSessionCountView)
+
+Client.SessionPoolStats returns a snapshot of the same state. If requests fail
+because no session became available in time, set
+SessionPoolConfig.TrackSessionHandles to find out which code holds the sessions.
 */
 package spanner // import "cloud.google.com/go/spanner"
@@ -205,6 +205,30 @@ func ExampleClient_Apply() {
 	}
 }
 
+func ExampleClient_NewBulkWriter() {
+	ctx := context.Background()
+	client, err := spanner.NewClient(ctx, myDB)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	w := client.NewBulkWriter(ctx, spanner.BulkWriterConfig{
+		OnCommit: func(r *spanner.BulkCommitResult) {
+			if r.Err != nil {
+				// TODO: Handle the failed batch, r.Mutations.
+			}
+		},
+	})
+	for i := 0; i < 1000000; i++ {
+		m := spanner.InsertOrUpdate("Users", []string{"name", "email"}, []interface{}{fmt.Sprint("user", i), nil})
+		if err := w.Write(m); err != nil {
+			// TODO: Handle error.
+		}
+	}
+	if err := w.Close(); err != nil {
+		// TODO: Handle error.
+	}
+}
+
 func ExampleInsert() {
 	m := spanner.Insert("Users", []string{"name", "email"}, []interface{}{"alice", "a@example.com"})
 	_ = m // TODO: use with Client.Apply or in a ReadWriteTransaction.
@@ -634,6 +658,79 @@ func ExampleClient_BatchReadOnlyTransaction() {
 	wg.Wait()
 }
 
+func ExampleClient_NewPartitionedQuery() {
+	ctx := context.Background()
+	client, err := spanner.NewClient(ctx, myDB)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer client.Close()
+
+	// loadCheckpoint and saveCheckpoint read and write durable storage, such
+	// as a file or a Cloud Storage object.
+	var loadCheckpoint func() ([]byte, error)
+	var saveCheckpoint func(ctx context.Context, data []byte) error
+
+	q := &spanner.PartitionedQuery{}
+	data, err := loadCheckpoint()
+	if err != nil {
+		// TODO: Handle error.
+	}
+	if data != nil {
+		// Resume a previous run.
+		if err := q.UnmarshalBinary(data); err != nil {
+			// TODO: Handle error.
+		}
+	} else {
+		stmt := spanner.Statement{SQL: "SELECT * FROM Singers"}
+		q, err = client.NewPartitionedQuery(ctx, spanner.StrongRead(), stmt, spanner.PartitionOptions{})
+		if err != nil {
+			// TODO: Handle error.
+		}
+	}
+	config := spanner.PartitionedQueryConfig{Workers: 8, Checkpoint: saveCheckpoint}
+	err = q.Run(ctx, client, config, func(partition int, row *spanner.Row) error {
+		return nil // TODO: Process the row.
+	})
+	if err != nil {
+		// TODO: Handle error.
+	}
+	q.Cleanup(ctx, client)
+}
+
+func ExampleClient_NewChangePoller() {
+	ctx := context.Background()
+	client, err := spanner.NewClient(ctx, myDB)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer client.Close()
+
+	// The watermark is saved after each batch of changes, and polling resumes
+	// from it.
+	var watermark time.Time
+	p, err := client.NewChangePoller(spanner.ChangePollerConfig{
+		Table:                 "Singers",
+		CommitTimestampColumn: "LastUpdated",
+		Columns:               []string{"SingerId", "FirstName", "LastName"},
+		Start:                 watermark,
+	})
+	if err != nil {
+		// TODO: Handle error.
+	}
+	for {
+		rows, err := p.Poll(ctx)
+		if err != nil {
+			// TODO: Handle error.
+		}
+		for _, row := range rows {
+			_ = row // TODO: Process the row.
+		}
+		watermark = p.Watermark() // TODO: Save the watermark.
+		time.Sleep(time.Second)
+	}
+}
+
 func ExampleCommitTimestamp() {
 	ctx := context.Background()
 	client, err := spanner.NewClient(ctx, myDB)
@@ -62,6 +62,7 @@ const (
 	MethodGetSession          string = "GET_SESSION"
 	MethodExecuteSql          string = "EXECUTE_SQL"
 	MethodExecuteStreamingSql string = "EXECUTE_STREAMING_SQL"
+	MethodPartitionQuery      string = "PARTITION_QUERY"
 )
 
 // StatementResult represents a mocked result on the test server. The result is
@@ -907,15 +908,35 @@ func (s *inMemSpannerServer) Rollback(ctx context.Context, req *spannerpb.Rollba
 	return &emptypb.Empty{}, nil
 }
 
+// PartitionQuery returns PartitionOptions.MaxPartitions partitions, or a single
+// partition if MaxPartitions is not set. Executing any of the partitions
+// returns the result registered for the query.
 func (s *inMemSpannerServer) PartitionQuery(ctx context.Context, req *spannerpb.PartitionQueryRequest) (*spannerpb.PartitionResponse, error) {
-	s.mu.Lock()
-	if s.stopped {
-		s.mu.Unlock()
-		return nil, gstatus.Error(codes.Unavailable, "server has been stopped")
+	if err := s.simulateExecutionTime(MethodPartitionQuery, req); err != nil {
+		return nil, err
 	}
-	s.receivedRequests <- req
-	s.mu.Unlock()
-	return nil, gstatus.Error(codes.Unimplemented, "Method not yet implemented")
+	if req.Session == "" {
+		return nil, gstatus.Error(codes.InvalidArgument, "Missing session name")
+	}
+	session, err := s.findSession(req.Session)
+	if err != nil {
+		return nil, err
+	}
+	s.updateSessionLastUseTime(session.Name)
+	if _, err := s.getStatementResult(req.Sql); err != nil {
+		return nil, err
+	}
+	n := req.PartitionOptions.GetMaxPartitions()
+	if n <= 0 {
+		n = 1
+	}
+	resp := &spannerpb.PartitionResponse{}
+	for i := int64(0); i < n; i++ {
+		resp.Partitions = append(resp.Partitions, &spannerpb.Partition{
+			PartitionToken: []byte(fmt.Sprintf("partition-%d", i)),
+		})
+	}
+	return resp, nil
 }
 
 func (s *inMemSpannerServer) PartitionRead(ctx context.Context, req *spannerpb.PartitionReadRequest) (*spannerpb.PartitionResponse, error) {
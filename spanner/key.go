@@ -86,6 +86,15 @@ func keyPartValue(part interface{}) (pb *proto3.Value, err error) {
 		pb, _, err = encodeValue(float64(v))
 	case int64, float64, NullInt64, NullFloat64, bool, NullBool, []byte, string, NullString, time.Time, civil.Date, NullTime, NullDate:
 		pb, _, err = encodeValue(v)
+	case Encoder:
+		x, err := v.EncodeSpanner()
+		if err != nil {
+			return nil, spannerErrorf(codes.InvalidArgument, "cannot encode key part %T: %v", v, err)
+		}
+		if _, ok := x.(Encoder); ok {
+			return nil, errInvdKeyPartType(v)
+		}
+		return keyPartValue(x)
 	default:
 		return nil, errInvdKeyPartType(v)
 	}
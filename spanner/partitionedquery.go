@@ -0,0 +1,290 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// A PartitionedQuery executes all the partitions of a query in a
+// BatchReadOnlyTransaction, and keeps track of the partitions that have been
+// executed completely.
+//
+// The state of a PartitionedQuery can be saved with MarshalBinary after each
+// partition completes (see PartitionedQueryConfig.Checkpoint), so that a job
+// that stopped before all partitions were executed can be resumed by
+// unmarshaling the last checkpoint and calling Run again. Only the partitions
+// that did not complete are executed again. The rows of a partition that was
+// being executed when the job stopped are delivered again, so the function
+// that processes the rows must tolerate duplicates for partitions that
+// did not complete.
+//
+// Resuming a query requires that the session of its BatchReadOnlyTransaction
+// still exists, and that the read timestamp of the transaction is still
+// within the version retention period of the database (one hour). Call
+// Cleanup when the query is done, to delete the session.
+type PartitionedQuery struct {
+	// ID identifies the BatchReadOnlyTransaction in which the partitions are
+	// executed.
+	ID BatchReadOnlyTransactionID
+
+	// Partitions are the partitions of the query.
+	Partitions []*Partition
+
+	mu sync.Mutex
+	// done[i] is true if Partitions[i] has been executed completely.
+	done []bool
+
+	// checkpointMu serializes the calls to PartitionedQueryConfig.Checkpoint,
+	// which are made without holding mu.
+	checkpointMu sync.Mutex
+}
+
+// PartitionedQueryConfig configures PartitionedQuery.Run.
+type PartitionedQueryConfig struct {
+	// Workers is the number of partitions that are executed concurrently.
+	//
+	// Defaults to 4.
+	Workers int
+
+	// Checkpoint, if non-nil, is called each time a partition has been
+	// executed completely, with the result of calling MarshalBinary on the
+	// PartitionedQuery. Calls to Checkpoint are not concurrent. If Checkpoint
+	// returns an error, Run stops and returns that error.
+	Checkpoint func(ctx context.Context, checkpoint []byte) error
+}
+
+// NewPartitionedQuery starts a BatchReadOnlyTransaction with the given
+// timestamp bound, and partitions statement in it.
+func (c *Client) NewPartitionedQuery(ctx context.Context, tb TimestampBound, statement Statement, opt PartitionOptions) (*PartitionedQuery, error) {
+	t, err := c.BatchReadOnlyTransaction(ctx, tb)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := t.PartitionQuery(ctx, statement, opt)
+	if err != nil {
+		t.Cleanup(ctx)
+		return nil, err
+	}
+	return &PartitionedQuery{
+		ID:         t.ID,
+		Partitions: ps,
+		done:       make([]bool, len(ps)),
+	}, nil
+}
+
+// Done reports whether partition i has been executed completely.
+func (q *PartitionedQuery) Done(i int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.done[i]
+}
+
+// Remaining returns the number of partitions that have not been executed
+// completely.
+func (q *PartitionedQuery) Remaining() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, d := range q.done {
+		if !d {
+			n++
+		}
+	}
+	return n
+}
+
+// Run executes the partitions that have not been executed completely, using
+// config.Workers goroutines, and calls f with the index of the partition and
+// each of its rows. f is called concurrently for different partitions, and
+// sequentially for the rows of one partition. If f returns an error, the
+// partition is not marked as done and Run stops.
+//
+// Run returns the first error returned by f, by the execution of a partition
+// or by config.Checkpoint. The partitions that completed before the error are
+// marked as done, so calling Run again executes only the others.
+func (q *PartitionedQuery) Run(ctx context.Context, c *Client, config PartitionedQueryConfig, f func(partition int, row *Row) error) error {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	t := c.BatchReadOnlyTransactionFromID(q.ID)
+	defer t.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	setErr := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	todo := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				if err := q.execute(ctx, t, i, config, f); err != nil {
+					setErr(err)
+				}
+			}
+		}()
+	}
+	q.mu.Lock()
+	var pending []int
+	for i, d := range q.done {
+		if !d {
+			pending = append(pending, i)
+		}
+	}
+	q.mu.Unlock()
+loop:
+	for _, i := range pending {
+		select {
+		case todo <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(todo)
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		// The context passed to Run is done.
+		firstErr = toSpannerError(ctx.Err())
+	}
+	return firstErr
+}
+
+// execute runs partition i and records it as done.
+func (q *PartitionedQuery) execute(ctx context.Context, t *BatchReadOnlyTransaction, i int, config PartitionedQueryConfig, f func(int, *Row) error) error {
+	// The partitions returned by PartitionQuery share their request, which
+	// Execute modifies, so each partition is executed with a copy.
+	p := q.Partitions[i]
+	p = &Partition{pt: p.pt, qreq: proto.Clone(p.qreq).(*sppb.ExecuteSqlRequest)}
+	err := t.Execute(ctx, p).Do(func(r *Row) error {
+		return f(i, r)
+	})
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.done[i] = true
+	q.mu.Unlock()
+	if config.Checkpoint == nil {
+		return nil
+	}
+	// Marshal the query after taking checkpointMu, so that each checkpoint
+	// is at least as recent as the previous one.
+	q.checkpointMu.Lock()
+	defer q.checkpointMu.Unlock()
+	data, err := q.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return config.Checkpoint(ctx, data)
+}
+
+// Cleanup deletes the session of the BatchReadOnlyTransaction of the query.
+// After Cleanup, the query cannot be run or resumed anymore.
+func (q *PartitionedQuery) Cleanup(ctx context.Context, c *Client) {
+	c.BatchReadOnlyTransactionFromID(q.ID).Cleanup(ctx)
+}
+
+// MarshalBinary implements BinaryMarshaler. The result includes the
+// transaction ID, the partitions and which of them have been executed.
+func (q *PartitionedQuery) MarshalBinary() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.marshalLocked()
+}
+
+func (q *PartitionedQuery) marshalLocked() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	tid, err := q.ID.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(tid); err != nil {
+		return nil, err
+	}
+	ps := make([][]byte, len(q.Partitions))
+	for i, p := range q.Partitions {
+		if ps[i], err = p.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Encode(ps); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(q.done); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements BinaryUnmarshaler.
+func (q *PartitionedQuery) UnmarshalBinary(data []byte) error {
+	var (
+		tid  []byte
+		ps   [][]byte
+		done []bool
+	)
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&tid); err != nil {
+		return err
+	}
+	if err := dec.Decode(&ps); err != nil {
+		return err
+	}
+	if err := dec.Decode(&done); err != nil {
+		return err
+	}
+	if len(done) != len(ps) {
+		return spannerErrorf(codes.InvalidArgument, "invalid PartitionedQuery checkpoint: %d partitions but %d done flags", len(ps), len(done))
+	}
+	var id BatchReadOnlyTransactionID
+	if err := id.UnmarshalBinary(tid); err != nil {
+		return err
+	}
+	partitions := make([]*Partition, len(ps))
+	for i, d := range ps {
+		partitions[i] = &Partition{}
+		if err := partitions[i].UnmarshalBinary(d); err != nil {
+			return err
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ID = id
+	q.Partitions = partitions
+	q.done = done
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	. "cloud.google.com/go/spanner/internal/testutil"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+func TestPartitionedQueryRunAndResume(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+
+	const numPartitions = 5
+	q, err := client.NewPartitionedQuery(ctx, StrongRead(), NewStatement(SelectFooFromBar), PartitionOptions{MaxPartitions: numPartitions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Partitions) != numPartitions || q.Remaining() != numPartitions {
+		t.Fatalf("got %d partitions with %d remaining, want %d", len(q.Partitions), q.Remaining(), numPartitions)
+	}
+
+	// The first run fails in partition 3.
+	errBoom := errors.New("boom")
+	var (
+		mu         sync.Mutex
+		checkpoint []byte
+	)
+	config := PartitionedQueryConfig{
+		Workers: 2,
+		Checkpoint: func(ctx context.Context, data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			checkpoint = data
+			return nil
+		},
+	}
+	err = q.Run(ctx, client, config, func(p int, row *Row) error {
+		if p == 3 {
+			return errBoom
+		}
+		return nil
+	})
+	if err != errBoom {
+		t.Fatalf("Run returned %v, want %v", err, errBoom)
+	}
+	if q.Done(3) {
+		t.Fatal("partition 3 is done after it failed")
+	}
+	if checkpoint == nil {
+		// No other partition completed before the failure: nothing to resume.
+		checkpoint, err = q.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Resume from the last checkpoint.
+	var resumed PartitionedQuery
+	if err := resumed.UnmarshalBinary(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resumed.Remaining(), q.Remaining(); got != want {
+		t.Fatalf("resumed query has %d remaining partitions, want %d", got, want)
+	}
+	var want []int
+	for i := range resumed.Partitions {
+		if !resumed.Done(i) {
+			want = append(want, i)
+		}
+	}
+	drainRequestsFromServer(server.TestSpanner)
+	rows := map[int]int{}
+	err = resumed.Run(ctx, client, config, func(p int, row *Row) error {
+		var foo int64
+		if err := row.Columns(&foo); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		rows[p]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Remaining() != 0 {
+		t.Fatalf("%d partitions remaining after Run", resumed.Remaining())
+	}
+	for _, i := range want {
+		if rows[i] != 2 {
+			t.Errorf("partition %d: got %d rows, want 2", i, rows[i])
+		}
+	}
+	if len(rows) != len(want) {
+		t.Errorf("executed partitions %v, want %v", rows, want)
+	}
+	// Each partition was executed with its own token.
+	tokens := map[string]bool{}
+	for _, req := range drainRequestsFromServer(server.TestSpanner) {
+		if sql, ok := req.(*sppb.ExecuteSqlRequest); ok {
+			tokens[string(sql.PartitionToken)] = true
+		}
+	}
+	if len(tokens) != len(want) {
+		t.Errorf("got partition tokens %v, want %d distinct tokens", tokens, len(want))
+	}
+	resumed.Cleanup(ctx, client)
+}
+
+func TestPartitionedQueryCheckpointError(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	_, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+
+	q, err := client.NewPartitionedQuery(ctx, StrongRead(), NewStatement(SelectFooFromBar), PartitionOptions{MaxPartitions: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errSave := errors.New("cannot save")
+	config := PartitionedQueryConfig{
+		Workers: 1,
+		Checkpoint: func(ctx context.Context, data []byte) error {
+			return errSave
+		},
+	}
+	err = q.Run(ctx, client, config, func(int, *Row) error { return nil })
+	if err != errSave {
+		t.Fatalf("Run returned %v, want %v", err, errSave)
+	}
+}
+
+// Checkpoint can call the methods of the query.
+func TestPartitionedQueryCheckpointCallsQuery(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	_, client, teardown := setupMockedTestServer(t)
+	defer teardown()
+
+	const numPartitions = 3
+	q, err := client.NewPartitionedQuery(ctx, StrongRead(), NewStatement(SelectFooFromBar), PartitionOptions{MaxPartitions: numPartitions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var remaining []int
+	config := PartitionedQueryConfig{
+		Workers: 1,
+		Checkpoint: func(ctx context.Context, data []byte) error {
+			remaining = append(remaining, q.Remaining())
+			return nil
+		},
+	}
+	if err := q.Run(ctx, client, config, func(int, *Row) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := remaining, []int{2, 1, 0}; !testEqual(got, want) {
+		t.Errorf("Remaining in Checkpoint = %v, want %v", got, want)
+	}
+}
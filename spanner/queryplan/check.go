@@ -0,0 +1,158 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queryplan
+
+// This file holds the functions for inspecting and checking plans.
+
+import (
+	"fmt"
+	"strings"
+
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+// ScanType is the type of a scan in a plan.
+type ScanType string
+
+const (
+	// TableScan is a scan of the rows of a base table.
+	TableScan ScanType = "TableScan"
+
+	// IndexScan is a scan of the rows of a secondary index.
+	IndexScan ScanType = "IndexScan"
+)
+
+// A Scan is an operator of a plan that reads a table or an index.
+type Scan struct {
+	Type   ScanType
+	Target string // the name of the table or index
+	Full   bool   // whether all the rows of the target are read
+}
+
+func (s Scan) String() string {
+	str := string(s.Type) + " of " + s.Target
+	if s.Full {
+		str = "full " + str
+	}
+	return str
+}
+
+// Scans returns the scans in the plan, in the order of the plan's nodes.
+func Scans(plan *sppb.QueryPlan) []Scan {
+	var scans []Scan
+	for _, n := range plan.GetPlanNodes() {
+		f := n.GetMetadata().GetFields()
+		typ := f["scan_type"].GetStringValue()
+		if typ == "" {
+			continue
+		}
+		scans = append(scans, Scan{
+			Type:   ScanType(typ),
+			Target: f["scan_target"].GetStringValue(),
+			Full:   f["Full scan"].GetStringValue() == "true" || f["Full scan"].GetBoolValue(),
+		})
+	}
+	return scans
+}
+
+// A Check checks a property of a plan, and returns an error describing how
+// the plan violates it, or nil.
+type Check func(plan *sppb.QueryPlan) error
+
+// Verify runs the checks on the plan, and returns an error that describes all
+// their failures, followed by the rendered plan. It returns nil if all the
+// checks pass.
+//
+// In a test, use it like this:
+//
+//	if err := queryplan.Verify(plan, queryplan.UsesIndex("SingersByName")); err != nil {
+//		t.Error(err)
+//	}
+func Verify(plan *sppb.QueryPlan, checks ...Check) error {
+	var msgs []string
+	for _, c := range checks {
+		if err := c(plan); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("query plan check failed:\n%s\nplan:\n%s", strings.Join(msgs, "\n"), Render(plan))
+}
+
+// UsesIndex checks that the plan scans the named index.
+func UsesIndex(index string) Check {
+	return func(plan *sppb.QueryPlan) error {
+		for _, s := range Scans(plan) {
+			if s.Type == IndexScan && strings.EqualFold(s.Target, index) {
+				return nil
+			}
+		}
+		return fmt.Errorf("plan does not use index %s", index)
+	}
+}
+
+// NoTableScan checks that the plan does not scan the base table of the named
+// table, so that all its reads go through indexes.
+func NoTableScan(table string) Check {
+	return func(plan *sppb.QueryPlan) error {
+		for _, s := range Scans(plan) {
+			if s.Type == TableScan && strings.EqualFold(s.Target, table) {
+				return fmt.Errorf("plan has a %v", s)
+			}
+		}
+		return nil
+	}
+}
+
+// NoFullScan checks that the plan does not read all the rows of the named
+// table or index. If target is empty, it checks that the plan has no full
+// scans at all.
+func NoFullScan(target string) Check {
+	return func(plan *sppb.QueryPlan) error {
+		for _, s := range Scans(plan) {
+			if s.Full && (target == "" || strings.EqualFold(s.Target, target)) {
+				return fmt.Errorf("plan has a %v", s)
+			}
+		}
+		return nil
+	}
+}
+
+// HasOperator checks that the plan has an operator with the given display
+// name, such as "Hash Join".
+func HasOperator(name string) Check {
+	return func(plan *sppb.QueryPlan) error {
+		for _, n := range plan.GetPlanNodes() {
+			if n.Kind == sppb.PlanNode_RELATIONAL && n.DisplayName == name {
+				return nil
+			}
+		}
+		return fmt.Errorf("plan has no %s operator", name)
+	}
+}
+
+// NoOperator checks that the plan has no operator with the given display name.
+func NoOperator(name string) Check {
+	return func(plan *sppb.QueryPlan) error {
+		if HasOperator(name)(plan) == nil {
+			return fmt.Errorf("plan has a %s operator", name)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package queryplan renders and inspects Cloud Spanner query plans.
+
+A query plan is returned by ReadOnlyTransaction.AnalyzeQuery, and by
+RowIterator.QueryPlan for a query run with QueryWithStats. The plan is a list
+of nodes that refer to each other by index, which is hard to read. Render
+prints it as an indented tree of operators instead, with the execution
+statistics of each operator if the plan has them:
+
+	Distributed Union
+	  Serialize Result {rows: 3 rows, latency: 0.1 msecs}
+	    Scan (Full scan: true, scan_target: Singers, scan_type: TableScan)
+
+The Check functions assert properties of a plan, such as the use of an index,
+so that tests can catch changes in the plans of important queries. Plans can
+be recorded with Write and loaded with Read, to check them without a
+database.
+
+This package is EXPERIMENTAL and subject to change or removal without notice.
+*/
+package queryplan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+// Render returns the plan as an indented tree of its relational operators.
+//
+// Each operator is written on its own line, followed by its metadata in
+// parentheses and its execution statistics in braces. The scalar expressions
+// that an operator uses, such as its conditions, are written below it, in the
+// form "Link type: expression". A child that is linked to its parent with a
+// named link type is prefixed with it.
+func Render(plan *sppb.QueryPlan) string {
+	var buf bytes.Buffer
+	nodes := plan.GetPlanNodes()
+	if len(nodes) > 0 {
+		render(&buf, nodes, nodes[0], "", 0, map[int32]bool{})
+	}
+	return buf.String()
+}
+
+func render(buf *bytes.Buffer, nodes []*sppb.PlanNode, n *sppb.PlanNode, link string, depth int, seen map[int32]bool) {
+	indent := strings.Repeat("  ", depth)
+	if seen[n.Index] {
+		// Plans are trees, but don't loop on malformed ones.
+		fmt.Fprintf(buf, "%s(cycle to node %d)\n", indent, n.Index)
+		return
+	}
+	seen[n.Index] = true
+	defer delete(seen, n.Index)
+
+	buf.WriteString(indent)
+	if link != "" {
+		buf.WriteString(link + ": ")
+	}
+	buf.WriteString(n.DisplayName)
+	if md := formatStruct(n.Metadata); md != "" {
+		buf.WriteString(" (" + md + ")")
+	}
+	if st := formatStats(n.ExecutionStats); st != "" {
+		buf.WriteString(" {" + st + "}")
+	}
+	buf.WriteString("\n")
+
+	for _, cl := range n.ChildLinks {
+		c := node(nodes, cl.ChildIndex)
+		if c == nil {
+			fmt.Fprintf(buf, "%s  (missing node %d)\n", indent, cl.ChildIndex)
+			continue
+		}
+		if c.Kind == sppb.PlanNode_SCALAR {
+			if cl.Type == "" {
+				// Unnamed scalar children are part of their parent's
+				// expression, which is already shown.
+				continue
+			}
+			fmt.Fprintf(buf, "%s  %s: %s\n", indent, cl.Type, scalarDescription(nodes, c))
+			continue
+		}
+		render(buf, nodes, c, cl.Type, depth+1, seen)
+	}
+}
+
+// node returns the node with index i, or nil if there is none.
+func node(nodes []*sppb.PlanNode, i int32) *sppb.PlanNode {
+	if i >= 0 && int(i) < len(nodes) && nodes[i].Index == i {
+		return nodes[i]
+	}
+	for _, n := range nodes {
+		if n.Index == i {
+			return n
+		}
+	}
+	return nil
+}
+
+// scalarDescription returns a description of a scalar node.
+func scalarDescription(nodes []*sppb.PlanNode, n *sppb.PlanNode) string {
+	if sr := n.ShortRepresentation; sr != nil && sr.Description != "" {
+		return sr.Description
+	}
+	return n.DisplayName
+}
+
+// formatStruct formats the fields of s as "key: value" pairs, sorted by key.
+func formatStruct(s *proto3.Struct) string {
+	var parts []string
+	for _, k := range sortedKeys(s) {
+		parts = append(parts, k+": "+formatValue(s.Fields[k]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatStats formats execution statistics. A statistic is normally a struct
+// with a total and a unit, such as {"total": "3", "unit": "rows"}, which is
+// written as "3 rows". Other structs, such as the execution summary, are
+// flattened.
+func formatStats(s *proto3.Struct) string {
+	var parts []string
+	var flatten func(prefix string, s *proto3.Struct)
+	flatten = func(prefix string, s *proto3.Struct) {
+		for _, k := range sortedKeys(s) {
+			v := s.Fields[k]
+			sv := v.GetStructValue()
+			if sv == nil {
+				parts = append(parts, prefix+k+": "+formatValue(v))
+				continue
+			}
+			if total, ok := sv.Fields["total"]; ok {
+				str := formatValue(total)
+				if unit := sv.Fields["unit"].GetStringValue(); unit != "" {
+					str += " " + unit
+				}
+				parts = append(parts, prefix+k+": "+str)
+				continue
+			}
+			flatten(prefix+k+".", sv)
+		}
+	}
+	flatten("", s)
+	return strings.Join(parts, ", ")
+}
+
+func sortedKeys(s *proto3.Struct) []string {
+	var keys []string
+	for k := range s.GetFields() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(v *proto3.Value) string {
+	switch k := v.GetKind().(type) {
+	case *proto3.Value_StringValue:
+		return k.StringValue
+	case *proto3.Value_NumberValue:
+		return fmt.Sprint(k.NumberValue)
+	case *proto3.Value_BoolValue:
+		return fmt.Sprint(k.BoolValue)
+	case *proto3.Value_NullValue:
+		return "NULL"
+	case *proto3.Value_StructValue:
+		return "{" + formatStruct(k.StructValue) + "}"
+	case *proto3.Value_ListValue:
+		var elems []string
+		for _, e := range k.ListValue.Values {
+			elems = append(elems, formatValue(e))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return ""
+}
+
+// Write writes the plan to w as JSON, for use with Read.
+func Write(w io.Writer, plan *sppb.QueryPlan) error {
+	m := jsonpb.Marshaler{Indent: "  "}
+	return m.Marshal(w, plan)
+}
+
+// Read reads a plan written by Write.
+func Read(r io.Reader) (*sppb.QueryPlan, error) {
+	plan := &sppb.QueryPlan{}
+	if err := jsonpb.Unmarshal(r, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
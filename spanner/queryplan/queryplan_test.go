@@ -0,0 +1,210 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queryplan
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+func str(s string) *proto3.Value {
+	return &proto3.Value{Kind: &proto3.Value_StringValue{StringValue: s}}
+}
+
+func fields(kv ...interface{}) *proto3.Struct {
+	s := &proto3.Struct{Fields: map[string]*proto3.Value{}}
+	for i := 0; i < len(kv); i += 2 {
+		v, ok := kv[i+1].(*proto3.Value)
+		if !ok {
+			v = &proto3.Value{Kind: &proto3.Value_StructValue{StructValue: kv[i+1].(*proto3.Struct)}}
+		}
+		s.Fields[kv[i].(string)] = v
+	}
+	return s
+}
+
+func stat(total, unit string) *proto3.Struct {
+	return fields("total", str(total), "unit", str(unit))
+}
+
+// testPlan is the plan of
+//
+//	SELECT s.FirstName FROM Singers@{FORCE_INDEX=SingersByLastName} s WHERE s.LastName = "Lee"
+//
+// as a profile query.
+var testPlan = &sppb.QueryPlan{
+	PlanNodes: []*sppb.PlanNode{
+		{
+			Index:       0,
+			Kind:        sppb.PlanNode_RELATIONAL,
+			DisplayName: "Distributed Union",
+			ChildLinks: []*sppb.PlanNode_ChildLink{
+				{ChildIndex: 1},
+				{ChildIndex: 6, Type: "Split Range"},
+			},
+			Metadata: fields("call_type", str("Local")),
+			ExecutionStats: fields(
+				"rows", stat("1", "rows"),
+				"latency", stat("0.5", "msecs"),
+				"execution_summary", fields("num_executions", str("1")),
+			),
+		},
+		{
+			Index:       1,
+			Kind:        sppb.PlanNode_RELATIONAL,
+			DisplayName: "Serialize Result",
+			ChildLinks: []*sppb.PlanNode_ChildLink{
+				{ChildIndex: 2},
+				{ChildIndex: 5},
+			},
+		},
+		{
+			Index:       2,
+			Kind:        sppb.PlanNode_RELATIONAL,
+			DisplayName: "Filter Scan",
+			ChildLinks: []*sppb.PlanNode_ChildLink{
+				{ChildIndex: 3},
+				{ChildIndex: 4, Type: "Seek Condition"},
+			},
+		},
+		{
+			Index:       3,
+			Kind:        sppb.PlanNode_RELATIONAL,
+			DisplayName: "Scan",
+			Metadata: fields(
+				"scan_type", str("IndexScan"),
+				"scan_target", str("SingersByLastName"),
+			),
+			ExecutionStats: fields("rows", stat("1", "rows")),
+		},
+		{
+			Index:               4,
+			Kind:                sppb.PlanNode_SCALAR,
+			DisplayName:         "Function",
+			ShortRepresentation: &sppb.PlanNode_ShortRepresentation{Description: "($LastName = 'Lee')"},
+		},
+		{
+			Index:               5,
+			Kind:                sppb.PlanNode_SCALAR,
+			DisplayName:         "Reference",
+			ShortRepresentation: &sppb.PlanNode_ShortRepresentation{Description: "$FirstName"},
+		},
+		{
+			Index:               6,
+			Kind:                sppb.PlanNode_SCALAR,
+			DisplayName:         "Constant",
+			ShortRepresentation: &sppb.PlanNode_ShortRepresentation{Description: "true"},
+		},
+	},
+}
+
+func TestRender(t *testing.T) {
+	want := strings.Join([]string{
+		`Distributed Union (call_type: Local) {execution_summary.num_executions: 1, latency: 0.5 msecs, rows: 1 rows}`,
+		`  Serialize Result`,
+		`    Filter Scan`,
+		`      Scan (scan_target: SingersByLastName, scan_type: IndexScan) {rows: 1 rows}`,
+		`      Seek Condition: ($LastName = 'Lee')`,
+		`  Split Range: true`,
+		``,
+	}, "\n")
+	if got := Render(testPlan); got != want {
+		t.Errorf("Render mismatch\n got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := Render(&sppb.QueryPlan{}); got != "" {
+		t.Errorf("Render of an empty plan = %q, want empty", got)
+	}
+}
+
+func TestScans(t *testing.T) {
+	plan := proto.Clone(testPlan).(*sppb.QueryPlan)
+	plan.PlanNodes = append(plan.PlanNodes, &sppb.PlanNode{
+		Index:       7,
+		Kind:        sppb.PlanNode_RELATIONAL,
+		DisplayName: "Scan",
+		Metadata: fields(
+			"scan_type", str("TableScan"),
+			"scan_target", str("Singers"),
+			"Full scan", str("true"),
+		),
+	})
+	got := Scans(plan)
+	want := []Scan{
+		{Type: IndexScan, Target: "SingersByLastName"},
+		{Type: TableScan, Target: "Singers", Full: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Scans = %v, want %v", got, want)
+	}
+
+	for _, test := range []struct {
+		check Check
+		ok    bool
+	}{
+		{UsesIndex("SingersByLastName"), true},
+		{UsesIndex("singersbylastname"), true},
+		{UsesIndex("SingersByFirstName"), false},
+		{NoTableScan("Albums"), true},
+		{NoTableScan("Singers"), false},
+		{NoFullScan("SingersByLastName"), true},
+		{NoFullScan("Singers"), false},
+		{NoFullScan(""), false},
+		{HasOperator("Filter Scan"), true},
+		{HasOperator("Hash Join"), false},
+		{NoOperator("Hash Join"), true},
+		{NoOperator("Serialize Result"), false},
+	} {
+		if err := test.check(plan); (err == nil) != test.ok {
+			t.Errorf("check returned %v, want ok=%t", err, test.ok)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	if err := Verify(testPlan, UsesIndex("SingersByLastName"), NoFullScan("")); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	err := Verify(testPlan, UsesIndex("SingersByFirstName"), NoOperator("Filter Scan"))
+	if err == nil {
+		t.Fatal("Verify succeeded, want failure")
+	}
+	for _, want := range []string{"does not use index SingersByFirstName", "has a Filter Scan operator", "Serialize Result"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Verify error does not contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testPlan); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, testPlan) {
+		t.Errorf("round trip mismatch\n got %v\nwant %v", got, testPlan)
+	}
+}
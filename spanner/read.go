@@ -75,6 +75,11 @@ type RowIterator struct {
 	// iterator.Done.
 	RowCount int64
 
+	// The metadata of the results, including the names and types of the
+	// columns. Available after the first call to RowIterator.Next, even if it
+	// returns iterator.Done.
+	Metadata *sppb.ResultSetMetadata
+
 	streamd      *resumableStreamDecoder
 	rowd         *partialResultSetDecoder
 	setTimestamp func(time.Time)
@@ -94,6 +99,9 @@ func (r *RowIterator) Next() (*Row, error) {
 	}
 	for len(r.rows) == 0 && r.streamd.next() {
 		prs := r.streamd.get()
+		if r.Metadata == nil && prs.Metadata != nil {
+			r.Metadata = prs.Metadata
+		}
 		if prs.Stats != nil {
 			r.sawStats = true
 			r.QueryPlan = prs.Stats.QueryPlan
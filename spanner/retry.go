@@ -18,6 +18,7 @@ package spanner
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"cloud.google.com/go/internal/trace"
@@ -73,24 +74,48 @@ func (r *spannerRetryer) Retry(err error) (time.Duration, bool) {
 // by Cloud Spanner, and if none is returned, the calculated delay with a
 // minimum of 10ms and maximum of 32s.
 func runWithRetryOnAborted(ctx context.Context, f func(context.Context) error) error {
-	retryer := onCodes(DefaultRetryBackoff, codes.Aborted)
-	funcWithRetry := func(ctx context.Context) error {
-		for {
-			err := f(ctx)
-			if err == nil {
-				return nil
-			}
-			delay, shouldRetry := retryer.Retry(err)
-			if !shouldRetry {
-				return err
-			}
-			trace.TracePrintf(ctx, nil, "Backing off after ABORTED for %s, then retrying", delay)
-			if err := gax.Sleep(ctx, delay); err != nil {
-				return err
-			}
+	return runWithRetryPolicyOnAborted(ctx, &transactionOption{backoff: DefaultRetryBackoff}, f)
+}
+
+// runWithRetryPolicyOnAborted is like runWithRetryOnAborted, but retries the
+// function according to the retry options in to.
+func runWithRetryPolicyOnAborted(ctx context.Context, to *transactionOption, f func(context.Context) error) error {
+	retryer := onCodes(to.backoff, codes.Aborted)
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+		delay, shouldRetry := retryer.Retry(err)
+		if !shouldRetry {
+			return err
+		}
+		if to.maxAttempts > 0 && attempt >= to.maxAttempts {
+			return decorateAborted(err, fmt.Sprintf("transaction aborted after %d attempts", attempt))
+		}
+		if to.maxElapsedTime > 0 && time.Since(start)+delay > to.maxElapsedTime {
+			return decorateAborted(err, fmt.Sprintf("transaction aborted and cannot be retried within %v", to.maxElapsedTime))
 		}
+		if to.onRetry != nil {
+			to.onRetry(TransactionRetry{Attempt: attempt, Err: err, Delay: delay})
+		}
+		trace.TracePrintf(ctx, nil, "Backing off after ABORTED for %s, then retrying", delay)
+		if err := gax.Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// decorateAborted adds info to the description of the Aborted error err,
+// which keeps its code so that callers can still recognize it.
+func decorateAborted(err error, info string) error {
+	se, ok := toSpannerError(err).(*Error)
+	if !ok {
+		return err
 	}
-	return funcWithRetry(ctx)
+	se.decorate(info)
+	return se
 }
 
 // extractRetryDelay extracts retry backoff if present.
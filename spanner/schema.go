@@ -0,0 +1,196 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanner
+
+import (
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/spanner/spansql"
+	"google.golang.org/grpc/codes"
+)
+
+// Struct tag options recognized by InferCreateTable.
+const (
+	primaryKeyTagOption           = "primarykey"
+	descTagOption                 = "desc"
+	nullableTagOption             = "nullable"
+	allowCommitTimestampTagOption = "allow_commit_timestamp"
+)
+
+var (
+	typeOfString      = reflect.TypeOf("")
+	typeOfBytes       = reflect.TypeOf([]byte(nil))
+	typeOfInt64       = reflect.TypeOf(int64(0))
+	typeOfFloat64     = reflect.TypeOf(float64(0))
+	typeOfBool        = reflect.TypeOf(false)
+	typeOfTime        = reflect.TypeOf(time.Time{})
+	typeOfDate        = reflect.TypeOf(civil.Date{})
+	typeOfNullString  = reflect.TypeOf(NullString{})
+	typeOfNullInt64   = reflect.TypeOf(NullInt64{})
+	typeOfNullFloat64 = reflect.TypeOf(NullFloat64{})
+	typeOfNullBool    = reflect.TypeOf(NullBool{})
+	typeOfNullTime    = reflect.TypeOf(NullTime{})
+	typeOfNullDate    = reflect.TypeOf(NullDate{})
+)
+
+// InferCreateTable returns a CREATE TABLE statement for a table named table,
+// with a column for each exported field of the struct st, which must be a
+// struct or a pointer to a struct. It is the inverse of Row.ToStruct: a row
+// of the table can be read into and written from values of the struct's type.
+//
+// The Go types of the fields map to Cloud Spanner column types as follows:
+//
+//	string, NullString                  STRING(MAX)
+//	[]byte                              BYTES(MAX)
+//	int64, NullInt64                    INT64
+//	float64, NullFloat64                FLOAT64
+//	bool, NullBool                      BOOL
+//	time.Time, NullTime                 TIMESTAMP
+//	civil.Date, NullDate                DATE
+//
+// A slice of any of these types, other than []byte, maps to an ARRAY column
+// of the corresponding type. A column of a type that cannot hold NULL, such as
+// string or int64, is NOT NULL; the other columns, including arrays, are
+// nullable.
+//
+// The name of a column is the name of its field, or the name in its struct
+// tag, as for Row.ToStruct. The tag may also hold options, after the name and
+// separated by commas, which Row.ToStruct and the struct mutations ignore:
+//
+//	primarykey               the column is part of the primary key; the
+//	                         primary key columns are in the order of their fields
+//	desc                     the primary key column is in descending order
+//	nullable                 the column is nullable even if its type cannot
+//	                         hold NULL
+//	allow_commit_timestamp   the column allows commit timestamps
+//
+// For example:
+//
+//	type Singer struct {
+//		SingerID  int64 `spanner:"SingerId,primarykey"`
+//		FirstName NullString
+//		Updated   time.Time `spanner:",allow_commit_timestamp"`
+//	}
+//
+// InferCreateTable returns an error for fields of other types, including
+// struct types, which cannot be the type of a column.
+// It is EXPERIMENTAL and subject to change or removal without notice.
+func InferCreateTable(table string, st interface{}) (spansql.CreateTable, error) {
+	ct := spansql.CreateTable{Name: table}
+	t := reflect.TypeOf(st)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return ct, errNotStruct(st)
+	}
+	fields, err := fieldCache.Fields(t)
+	if err != nil {
+		return ct, toSpannerError(err)
+	}
+	for _, f := range fields {
+		typ, nullable, ok := inferColumnType(f.Type)
+		if !ok {
+			return ct, spannerErrorf(codes.InvalidArgument, "cannot infer the column type of field %s of type %v", f.Name, f.Type)
+		}
+		cd := spansql.ColumnDef{Name: f.Name, Type: typ}
+		var key *spansql.KeyPart
+		opts, _ := f.ParsedTag.([]string)
+		for _, opt := range opts {
+			switch opt {
+			case primaryKeyTagOption:
+				key = &spansql.KeyPart{Column: f.Name}
+			case nullableTagOption:
+				nullable = true
+			case allowCommitTimestampTagOption:
+				if typ.Base != spansql.Timestamp || typ.Array {
+					return ct, spannerErrorf(codes.InvalidArgument, "field %s: allow_commit_timestamp requires a TIMESTAMP column", f.Name)
+				}
+				allow := true
+				cd.Options.AllowCommitTimestamp = &allow
+			case descTagOption:
+			default:
+				return ct, spannerErrorf(codes.InvalidArgument, "field %s: unknown struct tag option %q", f.Name, opt)
+			}
+		}
+		for _, opt := range opts {
+			if opt == descTagOption {
+				if key == nil {
+					return ct, spannerErrorf(codes.InvalidArgument, "field %s: desc requires primarykey", f.Name)
+				}
+				key.Desc = true
+			}
+		}
+		cd.NotNull = !nullable
+		ct.Columns = append(ct.Columns, cd)
+		if key != nil {
+			ct.PrimaryKey = append(ct.PrimaryKey, *key)
+		}
+	}
+	return ct, nil
+}
+
+// inferColumnType returns the column type for values of the Go type t, and
+// whether the type can hold NULL.
+func inferColumnType(t reflect.Type) (typ spansql.Type, nullable bool, ok bool) {
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		elem, _, ok := inferColumnType(t.Elem())
+		if !ok || elem.Array {
+			return typ, false, false
+		}
+		elem.Array = true
+		return elem, true, true
+	}
+	base := func(b spansql.TypeBase) spansql.Type {
+		typ := spansql.Type{Base: b}
+		if b == spansql.String || b == spansql.Bytes {
+			typ.Len = spansql.MaxLen
+		}
+		return typ
+	}
+	// Only the types that Row.ToStruct can decode are accepted; other types
+	// with the same kind, such as int or a named string type, are not.
+	switch t {
+	case typeOfString:
+		return base(spansql.String), false, true
+	case typeOfBytes:
+		return base(spansql.Bytes), true, true
+	case typeOfInt64:
+		return base(spansql.Int64), false, true
+	case typeOfFloat64:
+		return base(spansql.Float64), false, true
+	case typeOfBool:
+		return base(spansql.Bool), false, true
+	case typeOfTime:
+		return base(spansql.Timestamp), false, true
+	case typeOfDate:
+		return base(spansql.Date), false, true
+	case typeOfNullString:
+		return base(spansql.String), true, true
+	case typeOfNullInt64:
+		return base(spansql.Int64), true, true
+	case typeOfNullFloat64:
+		return base(spansql.Float64), true, true
+	case typeOfNullBool:
+		return base(spansql.Bool), true, true
+	case typeOfNullTime:
+		return base(spansql.Timestamp), true, true
+	case typeOfNullDate:
+		return base(spansql.Date), true, true
+	}
+	return typ, false, false
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanner
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/spanner/spansql"
+	"google.golang.org/grpc/codes"
+)
+
+func TestInferCreateTable(t *testing.T) {
+	type Singer struct {
+		ID         int64  `spanner:"SingerId,primarykey"`
+		Name       string `spanner:",primarykey,desc"`
+		Nickname   NullString
+		Age        int64 `spanner:",nullable"`
+		Rating     float64
+		Active     NullBool
+		Photo      []byte
+		Born       civil.Date
+		Died       NullDate
+		Tags       []string
+		Scores     []NullInt64
+		Updated    time.Time `spanner:",allow_commit_timestamp"`
+		Ignored    string    `spanner:"-"`
+		unexported int
+	}
+	got, err := InferCreateTable("Singers", &Singer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `CREATE TABLE Singers (
+  SingerId INT64 NOT NULL,
+  Name STRING(MAX) NOT NULL,
+  Nickname STRING(MAX),
+  Age INT64,
+  Rating FLOAT64 NOT NULL,
+  Active BOOL,
+  Photo BYTES(MAX),
+  Born DATE NOT NULL,
+  Died DATE,
+  Tags ARRAY<STRING(MAX)>,
+  Scores ARRAY<INT64>,
+  Updated TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp = true),
+) PRIMARY KEY(SingerId, Name DESC)`
+	if s := got.SQL(); s != want {
+		t.Errorf("InferCreateTable:\n got %s\nwant %s", s, want)
+	}
+	// The statement must be valid DDL.
+	if _, err := spansql.ParseDDLStmt(got.SQL()); err != nil {
+		t.Errorf("parsing inferred DDL: %v", err)
+	}
+}
+
+type myString string
+
+func TestInferCreateTableErrors(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		in   interface{}
+	}{
+		{"not a struct", 1},
+		{"nil", nil},
+		{"struct field", struct{ S struct{ A int } }{}},
+		{"pointer field", struct{ P *int64 }{}},
+		{"unsupported type", struct{ I int32 }{}},
+		// Row.ToStruct cannot decode into these types.
+		{"int", struct{ I int }{}},
+		{"named string", struct{ S myString }{}},
+		{"int slice", struct{ A []int }{}},
+		{"nested array", struct{ A [][]int64 }{}},
+		{"unknown option", struct {
+			A int64 `spanner:"A,index"`
+		}{}},
+		{"desc without primarykey", struct {
+			A int64 `spanner:"A,desc"`
+		}{}},
+		{"commit timestamp on non-timestamp", struct {
+			A int64 `spanner:"A,allow_commit_timestamp"`
+		}{}},
+	} {
+		_, err := InferCreateTable("T", test.in)
+		if ErrCode(err) != codes.InvalidArgument {
+			t.Errorf("%s: got error %v, want code InvalidArgument", test.desc, err)
+		}
+	}
+}
+
+// A struct tagged for InferCreateTable reads and writes the rows of the table
+// that InferCreateTable returns: the other struct helpers ignore the options.
+func TestTagOptions(t *testing.T) {
+	type Singer struct {
+		ID      int64     `spanner:"SingerId,primarykey"`
+		Updated time.Time `spanner:",allow_commit_timestamp"`
+	}
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	row, err := NewRow([]string{"SingerId", "Updated"}, []interface{}{int64(7), ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Singer
+	if err := row.ToStruct(&s); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Singer{ID: 7, Updated: ts}); !testEqual(s, want) {
+		t.Errorf("ToStruct: got %+v, want %+v", s, want)
+	}
+	m, err := InsertStruct("Singers", &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.columns, []string{"SingerId", "Updated"}; !testEqual(got, want) {
+		t.Errorf("InsertStruct: got columns %q, want %q", got, want)
+	}
+
+	ct, err := InferCreateTable("Singers", &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "CREATE TABLE Singers (\n  SingerId INT64 NOT NULL,\n  Updated TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp = true),\n) PRIMARY KEY(SingerId)"
+	if got := ct.SQL(); got != want {
+		t.Errorf("InferCreateTable:\n got %s\nwant %s", got, want)
+	}
+}
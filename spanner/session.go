@@ -17,6 +17,7 @@ limitations under the License.
 package spanner
 
 import (
+	"bytes"
 	"container/heap"
 	"container/list"
 	"context"
@@ -24,6 +25,7 @@ import (
 	"log"
 	"math"
 	"math/rand"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
@@ -44,6 +46,20 @@ type sessionHandle struct {
 	// session is a pointer to a session object. Transactions never need to
 	// access it directly.
 	session *session
+	// checkout is the element of the session pool's list of checked out
+	// sessions that records this sessionHandle. It is only set if
+	// SessionPoolConfig.TrackSessionHandles is true.
+	checkout *list.Element
+}
+
+// untrack removes sh from the list of checked out sessions of its session
+// pool. sh.mu must be held.
+func (sh *sessionHandle) untrack() {
+	if sh.checkout == nil {
+		return
+	}
+	sh.session.pool.untrack(sh.checkout)
+	sh.checkout = nil
 }
 
 // recycle gives the inner session object back to its home session pool. It is
@@ -55,6 +71,7 @@ func (sh *sessionHandle) recycle() {
 		// sessionHandle has already been recycled.
 		return
 	}
+	sh.untrack()
 	sh.session.recycle()
 	sh.session = nil
 }
@@ -109,6 +126,9 @@ func (sh *sessionHandle) getTransactionID() transactionID {
 func (sh *sessionHandle) destroy() {
 	sh.mu.Lock()
 	s := sh.session
+	if s != nil {
+		sh.untrack()
+	}
 	sh.session = nil
 	sh.mu.Unlock()
 	if s == nil {
@@ -359,6 +379,21 @@ type SessionPoolConfig struct {
 	// Defaults to 5m.
 	HealthCheckInterval time.Duration
 
+	// TrackSessionHandles determines whether the session pool records the
+	// stack trace of the goroutine that checks out each session. The stack
+	// traces of the sessions that are checked out are included in the error
+	// that is returned when a session cannot be obtained before the context
+	// is done, are returned by Client.CheckedOutSessions, and are logged when
+	// the client is closed while sessions are still checked out. This makes
+	// it possible to find sessions that are held for a long time or that are
+	// never returned to the pool.
+	//
+	// Recording a stack trace for each checkout is expensive, so this should
+	// only be enabled while debugging session leaks.
+	//
+	// Defaults to false.
+	TrackSessionHandles bool
+
 	// healthCheckSampleInterval is how often the health checker samples live
 	// session (for use in maintaining session pool size).
 	//
@@ -447,6 +482,18 @@ type sessionPool struct {
 	createReqs uint64
 	// prepareReqs is the number of ongoing session preparation request.
 	prepareReqs uint64
+	// numWaiters is the number of calls to take or takeWriteSession that are
+	// waiting for a session to become available.
+	numWaiters uint64
+	// numCreationFailures is the number of sessions whose creation failed.
+	numCreationFailures uint64
+	// numGetSessionTimeouts is the number of calls to take or
+	// takeWriteSession that failed because their context was done before a
+	// session became available.
+	numGetSessionTimeouts uint64
+	// checkedOut holds a *CheckedOutSession for each session that is checked
+	// out of the pool, if TrackSessionHandles is true.
+	checkedOut list.List
 	// configuration of the session pool.
 	SessionPoolConfig
 	// hc is the health checker
@@ -469,6 +516,7 @@ func newSessionPool(sc *sessionClient, config SessionPoolConfig) (*sessionPool,
 		SessionPoolConfig: config,
 		mw:                newMaintenanceWindow(config.MaxOpened),
 	}
+	recordStat(context.Background(), MaxAllowedSessionsCount, int64(config.MaxOpened))
 	if config.HealthCheckWorkers == 0 {
 		// With 10 workers and assuming average latency of 5ms for
 		// BeginTransaction, we will be able to prepare 2000 tx/sec in advance.
@@ -508,9 +556,10 @@ func (p *sessionPool) initPool(numSessions int32) error {
 	p.mu.Lock()
 	// Take budget before the actual session creation.
 	p.numOpened += uint64(numSessions)
-	recordStat(context.Background(), OpenSessionCount, int64(p.numOpened))
+	numOpened := p.numOpened
 	p.createReqs += uint64(numSessions)
 	p.mu.Unlock()
+	recordStat(context.Background(), OpenSessionCount, int64(numOpened))
 	// Asynchronously create the initial sessions for the pool.
 	return p.sc.batchCreateSessions(numSessions, p)
 }
@@ -550,13 +599,16 @@ func (p *sessionPool) sessionReady(s *session) {
 // the session creation failed.
 func (p *sessionPool) sessionCreationFailed(err error, numSessions int32) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	p.createReqs -= uint64(numSessions)
 	p.numOpened -= uint64(numSessions)
-	recordStat(context.Background(), OpenSessionCount, int64(p.numOpened))
+	p.numCreationFailures += uint64(numSessions)
+	numOpened := p.numOpened
 	// Notify other waiters blocking on session creation.
 	close(p.mayGetSession)
 	p.mayGetSession = make(chan struct{})
+	p.mu.Unlock()
+	recordStat(context.Background(), OpenSessionCount, int64(numOpened))
+	recordStat(context.Background(), SessionCreationFailuresCount, int64(numSessions))
 }
 
 // isValid checks if the session pool is still valid.
@@ -581,6 +633,10 @@ func (p *sessionPool) close() {
 	}
 	p.valid = false
 	p.mu.Unlock()
+	for _, cs := range p.checkedOutSessions() {
+		log.Printf("Session %v is still checked out of the session pool of a closed client; it was checked out at %v by:\n%s",
+			cs.ID, cs.CheckoutTime.Format(time.RFC3339Nano), cs.Stack)
+	}
 	p.hc.close()
 	// destroy all the sessions
 	p.hc.mu.Lock()
@@ -615,13 +671,18 @@ func (p *sessionPool) createSession(ctx context.Context) (*session, error) {
 		if !done {
 			// Session creation failed, give budget back.
 			p.numOpened--
-			recordStat(ctx, OpenSessionCount, int64(p.numOpened))
+			p.numCreationFailures++
 		}
+		numOpened := p.numOpened
 		p.createReqs--
 		// Notify other waiters blocking on session creation.
 		close(p.mayGetSession)
 		p.mayGetSession = make(chan struct{})
 		p.mu.Unlock()
+		if !done {
+			recordStat(ctx, OpenSessionCount, int64(numOpened))
+			recordStat(ctx, SessionCreationFailuresCount, 1)
+		}
 	}
 	s, err := p.sc.createSession(ctx)
 	if err != nil {
@@ -692,32 +753,28 @@ func (p *sessionPool) take(ctx context.Context) (*sessionHandle, error) {
 			if !p.isHealthy(s) {
 				continue
 			}
-			return &sessionHandle{session: s}, nil
+			return p.newSessionHandle(s), nil
 		}
 
 		// Idle list is empty, block if session pool has reached max session
 		// creation concurrency or max number of open sessions.
 		if (p.MaxOpened > 0 && p.numOpened >= p.MaxOpened) || (p.MaxBurst > 0 && p.createReqs >= p.MaxBurst) {
-			mayGetSession := p.mayGetSession
-			p.mu.Unlock()
 			trace.TracePrintf(ctx, nil, "Waiting for read-only session to become available")
-			select {
-			case <-ctx.Done():
-				trace.TracePrintf(ctx, nil, "Context done waiting for session")
-				return nil, errGetSessionTimeout()
-			case <-mayGetSession:
+			if err := p.waitForSession(ctx); err != nil {
+				return nil, err
 			}
 			continue
 		}
 
 		// Take budget before the actual session creation.
 		p.numOpened++
+		numOpened := p.numOpened
 		// Creating a new session that will be returned directly to the client
 		// means that the max number of sessions in use also increases.
 		numCheckedOut := p.currSessionsCheckedOutLocked()
-		recordStat(ctx, OpenSessionCount, int64(p.numOpened))
 		p.createReqs++
 		p.mu.Unlock()
+		recordStat(ctx, OpenSessionCount, int64(numOpened))
 		p.mw.updateMaxSessionsCheckedOutDuringWindow(numCheckedOut)
 		if s, err = p.createSession(ctx); err != nil {
 			trace.TracePrintf(ctx, nil, "Error creating session: %v", err)
@@ -725,7 +782,7 @@ func (p *sessionPool) take(ctx context.Context) (*sessionHandle, error) {
 		}
 		trace.TracePrintf(ctx, map[string]interface{}{"sessionID": s.getID()},
 			"Created session")
-		return &sessionHandle{session: s}, nil
+		return p.newSessionHandle(s), nil
 	}
 }
 
@@ -771,26 +828,22 @@ func (p *sessionPool) takeWriteSession(ctx context.Context) (*sessionHandle, err
 			// Idle list is empty, block if session pool has reached max session
 			// creation concurrency or max number of open sessions.
 			if (p.MaxOpened > 0 && p.numOpened >= p.MaxOpened) || (p.MaxBurst > 0 && p.createReqs >= p.MaxBurst) {
-				mayGetSession := p.mayGetSession
-				p.mu.Unlock()
 				trace.TracePrintf(ctx, nil, "Waiting for read-write session to become available")
-				select {
-				case <-ctx.Done():
-					trace.TracePrintf(ctx, nil, "Context done waiting for session")
-					return nil, errGetSessionTimeout()
-				case <-mayGetSession:
+				if err := p.waitForSession(ctx); err != nil {
+					return nil, err
 				}
 				continue
 			}
 
 			// Take budget before the actual session creation.
 			p.numOpened++
+			numOpened := p.numOpened
 			// Creating a new session that will be returned directly to the client
 			// means that the max number of sessions in use also increases.
 			numCheckedOut := p.currSessionsCheckedOutLocked()
-			recordStat(ctx, OpenSessionCount, int64(p.numOpened))
 			p.createReqs++
 			p.mu.Unlock()
+			recordStat(ctx, OpenSessionCount, int64(numOpened))
 			p.mw.updateMaxSessionsCheckedOutDuringWindow(numCheckedOut)
 			if s, err = p.createSession(ctx); err != nil {
 				trace.TracePrintf(ctx, nil, "Error creating session: %v", err)
@@ -807,7 +860,7 @@ func (p *sessionPool) takeWriteSession(ctx context.Context) (*sessionHandle, err
 				return nil, toSpannerError(err)
 			}
 		}
-		return &sessionHandle{session: s}, nil
+		return p.newSessionHandle(s), nil
 	}
 }
 
@@ -838,10 +891,10 @@ func (p *sessionPool) recycle(s *session) bool {
 // such cases, only idle sessions can be removed.
 func (p *sessionPool) remove(s *session, isExpire bool) bool {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	if isExpire && (p.numOpened <= p.MinOpened || s.getIdleList() == nil) {
 		// Don't expire session if the session is not in idle list (in use), or
 		// if number of open sessions is going below p.MinOpened.
+		p.mu.Unlock()
 		return false
 	}
 	ol := s.setIdleList(nil)
@@ -854,12 +907,15 @@ func (p *sessionPool) remove(s *session, isExpire bool) bool {
 	if s.invalidate() {
 		// Decrease the number of opened sessions.
 		p.numOpened--
-		recordStat(context.Background(), OpenSessionCount, int64(p.numOpened))
+		numOpened := p.numOpened
 		// Broadcast that a session has been destroyed.
 		close(p.mayGetSession)
 		p.mayGetSession = make(chan struct{})
+		p.mu.Unlock()
+		recordStat(context.Background(), OpenSessionCount, int64(numOpened))
 		return true
 	}
+	p.mu.Unlock()
 	return false
 }
 
@@ -867,6 +923,173 @@ func (p *sessionPool) currSessionsCheckedOutLocked() uint64 {
 	return p.numOpened - uint64(p.idleList.Len()) - uint64(p.idleWriteList.Len())
 }
 
+// recordStats records the number of idle, in use and waiting sessions of the
+// session pool. It is called by the maintainer of the health checker once per
+// sample interval, rather than on every change, so that taking and recycling
+// sessions doesn't pay for it. OpenSessionCount is recorded on every change.
+func (p *sessionPool) recordStats(ctx context.Context) {
+	p.mu.Lock()
+	numIdle := p.idleList.Len() + p.idleWriteList.Len()
+	numCheckedOut := p.statsLocked().NumCheckedOut
+	numWaiters := p.numWaiters
+	p.mu.Unlock()
+	recordStat(ctx, IdleSessionCount, int64(numIdle))
+	recordStat(ctx, InUseSessionCount, int64(numCheckedOut))
+	recordStat(ctx, SessionWaiterCount, int64(numWaiters))
+}
+
+// newSessionHandle returns a sessionHandle for a session that has been
+// checked out of the pool, recording the stack of the caller if
+// TrackSessionHandles is enabled.
+func (p *sessionPool) newSessionHandle(s *session) *sessionHandle {
+	sh := &sessionHandle{session: s}
+	if p.TrackSessionHandles {
+		cs := &CheckedOutSession{
+			ID:           s.getID(),
+			CheckoutTime: time.Now(),
+			Stack:        string(debug.Stack()),
+		}
+		p.mu.Lock()
+		sh.checkout = p.checkedOut.PushBack(cs)
+		p.mu.Unlock()
+	}
+	return sh
+}
+
+// untrack removes an element from the list of checked out sessions.
+func (p *sessionPool) untrack(e *list.Element) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checkedOut.Remove(e)
+}
+
+// waitForSession blocks until a session may have become available, or ctx is
+// done. It must be called with p.mu held, and returns with p.mu released.
+func (p *sessionPool) waitForSession(ctx context.Context) error {
+	mayGetSession := p.mayGetSession
+	p.numWaiters++
+	p.mu.Unlock()
+	var err error
+	select {
+	case <-ctx.Done():
+		trace.TracePrintf(ctx, nil, "Context done waiting for session")
+		err = p.getSessionTimeoutError()
+	case <-mayGetSession:
+	}
+	p.mu.Lock()
+	p.numWaiters--
+	if err != nil {
+		p.numGetSessionTimeouts++
+	}
+	p.mu.Unlock()
+	if err != nil {
+		recordStat(ctx, GetSessionTimeoutsCount, 1)
+	}
+	return err
+}
+
+// getSessionTimeoutError returns errGetSessionTimeout, including the stacks of
+// the goroutines that checked out sessions if TrackSessionHandles is enabled.
+func (p *sessionPool) getSessionTimeoutError() error {
+	err := errGetSessionTimeout()
+	if !p.TrackSessionHandles {
+		return err
+	}
+	cs := p.checkedOutSessions()
+	if len(cs) == 0 {
+		return err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d sessions checked out of the pool:", len(cs))
+	for _, s := range cs {
+		fmt.Fprintf(&buf, "\n\nsession %s checked out at %v by:\n%s", s.ID, s.CheckoutTime.Format(time.RFC3339Nano), s.Stack)
+	}
+	err.(*Error).Desc += "\n" + buf.String()
+	return err
+}
+
+// checkedOutSessions returns the sessions that are checked out of the pool,
+// oldest first.
+func (p *sessionPool) checkedOutSessions() []CheckedOutSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var cs []CheckedOutSession
+	for e := p.checkedOut.Front(); e != nil; e = e.Next() {
+		cs = append(cs, *e.Value.(*CheckedOutSession))
+	}
+	return cs
+}
+
+// stats returns a snapshot of the state of the session pool.
+func (p *sessionPool) stats() SessionPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.statsLocked()
+}
+
+func (p *sessionPool) statsLocked() SessionPoolStats {
+	st := SessionPoolStats{
+		MaxOpened:             p.MaxOpened,
+		NumOpened:             p.numOpened,
+		NumIdleRead:           uint64(p.idleList.Len()),
+		NumIdleWrite:          uint64(p.idleWriteList.Len()),
+		NumCreating:           p.createReqs,
+		NumWaiters:            p.numWaiters,
+		NumCreationFailures:   p.numCreationFailures,
+		NumGetSessionTimeouts: p.numGetSessionTimeouts,
+	}
+	// Sessions that are being created or prepared for write are neither idle
+	// nor checked out.
+	notCheckedOut := st.NumIdleRead + st.NumIdleWrite + p.createReqs + p.prepareReqs
+	if st.NumOpened > notCheckedOut {
+		st.NumCheckedOut = st.NumOpened - notCheckedOut
+	}
+	return st
+}
+
+// SessionPoolStats is a snapshot of the state of the session pool of a Client.
+// It is EXPERIMENTAL and subject to change or removal without notice.
+type SessionPoolStats struct {
+	// MaxOpened is the maximum number of sessions that the pool may open.
+	MaxOpened uint64
+	// NumOpened is the number of sessions that are open, including those that
+	// are being created.
+	NumOpened uint64
+	// NumIdleRead is the number of idle sessions.
+	NumIdleRead uint64
+	// NumIdleWrite is the number of idle sessions that have been prepared for
+	// a read/write transaction.
+	NumIdleWrite uint64
+	// NumCheckedOut is the number of sessions that are in use by
+	// transactions.
+	NumCheckedOut uint64
+	// NumCreating is the number of sessions that are being created.
+	NumCreating uint64
+	// NumWaiters is the number of requests that are waiting for a session to
+	// become available.
+	NumWaiters uint64
+	// NumCreationFailures is the number of sessions whose creation has failed
+	// since the client was created.
+	NumCreationFailures uint64
+	// NumGetSessionTimeouts is the number of requests that have failed because
+	// their context was done before a session became available, since the
+	// client was created.
+	NumGetSessionTimeouts uint64
+}
+
+// CheckedOutSession describes a session that is checked out of the session
+// pool. Sessions are only tracked if SessionPoolConfig.TrackSessionHandles is
+// true.
+// It is EXPERIMENTAL and subject to change or removal without notice.
+type CheckedOutSession struct {
+	// ID is the name of the session.
+	ID string
+	// CheckoutTime is the time at which the session was checked out.
+	CheckoutTime time.Time
+	// Stack is the stack trace of the goroutine that checked out the session.
+	Stack string
+}
+
 // hcHeap implements heap.Interface. It is used to create the priority queue for
 // session healthchecks.
 type hcHeap struct {
@@ -1221,6 +1444,7 @@ func (hc *healthChecker) maintainer() {
 			return
 		}
 
+		hc.pool.recordStats(context.Background())
 		hc.pool.mu.Lock()
 		currSessionsOpened := hc.pool.numOpened
 		maxIdle := hc.pool.MaxIdle
@@ -1282,10 +1506,11 @@ func (hc *healthChecker) growPool(ctx context.Context, growToNumSessions uint64)
 			break
 		}
 		p.numOpened++
-		recordStat(ctx, OpenSessionCount, int64(p.numOpened))
+		numOpened := p.numOpened
 		p.createReqs++
 		shouldPrepareWrite := p.shouldPrepareWriteLocked()
 		p.mu.Unlock()
+		recordStat(ctx, OpenSessionCount, int64(numOpened))
 		var (
 			s   *session
 			err error
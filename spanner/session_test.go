@@ -22,10 +22,12 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
 	. "cloud.google.com/go/spanner/internal/testutil"
+	"go.opencensus.io/stats/view"
 	sppb "google.golang.org/genproto/googleapis/spanner/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -632,6 +634,188 @@ func TestSessionDestroy(t *testing.T) {
 	}
 }
 
+// TestSessionPoolStats tests the snapshots of the session pool state.
+func TestSessionPoolStats(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	_, client, teardown := setupMockedTestServerWithConfig(t,
+		ClientConfig{
+			SessionPoolConfig: SessionPoolConfig{
+				MaxOpened: 1,
+			},
+		})
+	defer teardown()
+	sp := client.idleSessions
+
+	if got, want := client.SessionPoolStats(), (SessionPoolStats{MaxOpened: 1}); got != want {
+		t.Fatalf("initial stats mismatch\n got %+v\nwant %+v", got, want)
+	}
+	sh, err := sp.take(ctx)
+	if err != nil {
+		t.Fatalf("cannot take session from session pool: %v", err)
+	}
+	if got, want := client.SessionPoolStats(), (SessionPoolStats{MaxOpened: 1, NumOpened: 1, NumCheckedOut: 1}); got != want {
+		t.Fatalf("stats after take mismatch\n got %+v\nwant %+v", got, want)
+	}
+
+	// A second request has to wait for the first session.
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := sp.take(ctx2); err == nil {
+		t.Fatal("second take succeeded, want timeout")
+	}
+	if got := client.SessionPoolStats().NumGetSessionTimeouts; got != 1 {
+		t.Fatalf("NumGetSessionTimeouts = %d, want 1", got)
+	}
+	done := make(chan error)
+	go func() {
+		sh, err := sp.take(ctx)
+		if err == nil {
+			sh.recycle()
+		}
+		done <- err
+	}()
+	waitFor(t, func() error {
+		if got := client.SessionPoolStats().NumWaiters; got != 1 {
+			return fmt.Errorf("NumWaiters = %d, want 1", got)
+		}
+		return nil
+	})
+	sh.recycle()
+	if err := <-done; err != nil {
+		t.Fatalf("waiting take failed: %v", err)
+	}
+	if got, want := client.SessionPoolStats(), (SessionPoolStats{MaxOpened: 1, NumOpened: 1, NumIdleRead: 1, NumGetSessionTimeouts: 1}); got != want {
+		t.Fatalf("stats after recycle mismatch\n got %+v\nwant %+v", got, want)
+	}
+}
+
+// TestSessionPoolStatsViews tests that the maintainer records the size of the
+// session pool. It is not run in parallel, since the views aggregate the
+// values recorded by all session pools.
+func TestSessionPoolStatsViews(t *testing.T) {
+	ctx := context.Background()
+	if err := view.Register(InUseSessionCountView); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(InUseSessionCountView)
+	_, client, teardown := setupMockedTestServerWithConfig(t,
+		ClientConfig{
+			SessionPoolConfig: SessionPoolConfig{
+				healthCheckSampleInterval: 10 * time.Millisecond,
+			},
+		})
+	defer teardown()
+
+	sh, err := client.idleSessions.take(ctx)
+	if err != nil {
+		t.Fatalf("cannot take session from session pool: %v", err)
+	}
+	defer sh.recycle()
+	waitFor(t, func() error {
+		rows, err := view.RetrieveData(InUseSessionCountView.Name)
+		if err != nil {
+			return err
+		}
+		if len(rows) != 1 {
+			return fmt.Errorf("got %d rows, want 1", len(rows))
+		}
+		if got := rows[0].Data.(*view.LastValueData).Value; got != 1 {
+			return fmt.Errorf("in use sessions = %v, want 1", got)
+		}
+		return nil
+	})
+}
+
+// TestOpenSessionCountView tests that the number of open sessions is recorded
+// as soon as it changes, and not only when the pool's gauges are sampled.
+func TestOpenSessionCountView(t *testing.T) {
+	ctx := context.Background()
+	if err := view.Register(OpenSessionCountView); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(OpenSessionCountView)
+	_, client, teardown := setupMockedTestServerWithConfig(t,
+		ClientConfig{
+			SessionPoolConfig: SessionPoolConfig{
+				MinOpened:                 0,
+				healthCheckSampleInterval: time.Hour,
+			},
+		})
+	defer teardown()
+
+	for want := 1; want <= 2; want++ {
+		sh, err := client.idleSessions.take(ctx)
+		if err != nil {
+			t.Fatalf("cannot take session from session pool: %v", err)
+		}
+		defer sh.recycle()
+		rows, err := view.RetrieveData(OpenSessionCountView.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("got %d rows, want 1", len(rows))
+		}
+		if got := rows[0].Data.(*view.LastValueData).Value; got != float64(want) {
+			t.Errorf("open sessions = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestTrackSessionHandles tests that the checkouts of sessions are recorded
+// when SessionPoolConfig.TrackSessionHandles is set.
+func TestTrackSessionHandles(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	_, client, teardown := setupMockedTestServerWithConfig(t,
+		ClientConfig{
+			SessionPoolConfig: SessionPoolConfig{
+				MaxOpened:           1,
+				TrackSessionHandles: true,
+			},
+		})
+	defer teardown()
+	sp := client.idleSessions
+
+	sh, err := sp.take(ctx)
+	if err != nil {
+		t.Fatalf("cannot take session from session pool: %v", err)
+	}
+	cs := client.CheckedOutSessions()
+	if len(cs) != 1 {
+		t.Fatalf("got %d checked out sessions, want 1", len(cs))
+	}
+	if cs[0].ID != sh.getID() {
+		t.Errorf("checked out session ID = %q, want %q", cs[0].ID, sh.getID())
+	}
+	if !strings.Contains(cs[0].Stack, "TestTrackSessionHandles") {
+		t.Errorf("stack of checked out session does not contain the test:\n%s", cs[0].Stack)
+	}
+
+	// The error for a timed out request includes the stacks of the checked out
+	// sessions.
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = sp.takeWriteSession(ctx2)
+	if ErrCode(err) != codes.Canceled || !strings.Contains(ErrDesc(err), "TestTrackSessionHandles") {
+		t.Errorf("takeWriteSession returned %v, want timeout with stack of checked out session", err)
+	}
+
+	sh.recycle()
+	if cs := client.CheckedOutSessions(); len(cs) != 0 {
+		t.Fatalf("got %d checked out sessions after recycle, want 0", len(cs))
+	}
+	sh, err = sp.takeWriteSession(ctx)
+	if err != nil {
+		t.Fatalf("cannot take write session from session pool: %v", err)
+	}
+	sh.destroy()
+	if cs := client.CheckedOutSessions(); len(cs) != 0 {
+		t.Fatalf("got %d checked out sessions after destroy, want 0", len(cs))
+	}
+}
+
 // TestHcHeap tests heap operation on top of hcHeap.
 func TestHcHeap(t *testing.T) {
 	in := []*session{
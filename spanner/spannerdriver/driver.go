@@ -0,0 +1,542 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package spannerdriver provides a database/sql driver for Cloud Spanner.
+
+The driver is registered under the name "spanner". The data source name is the
+name of a database:
+
+	db, err := sql.Open("spanner", "projects/P/instances/I/databases/D")
+
+A *spanner.Client is created for the database the first time a connection is
+needed. It is shared by all the *sql.DBs opened for the same database, and is
+closed when the last of them is closed. To configure the client, create it
+yourself and use NewConnector:
+
+	db := sql.OpenDB(spannerdriver.NewConnector(client, nil))
+
+Queries and statements use Spanner's named parameters. Arguments passed with
+sql.Named are bound to the parameter of the same name; other arguments are
+bound by position to the parameters @p1, @p2, and so on.
+
+	rows, err := db.QueryContext(ctx, "SELECT Name FROM Singers WHERE Id = @id", sql.Named("id", 7))
+
+Transactions
+
+A read-write transaction started with BeginTx is run as a Spanner read-write
+transaction. If Spanner aborts the transaction, it is retried by replaying the
+statements executed so far; if a replayed statement produces a different
+result than it did originally, the operation that observed the abort fails
+with ErrAbortedDueToConcurrentModification. To support this, the results of
+queries executed in a read-write transaction are read in full when the query
+is executed.
+
+A transaction started with sql.TxOptions.ReadOnly set is run as a Spanner
+read-only transaction. Statements executed outside a transaction are run in
+a single-use read-only transaction (queries) or in their own read-write
+transaction (DML).
+
+Connection settings
+
+Each connection has settings that control how statements are run. They start
+out with the values in Config, and may be changed by executing one of the
+following statements:
+
+	SET READ_ONLY_STALENESS = 'STRONG'
+	SET READ_ONLY_STALENESS = 'EXACT_STALENESS 10s'
+	SET READ_ONLY_STALENESS = 'MAX_STALENESS 10s'
+	SET READ_ONLY_STALENESS = 'READ_TIMESTAMP 2020-01-02T15:04:05Z'
+	SET READ_ONLY_STALENESS = 'MIN_READ_TIMESTAMP 2020-01-02T15:04:05Z'
+	SET AUTOCOMMIT_DML_MODE = 'TRANSACTIONAL'
+	SET AUTOCOMMIT_DML_MODE = 'PARTITIONED_NON_ATOMIC'
+
+Spanner accepts the MAX_STALENESS and MIN_READ_TIMESTAMP bounds only in
+single-use transactions, so they apply only to queries executed outside of a
+transaction. BeginTx fails for a read-only transaction while one of them is set.
+
+Settings are reset when a connection is returned to the *sql.DB's pool,
+so they should be changed on a *sql.Conn obtained from (*sql.DB).Conn.
+
+Mutations
+
+Mutations are applied with ApplyMutations. In a read-write transaction they
+are buffered and applied when the transaction commits; outside a transaction
+they are applied immediately.
+
+Types
+
+Not all Spanner values have an equivalent among the driver.Value types, so
+query results may hold other types. A DATE is a civil.Date, an ARRAY is a
+slice of the spanner.NullXXX type of its elements (or [][]byte for
+ARRAY<BYTES>), and other types, such as STRUCT, are a
+spanner.GenericColumnValue. database/sql assigns these values to a Scan
+destination of the same type or to an *interface{}; to scan them into other
+types, use a destination that implements sql.Scanner. The same types, and any
+other type accepted by the spanner package, may be passed as arguments.
+*/
+package spannerdriver // import "cloud.google.com/go/spanner/spannerdriver"
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/grpc/codes"
+)
+
+func init() {
+	sql.Register("spanner", &Driver{})
+}
+
+// ErrAbortedDueToConcurrentModification is returned when a read-write
+// transaction was aborted by Spanner and could not be retried because the
+// data it had read was modified in the meantime.
+var ErrAbortedDueToConcurrentModification = errors.New("spannerdriver: transaction was aborted and could not be retried due to a concurrent modification")
+
+var errConnectorClosed = errors.New("spannerdriver: connector is closed")
+
+// Driver is the database/sql driver for Cloud Spanner.
+type Driver struct {
+	mu         sync.Mutex
+	connectors map[string]*connector // by database name
+}
+
+// Open returns a new connection to the named database.
+// It shares the *spanner.Client of the connectors returned by OpenConnector
+// for the same database, and holds on to it until the connection is closed.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c := d.connector(name)
+	dc, err := c.Connect(context.Background())
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	dc.(*conn).connector = c
+	return dc, nil
+}
+
+// OpenConnector returns a connector for the named database.
+// It implements driver.DriverContext.
+//
+// The connectors for the same database share a *spanner.Client, which is
+// closed when the last of them is closed.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	return d.connector(name), nil
+}
+
+// connector returns the connector for the named database, and adds a
+// reference to it that is released by its Close method.
+func (d *Driver) connector(name string) *connector {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.connectors[name]
+	if !ok {
+		c = &connector{driver: d, database: name}
+		if d.connectors == nil {
+			d.connectors = make(map[string]*connector)
+		}
+		d.connectors[name] = c
+	}
+	c.refs++
+	return c
+}
+
+// release removes a reference to c, and reports whether it was the last one.
+func (d *Driver) release(c *connector) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.connectors[c.database] != c {
+		// c was made by NewConnector, or was already released.
+		return false
+	}
+	c.refs--
+	if c.refs > 0 {
+		return false
+	}
+	delete(d.connectors, c.database)
+	return true
+}
+
+// AutocommitDMLMode controls how DML statements executed outside of a
+// transaction are run.
+type AutocommitDMLMode int
+
+const (
+	// Transactional runs each DML statement in its own read-write transaction.
+	Transactional AutocommitDMLMode = iota
+	// PartitionedNonAtomic runs each DML statement as partitioned DML.
+	// See (*spanner.Client).PartitionedUpdate.
+	PartitionedNonAtomic
+)
+
+func (m AutocommitDMLMode) String() string {
+	switch m {
+	case Transactional:
+		return "TRANSACTIONAL"
+	case PartitionedNonAtomic:
+		return "PARTITIONED_NON_ATOMIC"
+	}
+	return fmt.Sprintf("AutocommitDMLMode(%d)", int(m))
+}
+
+// Config holds the initial settings of the connections made by a connector.
+type Config struct {
+	// ReadOnlyStaleness is the timestamp bound of read-only transactions,
+	// and of queries executed outside of a transaction, in the syntax of the
+	// READ_ONLY_STALENESS setting, such as "EXACT_STALENESS 10s".
+	// The empty string is a strong read. A bounded staleness, MAX_STALENESS
+	// or MIN_READ_TIMESTAMP, can only be used outside of a transaction.
+	ReadOnlyStaleness string
+
+	// AutocommitDMLMode controls how DML statements executed outside of a
+	// transaction are run. The default is Transactional.
+	AutocommitDMLMode AutocommitDMLMode
+}
+
+// NewConnector returns a connector that makes connections using client.
+// The caller remains responsible for closing client once the *sql.DB that
+// uses the connector is closed. If config is nil, the defaults are used.
+// If config is invalid, the connector fails to make connections.
+func NewConnector(client *spanner.Client, config *Config) driver.Connector {
+	c := &connector{driver: &Driver{}, client: client}
+	if config != nil {
+		c.config = *config
+		if config.ReadOnlyStaleness != "" {
+			c.staleness, c.err = parseStaleness(config.ReadOnlyStaleness)
+		}
+	}
+	return c
+}
+
+type connector struct {
+	driver   *Driver
+	database string
+	config   Config
+	refs     int // guarded by driver.mu
+
+	// staleness is config.ReadOnlyStaleness, parsed.
+	staleness staleness
+
+	once   sync.Once
+	client *spanner.Client
+	err    error // of parsing config or of making client
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.once.Do(func() {
+		if c.client != nil {
+			return
+		}
+		// The client outlives ctx, which is only for this connection.
+		c.client, c.err = spanner.NewClient(context.Background(), c.database)
+	})
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &conn{
+		client:           c.client,
+		config:           c.config,
+		defaultStaleness: c.staleness,
+		staleness:        c.staleness,
+		dmlMode:          c.config.AutocommitDMLMode,
+	}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return c.driver }
+
+// Close releases the connector. It is called by (*sql.DB).Close.
+// The client of the connectors returned by OpenConnector is closed when the
+// last of them is released. A client passed to NewConnector is not closed.
+func (c *connector) Close() error {
+	if !c.driver.release(c) {
+		return nil
+	}
+	// If no connection was made, make sure that none will be.
+	c.once.Do(func() { c.err = errConnectorClosed })
+	if c.client != nil {
+		c.client.Close()
+	}
+	return nil
+}
+
+// Execer is satisfied by *sql.DB, *sql.Conn and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// ApplyMutations applies ms using e.
+// If e is a read-write transaction, the mutations are buffered and applied
+// when the transaction commits. Otherwise they are applied atomically
+// in a transaction of their own.
+func ApplyMutations(ctx context.Context, e Execer, ms ...*spanner.Mutation) error {
+	_, err := e.ExecContext(ctx, "", ms)
+	return err
+}
+
+// conn is a connection to a database. It is not used concurrently.
+type conn struct {
+	client           *spanner.Client
+	config           Config
+	defaultStaleness staleness // config.ReadOnlyStaleness, parsed
+
+	// Connection settings.
+	staleness staleness
+	dmlMode   AutocommitDMLMode
+
+	tx transaction // the current transaction, or nil
+
+	connector *connector // if made by Driver.Open, released by Close
+}
+
+// transaction is satisfied by *roTx and *rwTx.
+type transaction interface {
+	driver.Tx
+	query(ctx context.Context, stmt spanner.Statement) (driver.Rows, error)
+	exec(ctx context.Context, stmt spanner.Statement) (driver.Result, error)
+	bufferWrite(ms []*spanner.Mutation) error
+}
+
+var (
+	_ driver.Conn                           = (*conn)(nil)
+	_ driver.ConnBeginTx                    = (*conn)(nil)
+	_ driver.ExecerContext                  = (*conn)(nil)
+	_ driver.QueryerContext                 = (*conn)(nil)
+	_ driver.NamedValueChecker              = (*conn)(nil)
+	_ driver.Pinger                         = (*conn)(nil)
+	_ driver.SessionResetter                = (*conn)(nil)
+	_ driver.StmtExecContext                = (*stmt)(nil)
+	_ driver.StmtQueryContext               = (*stmt)(nil)
+	_ driver.DriverContext                  = (*Driver)(nil)
+	_ io.Closer                             = (*connector)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	if c.connector != nil {
+		defer c.connector.Close()
+	}
+	if c.tx != nil {
+		return c.tx.Rollback()
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("spannerdriver: a transaction is already in progress")
+	}
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelSerializable:
+	default:
+		return nil, fmt.Errorf("spannerdriver: unsupported isolation level %v", sql.IsolationLevel(opts.Isolation))
+	}
+	if opts.ReadOnly {
+		if c.staleness.bounded {
+			return nil, fmt.Errorf("spannerdriver: read-only transactions cannot use the timestamp bound %v; it is only allowed outside of a transaction", c.staleness.tb)
+		}
+		c.tx = &roTx{
+			conn: c,
+			tx:   c.client.ReadOnlyTransaction().WithTimestampBound(c.staleness.tb),
+		}
+		return c.tx, nil
+	}
+	tx, err := beginReadWrite(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	c.tx = tx
+	return tx, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	iter := c.client.Single().Query(ctx, spanner.NewStatement("SELECT 1"))
+	defer iter.Stop()
+	_, err := iter.Next()
+	return err
+}
+
+// ResetSession restores the connection settings to the connector's defaults
+// when the connection is reused.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if c.tx != nil {
+		return driver.ErrBadConn
+	}
+	c.staleness = c.defaultStaleness
+	c.dmlMode = c.config.AutocommitDMLMode
+	return nil
+}
+
+// CheckNamedValue accepts all values, leaving their validation to Spanner.
+// Values that implement driver.Valuer are converted first.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, ok := nv.Value.(driver.Valuer); ok {
+		var err error
+		nv.Value, err = v.Value()
+		return err
+	}
+	return nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	stmt, err := newStatement(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if c.tx != nil {
+		return c.tx.query(ctx, stmt)
+	}
+	return newRows(c.client.Single().WithTimestampBound(c.staleness.tb).Query(ctx, stmt))
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if ok, err := c.applySetting(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		return driver.ResultNoRows, nil
+	}
+	if query == "" {
+		ms, err := mutations(args)
+		if err != nil {
+			return nil, err
+		}
+		if c.tx != nil {
+			return driver.ResultNoRows, c.tx.bufferWrite(ms)
+		}
+		if _, err := c.client.Apply(ctx, ms); err != nil {
+			return nil, err
+		}
+		return driver.ResultNoRows, nil
+	}
+
+	stmt, err := newStatement(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if c.tx != nil {
+		return c.tx.exec(ctx, stmt)
+	}
+	var n int64
+	switch c.dmlMode {
+	case PartitionedNonAtomic:
+		n, err = c.client.PartitionedUpdate(ctx, stmt)
+	default:
+		_, err = c.client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+			var err error
+			n, err = tx.Update(ctx, stmt)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+// newStatement returns a statement for query with the given arguments bound to
+// its parameters.
+func newStatement(query string, args []driver.NamedValue) (spanner.Statement, error) {
+	stmt := spanner.NewStatement(query)
+	for _, arg := range args {
+		if isMutation(arg.Value) {
+			return spanner.Statement{}, errors.New("spannerdriver: mutations must be applied with ApplyMutations")
+		}
+		name := arg.Name
+		if name == "" {
+			name = fmt.Sprintf("p%d", arg.Ordinal)
+		}
+		stmt.Params[name] = arg.Value
+	}
+	return stmt, nil
+}
+
+func isMutation(v interface{}) bool {
+	switch v.(type) {
+	case *spanner.Mutation, []*spanner.Mutation:
+		return true
+	}
+	return false
+}
+
+// mutations returns the mutations passed as arguments to an empty statement.
+func mutations(args []driver.NamedValue) ([]*spanner.Mutation, error) {
+	var ms []*spanner.Mutation
+	for _, arg := range args {
+		switch v := arg.Value.(type) {
+		case *spanner.Mutation:
+			ms = append(ms, v)
+		case []*spanner.Mutation:
+			ms = append(ms, v...)
+		default:
+			return nil, fmt.Errorf("spannerdriver: empty statement with argument of type %T; only mutations are allowed", v)
+		}
+	}
+	return ms, nil
+}
+
+// endTx is called when the current transaction finishes.
+func (c *conn) endTx() { c.tx = nil }
+
+// isAborted reports whether err means that Spanner aborted a transaction.
+func isAborted(err error) bool {
+	return err != nil && spanner.ErrCode(err) == codes.Aborted
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nvs
+}
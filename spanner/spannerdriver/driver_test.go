@@ -0,0 +1,472 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spannerdriver
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/spanner"
+	. "cloud.google.com/go/spanner/internal/testutil"
+	"cloud.google.com/go/spanner/spannertest"
+	"cloud.google.com/go/spanner/spansql"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testDB = "projects/fake-proj/instances/fake-instance/databases/fake-db"
+
+// openFake returns a *sql.DB backed by an in-memory fake with the given schema.
+func openFake(t *testing.T, schema string) (*sql.DB, func()) {
+	t.Helper()
+	srv, err := spannertest.NewServer("localhost:0")
+	if err != nil {
+		t.Fatalf("Starting in-memory fake: %v", err)
+	}
+	srv.SetLogger(t.Logf)
+	ddl, err := spansql.ParseDDL(schema)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("Parsing schema: %v", err)
+	}
+	if err := srv.UpdateDDL(ddl); err != nil {
+		srv.Close()
+		t.Fatalf("Applying schema: %v", err)
+	}
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, srv.Addr, grpc.WithInsecure())
+	if err != nil {
+		srv.Close()
+		t.Fatalf("Dialing in-memory fake: %v", err)
+	}
+	client, err := spanner.NewClient(ctx, testDB, option.WithGRPCConn(conn))
+	if err != nil {
+		srv.Close()
+		t.Fatalf("Connecting to in-memory fake: %v", err)
+	}
+	db := sql.OpenDB(NewConnector(client, nil))
+	return db, func() {
+		db.Close()
+		client.Close()
+		conn.Close()
+		srv.Close()
+	}
+}
+
+const singersSchema = `CREATE TABLE Singers (
+	Id INT64 NOT NULL,
+	Name STRING(MAX),
+	Tags ARRAY<INT64>,
+	Born DATE,
+) PRIMARY KEY (Id)`
+
+func TestQueryAndExec(t *testing.T) {
+	db, cleanup := openFake(t, singersSchema)
+	defer cleanup()
+	ctx := context.Background()
+
+	res, err := db.ExecContext(ctx, "INSERT INTO Singers (Id, Name, Tags, Born) VALUES (@p1, @name, @p3, @p4)",
+		1, sql.Named("name", "Marc"), []int64{4, 5}, civil.Date{Year: 1990, Month: 2, Day: 3})
+	if err != nil {
+		t.Fatalf("Inserting: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		t.Errorf("RowsAffected = %d, %v; want 1, nil", n, err)
+	}
+	if err := ApplyMutations(ctx, db, spanner.Insert("Singers", []string{"Id"}, []interface{}{2})); err != nil {
+		t.Fatalf("Applying mutations: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT Id, Name, Tags, Born FROM Singers ORDER BY Id")
+	if err != nil {
+		t.Fatalf("Querying: %v", err)
+	}
+	type singer struct {
+		ID   int64
+		Name sql.NullString
+		Tags []spanner.NullInt64
+		Born interface{}
+	}
+	var got []singer
+	for rows.Next() {
+		var s singer
+		if err := rows.Scan(&s.ID, &s.Name, &s.Tags, &s.Born); err != nil {
+			t.Fatalf("Scanning: %v", err)
+		}
+		got = append(got, s)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Iterating: %v", err)
+	}
+	want := []singer{
+		{
+			ID:   1,
+			Name: sql.NullString{String: "Marc", Valid: true},
+			Tags: []spanner.NullInt64{{Int64: 4, Valid: true}, {Int64: 5, Valid: true}},
+			Born: civil.Date{Year: 1990, Month: 2, Day: 3},
+		},
+		{ID: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query results wrong.\n got %+v\nwant %+v", got, want)
+	}
+
+	// Column names are known even without results.
+	rows, err = db.QueryContext(ctx, "SELECT Id, Name FROM Singers WHERE Id > 10")
+	if err != nil {
+		t.Fatalf("Querying: %v", err)
+	}
+	cols, err := rows.Columns()
+	rows.Close()
+	if err != nil || !reflect.DeepEqual(cols, []string{"Id", "Name"}) {
+		t.Errorf("Columns = %q, %v; want [Id Name], nil", cols, err)
+	}
+
+	if _, err := db.QueryContext(ctx, "SELECT * FROM NoSuchTable"); err == nil {
+		t.Errorf("Querying a missing table succeeded")
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	db, cleanup := openFake(t, singersSchema)
+	defer cleanup()
+	ctx := context.Background()
+
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM Singers").Scan(&n); err != nil {
+			t.Fatalf("Counting: %v", err)
+		}
+		return n
+	}
+
+	// Read-write transaction that is committed.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO Singers (Id) VALUES (1)"); err != nil {
+		t.Fatalf("Inserting: %v", err)
+	}
+	if err := ApplyMutations(ctx, tx, spanner.Insert("Singers", []string{"Id"}, []interface{}{2})); err != nil {
+		t.Fatalf("Buffering mutations: %v", err)
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Singers").Scan(&n); err != nil {
+		t.Fatalf("Counting in transaction: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Count in transaction = %d, want 1 (mutations are not visible until commit)", n)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Count after commit = %d, want 2", n)
+	}
+
+	// Read-write transaction that is rolled back.
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM Singers WHERE TRUE"); err != nil {
+		t.Fatalf("Deleting: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Count after rollback = %d, want 2", n)
+	}
+
+	// Read-only transaction.
+	tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Singers").Scan(&n); err != nil || n != 2 {
+		t.Errorf("Count in read-only transaction = %d, %v; want 2, nil", n, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM Singers WHERE TRUE"); err != errReadOnly {
+		t.Errorf("DML in read-only transaction: got %v, want %v", err, errReadOnly)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if _, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadUncommitted}); err == nil {
+		t.Errorf("BeginTx with LevelReadUncommitted succeeded")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	db, cleanup := openFake(t, singersSchema)
+	defer cleanup()
+	ctx := context.Background()
+
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.ExecContext(ctx, "INSERT INTO Singers (Id) VALUES (1)"); err != nil {
+		t.Fatalf("Inserting: %v", err)
+	}
+	for _, stmt := range []string{
+		"SET AUTOCOMMIT_DML_MODE = 'PARTITIONED_NON_ATOMIC'",
+		"set read_only_staleness='MAX_STALENESS 10s';",
+		"SET READ_ONLY_STALENESS = 'STRONG'",
+	} {
+		if _, err := c.ExecContext(ctx, stmt); err != nil {
+			t.Errorf("%s: %v", stmt, err)
+		}
+	}
+	if _, err := c.ExecContext(ctx, "UPDATE Singers SET Name = 'x' WHERE TRUE"); err != nil {
+		t.Fatalf("Partitioned update: %v", err)
+	}
+
+	// Bounded staleness is only allowed outside of a transaction.
+	if _, err := c.ExecContext(ctx, "SET READ_ONLY_STALENESS = 'MAX_STALENESS 10s'"); err != nil {
+		t.Fatalf("Setting MAX_STALENESS: %v", err)
+	}
+	var n int
+	if err := c.QueryRowContext(ctx, "SELECT COUNT(*) FROM Singers").Scan(&n); err != nil || n != 1 {
+		t.Errorf("Count with MAX_STALENESS = %d, %v; want 1, nil", n, err)
+	}
+	if _, err := c.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err == nil {
+		t.Errorf("BeginTx with MAX_STALENESS succeeded")
+	}
+	if _, err := c.ExecContext(ctx, "SET READ_ONLY_STALENESS = 'EXACT_STALENESS 0s'"); err != nil {
+		t.Fatalf("Setting EXACT_STALENESS: %v", err)
+	}
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("BeginTx with EXACT_STALENESS: %v", err)
+	}
+	tx.Rollback()
+	for _, stmt := range []string{
+		"SET AUTOCOMMIT_DML_MODE = 'EVENTUALLY'",
+		"SET READ_ONLY_STALENESS = 'EXACT_STALENESS soon'",
+		"SET READ_ONLY_STALENESS = STRONG",
+		"SET NO_SUCH_SETTING = 'x'",
+	} {
+		if _, err := c.ExecContext(ctx, stmt); err == nil {
+			t.Errorf("%s succeeded, should have failed", stmt)
+		}
+	}
+}
+
+func TestParseStaleness(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in          string
+		want        spanner.TimestampBound
+		wantBounded bool
+	}{
+		{"STRONG", spanner.StrongRead(), false},
+		{"exact_staleness 10s", spanner.ExactStaleness(10 * time.Second), false},
+		{"MAX_STALENESS 1m", spanner.MaxStaleness(time.Minute), true},
+		{"READ_TIMESTAMP 2020-01-02T15:04:05Z", spanner.ReadTimestamp(ts), false},
+		{"MIN_READ_TIMESTAMP 2020-01-02T15:04:05Z", spanner.MinReadTimestamp(ts), true},
+	}
+	for _, test := range tests {
+		got, err := parseStaleness(test.in)
+		if err != nil {
+			t.Errorf("parseStaleness(%q): %v", test.in, err)
+			continue
+		}
+		if got.tb.String() != test.want.String() || got.bounded != test.wantBounded {
+			t.Errorf("parseStaleness(%q) = %v (bounded: %t), want %v (bounded: %t)",
+				test.in, got.tb, got.bounded, test.want, test.wantBounded)
+		}
+	}
+}
+
+func TestConfigStaleness(t *testing.T) {
+	ctx := context.Background()
+	_, opts, teardown := NewMockedSpannerInMemTestServer(t)
+	defer teardown()
+	client, err := spanner.NewClientWithConfig(ctx, testDB, spanner.ClientConfig{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	db := sql.OpenDB(NewConnector(client, &Config{ReadOnlyStaleness: "MAX_STALENESS 10s"}))
+	defer db.Close()
+	if _, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err == nil {
+		t.Error("BeginTx with a MAX_STALENESS config succeeded")
+	}
+
+	bad := sql.OpenDB(NewConnector(client, &Config{ReadOnlyStaleness: "SOON"}))
+	defer bad.Close()
+	if err := bad.PingContext(ctx); err == nil {
+		t.Error("Ping with an invalid ReadOnlyStaleness config succeeded")
+	}
+}
+
+// openMock returns a *sql.DB backed by the mocked test server.
+func openMock(t *testing.T) (*sql.DB, *MockedSpannerInMemTestServer, func()) {
+	t.Helper()
+	server, opts, teardown := NewMockedSpannerInMemTestServer(t)
+	client, err := spanner.NewClientWithConfig(context.Background(), testDB, spanner.ClientConfig{}, opts...)
+	if err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(NewConnector(client, nil))
+	return db, server, func() {
+		db.Close()
+		client.Close()
+		teardown()
+	}
+}
+
+func TestAbortRetry(t *testing.T) {
+	db, server, cleanup := openMock(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// An aborted DML statement is retried transparently.
+	server.TestSpanner.PutExecutionTime(MethodExecuteSql, SimulatedExecutionTime{
+		Errors: []error{status.Error(codes.Aborted, "Aborted")},
+	})
+	// So is an aborted commit, after the statements have been replayed.
+	server.TestSpanner.PutExecutionTime(MethodCommitTransaction, SimulatedExecutionTime{
+		Errors: []error{status.Error(codes.Aborted, "Aborted")},
+	})
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	res, err := tx.ExecContext(ctx, UpdateBarSetFoo)
+	if err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != UpdateBarSetFooRowCount {
+		t.Errorf("RowsAffected = %d, want %d", n, UpdateBarSetFooRowCount)
+	}
+	var sum, n int64
+	rows, err := tx.QueryContext(ctx, SelectFooFromBar)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	for rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		sum += n
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if sum != 3 {
+		t.Errorf("Sum of results = %d, want 3", sum)
+	}
+
+	// A replayed statement that produces a different result fails the transaction.
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, UpdateBarSetFoo); err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	server.TestSpanner.PutStatementResult(UpdateBarSetFoo, &StatementResult{
+		Type:        StatementResultUpdateCount,
+		UpdateCount: UpdateBarSetFooRowCount + 1,
+	})
+	server.TestSpanner.PutExecutionTime(MethodCommitTransaction, SimulatedExecutionTime{
+		Errors: []error{status.Error(codes.Aborted, "Aborted")},
+	})
+	if err := tx.Commit(); err != ErrAbortedDueToConcurrentModification {
+		t.Errorf("Commit: got %v, want %v", err, ErrAbortedDueToConcurrentModification)
+	}
+}
+
+func TestConnectorClose(t *testing.T) {
+	_, opts, teardown := NewMockedSpannerInMemTestServer(t)
+	defer teardown()
+	client, err := spanner.NewClientWithConfig(context.Background(), testDB, spanner.ClientConfig{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ping := func() error {
+		iter := client.Single().Query(context.Background(), spanner.NewStatement(SelectFooFromBar))
+		defer iter.Stop()
+		_, err := iter.Next()
+		return err
+	}
+
+	d := &Driver{}
+	c1, _ := d.OpenConnector(testDB)
+	c2, _ := d.OpenConnector(testDB)
+	if c1 != c2 {
+		t.Fatal("OpenConnector returned different connectors for the same database")
+	}
+	// Pretend that the connector created the client.
+	c := c1.(*connector)
+	c.once.Do(func() { c.client = client })
+	conn, err := d.Open(testDB)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// The client is closed when the connectors and the connection are closed.
+	if err := c1.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ping(); err != nil {
+		t.Fatalf("Client was closed while a connector was open: %v", err)
+	}
+	if err := c2.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ping(); err == nil {
+		t.Error("Client was not closed with the last connector")
+	}
+	if c3, _ := d.OpenConnector(testDB); c3 == c1 {
+		t.Error("OpenConnector returned a closed connector")
+	}
+
+	// A client passed to NewConnector is left open.
+	client2, err := spanner.NewClientWithConfig(context.Background(), testDB, spanner.ClientConfig{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	client = client2
+	if err := NewConnector(client2, nil).(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ping(); err != nil {
+		t.Errorf("NewConnector's client was closed: %v", err)
+	}
+}
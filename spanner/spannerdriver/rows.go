@@ -0,0 +1,186 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spannerdriver
+
+// This file holds the conversion of query results to driver values.
+
+import (
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/spanner"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+	"google.golang.org/api/iterator"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+// rows iterates over the results of a query, either streaming them from a
+// *spanner.RowIterator or from a buffer.
+type rows struct {
+	ri    *spanner.RowIterator // nil if all results are buffered
+	cols  []string
+	types []string
+	buf   []*spanner.Row // rows read ahead of the caller
+	done  bool           // whether ri is exhausted
+}
+
+// newRows returns an iterator over the results of ri.
+// It reads the first result, so that errors in the query are reported early
+// and the columns are known.
+func newRows(ri *spanner.RowIterator) (*rows, error) {
+	r := &rows{ri: ri}
+	row, err := ri.Next()
+	switch err {
+	case nil:
+		r.buf = append(r.buf, row)
+	case iterator.Done:
+		r.done = true
+	default:
+		ri.Stop()
+		return nil, err
+	}
+	if md := ri.Metadata; md != nil && md.RowType != nil {
+		for _, f := range md.RowType.Fields {
+			r.cols = append(r.cols, f.Name)
+			r.types = append(r.types, typeName(f.Type))
+		}
+	}
+	return r, nil
+}
+
+func (r *rows) Columns() []string { return r.cols }
+
+// ColumnTypeDatabaseTypeName returns the Spanner type of a column,
+// such as "INT64" or "ARRAY<STRING>".
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string { return r.types[index] }
+
+func (r *rows) Close() error {
+	if r.ri != nil {
+		r.ri.Stop()
+	}
+	return nil
+}
+
+// nextRow returns the next result, or io.EOF.
+func (r *rows) nextRow() (*spanner.Row, error) {
+	if len(r.buf) > 0 {
+		row := r.buf[0]
+		r.buf = r.buf[1:]
+		return row, nil
+	}
+	if r.done {
+		return nil, io.EOF
+	}
+	row, err := r.ri.Next()
+	if err == iterator.Done {
+		r.done = true
+		return nil, io.EOF
+	}
+	return row, err
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	row, err := r.nextRow()
+	if err != nil {
+		return err
+	}
+	for i := range dest {
+		dest[i], err = columnValue(row, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnValue returns the value of column i of row.
+//
+// Scalar values are returned as bool, int64, float64, string, []byte,
+// civil.Date and time.Time, and NULL as nil. Arrays are returned as slices of
+// the spanner.NullXXX types (or [][]byte), so that NULL elements are preserved;
+// a NULL array is a nil slice.
+// Other values are returned as a spanner.GenericColumnValue.
+// The values that are not driver.Value types are described in the package
+// documentation.
+func columnValue(row *spanner.Row, i int) (driver.Value, error) {
+	var gcv spanner.GenericColumnValue
+	if err := row.Column(i, &gcv); err != nil {
+		return nil, err
+	}
+	var ptr interface{}
+	switch gcv.Type.Code {
+	case sppb.TypeCode_BOOL:
+		ptr = new(bool)
+	case sppb.TypeCode_INT64:
+		ptr = new(int64)
+	case sppb.TypeCode_FLOAT64:
+		ptr = new(float64)
+	case sppb.TypeCode_STRING:
+		ptr = new(string)
+	case sppb.TypeCode_BYTES:
+		ptr = new([]byte)
+	case sppb.TypeCode_DATE:
+		ptr = new(civil.Date)
+	case sppb.TypeCode_TIMESTAMP:
+		ptr = new(time.Time)
+	case sppb.TypeCode_ARRAY:
+		switch gcv.Type.ArrayElementType.Code {
+		case sppb.TypeCode_BOOL:
+			ptr = new([]spanner.NullBool)
+		case sppb.TypeCode_INT64:
+			ptr = new([]spanner.NullInt64)
+		case sppb.TypeCode_FLOAT64:
+			ptr = new([]spanner.NullFloat64)
+		case sppb.TypeCode_STRING:
+			ptr = new([]spanner.NullString)
+		case sppb.TypeCode_BYTES:
+			ptr = new([][]byte)
+		case sppb.TypeCode_DATE:
+			ptr = new([]spanner.NullDate)
+		case sppb.TypeCode_TIMESTAMP:
+			ptr = new([]spanner.NullTime)
+		}
+	}
+	if _, ok := gcv.Value.Kind.(*proto3.Value_NullValue); ok {
+		if gcv.Type.Code == sppb.TypeCode_ARRAY && ptr != nil {
+			// A nil slice can be scanned into a slice, unlike nil.
+			return reflect.ValueOf(ptr).Elem().Interface(), nil
+		}
+		return nil, nil
+	}
+	if ptr == nil {
+		return gcv, nil
+	}
+	if err := gcv.Decode(ptr); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(ptr).Elem().Interface(), nil
+}
+
+// typeName returns the name of a Spanner type, as it is written in DDL.
+func typeName(t *sppb.Type) string {
+	switch t.Code {
+	case sppb.TypeCode_ARRAY:
+		return "ARRAY<" + typeName(t.ArrayElementType) + ">"
+	case sppb.TypeCode_STRUCT:
+		return "STRUCT"
+	}
+	return t.Code.String()
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spannerdriver
+
+// This file holds the handling of the SET statements that change connection settings.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+// applySetting reports whether query is a SET statement,
+// and if so, applies it to the connection.
+func (c *conn) applySetting(query string) (bool, error) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "SET") {
+		return false, nil
+	}
+	rest := strings.TrimSpace(query)[len("SET"):]
+	eq := strings.Index(rest, "=")
+	if eq < 0 {
+		return true, fmt.Errorf("spannerdriver: malformed SET statement %q", query)
+	}
+	name := strings.ToUpper(strings.TrimSpace(rest[:eq]))
+	value := strings.TrimSpace(rest[eq+1:])
+	value = strings.TrimSpace(strings.TrimSuffix(value, ";"))
+	if len(value) < 2 || value[0] != '\'' || value[len(value)-1] != '\'' {
+		return true, fmt.Errorf("spannerdriver: value of %s must be a single-quoted string, got %s", name, value)
+	}
+	value = value[1 : len(value)-1]
+
+	switch name {
+	case "READ_ONLY_STALENESS":
+		tb, err := parseStaleness(value)
+		if err != nil {
+			return true, err
+		}
+		c.staleness = tb
+	case "AUTOCOMMIT_DML_MODE":
+		switch strings.ToUpper(value) {
+		case "TRANSACTIONAL":
+			c.dmlMode = Transactional
+		case "PARTITIONED_NON_ATOMIC":
+			c.dmlMode = PartitionedNonAtomic
+		default:
+			return true, fmt.Errorf("spannerdriver: unknown AUTOCOMMIT_DML_MODE %q", value)
+		}
+	default:
+		return true, fmt.Errorf("spannerdriver: unknown connection setting %s", name)
+	}
+	return true, nil
+}
+
+// staleness is a parsed READ_ONLY_STALENESS setting. The zero value is a
+// strong read.
+type staleness struct {
+	tb spanner.TimestampBound
+	// bounded is true for the MAX_STALENESS and MIN_READ_TIMESTAMP bounds,
+	// which Spanner accepts only in single-use transactions.
+	bounded bool
+}
+
+// parseStaleness parses the value of a READ_ONLY_STALENESS setting.
+func parseStaleness(s string) (staleness, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return staleness{}, fmt.Errorf("spannerdriver: empty READ_ONLY_STALENESS")
+	}
+	kind := strings.ToUpper(fields[0])
+	if kind == "STRONG" {
+		if len(fields) != 1 {
+			return staleness{}, fmt.Errorf("spannerdriver: STRONG takes no argument")
+		}
+		return staleness{tb: spanner.StrongRead()}, nil
+	}
+	if len(fields) != 2 {
+		return staleness{}, fmt.Errorf("spannerdriver: %s takes exactly one argument", kind)
+	}
+	arg := fields[1]
+	switch kind {
+	case "EXACT_STALENESS", "MAX_STALENESS":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return staleness{}, fmt.Errorf("spannerdriver: bad %s: %v", kind, err)
+		}
+		if kind == "EXACT_STALENESS" {
+			return staleness{tb: spanner.ExactStaleness(d)}, nil
+		}
+		return staleness{tb: spanner.MaxStaleness(d), bounded: true}, nil
+	case "READ_TIMESTAMP", "MIN_READ_TIMESTAMP":
+		t, err := time.Parse(time.RFC3339Nano, arg)
+		if err != nil {
+			return staleness{}, fmt.Errorf("spannerdriver: bad %s: %v", kind, err)
+		}
+		if kind == "READ_TIMESTAMP" {
+			return staleness{tb: spanner.ReadTimestamp(t)}, nil
+		}
+		return staleness{tb: spanner.MinReadTimestamp(t), bounded: true}, nil
+	}
+	return staleness{}, fmt.Errorf("spannerdriver: unknown READ_ONLY_STALENESS kind %s", kind)
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spannerdriver
+
+// This file holds the transaction implementations.
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"errors"
+	"io"
+
+	"cloud.google.com/go/spanner"
+	"github.com/golang/protobuf/proto"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+)
+
+var errReadOnly = errors.New("spannerdriver: cannot write in a read-only transaction")
+
+// roTx is a read-only transaction.
+type roTx struct {
+	conn *conn
+	tx   *spanner.ReadOnlyTransaction
+}
+
+func (t *roTx) Commit() error {
+	t.tx.Close()
+	t.conn.endTx()
+	return nil
+}
+
+func (t *roTx) Rollback() error {
+	return t.Commit()
+}
+
+func (t *roTx) query(ctx context.Context, stmt spanner.Statement) (driver.Rows, error) {
+	return newRows(t.tx.Query(ctx, stmt))
+}
+
+func (t *roTx) exec(ctx context.Context, stmt spanner.Statement) (driver.Result, error) {
+	return nil, errReadOnly
+}
+
+func (t *roTx) bufferWrite(ms []*spanner.Mutation) error {
+	return errReadOnly
+}
+
+// errRollback is returned from the transaction function to make the client
+// roll back the transaction.
+var errRollback = errors.New("spannerdriver: transaction rolled back")
+
+// rwTx is a read-write transaction.
+//
+// The database/sql API is imperative, while (*spanner.Client).ReadWriteTransaction
+// takes a function that it calls once per attempt. The two are bridged by a
+// goroutine that runs ReadWriteTransaction with a function that hands each
+// attempt's *spanner.ReadWriteTransaction to the rwTx, and then returns
+// whatever the rwTx tells it to: nil to commit, an ABORTED error to start a
+// new attempt, or any other error to roll back.
+//
+// When a new attempt starts, the operations executed in earlier attempts are
+// replayed, and their results compared with the original ones.
+type rwTx struct {
+	conn *conn
+	ctx  context.Context // from BeginTx; bounds the whole transaction
+
+	attempts chan *spanner.ReadWriteTransaction // each attempt, from the transaction function
+	results  chan error                         // the transaction function's return value
+	done     chan struct{}                      // closed when ReadWriteTransaction returns
+	err      error                              // ReadWriteTransaction's error; set before done is closed
+
+	tx      *spanner.ReadWriteTransaction // the current attempt
+	history []replayer                    // the operations executed so far
+	failed  error                         // if non-nil, the transaction cannot proceed
+}
+
+// A replayer is an operation that can be executed again in a new attempt.
+type replayer interface {
+	// replay executes the operation in tx. It returns an error if the
+	// operation fails, or ErrAbortedDueToConcurrentModification if its
+	// result differs from that of the original execution.
+	replay(ctx context.Context, tx *spanner.ReadWriteTransaction) error
+}
+
+func beginReadWrite(ctx context.Context, c *conn) (*rwTx, error) {
+	t := &rwTx{
+		conn:     c,
+		ctx:      ctx,
+		attempts: make(chan *spanner.ReadWriteTransaction),
+		results:  make(chan error),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		_, t.err = c.client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+			select {
+			case t.attempts <- tx:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			select {
+			case err := <-t.results:
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(t.done)
+	}()
+	select {
+	case t.tx = <-t.attempts:
+		return t, nil
+	case <-t.done:
+		return nil, t.err
+	}
+}
+
+// finish makes the transaction function of the current attempt return err.
+func (t *rwTx) finish(err error) {
+	select {
+	case t.results <- err:
+	case <-t.done:
+	}
+}
+
+// retry abandons the current attempt with the ABORTED error err, and replays
+// the history in new attempts until one succeeds or the transaction fails.
+func (t *rwTx) retry(err error) error {
+	for {
+		t.finish(err)
+		select {
+		case <-t.done:
+			t.failed = t.err
+			return t.err
+		case t.tx = <-t.attempts:
+			if err = t.replay(); err == nil {
+				return nil
+			}
+		}
+	}
+}
+
+// replay executes the history in the current attempt.
+func (t *rwTx) replay() error {
+	for _, op := range t.history {
+		if err := op.replay(t.ctx, t.tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run calls f with the current attempt, retrying the transaction if f fails
+// because it was aborted.
+func (t *rwTx) run(f func(tx *spanner.ReadWriteTransaction) error) error {
+	if t.failed != nil {
+		return t.failed
+	}
+	for {
+		err := f(t.tx)
+		if !isAborted(err) {
+			return err
+		}
+		if err := t.retry(err); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *rwTx) Commit() error {
+	defer t.conn.endTx()
+	if t.failed != nil {
+		return t.failed
+	}
+	var err error
+	for {
+		t.finish(err)
+		select {
+		case <-t.done:
+			return t.err
+		case t.tx = <-t.attempts:
+			// The commit was aborted.
+			err = t.replay()
+		}
+	}
+}
+
+func (t *rwTx) Rollback() error {
+	defer t.conn.endTx()
+	t.finish(errRollback)
+	<-t.done
+	return nil
+}
+
+func (t *rwTx) query(ctx context.Context, stmt spanner.Statement) (driver.Rows, error) {
+	var q *queryResult
+	err := t.run(func(tx *spanner.ReadWriteTransaction) error {
+		var err error
+		q, err = runQuery(ctx, tx, stmt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.history = append(t.history, q)
+	return q.rows(), nil
+}
+
+func (t *rwTx) exec(ctx context.Context, stmt spanner.Statement) (driver.Result, error) {
+	var n int64
+	err := t.run(func(tx *spanner.ReadWriteTransaction) error {
+		var err error
+		n, err = tx.Update(ctx, stmt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.history = append(t.history, &updateResult{stmt: stmt, count: n})
+	return driver.RowsAffected(n), nil
+}
+
+func (t *rwTx) bufferWrite(ms []*spanner.Mutation) error {
+	err := t.run(func(tx *spanner.ReadWriteTransaction) error {
+		return tx.BufferWrite(ms)
+	})
+	if err != nil {
+		return err
+	}
+	t.history = append(t.history, bufferedWrite(ms))
+	return nil
+}
+
+// updateResult records the execution of a DML statement.
+type updateResult struct {
+	stmt  spanner.Statement
+	count int64
+}
+
+func (u *updateResult) replay(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+	n, err := tx.Update(ctx, u.stmt)
+	if err != nil {
+		return err
+	}
+	if n != u.count {
+		return ErrAbortedDueToConcurrentModification
+	}
+	return nil
+}
+
+// bufferedWrite records mutations buffered in a transaction.
+type bufferedWrite []*spanner.Mutation
+
+func (bw bufferedWrite) replay(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+	return tx.BufferWrite(bw)
+}
+
+// queryResult records the execution of a query, and holds its results.
+type queryResult struct {
+	stmt     spanner.Statement
+	columns  []string
+	types    []string
+	all      []*spanner.Row
+	checksum [sha256.Size]byte
+}
+
+func (q *queryResult) replay(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+	q2, err := runQuery(ctx, tx, q.stmt)
+	if err != nil {
+		return err
+	}
+	if q2.checksum != q.checksum {
+		return ErrAbortedDueToConcurrentModification
+	}
+	return nil
+}
+
+// runQuery executes a query and reads all of its results.
+func runQuery(ctx context.Context, tx *spanner.ReadWriteTransaction, stmt spanner.Statement) (*queryResult, error) {
+	r, err := newRows(tx.Query(ctx, stmt))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	q := &queryResult{stmt: stmt, columns: r.cols, types: r.types}
+	h := sha256.New()
+	for {
+		row, err := r.nextRow()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		vals := &proto3.ListValue{}
+		for i := 0; i < row.Size(); i++ {
+			var gcv spanner.GenericColumnValue
+			if err := row.Column(i, &gcv); err != nil {
+				return nil, err
+			}
+			vals.Values = append(vals.Values, gcv.Value)
+		}
+		b, err := proto.Marshal(vals)
+		if err != nil {
+			return nil, err
+		}
+		h.Write(b)
+		q.all = append(q.all, row)
+	}
+	copy(q.checksum[:], h.Sum(nil))
+	return q, nil
+}
+
+// rows returns an iterator over the query's results.
+func (q *queryResult) rows() *rows {
+	return &rows{cols: q.columns, types: q.types, buf: q.all, done: true}
+}
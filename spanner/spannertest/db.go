@@ -19,13 +19,13 @@ package spannertest
 // This file contains the implementation of the Spanner fake itself,
 // namely the part behind the RPC interface.
 
-// TODO: missing transactionality in a serious way!
-
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -39,21 +39,78 @@ import (
 
 type database struct {
 	mu      sync.Mutex
-	tables  map[string]*table
-	indexes map[string]struct{} // only record their existence
+	tables  map[string]*table // must be replaced, not modified; see publish
+	indexes map[string]string // index name to the name of its table
+
+	// lastTS is the timestamp of the latest change to tables.
+	// maxTS is the latest timestamp handed out for either a change or a read;
+	// any later change must have a later timestamp.
+	lastTS, maxTS time.Time
+
+	// history holds the earlier versions of tables, oldest first,
+	// so that reads can be served at a timestamp in the past.
+	history []dbVersion
+	pruned  bool // whether any versions have been discarded from history
+
+	// mods records when tables were last modified.
+	// It is used to detect conflicts between read-write transactions.
+	mods map[string]*tableMods
 }
 
-type table struct {
-	mu sync.Mutex
+// dbVersion is a version of the tables of a database.
+// Neither the map nor the tables in it may be modified.
+type dbVersion struct {
+	ts     time.Time // when this version became current
+	tables map[string]*table
+}
+
+// versionRetention is how long a version of the database is kept after it is superseded.
+// This matches the version garbage collection period of Cloud Spanner.
+const versionRetention = 1 * time.Hour
 
+// tableMods records when a table and its rows were last modified.
+type tableMods struct {
+	any  time.Time            // last change to any row
+	wide time.Time            // last change that may have affected every row (e.g. a schema change)
+	keys map[string]time.Time // last change to each row, keyed by keyString
+}
+
+type table struct {
 	// Information about the table columns.
 	// They are reordered on table creation so the primary key columns come first.
 	cols     []colInfo
 	colIndex map[string]int // col name to index
 	pkCols   int            // number of primary key columns (may be 0)
 
+	// parent is the name of the table that this table is interleaved in, if any.
+	// Its primary key is a prefix of this table's primary key.
+	// onDelete says what happens to this table's rows when their parent row is deleted.
+	parent   string
+	onDelete spansql.OnDelete
+
 	// Rows are stored in primary key order.
+	// A row may be shared between versions of a table,
+	// so it must be replaced rather than modified in place.
 	rows []row
+
+	// indexes holds the secondary indexes on the table, keyed by name.
+	// They are maintained as rows are written, and copied when the table is cloned.
+	indexes map[string]*index
+}
+
+// index represents a secondary index on a table.
+type index struct {
+	name         string
+	unique       bool
+	nullFiltered bool
+
+	keyCols []int  // table column indexes of the index key
+	desc    []bool // whether each index key column is in descending order
+	storing []int  // table column indexes of the STORING columns
+
+	// entries holds an entry for each indexed row, sorted in index order.
+	// Each entry is the index key followed by the row's primary key.
+	entries []row
 }
 
 // colInfo represents information about a column in a table or result set.
@@ -72,7 +129,7 @@ The mapping between Spanner types and Go types internal to this package are:
 	STRING		string
 	BYTES		[]byte
 	DATE		string (RFC 3339 date; "YYYY-MM-DD")
-	TIMESTAMP	TODO
+	TIMESTAMP	time.Time (in UTC)
 	ARRAY<T>	[]T
 	STRUCT		TODO
 */
@@ -104,22 +161,27 @@ func (d *database) ApplyDDL(stmt spansql.DDLStmt) *status.Status {
 	defer d.mu.Unlock()
 
 	// Lazy init.
-	if d.tables == nil {
-		d.tables = make(map[string]*table)
-	}
 	if d.indexes == nil {
-		d.indexes = make(map[string]struct{})
+		d.indexes = make(map[string]string)
+	}
+
+	// Schema changes produce a new version of the tables,
+	// since the current version may still be in use by transactions.
+	tables := make(map[string]*table, len(d.tables)+1)
+	for name, t := range d.tables {
+		tables[name] = t
 	}
 
 	switch stmt := stmt.(type) {
 	default:
 		return status.Newf(codes.Unimplemented, "unhandled DDL statement type %T", stmt)
 	case spansql.CreateTable:
-		if _, ok := d.tables[stmt.Name]; ok {
+		if _, ok := tables[stmt.Name]; ok {
 			return status.Newf(codes.AlreadyExists, "table %s already exists", stmt.Name)
 		}
-
-		// TODO: check stmt.Interleave details.
+		if _, ok := d.indexes[stmt.Name]; ok {
+			return status.Newf(codes.AlreadyExists, "an index named %s already exists", stmt.Name)
+		}
 
 		// Move primary keys first, preserving their order.
 		pk := make(map[string]int)
@@ -145,32 +207,82 @@ func (d *database) ApplyDDL(stmt spansql.DDLStmt) *status.Status {
 				return status.Newf(codes.InvalidArgument, "primary key column %q not in table", col)
 			}
 		}
-		d.tables[stmt.Name] = t
+		if stmt.Interleave != nil {
+			parent, ok := tables[stmt.Interleave.Parent]
+			if !ok {
+				return status.Newf(codes.NotFound, "no table named %s to interleave %s in", stmt.Interleave.Parent, stmt.Name)
+			}
+			// The parent's primary key must be a prefix of the child's.
+			if parent.pkCols > t.pkCols {
+				return status.Newf(codes.InvalidArgument, "table %s must have all the primary key columns of its parent table %s", stmt.Name, stmt.Interleave.Parent)
+			}
+			for i, pc := range parent.cols[:parent.pkCols] {
+				if c := t.cols[i]; c.Name != pc.Name || c.Type != pc.Type {
+					return status.Newf(codes.InvalidArgument, "primary key column %d of table %s is %s %s, but its parent table %s has %s %s",
+						i+1, stmt.Name, c.Name, c.Type.SQL(), stmt.Interleave.Parent, pc.Name, pc.Type.SQL())
+				}
+			}
+			t.parent = stmt.Interleave.Parent
+			t.onDelete = stmt.Interleave.OnDelete
+		}
+		tables[stmt.Name] = t
+		d.publishDDL(tables, stmt.Name)
 		return nil
 	case spansql.CreateIndex:
 		if _, ok := d.indexes[stmt.Name]; ok {
 			return status.Newf(codes.AlreadyExists, "index %s already exists", stmt.Name)
 		}
-		d.indexes[stmt.Name] = struct{}{}
+		if _, ok := tables[stmt.Name]; ok {
+			return status.Newf(codes.AlreadyExists, "a table named %s already exists", stmt.Name)
+		}
+		t, ok := tables[stmt.Table]
+		if !ok {
+			return status.Newf(codes.NotFound, "no table named %s", stmt.Table)
+		}
+		t = t.clone()
+		if st := t.addIndex(stmt); st.Code() != codes.OK {
+			return st
+		}
+		tables[stmt.Table] = t
+		d.indexes[stmt.Name] = stmt.Table
+		d.publishDDL(tables, stmt.Table)
 		return nil
 	case spansql.DropTable:
-		if _, ok := d.tables[stmt.Name]; !ok {
+		t, ok := tables[stmt.Name]
+		if !ok {
 			return status.Newf(codes.NotFound, "no table named %s", stmt.Name)
 		}
-		// TODO: check for indexes on this table.
-		delete(d.tables, stmt.Name)
+		if len(t.indexes) > 0 {
+			var names []string
+			for name := range t.indexes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return status.Newf(codes.FailedPrecondition, "cannot drop table %s with indexes: %s", stmt.Name, strings.Join(names, ", "))
+		}
+		if children := childTables(tables, stmt.Name); len(children) > 0 {
+			return status.Newf(codes.FailedPrecondition, "cannot drop table %s with interleaved tables: %s", stmt.Name, strings.Join(children, ", "))
+		}
+		delete(tables, stmt.Name)
+		d.publishDDL(tables, stmt.Name)
 		return nil
 	case spansql.DropIndex:
-		if _, ok := d.indexes[stmt.Name]; !ok {
+		tbl, ok := d.indexes[stmt.Name]
+		if !ok {
 			return status.Newf(codes.NotFound, "no index named %s", stmt.Name)
 		}
+		t := tables[tbl].clone()
+		delete(t.indexes, stmt.Name)
+		tables[tbl] = t
 		delete(d.indexes, stmt.Name)
+		d.publishDDL(tables, tbl)
 		return nil
 	case spansql.AlterTable:
-		t, ok := d.tables[stmt.Name]
+		t, ok := tables[stmt.Name]
 		if !ok {
 			return status.Newf(codes.NotFound, "no table named %s", stmt.Name)
 		}
+		t = t.clone()
 		switch alt := stmt.Alteration.(type) {
 		default:
 			return status.Newf(codes.Unimplemented, "unhandled DDL table alteration type %T", alt)
@@ -181,169 +293,976 @@ func (d *database) ApplyDDL(stmt spansql.DDLStmt) *status.Status {
 			if st := t.addColumn(alt.Def); st.Code() != codes.OK {
 				return st
 			}
+			tables[stmt.Name] = t
+			d.publishDDL(tables, stmt.Name)
+			return nil
+		case spansql.SetOnDelete:
+			if t.parent == "" {
+				return status.Newf(codes.FailedPrecondition, "table %s is not interleaved in another table", stmt.Name)
+			}
+			t.onDelete = alt.Action
+			tables[stmt.Name] = t
+			d.publishDDL(tables, stmt.Name)
 			return nil
 		}
 	}
 
 }
 
-func (d *database) table(tbl string) (*table, error) {
+// childTables returns the names of the tables interleaved in the named table, in order.
+func childTables(tables map[string]*table, tbl string) []string {
+	var names []string
+	for name, t := range tables {
+		if t.parent == tbl {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nextTS returns a timestamp for a change to the database.
+// It is later than any timestamp previously handed out.
+// d.mu must be held.
+func (d *database) nextTS() time.Time {
+	ts := time.Now().UTC().Truncate(time.Microsecond)
+	if !ts.After(d.maxTS) {
+		ts = d.maxTS.Add(time.Microsecond)
+	}
+	d.maxTS = ts
+	return ts
+}
+
+// publish makes the given tables the current version of the database,
+// as of ts, which must have come from nextTS.
+// d.mu must be held.
+func (d *database) publish(tables map[string]*table, ts time.Time) {
+	d.history = append(d.history, dbVersion{ts: d.lastTS, tables: d.tables})
+	d.tables, d.lastTS = tables, ts
+
+	// Discard versions that were superseded more than versionRetention ago.
+	cutoff := ts.Add(-versionRetention)
+	n := 0
+	for ; n < len(d.history); n++ {
+		superseded := d.lastTS
+		if n+1 < len(d.history) {
+			superseded = d.history[n+1].ts
+		}
+		if superseded.After(cutoff) {
+			break
+		}
+	}
+	if n > 0 {
+		d.history = append([]dbVersion(nil), d.history[n:]...)
+		d.pruned = true
+	}
+}
+
+// publishDDL publishes tables following a schema change to the named table.
+// d.mu must be held.
+func (d *database) publishDDL(tables map[string]*table, tbl string) {
+	ts := d.nextTS()
+	d.publish(tables, ts)
+	m := d.tableMods(tbl)
+	m.any, m.wide = ts, ts
+}
+
+// tableMods returns the modification record for the named table, creating it if needed.
+// d.mu must be held.
+func (d *database) tableMods(tbl string) *tableMods {
+	if d.mods == nil {
+		d.mods = make(map[string]*tableMods)
+	}
+	m, ok := d.mods[tbl]
+	if !ok {
+		m = &tableMods{keys: make(map[string]time.Time)}
+		d.mods[tbl] = m
+	}
+	return m
+}
+
+// snapshot returns the version of the tables as of the given timestamp.
+// If ts is zero, it returns the current tables, along with a current timestamp.
+func (d *database) snapshot(ts time.Time) (map[string]*table, time.Time, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	t, ok := d.tables[tbl]
+	if ts.IsZero() {
+		ts = time.Now().UTC().Truncate(time.Microsecond)
+		if ts.Before(d.maxTS) {
+			ts = d.maxTS
+		}
+	}
+	// Changes made from now on must not be visible at this timestamp.
+	if ts.After(d.maxTS) {
+		d.maxTS = ts
+	}
+
+	if !ts.Before(d.lastTS) {
+		return d.tables, ts, nil
+	}
+	for i := len(d.history) - 1; i >= 0; i-- {
+		if !ts.Before(d.history[i].ts) {
+			return d.history[i].tables, ts, nil
+		}
+	}
+	if d.pruned {
+		return nil, time.Time{}, status.Errorf(codes.FailedPrecondition, "read timestamp %v is too old", ts.Format(time.RFC3339Nano))
+	}
+	return nil, ts, nil
+}
+
+// transaction represents a transaction on a database.
+//
+// A read-write transaction sees a snapshot of the database taken at its first read or write,
+// along with the effects of its own DML statements. Its writes are buffered
+// and applied at commit, which fails with ABORTED if any data it read
+// was modified in the meantime by another transaction.
+// A read-only transaction sees a snapshot of the database at a fixed timestamp.
+type transaction struct {
+	// mu is held while the transaction is in use.
+	// The methods of transaction and database do not acquire it.
+	mu sync.Mutex
+
+	d           *database
+	readOnly    bool // whether this transaction may not perform writes
+	partitioned bool // whether this is a Partitioned DML transaction
+
+	started  bool
+	finished bool
+	readTS   time.Time         // when the snapshot was taken
+	tables   map[string]*table // the snapshot; must not be modified
+
+	// The remaining fields are only used by read-write transactions.
+
+	// ops are the writes to apply at commit, in order.
+	ops []writeOp
+	// own holds copies of tables modified by this transaction's DML statements,
+	// so that its later reads can see those changes.
+	own map[string]*table
+
+	// Data read by this transaction, which is checked for conflicts at commit.
+	scanned  map[string]bool            // tables that were read in full
+	readKeys map[string]map[string]bool // rows that were read, by table and keyString
+}
+
+// NewTransaction starts a read-write transaction.
+func (d *database) NewTransaction() *transaction {
+	return &transaction{d: d}
+}
+
+// NewPartitionedDMLTransaction starts a transaction for executing Partitioned DML.
+// Each statement executed in it is committed independently.
+func (d *database) NewPartitionedDMLTransaction() *transaction {
+	return &transaction{d: d, partitioned: true}
+}
+
+// NewReadOnlyTransaction starts a read-only transaction that reads the database
+// as of the given timestamp, or reads the latest data if ts is zero.
+func (d *database) NewReadOnlyTransaction(ts time.Time) (*transaction, error) {
+	tables, ts, err := d.snapshot(ts)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{
+		d:        d,
+		readOnly: true,
+		started:  true,
+		readTS:   ts,
+		tables:   tables,
+	}, nil
+}
+
+// ReadTimestamp returns the timestamp at which the transaction reads.
+// It is zero for a read-write transaction that has not yet read or written anything.
+func (tx *transaction) ReadTimestamp() time.Time { return tx.readTS }
+
+// start takes the snapshot for the transaction, if it has not been done yet.
+func (tx *transaction) start() error {
+	if tx.finished {
+		return status.Errorf(codes.FailedPrecondition, "transaction has already finished")
+	}
+	if tx.started {
+		return nil
+	}
+	tables, ts, err := tx.d.snapshot(time.Time{})
+	if err != nil {
+		return err
+	}
+	tx.started, tx.readTS, tx.tables = true, ts, tables
+	return nil
+}
+
+// table returns the named table as seen by the transaction.
+// The returned table must not be modified.
+func (tx *transaction) table(tbl string) (*table, error) {
+	if err := tx.start(); err != nil {
+		return nil, err
+	}
+	t, ok := tx.own[tbl]
+	if !ok {
+		t, ok = tx.tables[tbl]
+	}
 	if !ok {
 		return nil, status.Errorf(codes.NotFound, "no table named %s", tbl)
 	}
 	return t, nil
 }
 
-// writeValues executes a write option (Insert, Update, etc.).
-func (d *database) writeValues(tbl string, cols []string, values []*structpb.ListValue, f func(t *table, colIndexes []int, r row) error) error {
-	t, err := d.table(tbl)
-	if err != nil {
+// noteScan records that the transaction has read a whole table.
+func (tx *transaction) noteScan(tbl string) {
+	if tx.readOnly {
+		return
+	}
+	if tx.scanned == nil {
+		tx.scanned = make(map[string]bool)
+	}
+	tx.scanned[tbl] = true
+}
+
+// noteRead records that the transaction has read the row with the given primary key,
+// whether or not it exists.
+func (tx *transaction) noteRead(tbl string, pk []interface{}) {
+	if tx.readOnly {
+		return
+	}
+	if tx.readKeys == nil {
+		tx.readKeys = make(map[string]map[string]bool)
+	}
+	if tx.readKeys[tbl] == nil {
+		tx.readKeys[tbl] = make(map[string]bool)
+	}
+	tx.readKeys[tbl][keyString(pk)] = true
+}
+
+// checkWritable reports an error if the transaction may not perform writes.
+func (tx *transaction) checkWritable() error {
+	if tx.finished {
+		return status.Errorf(codes.FailedPrecondition, "transaction has already finished")
+	}
+	if tx.readOnly {
+		return status.Errorf(codes.FailedPrecondition, "cannot write in a read-only transaction")
+	}
+	return nil
+}
+
+// buffer adds writes to be applied when the transaction commits.
+// They are not visible to reads in the transaction.
+func (tx *transaction) buffer(op writeOp) error {
+	if err := tx.checkWritable(); err != nil {
 		return err
 	}
+	tx.ops = append(tx.ops, op)
+	return nil
+}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// write applies writes to the transaction's view of the database,
+// and adds them to be applied when the transaction commits.
+// The writes are applied atomically; if op fails, the transaction is unchanged.
+func (tx *transaction) write(op writeOp) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.start(); err != nil {
+		return err
+	}
 
-	colIndexes, err := t.colIndexes(cols)
-	if err != nil {
+	view := make(map[string]*table, len(tx.tables))
+	for name, t := range tx.tables {
+		view[name] = t
+	}
+	for name, t := range tx.own {
+		view[name] = t
+	}
+	w := newWriter(view)
+	if err := op(w); err != nil {
 		return err
 	}
-	revIndex := make(map[int]int) // table index to col index
-	for j, i := range colIndexes {
-		revIndex[i] = j
+	if err := w.checkConstraints(); err != nil {
+		return err
 	}
 
-	for pki := 0; pki < t.pkCols; pki++ {
-		_, ok := revIndex[pki]
-		if !ok {
-			return status.Errorf(codes.InvalidArgument, "primary key column %s not included in write", t.cols[pki].Name)
+	if tx.own == nil {
+		tx.own = make(map[string]*table)
+	}
+	for name, t := range w.tables {
+		tx.own[name] = t
+	}
+	tx.ops = append(tx.ops, op)
+	return nil
+}
+
+// Commit applies the transaction's writes to the database,
+// and returns the commit timestamp.
+// It fails with ABORTED if another transaction has committed changes
+// to data read by this transaction since it took its snapshot.
+func (tx *transaction) Commit() (time.Time, error) {
+	if err := tx.checkWritable(); err != nil {
+		return time.Time{}, err
+	}
+	tx.finished = true
+
+	d := tx.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := tx.checkConflicts(); err != nil {
+		return time.Time{}, err
+	}
+
+	w := newWriter(d.tables)
+	for _, op := range tx.ops {
+		if err := op(w); err != nil {
+			return time.Time{}, err
+		}
+	}
+	// Constraints are checked once all the writes have been applied,
+	// since intermediate states may legitimately violate them.
+	if err := w.checkConstraints(); err != nil {
+		return time.Time{}, err
+	}
+
+	ts := d.nextTS()
+	if len(w.tables) == 0 {
+		return ts, nil
+	}
+	tables := make(map[string]*table, len(d.tables))
+	for name, t := range d.tables {
+		tables[name] = t
+	}
+	for name, t := range w.tables {
+		tables[name] = t
+
+		m := d.tableMods(name)
+		m.any = ts
+		for k := range w.keys[name] {
+			m.keys[k] = ts
+		}
+	}
+	d.publish(tables, ts)
+	return ts, nil
+}
+
+// checkConflicts reports an ABORTED error if any data read by the transaction
+// has been modified since its snapshot was taken.
+// tx.d.mu must be held.
+func (tx *transaction) checkConflicts() error {
+	aborted := func(tbl string) error {
+		return status.Errorf(codes.Aborted, "transaction aborted: data in table %s was modified by a concurrent transaction", tbl)
+	}
+	for tbl := range tx.scanned {
+		if m := tx.d.mods[tbl]; m != nil && m.any.After(tx.readTS) {
+			return aborted(tbl)
+		}
+	}
+	for tbl, keys := range tx.readKeys {
+		m := tx.d.mods[tbl]
+		if m == nil {
+			continue
+		}
+		if m.wide.After(tx.readTS) {
+			return aborted(tbl)
+		}
+		for k := range keys {
+			if m.keys[k].After(tx.readTS) {
+				return aborted(tbl)
+			}
+		}
+	}
+	return nil
+}
+
+// Rollback abandons the transaction, discarding any writes.
+func (tx *transaction) Rollback() {
+	tx.finished = true
+	tx.ops, tx.own = nil, nil
+}
+
+// writeOp represents a set of writes made by a transaction.
+// It is applied once to the transaction's own view of the database if it
+// comes from a DML statement, and again to the current tables at commit,
+// so it must not modify any state of its own.
+type writeOp func(w *writer) error
+
+// writer applies writes to copies of a set of tables,
+// recording which rows it has modified.
+type writer struct {
+	base   map[string]*table // must not be modified
+	tables map[string]*table // modified copies of tables in base
+
+	keys map[string]map[string][]interface{} // primary keys of modified rows, by table and keyString
+}
+
+func newWriter(base map[string]*table) *writer {
+	return &writer{
+		base:   base,
+		tables: make(map[string]*table),
+		keys:   make(map[string]map[string][]interface{}),
+	}
+}
+
+// readTable returns the named table for reading only.
+func (w *writer) readTable(tbl string) (*table, error) {
+	if t, ok := w.tables[tbl]; ok {
+		return t, nil
+	}
+	if t, ok := w.base[tbl]; ok {
+		return t, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no table named %s", tbl)
+}
+
+// table returns the named table, ready for modification.
+func (w *writer) table(tbl string) (*table, error) {
+	if t, ok := w.tables[tbl]; ok {
+		return t, nil
+	}
+	t, ok := w.base[tbl]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no table named %s", tbl)
+	}
+	t = t.clone()
+	w.tables[tbl] = t
+	return t, nil
+}
+
+// noteKey records that the row with the given primary key has been modified.
+func (w *writer) noteKey(tbl string, pk []interface{}) {
+	if w.keys[tbl] == nil {
+		w.keys[tbl] = make(map[string][]interface{})
+	}
+	w.keys[tbl][keyString(pk)] = pk
+}
+
+// checkConstraints reports an error if the modified tables violate
+// a unique index, or the relationship between interleaved tables.
+func (w *writer) checkConstraints() error {
+	for tbl, t := range w.tables {
+		for _, idx := range t.indexes {
+			if err := idx.checkUnique(tbl); err != nil {
+				return err
+			}
+		}
+
+		for _, pk := range w.keys[tbl] {
+			if _, found := t.rowForPK(pk); found {
+				// A row that was written must have a parent row.
+				if t.parent == "" {
+					continue
+				}
+				pt, err := w.readTable(t.parent)
+				if err != nil {
+					return err
+				}
+				if _, found := pt.rowForPK(pk[:pt.pkCols]); !found {
+					return status.Errorf(codes.NotFound, "parent row %v in table %s is missing; row %v cannot be written to table %s", pk[:pt.pkCols], t.parent, pk, tbl)
+				}
+				continue
+			}
+			// A row that was deleted must not have child rows
+			// in tables that do not have ON DELETE CASCADE.
+			for _, child := range childTables(w.base, tbl) {
+				ct, err := w.readTable(child)
+				if err != nil {
+					return err
+				}
+				if ct.onDelete == spansql.CascadeOnDelete {
+					continue
+				}
+				if start, end := ct.findRange(prefixRange(pk)); start < end {
+					return status.Errorf(codes.FailedPrecondition, "row %v in table %s cannot be deleted, since it has child rows in table %s", pk, tbl, child)
+				}
+			}
 		}
 	}
+	return nil
+}
+
+// writeValues executes a write option (Insert, Update, etc.),
+// converting each row of values and passing it to f.
+func (w *writer) writeValues(tbl string, cols []string, values []*structpb.ListValue, f func(tbl string, cols []string, vals []interface{}) error) error {
+	t, err := w.readTable(tbl)
+	if err != nil {
+		return err
+	}
+
+	colIndexes, err := t.colIndexes(cols)
+	if err != nil {
+		return err
+	}
 
 	for _, vs := range values {
 		if len(vs.Values) != len(colIndexes) {
 			return status.Errorf(codes.InvalidArgument, "row of %d values can't be written to %d columns", len(vs.Values), len(colIndexes))
 		}
 
-		r := make(row, len(t.cols))
+		vals := make([]interface{}, len(vs.Values))
 		for j, v := range vs.Values {
-			i := colIndexes[j]
+			x, err := valForType(v, t.cols[colIndexes[j]].Type)
+			if err != nil {
+				return err
+			}
+			vals[j] = x
+		}
+		// TODO: enforce NOT NULL?
+
+		if err := f(tbl, cols, vals); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareRow returns the named table, ready for modification,
+// along with a row of it holding the given values for the named columns.
+func (w *writer) prepareRow(tbl string, cols []string, vals []interface{}) (*table, row, []int, error) {
+	t, err := w.table(tbl)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	colIndexes, err := t.colIndexes(cols)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := t.checkPKCols(colIndexes); err != nil {
+		return nil, nil, nil, err
+	}
+	r := make(row, len(t.cols))
+	for j, i := range colIndexes {
+		r[i] = vals[j]
+	}
+	return t, r, colIndexes, nil
+}
+
+func (w *writer) insert(tbl string, cols []string, vals []interface{}) error {
+	t, r, _, err := w.prepareRow(tbl, cols, vals)
+	if err != nil {
+		return err
+	}
+	pk := r[:t.pkCols]
+	rowNum, found := t.rowForPK(pk)
+	if found {
+		return status.Errorf(codes.AlreadyExists, "row already in table")
+	}
+	t.insertRow(rowNum, r)
+	w.noteKey(tbl, pk)
+	return nil
+}
+
+func (w *writer) update(tbl string, cols []string, vals []interface{}) error {
+	t, r, colIndexes, err := w.prepareRow(tbl, cols, vals)
+	if err != nil {
+		return err
+	}
+	pk := r[:t.pkCols]
+	rowNum, found := t.rowForPK(pk)
+	if !found {
+		// TODO: is this the right way to return `NOT_FOUND`?
+		return status.Errorf(codes.NotFound, "row not in table")
+	}
+	t.updateRow(rowNum, colIndexes, r)
+	w.noteKey(tbl, pk)
+	return nil
+}
+
+func (w *writer) insertOrUpdate(tbl string, cols []string, vals []interface{}) error {
+	t, r, colIndexes, err := w.prepareRow(tbl, cols, vals)
+	if err != nil {
+		return err
+	}
+	pk := r[:t.pkCols]
+	rowNum, found := t.rowForPK(pk)
+	if !found {
+		// New row; do an insert.
+		t.insertRow(rowNum, r)
+	} else {
+		// Existing row; do an update.
+		t.updateRow(rowNum, colIndexes, r)
+	}
+	w.noteKey(tbl, pk)
+	return nil
+}
 
-			x, err := valForType(v, t.cols[i].Type)
+func (w *writer) replace(tbl string, cols []string, vals []interface{}) error {
+	t, r, _, err := w.prepareRow(tbl, cols, vals)
+	if err != nil {
+		return err
+	}
+	pk := r[:t.pkCols]
+	rowNum, found := t.rowForPK(pk)
+	if !found {
+		t.insertRow(rowNum, r)
+	} else {
+		// Columns not written are reset to NULL.
+		t.setRow(rowNum, r)
+	}
+	w.noteKey(tbl, pk)
+	return nil
+}
+
+// deleteKey deletes the row with the given primary key.
+// It is not an error if the row does not exist.
+func (w *writer) deleteKey(tbl string, pk []interface{}) error {
+	t, err := w.readTable(tbl)
+	if err != nil {
+		return err
+	}
+	if _, found := t.rowForPK(pk); !found {
+		return nil
+	}
+	return w.deleteRows(tbl, func(t *table) (int, int) {
+		rowNum, _ := t.rowForPK(pk)
+		return rowNum, rowNum + 1
+	})
+}
+
+// deleteRows deletes a range of rows from a table.
+// The range is computed by f, and reported as a half-open interval.
+func (w *writer) deleteRows(tbl string, f func(t *table) (int, int)) error {
+	t, err := w.table(tbl)
+	if err != nil {
+		return err
+	}
+	startRow, endRow := f(t)
+	var pks [][]interface{}
+	for _, r := range t.rows[startRow:endRow] {
+		pks = append(pks, r[:t.pkCols])
+		w.noteKey(tbl, r[:t.pkCols])
+	}
+	t.removeRows(startRow, endRow)
+
+	// Deleting a row deletes its child rows in tables with ON DELETE CASCADE.
+	for _, child := range childTables(w.base, tbl) {
+		ct, err := w.readTable(child)
+		if err != nil {
+			return err
+		}
+		if ct.onDelete != spansql.CascadeOnDelete {
+			continue
+		}
+		for _, pk := range pks {
+			kr := prefixRange(pk)
+			if start, end := ct.findRange(kr); start == end {
+				// Don't modify the table unnecessarily.
+				continue
+			}
+			if err := w.deleteRows(child, func(t *table) (int, int) { return t.findRange(kr) }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *database) Insert(tx *transaction, tbl string, cols []string, values []*structpb.ListValue) error {
+	return tx.buffer(func(w *writer) error {
+		return w.writeValues(tbl, cols, values, w.insert)
+	})
+}
+
+func (d *database) Update(tx *transaction, tbl string, cols []string, values []*structpb.ListValue) error {
+	return tx.buffer(func(w *writer) error {
+		t, err := w.readTable(tbl)
+		if err != nil {
+			return err
+		}
+		if t.pkCols == 0 {
+			return status.Errorf(codes.InvalidArgument, "cannot update table %s with no columns in primary key", tbl)
+		}
+		return w.writeValues(tbl, cols, values, w.update)
+	})
+}
+
+func (d *database) InsertOrUpdate(tx *transaction, tbl string, cols []string, values []*structpb.ListValue) error {
+	return tx.buffer(func(w *writer) error {
+		return w.writeValues(tbl, cols, values, w.insertOrUpdate)
+	})
+}
+
+func (d *database) Replace(tx *transaction, tbl string, cols []string, values []*structpb.ListValue) error {
+	return tx.buffer(func(w *writer) error {
+		return w.writeValues(tbl, cols, values, w.replace)
+	})
+}
+
+func (d *database) Delete(tx *transaction, tbl string, keys []*structpb.ListValue, keyRanges keyRangeList, all bool) error {
+	return tx.buffer(func(w *writer) error {
+		if all {
+			return w.deleteRows(tbl, func(t *table) (int, int) { return 0, len(t.rows) })
+		}
+
+		t, err := w.readTable(tbl)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			pk, err := t.primaryKey(key.Values)
 			if err != nil {
 				return err
 			}
+			// Not an error if the key does not exist.
+			if err := w.deleteKey(tbl, pk); err != nil {
+				return err
+			}
+		}
 
-			r[i] = x
+		for _, r := range keyRanges {
+			// Work on a copy, since this may be run more than once.
+			kr := *r
+			kr.startKey, err = t.primaryKeyPrefix(r.start.Values)
+			if err != nil {
+				return err
+			}
+			kr.endKey, err = t.primaryKeyPrefix(r.end.Values)
+			if err != nil {
+				return err
+			}
+			if err := w.deleteRows(tbl, func(t *table) (int, int) { return t.findRange(&kr) }); err != nil {
+				return err
+			}
 		}
-		// TODO: enforce NOT NULL?
 
-		if err := f(t, colIndexes, r); err != nil {
-			return err
+		return nil
+	})
+}
+
+// Execute runs a DML statement.
+// It returns the number of affected rows.
+func (d *database) Execute(tx *transaction, stmt spansql.DMLStmt, params queryParams) (int, error) {
+	if tx.partitioned {
+		// Each statement in a Partitioned DML transaction is applied independently,
+		// retrying if it conflicts with other transactions.
+		for {
+			ptx := d.NewTransaction()
+			n, err := d.Execute(ptx, stmt, params)
+			if err != nil {
+				ptx.Rollback()
+				return 0, err
+			}
+			if _, err := ptx.Commit(); status.Code(err) != codes.Aborted {
+				return n, err
+			}
+		}
+	}
+
+	switch stmt := stmt.(type) {
+	default:
+		return 0, status.Errorf(codes.Unimplemented, "unhandled DML statement type %T", stmt)
+	case spansql.Delete:
+		return d.execDelete(tx, stmt, params)
+	case spansql.Update:
+		return d.execUpdate(tx, stmt, params)
+	case spansql.Insert:
+		return d.execInsert(tx, stmt, params)
+	}
+}
+
+func (d *database) execDelete(tx *transaction, stmt spansql.Delete, params queryParams) (int, error) {
+	t, err := tx.table(stmt.Table)
+	if err != nil {
+		return 0, err
+	}
+	tx.noteScan(stmt.Table)
+
+	// Evaluate the WHERE clause for every row before deleting anything,
+	// so a failure partway through leaves the table untouched.
+	ec := evalContext{
+		d:      d,
+		tx:     tx,
+		table:  scopeTable(t.cols, t.rows, stmt.Table),
+		params: params,
+	}
+	var pks [][]interface{}
+	for _, r := range t.rows {
+		ec.row = r
+		b, err := ec.evalBoolExpr(stmt.Where)
+		if err != nil {
+			return 0, err
+		}
+		if b {
+			pks = append(pks, r[:t.pkCols])
 		}
 	}
 
-	return nil
-}
-
-func (d *database) Insert(tbl string, cols []string, values []*structpb.ListValue) error {
-	return d.writeValues(tbl, cols, values, func(t *table, colIndexes []int, r row) error {
-		pk := r[:t.pkCols]
-		rowNum, found := t.rowForPK(pk)
-		if found {
-			return status.Errorf(codes.AlreadyExists, "row already in table")
+	err = tx.write(func(w *writer) error {
+		for _, pk := range pks {
+			if err := w.deleteKey(stmt.Table, pk); err != nil {
+				return err
+			}
 		}
-		t.insertRow(rowNum, r)
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return len(pks), nil
 }
 
-func (d *database) Update(tbl string, cols []string, values []*structpb.ListValue) error {
-	return d.writeValues(tbl, cols, values, func(t *table, colIndexes []int, r row) error {
-		if t.pkCols == 0 {
-			return status.Errorf(codes.InvalidArgument, "cannot update table %s with no columns in primary key", tbl)
+func (d *database) execUpdate(tx *transaction, stmt spansql.Update, params queryParams) (int, error) {
+	t, err := tx.table(stmt.Table)
+	if err != nil {
+		return 0, err
+	}
+	tx.noteScan(stmt.Table)
+
+	// Each update is written as the primary key columns followed by the updated columns.
+	var cols []string
+	for i := 0; i < t.pkCols; i++ {
+		cols = append(cols, t.cols[i].Name)
+	}
+	var colIndexes []int
+	for _, ui := range stmt.Items {
+		i, ok := t.colIndex[ui.Column]
+		if !ok {
+			return 0, status.Errorf(codes.InvalidArgument, "column %s not in table", ui.Column)
 		}
-		pk := r[:t.pkCols]
-		rowNum, found := t.rowForPK(pk)
-		if !found {
-			// TODO: is this the right way to return `NOT_FOUND`?
-			return status.Errorf(codes.NotFound, "row not in table")
+		if i < t.pkCols {
+			return 0, status.Errorf(codes.InvalidArgument, "cannot update primary key column %s", ui.Column)
 		}
+		cols = append(cols, ui.Column)
+		colIndexes = append(colIndexes, i)
+	}
 
-		for _, i := range colIndexes {
-			t.rows[rowNum][i] = r[i]
+	// Compute all the new values before modifying anything,
+	// so a failure partway through leaves the table untouched.
+	var updates [][]interface{}
+	ec := evalContext{
+		d:      d,
+		tx:     tx,
+		table:  scopeTable(t.cols, t.rows, stmt.Table),
+		params: params,
+	}
+	for _, r := range t.rows {
+		ec.row = r
+		b, err := ec.evalBoolExpr(stmt.Where)
+		if err != nil {
+			return 0, err
 		}
-		return nil
-	})
-}
+		if !b {
+			continue
+		}
+		vals := append([]interface{}(nil), r[:t.pkCols]...)
+		for j, ui := range stmt.Items {
+			var x interface{} // DEFAULT is NULL
+			if ui.Value != nil {
+				x, err = ec.evalExpr(ui.Value)
+				if err != nil {
+					return 0, err
+				}
+			}
+			x, err = coerceValue(x, t.cols[colIndexes[j]].Type)
+			if err != nil {
+				return 0, err
+			}
+			vals = append(vals, x)
+		}
+		updates = append(updates, vals)
+	}
 
-func (d *database) InsertOrUpdate(tbl string, cols []string, values []*structpb.ListValue) error {
-	return d.writeValues(tbl, cols, values, func(t *table, colIndexes []int, r row) error {
-		pk := r[:t.pkCols]
-		rowNum, found := t.rowForPK(pk)
-		if !found {
-			// New row; do an insert.
-			t.insertRow(rowNum, r)
-		} else {
-			// Existing row; do an update.
-			for _, i := range colIndexes {
-				t.rows[rowNum][i] = r[i]
+	err = tx.write(func(w *writer) error {
+		for _, vals := range updates {
+			if err := w.update(stmt.Table, cols, vals); err != nil {
+				return err
 			}
 		}
 		return nil
 	})
-}
-
-// TODO: Replace
-
-func (d *database) Delete(table string, keys []*structpb.ListValue, keyRanges keyRangeList, all bool) error {
-	t, err := d.table(table)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return len(updates), nil
+}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	if all {
-		t.rows = nil
-		return nil
+func (d *database) execInsert(tx *transaction, stmt spansql.Insert, params queryParams) (int, error) {
+	t, err := tx.table(stmt.Table)
+	if err != nil {
+		return 0, err
 	}
 
-	for _, key := range keys {
-		pk, err := t.primaryKey(key.Values)
+	// Evaluate the input rows first.
+	var input [][]interface{}
+	switch in := stmt.Input.(type) {
+	default:
+		return 0, status.Errorf(codes.Unimplemented, "unhandled INSERT input type %T", in)
+	case spansql.Values:
+		ec := evalContext{
+			d:      d,
+			tx:     tx,
+			params: params,
+		}
+		for _, list := range in {
+			var vals []interface{}
+			for _, e := range list {
+				var x interface{} // DEFAULT is NULL
+				if e != nil {
+					x, err = ec.evalExpr(e)
+					if err != nil {
+						return 0, err
+					}
+				}
+				vals = append(vals, x)
+			}
+			input = append(input, vals)
+		}
+	case spansql.Query:
+		ri, err := d.Query(tx, in, params)
 		if err != nil {
-			return err
+			return 0, err
 		}
-		// Not an error if the key does not exist.
-		rowNum, found := t.rowForPK(pk)
-		if found {
-			copy(t.rows[rowNum:], t.rows[rowNum+1:])
-			t.rows = t.rows[:len(t.rows)-1]
+		if len(ri.Cols) != len(stmt.Columns) {
+			return 0, status.Errorf(codes.InvalidArgument, "INSERT has %d columns but SELECT returns %d", len(stmt.Columns), len(ri.Cols))
+		}
+		for {
+			vals, ok := ri.Next()
+			if !ok {
+				break
+			}
+			input = append(input, vals)
 		}
 	}
 
-	for _, r := range keyRanges {
-		r.startKey, err = t.primaryKeyPrefix(r.start.Values)
-		if err != nil {
-			return err
-		}
-		r.endKey, err = t.primaryKeyPrefix(r.end.Values)
-		if err != nil {
-			return err
+	colIndexes, err := t.colIndexes(stmt.Columns)
+	if err != nil {
+		return 0, err
+	}
+	if err := t.checkPKCols(colIndexes); err != nil {
+		return 0, err
+	}
+
+	var rows [][]interface{}
+	for _, vals := range input {
+		if len(vals) != len(colIndexes) {
+			return 0, status.Errorf(codes.InvalidArgument, "row of %d values can't be written to %d columns", len(vals), len(colIndexes))
 		}
-		startRow, endRow := t.findRange(r)
-		if n := endRow - startRow; n > 0 {
-			copy(t.rows[startRow:], t.rows[endRow:])
-			t.rows = t.rows[:len(t.rows)-n]
+		r := make(row, len(t.cols))
+		for j, x := range vals {
+			i := colIndexes[j]
+			x, err := coerceValue(x, t.cols[i].Type)
+			if err != nil {
+				return 0, err
+			}
+			vals[j] = x
+			r[i] = x
 		}
+		// The insert depends on the row not already existing.
+		tx.noteRead(stmt.Table, r[:t.pkCols])
+		rows = append(rows, vals)
 	}
 
-	return nil
+	err = tx.write(func(w *writer) error {
+		for _, vals := range rows {
+			if err := w.insert(stmt.Table, stmt.Columns, vals); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(rows), nil
 }
 
 // resultIter is returned by reads and queries.
@@ -380,15 +1299,12 @@ func (ri *resultIter) add(src row, colIndexes []int) {
 }
 
 // readTable executes a read option (Read, ReadAll).
-func (d *database) readTable(table string, cols []string, f func(*table, *resultIter, []int) error) (*resultIter, error) {
-	t, err := d.table(table)
+func (d *database) readTable(tx *transaction, table string, cols []string, f func(*table, *resultIter, []int) error) (*resultIter, error) {
+	t, err := tx.table(table)
 	if err != nil {
 		return nil, err
 	}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
 	colIndexes, err := t.colIndexes(cols)
 	if err != nil {
 		return nil, err
@@ -401,13 +1317,14 @@ func (d *database) readTable(table string, cols []string, f func(*table, *result
 	return ri, f(t, ri, colIndexes)
 }
 
-func (d *database) Read(tbl string, cols []string, keys []*structpb.ListValue, limit int64) (*resultIter, error) {
-	return d.readTable(tbl, cols, func(t *table, ri *resultIter, colIndexes []int) error {
+func (d *database) Read(tx *transaction, tbl string, cols []string, keys []*structpb.ListValue, limit int64) (*resultIter, error) {
+	return d.readTable(tx, tbl, cols, func(t *table, ri *resultIter, colIndexes []int) error {
 		for _, key := range keys {
 			pk, err := t.primaryKey(key.Values)
 			if err != nil {
 				return err
 			}
+			tx.noteRead(tbl, pk)
 			// Not an error if the key does not exist.
 			rowNum, found := t.rowForPK(pk)
 			if !found {
@@ -422,8 +1339,9 @@ func (d *database) Read(tbl string, cols []string, keys []*structpb.ListValue, l
 	})
 }
 
-func (d *database) ReadAll(tbl string, cols []string, limit int64) (*resultIter, error) {
-	return d.readTable(tbl, cols, func(t *table, ri *resultIter, colIndexes []int) error {
+func (d *database) ReadAll(tx *transaction, tbl string, cols []string, limit int64) (*resultIter, error) {
+	return d.readTable(tx, tbl, cols, func(t *table, ri *resultIter, colIndexes []int) error {
+		tx.noteScan(tbl)
 		for _, r := range t.rows {
 			ri.add(r, colIndexes)
 			if limit > 0 && len(ri.rows) >= int(limit) {
@@ -434,25 +1352,101 @@ func (d *database) ReadAll(tbl string, cols []string, limit int64) (*resultIter,
 	})
 }
 
+// ReadIndex reads rows through the named secondary index of a table.
+// The keys and key ranges are in terms of the index key, which is the
+// indexed columns followed by the primary key columns.
+// Rows are returned in index order.
+func (d *database) ReadIndex(tx *transaction, tbl, index string, cols []string, keys []*structpb.ListValue, keyRanges keyRangeList, all bool, limit int64) (*resultIter, error) {
+	return d.readTable(tx, tbl, cols, func(t *table, ri *resultIter, colIndexes []int) error {
+		idx, ok := t.indexes[index]
+		if !ok {
+			return status.Errorf(codes.NotFound, "no index named %s on table %s", index, tbl)
+		}
+		for _, i := range colIndexes {
+			if !idx.covers(i, t.pkCols) {
+				return status.Errorf(codes.InvalidArgument, "column %s is not stored in index %s; consider adding it in a STORING clause", t.cols[i].Name, index)
+			}
+		}
+		// Index reads are not tracked by primary key,
+		// so any change to the table may conflict with them.
+		tx.noteScan(tbl)
+
+		// Each entry is returned at most once, in index order,
+		// however many of the keys and key ranges it matches.
+		sel := make([]bool, len(idx.entries))
+		mark := func(kr *keyRange) {
+			start, end := idx.findRange(kr)
+			for i := start; i < end; i++ {
+				sel[i] = true
+			}
+		}
+		if all {
+			mark(&keyRange{startClosed: true, endClosed: true})
+		}
+		for _, key := range keys {
+			k, err := t.indexKeyPrefix(idx, key.Values)
+			if err != nil {
+				return err
+			}
+			mark(&keyRange{startKey: k, endKey: k, startClosed: true, endClosed: true})
+		}
+		for _, r := range keyRanges {
+			kr := *r
+			var err error
+			kr.startKey, err = t.indexKeyPrefix(idx, r.start.Values)
+			if err != nil {
+				return err
+			}
+			kr.endKey, err = t.indexKeyPrefix(idx, r.end.Values)
+			if err != nil {
+				return err
+			}
+			mark(&kr)
+		}
+
+		for i, e := range idx.entries {
+			if !sel[i] {
+				continue
+			}
+			rowNum, _ := t.rowForPK(e[len(idx.keyCols):])
+			ri.add(t.rows[rowNum], colIndexes)
+			if limit > 0 && len(ri.rows) >= int(limit) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
 type queryParams map[string]interface{}
 
-func (d *database) Query(q spansql.Query, params queryParams) (*resultIter, error) {
+func (d *database) Query(tx *transaction, q spansql.Query, params queryParams) (*resultIter, error) {
+	ec := evalContext{
+		d:      d,
+		tx:     tx,
+		params: params,
+	}
+	return ec.evalQuery(q)
+}
+
+// evalQuery evaluates a query, which may be a subquery of the query in ec.outer.
+func (ec evalContext) evalQuery(q spansql.Query) (*resultIter, error) {
 	// If there's an ORDER BY clause, prepare the list of auxiliary data we need.
 	// This is provided to evalSelect to evaluate with each row.
 	var aux []spansql.Expr
 	var desc []bool
-	if len(q.Order) > 0 {
-		if len(q.Select.From) == 0 {
-			return nil, fmt.Errorf("ORDER BY doesn't work without a table")
-		}
-
-		for _, o := range q.Order {
-			aux = append(aux, o.Expr)
-			desc = append(desc, o.Desc)
-		}
+	for _, o := range q.Order {
+		aux = append(aux, o.Expr)
+		desc = append(desc, o.Desc)
 	}
 
-	ri, err := d.evalSelect(q.Select, params, aux)
+	var ri *resultIter
+	var err error
+	if len(q.SetOps) > 0 {
+		ri, err = ec.evalSetOps(q, aux)
+	} else {
+		ri, err = ec.evalSelect(q.Select, aux)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -473,7 +1467,7 @@ func (d *database) Query(q spansql.Query, params queryParams) (*resultIter, erro
 		})
 	}
 	if q.Limit != nil {
-		lim, err := evalLimit(q.Limit, params)
+		lim, err := evalLimit(q.Limit, ec.params)
 		if err != nil {
 			return nil, err
 		}
@@ -484,17 +1478,40 @@ func (d *database) Query(q spansql.Query, params queryParams) (*resultIter, erro
 	return ri, nil
 }
 
-func (t *table) addColumn(cd spansql.ColumnDef) *status.Status {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// clone returns a copy of the table that may be modified without affecting the original.
+func (t *table) clone() *table {
+	t2 := &table{
+		cols:     append([]colInfo(nil), t.cols...),
+		colIndex: make(map[string]int, len(t.colIndex)),
+		pkCols:   t.pkCols,
+		parent:   t.parent,
+		onDelete: t.onDelete,
+		rows:     append([]row(nil), t.rows...),
+	}
+	for name, i := range t.colIndex {
+		t2.colIndex[name] = i
+	}
+	if len(t.indexes) > 0 {
+		t2.indexes = make(map[string]*index, len(t.indexes))
+		for name, idx := range t.indexes {
+			idx2 := *idx
+			idx2.entries = append([]row(nil), idx.entries...)
+			t2.indexes[name] = &idx2
+		}
+	}
+	return t2
+}
 
+func (t *table) addColumn(cd spansql.ColumnDef) *status.Status {
 	if len(t.rows) > 0 {
 		if cd.NotNull {
 			// TODO: what happens in this case?
 			return status.Newf(codes.Unimplemented, "can't add NOT NULL columns to non-empty tables yet")
 		}
-		for i := range t.rows {
-			t.rows[i] = append(t.rows[i], nil)
+		for i, r := range t.rows {
+			nr := make(row, len(r)+1)
+			copy(nr, r)
+			t.rows[i] = nr
 		}
 	}
 
@@ -511,6 +1528,236 @@ func (t *table) insertRow(rowNum int, r row) {
 	t.rows = append(t.rows, nil)
 	copy(t.rows[rowNum+1:], t.rows[rowNum:])
 	t.rows[rowNum] = r
+	t.indexRow(r)
+}
+
+// updateRow replaces the values of the given columns in a row.
+func (t *table) updateRow(rowNum int, colIndexes []int, r row) {
+	nr := append(row(nil), t.rows[rowNum]...)
+	for _, i := range colIndexes {
+		nr[i] = r[i]
+	}
+	t.setRow(rowNum, nr)
+}
+
+// setRow replaces a row with one that has the same primary key.
+func (t *table) setRow(rowNum int, r row) {
+	t.unindexRow(t.rows[rowNum])
+	t.rows[rowNum] = r
+	t.indexRow(r)
+}
+
+// removeRows removes a range of rows, reported as a half-open interval.
+func (t *table) removeRows(startRow, endRow int) {
+	n := endRow - startRow
+	if n <= 0 {
+		return
+	}
+	for _, r := range t.rows[startRow:endRow] {
+		t.unindexRow(r)
+	}
+	copy(t.rows[startRow:], t.rows[endRow:])
+	t.rows = t.rows[:len(t.rows)-n]
+}
+
+// addIndex adds a secondary index to the table, populating it from the existing rows.
+func (t *table) addIndex(ci spansql.CreateIndex) *status.Status {
+	idx := &index{
+		name:         ci.Name,
+		unique:       ci.Unique,
+		nullFiltered: ci.NullFiltered,
+	}
+	used := make(map[int]bool)
+	for _, kp := range ci.Columns {
+		i, ok := t.colIndex[kp.Column]
+		if !ok {
+			return status.Newf(codes.InvalidArgument, "index %s refers to unknown column %s", ci.Name, kp.Column)
+		}
+		if used[i] {
+			return status.Newf(codes.InvalidArgument, "index %s refers to column %s more than once", ci.Name, kp.Column)
+		}
+		if t.cols[i].Type.Array {
+			return status.Newf(codes.InvalidArgument, "index %s cannot have array column %s as a key", ci.Name, kp.Column)
+		}
+		used[i] = true
+		idx.keyCols = append(idx.keyCols, i)
+		idx.desc = append(idx.desc, kp.Desc)
+	}
+	for _, col := range ci.Storing {
+		i, ok := t.colIndex[col]
+		if !ok {
+			return status.Newf(codes.InvalidArgument, "index %s stores unknown column %s", ci.Name, col)
+		}
+		if used[i] || i < t.pkCols {
+			return status.Newf(codes.InvalidArgument, "index %s cannot store column %s, which is part of its key", ci.Name, col)
+		}
+		used[i] = true
+		idx.storing = append(idx.storing, i)
+	}
+
+	for _, r := range t.rows {
+		if e := idx.entry(r, t.pkCols); e != nil {
+			idx.entries = append(idx.entries, e)
+		}
+	}
+	sort.Slice(idx.entries, func(i, j int) bool {
+		return idx.cmp(idx.entries[i], idx.entries[j]) < 0
+	})
+	if err := idx.checkUnique(ci.Table); err != nil {
+		return status.New(codes.FailedPrecondition, status.Convert(err).Message())
+	}
+
+	if t.indexes == nil {
+		t.indexes = make(map[string]*index)
+	}
+	t.indexes[ci.Name] = idx
+	return nil
+}
+
+// indexRow adds the entries for a row to the table's indexes.
+func (t *table) indexRow(r row) {
+	for _, idx := range t.indexes {
+		if e := idx.entry(r, t.pkCols); e != nil {
+			idx.insert(e)
+		}
+	}
+}
+
+// unindexRow removes the entries for a row from the table's indexes.
+func (t *table) unindexRow(r row) {
+	for _, idx := range t.indexes {
+		if e := idx.entry(r, t.pkCols); e != nil {
+			idx.remove(e)
+		}
+	}
+}
+
+// entry returns the index entry for a row of a table with pkCols primary key columns.
+// It returns nil if the row is excluded from a NULL_FILTERED index.
+func (idx *index) entry(r row, pkCols int) row {
+	e := make(row, 0, len(idx.keyCols)+pkCols)
+	for _, i := range idx.keyCols {
+		if r[i] == nil && idx.nullFiltered {
+			return nil
+		}
+		e = append(e, r[i])
+	}
+	return append(e, r[:pkCols]...)
+}
+
+// cmp compares two index entries, or prefixes of them, in index order, returning -1/0/+1.
+// a is permitted to be shorter than b.
+func (idx *index) cmp(a, b []interface{}) int {
+	for i := 0; i < len(a); i++ {
+		cmp := compareVals(a[i], b[i])
+		if i < len(idx.desc) && idx.desc[i] {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// search returns the position of the first entry that is not before the given entry or prefix.
+func (idx *index) search(e []interface{}) int {
+	return sort.Search(len(idx.entries), func(i int) bool {
+		return idx.cmp(e, idx.entries[i]) <= 0
+	})
+}
+
+func (idx *index) insert(e row) {
+	i := idx.search(e)
+	idx.entries = append(idx.entries, nil)
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = e
+}
+
+func (idx *index) remove(e row) {
+	i := idx.search(e)
+	if i < len(idx.entries) && idx.cmp(e, idx.entries[i]) == 0 {
+		idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+	}
+}
+
+// checkUnique reports an error if the index is unique
+// and more than one of its entries has the same index key.
+// NULL values are equal to each other for this purpose.
+func (idx *index) checkUnique(tbl string) error {
+	if !idx.unique {
+		return nil
+	}
+	n := len(idx.keyCols)
+	for i := 1; i < len(idx.entries); i++ {
+		prev, e := idx.entries[i-1], idx.entries[i]
+		if idx.cmp(prev[:n], e) == 0 {
+			return status.Errorf(codes.AlreadyExists, "unique index violation on index %s at index key %v: it conflicts with row %v in table %s", idx.name, e[:n], prev[n:], tbl)
+		}
+	}
+	return nil
+}
+
+// findRange finds the entries included in the key range,
+// reporting it as a half-open interval.
+// r.startKey and r.endKey should be populated, and be in index order.
+func (idx *index) findRange(r *keyRange) (int, int) {
+	startRow := idx.search(r.startKey)
+	if !r.startClosed {
+		for startRow < len(idx.entries) && idx.cmp(r.startKey, idx.entries[startRow]) == 0 {
+			startRow++
+		}
+	}
+
+	endRow := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.cmp(r.endKey, idx.entries[i]) < 0
+	})
+	if !r.endClosed {
+		for endRow > startRow && idx.cmp(r.endKey, idx.entries[endRow-1]) == 0 {
+			endRow--
+		}
+	}
+
+	if endRow < startRow {
+		endRow = startRow
+	}
+	return startRow, endRow
+}
+
+// covers reports whether the index holds the value of the given table column,
+// in a table with pkCols primary key columns.
+func (idx *index) covers(i, pkCols int) bool {
+	if i < pkCols {
+		return true
+	}
+	for _, j := range idx.keyCols {
+		if i == j {
+			return true
+		}
+	}
+	for _, j := range idx.storing {
+		if i == j {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPKCols checks that the given column indexes include every primary key column,
+// as is required for writing a row.
+func (t *table) checkPKCols(colIndexes []int) error {
+	revIndex := make(map[int]int) // table index to col index
+	for j, i := range colIndexes {
+		revIndex[i] = j
+	}
+
+	for pki := 0; pki < t.pkCols; pki++ {
+		_, ok := revIndex[pki]
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "primary key column %s not included in write", t.cols[pki].Name)
+		}
+	}
+	return nil
 }
 
 // findRange finds the rows included in the key range,
@@ -581,6 +1828,41 @@ func (t *table) primaryKeyPrefix(values []*structpb.Value) ([]interface{}, error
 	return pk, nil
 }
 
+// indexKeyPrefix constructs the internal representation of a prefix of an index key.
+func (t *table) indexKeyPrefix(idx *index, values []*structpb.Value) ([]interface{}, error) {
+	if n := len(idx.keyCols) + t.pkCols; len(values) > n {
+		return nil, status.Errorf(codes.InvalidArgument, "index key length too long: got %d values, index %s has %d", len(values), idx.name, n)
+	}
+
+	var key []interface{}
+	for j, value := range values {
+		i := j - len(idx.keyCols) // a primary key column
+		if j < len(idx.keyCols) {
+			i = idx.keyCols[j]
+		}
+		v, err := valForType(value, t.cols[i].Type)
+		if err != nil {
+			return nil, err
+		}
+		key = append(key, v)
+	}
+	return key, nil
+}
+
+// indexRows returns the rows of the table that are in the named index, in index order.
+func (t *table) indexRows(index string) ([]row, error) {
+	idx, ok := t.indexes[index]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "table does not have a secondary index called %s", index)
+	}
+	rows := make([]row, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		rowNum, _ := t.rowForPK(e[len(idx.keyCols):])
+		rows = append(rows, t.rows[rowNum])
+	}
+	return rows, nil
+}
+
 // rowForPK returns the index of t.rows that holds the row for the given primary key, and true.
 // If the given primary key isn't found, it returns the row that should hold it, and false.
 func (t *table) rowForPK(pk []interface{}) (row int, found bool) {
@@ -609,6 +1891,11 @@ func rowCmp(a, b []interface{}) int {
 	return 0
 }
 
+// keyString returns a string form of a primary key, for use as a map key.
+func keyString(pk []interface{}) string {
+	return fmt.Sprintf("%#v", pk)
+}
+
 func valForType(v *structpb.Value, t spansql.Type) (interface{}, error) {
 	if _, ok := v.Kind.(*structpb.Value_NullValue); ok {
 		// TODO: enforce NOT NULL constraints?
@@ -657,6 +1944,16 @@ func valForType(v *structpb.Value, t spansql.Type) (interface{}, error) {
 		if ok {
 			return sv.StringValue, nil
 		}
+	case spansql.Bytes:
+		// The Spanner protocol encodes BYTES as a base64-encoded string.
+		sv, ok := v.Kind.(*structpb.Value_StringValue)
+		if ok {
+			b, err := base64.StdEncoding.DecodeString(sv.StringValue)
+			if err != nil {
+				return nil, fmt.Errorf("bad BYTES string %q: %v", sv.StringValue, err)
+			}
+			return b, nil
+		}
 	case spansql.Date:
 		// The Spanner protocol encodes DATE in RFC 3339 date format.
 		sv, ok := v.Kind.(*structpb.Value_StringValue)
@@ -668,6 +1965,16 @@ func valForType(v *structpb.Value, t spansql.Type) (interface{}, error) {
 			}
 			return s, nil
 		}
+	case spansql.Timestamp:
+		// The Spanner protocol encodes TIMESTAMP in RFC 3339 format, in UTC.
+		sv, ok := v.Kind.(*structpb.Value_StringValue)
+		if ok {
+			t, err := time.Parse(time.RFC3339Nano, sv.StringValue)
+			if err != nil {
+				return nil, fmt.Errorf("bad TIMESTAMP string %q: %v", sv.StringValue, err)
+			}
+			return t.UTC(), nil
+		}
 	}
 	return nil, fmt.Errorf("unsupported inserting value kind %T into column of type %s", v.Kind, t.SQL())
 }
@@ -681,6 +1988,16 @@ type keyRange struct {
 	startKey, endKey []interface{}
 }
 
+// prefixRange returns a key range that includes every primary key with the given prefix.
+func prefixRange(prefix []interface{}) *keyRange {
+	return &keyRange{
+		startKey:    prefix,
+		endKey:      prefix,
+		startClosed: true,
+		endClosed:   true,
+	}
+}
+
 func (r *keyRange) String() string {
 	var sb bytes.Buffer // TODO: Switch to strings.Builder when we drop support for Go 1.9.
 	if r.startClosed {
@@ -19,120 +19,617 @@ package spannertest
 // This file contains the part of the Spanner fake that evaluates expressions.
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"cloud.google.com/go/civil"
 	"cloud.google.com/go/spanner/spansql"
 )
 
 // evalContext represents the context for evaluating an expression.
 type evalContext struct {
+	// d and tx are used to evaluate subqueries.
+	d  *database
+	tx *transaction
+
 	table  *table // may be nil
 	row    row    // set if table is set, only during expr evaluation
 	params queryParams
+
+	// outer is the context of the enclosing query when evaluating a subquery.
+	// Column names that aren't found in table are resolved there.
+	outer *evalContext
+
+	// When evaluating expressions once per group of rows in a query with
+	// grouping or aggregation, aggregate is set and group holds the rows.
+	// row is then the first row of the group, if there is one.
+	aggregate bool
+	group     []row
 }
 
-func (d *database) evalSelect(sel spansql.Select, params queryParams, aux []spansql.Expr) (ri *resultIter, evalErr error) {
-	// TODO: weave this in below.
-	if len(sel.From) == 0 && sel.Where == nil {
-		// Simple expressions.
-		ri := &resultIter{}
-		ec := evalContext{
-			params: params,
+func (ec evalContext) evalSelect(sel spansql.Select, aux []spansql.Expr) (*resultIter, error) {
+	t, err := ec.evalFrom(sel.From)
+	if err != nil {
+		return nil, err
+	}
+	ec.table = t
+
+	ri := &resultIter{}
+
+	for _, e := range sel.List {
+		ci, err := ec.colInfo(e)
+		if err != nil {
+			return nil, err
 		}
-		var r row
-		for _, e := range sel.List {
-			ci, err := ec.colInfo(e)
+		// TODO: deal with ci.Name == ""?
+		ri.Cols = append(ri.Cols, ci)
+	}
+
+	// Find the rows we want.
+	var rows []row
+	for _, r := range t.rows {
+		if sel.Where != nil {
+			ec.row = r
+			b, err := ec.evalBoolExpr(sel.Where)
 			if err != nil {
 				return nil, err
 			}
-			// TODO: set column names?
-			ri.Cols = append(ri.Cols, ci)
+			if !b {
+				continue
+			}
+		}
+		rows = append(rows, r)
+	}
+
+	if len(sel.GroupBy) > 0 || sel.Having != nil || hasAggregate(sel.List) || hasAggregate(aux) {
+		if err := ec.evalGroups(ri, sel, rows, aux); err != nil {
+			return nil, err
+		}
+		return ri, nil
+	}
+
+	for _, r := range rows {
+		ec.row = r
+
+		// Evaluate SELECT expression list on the row.
+		out, err := ec.evalExprList(sel.List)
+		if err != nil {
+			return nil, err
+		}
+		a, err := ec.evalExprList(aux)
+		if err != nil {
+			return nil, err
+		}
+
+		ri.rows = append(ri.rows, resultRow{data: out, aux: a})
+	}
+
+	return ri, nil
+}
+
+// evalFrom evaluates the FROM clause of a SELECT, returning a table holding
+// the rows to select from. Multiple items in the clause are cross joined.
+// Without a FROM clause there is a single row with no columns.
+func (ec evalContext) evalFrom(from []spansql.SelectFrom) (*table, error) {
+	if len(from) == 0 {
+		return scopeTable(nil, []row{{}}, ""), nil
+	}
+	t, err := ec.evalSelectFrom(from[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, sf := range from[1:] {
+		t, err = ec.evalJoin(spansql.SelectFromJoin{Type: spansql.CrossJoin, RHS: sf}, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (ec evalContext) evalSelectFrom(sf spansql.SelectFrom) (*table, error) {
+	switch sf := sf.(type) {
+	default:
+		return nil, fmt.Errorf("selecting from %T not supported", sf)
+	case spansql.SelectFromTable:
+		t, err := ec.tx.table(sf.Table)
+		if err != nil {
+			return nil, err
+		}
+		ec.tx.noteScan(sf.Table)
 
-			x, err := ec.evalExpr(e)
+		// TODO: Support table sampling.
+
+		// A forced index determines the order in which rows are scanned,
+		// and excludes any rows that it does not hold.
+		rows := t.rows
+		if index, ok := sf.Hints["FORCE_INDEX"]; ok && index != "_BASE_TABLE" {
+			rows, err = t.indexRows(index)
 			if err != nil {
 				return nil, err
 			}
-			r = append(r, x)
 		}
-		ri.rows = []resultRow{{data: r}}
-		return ri, nil
+
+		name := sf.Table
+		if sf.Alias != "" {
+			name = sf.Alias
+		}
+		return scopeTable(t.cols, rows, name), nil
+	case spansql.SelectFromSubquery:
+		// A subquery in a FROM clause can't refer to the other items in the clause,
+		// so it is evaluated without a current row.
+		sub := evalContext{d: ec.d, tx: ec.tx, params: ec.params, outer: ec.outer}
+		ri, err := sub.evalQuery(sf.Query)
+		if err != nil {
+			return nil, err
+		}
+		var rows []row
+		for {
+			data, ok := ri.Next()
+			if !ok {
+				break
+			}
+			rows = append(rows, data)
+		}
+		return scopeTable(ri.Cols, rows, sf.Alias), nil
+	case spansql.SelectFromUnnest:
+		return ec.evalUnnest(sf)
+	case spansql.SelectFromJoin:
+		lhs, err := ec.evalSelectFrom(sf.LHS)
+		if err != nil {
+			return nil, err
+		}
+		return ec.evalJoin(sf, lhs)
+	}
+}
+
+// evalUnnest evaluates UNNEST in a FROM clause,
+// producing a table with a row for each element of the array.
+func (ec evalContext) evalUnnest(sfu spansql.SelectFromUnnest) (*table, error) {
+	ci, err := ec.colInfo(sfu.Expr)
+	if err != nil {
+		return nil, err
 	}
+	if !ci.Type.Array {
+		return nil, fmt.Errorf("UNNEST of non-array type %s", ci.Type.SQL())
+	}
+	et := ci.Type // element type
+	et.Array = false
+
+	x, err := ec.evalExpr(sfu.Expr)
+	if err != nil {
+		return nil, err
+	}
+	var rows []row
+	arr, _ := x.([]interface{}) // NULL produces no rows
+	for _, elem := range arr {
+		rows = append(rows, row{elem})
+	}
+	return scopeTable([]colInfo{{Name: sfu.Alias, Type: et}}, rows, ""), nil
+}
 
-	if len(sel.From) != 1 {
-		return nil, fmt.Errorf("selecting from more than one table not supported")
+// evalJoin joins a table produced by evalSelectFrom with sfj.RHS.
+// sfj.LHS is ignored.
+func (ec evalContext) evalJoin(sfj spansql.SelectFromJoin, lhs *table) (*table, error) {
+	// UNNEST on the RHS may refer to the columns of the LHS,
+	// so it is evaluated again for each LHS row, and needs no join condition.
+	// Evaluating it without a row determines the columns.
+	uc := ec
+	uc.table = lhs
+	sfu, lateral := sfj.RHS.(spansql.SelectFromUnnest)
+	if sfj.Type != spansql.CrossJoin && !lateral && sfj.On == nil && len(sfj.Using) == 0 {
+		return nil, fmt.Errorf("join requires ON or USING")
+	}
+	var rhs *table
+	var err error
+	if lateral {
+		if sfj.Type == spansql.RightJoin || sfj.Type == spansql.FullJoin {
+			return nil, fmt.Errorf("RIGHT or FULL JOIN with UNNEST not supported")
+		}
+		rhs, err = uc.evalUnnest(sfu)
+	} else {
+		rhs, err = ec.evalSelectFrom(sfj.RHS)
 	}
-	tableName := sel.From[0].Table
-	t, err := d.table(tableName)
 	if err != nil {
 		return nil, err
 	}
 
-	ri = &resultIter{}
+	// The joined rows are the LHS row followed by the RHS row.
+	// A column name on both sides is ambiguous unless it is qualified.
+	t := &table{
+		cols:     append(append([]colInfo(nil), lhs.cols...), rhs.cols...),
+		colIndex: make(map[string]int),
+	}
+	for name, i := range lhs.colIndex {
+		t.colIndex[name] = i
+	}
+	for name, i := range rhs.colIndex {
+		if _, ok := t.colIndex[name]; ok || i < 0 {
+			t.colIndex[name] = -1
+		} else {
+			t.colIndex[name] = len(lhs.cols) + i
+		}
+	}
+
+	// Each USING column is added to the end of the joined row,
+	// taking the value from whichever side has one,
+	// and that's what the unqualified column name refers to.
+	type usingCol struct{ l, r int }
+	var using []usingCol
+	for _, name := range sfj.Using {
+		li, lok := lhs.colIndex[name]
+		ri, rok := rhs.colIndex[name]
+		if !lok || !rok || li < 0 || ri < 0 {
+			return nil, fmt.Errorf("column %s in USING clause must appear once on each side of the join", name)
+		}
+		using = append(using, usingCol{li, ri})
+		t.colIndex[name] = len(t.cols)
+		t.cols = append(t.cols, lhs.cols[li])
+	}
 
-	// Handle COUNT(*) specially.
-	// TODO: Handle aggregation more generally.
-	if len(sel.List) == 1 && isCountStar(sel.List[0]) {
-		// Replace the `COUNT(*)` with `1`, then aggregate on the way out.
-		sel.List[0] = spansql.IntegerLiteral(1)
-		defer func() {
-			if evalErr != nil {
-				return
+	join := func(l, r row) row {
+		// A nil row is all NULLs, for the unmatched side of an outer join.
+		if l == nil {
+			l = make(row, len(lhs.cols))
+		}
+		if r == nil {
+			r = make(row, len(rhs.cols))
+		}
+		out := make(row, 0, len(t.cols))
+		out = append(out, l...)
+		out = append(out, r...)
+		for _, u := range using {
+			x := l[u.l]
+			if x == nil {
+				x = r[u.r]
 			}
-			count := int64(len(ri.rows))
-			ri.rows = []resultRow{
-				{data: []interface{}{count}},
+			out = append(out, x)
+		}
+		return out
+	}
+	jc := ec
+	jc.table = t
+	match := func(jr row) (bool, error) {
+		if sfj.On != nil {
+			jc.row = jr
+			return jc.evalBoolExpr(sfj.On)
+		}
+		for _, u := range using {
+			x, y := jr[u.l], jr[len(lhs.cols)+u.r]
+			if x == nil || y == nil || compareVals(x, y) != 0 {
+				return false, nil
 			}
-		}()
+		}
+		return true, nil
 	}
 
-	// TODO: Support table sampling.
+	rhsMatched := make([]bool, len(rhs.rows))
+	for _, l := range lhs.rows {
+		rrows := rhs.rows
+		if lateral {
+			uc.row = l
+			rt, err := uc.evalUnnest(sfu)
+			if err != nil {
+				return nil, err
+			}
+			rrows = rt.rows
+		}
+		matched := false
+		for j, r := range rrows {
+			jr := join(l, r)
+			ok, err := match(jr)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			matched = true
+			if !lateral {
+				rhsMatched[j] = true
+			}
+			t.rows = append(t.rows, jr)
+		}
+		if !matched && (sfj.Type == spansql.LeftJoin || sfj.Type == spansql.FullJoin) {
+			t.rows = append(t.rows, join(l, nil))
+		}
+	}
+	if sfj.Type == spansql.RightJoin || sfj.Type == spansql.FullJoin {
+		for j, r := range rhs.rows {
+			if !rhsMatched[j] {
+				t.rows = append(t.rows, join(nil, r))
+			}
+		}
+	}
+	return t, nil
+}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	ec := evalContext{
-		table:  t,
-		params: params,
+// scopeTable returns a table for evaluating expressions over the given rows.
+// Its columns may be referred to by name, or, if qualifier is not empty,
+// by name qualified by it, as in "Singers.FirstName".
+// The table must not be modified.
+func scopeTable(cols []colInfo, rows []row, qualifier string) *table {
+	t := &table{
+		cols:     cols,
+		colIndex: make(map[string]int),
+		rows:     rows,
 	}
+	for i, ci := range cols {
+		addColName(t.colIndex, ci.Name, i)
+		if qualifier != "" && ci.Name != "" {
+			addColName(t.colIndex, qualifier+"."+ci.Name, i)
+		}
+	}
+	return t
+}
 
-	for _, e := range sel.List {
-		ci, err := ec.colInfo(e)
+// addColName adds a column name to a colIndex map.
+// A name that is already present is ambiguous, which is recorded as -1.
+func addColName(colIndex map[string]int, name string, i int) {
+	if name == "" {
+		return
+	}
+	if _, ok := colIndex[name]; ok {
+		colIndex[name] = -1
+		return
+	}
+	colIndex[name] = i
+}
+
+// evalSetOps evaluates the SELECTs of a query with set operations,
+// and combines their results.
+// Any ORDER BY expressions (aux) are evaluated on the combined result,
+// so they may only refer to its columns.
+func (ec evalContext) evalSetOps(q spansql.Query, aux []spansql.Expr) (*resultIter, error) {
+	ri, err := ec.evalSelect(q.Select, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, so := range q.SetOps {
+		ri2, err := ec.evalSelect(so.Select, nil)
 		if err != nil {
 			return nil, err
 		}
-		// TODO: deal with ci.Name == ""?
-		ri.Cols = append(ri.Cols, ci)
+		if len(ri.Cols) != len(ri2.Cols) {
+			return nil, fmt.Errorf("queries in set operation have mismatched column count: %d vs %d", len(ri.Cols), len(ri2.Cols))
+		}
+		for i := range ri.Cols {
+			t1, t2 := ri.Cols[i].Type, ri2.Cols[i].Type
+			if t1.Array != t2.Array || t1.Base != t2.Base {
+				return nil, fmt.Errorf("column %d in set operation has incompatible types %s and %s", i+1, t1.SQL(), t2.SQL())
+			}
+		}
+		ri.rows = combineRows(so, ri.rows, ri2.rows)
 	}
-	for _, r := range t.rows {
-		ec.row = r
 
-		// See if we want this row.
-		if sel.Where != nil {
-			b, err := ec.evalBoolExpr(sel.Where)
+	if len(aux) > 0 {
+		var rows []row
+		for _, r := range ri.rows {
+			rows = append(rows, r.data)
+		}
+		oc := ec
+		oc.table = scopeTable(ri.Cols, rows, "")
+		for i := range ri.rows {
+			oc.row = ri.rows[i].data
+			a, err := oc.evalExprList(aux)
 			if err != nil {
 				return nil, err
 			}
+			ri.rows[i].aux = a
+		}
+	}
+	return ri, nil
+}
+
+// combineRows applies a set operation to two lists of result rows.
+func combineRows(so spansql.SetOp, lhs, rhs []resultRow) []resultRow {
+	if so.Op == spansql.Union {
+		out := append(append([]resultRow(nil), lhs...), rhs...)
+		if !so.All {
+			out = distinctRows(out)
+		}
+		return out
+	}
+
+	count := make(map[string]int) // keyString of RHS row data to number of occurrences
+	for _, r := range rhs {
+		count[keyString(r.data)]++
+	}
+	var out []resultRow
+	for _, r := range lhs {
+		k := keyString(r.data)
+		in := count[k] > 0
+		if in && so.All {
+			// Each RHS row may only match a single LHS row.
+			count[k]--
+		}
+		if in == (so.Op == spansql.Intersect) {
+			out = append(out, r)
+		}
+	}
+	if !so.All {
+		out = distinctRows(out)
+	}
+	return out
+}
+
+// distinctRows returns the rows with duplicates removed,
+// preserving the order in which they first appear.
+func distinctRows(rows []resultRow) []resultRow {
+	seen := make(map[string]bool)
+	var out []resultRow
+	for _, r := range rows {
+		k := keyString(r.data)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, r)
+	}
+	return out
+}
+
+// evalGroups evaluates the SELECT list, HAVING clause and auxiliary expressions
+// of a query with grouping or aggregation, adding the results to ri.
+// The rows have already been filtered by the WHERE clause.
+func (ec evalContext) evalGroups(ri *resultIter, sel spansql.Select, rows []row, aux []spansql.Expr) error {
+	exprs := append(append([]spansql.Expr(nil), sel.List...), aux...)
+	if sel.Having != nil {
+		exprs = append(exprs, sel.Having)
+	}
+	for _, e := range exprs {
+		if err := checkGrouped(e, sel.GroupBy); err != nil {
+			return err
+		}
+	}
+
+	// Partition the rows into groups, in the order each group is first seen.
+	// Without GROUP BY, all the rows form one group, even if there are none.
+	var groups [][]row
+	if len(sel.GroupBy) == 0 {
+		groups = [][]row{rows}
+	} else {
+		index := make(map[string]int) // keyString of GROUP BY values to groups index
+		for _, r := range rows {
+			ec.row = r
+			key, err := ec.evalExprList(sel.GroupBy)
+			if err != nil {
+				return err
+			}
+			k := keyString(key)
+			i, ok := index[k]
+			if !ok {
+				i = len(groups)
+				index[k] = i
+				groups = append(groups, nil)
+			}
+			groups[i] = append(groups[i], r)
+		}
+	}
+
+	ec.aggregate = true
+	for _, g := range groups {
+		ec.group, ec.row = g, nil
+		if len(g) > 0 {
+			ec.row = g[0]
+		}
+
+		if sel.Having != nil {
+			b, err := ec.evalBoolExpr(sel.Having)
+			if err != nil {
+				return err
+			}
 			if !b {
 				continue
 			}
 		}
 
-		// Evaluate SELECT expression list on the row.
 		out, err := ec.evalExprList(sel.List)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		a, err := ec.evalExprList(aux)
 		if err != nil {
-			return nil, err
+			return err
 		}
-
 		ri.rows = append(ri.rows, resultRow{data: out, aux: a})
 	}
+	return nil
+}
 
-	return ri, nil
+// checkGrouped checks that an expression evaluated once per group of rows
+// only refers to columns inside aggregate functions or GROUP BY expressions.
+func checkGrouped(e spansql.Expr, groupBy []spansql.Expr) error {
+	for _, g := range groupBy {
+		if e.SQL() == g.SQL() {
+			return nil
+		}
+	}
+	switch e := e.(type) {
+	case spansql.ID, spansql.PathExp:
+		return fmt.Errorf("column %s must be grouped or aggregated", e.SQL())
+	case spansql.Func:
+		if _, ok := aggregateFuncs[strings.ToUpper(e.Name)]; ok {
+			return nil
+		}
+	}
+	for _, sub := range subExprs(e) {
+		if err := checkGrouped(sub, groupBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasAggregate reports whether any of the expressions uses an aggregate function.
+func hasAggregate(list []spansql.Expr) bool {
+	for _, e := range list {
+		if f, ok := e.(spansql.Func); ok {
+			if _, ok := aggregateFuncs[strings.ToUpper(f.Name)]; ok {
+				return true
+			}
+		}
+		if hasAggregate(subExprs(e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// subExprs returns the direct subexpressions of an expression.
+func subExprs(e spansql.Expr) []spansql.Expr {
+	var list []spansql.Expr
+	add := func(es ...spansql.Expr) {
+		for _, e := range es {
+			if e != nil {
+				list = append(list, e)
+			}
+		}
+	}
+	switch e := e.(type) {
+	case spansql.Paren:
+		add(e.Expr)
+	case spansql.Func:
+		for i, arg := range e.Args {
+			// A date part name is not a column reference.
+			if _, ok := arg.(spansql.ID); ok && i == scalarFuncs[strings.ToUpper(e.Name)].PartArg-1 {
+				continue
+			}
+			add(arg)
+		}
+	case spansql.TypedExpr:
+		add(e.Expr)
+	case spansql.ExtractExpr:
+		add(e.Expr, e.TimeZone)
+	case spansql.IntervalExpr:
+		add(e.Expr)
+	case spansql.ArithOp:
+		add(e.LHS, e.RHS)
+	case spansql.Case:
+		add(e.Expr)
+		for _, w := range e.WhenClauses {
+			add(w.Cond, w.Result)
+		}
+		add(e.ElseResult)
+	case spansql.Array:
+		add(e...)
+	case spansql.LogicalOp:
+		add(e.LHS, e.RHS)
+	case spansql.ComparisonOp:
+		add(e.LHS, e.RHS, e.RHS2)
+	case spansql.IsOp:
+		add(e.LHS)
+	case spansql.InOp:
+		add(e.LHS)
+		add(e.RHS...)
+	}
+	// Subqueries are evaluated separately, so aren't included.
+	return list
 }
 
 func (ec evalContext) evalExprList(list []spansql.Expr) ([]interface{}, error) {
@@ -147,62 +644,96 @@ func (ec evalContext) evalExprList(list []spansql.Expr) ([]interface{}, error) {
 	return out, nil
 }
 
+// evalBoolExpr evaluates a boolean expression that filters rows,
+// such as a WHERE clause. A NULL result is false.
 func (ec evalContext) evalBoolExpr(be spansql.BoolExpr) (bool, error) {
+	x, err := ec.evalBoolValue(be)
+	if err != nil {
+		return false, err
+	}
+	b, _ := x.(bool)
+	return b, nil
+}
+
+// evalBoolValue evaluates a boolean expression with SQL's three-valued logic.
+// The result is true, false or nil (NULL).
+func (ec evalContext) evalBoolValue(be spansql.BoolExpr) (interface{}, error) {
 	switch be := be.(type) {
 	default:
-		return false, fmt.Errorf("unhandled BoolExpr %T", be)
+		return nil, fmt.Errorf("unhandled BoolExpr %T", be)
 	case spansql.BoolLiteral:
 		return bool(be), nil
-	case spansql.ID, spansql.Paren:
+	case spansql.ID, spansql.PathExp, spansql.Param, spansql.Paren, spansql.Func, spansql.ScalarSubquery, spansql.Case:
 		e, err := ec.evalExpr(be)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
-		if e == nil { // NULL is a false boolean.
-			return false, nil
+		if e == nil {
+			return nil, nil
 		}
 		b, ok := e.(bool)
 		if !ok {
-			return false, fmt.Errorf("got %T, want bool", e)
+			return nil, fmt.Errorf("got %T, want bool", e)
 		}
 		return b, nil
 	case spansql.LogicalOp:
-		var lhs, rhs bool
+		var lhs, rhs interface{}
 		var err error
 		if be.LHS != nil {
-			lhs, err = ec.evalBoolExpr(be.LHS)
+			lhs, err = ec.evalBoolValue(be.LHS)
 			if err != nil {
-				return false, err
+				return nil, err
 			}
 		}
-		rhs, err = ec.evalBoolExpr(be.RHS)
+		rhs, err = ec.evalBoolValue(be.RHS)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 		switch be.Op {
 		case spansql.And:
-			return lhs && rhs, nil
+			// FALSE AND NULL is FALSE; TRUE AND NULL is NULL.
+			if lhs == false || rhs == false {
+				return false, nil
+			}
+			if lhs == nil || rhs == nil {
+				return nil, nil
+			}
+			return true, nil
 		case spansql.Or:
-			return lhs || rhs, nil
+			// TRUE OR NULL is TRUE; FALSE OR NULL is NULL.
+			if lhs == true || rhs == true {
+				return true, nil
+			}
+			if lhs == nil || rhs == nil {
+				return nil, nil
+			}
+			return false, nil
 		case spansql.Not:
-			return !rhs, nil
+			if rhs == nil {
+				return nil, nil
+			}
+			return !rhs.(bool), nil
 		default:
-			return false, fmt.Errorf("unhandled LogicalOp %d", be.Op)
+			return nil, fmt.Errorf("unhandled LogicalOp %d", be.Op)
 		}
 	case spansql.ComparisonOp:
 		var lhs, rhs interface{}
 		var err error
 		lhs, err = ec.evalExpr(be.LHS)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 		rhs, err = ec.evalExpr(be.RHS)
 		if err != nil {
-			return false, err
+			return nil, err
+		}
+		// A comparison with NULL is NULL.
+		if lhs == nil || rhs == nil {
+			return nil, nil
 		}
 		switch be.Op {
 		default:
-			return false, fmt.Errorf("TODO: ComparisonOp %d", be.Op)
+			return nil, fmt.Errorf("TODO: ComparisonOp %d", be.Op)
 		case spansql.Lt:
 			return compareVals(lhs, rhs) < 0, nil
 		case spansql.Le:
@@ -219,12 +750,12 @@ func (ec evalContext) evalBoolExpr(be spansql.BoolExpr) (bool, error) {
 			left, ok := lhs.(string)
 			if !ok {
 				// TODO: byte works here too?
-				return false, fmt.Errorf("LHS of LIKE is %T, not string", lhs)
+				return nil, fmt.Errorf("LHS of LIKE is %T, not string", lhs)
 			}
 			right, ok := rhs.(string)
 			if !ok {
 				// TODO: byte works here too?
-				return false, fmt.Errorf("RHS of LIKE is %T, not string", rhs)
+				return nil, fmt.Errorf("RHS of LIKE is %T, not string", rhs)
 			}
 
 			match := evalLike(left, right)
@@ -235,7 +766,10 @@ func (ec evalContext) evalBoolExpr(be spansql.BoolExpr) (bool, error) {
 		case spansql.Between, spansql.NotBetween:
 			rhs2, err := ec.evalExpr(be.RHS2)
 			if err != nil {
-				return false, err
+				return nil, err
+			}
+			if rhs2 == nil {
+				return nil, nil
 			}
 			b := compareVals(rhs, lhs) <= 0 && compareVals(lhs, rhs2) <= 0
 			if be.Op == spansql.NotBetween {
@@ -246,18 +780,19 @@ func (ec evalContext) evalBoolExpr(be spansql.BoolExpr) (bool, error) {
 	case spansql.IsOp:
 		lhs, err := ec.evalExpr(be.LHS)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 		var b bool
 		switch rhs := be.RHS.(type) {
 		default:
-			return false, fmt.Errorf("unhandled IsOp %T", rhs)
+			return nil, fmt.Errorf("unhandled IsOp %T", rhs)
 		case spansql.BoolLiteral:
 			lhsBool, ok := lhs.(bool)
-			if !ok {
-				return false, fmt.Errorf("non-bool value %T on LHS for %s", lhs, be.SQL())
+			if !ok && lhs != nil {
+				return nil, fmt.Errorf("non-bool value %T on LHS for %s", lhs, be.SQL())
 			}
-			b = (lhsBool == bool(rhs))
+			// NULL IS TRUE and NULL IS FALSE are both FALSE.
+			b = ok && lhsBool == bool(rhs)
 		case spansql.NullLiteral:
 			b = (lhs == nil)
 		}
@@ -265,52 +800,809 @@ func (ec evalContext) evalBoolExpr(be spansql.BoolExpr) (bool, error) {
 			b = !b
 		}
 		return b, nil
+	case spansql.InOp:
+		return ec.evalInOp(be)
+	case spansql.ExistsOp:
+		ri, err := ec.evalSubquery(be.Query)
+		if err != nil {
+			return nil, err
+		}
+		_, ok := ri.Next()
+		return ok, nil
 	}
 }
 
-func (ec evalContext) evalExpr(e spansql.Expr) (interface{}, error) {
-	switch e := e.(type) {
-	default:
-		return nil, fmt.Errorf("TODO: evalExpr(%s %T)", e.SQL(), e)
-	case spansql.ID:
-		return ec.evalID(e)
-	case spansql.Param:
-		v, ok := ec.params[string(e)]
-		if !ok {
-			return 0, fmt.Errorf("unbound param %s", e.SQL())
+func (ec evalContext) evalInOp(io spansql.InOp) (interface{}, error) {
+	lhs, err := ec.evalExpr(io.LHS)
+	if err != nil {
+		return nil, err
+	}
+	var rhs []interface{}
+	if io.Subquery != nil {
+		ri, err := ec.evalSubquery(*io.Subquery)
+		if err != nil {
+			return nil, err
 		}
-		return v, nil
-	case spansql.IntegerLiteral:
-		return int64(e), nil
-	case spansql.FloatLiteral:
-		return float64(e), nil
-	case spansql.StringLiteral:
+		if len(ri.Cols) != 1 {
+			return nil, fmt.Errorf("subquery of IN must have exactly one column, not %d", len(ri.Cols))
+		}
+		for {
+			data, ok := ri.Next()
+			if !ok {
+				break
+			}
+			rhs = append(rhs, data[0])
+		}
+	} else if io.Unnest {
+		x, err := ec.evalExpr(io.RHS[0])
+		if err != nil {
+			return nil, err
+		}
+		arr, ok := x.([]interface{})
+		if x != nil && !ok {
+			return nil, fmt.Errorf("UNNEST of non-array value %T", x)
+		}
+		rhs = arr
+	} else {
+		rhs, err = ec.evalExprList(io.RHS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The result is NULL if the LHS is NULL, or if there's no match but there is a NULL on the RHS,
+	// whether or not the IN is negated.
+	if lhs == nil {
+		return nil, nil
+	}
+	found, null := false, false
+	for _, x := range rhs {
+		if x == nil {
+			null = true
+		} else if compareVals(lhs, x) == 0 {
+			found = true
+			break
+		}
+	}
+	if !found && null {
+		return nil, nil
+	}
+	return found != io.Neg, nil
+}
+
+// evalSubquery evaluates a subquery, which may refer to columns of the current row.
+func (ec evalContext) evalSubquery(q spansql.Query) (*resultIter, error) {
+	if ec.d == nil {
+		return nil, fmt.Errorf("subqueries not supported here")
+	}
+	outer := ec
+	sub := evalContext{
+		d:      ec.d,
+		tx:     ec.tx,
+		params: ec.params,
+		outer:  &outer,
+	}
+	return sub.evalQuery(q)
+}
+
+func (ec evalContext) evalExpr(e spansql.Expr) (interface{}, error) {
+	switch e := e.(type) {
+	default:
+		return nil, fmt.Errorf("TODO: evalExpr(%s %T)", e.SQL(), e)
+	case spansql.ID:
+		return ec.evalColumn(string(e))
+	case spansql.PathExp:
+		return ec.evalColumn(e.SQL())
+	case spansql.Param:
+		v, ok := ec.params[string(e)]
+		if !ok {
+			return 0, fmt.Errorf("unbound param %s", e.SQL())
+		}
+		return v, nil
+	case spansql.IntegerLiteral:
+		return int64(e), nil
+	case spansql.FloatLiteral:
+		return float64(e), nil
+	case spansql.StringLiteral:
 		return string(e), nil
 	case spansql.BytesLiteral:
 		return []byte(e), nil
+	case spansql.DateLiteral:
+		return civil.Date(e).String(), nil
+	case spansql.TimestampLiteral:
+		return time.Time(e), nil
 	case spansql.NullLiteral:
 		return nil, nil
 	case spansql.BoolLiteral:
 		return bool(e), nil
 	case spansql.Paren:
 		return ec.evalExpr(e.Expr)
+	case spansql.ArithOp:
+		return ec.evalArithOp(e)
+	case spansql.Case:
+		return ec.evalCase(e)
+	case spansql.Array:
+		t, err := ec.arrayType(e)
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]interface{}, 0, len(e)) // non-nil even if empty
+		for _, elem := range e {
+			x, err := ec.evalExpr(elem)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, x)
+		}
+		return promote(arr, t), nil
 	case spansql.LogicalOp:
-		return ec.evalBoolExpr(e)
+		return ec.evalBoolValue(e)
+	case spansql.ComparisonOp:
+		return ec.evalBoolValue(e)
 	case spansql.IsOp:
-		return ec.evalBoolExpr(e)
+		return ec.evalBoolValue(e)
+	case spansql.InOp:
+		return ec.evalBoolValue(e)
+	case spansql.ExistsOp:
+		return ec.evalBoolValue(e)
+	case spansql.ScalarSubquery:
+		ri, err := ec.evalSubquery(e.Query)
+		if err != nil {
+			return nil, err
+		}
+		if len(ri.Cols) != 1 {
+			return nil, fmt.Errorf("scalar subquery must have exactly one column, not %d", len(ri.Cols))
+		}
+		data, ok := ri.Next()
+		if !ok {
+			return nil, nil
+		}
+		if _, ok := ri.Next(); ok {
+			return nil, fmt.Errorf("scalar subquery returned more than one row")
+		}
+		return data[0], nil
+	case spansql.Func:
+		return ec.evalFunc(e)
 	}
 }
 
-func (ec evalContext) evalID(id spansql.ID) (interface{}, error) {
-	// TODO: look beyond column names.
-	if ec.table == nil {
-		return nil, fmt.Errorf("identifier %s when not SELECTing on a table is not supported", string(id))
+func (ec evalContext) evalFunc(f spansql.Func) (interface{}, error) {
+	name := strings.ToUpper(f.Name)
+	if fn, ok := aggregateFuncs[name]; ok {
+		return ec.evalAggregate(f, fn)
+	}
+	switch name {
+	case "CAST", "SAFE_CAST":
+		return ec.evalCast(f)
+	case "EXTRACT":
+		return ec.evalExtract(f)
+	case "IF", "IFNULL", "COALESCE", "NULLIF":
+		return ec.evalConditional(f)
+	}
+	fn, ok := scalarFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("function %s is not supported", f.Name)
+	}
+	if _, err := ec.scalarFuncType(f, fn); err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, len(f.Args))
+	for i, arg := range f.Args {
+		var x interface{}
+		var err error
+		if i == fn.PartArg-1 {
+			x, err = ec.evalPartArg(arg)
+		} else {
+			x, err = ec.evalExpr(arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if x == nil {
+			return nil, nil
+		}
+		args[i] = x
+	}
+	x, err := fn.Eval(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return x, nil
+}
+
+// scalarFuncType checks the arguments of a scalar function,
+// returning the type of its result.
+func (ec evalContext) scalarFuncType(f spansql.Func, fn scalarFunc) (spansql.Type, error) {
+	name := strings.ToUpper(f.Name)
+	if f.Distinct {
+		return spansql.Type{}, fmt.Errorf("DISTINCT not allowed in %s", name)
+	}
+	if fn.PartArg > len(f.Args) {
+		return spansql.Type{}, fmt.Errorf("%s: missing argument %d", name, fn.PartArg)
+	}
+	var types []spansql.Type
+	for i, arg := range f.Args {
+		if i == fn.PartArg-1 {
+			switch arg := arg.(type) {
+			case spansql.IntervalExpr:
+				ci, err := ec.colInfo(arg.Expr)
+				if err != nil {
+					return spansql.Type{}, err
+				}
+				if !sameType(ci.Type, int64Type) {
+					return spansql.Type{}, fmt.Errorf("%s: INTERVAL value has type %s, want INT64", name, ci.Type.SQL())
+				}
+			case spansql.ID:
+			default:
+				return spansql.Type{}, fmt.Errorf("%s: argument %d must be an INTERVAL or date part", name, i+1)
+			}
+			continue
+		}
+		if _, ok := arg.(spansql.NullLiteral); ok {
+			types = append(types, nullType)
+			continue
+		}
+		ci, err := ec.colInfo(arg)
+		if err != nil {
+			return spansql.Type{}, err
+		}
+		types = append(types, ci.Type)
+	}
+	t, err := fn.Type(types)
+	if err != nil {
+		return spansql.Type{}, fmt.Errorf("%s: %v", name, err)
+	}
+	return t, nil
+}
+
+// evalPartArg evaluates the argument of a scalar function at its PartArg position.
+func (ec evalContext) evalPartArg(e spansql.Expr) (interface{}, error) {
+	switch e := e.(type) {
+	case spansql.IntervalExpr:
+		x, err := ec.evalExpr(e.Expr)
+		if err != nil || x == nil {
+			return nil, err
+		}
+		return interval{n: x.(int64), part: e.DatePart}, nil
+	case spansql.ID:
+		return strings.ToUpper(string(e)), nil
+	}
+	return nil, fmt.Errorf("expression %s is not an INTERVAL or date part", e.SQL())
+}
+
+// castArg returns the argument of CAST or SAFE_CAST,
+// checking that the conversion is supported.
+func (ec evalContext) castArg(f spansql.Func) (spansql.TypedExpr, error) {
+	if len(f.Args) != 1 {
+		return spansql.TypedExpr{}, fmt.Errorf("%s takes exactly one argument", f.Name)
+	}
+	te, ok := f.Args[0].(spansql.TypedExpr)
+	if !ok {
+		return spansql.TypedExpr{}, fmt.Errorf("argument of %s must be of the form <expr> AS <type>", f.Name)
+	}
+	ci, err := ec.colInfo(te.Expr)
+	if err != nil {
+		return spansql.TypedExpr{}, err
 	}
-	i, ok := ec.table.colIndex[string(id)]
+	if !canCast(ci.Type, te.Type) {
+		return spansql.TypedExpr{}, fmt.Errorf("can't cast %s to %s", ci.Type.SQL(), te.Type.SQL())
+	}
+	return te, nil
+}
+
+// evalCast evaluates CAST or SAFE_CAST.
+// SAFE_CAST produces NULL for a value that can't be converted, instead of an error.
+func (ec evalContext) evalCast(f spansql.Func) (interface{}, error) {
+	te, err := ec.castArg(f)
+	if err != nil {
+		return nil, err
+	}
+	x, err := ec.evalExpr(te.Expr)
+	if err != nil {
+		return nil, err
+	}
+	x, err = castValue(x, te.Type)
+	if err != nil {
+		if strings.ToUpper(f.Name) == "SAFE_CAST" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return x, nil
+}
+
+// extractArg returns the argument of EXTRACT, checking its types.
+func (ec evalContext) extractArg(f spansql.Func) (spansql.ExtractExpr, error) {
+	if len(f.Args) != 1 {
+		return spansql.ExtractExpr{}, fmt.Errorf("EXTRACT takes exactly one argument")
+	}
+	ee, ok := f.Args[0].(spansql.ExtractExpr)
 	if !ok {
-		return nil, fmt.Errorf("couldn't resolve identifier %s", string(id))
+		return spansql.ExtractExpr{}, fmt.Errorf("argument of EXTRACT must be of the form <part> FROM <expr>")
+	}
+	ci, err := ec.colInfo(ee.Expr)
+	if err != nil {
+		return spansql.ExtractExpr{}, err
+	}
+	switch {
+	case sameType(ci.Type, timestampType):
+	case sameType(ci.Type, dateType) && ee.TimeZone == nil:
+	default:
+		return spansql.ExtractExpr{}, fmt.Errorf("can't EXTRACT from %s", ci.Type.SQL())
+	}
+	if ee.TimeZone != nil {
+		ci, err := ec.colInfo(ee.TimeZone)
+		if err != nil {
+			return spansql.ExtractExpr{}, err
+		}
+		if !sameType(ci.Type, stringType) {
+			return spansql.ExtractExpr{}, fmt.Errorf("time zone has type %s, want STRING", ci.Type.SQL())
+		}
+	}
+	return ee, nil
+}
+
+func (ec evalContext) evalExtract(f spansql.Func) (interface{}, error) {
+	ee, err := ec.extractArg(f)
+	if err != nil {
+		return nil, err
+	}
+	x, err := ec.evalExpr(ee.Expr)
+	if err != nil || x == nil {
+		return nil, err
+	}
+	if s, ok := x.(string); ok {
+		t, err := parseDate(s)
+		if err != nil {
+			return nil, err
+		}
+		x, err = extractPart(ee.Part, t, true)
+		if err != nil {
+			return nil, fmt.Errorf("EXTRACT: %v", err)
+		}
+		return x, nil
+	}
+
+	tz := defaultTimeZone
+	if ee.TimeZone != nil {
+		z, err := ec.evalExpr(ee.TimeZone)
+		if err != nil || z == nil {
+			return nil, err
+		}
+		tz = z.(string)
+	}
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	x, err = extractPart(ee.Part, x.(time.Time).In(loc), false)
+	if err != nil {
+		return nil, fmt.Errorf("EXTRACT: %v", err)
+	}
+	return x, nil
+}
+
+// conditionalType checks the arguments of a conditional function (IF, IFNULL, COALESCE, NULLIF),
+// returning the type of its result.
+func (ec evalContext) conditionalType(f spansql.Func) (spansql.Type, error) {
+	name := strings.ToUpper(f.Name)
+	want := 2
+	results := f.Args
+	switch name {
+	case "IF":
+		want = 3
+		results = f.Args[1:]
+	case "COALESCE":
+		want = len(f.Args)
+		if want == 0 {
+			return spansql.Type{}, fmt.Errorf("COALESCE requires at least one argument")
+		}
+	}
+	if len(f.Args) != want {
+		return spansql.Type{}, fmt.Errorf("%s takes %d arguments, not %d", name, want, len(f.Args))
+	}
+	if _, null := f.Args[0].(spansql.NullLiteral); name == "IF" && !null {
+		ci, err := ec.colInfo(f.Args[0])
+		if err != nil {
+			return spansql.Type{}, err
+		}
+		if !sameType(ci.Type, boolType) {
+			return spansql.Type{}, fmt.Errorf("IF condition has type %s, want BOOL", ci.Type.SQL())
+		}
+	}
+	t, err := ec.commonExprType(results)
+	if err != nil {
+		return spansql.Type{}, fmt.Errorf("%s: %v", name, err)
+	}
+	return t, nil
+}
+
+// evalConditional evaluates a conditional function.
+// Only the arguments needed to determine the result are evaluated.
+func (ec evalContext) evalConditional(f spansql.Func) (interface{}, error) {
+	t, err := ec.conditionalType(f)
+	if err != nil {
+		return nil, err
+	}
+	var x interface{}
+	switch strings.ToUpper(f.Name) {
+	case "IF":
+		cond, err := ec.evalExpr(f.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		if b, _ := cond.(bool); b {
+			x, err = ec.evalExpr(f.Args[1])
+		} else {
+			x, err = ec.evalExpr(f.Args[2])
+		}
+		if err != nil {
+			return nil, err
+		}
+	case "IFNULL", "COALESCE":
+		for _, arg := range f.Args {
+			x, err = ec.evalExpr(arg)
+			if err != nil {
+				return nil, err
+			}
+			if x != nil {
+				break
+			}
+		}
+	case "NULLIF":
+		list, err := ec.evalExprList(f.Args)
+		if err != nil {
+			return nil, err
+		}
+		x = list[0]
+		if x != nil && list[1] != nil && compareVals(x, list[1]) == 0 {
+			x = nil
+		}
+	}
+	return promote(x, t), nil
+}
+
+// caseType checks the parts of a CASE expression, returning the type of its result.
+func (ec evalContext) caseType(c spansql.Case) (spansql.Type, error) {
+	var results []spansql.Expr
+	if c.Expr != nil {
+		// Each WHEN value must be comparable with the CASE value.
+		conds := []spansql.Expr{c.Expr}
+		for _, w := range c.WhenClauses {
+			conds = append(conds, w.Cond)
+		}
+		if _, err := ec.commonExprType(conds); err != nil {
+			return spansql.Type{}, fmt.Errorf("CASE: %v", err)
+		}
+	}
+	for _, w := range c.WhenClauses {
+		if _, null := w.Cond.(spansql.NullLiteral); c.Expr == nil && !null {
+			ci, err := ec.colInfo(w.Cond)
+			if err != nil {
+				return spansql.Type{}, err
+			}
+			if !sameType(ci.Type, boolType) {
+				return spansql.Type{}, fmt.Errorf("CASE: WHEN condition has type %s, want BOOL", ci.Type.SQL())
+			}
+		}
+		results = append(results, w.Result)
+	}
+	if c.ElseResult != nil {
+		results = append(results, c.ElseResult)
+	}
+	t, err := ec.commonExprType(results)
+	if err != nil {
+		return spansql.Type{}, fmt.Errorf("CASE: %v", err)
+	}
+	return t, nil
+}
+
+func (ec evalContext) evalCase(c spansql.Case) (interface{}, error) {
+	t, err := ec.caseType(c)
+	if err != nil {
+		return nil, err
+	}
+	var x interface{}
+	if c.Expr != nil {
+		x, err = ec.evalExpr(c.Expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result := c.ElseResult
+	for _, w := range c.WhenClauses {
+		y, err := ec.evalExpr(w.Cond)
+		if err != nil {
+			return nil, err
+		}
+		var match bool
+		if c.Expr != nil {
+			// NULL doesn't match anything.
+			match = x != nil && y != nil && compareVals(x, y) == 0
+		} else {
+			match, _ = y.(bool)
+		}
+		if match {
+			result = w.Result
+			break
+		}
+	}
+	if result == nil {
+		return nil, nil
+	}
+	x, err = ec.evalExpr(result)
+	if err != nil {
+		return nil, err
+	}
+	return promote(x, t), nil
+}
+
+// arrayType returns the type of an array literal.
+func (ec evalContext) arrayType(arr spansql.Array) (spansql.Type, error) {
+	t, err := ec.commonExprType(arr)
+	if err != nil {
+		return spansql.Type{}, fmt.Errorf("array literal: %v", err)
+	}
+	if t.Array {
+		return spansql.Type{}, fmt.Errorf("arrays of arrays are not supported")
+	}
+	t.Array = true
+	return t, nil
+}
+
+// commonExprType returns the type that the values of all the expressions
+// may be converted to. NULL literals are compatible with any type.
+func (ec evalContext) commonExprType(list []spansql.Expr) (spansql.Type, error) {
+	var types []spansql.Type
+	for _, e := range list {
+		if _, ok := e.(spansql.NullLiteral); ok {
+			continue
+		}
+		ci, err := ec.colInfo(e)
+		if err != nil {
+			return spansql.Type{}, err
+		}
+		types = append(types, ci.Type)
+	}
+	if len(types) == 0 {
+		// This matches the type of a lone NULL; see colInfo.
+		return int64Type, nil
+	}
+	return commonType(types)
+}
+
+// arithType returns the type of the result of an arithmetic operation.
+func (ec evalContext) arithType(e spansql.ArithOp) (spansql.Type, error) {
+	rci, err := ec.colInfo(e.RHS)
+	if err != nil {
+		return spansql.Type{}, err
+	}
+	rt := rci.Type
+	switch e.Op {
+	case spansql.Neg, spansql.Plus:
+		if isNumeric(rt) {
+			return spansql.Type{Base: rt.Base}, nil
+		}
+	case spansql.BitNot:
+		if sameType(rt, int64Type) {
+			return int64Type, nil
+		}
+	default:
+		lci, err := ec.colInfo(e.LHS)
+		if err != nil {
+			return spansql.Type{}, err
+		}
+		lt := lci.Type
+		switch e.Op {
+		case spansql.Concat:
+			if sameType(lt, rt) && (lt.Array || lt.Base == spansql.String || lt.Base == spansql.Bytes) {
+				return spansql.Type{Array: lt.Array, Base: lt.Base}, nil
+			}
+		case spansql.Add, spansql.Sub, spansql.Mul:
+			if isNumeric(lt) && isNumeric(rt) {
+				return commonType([]spansql.Type{lt, rt})
+			}
+		case spansql.Div:
+			if isNumeric(lt) && isNumeric(rt) {
+				return float64Type, nil
+			}
+		default: // bitwise operators
+			if sameType(lt, int64Type) && sameType(rt, int64Type) {
+				return int64Type, nil
+			}
+		}
+		return spansql.Type{}, fmt.Errorf("operands of [%s] have unsupported types %s and %s", e.SQL(), lt.SQL(), rt.SQL())
+	}
+	return spansql.Type{}, fmt.Errorf("operand of [%s] has unsupported type %s", e.SQL(), rt.SQL())
+}
+
+func (ec evalContext) evalArithOp(e spansql.ArithOp) (interface{}, error) {
+	if _, err := ec.arithType(e); err != nil {
+		return nil, err
+	}
+	var lhs interface{}
+	if e.LHS != nil {
+		var err error
+		lhs, err = ec.evalExpr(e.LHS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rhs, err := ec.evalExpr(e.RHS)
+	if err != nil {
+		return nil, err
+	}
+	if rhs == nil || (e.LHS != nil && lhs == nil) {
+		return nil, nil
+	}
+	x, err := arith(e.Op, lhs, rhs)
+	if err != nil {
+		return nil, fmt.Errorf("evaluating [%s]: %v", e.SQL(), err)
+	}
+	return x, nil
+}
+
+// arith applies an arithmetic operator to non-NULL operands whose types
+// have been checked. lhs is ignored for unary operators.
+func arith(op spansql.ArithOperator, lhs, rhs interface{}) (interface{}, error) {
+	errOverflow := fmt.Errorf("int64 overflow")
+	switch op {
+	case spansql.Neg:
+		if y, ok := rhs.(int64); ok {
+			if y == math.MinInt64 {
+				return nil, errOverflow
+			}
+			return -y, nil
+		}
+		return -rhs.(float64), nil
+	case spansql.Plus:
+		return rhs, nil
+	case spansql.BitNot:
+		return ^rhs.(int64), nil
+	case spansql.Concat:
+		switch x := lhs.(type) {
+		case string:
+			return x + rhs.(string), nil
+		case []byte:
+			return append(append([]byte(nil), x...), rhs.([]byte)...), nil
+		default:
+			return append(append([]interface{}{}, x.([]interface{})...), rhs.([]interface{})...), nil
+		}
+	case spansql.Div:
+		// Division always produces FLOAT64.
+		y := asFloat(rhs)
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return asFloat(lhs) / y, nil
+	}
+
+	x, xok := lhs.(int64)
+	y, yok := rhs.(int64)
+	if !xok || !yok {
+		// At least one operand is FLOAT64.
+		switch op {
+		case spansql.Add:
+			return asFloat(lhs) + asFloat(rhs), nil
+		case spansql.Sub:
+			return asFloat(lhs) - asFloat(rhs), nil
+		case spansql.Mul:
+			return asFloat(lhs) * asFloat(rhs), nil
+		}
+		return nil, fmt.Errorf("unsupported operands %T and %T", lhs, rhs)
+	}
+	switch op {
+	case spansql.Add:
+		if (y > 0 && x > math.MaxInt64-y) || (y < 0 && x < math.MinInt64-y) {
+			return nil, errOverflow
+		}
+		return x + y, nil
+	case spansql.Sub:
+		if (y < 0 && x > math.MaxInt64+y) || (y > 0 && x < math.MinInt64+y) {
+			return nil, errOverflow
+		}
+		return x - y, nil
+	case spansql.Mul:
+		if x != 0 && y != 0 {
+			z := x * y
+			if z/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+				return nil, errOverflow
+			}
+		}
+		return x * y, nil
+	case spansql.BitShl, spansql.BitShr:
+		if y < 0 {
+			return nil, fmt.Errorf("negative shift %d", y)
+		}
+		if y >= 64 {
+			return int64(0), nil
+		}
+		// Shifts are logical, not arithmetic.
+		if op == spansql.BitShl {
+			return int64(uint64(x) << uint(y)), nil
+		}
+		return int64(uint64(x) >> uint(y)), nil
+	case spansql.BitAnd:
+		return x & y, nil
+	case spansql.BitXor:
+		return x ^ y, nil
+	case spansql.BitOr:
+		return x | y, nil
+	}
+	return nil, fmt.Errorf("unhandled ArithOp %d", op)
+}
+
+func (ec evalContext) evalAggregate(f spansql.Func, fn aggregateFunc) (interface{}, error) {
+	if !ec.aggregate {
+		return nil, fmt.Errorf("aggregate function %s not allowed here", f.Name)
+	}
+	if len(f.Args) != 1 {
+		return nil, fmt.Errorf("aggregate function %s takes exactly one argument", f.Name)
+	}
+
+	var values []interface{}
+	if f.Args[0] == spansql.Star {
+		if !fn.AcceptStar {
+			return nil, fmt.Errorf("%s(*) not supported", f.Name)
+		}
+		// Every row counts, so give each a non-NULL value.
+		for range ec.group {
+			values = append(values, true)
+		}
+	} else {
+		// Evaluate the argument on each row of the group.
+		// Aggregate functions may not be nested.
+		rec := ec
+		rec.aggregate, rec.group = false, nil
+		for _, r := range ec.group {
+			rec.row = r
+			x, err := rec.evalExpr(f.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, x)
+		}
+	}
+	if f.Distinct {
+		values = distinctValues(values)
+	}
+	return fn.Eval(values)
+}
+
+func (ec evalContext) evalColumn(name string) (interface{}, error) {
+	cc, i, err := ec.resolveColumn(name)
+	if err != nil {
+		return nil, err
+	}
+	if cc.row == nil {
+		// There's no current row in the context of the column, which happens
+		// when deducing the type of a subquery that refers to the enclosing query.
+		return nil, nil
+	}
+	return cc.row.copyDataElem(i), nil
+}
+
+// resolveColumn finds the context that a column name refers to,
+// and the column's index in that context's table.
+// Names not found in the current context are looked up in the enclosing query.
+func (ec evalContext) resolveColumn(name string) (evalContext, int, error) {
+	// TODO: look beyond column names.
+	if ec.table != nil {
+		if i, ok := ec.table.colIndex[name]; ok {
+			if i < 0 {
+				return evalContext{}, 0, fmt.Errorf("column name %s is ambiguous", name)
+			}
+			return ec, i, nil
+		}
+	}
+	if ec.outer != nil {
+		return ec.outer.resolveColumn(name)
 	}
-	return ec.row.copyDataElem(i), nil
+	if ec.table == nil {
+		return evalContext{}, 0, fmt.Errorf("identifier %s when not SELECTing on a table is not supported", name)
+	}
+	return evalContext{}, 0, fmt.Errorf("couldn't resolve identifier %s", name)
 }
 
 func evalLimit(lim spansql.Limit, params queryParams) (int64, error) {
@@ -353,7 +1645,21 @@ func compareVals(x, y interface{}) int {
 		return 1
 	}
 
-	// TODO: coerce between comparable types (e.g. int64/float64).
+	// Coerce between comparable types.
+	switch xv := x.(type) {
+	case int64:
+		if _, ok := y.(float64); ok {
+			x = float64(xv)
+		}
+	case float64:
+		if yv, ok := y.(int64); ok {
+			y = float64(yv)
+		}
+	case string:
+		if _, ok := y.(time.Time); ok {
+			return -compareVals(y, x)
+		}
+	}
 
 	switch x := x.(type) {
 	default:
@@ -393,30 +1699,168 @@ func compareVals(x, y interface{}) int {
 	case string:
 		// This handles DATE too.
 		return strings.Compare(x, y.(string))
+	case []byte:
+		return bytes.Compare(x, y.([]byte))
+	case time.Time:
+		yt, ok := y.(time.Time)
+		if !ok {
+			// A string is interpreted as a TIMESTAMP literal.
+			s := y.(string)
+			loc, err := loadLocation(defaultTimeZone)
+			if err == nil {
+				yt, err = parseTimestamp(s, loc)
+			}
+			if err != nil {
+				panic(fmt.Sprintf("bad TIMESTAMP string %q: %v", s, err))
+			}
+		}
+		if x.Before(yt) {
+			return -1
+		} else if x.After(yt) {
+			return 1
+		}
+		return 0
 	}
 }
 
+// coerceValue converts the result of evaluating an expression to the
+// internal representation of the given column type, applying the implicit
+// conversions that happen when a DML statement writes a value to a column.
+func coerceValue(x interface{}, t spansql.Type) (interface{}, error) {
+	if x == nil {
+		return nil, nil
+	}
+
+	if t.Array {
+		arr, ok := x.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("can't assign value of type %T to column of type %s", x, t.SQL())
+		}
+		et := t // element type
+		et.Array = false
+
+		out := make([]interface{}, 0, len(arr))
+		for _, elem := range arr {
+			v, err := coerceValue(elem, et)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+
+	switch t.Base {
+	case spansql.Bool:
+		if b, ok := x.(bool); ok {
+			return b, nil
+		}
+	case spansql.Int64:
+		switch x := x.(type) {
+		case int64:
+			return x, nil
+		case string:
+			// This happens for parameters supplied without a type.
+			n, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad int64 string %q: %v", x, err)
+			}
+			return n, nil
+		}
+	case spansql.Float64:
+		switch x := x.(type) {
+		case float64:
+			return x, nil
+		case int64:
+			return float64(x), nil
+		}
+	case spansql.String:
+		if s, ok := x.(string); ok {
+			return s, nil
+		}
+	case spansql.Bytes:
+		if b, ok := x.([]byte); ok {
+			return b, nil
+		}
+	case spansql.Date:
+		if s, ok := x.(string); ok {
+			if _, err := time.Parse("2006-01-02", s); err != nil {
+				return nil, fmt.Errorf("bad DATE string %q: %v", s, err)
+			}
+			return s, nil
+		}
+	case spansql.Timestamp:
+		switch x := x.(type) {
+		case time.Time:
+			return x, nil
+		case string:
+			// This happens for parameters supplied without a type, and for literals.
+			loc, err := loadLocation(defaultTimeZone)
+			if err != nil {
+				return nil, err
+			}
+			return parseTimestamp(x, loc)
+		}
+	}
+	return nil, fmt.Errorf("can't assign value of type %T to column of type %s", x, t.SQL())
+}
+
 func (ec evalContext) colInfo(e spansql.Expr) (colInfo, error) {
 	// TODO: more types
 	switch e := e.(type) {
 	case spansql.IntegerLiteral:
 		return colInfo{Type: spansql.Type{Base: spansql.Int64}}, nil
+	case spansql.FloatLiteral:
+		return colInfo{Type: spansql.Type{Base: spansql.Float64}}, nil
 	case spansql.StringLiteral:
 		return colInfo{Type: spansql.Type{Base: spansql.String}}, nil
 	case spansql.BytesLiteral:
 		return colInfo{Type: spansql.Type{Base: spansql.Bytes}}, nil
-	case spansql.LogicalOp, spansql.ComparisonOp, spansql.IsOp:
+	case spansql.DateLiteral:
+		return colInfo{Type: spansql.Type{Base: spansql.Date}}, nil
+	case spansql.TimestampLiteral:
+		return colInfo{Type: spansql.Type{Base: spansql.Timestamp}}, nil
+	case spansql.BoolLiteral, spansql.LogicalOp, spansql.ComparisonOp, spansql.IsOp, spansql.InOp, spansql.ExistsOp:
 		return colInfo{Type: spansql.Type{Base: spansql.Bool}}, nil
-	case spansql.ID:
+	case spansql.ID, spansql.PathExp:
 		// TODO: support more than only naming a table column.
-		name := string(e)
-		if ec.table != nil {
-			if i, ok := ec.table.colIndex[name]; ok {
-				return ec.table.cols[i], nil
-			}
+		cc, i, err := ec.resolveColumn(e.SQL())
+		if err != nil {
+			return colInfo{}, err
+		}
+		return cc.table.cols[i], nil
+	case spansql.ScalarSubquery:
+		ri, err := ec.evalSubquery(e.Query)
+		if err != nil {
+			return colInfo{}, err
 		}
+		if len(ri.Cols) != 1 {
+			return colInfo{}, fmt.Errorf("scalar subquery must have exactly one column, not %d", len(ri.Cols))
+		}
+		return colInfo{Type: ri.Cols[0].Type}, nil
+	case spansql.Param:
+		v, ok := ec.params[string(e)]
+		if !ok {
+			return colInfo{}, fmt.Errorf("unbound param %s", e.SQL())
+		}
+		t, err := valueType(v)
+		if err != nil {
+			return colInfo{}, fmt.Errorf("param %s: %v", e.SQL(), err)
+		}
+		return colInfo{Type: t}, nil
 	case spansql.Paren:
 		return ec.colInfo(e.Expr)
+	case spansql.ArithOp:
+		t, err := ec.arithType(e)
+		return colInfo{Type: t}, err
+	case spansql.Case:
+		t, err := ec.caseType(e)
+		return colInfo{Type: t}, err
+	case spansql.Array:
+		t, err := ec.arrayType(e)
+		return colInfo{Type: t}, err
+	case spansql.Func:
+		return ec.funcColInfo(e)
 	case spansql.NullLiteral:
 		// There isn't necessarily something sensible here.
 		// Empirically, though, the real Spanner returns Int64.
@@ -425,6 +1869,93 @@ func (ec evalContext) colInfo(e spansql.Expr) (colInfo, error) {
 	return colInfo{}, fmt.Errorf("can't deduce column type from expression [%s]", e.SQL())
 }
 
+func (ec evalContext) funcColInfo(f spansql.Func) (colInfo, error) {
+	name := strings.ToUpper(f.Name)
+	if fn, ok := aggregateFuncs[name]; ok {
+		if len(f.Args) != 1 {
+			return colInfo{}, fmt.Errorf("aggregate function %s takes exactly one argument", f.Name)
+		}
+		var t spansql.Type
+		if f.Args[0] != spansql.Star {
+			ci, err := ec.colInfo(f.Args[0])
+			if err != nil {
+				return colInfo{}, err
+			}
+			t = ci.Type
+		}
+		t, err := fn.Type(t)
+		if err != nil {
+			return colInfo{}, err
+		}
+		return colInfo{Type: t}, nil
+	}
+	switch name {
+	case "CAST", "SAFE_CAST":
+		te, err := ec.castArg(f)
+		if err != nil {
+			return colInfo{}, err
+		}
+		t := te.Type
+		t.Len = 0
+		return colInfo{Type: t}, nil
+	case "EXTRACT":
+		ee, err := ec.extractArg(f)
+		if err != nil {
+			return colInfo{}, err
+		}
+		if ee.Part == "DATE" {
+			return colInfo{Type: dateType}, nil
+		}
+		return colInfo{Type: int64Type}, nil
+	case "IF", "IFNULL", "COALESCE", "NULLIF":
+		t, err := ec.conditionalType(f)
+		return colInfo{Type: t}, err
+	}
+	fn, ok := scalarFuncs[name]
+	if !ok {
+		return colInfo{}, fmt.Errorf("function %s is not supported", f.Name)
+	}
+	t, err := ec.scalarFuncType(f, fn)
+	return colInfo{Type: t}, err
+}
+
+// valueType returns the type of a value in its internal representation.
+// It is used to deduce the types of query parameters.
+func valueType(x interface{}) (spansql.Type, error) {
+	switch x := x.(type) {
+	case nil:
+		// This matches the type of a NULL literal; see colInfo.
+		return int64Type, nil
+	case bool:
+		return boolType, nil
+	case int64:
+		return int64Type, nil
+	case float64:
+		return float64Type, nil
+	case string:
+		return stringType, nil
+	case []byte:
+		return bytesType, nil
+	case time.Time:
+		return timestampType, nil
+	case []interface{}:
+		// The element type is determined by the first non-NULL element.
+		t := int64Type
+		for _, elem := range x {
+			if elem != nil {
+				var err error
+				if t, err = valueType(elem); err != nil {
+					return spansql.Type{}, err
+				}
+				break
+			}
+		}
+		t.Array = true
+		return t, nil
+	}
+	return spansql.Type{}, fmt.Errorf("unhandled value type %T", x)
+}
+
 func evalLike(str, pat string) bool {
 	/*
 		% matches any number of chars.
@@ -442,14 +1973,3 @@ func evalLike(str, pat string) bool {
 	}
 	return match
 }
-
-func isCountStar(e spansql.Expr) bool {
-	f, ok := e.(spansql.Func)
-	if !ok {
-		return false
-	}
-	if f.Name != "COUNT" || len(f.Args) != 1 {
-		return false
-	}
-	return f.Args[0] == spansql.Star
-}
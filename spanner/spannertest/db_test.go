@@ -19,8 +19,10 @@ package spannertest
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	structpb "github.com/golang/protobuf/ptypes/struct"
 
@@ -83,40 +85,48 @@ func TestTableData(t *testing.T) {
 	}
 
 	// Insert a subset of columns.
-	err := db.Insert("Staff", []string{"ID", "Name", "Tenure", "Height"}, []*structpb.ListValue{
-		// int64 arrives as a decimal string.
-		listV(stringV("1"), stringV("Jack"), stringV("10"), floatV(1.85)),
-		listV(stringV("2"), stringV("Daniel"), stringV("11"), floatV(1.83)),
+	err := applyInTx(&db, func(tx *transaction) error {
+		return db.Insert(tx, "Staff", []string{"ID", "Name", "Tenure", "Height"}, []*structpb.ListValue{
+			// int64 arrives as a decimal string.
+			listV(stringV("1"), stringV("Jack"), stringV("10"), floatV(1.85)),
+			listV(stringV("2"), stringV("Daniel"), stringV("11"), floatV(1.83)),
+		})
 	})
 	if err != nil {
 		t.Fatalf("Inserting data: %v", err)
 	}
 	// Insert a different set of columns.
-	err = db.Insert("Staff", []string{"Name", "ID", "Cool", "Tenure", "Height"}, []*structpb.ListValue{
-		listV(stringV("Sam"), stringV("3"), boolV(false), stringV("9"), floatV(1.75)),
-		listV(stringV("Teal'c"), stringV("4"), boolV(true), stringV("8"), floatV(1.91)),
-		listV(stringV("George"), stringV("5"), nullV(), stringV("6"), floatV(1.73)),
-		listV(stringV("Harry"), stringV("6"), boolV(true), nullV(), nullV()),
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Insert(tx, "Staff", []string{"Name", "ID", "Cool", "Tenure", "Height"}, []*structpb.ListValue{
+			listV(stringV("Sam"), stringV("3"), boolV(false), stringV("9"), floatV(1.75)),
+			listV(stringV("Teal'c"), stringV("4"), boolV(true), stringV("8"), floatV(1.91)),
+			listV(stringV("George"), stringV("5"), nullV(), stringV("6"), floatV(1.73)),
+			listV(stringV("Harry"), stringV("6"), boolV(true), nullV(), nullV()),
+		})
 	})
 	if err != nil {
 		t.Fatalf("Inserting more data: %v", err)
 	}
 	// Delete that last one.
-	err = db.Delete("Staff", []*structpb.ListValue{listV(stringV("Harry"), stringV("6"))}, nil, false)
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Delete(tx, "Staff", []*structpb.ListValue{listV(stringV("Harry"), stringV("6"))}, nil, false)
+	})
 	if err != nil {
 		t.Fatalf("Deleting a row: %v", err)
 	}
 	// Turns out this guy isn't cool after all.
-	err = db.Update("Staff", []string{"Name", "ID", "Cool"}, []*structpb.ListValue{
-		// Missing columns should be left alone.
-		listV(stringV("Daniel"), stringV("2"), boolV(false)),
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Update(tx, "Staff", []string{"Name", "ID", "Cool"}, []*structpb.ListValue{
+			// Missing columns should be left alone.
+			listV(stringV("Daniel"), stringV("2"), boolV(false)),
+		})
 	})
 	if err != nil {
 		t.Fatalf("Updating a row: %v", err)
 	}
 
 	// Read some specific keys.
-	ri, err := db.Read("Staff", []string{"Name", "Tenure"}, []*structpb.ListValue{
+	ri, err := db.Read(snapshotTx(t, &db), "Staff", []string{"Name", "Tenure"}, []*structpb.ListValue{
 		listV(stringV("George"), stringV("5")),
 		listV(stringV("Harry"), stringV("6")), // should be silently ignored.
 		listV(stringV("Sam"), stringV("3")),
@@ -134,7 +144,7 @@ func TestTableData(t *testing.T) {
 	}
 
 	// Read a subset of all rows, with a limit.
-	ri, err = db.ReadAll("Staff", []string{"Tenure", "Name", "Height"}, 4)
+	ri, err = db.ReadAll(snapshotTx(t, &db), "Staff", []string{"Tenure", "Name", "Height"}, 4)
 	if err != nil {
 		t.Fatalf("ReadAll: %v", err)
 	}
@@ -169,10 +179,12 @@ func TestTableData(t *testing.T) {
 	if st.Code() != codes.OK {
 		t.Fatalf("Adding column: %v", st.Err())
 	}
-	err = db.Update("Staff", []string{"Name", "ID", "FirstSeen"}, []*structpb.ListValue{
-		listV(stringV("Jack"), stringV("1"), stringV("1994-10-28")),
-		listV(stringV("Daniel"), stringV("2"), stringV("1994-10-28")),
-		listV(stringV("George"), stringV("5"), stringV("1997-07-27")),
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Update(tx, "Staff", []string{"Name", "ID", "FirstSeen"}, []*structpb.ListValue{
+			listV(stringV("Jack"), stringV("1"), stringV("1994-10-28")),
+			listV(stringV("Daniel"), stringV("2"), stringV("1994-10-28")),
+			listV(stringV("George"), stringV("5"), stringV("1997-07-27")),
+		})
 	})
 	if err != nil {
 		t.Fatalf("Updating rows: %v", err)
@@ -180,23 +192,51 @@ func TestTableData(t *testing.T) {
 
 	// Add some more data, then delete it with a KeyRange.
 	// The queries below ensure that this was all deleted.
-	err = db.Insert("Staff", []string{"Name", "ID"}, []*structpb.ListValue{
-		listV(stringV("01"), stringV("1")),
-		listV(stringV("03"), stringV("3")),
-		listV(stringV("06"), stringV("6")),
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Insert(tx, "Staff", []string{"Name", "ID"}, []*structpb.ListValue{
+			listV(stringV("01"), stringV("1")),
+			listV(stringV("03"), stringV("3")),
+			listV(stringV("06"), stringV("6")),
+		})
 	})
 	if err != nil {
 		t.Fatalf("Inserting data: %v", err)
 	}
-	err = db.Delete("Staff", nil, keyRangeList{{
-		start:       listV(stringV("01"), stringV("1")),
-		startClosed: true,
-		end:         listV(stringV("9")),
-	}}, false)
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Delete(tx, "Staff", nil, keyRangeList{{
+			start:       listV(stringV("01"), stringV("1")),
+			startClosed: true,
+			end:         listV(stringV("9")),
+		}}, false)
+	})
 	if err != nil {
 		t.Fatalf("Deleting key range: %v", err)
 	}
 
+	// Add a second table for joins and subqueries.
+	st = db.ApplyDDL(spansql.CreateTable{
+		Name: "Missions",
+		Columns: []spansql.ColumnDef{
+			{Name: "Name", Type: spansql.Type{Base: spansql.String}},
+			{Name: "Mission", Type: spansql.Type{Base: spansql.String}},
+		},
+		PrimaryKey: []spansql.KeyPart{{Column: "Name"}, {Column: "Mission"}},
+	})
+	if st.Code() != codes.OK {
+		t.Fatalf("Creating table: %v", st.Err())
+	}
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Insert(tx, "Missions", []string{"Name", "Mission"}, []*structpb.ListValue{
+			listV(stringV("Jack"), stringV("Abydos")),
+			listV(stringV("Daniel"), stringV("Abydos")),
+			listV(stringV("Daniel"), stringV("Chulak")),
+			listV(stringV("Vala"), stringV("Ori")),
+		})
+	})
+	if err != nil {
+		t.Fatalf("Inserting data: %v", err)
+	}
+
 	// Do some complex queries.
 	tests := []struct {
 		q      string
@@ -239,8 +279,8 @@ func TestTableData(t *testing.T) {
 			`SELECT Name, Cool IS NOT NULL FROM Staff WHERE Tenure > 8 ORDER BY NOT Cool, Name`,
 			nil,
 			[][]interface{}{
+				{"Jack", false},  // Jack has NULL Cool, so NOT Cool is NULL, which sorts first
 				{"Daniel", true}, // Daniel has Cool==true
-				{"Jack", false},  // Jack has NULL Cool
 				{"Sam", true},    // Sam has Cool==false
 			},
 		},
@@ -283,6 +323,267 @@ func TestTableData(t *testing.T) {
 				{"George"},
 			},
 		},
+		{
+			`SELECT Name, TIMESTAMP '2020-03-04 05:06:07 UTC' FROM Staff WHERE FirstSeen >= DATE '1996-01-01'`,
+			nil,
+			[][]interface{}{
+				{"George", time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)},
+			},
+		},
+		{
+			`SELECT Cool, COUNT(*), SUM(Tenure), MAX(Name) FROM Staff GROUP BY Cool ORDER BY Cool`,
+			nil,
+			[][]interface{}{
+				{nil, int64(2), int64(16), "Jack"},
+				{false, int64(2), int64(20), "Sam"},
+				{true, int64(1), int64(8), "Teal'c"},
+			},
+		},
+		{
+			`SELECT COUNT(DISTINCT Cool), COUNT(Cool), LOGICAL_OR(Cool), LOGICAL_AND(Cool), MIN(Height) FROM Staff`,
+			nil,
+			[][]interface{}{
+				{int64(2), int64(3), true, false, 1.73},
+			},
+		},
+		{
+			`SELECT AVG(Tenure) FROM Staff WHERE Cool = FALSE`,
+			nil,
+			[][]interface{}{
+				{float64(10)},
+			},
+		},
+		{
+			`SELECT FirstSeen, ARRAY_AGG(Name) FROM Staff WHERE FirstSeen IS NOT NULL GROUP BY FirstSeen HAVING COUNT(*) > 1`,
+			nil,
+			[][]interface{}{
+				{"1994-10-28", []interface{}{"Daniel", "Jack"}},
+			},
+		},
+		{
+			// Aggregating no rows still produces a row.
+			`SELECT COUNT(*), SUM(Tenure), ARRAY_AGG(Name) FROM Staff WHERE Name = "Nobody"`,
+			nil,
+			[][]interface{}{
+				{int64(0), nil, nil},
+			},
+		},
+		{
+			`SELECT s.Name, m.Mission FROM Staff AS s JOIN Missions m ON s.Name = m.Name ORDER BY s.Name, m.Mission`,
+			nil,
+			[][]interface{}{
+				{"Daniel", "Abydos"},
+				{"Daniel", "Chulak"},
+				{"Jack", "Abydos"},
+			},
+		},
+		{
+			`SELECT Name, Mission FROM Staff LEFT JOIN Missions USING (Name) WHERE Tenure >= 9 ORDER BY Name, Mission`,
+			nil,
+			[][]interface{}{
+				{"Daniel", "Abydos"},
+				{"Daniel", "Chulak"},
+				{"Jack", "Abydos"},
+				{"Sam", nil},
+			},
+		},
+		{
+			`SELECT COUNT(*), COUNT(Staff.Name), COUNT(Missions.Name) FROM Staff FULL JOIN Missions ON Staff.Name = Missions.Name`,
+			nil,
+			[][]interface{}{
+				{int64(7), int64(6), int64(4)},
+			},
+		},
+		{
+			`SELECT COUNT(*) FROM Staff, Missions`,
+			nil,
+			[][]interface{}{
+				{int64(20)},
+			},
+		},
+		{
+			`SELECT Name FROM Staff WHERE Name IN (SELECT Name FROM Missions) ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"Daniel"},
+				{"Jack"},
+			},
+		},
+		{
+			`SELECT Name FROM Staff WHERE ID NOT IN (1, 2, 3) ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"George"},
+				{"Teal'c"},
+			},
+		},
+		{
+			// Correlated subqueries.
+			`SELECT Name FROM Staff WHERE NOT EXISTS (SELECT 1 FROM Missions WHERE Missions.Name = Staff.Name) ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"George"},
+				{"Sam"},
+				{"Teal'c"},
+			},
+		},
+		{
+			`SELECT Name, (SELECT COUNT(*) FROM Missions WHERE Missions.Name = Staff.Name) FROM Staff WHERE Tenure > 9 ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"Daniel", int64(2)},
+				{"Jack", int64(1)},
+			},
+		},
+		{
+			`SELECT n.Name FROM (SELECT Name FROM Missions WHERE Mission = "Abydos") AS n JOIN Staff USING (Name) ORDER BY n.Name`,
+			nil,
+			[][]interface{}{
+				{"Daniel"},
+				{"Jack"},
+			},
+		},
+		{
+			`SELECT Name FROM Staff WHERE Cool UNION ALL SELECT Name FROM Missions ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"Daniel"},
+				{"Daniel"},
+				{"Jack"},
+				{"Teal'c"},
+				{"Vala"},
+			},
+		},
+		{
+			`SELECT Name FROM Staff INTERSECT DISTINCT SELECT Name FROM Missions ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"Daniel"},
+				{"Jack"},
+			},
+		},
+		{
+			`SELECT Name FROM Missions EXCEPT ALL SELECT Name FROM Staff ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"Daniel"},
+				{"Vala"},
+			},
+		},
+		{
+			`SELECT Tenure * 2 + ID, Height / 2, -Tenure, Tenure << 1 | 1, "a" || Name FROM Staff WHERE Name = "Jack"`,
+			nil,
+			[][]interface{}{{int64(21), 0.925, int64(-10), int64(21), "aJack"}},
+		},
+		{
+			`SELECT CONCAT(Name, "!"), LOWER(Name), UPPER(SUBSTR(Name, 2, 3)), LENGTH(Name), STRPOS(Name, "c") FROM Staff ` +
+				`WHERE STARTS_WITH(Name, "T") OR REGEXP_CONTAINS(Name, "^J.c") ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"Jack!", "jack", "ACK", int64(4), int64(3)},
+				{"Teal'c!", "teal'c", "EAL", int64(6), int64(6)},
+			},
+		},
+		{
+			`SELECT ABS(ID - Tenure), MOD(Tenure, 4), ROUND(Height, 1), FLOOR(Height), GREATEST(ID, 2), LEAST(ID, Height) FROM Staff WHERE Name = "Daniel"`,
+			nil,
+			[][]interface{}{{int64(9), int64(3), 1.8, 1.0, int64(2), 1.83}},
+		},
+		{
+			`SELECT Name, IF(Cool, "yes", "no"), IFNULL(Cool, TRUE), NULLIF(Tenure, 9), ` +
+				`CASE WHEN Tenure > 10 THEN "long" WHEN Tenure > 8 THEN "medium" ELSE "short" END FROM Staff ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"Daniel", "no", false, int64(11), "long"},
+				{"George", "no", true, int64(6), "short"},
+				{"Jack", "no", true, int64(10), "medium"},
+				{"Sam", "no", false, nil, "medium"},
+				{"Teal'c", "yes", true, int64(8), "short"},
+			},
+		},
+		{
+			// Comparisons with NULL are NULL, and logical operators use three-valued logic.
+			`SELECT Name, NULL = NULL, Cool = TRUE, NOT (Cool = TRUE), Cool OR TRUE, Cool AND TRUE, Cool AND FALSE, Cool IS TRUE, ` +
+				`Cool IN (TRUE), IF(Cool = TRUE, "y", "n"), CASE WHEN NOT (Cool = TRUE) THEN "y" ELSE "n" END FROM Staff WHERE Name = "Jack"`,
+			nil,
+			[][]interface{}{{"Jack", nil, nil, nil, true, nil, false, false, nil, "n", "n"}},
+		},
+		{
+			`SELECT Name FROM Staff WHERE NOT (Cool = TRUE) ORDER BY Name`,
+			nil,
+			[][]interface{}{
+				{"Daniel"},
+				{"Sam"},
+			},
+		},
+		{
+			`SELECT LOGICAL_AND(Cool = FALSE), LOGICAL_OR(NOT (Cool = FALSE)) FROM Staff WHERE Name IN ("Daniel", "Jack")`,
+			nil,
+			[][]interface{}{{true, false}},
+		},
+		{
+			`SELECT CASE ID WHEN 1 THEN "one" WHEN 2 THEN "two" END, COALESCE(Cool, ID > 1) FROM Staff WHERE ID < 4 ORDER BY ID`,
+			nil,
+			[][]interface{}{
+				{"one", false},
+				{"two", false},
+				{nil, false},
+			},
+		},
+		{
+			`SELECT CAST(Tenure AS STRING), CAST("12" AS INT64), CAST(Height AS INT64), SAFE_CAST("x" AS INT64), CAST(FirstSeen AS STRING) FROM Staff WHERE Name = "Jack"`,
+			nil,
+			[][]interface{}{{"10", int64(12), int64(2), nil, "1994-10-28"}},
+		},
+		{
+			`SELECT ARRAY_LENGTH([1, 2, 3]), x FROM UNNEST(["a", "b"]) AS x ORDER BY x DESC`,
+			nil,
+			[][]interface{}{
+				{int64(3), "b"},
+				{int64(3), "a"},
+			},
+		},
+		{
+			// A NULL literal is accepted as the argument of any function, and the result is NULL.
+			`SELECT LOWER(NULL), CONCAT("a", NULL), CONCAT(NULL, b"x"), ABS(NULL), GREATEST(1, NULL), ARRAY_LENGTH(NULL), ` +
+				`IF(NULL, 1, 2), CASE WHEN NULL THEN 1 ELSE 2 END FROM Staff WHERE Name = "Jack"`,
+			nil,
+			[][]interface{}{{nil, nil, nil, nil, nil, nil, int64(2), int64(2)}},
+		},
+		{
+			`SELECT Name, n FROM Staff, UNNEST([ID, Tenure]) AS n WHERE Name = "Jack" ORDER BY n`,
+			nil,
+			[][]interface{}{
+				{"Jack", int64(1)},
+				{"Jack", int64(10)},
+			},
+		},
+		{
+			`SELECT Name FROM Staff WHERE ID IN UNNEST(@ids) ORDER BY Name`,
+			queryParams{"ids": []interface{}{int64(1), int64(4)}},
+			[][]interface{}{
+				{"Jack"},
+				{"Teal'c"},
+			},
+		},
+		{
+			`SELECT EXTRACT(YEAR FROM FirstSeen), DATE_ADD(FirstSeen, INTERVAL 1 MONTH), FORMAT_DATE("%d/%m/%Y", FirstSeen) FROM Staff WHERE Name = "George"`,
+			nil,
+			[][]interface{}{{int64(1997), "1997-08-27", "27/07/1997"}},
+		},
+		{
+			`SELECT TIMESTAMP_ADD(@t, INTERVAL 90 MINUTE), EXTRACT(HOUR FROM @t AT TIME ZONE "UTC"), ` +
+				`FORMAT_TIMESTAMP("%Y-%m-%d %H:%M", @t, "America/New_York"), ` +
+				`TIMESTAMP_DIFF(@t, CAST("2019-11-20 00:00:00+00" AS TIMESTAMP), HOUR), CAST(@t AS STRING)`,
+			queryParams{"t": time.Date(2019, 11, 20, 13, 14, 15, 0, time.UTC)},
+			[][]interface{}{{
+				time.Date(2019, 11, 20, 14, 44, 15, 0, time.UTC),
+				int64(13),
+				"2019-11-20 08:14",
+				int64(13),
+				"2019-11-20 05:14:15-08",
+			}},
+		},
 	}
 	for _, test := range tests {
 		q, err := spansql.ParseQuery(test.q)
@@ -290,7 +591,7 @@ func TestTableData(t *testing.T) {
 			t.Errorf("ParseQuery(%q): %v", test.q, err)
 			continue
 		}
-		ri, err := db.Query(q, test.params)
+		ri, err := db.Query(snapshotTx(t, &db), q, test.params)
 		if err != nil {
 			t.Errorf("Query(%q, %v): %v", test.q, test.params, err)
 			continue
@@ -300,6 +601,163 @@ func TestTableData(t *testing.T) {
 			t.Errorf("Results from Query(%q, %v) are wrong.\n got %v\nwant %v", test.q, test.params, all, test.want)
 		}
 	}
+
+	// Non-aggregated columns must be grouped.
+	for _, q := range []string{
+		`SELECT Name, COUNT(*) FROM Staff`,
+		`SELECT Cool, Name FROM Staff GROUP BY Cool`,
+		`SELECT Cool FROM Staff GROUP BY Cool HAVING Tenure > 1`,
+	} {
+		pq, err := spansql.ParseQuery(q)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", q, err)
+			continue
+		}
+		if _, err := db.Query(snapshotTx(t, &db), pq, nil); err == nil {
+			t.Errorf("Query(%q) succeeded, want error", q)
+		}
+	}
+
+	// Other invalid queries.
+	for _, q := range []string{
+		`SELECT Name FROM Staff JOIN Missions ON Staff.Name = Missions.Name`,      // ambiguous column
+		`SELECT (SELECT Name FROM Missions)`,                                      // scalar subquery with multiple rows
+		`SELECT Name FROM Staff UNION ALL SELECT Name, Mission FROM Missions`,     // mismatched column count
+		`SELECT Name FROM Staff WHERE ID IN (SELECT Name, Mission FROM Missions)`, // multiple columns for IN
+		`SELECT Tenure + Name FROM Staff`,                                         // mismatched operand types
+		`SELECT ID / 0 FROM Staff`,                                                // division by zero
+		`SELECT SUBSTR(Name) FROM Staff`,                                          // too few arguments
+		`SELECT CAST(Height AS DATE) FROM Staff`,                                  // unsupported conversion
+		`SELECT CAST(Name AS INT64) FROM Staff`,                                   // bad conversion
+		`SELECT IF(ID, 1, 2) FROM Staff`,                                          // non-bool condition
+		`SELECT NO_SUCH_FUNC(ID) FROM Staff`,                                      // unknown function
+	} {
+		pq, err := spansql.ParseQuery(q)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", q, err)
+			continue
+		}
+		if _, err := db.Query(snapshotTx(t, &db), pq, nil); err == nil {
+			t.Errorf("Query(%q) succeeded, want error", q)
+		}
+	}
+}
+
+func TestTableDML(t *testing.T) {
+	var db database
+	st := db.ApplyDDL(stdTestTable)
+	if st.Code() != codes.OK {
+		t.Fatalf("Creating table: %v", st.Err())
+	}
+
+	exec := func(sql string, params queryParams) (int, error) {
+		t.Helper()
+		stmt, err := spansql.ParseDMLStmt(sql)
+		if err != nil {
+			t.Fatalf("ParseDMLStmt(%q): %v", sql, err)
+		}
+		tx := db.NewTransaction()
+		n, err := db.Execute(tx, stmt, params)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if _, err := tx.Commit(); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	query := func(sql string) [][]interface{} {
+		t.Helper()
+		q, err := spansql.ParseQuery(sql)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %v", sql, err)
+		}
+		ri, err := db.Query(snapshotTx(t, &db), q, nil)
+		if err != nil {
+			t.Fatalf("Query(%q): %v", sql, err)
+		}
+		return slurp(ri)
+	}
+
+	tests := []struct {
+		sql    string
+		params queryParams
+		n      int
+	}{
+		{`INSERT INTO Staff (Name, ID, Tenure, Cool) VALUES ("Jack", 1, 10, TRUE), ("Daniel", 2, 11, FALSE)`, nil, 2},
+		// Parameters without types arrive as strings, and are coerced to the column type.
+		{`INSERT Staff (Name, ID, Height) VALUES (@name, @id, @height)`, queryParams{"name": "Sam", "id": "3", "height": 1.75}, 1},
+		{`UPDATE Staff SET Cool = TRUE, Height = DEFAULT WHERE Name = "Daniel" OR Name = "Sam"`, nil, 2},
+		{`UPDATE Staff SET Tenure = 1 WHERE Cool IS NULL`, nil, 0},
+		{`DELETE FROM Staff WHERE ID > 1 AND Cool`, nil, 2},
+	}
+	for _, test := range tests {
+		n, err := exec(test.sql, test.params)
+		if err != nil {
+			t.Fatalf("Executing %q: %v", test.sql, err)
+		}
+		if n != test.n {
+			t.Errorf("Executing %q affected %d rows, want %d", test.sql, n, test.n)
+		}
+	}
+	got := query(`SELECT Name, ID, Tenure, Cool FROM Staff`)
+	want := [][]interface{}{
+		{"Jack", int64(1), int64(10), true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Table data after DML wrong.\n got %v\nwant %v", got, want)
+	}
+
+	// A failing statement should have no effect.
+	if _, err := exec(`INSERT Staff (Name, ID) VALUES ("Teal'c", 4), ("Jack", 1)`, nil); err == nil {
+		t.Errorf("Inserting duplicate row succeeded")
+	}
+	if _, err := exec(`UPDATE Staff SET ID = 7 WHERE TRUE`, nil); err == nil {
+		t.Errorf("Updating primary key column succeeded")
+	}
+	if _, err := exec(`INSERT Staff (Tenure) VALUES (4)`, nil); err == nil {
+		t.Errorf("Inserting row without primary key succeeded")
+	}
+	got = query(`SELECT Name FROM Staff`)
+	want = [][]interface{}{{"Jack"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Table data after failed DML wrong.\n got %v\nwant %v", got, want)
+	}
+
+	// INSERT ... SELECT from the same table.
+	if _, err := exec(`INSERT Staff (Name, ID, Tenure) SELECT Name, Tenure, ID FROM Staff`, nil); err != nil {
+		t.Fatalf("INSERT ... SELECT: %v", err)
+	}
+	got = query(`SELECT Name, ID, Tenure FROM Staff ORDER BY ID`)
+	want = [][]interface{}{
+		{"Jack", int64(1), int64(10)},
+		{"Jack", int64(10), int64(1)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Table data after INSERT ... SELECT wrong.\n got %v\nwant %v", got, want)
+	}
+}
+
+// applyInTx runs f in a new read-write transaction on db, then commits it.
+func applyInTx(db *database, f func(tx *transaction) error) error {
+	tx := db.NewTransaction()
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err := tx.Commit()
+	return err
+}
+
+// snapshotTx returns a read-only transaction that reads the latest data in db.
+func snapshotTx(t *testing.T, db *database) *transaction {
+	t.Helper()
+	tx, err := db.NewReadOnlyTransaction(time.Time{})
+	if err != nil {
+		t.Fatalf("Starting read-only transaction: %v", err)
+	}
+	return tx
 }
 
 func slurp(ri *resultIter) (all [][]interface{}) {
@@ -318,6 +776,398 @@ func floatV(f float64) *structpb.Value                { return &structpb.Value{K
 func boolV(b bool) *structpb.Value                    { return &structpb.Value{Kind: &structpb.Value_BoolValue{b}} }
 func nullV() *structpb.Value                          { return &structpb.Value{Kind: &structpb.Value_NullValue{}} }
 
+func TestTransactions(t *testing.T) {
+	var db database
+	st := db.ApplyDDL(stdTestTable)
+	if st.Code() != codes.OK {
+		t.Fatalf("Creating table: %v", st.Err())
+	}
+	insert := func(tx *transaction, name, id string) {
+		t.Helper()
+		err := db.Insert(tx, "Staff", []string{"Name", "ID"}, []*structpb.ListValue{
+			listV(stringV(name), stringV(id)),
+		})
+		if err != nil {
+			t.Fatalf("Inserting %s: %v", name, err)
+		}
+	}
+	names := func(tx *transaction) []string {
+		t.Helper()
+		ri, err := db.ReadAll(tx, "Staff", []string{"Name"}, 0)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		var ns []string
+		for _, r := range slurp(ri) {
+			ns = append(ns, r[0].(string))
+		}
+		return ns
+	}
+	exec := func(tx *transaction, sql string) {
+		t.Helper()
+		stmt, err := spansql.ParseDMLStmt(sql)
+		if err != nil {
+			t.Fatalf("ParseDMLStmt(%q): %v", sql, err)
+		}
+		if _, err := db.Execute(tx, stmt, nil); err != nil {
+			t.Fatalf("Executing %q: %v", sql, err)
+		}
+	}
+
+	tx := db.NewTransaction()
+	insert(tx, "Jack", "1")
+	ts1, err := tx.Commit()
+	if err != nil {
+		t.Fatalf("Committing: %v", err)
+	}
+	ro1 := snapshotTx(t, &db)
+
+	// Buffered mutations are not visible until commit, even to their own transaction.
+	// DML statements are visible to the rest of their transaction.
+	tx = db.NewTransaction()
+	insert(tx, "Daniel", "2")
+	exec(tx, `INSERT Staff (Name, ID) VALUES ("Sam", 3)`)
+	if got, want := names(tx), []string{"Jack", "Sam"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inside transaction, read %v, want %v", got, want)
+	}
+	if got, want := names(snapshotTx(t, &db)), []string{"Jack"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Outside transaction, read %v, want %v", got, want)
+	}
+	ts2, err := tx.Commit()
+	if err != nil {
+		t.Fatalf("Committing: %v", err)
+	}
+	if !ts2.After(ts1) {
+		t.Errorf("Commit timestamp %v not after earlier commit timestamp %v", ts2, ts1)
+	}
+	if got, want := names(snapshotTx(t, &db)), []string{"Daniel", "Jack", "Sam"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("After commit, read %v, want %v", got, want)
+	}
+
+	// Read-only transactions see the data as of their timestamp.
+	if got, want := names(ro1), []string{"Jack"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Read-only transaction started before commit read %v, want %v", got, want)
+	}
+	stale, err := db.NewReadOnlyTransaction(ts1)
+	if err != nil {
+		t.Fatalf("Starting stale read-only transaction: %v", err)
+	}
+	if got, want := names(stale), []string{"Jack"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Read at first commit timestamp read %v, want %v", got, want)
+	}
+	if !stale.ReadTimestamp().Equal(ts1) {
+		t.Errorf("Stale read timestamp is %v, want %v", stale.ReadTimestamp(), ts1)
+	}
+	if err := db.Insert(stale, "Staff", []string{"Name", "ID"}, nil); err == nil {
+		t.Errorf("Writing in a read-only transaction succeeded")
+	}
+
+	// A transaction whose reads are invalidated by a concurrent commit is aborted.
+	tx1, tx2 := db.NewTransaction(), db.NewTransaction()
+	names(tx1)
+	names(tx2)
+	exec(tx1, `UPDATE Staff SET Tenure = 1 WHERE TRUE`)
+	exec(tx2, `UPDATE Staff SET Tenure = 2 WHERE TRUE`)
+	if _, err := tx1.Commit(); err != nil {
+		t.Fatalf("Committing first transaction: %v", err)
+	}
+	if _, err := tx2.Commit(); status.Code(err) != codes.Aborted {
+		t.Errorf("Committing conflicting transaction: got %v, want code %v", err, codes.Aborted)
+	}
+
+	// Transactions touching different rows do not conflict.
+	tx1, tx2 = db.NewTransaction(), db.NewTransaction()
+	for _, tx := range []*transaction{tx1, tx2} {
+		ri, err := db.Read(tx, "Staff", []string{"Name"}, []*structpb.ListValue{listV(stringV("Jack"), stringV("1"))}, 0)
+		if err != nil {
+			t.Fatalf("Reading: %v", err)
+		}
+		slurp(ri)
+	}
+	insert(tx1, "Teal'c", "4")
+	insert(tx2, "George", "5")
+	if _, err := tx1.Commit(); err != nil {
+		t.Fatalf("Committing first transaction: %v", err)
+	}
+	if _, err := tx2.Commit(); err != nil {
+		t.Fatalf("Committing non-conflicting transaction: %v", err)
+	}
+
+	// A blind write conflicts with nothing, but still fails if the row it inserts now exists.
+	tx = db.NewTransaction()
+	insert(tx, "George", "5")
+	if _, err := tx.Commit(); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Committing duplicate insert: got %v, want code %v", err, codes.AlreadyExists)
+	}
+
+	// A rolled back transaction has no effect.
+	tx = db.NewTransaction()
+	exec(tx, `DELETE FROM Staff WHERE TRUE`)
+	tx.Rollback()
+	if got, want := names(snapshotTx(t, &db)), []string{"Daniel", "George", "Jack", "Sam", "Teal'c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("After rollback, read %v, want %v", got, want)
+	}
+	if _, err := tx.Commit(); err == nil {
+		t.Errorf("Committing a rolled back transaction succeeded")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	var db database
+	ddl := func(sql string) *status.Status {
+		t.Helper()
+		stmt, err := spansql.ParseDDLStmt(sql)
+		if err != nil {
+			t.Fatalf("ParseDDLStmt(%q): %v", sql, err)
+		}
+		return db.ApplyDDL(stmt)
+	}
+	if st := db.ApplyDDL(stdTestTable); st.Code() != codes.OK {
+		t.Fatalf("Creating table: %v", st.Err())
+	}
+	err := applyInTx(&db, func(tx *transaction) error {
+		return db.Insert(tx, "Staff", []string{"Name", "ID", "Tenure", "Height"}, []*structpb.ListValue{
+			listV(stringV("Jack"), stringV("1"), stringV("10"), floatV(1.85)),
+			listV(stringV("Daniel"), stringV("2"), stringV("11"), floatV(1.83)),
+			listV(stringV("Sam"), stringV("3"), stringV("9"), floatV(1.75)),
+			listV(stringV("Teal'c"), stringV("4"), nullV(), floatV(1.91)),
+		})
+	})
+	if err != nil {
+		t.Fatalf("Inserting data: %v", err)
+	}
+
+	// Indexes on existing data.
+	for _, sql := range []string{
+		`CREATE INDEX StaffByTenure ON Staff(Tenure DESC) STORING (Height)`,
+		`CREATE UNIQUE NULL_FILTERED INDEX StaffByID ON Staff(ID)`,
+	} {
+		if st := ddl(sql); st.Code() != codes.OK {
+			t.Fatalf("Applying %q: %v", sql, st.Err())
+		}
+	}
+	for _, test := range []struct {
+		sql  string
+		code codes.Code
+	}{
+		{`CREATE INDEX StaffByID ON Staff(Name)`, codes.AlreadyExists},
+		{`CREATE INDEX StaffByCool ON Staff(NoSuchColumn)`, codes.InvalidArgument},
+		{`CREATE INDEX StaffByTenure2 ON Staff(Tenure) STORING (ID)`, codes.InvalidArgument},
+		{`CREATE UNIQUE INDEX StaffByCool ON Staff(Cool)`, codes.FailedPrecondition}, // all NULL
+		{`DROP TABLE Staff`, codes.FailedPrecondition},
+	} {
+		if st := ddl(test.sql); st.Code() != test.code {
+			t.Errorf("Applying %q: got %v, want code %v", test.sql, st.Err(), test.code)
+		}
+	}
+
+	readIndex := func(tx *transaction, index string, cols []string, keys []*structpb.ListValue, krl keyRangeList, all bool) [][]interface{} {
+		t.Helper()
+		ri, err := db.ReadIndex(tx, "Staff", index, cols, keys, krl, all, 0)
+		if err != nil {
+			t.Fatalf("Reading through index %s: %v", index, err)
+		}
+		return slurp(ri)
+	}
+	got := readIndex(snapshotTx(t, &db), "StaffByTenure", []string{"Name", "Tenure", "Height"}, nil, nil, true)
+	want := [][]interface{}{
+		{"Daniel", int64(11), 1.83},
+		{"Jack", int64(10), 1.85},
+		{"Sam", int64(9), 1.75},
+		{"Teal'c", nil, 1.91},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reading all of index in order.\n got %v\nwant %v", got, want)
+	}
+	got = readIndex(snapshotTx(t, &db), "StaffByTenure", []string{"Name"}, []*structpb.ListValue{
+		listV(stringV("9")),
+		listV(nullV()),
+		listV(stringV("9")),
+	}, keyRangeList{
+		// In index order, so descending.
+		{start: listV(stringV("11")), end: listV(stringV("9")), startClosed: false, endClosed: false},
+	}, false)
+	want = [][]interface{}{{"Jack"}, {"Sam"}, {"Teal'c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reading keys and ranges of index.\n got %v\nwant %v", got, want)
+	}
+	if _, err := db.ReadIndex(snapshotTx(t, &db), "Staff", "StaffByID", []string{"Height"}, nil, nil, true, 0); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Reading column not stored in index: got %v, want code %v", err, codes.InvalidArgument)
+	}
+
+	// Indexes follow writes, and unique indexes are enforced at commit.
+	err = applyInTx(&db, func(tx *transaction) error {
+		if err := db.Update(tx, "Staff", []string{"Name", "ID", "Tenure"}, []*structpb.ListValue{
+			listV(stringV("Teal'c"), stringV("4"), stringV("12")),
+		}); err != nil {
+			return err
+		}
+		return db.Delete(tx, "Staff", []*structpb.ListValue{listV(stringV("Jack"), stringV("1"))}, nil, false)
+	})
+	if err != nil {
+		t.Fatalf("Updating data: %v", err)
+	}
+	got = readIndex(snapshotTx(t, &db), "StaffByTenure", []string{"Name"}, nil, nil, true)
+	want = [][]interface{}{{"Teal'c"}, {"Daniel"}, {"Sam"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reading index after writes.\n got %v\nwant %v", got, want)
+	}
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Insert(tx, "Staff", []string{"Name", "ID"}, []*structpb.ListValue{
+			listV(stringV("George"), stringV("2")),
+		})
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Inserting duplicate into unique index: got %v, want code %v", err, codes.AlreadyExists)
+	}
+	err = applyInTx(&db, func(tx *transaction) error {
+		return db.Insert(tx, "Staff", []string{"Name", "ID"}, []*structpb.ListValue{
+			listV(stringV("George"), nullV()),
+			listV(stringV("Harry"), nullV()),
+		})
+	})
+	if err != nil {
+		t.Errorf("Inserting NULLs into NULL_FILTERED unique index: %v", err)
+	}
+
+	// Queries may force the use of an index.
+	q, err := spansql.ParseQuery(`SELECT Name FROM Staff@{FORCE_INDEX=StaffByID}`)
+	if err != nil {
+		t.Fatalf("Parsing query: %v", err)
+	}
+	ri, err := db.Query(snapshotTx(t, &db), q, nil)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	got = slurp(ri)
+	want = [][]interface{}{{"Daniel"}, {"Sam"}, {"Teal'c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query with forced index.\n got %v\nwant %v", got, want)
+	}
+
+	if st := ddl(`DROP INDEX StaffByTenure`); st.Code() != codes.OK {
+		t.Fatalf("Dropping index: %v", st.Err())
+	}
+	if _, err := db.ReadIndex(snapshotTx(t, &db), "Staff", "StaffByTenure", []string{"Name"}, nil, nil, true, 0); status.Code(err) != codes.NotFound {
+		t.Errorf("Reading dropped index: got %v, want code %v", err, codes.NotFound)
+	}
+}
+
+func TestInterleaving(t *testing.T) {
+	var db database
+	ddl := func(sql string) *status.Status {
+		t.Helper()
+		stmt, err := spansql.ParseDDLStmt(sql)
+		if err != nil {
+			t.Fatalf("ParseDDLStmt(%q): %v", sql, err)
+		}
+		return db.ApplyDDL(stmt)
+	}
+	for _, test := range []struct {
+		sql  string
+		code codes.Code
+	}{
+		{`CREATE TABLE Singers (SingerId INT64 NOT NULL, Name STRING(MAX)) PRIMARY KEY (SingerId)`, codes.OK},
+		{`CREATE TABLE Albums (SingerId INT64 NOT NULL, AlbumId INT64 NOT NULL) PRIMARY KEY (SingerId, AlbumId),
+			INTERLEAVE IN PARENT Singers ON DELETE CASCADE`, codes.OK},
+		{`CREATE TABLE Songs (SingerId INT64 NOT NULL, AlbumId INT64 NOT NULL, TrackId INT64 NOT NULL) PRIMARY KEY (SingerId, AlbumId, TrackId),
+			INTERLEAVE IN PARENT Albums ON DELETE NO ACTION`, codes.OK},
+		{`CREATE TABLE Tours (TourId INT64 NOT NULL) PRIMARY KEY (TourId), INTERLEAVE IN PARENT Bands`, codes.NotFound},
+		{`CREATE TABLE Tours (SingerId STRING(MAX), TourId INT64) PRIMARY KEY (SingerId, TourId), INTERLEAVE IN PARENT Singers`, codes.InvalidArgument},
+		{`CREATE TABLE Tours (SingerId INT64, TourId INT64) PRIMARY KEY (TourId, SingerId), INTERLEAVE IN PARENT Singers`, codes.InvalidArgument},
+		{`ALTER TABLE Singers SET ON DELETE CASCADE`, codes.FailedPrecondition},
+		{`DROP TABLE Albums`, codes.FailedPrecondition},
+	} {
+		if st := ddl(test.sql); st.Code() != test.code {
+			t.Errorf("Applying %q: got %v, want code %v", test.sql, st.Err(), test.code)
+		}
+	}
+
+	insert := func(tbl string, keys ...string) func(tx *transaction) error {
+		return func(tx *transaction) error {
+			var vs []*structpb.Value
+			for _, k := range keys {
+				vs = append(vs, stringV(k))
+			}
+			cols := []string{"SingerId", "AlbumId", "TrackId"}[:len(keys)]
+			return db.Insert(tx, tbl, cols, []*structpb.ListValue{listV(vs...)})
+		}
+	}
+	count := func(tbl string) int {
+		t.Helper()
+		ri, err := db.ReadAll(snapshotTx(t, &db), tbl, []string{"SingerId"}, 0)
+		if err != nil {
+			t.Fatalf("Reading %s: %v", tbl, err)
+		}
+		return len(slurp(ri))
+	}
+	deleteSinger := func(id string) error {
+		return applyInTx(&db, func(tx *transaction) error {
+			return db.Delete(tx, "Singers", []*structpb.ListValue{listV(stringV(id))}, nil, false)
+		})
+	}
+
+	// A child row needs a parent row, which may be written later in the same transaction.
+	if err := applyInTx(&db, insert("Albums", "1", "1")); status.Code(err) != codes.NotFound {
+		t.Errorf("Inserting child row without parent: got %v, want code %v", err, codes.NotFound)
+	}
+	err := applyInTx(&db, func(tx *transaction) error {
+		for _, f := range []func(*transaction) error{
+			insert("Albums", "1", "1"),
+			insert("Albums", "1", "2"),
+			insert("Singers", "1"),
+			insert("Singers", "2"),
+			insert("Albums", "2", "1"),
+		} {
+			if err := f(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Inserting rows: %v", err)
+	}
+
+	// Deleting a parent row cascades to a child table with ON DELETE CASCADE.
+	if err := deleteSinger("1"); err != nil {
+		t.Fatalf("Deleting parent row: %v", err)
+	}
+	if got := count("Albums"); got != 1 {
+		t.Errorf("After cascading delete, Albums has %d rows, want 1", got)
+	}
+
+	// ... but not to one with ON DELETE NO ACTION, even several levels down.
+	if err := applyInTx(&db, insert("Songs", "2", "1", "1")); err != nil {
+		t.Fatalf("Inserting grandchild row: %v", err)
+	}
+	if err := deleteSinger("2"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Deleting row with grandchild rows: got %v, want code %v", err, codes.FailedPrecondition)
+	}
+	if got := count("Singers"); got != 1 {
+		t.Errorf("After failed delete, Singers has %d rows, want 1", got)
+	}
+	if st := ddl(`ALTER TABLE Songs SET ON DELETE CASCADE`); st.Code() != codes.OK {
+		t.Fatalf("Changing ON DELETE action: %v", st.Err())
+	}
+	stmt, err := spansql.ParseDMLStmt(`DELETE FROM Singers WHERE TRUE`)
+	if err != nil {
+		t.Fatalf("Parsing DML: %v", err)
+	}
+	err = applyInTx(&db, func(tx *transaction) error {
+		_, err := db.Execute(tx, stmt, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Deleting all parent rows: %v", err)
+	}
+	for _, tbl := range []string{"Singers", "Albums", "Songs"} {
+		if got := count(tbl); got != 0 {
+			t.Errorf("After cascading delete, %s has %d rows, want 0", tbl, got)
+		}
+	}
+}
+
 func TestRowCmp(t *testing.T) {
 	r := func(x ...interface{}) []interface{} { return x }
 	tests := []struct {
@@ -0,0 +1,1796 @@
+/*
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spannertest
+
+// This file contains implementations of query functions.
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"cloud.google.com/go/spanner/spansql"
+)
+
+// aggregateFunc represents an aggregate function.
+// https://cloud.google.com/spanner/docs/aggregate_functions
+type aggregateFunc struct {
+	// Whether the function may be passed *, as in COUNT(*).
+	AcceptStar bool
+
+	// Type returns the result type of the function given its argument type.
+	Type func(spansql.Type) (spansql.Type, error)
+
+	// Eval computes the function's result from the argument's value for each row,
+	// which may include NULLs.
+	Eval func(values []interface{}) (interface{}, error)
+}
+
+var (
+	boolType      = spansql.Type{Base: spansql.Bool}
+	int64Type     = spansql.Type{Base: spansql.Int64}
+	float64Type   = spansql.Type{Base: spansql.Float64}
+	stringType    = spansql.Type{Base: spansql.String}
+	bytesType     = spansql.Type{Base: spansql.Bytes}
+	dateType      = spansql.Type{Base: spansql.Date}
+	timestampType = spansql.Type{Base: spansql.Timestamp}
+
+	// nullType is the type of a NULL literal passed to a scalar function.
+	// Elsewhere a NULL literal has type INT64 (see colInfo), but as an
+	// argument it is assignable to any parameter type.
+	nullType = spansql.Type{Base: -1}
+)
+
+var aggregateFuncs = map[string]aggregateFunc{
+	"ANY_VALUE": {
+		Type: func(t spansql.Type) (spansql.Type, error) { return t, nil },
+		Eval: func(values []interface{}) (interface{}, error) {
+			// Any value is permitted, so pick the first non-NULL value.
+			for _, v := range values {
+				if v != nil {
+					return v, nil
+				}
+			}
+			return nil, nil
+		},
+	},
+	"ARRAY_AGG": {
+		Type: func(t spansql.Type) (spansql.Type, error) {
+			if t.Array {
+				return spansql.Type{}, fmt.Errorf("ARRAY_AGG of %s not supported", t.SQL())
+			}
+			t.Array = true
+			return t, nil
+		},
+		Eval: func(values []interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			for _, v := range values {
+				if v == nil {
+					return nil, fmt.Errorf("ARRAY_AGG: array cannot have a NULL element")
+				}
+			}
+			return append([]interface{}(nil), values...), nil
+		},
+	},
+	"AVG": {
+		Type: func(t spansql.Type) (spansql.Type, error) {
+			if t.Array || (t.Base != spansql.Int64 && t.Base != spansql.Float64) {
+				return spansql.Type{}, fmt.Errorf("AVG of %s not supported", t.SQL())
+			}
+			return float64Type, nil
+		},
+		Eval: func(values []interface{}) (interface{}, error) {
+			var sum float64
+			n := 0
+			for _, v := range values {
+				switch v := v.(type) {
+				case nil:
+					continue
+				case int64:
+					sum += float64(v)
+				case float64:
+					sum += v
+				default:
+					return nil, fmt.Errorf("AVG of %T not supported", v)
+				}
+				n++
+			}
+			if n == 0 {
+				return nil, nil
+			}
+			return sum / float64(n), nil
+		},
+	},
+	"COUNT": {
+		AcceptStar: true,
+		Type:       func(spansql.Type) (spansql.Type, error) { return int64Type, nil },
+		Eval: func(values []interface{}) (interface{}, error) {
+			var n int64
+			for _, v := range values {
+				if v != nil {
+					n++
+				}
+			}
+			return n, nil
+		},
+	},
+	"LOGICAL_AND": {
+		Type: func(t spansql.Type) (spansql.Type, error) { return boolType, nil },
+		Eval: func(values []interface{}) (interface{}, error) {
+			return evalLogicalAgg(values, true, "LOGICAL_AND")
+		},
+	},
+	"LOGICAL_OR": {
+		Type: func(t spansql.Type) (spansql.Type, error) { return boolType, nil },
+		Eval: func(values []interface{}) (interface{}, error) {
+			return evalLogicalAgg(values, false, "LOGICAL_OR")
+		},
+	},
+	"MAX": {
+		Type: func(t spansql.Type) (spansql.Type, error) { return t, nil },
+		Eval: func(values []interface{}) (interface{}, error) {
+			return evalExtremum(values, 1), nil
+		},
+	},
+	"MIN": {
+		Type: func(t spansql.Type) (spansql.Type, error) { return t, nil },
+		Eval: func(values []interface{}) (interface{}, error) {
+			return evalExtremum(values, -1), nil
+		},
+	},
+	"SUM": {
+		Type: func(t spansql.Type) (spansql.Type, error) {
+			if t.Array || (t.Base != spansql.Int64 && t.Base != spansql.Float64) {
+				return spansql.Type{}, fmt.Errorf("SUM of %s not supported", t.SQL())
+			}
+			return t, nil
+		},
+		Eval: func(values []interface{}) (interface{}, error) {
+			var isum int64
+			var fsum float64
+			seen, float := false, false
+			for _, v := range values {
+				switch v := v.(type) {
+				case nil:
+					continue
+				case int64:
+					if (v > 0 && isum > math.MaxInt64-v) || (v < 0 && isum < math.MinInt64-v) {
+						return nil, fmt.Errorf("SUM: int64 overflow")
+					}
+					isum += v
+				case float64:
+					fsum += v
+					float = true
+				default:
+					return nil, fmt.Errorf("SUM of %T not supported", v)
+				}
+				seen = true
+			}
+			if !seen {
+				return nil, nil
+			}
+			if float {
+				return fsum + float64(isum), nil
+			}
+			return isum, nil
+		},
+	},
+}
+
+// evalLogicalAgg computes LOGICAL_AND (if and is true) or LOGICAL_OR,
+// ignoring NULLs.
+func evalLogicalAgg(values []interface{}, and bool, name string) (interface{}, error) {
+	var res interface{} // NULL if there are no non-NULL values
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s of %T not supported", name, v)
+		}
+		if res == nil {
+			res = b
+		} else if and {
+			res = res.(bool) && b
+		} else {
+			res = res.(bool) || b
+		}
+	}
+	return res, nil
+}
+
+// evalExtremum returns the maximum (if dir is 1) or minimum (if dir is -1)
+// of the non-NULL values, or NULL if there are none.
+func evalExtremum(values []interface{}, dir int) interface{} {
+	var res interface{}
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		if res == nil || compareVals(v, res)*dir > 0 {
+			res = v
+		}
+	}
+	return res
+}
+
+// distinctValues returns the values with duplicates removed,
+// preserving the order in which they first appear.
+func distinctValues(values []interface{}) []interface{} {
+	seen := make(map[string]bool)
+	var out []interface{}
+	for _, v := range values {
+		k := keyString([]interface{}{v})
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// scalarFunc represents a scalar function.
+// https://cloud.google.com/spanner/docs/functions-and-operators
+//
+// CAST, SAFE_CAST, EXTRACT and the conditional functions (IF, IFNULL, COALESCE, NULLIF)
+// aren't represented this way, since their arguments need special handling.
+type scalarFunc struct {
+	// PartArg is the position (counting from 1) of an argument that is
+	// an INTERVAL expression, as in TIMESTAMP_ADD, or the name of a date part,
+	// as in TIMESTAMP_DIFF. It is zero if there is no such argument.
+	PartArg int
+
+	// Type returns the result type of the function given its argument types,
+	// or an error if they are unsuitable. The argument at PartArg is omitted.
+	Type func(args []spansql.Type) (spansql.Type, error)
+
+	// Eval computes the function's result from its argument values,
+	// which have been checked by Type. It is not called if any argument is NULL,
+	// since the result is then NULL. The argument at PartArg is passed
+	// as an interval, or as the date part name in upper case.
+	Eval func(args []interface{}) (interface{}, error)
+}
+
+// interval is the value of an INTERVAL expression.
+type interval struct {
+	n    int64
+	part string // upper case, such as "DAY"
+}
+
+var scalarFuncs = map[string]scalarFunc{
+	// String functions. Unless noted, these also operate on BYTES.
+	"BYTE_LENGTH": {
+		Type: stringSig(int64Type, 1, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return int64(len(asString(args[0]))), nil
+		},
+	},
+	"CHAR_LENGTH": {
+		// STRING only.
+		Type: sig(int64Type, 1, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return int64(utf8.RuneCountInString(args[0].(string))), nil
+		},
+	},
+	"CONCAT": {
+		Type: func(args []spansql.Type) (spansql.Type, error) {
+			params := make([]spansql.Type, len(args))
+			for i := range params {
+				params[i] = stringType
+			}
+			return stringSig(stringType, 1, params...)(args)
+		},
+		Eval: func(args []interface{}) (interface{}, error) {
+			var buf bytes.Buffer
+			for _, x := range args {
+				buf.WriteString(asString(x))
+			}
+			return sameKind(args[0], buf.String()), nil
+		},
+	},
+	"ENDS_WITH": {
+		Type: stringSig(boolType, 2, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return strings.HasSuffix(asString(args[0]), asString(args[1])), nil
+		},
+	},
+	"LENGTH": {
+		Type: stringSig(int64Type, 1, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				return int64(utf8.RuneCountInString(s)), nil
+			}
+			return int64(len(args[0].([]byte))), nil
+		},
+	},
+	"LOWER": {
+		Type: stringSig(stringType, 1, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return changeCase(args[0], false), nil
+		},
+	},
+	"LTRIM": {
+		Type: stringSig(stringType, 1, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return trim(args, true, false), nil
+		},
+	},
+	"REGEXP_CONTAINS": {
+		// STRING only.
+		Type: sig(boolType, 2, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			re, err := regexp.Compile(args[1].(string))
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(args[0].(string)), nil
+		},
+	},
+	"REGEXP_EXTRACT": {
+		// STRING only.
+		Type: sig(stringType, 2, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			re, err := regexp.Compile(args[1].(string))
+			if err != nil {
+				return nil, err
+			}
+			if re.NumSubexp() > 1 {
+				return nil, fmt.Errorf("regular expression may have at most one capturing group")
+			}
+			// The result is the match of the capturing group if there is one,
+			// or the match of the whole expression.
+			m := re.FindStringSubmatch(args[0].(string))
+			if m == nil {
+				return nil, nil
+			}
+			return m[len(m)-1], nil
+		},
+	},
+	"REGEXP_REPLACE": {
+		// STRING only.
+		Type: sig(stringType, 3, stringType, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			re, err := regexp.Compile(args[1].(string))
+			if err != nil {
+				return nil, err
+			}
+			return re.ReplaceAllString(args[0].(string), regexpReplacement(args[2].(string))), nil
+		},
+	},
+	"REPEAT": {
+		Type: stringSig(stringType, 2, stringType, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			n := args[1].(int64)
+			if n < 0 {
+				return nil, fmt.Errorf("negative repetitions %d", n)
+			}
+			return sameKind(args[0], strings.Repeat(asString(args[0]), int(n))), nil
+		},
+	},
+	"REPLACE": {
+		Type: stringSig(stringType, 3, stringType, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			s, from, to := asString(args[0]), asString(args[1]), asString(args[2])
+			if from == "" {
+				return args[0], nil
+			}
+			return sameKind(args[0], strings.Replace(s, from, to, -1)), nil
+		},
+	},
+	"REVERSE": {
+		Type: stringSig(stringType, 1, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				r := []rune(s)
+				for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+					r[i], r[j] = r[j], r[i]
+				}
+				return string(r), nil
+			}
+			b := append([]byte(nil), args[0].([]byte)...)
+			for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+				b[i], b[j] = b[j], b[i]
+			}
+			return b, nil
+		},
+	},
+	"RTRIM": {
+		Type: stringSig(stringType, 1, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return trim(args, false, true), nil
+		},
+	},
+	"SPLIT": {
+		Type: stringSig(spansql.Type{Array: true, Base: spansql.String}, 1, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			delim := ","
+			if len(args) > 1 {
+				delim = asString(args[1])
+			}
+			var out []interface{}
+			for _, part := range strings.Split(asString(args[0]), delim) {
+				out = append(out, sameKind(args[0], part))
+			}
+			return out, nil
+		},
+	},
+	"STARTS_WITH": {
+		Type: stringSig(boolType, 2, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return strings.HasPrefix(asString(args[0]), asString(args[1])), nil
+		},
+	},
+	"STRPOS": {
+		Type: stringSig(int64Type, 2, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			s := asString(args[0])
+			i := strings.Index(s, asString(args[1]))
+			if i < 0 {
+				return int64(0), nil
+			}
+			if _, ok := args[0].(string); ok {
+				// Positions in a STRING count characters, not bytes.
+				i = utf8.RuneCountInString(s[:i])
+			}
+			return int64(i + 1), nil
+		},
+	},
+	"SUBSTR": {
+		Type: stringSig(stringType, 2, stringType, int64Type, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				r := []rune(s)
+				i, j, err := substrRange(len(r), args[1:])
+				if err != nil {
+					return nil, err
+				}
+				return string(r[i:j]), nil
+			}
+			b := args[0].([]byte)
+			i, j, err := substrRange(len(b), args[1:])
+			if err != nil {
+				return nil, err
+			}
+			return append([]byte(nil), b[i:j]...), nil
+		},
+	},
+	"TRIM": {
+		Type: stringSig(stringType, 1, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return trim(args, true, true), nil
+		},
+	},
+	"UPPER": {
+		Type: stringSig(stringType, 1, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return changeCase(args[0], true), nil
+		},
+	},
+
+	// Mathematical functions.
+	"ABS": {
+		Type: numericSig,
+		Eval: func(args []interface{}) (interface{}, error) {
+			switch x := args[0].(type) {
+			case int64:
+				if x == math.MinInt64 {
+					return nil, fmt.Errorf("int64 overflow")
+				}
+				if x < 0 {
+					return -x, nil
+				}
+				return x, nil
+			default:
+				return math.Abs(x.(float64)), nil
+			}
+		},
+	},
+	"CEIL":    mathFunc(math.Ceil),
+	"CEILING": mathFunc(math.Ceil),
+	"DIV": {
+		Type: sig(int64Type, 2, int64Type, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			x, y := args[0].(int64), args[1].(int64)
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if x == math.MinInt64 && y == -1 {
+				return nil, fmt.Errorf("int64 overflow")
+			}
+			return x / y, nil
+		},
+	},
+	"EXP":   mathFunc(math.Exp),
+	"FLOOR": mathFunc(math.Floor),
+	"GREATEST": {
+		Type: orderedSig,
+		Eval: func(args []interface{}) (interface{}, error) {
+			return extremumOf(args, 1), nil
+		},
+	},
+	"IEEE_DIVIDE": {
+		Type: sig(float64Type, 2, float64Type, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return asFloat(args[0]) / asFloat(args[1]), nil
+		},
+	},
+	"IS_INF": {
+		Type: sig(boolType, 1, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return math.IsInf(asFloat(args[0]), 0), nil
+		},
+	},
+	"IS_NAN": {
+		Type: sig(boolType, 1, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return math.IsNaN(asFloat(args[0])), nil
+		},
+	},
+	"LEAST": {
+		Type: orderedSig,
+		Eval: func(args []interface{}) (interface{}, error) {
+			return extremumOf(args, -1), nil
+		},
+	},
+	"LN": {
+		Type: sig(float64Type, 1, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return logarithm(asFloat(args[0]), math.E)
+		},
+	},
+	"LOG": {
+		Type: sig(float64Type, 1, float64Type, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			base := math.E
+			if len(args) > 1 {
+				base = asFloat(args[1])
+			}
+			return logarithm(asFloat(args[0]), base)
+		},
+	},
+	"LOG10": {
+		Type: sig(float64Type, 1, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return logarithm(asFloat(args[0]), 10)
+		},
+	},
+	"MOD": {
+		Type: sig(int64Type, 2, int64Type, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			x, y := args[0].(int64), args[1].(int64)
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return x % y, nil
+		},
+	},
+	"POW":   powFunc,
+	"POWER": powFunc,
+	"ROUND": {
+		Type: sig(float64Type, 1, float64Type, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			// Halfway cases are rounded away from zero, as math.Round does.
+			return roundTo(args, math.Round), nil
+		},
+	},
+	"SAFE_DIVIDE": {
+		Type: sig(float64Type, 2, float64Type, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			x, y := asFloat(args[0]), asFloat(args[1])
+			if y == 0 {
+				return nil, nil
+			}
+			return x / y, nil
+		},
+	},
+	"SIGN": {
+		Type: numericSig,
+		Eval: func(args []interface{}) (interface{}, error) {
+			switch x := args[0].(type) {
+			case int64:
+				switch {
+				case x > 0:
+					return int64(1), nil
+				case x < 0:
+					return int64(-1), nil
+				}
+				return int64(0), nil
+			default:
+				f := x.(float64)
+				switch {
+				case f > 0:
+					return 1.0, nil
+				case f < 0:
+					return -1.0, nil
+				}
+				return f, nil // 0 or NaN
+			}
+		},
+	},
+	"SQRT": {
+		Type: sig(float64Type, 1, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			x := asFloat(args[0])
+			if x < 0 {
+				return nil, fmt.Errorf("argument must not be negative")
+			}
+			return math.Sqrt(x), nil
+		},
+	},
+	"TRUNC": {
+		Type: sig(float64Type, 1, float64Type, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return roundTo(args, math.Trunc), nil
+		},
+	},
+
+	// Array functions.
+	"ARRAY_CONCAT": {
+		Type: func(args []spansql.Type) (spansql.Type, error) {
+			if len(args) == 0 {
+				return spansql.Type{}, fmt.Errorf("requires at least one argument")
+			}
+			at := nullType
+			for _, t := range args {
+				if t == nullType {
+					continue
+				}
+				if at == nullType {
+					at = t
+				}
+				if !t.Array || !sameType(t, at) {
+					return spansql.Type{}, fmt.Errorf("arguments must be arrays of the same type")
+				}
+			}
+			if at == nullType {
+				return spansql.Type{Array: true, Base: spansql.Int64}, nil
+			}
+			return spansql.Type{Array: true, Base: at.Base}, nil
+		},
+		Eval: func(args []interface{}) (interface{}, error) {
+			out := []interface{}{}
+			for _, x := range args {
+				out = append(out, x.([]interface{})...)
+			}
+			return out, nil
+		},
+	},
+	"ARRAY_LENGTH": {
+		Type: func(args []spansql.Type) (spansql.Type, error) {
+			if _, err := checkArrayArg(args); err != nil {
+				return spansql.Type{}, err
+			}
+			return int64Type, nil
+		},
+		Eval: func(args []interface{}) (interface{}, error) {
+			return int64(len(args[0].([]interface{}))), nil
+		},
+	},
+	"ARRAY_REVERSE": {
+		Type: func(args []spansql.Type) (spansql.Type, error) {
+			t, err := checkArrayArg(args)
+			if err != nil {
+				return spansql.Type{}, err
+			}
+			return spansql.Type{Array: true, Base: t.Base}, nil
+		},
+		Eval: func(args []interface{}) (interface{}, error) {
+			arr := args[0].([]interface{})
+			out := make([]interface{}, len(arr))
+			for i, x := range arr {
+				out[len(arr)-1-i] = x
+			}
+			return out, nil
+		},
+	},
+	"ARRAY_TO_STRING": {
+		Type: stringSig(stringType, 2, spansql.Type{Array: true, Base: spansql.String}, stringType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			// NULL elements are omitted unless a replacement is given.
+			var parts []string
+			for _, x := range args[0].([]interface{}) {
+				if x == nil {
+					if len(args) < 3 {
+						continue
+					}
+					x = args[2]
+				}
+				parts = append(parts, asString(x))
+			}
+			return sameKind(args[1], strings.Join(parts, asString(args[1]))), nil
+		},
+	},
+
+	// Date and timestamp functions.
+	"CURRENT_DATE": {
+		Type: sig(dateType, 0, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			loc, err := timeZoneArg(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return formatDate(time.Now().In(loc)), nil
+		},
+	},
+	"CURRENT_TIMESTAMP": {
+		Type: sig(timestampType, 0),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return time.Now().UTC().Truncate(time.Microsecond), nil
+		},
+	},
+	"DATE": {
+		Type: func(args []spansql.Type) (spansql.Type, error) {
+			if len(args) == 3 {
+				return sig(dateType, 3, int64Type, int64Type, int64Type)(args)
+			}
+			return sig(dateType, 1, timestampType, stringType)(args)
+		},
+		Eval: func(args []interface{}) (interface{}, error) {
+			if len(args) == 3 {
+				y, m, d := args[0].(int64), args[1].(int64), args[2].(int64)
+				t := time.Date(int(y), time.Month(m), int(d), 0, 0, 0, 0, time.UTC)
+				if int64(t.Year()) != y || int64(t.Month()) != m || int64(t.Day()) != d {
+					return nil, fmt.Errorf("invalid date %d-%d-%d", y, m, d)
+				}
+				return formatDate(t), nil
+			}
+			loc, err := timeZoneArg(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return formatDate(args[0].(time.Time).In(loc)), nil
+		},
+	},
+	"DATE_ADD": {
+		PartArg: 2,
+		Type:    sig(dateType, 1, dateType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return dateAdd(args[0].(string), args[1], 1)
+		},
+	},
+	"DATE_DIFF": {
+		PartArg: 3,
+		Type:    sig(int64Type, 2, dateType, dateType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			a, err := parseDate(args[0].(string))
+			if err != nil {
+				return nil, err
+			}
+			b, err := parseDate(args[1].(string))
+			if err != nil {
+				return nil, err
+			}
+			months := func(t time.Time) int64 { return int64(t.Year())*12 + int64(t.Month()) - 1 }
+			switch part, _ := args[2].(string); part {
+			case "DAY":
+				return int64(a.Sub(b) / (24 * time.Hour)), nil
+			case "MONTH":
+				return months(a) - months(b), nil
+			case "QUARTER":
+				return months(a)/3 - months(b)/3, nil
+			case "YEAR":
+				return int64(a.Year() - b.Year()), nil
+			}
+			return nil, fmt.Errorf("unsupported date part %v", args[2])
+		},
+	},
+	"DATE_SUB": {
+		PartArg: 2,
+		Type:    sig(dateType, 1, dateType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return dateAdd(args[0].(string), args[1], -1)
+		},
+	},
+	"DATE_TRUNC": {
+		PartArg: 2,
+		Type:    sig(dateType, 1, dateType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			t, err := parseDate(args[0].(string))
+			if err != nil {
+				return nil, err
+			}
+			part, _ := args[1].(string)
+			if _, ok := timestampUnits[part]; ok && part != "DAY" {
+				return nil, fmt.Errorf("unsupported date part %s", part)
+			}
+			t, err = truncTime(t, part)
+			if err != nil {
+				return nil, err
+			}
+			return formatDate(t), nil
+		},
+	},
+	"FORMAT_DATE": {
+		Type: sig(stringType, 2, stringType, dateType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			t, err := parseDate(args[1].(string))
+			if err != nil {
+				return nil, err
+			}
+			return formatTime(args[0].(string), t)
+		},
+	},
+	"FORMAT_TIMESTAMP": {
+		Type: sig(stringType, 2, stringType, timestampType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			loc, err := timeZoneArg(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			return formatTime(args[0].(string), args[1].(time.Time).In(loc))
+		},
+	},
+	"TIMESTAMP": {
+		Type: func(args []spansql.Type) (spansql.Type, error) {
+			if len(args) > 0 && sameType(args[0], dateType) {
+				return sig(timestampType, 1, dateType, stringType)(args)
+			}
+			return sig(timestampType, 1, stringType, stringType)(args)
+		},
+		Eval: func(args []interface{}) (interface{}, error) {
+			// DATE values are also strings, and are parsed the same way.
+			loc, err := timeZoneArg(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return parseTimestamp(args[0].(string), loc)
+		},
+	},
+	"TIMESTAMP_ADD": {
+		PartArg: 2,
+		Type:    sig(timestampType, 1, timestampType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return timestampAdd(args[0].(time.Time), args[1], 1)
+		},
+	},
+	"TIMESTAMP_DIFF": {
+		PartArg: 3,
+		Type:    sig(int64Type, 2, timestampType, timestampType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			part, _ := args[2].(string)
+			unit, ok := timestampUnits[part]
+			if !ok {
+				return nil, fmt.Errorf("unsupported date part %v", args[2])
+			}
+			return int64(args[0].(time.Time).Sub(args[1].(time.Time)) / unit), nil
+		},
+	},
+	"TIMESTAMP_MICROS": {
+		Type: sig(timestampType, 1, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			n := args[0].(int64)
+			return time.Unix(n/1e6, n%1e6*1e3).UTC(), nil
+		},
+	},
+	"TIMESTAMP_MILLIS": {
+		Type: sig(timestampType, 1, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			n := args[0].(int64)
+			return time.Unix(n/1e3, n%1e3*1e6).UTC(), nil
+		},
+	},
+	"TIMESTAMP_SECONDS": {
+		Type: sig(timestampType, 1, int64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return time.Unix(args[0].(int64), 0).UTC(), nil
+		},
+	},
+	"TIMESTAMP_SUB": {
+		PartArg: 2,
+		Type:    sig(timestampType, 1, timestampType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return timestampAdd(args[0].(time.Time), args[1], -1)
+		},
+	},
+	"TIMESTAMP_TRUNC": {
+		PartArg: 2,
+		Type:    sig(timestampType, 1, timestampType, stringType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			loc, err := timeZoneArg(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			part, _ := args[1].(string)
+			t, err := truncTime(args[0].(time.Time).In(loc), part)
+			if err != nil {
+				return nil, err
+			}
+			return t.UTC(), nil
+		},
+	},
+	"UNIX_MICROS": {
+		Type: sig(int64Type, 1, timestampType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			t := args[0].(time.Time)
+			return t.Unix()*1e6 + int64(t.Nanosecond())/1e3, nil
+		},
+	},
+	"UNIX_MILLIS": {
+		Type: sig(int64Type, 1, timestampType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			t := args[0].(time.Time)
+			return t.Unix()*1e3 + int64(t.Nanosecond())/1e6, nil
+		},
+	},
+	"UNIX_SECONDS": {
+		Type: sig(int64Type, 1, timestampType),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return args[0].(time.Time).Unix(), nil
+		},
+	},
+}
+
+// powFunc implements POW and its synonym POWER.
+var powFunc = scalarFunc{
+	Type: sig(float64Type, 2, float64Type, float64Type),
+	Eval: func(args []interface{}) (interface{}, error) {
+		return math.Pow(asFloat(args[0]), asFloat(args[1])), nil
+	},
+}
+
+// mathFunc returns a scalarFunc for a function of one FLOAT64 argument.
+func mathFunc(f func(float64) float64) scalarFunc {
+	return scalarFunc{
+		Type: sig(float64Type, 1, float64Type),
+		Eval: func(args []interface{}) (interface{}, error) {
+			return f(asFloat(args[0])), nil
+		},
+	}
+}
+
+// sig returns a scalarFunc.Type implementation for a function returning ret,
+// whose arguments have the types in params. Only the first required arguments
+// must be present. An INT64 argument is accepted for a FLOAT64 parameter,
+// and a STRING argument for a DATE parameter.
+func sig(ret spansql.Type, required int, params ...spansql.Type) func([]spansql.Type) (spansql.Type, error) {
+	return func(args []spansql.Type) (spansql.Type, error) {
+		if len(args) < required || len(args) > len(params) {
+			if required == len(params) {
+				return spansql.Type{}, fmt.Errorf("takes %d arguments, not %d", required, len(args))
+			}
+			return spansql.Type{}, fmt.Errorf("takes %d to %d arguments, not %d", required, len(params), len(args))
+		}
+		for i, t := range args {
+			if !assignable(t, params[i]) {
+				return spansql.Type{}, fmt.Errorf("argument %d has type %s, want %s", i+1, t.SQL(), params[i].SQL())
+			}
+		}
+		return ret, nil
+	}
+}
+
+// stringSig is like sig, for functions that operate on either STRING or BYTES values.
+// If the first argument that is not NULL is BYTES, any STRING in the parameter
+// or result types is replaced by BYTES.
+func stringSig(ret spansql.Type, required int, params ...spansql.Type) func([]spansql.Type) (spansql.Type, error) {
+	return func(args []spansql.Type) (spansql.Type, error) {
+		first := nullType
+		for _, t := range args {
+			if t != nullType {
+				first = t
+				break
+			}
+		}
+		if first.Base != spansql.Bytes {
+			return sig(ret, required, params...)(args)
+		}
+		toBytes := func(t spansql.Type) spansql.Type {
+			if t.Base == spansql.String {
+				t.Base = spansql.Bytes
+			}
+			return t
+		}
+		bparams := make([]spansql.Type, len(params))
+		for i, t := range params {
+			bparams[i] = toBytes(t)
+		}
+		return sig(toBytes(ret), required, bparams...)(args)
+	}
+}
+
+// numericSig is a scalarFunc.Type implementation for a function of one
+// INT64 or FLOAT64 argument, returning a value of the same type.
+func numericSig(args []spansql.Type) (spansql.Type, error) {
+	if len(args) != 1 {
+		return spansql.Type{}, fmt.Errorf("takes 1 argument, not %d", len(args))
+	}
+	if args[0] == nullType {
+		return int64Type, nil
+	}
+	if !isNumeric(args[0]) {
+		return spansql.Type{}, fmt.Errorf("argument has type %s, want INT64 or FLOAT64", args[0].SQL())
+	}
+	return spansql.Type{Base: args[0].Base}, nil
+}
+
+// orderedSig is a scalarFunc.Type implementation for GREATEST and LEAST.
+func orderedSig(args []spansql.Type) (spansql.Type, error) {
+	if len(args) == 0 {
+		return spansql.Type{}, fmt.Errorf("requires at least one argument")
+	}
+	t, err := commonType(args)
+	if err != nil {
+		return spansql.Type{}, err
+	}
+	if t.Array {
+		return spansql.Type{}, fmt.Errorf("arguments of type %s are not ordered", t.SQL())
+	}
+	return t, nil
+}
+
+// checkArrayArg checks that a function has a single argument of array type,
+// and returns that type. A NULL argument is taken to be an ARRAY<INT64>.
+func checkArrayArg(args []spansql.Type) (spansql.Type, error) {
+	if len(args) != 1 {
+		return spansql.Type{}, fmt.Errorf("takes 1 argument, not %d", len(args))
+	}
+	if args[0] == nullType {
+		return spansql.Type{Array: true, Base: spansql.Int64}, nil
+	}
+	if !args[0].Array {
+		return spansql.Type{}, fmt.Errorf("argument has type %s, want an array", args[0].SQL())
+	}
+	return args[0], nil
+}
+
+// sameType reports whether two types are the same, ignoring any length.
+func sameType(a, b spansql.Type) bool {
+	return a.Array == b.Array && a.Base == b.Base
+}
+
+func isNumeric(t spansql.Type) bool {
+	return !t.Array && (t.Base == spansql.Int64 || t.Base == spansql.Float64)
+}
+
+// assignable reports whether a value of type t may be used where type want is expected.
+// A NULL literal may be used anywhere.
+func assignable(t, want spansql.Type) bool {
+	if t == nullType || sameType(t, want) {
+		return true
+	}
+	if t.Array || want.Array {
+		return false
+	}
+	return (t.Base == spansql.Int64 && want.Base == spansql.Float64) ||
+		(t.Base == spansql.String && want.Base == spansql.Date)
+}
+
+// commonType returns the type that values of all the given types may be converted to,
+// which is FLOAT64 for a mixture of INT64 and FLOAT64. NULL literals are
+// compatible with any type, and on their own have type INT64.
+func commonType(types []spansql.Type) (spansql.Type, error) {
+	t := nullType
+	for _, u := range types {
+		switch {
+		case u == nullType:
+		case t == nullType:
+			t = u
+			t.Len = 0
+		case sameType(t, u):
+		case isNumeric(t) && isNumeric(u):
+			t = float64Type
+		default:
+			return spansql.Type{}, fmt.Errorf("incompatible types %s and %s", t.SQL(), u.SQL())
+		}
+	}
+	if t == nullType {
+		return int64Type, nil
+	}
+	return t, nil
+}
+
+// promote converts an INT64 value, or the INT64 elements of an array,
+// to FLOAT64 if t is FLOAT64 or ARRAY<FLOAT64>. It is used where the type
+// of a value is the common type of several expressions.
+func promote(x interface{}, t spansql.Type) interface{} {
+	if t.Base != spansql.Float64 {
+		return x
+	}
+	if arr, ok := x.([]interface{}); ok && t.Array {
+		out := make([]interface{}, len(arr))
+		for i, elem := range arr {
+			out[i] = promote(elem, float64Type)
+		}
+		return out
+	}
+	if n, ok := x.(int64); ok {
+		return float64(n)
+	}
+	return x
+}
+
+// asFloat returns a FLOAT64 or INT64 value as a float64.
+func asFloat(x interface{}) float64 {
+	if n, ok := x.(int64); ok {
+		return float64(n)
+	}
+	return x.(float64)
+}
+
+// asString returns a STRING or BYTES value as a string.
+func asString(x interface{}) string {
+	if b, ok := x.([]byte); ok {
+		return string(b)
+	}
+	return x.(string)
+}
+
+// sameKind returns s as a STRING value if x is a STRING value,
+// or as a BYTES value if x is a BYTES value.
+func sameKind(x interface{}, s string) interface{} {
+	if _, ok := x.([]byte); ok {
+		return []byte(s)
+	}
+	return s
+}
+
+// changeCase implements LOWER (if upper is false) and UPPER.
+func changeCase(x interface{}, upper bool) interface{} {
+	if s, ok := x.(string); ok {
+		if upper {
+			return strings.ToUpper(s)
+		}
+		return strings.ToLower(s)
+	}
+	// Only ASCII characters are converted in BYTES values.
+	b := append([]byte(nil), x.([]byte)...)
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			b[i] = c - 'A' + 'a'
+		}
+	}
+	return b
+}
+
+// trim implements TRIM, LTRIM and RTRIM. Without a second argument,
+// leading and/or trailing whitespace is removed.
+func trim(args []interface{}, left, right bool) interface{} {
+	s := asString(args[0])
+	if len(args) == 1 {
+		if left {
+			s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		}
+		if right {
+			s = strings.TrimRightFunc(s, unicode.IsSpace)
+		}
+	} else {
+		cutset := asString(args[1])
+		if left {
+			s = strings.TrimLeft(s, cutset)
+		}
+		if right {
+			s = strings.TrimRight(s, cutset)
+		}
+	}
+	return sameKind(args[0], s)
+}
+
+// substrRange returns the half-open range of a sequence of n elements
+// that SUBSTR selects, given its position and optional length arguments.
+func substrRange(n int, args []interface{}) (int, int, error) {
+	// Positions count from 1, or from the end if negative.
+	// Position 0 is treated as 1.
+	pos := args[0].(int64)
+	var start int64
+	switch {
+	case pos > int64(n):
+		start = int64(n)
+	case pos > 0:
+		start = pos - 1
+	case pos < 0:
+		start = int64(n) + pos
+		if start < 0 {
+			start = 0
+		}
+	}
+	end := int64(n)
+	if len(args) > 1 {
+		length := args[1].(int64)
+		if length < 0 {
+			return 0, 0, fmt.Errorf("negative length %d", length)
+		}
+		if length < end-start {
+			end = start + length
+		}
+	}
+	return int(start), int(end), nil
+}
+
+// regexpReplacement converts the replacement argument of REGEXP_REPLACE,
+// which refers to capturing groups as \1 and so on, to the form used by package regexp.
+func regexpReplacement(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			buf.WriteString("$$")
+		case c == '\\' && i+1 < len(s) && '0' <= s[i+1] && s[i+1] <= '9':
+			fmt.Fprintf(&buf, "${%c}", s[i+1])
+			i++
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\\':
+			buf.WriteByte('\\')
+			i++
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// extremumOf implements GREATEST (if dir is 1) and LEAST (if dir is -1).
+// The arguments are not NULL.
+func extremumOf(args []interface{}, dir int) interface{} {
+	res := evalExtremum(args, dir)
+	for _, x := range args {
+		if _, ok := x.(float64); ok {
+			// A mixture of INT64 and FLOAT64 produces a FLOAT64.
+			return asFloat(res)
+		}
+	}
+	return res
+}
+
+func logarithm(x, base float64) (interface{}, error) {
+	if x <= 0 || base <= 0 || base == 1 {
+		return nil, fmt.Errorf("logarithm of %v to base %v is undefined", x, base)
+	}
+	if base == 10 {
+		return math.Log10(x), nil
+	}
+	return math.Log(x) / math.Log(base), nil
+}
+
+// roundTo implements ROUND or TRUNC using the given rounding function,
+// to a number of decimal places given by the optional second argument.
+func roundTo(args []interface{}, round func(float64) float64) float64 {
+	x := asFloat(args[0])
+	if len(args) < 2 {
+		return round(x)
+	}
+	p := math.Pow(10, float64(args[1].(int64)))
+	return round(x*p) / p
+}
+
+// defaultTimeZone is the time zone that Cloud Spanner uses when one isn't specified.
+const defaultTimeZone = "America/Los_Angeles"
+
+// tzOffsetRE matches a time zone given as an offset from UTC, such as "-08" or "+05:30".
+var tzOffsetRE = regexp.MustCompile(`^(?:UTC)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// loadLocation returns the named time zone, which may be a name from the
+// tz database, such as "America/Los_Angeles", or an offset from UTC.
+func loadLocation(tz string) (*time.Location, error) {
+	if m := tzOffsetRE.FindStringSubmatch(tz); m != nil {
+		h, _ := strconv.Atoi(m[2])
+		min, _ := strconv.Atoi(m[3]) // may be empty
+		off := (h*60 + min) * 60
+		if m[1] == "-" {
+			off = -off
+		}
+		return time.FixedZone(tz, off), nil
+	}
+	if tz == "" || tz == "Local" {
+		return nil, fmt.Errorf("invalid time zone %q", tz)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %v", tz, err)
+	}
+	return loc, nil
+}
+
+// timeZoneArg returns the time zone named by the optional function argument args[i],
+// or the default time zone if there is no such argument.
+func timeZoneArg(args []interface{}, i int) (*time.Location, error) {
+	if i < len(args) {
+		return loadLocation(args[i].(string))
+	}
+	return loadLocation(defaultTimeZone)
+}
+
+// timestampRE matches the string form of a TIMESTAMP value.
+// The time and the time zone are optional.
+var timestampRE = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})(?:[Tt ](\d{1,2}):(\d{1,2}):(\d{1,2})(?:\.(\d{1,9}))?)?(?: ?(\S+))?$`)
+
+// parseTimestamp parses the string form of a TIMESTAMP value,
+// such as "2019-11-20 13:14:15.5-08" or "2019-11-20T21:14:15.5Z".
+// If the string doesn't include a time zone, it is interpreted in loc.
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	m := timestampRE.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return time.Time{}, fmt.Errorf("bad TIMESTAMP string %q", s)
+	}
+	var f [6]int // year, month, day, hour, minute, second
+	for i := range f {
+		f[i], _ = strconv.Atoi(m[i+1]) // may be empty
+	}
+	var ns int
+	if m[7] != "" {
+		ns, _ = strconv.Atoi((m[7] + "00000000")[:9])
+	}
+	if tz := m[8]; tz == "Z" || tz == "z" {
+		loc = time.UTC
+	} else if tz != "" {
+		var err error
+		loc, err = loadLocation(tz)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad TIMESTAMP string %q: %v", s, err)
+		}
+	}
+	if !validDate(f[0], f[1], f[2]) || f[3] > 23 || f[4] > 59 || f[5] > 59 {
+		return time.Time{}, fmt.Errorf("bad TIMESTAMP string %q", s)
+	}
+	return time.Date(f[0], time.Month(f[1]), f[2], f[3], f[4], f[5], ns, loc).UTC(), nil
+}
+
+// timestampString returns the string form of a TIMESTAMP value, as produced by CAST.
+func timestampString(t time.Time) (string, error) {
+	loc, err := loadLocation(defaultTimeZone)
+	if err != nil {
+		return "", err
+	}
+	t = t.In(loc)
+	layout := "2006-01-02 15:04:05.999999999-07"
+	if _, off := t.Zone(); off%3600 != 0 {
+		layout += ":00"
+	}
+	return t.Format(layout), nil
+}
+
+// parseDate parses a DATE value, returning midnight UTC at the start of that day.
+func parseDate(s string) (time.Time, error) {
+	t, err := time.Parse("2006-1-2", strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad DATE string %q", s)
+	}
+	return t, nil
+}
+
+// formatDate returns the date of t as a DATE value.
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func validDate(y, m, d int) bool {
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	return t.Year() == y && int(t.Month()) == m && t.Day() == d
+}
+
+// timestampUnits are the date parts that can be used with TIMESTAMP_ADD and similar functions.
+var timestampUnits = map[string]time.Duration{
+	"NANOSECOND":  time.Nanosecond,
+	"MICROSECOND": time.Microsecond,
+	"MILLISECOND": time.Millisecond,
+	"SECOND":      time.Second,
+	"MINUTE":      time.Minute,
+	"HOUR":        time.Hour,
+	"DAY":         24 * time.Hour,
+}
+
+// timestampAdd implements TIMESTAMP_ADD (if sign is 1) and TIMESTAMP_SUB (if sign is -1).
+func timestampAdd(t time.Time, iv interface{}, sign int64) (interface{}, error) {
+	v, ok := iv.(interval)
+	if !ok {
+		return nil, fmt.Errorf("second argument must be an INTERVAL")
+	}
+	unit, ok := timestampUnits[v.part]
+	if !ok {
+		return nil, fmt.Errorf("unsupported date part %s", v.part)
+	}
+	return t.Add(time.Duration(sign*v.n) * unit), nil
+}
+
+// dateAdd implements DATE_ADD (if sign is 1) and DATE_SUB (if sign is -1).
+func dateAdd(s string, iv interface{}, sign int64) (interface{}, error) {
+	v, ok := iv.(interval)
+	if !ok {
+		return nil, fmt.Errorf("second argument must be an INTERVAL")
+	}
+	t, err := parseDate(s)
+	if err != nil {
+		return nil, err
+	}
+	n := int(sign * v.n)
+	switch v.part {
+	default:
+		return nil, fmt.Errorf("unsupported date part %s", v.part)
+	case "DAY":
+		t = t.AddDate(0, 0, n)
+	case "WEEK":
+		t = t.AddDate(0, 0, 7*n)
+	case "MONTH", "QUARTER", "YEAR":
+		switch v.part {
+		case "QUARTER":
+			n *= 3
+		case "YEAR":
+			n *= 12
+		}
+		// The day is clamped to the end of the resulting month.
+		y, m, d := t.Date()
+		first := time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+		if last := first.AddDate(0, 1, -1).Day(); d > last {
+			d = last
+		}
+		t = first.AddDate(0, 0, d-1)
+	}
+	return formatDate(t), nil
+}
+
+// truncTime truncates t to the start of the given date part, in t's time zone.
+func truncTime(t time.Time, part string) (time.Time, error) {
+	y, m, d := t.Date()
+	h, min, sec := t.Clock()
+	ns, loc := t.Nanosecond(), t.Location()
+	switch part {
+	case "NANOSECOND":
+		return t, nil
+	case "MICROSECOND":
+		return time.Date(y, m, d, h, min, sec, ns/1e3*1e3, loc), nil
+	case "MILLISECOND":
+		return time.Date(y, m, d, h, min, sec, ns/1e6*1e6, loc), nil
+	case "SECOND":
+		return time.Date(y, m, d, h, min, sec, 0, loc), nil
+	case "MINUTE":
+		return time.Date(y, m, d, h, min, 0, 0, loc), nil
+	case "HOUR":
+		return time.Date(y, m, d, h, 0, 0, 0, loc), nil
+	case "DAY":
+		return time.Date(y, m, d, 0, 0, 0, 0, loc), nil
+	case "WEEK": // weeks start on Sunday
+		return time.Date(y, m, d-int(t.Weekday()), 0, 0, 0, 0, loc), nil
+	case "ISOWEEK": // weeks start on Monday
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc), nil
+	case "MONTH":
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc), nil
+	case "QUARTER":
+		return time.Date(y, (m-1)/3*3+1, 1, 0, 0, 0, 0, loc), nil
+	case "YEAR":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc), nil
+	}
+	return time.Time{}, fmt.Errorf("unsupported date part %s", part)
+}
+
+// extractPart returns the value of a date part of t, for EXTRACT.
+// If isDate is set, t came from a DATE value and time parts may not be extracted.
+func extractPart(part string, t time.Time, isDate bool) (interface{}, error) {
+	if _, ok := timestampUnits[part]; ok && isDate && part != "DAY" {
+		return nil, fmt.Errorf("can't extract %s from a DATE", part)
+	}
+	switch part {
+	case "NANOSECOND":
+		return int64(t.Nanosecond()), nil
+	case "MICROSECOND":
+		return int64(t.Nanosecond() / 1e3), nil
+	case "MILLISECOND":
+		return int64(t.Nanosecond() / 1e6), nil
+	case "SECOND":
+		return int64(t.Second()), nil
+	case "MINUTE":
+		return int64(t.Minute()), nil
+	case "HOUR":
+		return int64(t.Hour()), nil
+	case "DAYOFWEEK": // 1 is Sunday
+		return int64(t.Weekday()) + 1, nil
+	case "DAY":
+		return int64(t.Day()), nil
+	case "DAYOFYEAR":
+		return int64(t.YearDay()), nil
+	case "WEEK": // weeks start on Sunday, and days before the first Sunday are in week 0
+		return int64(t.YearDay()-1-int(t.Weekday())+7) / 7, nil
+	case "ISOWEEK":
+		_, w := t.ISOWeek()
+		return int64(w), nil
+	case "MONTH":
+		return int64(t.Month()), nil
+	case "QUARTER":
+		return int64(t.Month()-1)/3 + 1, nil
+	case "YEAR":
+		return int64(t.Year()), nil
+	case "ISOYEAR":
+		y, _ := t.ISOWeek()
+		return int64(y), nil
+	case "DATE":
+		if !isDate {
+			return formatDate(t), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported date part %s", part)
+}
+
+// strftimeLayouts maps format elements of FORMAT_TIMESTAMP and FORMAT_DATE
+// to equivalent layouts for time.Format.
+var strftimeLayouts = map[byte]string{
+	'A': "Monday",
+	'a': "Mon",
+	'B': "January",
+	'b': "Jan",
+	'c': "Mon Jan _2 15:04:05 2006",
+	'D': "01/02/06",
+	'd': "02",
+	'e': "_2",
+	'F': "2006-01-02",
+	'H': "15",
+	'h': "Jan",
+	'I': "03",
+	'M': "04",
+	'm': "01",
+	'n': "\n",
+	'p': "PM",
+	'R': "15:04",
+	'S': "05",
+	'T': "15:04:05",
+	't': "\t",
+	'X': "15:04:05",
+	'x': "01/02/06",
+	'Y': "2006",
+	'y': "06",
+	'Z': "MST",
+	'z': "-0700",
+	'%': "%",
+}
+
+// formatTime formats t according to a format string as used by FORMAT_TIMESTAMP
+// and FORMAT_DATE, which resembles that of the C strftime function.
+// https://cloud.google.com/spanner/docs/functions-and-operators#supported_format_elements_for_timestamp
+func formatTime(format string, t time.Time) (string, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			buf.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("format string %q ends with %%", format)
+		}
+		if layout, ok := strftimeLayouts[format[i]]; ok {
+			buf.WriteString(t.Format(layout))
+			continue
+		}
+		switch c := format[i]; c {
+		default:
+			return "", fmt.Errorf("unsupported format element %%%c", c)
+		case 'C':
+			fmt.Fprintf(&buf, "%02d", t.Year()/100)
+		case 'G':
+			y, _ := t.ISOWeek()
+			fmt.Fprintf(&buf, "%d", y)
+		case 'j':
+			fmt.Fprintf(&buf, "%03d", t.YearDay())
+		case 'k':
+			fmt.Fprintf(&buf, "%2d", t.Hour())
+		case 'l':
+			fmt.Fprintf(&buf, "%2d", (t.Hour()+11)%12+1)
+		case 'P':
+			buf.WriteString(strings.ToLower(t.Format("PM")))
+		case 'Q':
+			fmt.Fprintf(&buf, "%d", (t.Month()-1)/3+1)
+		case 's':
+			fmt.Fprintf(&buf, "%d", t.Unix())
+		case 'U':
+			fmt.Fprintf(&buf, "%02d", (t.YearDay()-1-int(t.Weekday())+7)/7)
+		case 'u':
+			fmt.Fprintf(&buf, "%d", (int(t.Weekday())+6)%7+1)
+		case 'V':
+			_, w := t.ISOWeek()
+			fmt.Fprintf(&buf, "%02d", w)
+		case 'w':
+			fmt.Fprintf(&buf, "%d", t.Weekday())
+		case 'E':
+			// %E#S and %E*S are seconds with # or all fractional digits,
+			// %Ez is a numeric time zone with a colon,
+			// and %E4Y is a four digit year.
+			rest := format[i+1:]
+			switch {
+			case strings.HasPrefix(rest, "*S"):
+				buf.WriteString(t.Format("05.999999999"))
+				i += 2
+			case len(rest) >= 2 && '0' <= rest[0] && rest[0] <= '9' && rest[1] == 'S':
+				layout := "05"
+				if n := int(rest[0] - '0'); n > 0 {
+					layout += "." + strings.Repeat("0", n)
+				}
+				buf.WriteString(t.Format(layout))
+				i += 2
+			case strings.HasPrefix(rest, "z"):
+				buf.WriteString(t.Format("-07:00"))
+				i++
+			case strings.HasPrefix(rest, "4Y"):
+				fmt.Fprintf(&buf, "%04d", t.Year())
+				i += 2
+			default:
+				return "", fmt.Errorf("unsupported format element in %q", format)
+			}
+		}
+	}
+	return buf.String(), nil
+}
+
+// castable lists the types that CAST can convert values of each type to.
+// https://cloud.google.com/spanner/docs/functions-and-operators#casting
+var castable = map[spansql.TypeBase][]spansql.TypeBase{
+	spansql.Bool:      {spansql.Bool, spansql.Int64, spansql.String},
+	spansql.Int64:     {spansql.Bool, spansql.Int64, spansql.Float64, spansql.String},
+	spansql.Float64:   {spansql.Int64, spansql.Float64, spansql.String},
+	spansql.String:    {spansql.Bool, spansql.Int64, spansql.Float64, spansql.String, spansql.Bytes, spansql.Date, spansql.Timestamp},
+	spansql.Bytes:     {spansql.String, spansql.Bytes},
+	spansql.Date:      {spansql.String, spansql.Date, spansql.Timestamp},
+	spansql.Timestamp: {spansql.String, spansql.Date, spansql.Timestamp},
+}
+
+// canCast reports whether CAST can convert values of type from to type to.
+func canCast(from, to spansql.Type) bool {
+	if from.Array || to.Array {
+		// Arrays may only be cast to their own type.
+		return sameType(from, to)
+	}
+	for _, b := range castable[from.Base] {
+		if b == to.Base {
+			return true
+		}
+	}
+	return false
+}
+
+// castValue converts a value to the given type, as CAST does.
+// It returns an error if the value can't be converted.
+func castValue(x interface{}, t spansql.Type) (interface{}, error) {
+	if x == nil || t.Array {
+		return x, nil
+	}
+	switch t.Base {
+	case spansql.Bool:
+		switch x := x.(type) {
+		case bool:
+			return x, nil
+		case int64:
+			return x != 0, nil
+		case string:
+			switch strings.ToLower(x) {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+			return nil, fmt.Errorf("bad BOOL string %q", x)
+		}
+	case spansql.Int64:
+		switch x := x.(type) {
+		case bool:
+			if x {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case int64:
+			return x, nil
+		case float64:
+			// Halfway cases are rounded away from zero.
+			r := math.Round(x)
+			if math.IsNaN(r) || r < math.MinInt64 || r >= math.MaxInt64 {
+				return nil, fmt.Errorf("FLOAT64 value %v out of range for INT64", x)
+			}
+			return int64(r), nil
+		case string:
+			return parseInt64(x)
+		}
+	case spansql.Float64:
+		switch x := x.(type) {
+		case int64:
+			return float64(x), nil
+		case float64:
+			return x, nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad FLOAT64 string %q", x)
+			}
+			return f, nil
+		}
+	case spansql.String:
+		switch x := x.(type) {
+		case bool:
+			return strconv.FormatBool(x), nil
+		case int64:
+			return strconv.FormatInt(x, 10), nil
+		case float64:
+			switch {
+			case math.IsInf(x, 1):
+				return "inf", nil
+			case math.IsInf(x, -1):
+				return "-inf", nil
+			case math.IsNaN(x):
+				return "nan", nil
+			}
+			return strconv.FormatFloat(x, 'g', -1, 64), nil
+		case string:
+			return x, nil
+		case []byte:
+			if !utf8.Valid(x) {
+				return nil, fmt.Errorf("BYTES value is not valid UTF-8")
+			}
+			return string(x), nil
+		case time.Time:
+			return timestampString(x)
+		}
+	case spansql.Bytes:
+		switch x := x.(type) {
+		case string:
+			return []byte(x), nil
+		case []byte:
+			return x, nil
+		}
+	case spansql.Date:
+		switch x := x.(type) {
+		case string:
+			t, err := parseDate(x)
+			if err != nil {
+				return nil, err
+			}
+			return formatDate(t), nil
+		case time.Time:
+			loc, err := loadLocation(defaultTimeZone)
+			if err != nil {
+				return nil, err
+			}
+			return formatDate(x.In(loc)), nil
+		}
+	case spansql.Timestamp:
+		switch x := x.(type) {
+		case string:
+			// This also handles DATE values.
+			loc, err := loadLocation(defaultTimeZone)
+			if err != nil {
+				return nil, err
+			}
+			return parseTimestamp(x, loc)
+		case time.Time:
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("can't cast %T to %s", x, t.SQL())
+}
+
+// parseInt64 parses the string form of an INT64 value, which may be decimal or hexadecimal.
+func parseInt64(s string) (int64, error) {
+	t := strings.TrimSpace(s)
+	sign := ""
+	if t != "" && (t[0] == '+' || t[0] == '-') {
+		sign, t = t[:1], t[1:]
+	}
+	base := 10
+	if strings.HasPrefix(t, "0x") || strings.HasPrefix(t, "0X") {
+		base, t = 16, t[2:]
+	}
+	n, err := strconv.ParseInt(sign+t, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad INT64 string %q", s)
+	}
+	return n, nil
+}
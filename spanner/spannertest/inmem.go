@@ -52,6 +52,7 @@ import (
 	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -139,21 +140,6 @@ func timestampProto(t time.Time) *timestamppb.Timestamp {
 	return ts
 }
 
-type transaction struct {
-	// TODO: connect this with db.go.
-}
-
-func (t *transaction) Commit() error {
-	return nil
-}
-
-func (t *transaction) Rollback() error {
-	return nil
-}
-
-func (t *transaction) finish() {
-}
-
 // lro represents a Long-Running Operation, generally a schema change.
 type lro struct {
 	mu    sync.Mutex
@@ -396,7 +382,8 @@ func (s *server) popTx(sessionID, tid string) (tx *transaction, cleanup func(),
 		// TODO: what error does the real Spanner return?
 		return nil, nil, status.Errorf(codes.NotFound, "unknown transaction ID %q", tid)
 	}
-	return tx, tx.finish, nil
+	tx.mu.Lock()
+	return tx, tx.mu.Unlock, nil
 }
 
 // readTx returns a transaction for the given session and transaction selector.
@@ -415,41 +402,131 @@ func (s *server) readTx(ctx context.Context, session string, tsel *spannerpb.Tra
 	sess.mu.Unlock()
 
 	singleUse := func() (*transaction, func(), error) {
-		tx := &transaction{}
-		return tx, tx.finish, nil
+		tx := s.db.NewTransaction()
+		return tx, tx.Rollback, nil
 	}
-	singleUseReadOnly := func() (*transaction, func(), error) {
-		// TODO: figure out a way to make this read-only.
-		return singleUse()
+	singleUseReadOnly := func(ro *spannerpb.TransactionOptions_ReadOnly) (*transaction, func(), error) {
+		ts, err := readTimestamp(ro)
+		if err != nil {
+			return nil, nil, err
+		}
+		tx, err := s.db.NewReadOnlyTransaction(ts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tx, tx.Rollback, nil
 	}
 
 	if tsel.GetSelector() == nil {
-		return singleUseReadOnly()
+		return singleUseReadOnly(nil)
 	}
 
 	switch sel := tsel.Selector.(type) {
 	default:
 		return nil, nil, fmt.Errorf("TransactionSelector type %T not supported", sel)
 	case *spannerpb.TransactionSelector_SingleUse:
-		// Ignore options (e.g. timestamps).
 		switch mode := sel.SingleUse.Mode.(type) {
 		case *spannerpb.TransactionOptions_ReadOnly_:
-			return singleUseReadOnly()
+			return singleUseReadOnly(mode.ReadOnly)
 		case *spannerpb.TransactionOptions_ReadWrite_:
 			return singleUse()
 		default:
 			return nil, nil, fmt.Errorf("single use transaction in mode %T not supported", mode)
 		}
 	case *spannerpb.TransactionSelector_Id:
-		id := sel.Id // []byte
-		_ = id       // TODO: lookup an existing transaction by ID.
-		tx := &transaction{}
-		return tx, tx.finish, nil
+		sess.mu.Lock()
+		tx, ok := sess.transactions[string(sel.Id)]
+		sess.mu.Unlock()
+		if !ok {
+			// TODO: what error does the real Spanner return?
+			return nil, nil, status.Errorf(codes.NotFound, "unknown transaction ID %q", sel.Id)
+		}
+		// The transaction lives on until it is committed or rolled back.
+		tx.mu.Lock()
+		return tx, tx.mu.Unlock, nil
+	}
+}
+
+// readTimestamp returns the timestamp at which a read-only transaction
+// with the given options should read. A zero time means a strong read.
+func readTimestamp(ro *spannerpb.TransactionOptions_ReadOnly) (time.Time, error) {
+	switch tb := ro.GetTimestampBound().(type) {
+	default:
+		return time.Time{}, fmt.Errorf("timestamp bound type %T not supported", tb)
+	case nil, *spannerpb.TransactionOptions_ReadOnly_Strong:
+		return time.Time{}, nil
+	case *spannerpb.TransactionOptions_ReadOnly_MinReadTimestamp, *spannerpb.TransactionOptions_ReadOnly_MaxStaleness:
+		// Bounded staleness permits any sufficiently recent timestamp,
+		// so reading the latest data always satisfies it.
+		return time.Time{}, nil
+	case *spannerpb.TransactionOptions_ReadOnly_ReadTimestamp:
+		ts, err := ptypes.Timestamp(tb.ReadTimestamp)
+		if err != nil {
+			return time.Time{}, status.Errorf(codes.InvalidArgument, "bad read timestamp: %v", err)
+		}
+		return ts, nil
+	case *spannerpb.TransactionOptions_ReadOnly_ExactStaleness:
+		d, err := ptypes.Duration(tb.ExactStaleness)
+		if err != nil || d < 0 {
+			return time.Time{}, status.Errorf(codes.InvalidArgument, "bad exact staleness %v", tb.ExactStaleness)
+		}
+		return time.Now().Add(-d), nil
 	}
 }
 
+// isDML reports whether the given SQL looks like a DML statement,
+// as opposed to a query. Keywords are case insensitive.
+func isDML(sql string) bool {
+	f := strings.Fields(sql)
+	if len(f) == 0 {
+		return false
+	}
+	switch strings.ToUpper(f[0]) {
+	case "INSERT", "UPDATE", "DELETE":
+		return true
+	}
+	return false
+}
+
 func (s *server) ExecuteSql(ctx context.Context, req *spannerpb.ExecuteSqlRequest) (*spannerpb.ResultSet, error) {
-	return nil, status.Errorf(codes.Unimplemented, "ExecuteSql not implemented yet")
+	tx, cleanup, err := s.readTx(ctx, req.Session, req.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if isDML(req.Sql) {
+		n, err := s.executeDML(tx, req.Sql, req.GetParams(), req.GetParamTypes())
+		if err != nil {
+			return nil, err
+		}
+		return &spannerpb.ResultSet{
+			Metadata: &spannerpb.ResultSetMetadata{RowType: &spannerpb.StructType{}},
+			Stats:    dmlStats(n),
+		}, nil
+	}
+
+	ri, err := s.executeQuery(tx, req)
+	if err != nil {
+		return nil, err
+	}
+	rs := &spannerpb.ResultSet{}
+	rs.Metadata, err = resultSetMetadata(tx, ri)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		row, ok := ri.Next()
+		if !ok {
+			break
+		}
+		values, err := rowValues(row)
+		if err != nil {
+			return nil, err
+		}
+		rs.Rows = append(rs.Rows, &structpb.ListValue{Values: values})
+	}
+	return rs, nil
 }
 
 func (s *server) ExecuteStreamingSql(req *spannerpb.ExecuteSqlRequest, stream spannerpb.Spanner_ExecuteStreamingSqlServer) error {
@@ -459,37 +536,138 @@ func (s *server) ExecuteStreamingSql(req *spannerpb.ExecuteSqlRequest, stream sp
 	}
 	defer cleanup()
 
+	if isDML(req.Sql) {
+		n, err := s.executeDML(tx, req.Sql, req.GetParams(), req.GetParamTypes())
+		if err != nil {
+			return err
+		}
+		return stream.Send(&spannerpb.PartialResultSet{
+			Metadata: &spannerpb.ResultSetMetadata{RowType: &spannerpb.StructType{}},
+			Stats:    dmlStats(n),
+		})
+	}
+
+	ri, err := s.executeQuery(tx, req)
+	if err != nil {
+		return err
+	}
+	return s.readStream(stream.Context(), tx, stream.Send, ri)
+}
+
+func (s *server) executeQuery(tx *transaction, req *spannerpb.ExecuteSqlRequest) (*resultIter, error) {
 	q, err := spansql.ParseQuery(req.Sql)
 	if err != nil {
 		// TODO: check what code the real Spanner returns here.
-		return status.Errorf(codes.InvalidArgument, "bad query: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "bad query: %v", err)
+	}
+
+	params, err := parseQueryParams(req.GetParams(), req.GetParamTypes())
+	if err != nil {
+		return nil, err
+	}
+
+	s.logf("Querying: %s", q.SQL())
+	if len(params) > 0 {
+		s.logf("        ▹ %v", params)
+	}
+
+	return s.db.Query(tx, q, params)
+}
+
+// executeDML runs a single DML statement within the given transaction,
+// returning the number of affected rows.
+func (s *server) executeDML(tx *transaction, sql string, p *structpb.Struct, types map[string]*spannerpb.Type) (int, error) {
+	if tx.readOnly {
+		return 0, status.Errorf(codes.FailedPrecondition, "DML statements can only be performed in a read-write transaction")
+	}
+
+	stmt, err := spansql.ParseDMLStmt(sql)
+	if err != nil {
+		// TODO: check what code the real Spanner returns here.
+		return 0, status.Errorf(codes.InvalidArgument, "bad DML statement: %v", err)
+	}
+
+	params, err := parseQueryParams(p, types)
+	if err != nil {
+		return 0, err
+	}
+
+	s.logf("Executing: %s", stmt.SQL())
+	if len(params) > 0 {
+		s.logf("        ▹ %v", params)
+	}
+
+	return s.db.Execute(tx, stmt, params)
+}
+
+func (s *server) ExecuteBatchDml(ctx context.Context, req *spannerpb.ExecuteBatchDmlRequest) (*spannerpb.ExecuteBatchDmlResponse, error) {
+	if len(req.Statements) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "no statements in batch DML")
+	}
+
+	tx, cleanup, err := s.readTx(ctx, req.Session, req.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	resp := &spannerpb.ExecuteBatchDmlResponse{}
+	for _, stmt := range req.Statements {
+		n, err := s.executeDML(tx, stmt.Sql, stmt.Params, stmt.ParamTypes)
+		if err != nil {
+			// Execution stops at the first failed statement. The RPC itself
+			// succeeds, with the failure reported in the response status.
+			resp.Status = status.Convert(err).Proto()
+			return resp, nil
+		}
+		rs := &spannerpb.ResultSet{Stats: dmlStats(n)}
+		if len(resp.ResultSets) == 0 {
+			rs.Metadata = &spannerpb.ResultSetMetadata{RowType: &spannerpb.StructType{}}
+		}
+		resp.ResultSets = append(resp.ResultSets, rs)
 	}
+	resp.Status = status.New(codes.OK, "").Proto()
+	return resp, nil
+}
 
+// dmlStats returns the result set statistics for a DML statement that affected n rows.
+func dmlStats(n int) *spannerpb.ResultSetStats {
+	return &spannerpb.ResultSetStats{
+		RowCount: &spannerpb.ResultSetStats_RowCountExact{int64(n)},
+	}
+}
+
+// parseQueryParams converts the parameters of a query or DML statement
+// into their internal representation, using the parameter types if provided.
+func parseQueryParams(p *structpb.Struct, types map[string]*spannerpb.Type) (queryParams, error) {
 	params := make(queryParams)
-	for k, v := range req.GetParams().GetFields() {
+	for k, v := range p.GetFields() {
+		if pt, ok := types[k]; ok {
+			if t, err := typeFromSpannerType(pt); err == nil {
+				x, err := valForType(v, t)
+				if err != nil {
+					return nil, status.Errorf(codes.InvalidArgument, "bad value for param @%s: %v", k, err)
+				}
+				params[k] = x
+				continue
+			}
+			// Fall back to using the value without its type.
+		}
+
 		switch v := v.Kind.(type) {
 		default:
-			return fmt.Errorf("unsupported well-known type value kind %T", v)
+			return nil, fmt.Errorf("unsupported well-known type value kind %T", v)
 		case *structpb.Value_NullValue:
 			params[k] = nil
+		case *structpb.Value_BoolValue:
+			params[k] = v.BoolValue
 		case *structpb.Value_NumberValue:
 			params[k] = v.NumberValue
 		case *structpb.Value_StringValue:
 			params[k] = v.StringValue
 		}
-
 	}
-
-	s.logf("Querying: %s", q.SQL())
-	if len(params) > 0 {
-		s.logf("        ▹ %v", params)
-	}
-
-	ri, err := s.db.Query(q, params)
-	if err != nil {
-		return err
-	}
-	return s.readStream(stream.Context(), tx, stream.Send, ri)
+	return params, nil
 }
 
 // TODO: Read
@@ -502,9 +680,6 @@ func (s *server) StreamingRead(req *spannerpb.ReadRequest, stream spannerpb.Span
 	defer cleanup()
 
 	// Bail out if various advanced features are being used.
-	if req.Index != "" {
-		return fmt.Errorf("index reads (%q) not supported", req.Index)
-	}
 	if len(req.ResumeToken) > 0 {
 		// This should only happen if we send resume_token ourselves.
 		return fmt.Errorf("read resumption not supported")
@@ -513,18 +688,19 @@ func (s *server) StreamingRead(req *spannerpb.ReadRequest, stream spannerpb.Span
 		return fmt.Errorf("partition restrictions not supported")
 	}
 
-	// TODO: other KeySet types.
-	if len(req.KeySet.Ranges) > 0 {
-		return fmt.Errorf("reading with ranges not supported")
-	}
-
 	var ri *resultIter
-	if req.KeySet.All {
+	if req.Index != "" {
+		s.logf("Reading from %s through index %s (cols: %v)", req.Table, req.Index, req.Columns)
+		ri, err = s.db.ReadIndex(tx, req.Table, req.Index, req.Columns, req.KeySet.Keys, makeKeyRangeList(req.KeySet.Ranges), req.KeySet.All, req.Limit)
+	} else if len(req.KeySet.Ranges) > 0 {
+		// TODO: other KeySet types.
+		return fmt.Errorf("reading with ranges not supported")
+	} else if req.KeySet.All {
 		s.logf("Reading all from %s (cols: %v)", req.Table, req.Columns)
-		ri, err = s.db.ReadAll(req.Table, req.Columns, req.Limit)
+		ri, err = s.db.ReadAll(tx, req.Table, req.Columns, req.Limit)
 	} else {
 		s.logf("Reading %d rows from from %s (cols: %v)", len(req.KeySet.Keys), req.Table, req.Columns)
-		ri, err = s.db.Read(req.Table, req.Columns, req.KeySet.Keys, req.Limit)
+		ri, err = s.db.Read(tx, req.Table, req.Columns, req.KeySet.Keys, req.Limit)
 	}
 	if err != nil {
 		return err
@@ -538,20 +714,9 @@ func (s *server) StreamingRead(req *spannerpb.ReadRequest, stream spannerpb.Span
 }
 
 func (s *server) readStream(ctx context.Context, tx *transaction, send func(*spannerpb.PartialResultSet) error, ri *resultIter) error {
-	// Build the result set metadata.
-	rsm := &spannerpb.ResultSetMetadata{
-		RowType: &spannerpb.StructType{},
-		// TODO: transaction info?
-	}
-	for _, ci := range ri.Cols {
-		st, err := spannerTypeFromType(ci.Type)
-		if err != nil {
-			return err
-		}
-		rsm.RowType.Fields = append(rsm.RowType.Fields, &spannerpb.StructType_Field{
-			Name: ci.Name,
-			Type: st,
-		})
+	rsm, err := resultSetMetadata(tx, ri)
+	if err != nil {
+		return err
 	}
 
 	for {
@@ -560,13 +725,9 @@ func (s *server) readStream(ctx context.Context, tx *transaction, send func(*spa
 			break
 		}
 
-		values := make([]*structpb.Value, len(row))
-		for i, x := range row {
-			v, err := spannerValueFromValue(x)
-			if err != nil {
-				return err
-			}
-			values[i] = v
+		values, err := rowValues(row)
+		if err != nil {
+			return err
 		}
 
 		prs := &spannerpb.PartialResultSet{
@@ -580,10 +741,52 @@ func (s *server) readStream(ctx context.Context, tx *transaction, send func(*spa
 		// ResultSetMetadata is only set for the first PartialResultSet.
 		rsm = nil
 	}
+	if rsm != nil {
+		// There were no rows, but the metadata must still be sent.
+		return send(&spannerpb.PartialResultSet{Metadata: rsm})
+	}
 
 	return nil
 }
 
+// resultSetMetadata builds the result set metadata for the results of a read or query.
+func resultSetMetadata(tx *transaction, ri *resultIter) (*spannerpb.ResultSetMetadata, error) {
+	rsm := &spannerpb.ResultSetMetadata{
+		RowType: &spannerpb.StructType{},
+	}
+	if tx.readOnly {
+		// Report the read timestamp, which is how a single-use
+		// read-only transaction learns it.
+		rsm.Transaction = &spannerpb.Transaction{
+			ReadTimestamp: timestampProto(tx.ReadTimestamp()),
+		}
+	}
+	for _, ci := range ri.Cols {
+		st, err := spannerTypeFromType(ci.Type)
+		if err != nil {
+			return nil, err
+		}
+		rsm.RowType.Fields = append(rsm.RowType.Fields, &spannerpb.StructType_Field{
+			Name: ci.Name,
+			Type: st,
+		})
+	}
+	return rsm, nil
+}
+
+// rowValues converts a row of a read or query result to its protocol representation.
+func rowValues(row []interface{}) ([]*structpb.Value, error) {
+	values := make([]*structpb.Value, len(row))
+	for i, x := range row {
+		v, err := spannerValueFromValue(x)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
 func (s *server) BeginTransaction(ctx context.Context, req *spannerpb.BeginTransactionRequest) (*spannerpb.Transaction, error) {
 	//s.logf("BeginTransaction(%v)", req)
 
@@ -595,31 +798,58 @@ func (s *server) BeginTransaction(ctx context.Context, req *spannerpb.BeginTrans
 		return nil, status.Errorf(codes.NotFound, "unknown session %q", req.Session)
 	}
 
-	id := genRandomTransaction()
-	tx := &transaction{}
+	var tx *transaction
+	switch mode := req.GetOptions().GetMode().(type) {
+	default:
+		return nil, fmt.Errorf("transaction mode %T not supported", mode)
+	case *spannerpb.TransactionOptions_ReadWrite_:
+		tx = s.db.NewTransaction()
+	case *spannerpb.TransactionOptions_PartitionedDml_:
+		tx = s.db.NewPartitionedDMLTransaction()
+	case *spannerpb.TransactionOptions_ReadOnly_:
+		ts, err := readTimestamp(mode.ReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		tx, err = s.db.NewReadOnlyTransaction(ts)
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	id := genRandomTransaction()
 	sess.mu.Lock()
 	sess.lastUse = time.Now()
 	sess.transactions[id] = tx
 	sess.mu.Unlock()
 
-	return &spannerpb.Transaction{Id: []byte(id)}, nil
+	return &spannerpb.Transaction{
+		Id:            []byte(id),
+		ReadTimestamp: timestampProto(tx.ReadTimestamp()),
+	}, nil
 }
 
 func (s *server) Commit(ctx context.Context, req *spannerpb.CommitRequest) (*spannerpb.CommitResponse, error) {
 	//s.logf("Commit(%q, %q)", req.Session, req.Transaction)
 
-	obj, ok := req.Transaction.(*spannerpb.CommitRequest_TransactionId)
-	if !ok {
+	var tx *transaction
+	switch obj := req.Transaction.(type) {
+	default:
 		return nil, fmt.Errorf("unsupported transaction type %T", req.Transaction)
+	case *spannerpb.CommitRequest_TransactionId:
+		var cleanup func()
+		var err error
+		tx, cleanup, err = s.popTx(req.Session, string(obj.TransactionId))
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+	case *spannerpb.CommitRequest_SingleUseTransaction:
+		if obj.SingleUseTransaction.GetReadWrite() == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "single use transactions for commit must be read-write")
+		}
+		tx = s.db.NewTransaction()
 	}
-	tid := string(obj.TransactionId)
-
-	tx, cleanup, err := s.popTx(req.Session, tid)
-	if err != nil {
-		return nil, err
-	}
-	defer cleanup()
 
 	for _, m := range req.Mutations {
 		switch op := m.Operation.(type) {
@@ -627,19 +857,25 @@ func (s *server) Commit(ctx context.Context, req *spannerpb.CommitRequest) (*spa
 			return nil, fmt.Errorf("unsupported mutation operation type %T", op)
 		case *spannerpb.Mutation_Insert:
 			ins := op.Insert
-			err := s.db.Insert(ins.Table, ins.Columns, ins.Values)
+			err := s.db.Insert(tx, ins.Table, ins.Columns, ins.Values)
 			if err != nil {
 				return nil, err
 			}
 		case *spannerpb.Mutation_Update:
 			up := op.Update
-			err := s.db.Update(up.Table, up.Columns, up.Values)
+			err := s.db.Update(tx, up.Table, up.Columns, up.Values)
 			if err != nil {
 				return nil, err
 			}
 		case *spannerpb.Mutation_InsertOrUpdate:
 			iou := op.InsertOrUpdate
-			err := s.db.InsertOrUpdate(iou.Table, iou.Columns, iou.Values)
+			err := s.db.InsertOrUpdate(tx, iou.Table, iou.Columns, iou.Values)
+			if err != nil {
+				return nil, err
+			}
+		case *spannerpb.Mutation_Replace:
+			rep := op.Replace
+			err := s.db.Replace(tx, rep.Table, rep.Columns, rep.Values)
 			if err != nil {
 				return nil, err
 			}
@@ -647,7 +883,7 @@ func (s *server) Commit(ctx context.Context, req *spannerpb.CommitRequest) (*spa
 			del := op.Delete
 			ks := del.KeySet
 
-			err := s.db.Delete(del.Table, ks.Keys, makeKeyRangeList(ks.Ranges), ks.All)
+			err := s.db.Delete(tx, del.Table, ks.Keys, makeKeyRangeList(ks.Ranges), ks.All)
 			if err != nil {
 				return nil, err
 			}
@@ -655,12 +891,14 @@ func (s *server) Commit(ctx context.Context, req *spannerpb.CommitRequest) (*spa
 
 	}
 
-	if err := tx.Commit(); err != nil {
+	ts, err := tx.Commit()
+	if err != nil {
 		return nil, err
 	}
 
-	// TODO: return timestamp?
-	return &spannerpb.CommitResponse{}, nil
+	return &spannerpb.CommitResponse{
+		CommitTimestamp: timestampProto(ts),
+	}, nil
 }
 
 func (s *server) Rollback(ctx context.Context, req *spannerpb.RollbackRequest) (*emptypb.Empty, error) {
@@ -672,9 +910,7 @@ func (s *server) Rollback(ctx context.Context, req *spannerpb.RollbackRequest) (
 	}
 	defer cleanup()
 
-	if err := tx.Rollback(); err != nil {
-		return nil, err
-	}
+	tx.Rollback()
 
 	return &emptypb.Empty{}, nil
 }
@@ -698,6 +934,8 @@ func spannerTypeFromType(typ spansql.Type) (*spannerpb.Type, error) {
 		code = spannerpb.TypeCode_BYTES
 	case spansql.Date:
 		code = spannerpb.TypeCode_DATE
+	case spansql.Timestamp:
+		code = spannerpb.TypeCode_TIMESTAMP
 	}
 	st := &spannerpb.Type{Code: code}
 	if typ.Array {
@@ -709,6 +947,37 @@ func spannerTypeFromType(typ spansql.Type) (*spannerpb.Type, error) {
 	return st, nil
 }
 
+func typeFromSpannerType(st *spannerpb.Type) (spansql.Type, error) {
+	switch st.Code {
+	default:
+		return spansql.Type{}, fmt.Errorf("unhandled spanner type code %v", st.Code)
+	case spannerpb.TypeCode_BOOL:
+		return spansql.Type{Base: spansql.Bool}, nil
+	case spannerpb.TypeCode_INT64:
+		return spansql.Type{Base: spansql.Int64}, nil
+	case spannerpb.TypeCode_FLOAT64:
+		return spansql.Type{Base: spansql.Float64}, nil
+	case spannerpb.TypeCode_STRING:
+		return spansql.Type{Base: spansql.String, Len: spansql.MaxLen}, nil
+	case spannerpb.TypeCode_BYTES:
+		return spansql.Type{Base: spansql.Bytes, Len: spansql.MaxLen}, nil
+	case spannerpb.TypeCode_DATE:
+		return spansql.Type{Base: spansql.Date}, nil
+	case spannerpb.TypeCode_TIMESTAMP:
+		return spansql.Type{Base: spansql.Timestamp}, nil
+	case spannerpb.TypeCode_ARRAY:
+		typ, err := typeFromSpannerType(st.ArrayElementType)
+		if err != nil {
+			return spansql.Type{}, err
+		}
+		if typ.Array {
+			return spansql.Type{}, fmt.Errorf("nested arrays are not supported")
+		}
+		typ.Array = true
+		return typ, nil
+	}
+}
+
 func spannerValueFromValue(x interface{}) (*structpb.Value, error) {
 	switch x := x.(type) {
 	default:
@@ -725,6 +994,8 @@ func spannerValueFromValue(x interface{}) (*structpb.Value, error) {
 		return &structpb.Value{Kind: &structpb.Value_StringValue{x}}, nil
 	case []byte:
 		return &structpb.Value{Kind: &structpb.Value_StringValue{base64.StdEncoding.EncodeToString(x)}}, nil
+	case time.Time:
+		return &structpb.Value{Kind: &structpb.Value_StringValue{x.UTC().Format(time.RFC3339Nano)}}, nil
 	case nil:
 		return &structpb.Value{Kind: &structpb.Value_NullValue{}}, nil
 	case []interface{}:
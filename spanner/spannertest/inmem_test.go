@@ -0,0 +1,39 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spannertest
+
+import "testing"
+
+func TestIsDML(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"INSERT INTO T (A) VALUES (1)", true},
+		{"insert into T (A) VALUES (1)", true},
+		{"  Update T SET A = 2 WHERE TRUE", true},
+		{"delete FROM T WHERE TRUE", true},
+		{"SELECT * FROM T", false},
+		{"select * FROM T", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isDML(test.sql); got != test.want {
+			t.Errorf("isDML(%q) = %t, want %t", test.sql, got, test.want)
+		}
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"context"
 	"flag"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -236,6 +237,24 @@ func TestIntegration_SpannerBasics(t *testing.T) {
 		t.Errorf("Age sum after iterating over all rows = %d, want %d", ageSum, want)
 	}
 
+	// Read rows through the index, which returns them in index order.
+	rows = client.Single().ReadUsingIndex(ctx, tableName, "AgeIndex", spanner.AllKeys(), []string{"LastName"})
+	var lastNames []string
+	err = rows.Do(func(row *spanner.Row) error {
+		var lastName string
+		if err := row.Columns(&lastName); err != nil {
+			return err
+		}
+		lastNames = append(lastNames, lastName)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterating over index read: %v", err)
+	}
+	if want := []string{"Rogers", "Stark", "Romanoff", "Parker", "Quill"}; !reflect.DeepEqual(lastNames, want) {
+		t.Errorf("Index read results = %v, want %v", lastNames, want)
+	}
+
 	// Do a more complex query to find the aliases of the two oldest non-centenarian characters.
 	stmt := spanner.NewStatement(`SELECT Alias FROM ` + tableName + ` WHERE Age < @ageLimit AND Alias IS NOT NULL ORDER BY Age DESC LIMIT @limit`)
 	stmt.Params = map[string]interface{}{
@@ -341,6 +360,188 @@ func TestIntegration_SpannerBasics(t *testing.T) {
 	}
 }
 
+func TestIntegration_DML(t *testing.T) {
+	client, adminClient, cleanup := makeClient(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	const tableName = "Updateable"
+	err := updateDDL(t, adminClient, "DROP TABLE "+tableName)
+	// NotFound is an acceptable failure mode here.
+	if st, _ := status.FromError(err); st.Code() == codes.NotFound {
+		err = nil
+	}
+	if err != nil {
+		t.Fatalf("Dropping old table: %v", err)
+	}
+	err = updateDDL(t, adminClient,
+		`CREATE TABLE `+tableName+` (
+			id INT64,
+			name STRING(MAX),
+		) PRIMARY KEY (id)`)
+	if err != nil {
+		t.Fatalf("Setting up fresh table: %v", err)
+	}
+
+	var counts []int64
+	_, err = client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		stmt := spanner.NewStatement(`INSERT INTO ` + tableName + ` (id, name) VALUES (@id, @name), (2, "bar")`)
+		stmt.Params = map[string]interface{}{
+			"id":   1,
+			"name": "foo",
+		}
+		n, err := tx.Update(ctx, stmt)
+		if err != nil {
+			return err
+		}
+		counts = []int64{n}
+
+		batch, err := tx.BatchUpdate(ctx, []spanner.Statement{
+			spanner.NewStatement(`INSERT INTO ` + tableName + ` (id, name) VALUES (3, "baz")`),
+			spanner.NewStatement(`UPDATE ` + tableName + ` SET name = "updated" WHERE id > 1`),
+			spanner.NewStatement(`DELETE FROM ` + tableName + ` WHERE name = "foo"`),
+		})
+		counts = append(counts, batch...)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Executing DML: %v", err)
+	}
+	if want := []int64{2, 1, 2, 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("DML row counts = %v, want %v", counts, want)
+	}
+
+	// A batch stops at the first failing statement,
+	// but the transaction can still commit the preceding statements.
+	var batchErr error
+	_, err = client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		counts, batchErr = tx.BatchUpdate(ctx, []spanner.Statement{
+			spanner.NewStatement(`UPDATE ` + tableName + ` SET name = "again" WHERE id = 2`),
+			spanner.NewStatement(`INSERT INTO ` + tableName + ` (id, name) VALUES (3, "duplicate")`),
+			spanner.NewStatement(`DELETE FROM ` + tableName + ` WHERE TRUE`),
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Committing after failed batch DML: %v", err)
+	}
+	if spanner.ErrCode(batchErr) != codes.AlreadyExists {
+		t.Errorf("Batch DML with duplicate insert: got %v, want AlreadyExists", batchErr)
+	}
+	if want := []int64{1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("Batch DML with failure row counts = %v, want %v", counts, want)
+	}
+
+	var names []string
+	err = client.Single().Read(ctx, tableName, spanner.AllKeys(), []string{"name"}).Do(func(row *spanner.Row) error {
+		var name string
+		if err := row.Column(0, &name); err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Reading table: %v", err)
+	}
+	if want := []string{"again", "updated"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Table contents after DML = %q, want %q", names, want)
+	}
+}
+
+func TestIntegration_Transactions(t *testing.T) {
+	client, adminClient, cleanup := makeClient(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	const tableName = "Counters"
+	err := updateDDL(t, adminClient, "DROP TABLE "+tableName)
+	// NotFound is an acceptable failure mode here.
+	if st, _ := status.FromError(err); st.Code() == codes.NotFound {
+		err = nil
+	}
+	if err != nil {
+		t.Fatalf("Dropping old table: %v", err)
+	}
+	err = updateDDL(t, adminClient,
+		`CREATE TABLE `+tableName+` (
+			name STRING(MAX),
+			value INT64,
+		) PRIMARY KEY (name)`)
+	if err != nil {
+		t.Fatalf("Setting up fresh table: %v", err)
+	}
+
+	ts1, err := client.Apply(ctx, []*spanner.Mutation{
+		spanner.Insert(tableName, []string{"name", "value"}, []interface{}{"c", 0}),
+	})
+	if err != nil {
+		t.Fatalf("Inserting initial row: %v", err)
+	}
+
+	// Increment the counter concurrently.
+	// Conflicting transactions are aborted and retried, so no increment is lost.
+	const n = 10
+	var wg sync.WaitGroup
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+				row, err := tx.ReadRow(ctx, tableName, spanner.Key{"c"}, []string{"value"})
+				if err != nil {
+					return err
+				}
+				var v int64
+				if err := row.Column(0, &v); err != nil {
+					return err
+				}
+				return tx.BufferWrite([]*spanner.Mutation{
+					spanner.Update(tableName, []string{"name", "value"}, []interface{}{"c", v + 1}),
+				})
+			})
+			errc <- err
+		}()
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		if err != nil {
+			t.Fatalf("Incrementing counter: %v", err)
+		}
+	}
+
+	readValue := func(ro *spanner.ReadOnlyTransaction) int64 {
+		t.Helper()
+		row, err := ro.ReadRow(ctx, tableName, spanner.Key{"c"}, []string{"value"})
+		if err != nil {
+			t.Fatalf("Reading counter: %v", err)
+		}
+		var v int64
+		if err := row.Column(0, &v); err != nil {
+			t.Fatalf("Decoding counter: %v", err)
+		}
+		return v
+	}
+	if v := readValue(client.Single()); v != n {
+		t.Errorf("Counter after concurrent increments = %d, want %d", v, n)
+	}
+
+	// A stale read sees the data as it was at the requested time.
+	ro := client.Single().WithTimestampBound(spanner.ReadTimestamp(ts1))
+	if v := readValue(ro); v != 0 {
+		t.Errorf("Counter read at %v = %d, want 0", ts1, v)
+	}
+	if ts, err := ro.Timestamp(); err != nil || !ts.Equal(ts1) {
+		t.Errorf("Stale read timestamp = %v, %v; want %v", ts, err, ts1)
+	}
+}
+
 func updateDDL(t *testing.T, adminClient *dbadmin.DatabaseAdminClient, statements ...string) error {
 	t.Helper()
 	ctx := context.Background()
@@ -0,0 +1,390 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spansql
+
+// This file holds the schema diffing logic.
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Diff returns the DDL statements that, when applied in order to a database
+// whose schema is described by from, produce a database whose schema is
+// described by to.
+//
+// Both from and to may contain any sequence of DDL statements;
+// they are first folded into the schema they describe.
+// Positions and comments are ignored.
+//
+// Changes that Spanner cannot apply in place, such as changing the primary key
+// of a table or its interleaving parent, are reported as an error.
+// Indexes that change in a way that cannot be expressed by ALTER INDEX
+// are dropped and recreated.
+func Diff(from, to DDL) ([]DDLStmt, error) {
+	fs, err := foldSchema(from)
+	if err != nil {
+		return nil, fmt.Errorf("folding source schema: %v", err)
+	}
+	ts, err := foldSchema(to)
+	if err != nil {
+		return nil, fmt.Errorf("folding target schema: %v", err)
+	}
+
+	var stmts []DDLStmt
+
+	// Drop indexes that are gone or need recreating, and stored columns that are gone.
+	// This must happen before the tables or columns they depend on are dropped.
+	var addStored []DDLStmt
+	recreate := make(map[string]bool)
+	for _, fi := range fs.indexes {
+		ti, ok := ts.index(fi.Name)
+		if !ok || !sameIndexKey(fi, ti) {
+			stmts = append(stmts, DropIndex{Name: fi.Name})
+			recreate[fi.Name] = true
+			continue
+		}
+		for _, col := range fi.Storing {
+			if !containsString(ti.Storing, col) {
+				stmts = append(stmts, AlterIndex{Name: fi.Name, Alteration: DropStoredColumn{Name: col}})
+			}
+		}
+		for _, col := range ti.Storing {
+			if !containsString(fi.Storing, col) {
+				addStored = append(addStored, AlterIndex{Name: fi.Name, Alteration: AddStoredColumn{Name: col}})
+			}
+		}
+	}
+
+	// Drop tables that are gone. Interleaved tables are always created after
+	// their parents, so dropping in reverse order drops children first.
+	for i := len(fs.tables) - 1; i >= 0; i-- {
+		ft := fs.tables[i]
+		if _, ok := ts.table(ft.Name); !ok {
+			stmts = append(stmts, DropTable{Name: ft.Name})
+		}
+	}
+
+	// Alter tables that exist in both schemas.
+	for _, ft := range fs.tables {
+		tt, ok := ts.table(ft.Name)
+		if !ok {
+			continue
+		}
+		alts, err := diffTable(ft, tt)
+		if err != nil {
+			return nil, err
+		}
+		for _, alt := range alts {
+			stmts = append(stmts, AlterTable{Name: ft.Name, Alteration: alt})
+		}
+	}
+
+	// Create new tables, in the order they appear in the target schema.
+	for _, tt := range ts.tables {
+		if _, ok := fs.table(tt.Name); !ok {
+			stmts = append(stmts, tt)
+		}
+	}
+
+	// Create new or recreated indexes, and add new stored columns.
+	for _, ti := range ts.indexes {
+		if _, ok := fs.index(ti.Name); !ok || recreate[ti.Name] {
+			stmts = append(stmts, ti)
+		}
+	}
+	stmts = append(stmts, addStored...)
+
+	return stmts, nil
+}
+
+// diffTable returns the alterations that turn ft into tt.
+func diffTable(ft, tt CreateTable) ([]TableAlteration, error) {
+	if !reflect.DeepEqual(ft.PrimaryKey, tt.PrimaryKey) {
+		return nil, fmt.Errorf("table %s: cannot change primary key from (%s) to (%s)",
+			ft.Name, keyPartList(ft.PrimaryKey), keyPartList(tt.PrimaryKey))
+	}
+	if interleaveParent(ft) != interleaveParent(tt) {
+		return nil, fmt.Errorf("table %s: cannot change interleaving parent from %q to %q",
+			ft.Name, interleaveParent(ft), interleaveParent(tt))
+	}
+
+	var alts []TableAlteration
+	for _, fc := range ft.Columns {
+		if _, ok := findColumn(tt, fc.Name); !ok {
+			alts = append(alts, DropColumn{Name: fc.Name})
+		}
+	}
+	for _, tc := range tt.Columns {
+		fc, ok := findColumn(ft, tc.Name)
+		if !ok {
+			alts = append(alts, AddColumn{Def: tc})
+			continue
+		}
+		if fc.Type != tc.Type || fc.NotNull != tc.NotNull {
+			alts = append(alts, AlterColumn{
+				Name:       tc.Name,
+				Alteration: SetColumnType{Type: tc.Type, NotNull: tc.NotNull},
+			})
+		}
+		if !reflect.DeepEqual(fc.Options, tc.Options) {
+			opts := tc.Options
+			if opts.AllowCommitTimestamp == nil && fc.Options.AllowCommitTimestamp != nil {
+				// Removing the option must be spelled out explicitly.
+				f := false
+				opts.AllowCommitTimestamp = &f
+			}
+			alts = append(alts, AlterColumn{
+				Name:       tc.Name,
+				Alteration: SetColumnOptions{Options: opts},
+			})
+		}
+	}
+	if ft.Interleave != nil && tt.Interleave != nil && ft.Interleave.OnDelete != tt.Interleave.OnDelete {
+		alts = append(alts, SetOnDelete{Action: tt.Interleave.OnDelete})
+	}
+	return alts, nil
+}
+
+// schema is the result of applying a sequence of DDL statements.
+type schema struct {
+	tables  []CreateTable // in creation order
+	indexes []CreateIndex // in creation order
+}
+
+func foldSchema(ddl DDL) (*schema, error) {
+	s := &schema{}
+	for _, stmt := range ddl.List {
+		if err := s.apply(stmt); err != nil {
+			return nil, fmt.Errorf("%v: %v", stmt.Pos(), err)
+		}
+	}
+	return s, nil
+}
+
+func (s *schema) table(name string) (CreateTable, bool) {
+	for _, t := range s.tables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return CreateTable{}, false
+}
+
+func (s *schema) index(name string) (CreateIndex, bool) {
+	for _, idx := range s.indexes {
+		if idx.Name == name {
+			return idx, true
+		}
+	}
+	return CreateIndex{}, false
+}
+
+func (s *schema) apply(stmt DDLStmt) error {
+	switch stmt := stmt.(type) {
+	default:
+		return fmt.Errorf("unhandled DDL statement type %T", stmt)
+	case CreateTable:
+		if _, ok := s.table(stmt.Name); ok {
+			return fmt.Errorf("table %s already exists", stmt.Name)
+		}
+		stmt.Position = Position{}
+		cols := make([]ColumnDef, len(stmt.Columns))
+		for i, cd := range stmt.Columns {
+			cols[i] = normalizeColumn(cd)
+		}
+		stmt.Columns = cols
+		s.tables = append(s.tables, stmt)
+		return nil
+	case CreateIndex:
+		if _, ok := s.index(stmt.Name); ok {
+			return fmt.Errorf("index %s already exists", stmt.Name)
+		}
+		stmt.Position = Position{}
+		s.indexes = append(s.indexes, stmt)
+		return nil
+	case DropTable:
+		for i, t := range s.tables {
+			if t.Name == stmt.Name {
+				s.tables = append(s.tables[:i:i], s.tables[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("no table named %s", stmt.Name)
+	case DropIndex:
+		for i, idx := range s.indexes {
+			if idx.Name == stmt.Name {
+				s.indexes = append(s.indexes[:i:i], s.indexes[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("no index named %s", stmt.Name)
+	case AlterTable:
+		for i, t := range s.tables {
+			if t.Name == stmt.Name {
+				t, err := alterTable(t, stmt.Alteration)
+				if err != nil {
+					return err
+				}
+				s.tables[i] = t
+				return nil
+			}
+		}
+		return fmt.Errorf("no table named %s", stmt.Name)
+	case AlterIndex:
+		for i, idx := range s.indexes {
+			if idx.Name == stmt.Name {
+				idx, err := alterIndex(idx, stmt.Alteration)
+				if err != nil {
+					return err
+				}
+				s.indexes[i] = idx
+				return nil
+			}
+		}
+		return fmt.Errorf("no index named %s", stmt.Name)
+	}
+}
+
+// alterTable returns a copy of t with the alteration applied.
+func alterTable(t CreateTable, alt TableAlteration) (CreateTable, error) {
+	cols := append([]ColumnDef(nil), t.Columns...)
+	switch alt := alt.(type) {
+	default:
+		return CreateTable{}, fmt.Errorf("unhandled table alteration type %T", alt)
+	case AddColumn:
+		if _, ok := findColumn(t, alt.Def.Name); ok {
+			return CreateTable{}, fmt.Errorf("table %s already has a column named %s", t.Name, alt.Def.Name)
+		}
+		cols = append(cols, normalizeColumn(alt.Def))
+	case DropColumn:
+		i, ok := findColumnIndex(t, alt.Name)
+		if !ok {
+			return CreateTable{}, fmt.Errorf("table %s has no column named %s", t.Name, alt.Name)
+		}
+		cols = append(cols[:i], cols[i+1:]...)
+	case SetOnDelete:
+		if t.Interleave == nil {
+			return CreateTable{}, fmt.Errorf("table %s is not interleaved", t.Name)
+		}
+		il := *t.Interleave
+		il.OnDelete = alt.Action
+		t.Interleave = &il
+	case AlterColumn:
+		i, ok := findColumnIndex(t, alt.Name)
+		if !ok {
+			return CreateTable{}, fmt.Errorf("table %s has no column named %s", t.Name, alt.Name)
+		}
+		switch ca := alt.Alteration.(type) {
+		default:
+			return CreateTable{}, fmt.Errorf("unhandled column alteration type %T", ca)
+		case SetColumnType:
+			cols[i].Type = ca.Type
+			cols[i].NotNull = ca.NotNull
+		case SetColumnOptions:
+			cols[i].Options = ca.Options
+			cols[i] = normalizeColumn(cols[i])
+		}
+	}
+	t.Columns = cols
+	return t, nil
+}
+
+// alterIndex returns a copy of idx with the alteration applied.
+func alterIndex(idx CreateIndex, alt IndexAlteration) (CreateIndex, error) {
+	switch alt := alt.(type) {
+	default:
+		return CreateIndex{}, fmt.Errorf("unhandled index alteration type %T", alt)
+	case AddStoredColumn:
+		if containsString(idx.Storing, alt.Name) {
+			return CreateIndex{}, fmt.Errorf("index %s already stores column %s", idx.Name, alt.Name)
+		}
+		idx.Storing = append(idx.Storing[:len(idx.Storing):len(idx.Storing)], alt.Name)
+	case DropStoredColumn:
+		var storing []string
+		for _, col := range idx.Storing {
+			if col != alt.Name {
+				storing = append(storing, col)
+			}
+		}
+		if len(storing) == len(idx.Storing) {
+			return CreateIndex{}, fmt.Errorf("index %s does not store column %s", idx.Name, alt.Name)
+		}
+		idx.Storing = storing
+	}
+	return idx, nil
+}
+
+// normalizeColumn returns a copy of cd without its position,
+// and with options that are set to their default value unset.
+func normalizeColumn(cd ColumnDef) ColumnDef {
+	cd.Position = Position{}
+	if act := cd.Options.AllowCommitTimestamp; act != nil && !*act {
+		cd.Options.AllowCommitTimestamp = nil
+	}
+	return cd
+}
+
+// sameIndexKey reports whether two indexes differ at most in their stored columns.
+func sameIndexKey(a, b CreateIndex) bool {
+	a.Storing, b.Storing = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+func findColumn(t CreateTable, name string) (ColumnDef, bool) {
+	i, ok := findColumnIndex(t, name)
+	if !ok {
+		return ColumnDef{}, false
+	}
+	return t.Columns[i], true
+}
+
+func findColumnIndex(t CreateTable, name string) (int, bool) {
+	for i, cd := range t.Columns {
+		if cd.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func interleaveParent(t CreateTable) string {
+	if t.Interleave == nil {
+		return ""
+	}
+	return t.Interleave.Parent
+}
+
+func keyPartList(kps []KeyPart) string {
+	var str string
+	for i, kp := range kps {
+		if i > 0 {
+			str += ", "
+		}
+		str += kp.SQL()
+	}
+	return str
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spansql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		desc     string
+		from, to string
+		want     []string
+	}{
+		{
+			desc: "identical",
+			from: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A)`,
+			to: `-- Comments and layout are ignored.
+			CREATE TABLE T (
+				A INT64 NOT NULL,
+			) PRIMARY KEY (A)`,
+			want: nil,
+		},
+		{
+			desc: "alterations are folded",
+			from: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A);
+			ALTER TABLE T ADD COLUMN B STRING(MAX);
+			ALTER TABLE T ALTER COLUMN B BYTES(MAX)`,
+			to:   `CREATE TABLE T (A INT64 NOT NULL, B BYTES(MAX)) PRIMARY KEY (A)`,
+			want: nil,
+		},
+		{
+			desc: "new tables and indexes",
+			from: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A)`,
+			to: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A);
+			CREATE TABLE U (A INT64 NOT NULL, B INT64) PRIMARY KEY (A, B), INTERLEAVE IN PARENT T;
+			CREATE INDEX UByB ON U(B)`,
+			want: []string{
+				"CREATE TABLE U (\n  A INT64 NOT NULL,\n  B INT64,\n) PRIMARY KEY(A, B),\n  INTERLEAVE IN PARENT T ON DELETE NO ACTION",
+				"CREATE INDEX UByB ON U(B)",
+			},
+		},
+		{
+			desc: "dropped tables and indexes",
+			from: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A);
+			CREATE TABLE U (A INT64 NOT NULL, B INT64) PRIMARY KEY (A, B), INTERLEAVE IN PARENT T;
+			CREATE INDEX UByB ON U(B)`,
+			to: ``,
+			want: []string{
+				"DROP INDEX UByB",
+				"DROP TABLE U",
+				"DROP TABLE T",
+			},
+		},
+		{
+			desc: "column changes",
+			from: `CREATE TABLE T (
+				A INT64 NOT NULL,
+				B STRING(10),
+				C TIMESTAMP OPTIONS (allow_commit_timestamp = true),
+				D TIMESTAMP,
+				E INT64,
+			) PRIMARY KEY (A)`,
+			to: `CREATE TABLE T (
+				A INT64 NOT NULL,
+				B STRING(MAX) NOT NULL,
+				C TIMESTAMP,
+				D TIMESTAMP OPTIONS (allow_commit_timestamp = true),
+				F BOOL,
+			) PRIMARY KEY (A)`,
+			want: []string{
+				"ALTER TABLE T DROP COLUMN E",
+				"ALTER TABLE T ALTER COLUMN B STRING(MAX) NOT NULL",
+				"ALTER TABLE T ALTER COLUMN C SET OPTIONS (allow_commit_timestamp = null)",
+				"ALTER TABLE T ALTER COLUMN D SET OPTIONS (allow_commit_timestamp = true)",
+				"ALTER TABLE T ADD COLUMN F BOOL",
+			},
+		},
+		{
+			desc: "on delete action",
+			from: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A);
+			CREATE TABLE U (A INT64 NOT NULL) PRIMARY KEY (A), INTERLEAVE IN PARENT T`,
+			to: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A);
+			CREATE TABLE U (A INT64 NOT NULL) PRIMARY KEY (A), INTERLEAVE IN PARENT T ON DELETE CASCADE`,
+			want: []string{
+				"ALTER TABLE U SET ON DELETE CASCADE",
+			},
+		},
+		{
+			desc: "index changes",
+			from: `CREATE TABLE T (A INT64 NOT NULL, B INT64, C INT64) PRIMARY KEY (A);
+			CREATE INDEX TByB ON T(B) STORING (C);
+			CREATE INDEX TByC ON T(C)`,
+			to: `CREATE TABLE T (A INT64 NOT NULL, B INT64, C INT64) PRIMARY KEY (A);
+			CREATE INDEX TByB ON T(B);
+			CREATE UNIQUE INDEX TByC ON T(C) STORING (B)`,
+			want: []string{
+				"ALTER INDEX TByB DROP STORED COLUMN C",
+				"DROP INDEX TByC",
+				"CREATE UNIQUE INDEX TByC ON T(C) STORING (B)",
+			},
+		},
+	}
+	for _, test := range tests {
+		from, err := ParseDDL(test.from)
+		if err != nil {
+			t.Fatalf("%s: parsing from: %v", test.desc, err)
+		}
+		to, err := ParseDDL(test.to)
+		if err != nil {
+			t.Fatalf("%s: parsing to: %v", test.desc, err)
+		}
+		stmts, err := Diff(from, to)
+		if err != nil {
+			t.Errorf("%s: Diff: %v", test.desc, err)
+			continue
+		}
+		var got []string
+		for _, stmt := range stmts {
+			got = append(got, stmt.SQL())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Diff wrong.\n got %q\nwant %q", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestDiffFailures(t *testing.T) {
+	tests := []struct {
+		desc     string
+		from, to string
+	}{
+		{
+			desc: "primary key change",
+			from: `CREATE TABLE T (A INT64 NOT NULL, B INT64 NOT NULL) PRIMARY KEY (A)`,
+			to:   `CREATE TABLE T (A INT64 NOT NULL, B INT64 NOT NULL) PRIMARY KEY (A, B)`,
+		},
+		{
+			desc: "interleaving change",
+			from: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A);
+			CREATE TABLE U (A INT64 NOT NULL) PRIMARY KEY (A)`,
+			to: `CREATE TABLE T (A INT64 NOT NULL) PRIMARY KEY (A);
+			CREATE TABLE U (A INT64 NOT NULL) PRIMARY KEY (A), INTERLEAVE IN PARENT T`,
+		},
+		{
+			desc: "unknown table",
+			from: `ALTER TABLE T DROP COLUMN A`,
+		},
+	}
+	for _, test := range tests {
+		from, err := ParseDDL(test.from)
+		if err != nil {
+			t.Fatalf("%s: parsing from: %v", test.desc, err)
+		}
+		to, err := ParseDDL(test.to)
+		if err != nil {
+			t.Fatalf("%s: parsing to: %v", test.desc, err)
+		}
+		if _, err := Diff(from, to); err == nil {
+			t.Errorf("%s: Diff succeeded, should have failed", test.desc)
+		}
+	}
+}
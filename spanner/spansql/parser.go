@@ -23,6 +23,7 @@ Sources:
 	https://cloud.google.com/spanner/docs/lexical
 	https://cloud.google.com/spanner/docs/query-syntax
 	https://cloud.google.com/spanner/docs/data-definition-language
+	https://cloud.google.com/spanner/docs/dml-syntax
 */
 package spansql
 
@@ -46,9 +47,13 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
+
+	"cloud.google.com/go/civil"
 )
 
 const debug = false
@@ -92,6 +97,7 @@ func ParseDDL(s string) (DDL, error) {
 	if p.Rem() != "" {
 		return DDL{}, fmt.Errorf("unexpected trailing contents %q", p.Rem())
 	}
+	ddl.Comments = p.comments
 	return ddl, nil
 }
 
@@ -121,9 +127,23 @@ func ParseQuery(s string) (Query, error) {
 	return q, nil
 }
 
+// ParseDMLStmt parses a single DML statement.
+func ParseDMLStmt(s string) (DMLStmt, error) {
+	p := newParser(s)
+	stmt, err := p.parseDMLStmt()
+	if err != nil {
+		return nil, err
+	}
+	if p.Rem() != "" {
+		return nil, fmt.Errorf("unexpected trailing contents %q", p.Rem())
+	}
+	return stmt, nil
+}
+
 type token struct {
 	value string
 	err   error
+	pos   Position
 
 	typ     tokenType
 	int64   int64
@@ -141,6 +161,23 @@ const (
 	bytesToken
 )
 
+// endsOperand reports whether the token could be the end of an operand,
+// such as a literal, identifier or closing paren.
+func (t *token) endsOperand() bool {
+	if t.err != nil || t.value == "" {
+		return false
+	}
+	if t.typ != unknownToken {
+		return true // a literal
+	}
+	switch t.value {
+	case ")", "]", "TRUE", "FALSE", "NULL", "END":
+		return true
+	}
+	c := t.value[0]
+	return (c == '@' || isInitialIdentifierChar(c)) && !keywords[strings.ToUpper(t.value)]
+}
+
 func (t *token) String() string {
 	if t.err != nil {
 		return fmt.Sprintf("parse error: %v", t.err)
@@ -153,12 +190,38 @@ type parser struct {
 	done   bool   // Whether the parsing is finished (success or error).
 	backed bool   // Whether back() was called.
 	cur    token
+
+	// line is the line number of the start of s in the input.
+	line int
+	// tokLine is the line on which the most recent token ended.
+	tokLine int
+
+	// comments holds the comments seen so far, in order.
+	// Since the parser state may be restored to an earlier copy,
+	// elements of the slice must not be modified in place.
+	comments []*Comment
 }
 
 func newParser(s string) *parser {
 	return &parser{
-		s: s,
+		s:    s,
+		line: 1,
+	}
+}
+
+// Pos reports the position of the next token.
+func (p *parser) Pos() Position {
+	if p.backed {
+		return p.cur.pos
 	}
+	p.skipSpace()
+	return Position{Line: p.line}
+}
+
+// consume advances past the first n bytes of the remaining input.
+func (p *parser) consume(n int) {
+	p.line += strings.Count(p.s[:n], "\n")
+	p.s = p.s[n:]
 }
 
 // Rem returns the unparsed remainder, ignoring space.
@@ -538,6 +601,39 @@ var operators = map[string]bool{
 	"=":  true,
 	"!=": true,
 	"<>": true,
+
+	"/":  true,
+	"||": true,
+	"<<": true,
+	">>": true,
+	"&":  true,
+	"^":  true,
+	"|":  true,
+	"~":  true,
+}
+
+// keywords is the set of reserved keywords.
+// https://cloud.google.com/spanner/docs/lexical#reserved-keywords
+var keywords = map[string]bool{
+	"ALL": true, "AND": true, "ANY": true, "ARRAY": true, "AS": true, "ASC": true,
+	"ASSERT_ROWS_MODIFIED": true, "AT": true, "BETWEEN": true, "BY": true,
+	"CASE": true, "CAST": true, "COLLATE": true, "CONTAINS": true, "CREATE": true,
+	"CROSS": true, "CUBE": true, "CURRENT": true, "DEFAULT": true, "DEFINE": true,
+	"DESC": true, "DISTINCT": true, "ELSE": true, "END": true, "ENUM": true,
+	"ESCAPE": true, "EXCEPT": true, "EXCLUDE": true, "EXISTS": true, "EXTRACT": true,
+	"FALSE": true, "FETCH": true, "FOLLOWING": true, "FOR": true, "FROM": true,
+	"FULL": true, "GROUP": true, "GROUPING": true, "GROUPS": true, "HASH": true,
+	"HAVING": true, "IF": true, "IGNORE": true, "IN": true, "INNER": true,
+	"INTERSECT": true, "INTERVAL": true, "INTO": true, "IS": true, "JOIN": true,
+	"LATERAL": true, "LEFT": true, "LIKE": true, "LIMIT": true, "LOOKUP": true,
+	"MERGE": true, "NATURAL": true, "NEW": true, "NO": true, "NOT": true,
+	"NULL": true, "NULLS": true, "OF": true, "ON": true, "OR": true, "ORDER": true,
+	"OUTER": true, "OVER": true, "PARTITION": true, "PRECEDING": true, "PROTO": true,
+	"RANGE": true, "RECURSIVE": true, "RESPECT": true, "RIGHT": true, "ROLLUP": true,
+	"ROWS": true, "SELECT": true, "SET": true, "SOME": true, "STRUCT": true,
+	"TABLESAMPLE": true, "THEN": true, "TO": true, "TREAT": true, "TRUE": true,
+	"UNBOUNDED": true, "UNION": true, "UNNEST": true, "USING": true, "WHEN": true,
+	"WHERE": true, "WINDOW": true, "WITH": true, "WITHIN": true,
 }
 
 func isSpace(c byte) bool {
@@ -550,38 +646,78 @@ func isSpace(c byte) bool {
 	return false
 }
 
-// skipSpace skips past any space or comments.
+// skipSpace skips past any space or comments, recording the comments.
 func (p *parser) skipSpace() bool {
-	i := 0
-	for i < len(p.s) {
-		if isSpace(p.s[i]) {
-			i++
+	skipped := false
+	for len(p.s) > 0 {
+		if isSpace(p.s[0]) {
+			p.consume(1)
+			skipped = true
 			continue
 		}
 		// Comments.
-		term := ""
-		if p.s[i] == '#' {
-			term = "\n"
-		} else if i+1 < len(p.s) && p.s[i] == '-' && p.s[i+1] == '-' {
-			term = "\n"
-		} else if i+1 < len(p.s) && p.s[i] == '/' && p.s[i+1] == '*' {
-			term = "*/"
+		marker, term := "", ""
+		if p.s[0] == '#' {
+			marker, term = "#", "\n"
+		} else if strings.HasPrefix(p.s, "--") {
+			marker, term = "--", "\n"
+		} else if strings.HasPrefix(p.s, "/*") {
+			marker, term = "/*", "*/"
 		}
 		if term == "" {
 			break
 		}
-		ti := strings.Index(p.s[i:], term)
+		ti := strings.Index(p.s, term)
 		if ti < 0 {
-			p.errorf("unterminated comment")
-			return false
+			if marker == "/*" {
+				p.errorf("unterminated comment")
+				return false
+			}
+			// A single line comment may end the input.
+			ti = len(p.s)
 		}
-		i += ti + len(term)
+		start := Position{Line: p.line}
+		text := p.s[len(marker):ti]
+		p.consume(ti)
+		end := Position{Line: p.line}
+		if marker == "/*" {
+			p.consume(len(term))
+		}
+		p.addComment(marker, text, start, end)
+		skipped = true
 	}
-	p.s = p.s[i:]
 	if p.s == "" {
 		p.done = true
 	}
-	return i > 0
+	return skipped
+}
+
+// addComment records a comment whose text (without its markers) spans the given positions.
+func (p *parser) addComment(marker, text string, start, end Position) {
+	c := &Comment{
+		Marker:   marker,
+		Isolated: start.Line > p.tokLine,
+		Start:    start,
+		End:      end,
+	}
+	for _, line := range strings.Split(text, "\n") {
+		c.Text = append(c.Text, strings.TrimSpace(line))
+	}
+
+	// Consecutive single line comments with the same marker are joined,
+	// unless one follows something else on its line.
+	if n := len(p.comments); n > 0 && marker != "/*" && c.Isolated {
+		prev := p.comments[n-1]
+		if prev.Marker == marker && prev.Isolated && prev.End.Line == start.Line-1 {
+			joined := *prev
+			joined.Text = append(append([]string(nil), prev.Text...), c.Text...)
+			joined.End = end
+			// Replace the last element in a new slice, since the original may still be in use.
+			p.comments = append(p.comments[:n-1:n-1], &joined)
+			return
+		}
+	}
+	p.comments = append(p.comments, c)
 }
 
 // advance moves the parser to the next token, which will be available in p.cur.
@@ -590,12 +726,23 @@ func (p *parser) advance() {
 	if p.done {
 		return
 	}
+	p.cur.pos = Position{Line: p.line}
+	// Keep track of the line number as the input is consumed below.
+	prev := p.s
+	defer func() {
+		p.line += strings.Count(prev[:len(prev)-len(p.s)], "\n")
+		p.tokLine = p.line
+	}()
+	// A sign is only part of a numeric literal if it can't be a binary operator,
+	// which is the case if the previous token can't be the end of an operand.
+	afterOperand := p.cur.endsOperand()
+
 	p.cur.err = nil
 	p.cur.typ = unknownToken
 	// TODO: backtick (`) for quoted identifiers.
-	// TODO: array, struct, date, timestamp literals
+	// TODO: struct, date, timestamp literals
 	switch p.s[0] {
-	case ',', ';', '(', ')', '*':
+	case ',', ';', '(', ')', '{', '}', '*', '[', ']':
 		// Single character symbol.
 		p.cur.value, p.s = p.s[:1], p.s[1:]
 		return
@@ -612,6 +759,7 @@ func (p *parser) advance() {
 				bytes = true
 				continue
 			case p.s[i] == '"' || p.s[i] == '\'':
+				orig := p.s
 				switch {
 				case raw && bytes:
 					p.consumeRawBytes()
@@ -622,6 +770,8 @@ func (p *parser) advance() {
 				default:
 					p.consumeString()
 				}
+				// Record the literal as it appeared in the input.
+				p.cur.value = orig[:len(orig)-len(p.s)]
 				return
 			}
 			break
@@ -636,7 +786,7 @@ func (p *parser) advance() {
 		p.cur.value, p.s = p.s[:i], p.s[i:]
 		return
 	}
-	if len(p.s) >= 2 && (p.s[0] == '+' || p.s[0] == '-' || p.s[0] == '.') && ('0' <= p.s[1] && p.s[1] <= '9') {
+	if len(p.s) >= 2 && (p.s[0] == '.' || ((p.s[0] == '+' || p.s[0] == '-') && !afterOperand)) && ('0' <= p.s[1] && p.s[1] <= '9') {
 		// [-+.] followed by a digit.
 		p.consumeNumber()
 		return
@@ -648,7 +798,7 @@ func (p *parser) advance() {
 	// More single character symbols.
 	// These are deliberately below the numeric literal parsing.
 	switch p.s[0] {
-	case '-', '+':
+	case '-', '+', '.':
 		p.cur.value, p.s = p.s[:1], p.s[1:]
 		return
 	}
@@ -740,11 +890,12 @@ func (p *parser) parseDDLStmt() (DDLStmt, error) {
 
 	/*
 		statement:
-			{ create_database | create_table | create_index | alter_table | drop_table | drop_index }
+			{ create_database | create_table | create_index | alter_table | alter_index | drop_table | drop_index }
 	*/
 
 	// TODO: support create_database
 
+	pos := p.Pos()
 	if p.sniff("CREATE", "TABLE") {
 		ct, err := p.parseCreateTable()
 		return ct, err
@@ -756,6 +907,9 @@ func (p *parser) parseDDLStmt() (DDLStmt, error) {
 	} else if p.sniff("ALTER", "TABLE") {
 		a, err := p.parseAlterTable()
 		return a, err
+	} else if p.sniff("ALTER", "INDEX") {
+		a, err := p.parseAlterIndex()
+		return a, err
 	} else if p.eat("DROP") {
 		// These statements are simple.
 		//	DROP TABLE table_name
@@ -773,9 +927,9 @@ func (p *parser) parseDDLStmt() (DDLStmt, error) {
 			return nil, err
 		}
 		if kind == "TABLE" {
-			return DropTable{Name: name}, nil
+			return DropTable{Name: name, Position: pos}, nil
 		}
-		return DropIndex{Name: name}, nil
+		return DropIndex{Name: name, Position: pos}, nil
 	}
 
 	return nil, p.errorf("unknown DDL statement")
@@ -796,6 +950,7 @@ func (p *parser) parseCreateTable() (CreateTable, error) {
 			INTERLEAVE IN PARENT table_name [ ON DELETE { CASCADE | NO ACTION } ]
 	*/
 
+	pos := p.Pos()
 	if err := p.expect("CREATE"); err != nil {
 		return CreateTable{}, err
 	}
@@ -807,7 +962,7 @@ func (p *parser) parseCreateTable() (CreateTable, error) {
 		return CreateTable{}, err
 	}
 
-	ct := CreateTable{Name: tname}
+	ct := CreateTable{Name: tname, Position: pos}
 	err = p.parseCommaList(func(p *parser) error {
 		cd, err := p.parseColumnDef()
 		if err != nil {
@@ -878,6 +1033,7 @@ func (p *parser) parseCreateIndex() (CreateIndex, error) {
 
 	var unique, nullFiltered bool
 
+	pos := p.Pos()
 	if err := p.expect("CREATE"); err != nil {
 		return CreateIndex{}, err
 	}
@@ -907,6 +1063,8 @@ func (p *parser) parseCreateIndex() (CreateIndex, error) {
 
 		Unique:       unique,
 		NullFiltered: nullFiltered,
+
+		Position: pos,
 	}
 	ci.Columns, err = p.parseKeyPartList()
 	if err != nil {
@@ -945,6 +1103,7 @@ func (p *parser) parseAlterTable() (AlterTable, error) {
 			ALTER COLUMN column_name { { scalar_type | array_type } [NOT NULL] | SET options_def }
 	*/
 
+	pos := p.Pos()
 	if err := p.expect("ALTER"); err != nil {
 		return AlterTable{}, err
 	}
@@ -955,7 +1114,7 @@ func (p *parser) parseAlterTable() (AlterTable, error) {
 	if err != nil {
 		return AlterTable{}, err
 	}
-	a := AlterTable{Name: tname}
+	a := AlterTable{Name: tname, Position: pos}
 
 	tok := p.next()
 	if tok.err != nil {
@@ -997,8 +1156,81 @@ func (p *parser) parseAlterTable() (AlterTable, error) {
 		}
 		a.Alteration = SetOnDelete{Action: od}
 		return a, nil
+	case "ALTER":
+		if err := p.expect("COLUMN"); err != nil {
+			return AlterTable{}, err
+		}
+		name, err := p.parseTableOrIndexOrColumnName()
+		if err != nil {
+			return AlterTable{}, err
+		}
+		ac := AlterColumn{Name: name}
+		if p.eat("SET") {
+			co, err := p.parseColumnOptions()
+			if err != nil {
+				return AlterTable{}, err
+			}
+			ac.Alteration = SetColumnOptions{Options: co}
+		} else {
+			sct := SetColumnType{}
+			sct.Type, err = p.parseType()
+			if err != nil {
+				return AlterTable{}, err
+			}
+			if p.eat("NOT", "NULL") {
+				sct.NotNull = true
+			}
+			ac.Alteration = sct
+		}
+		a.Alteration = ac
+		return a, nil
+	}
+}
+
+func (p *parser) parseAlterIndex() (AlterIndex, error) {
+	debugf("parseAlterIndex: %v", p)
+
+	/*
+		ALTER INDEX index_name { ADD | DROP } STORED COLUMN column_name
+	*/
+
+	pos := p.Pos()
+	if err := p.expect("ALTER"); err != nil {
+		return AlterIndex{}, err
+	}
+	if err := p.expect("INDEX"); err != nil {
+		return AlterIndex{}, err
+	}
+	iname, err := p.parseTableOrIndexOrColumnName()
+	if err != nil {
+		return AlterIndex{}, err
+	}
+	a := AlterIndex{Name: iname, Position: pos}
+
+	tok := p.next()
+	if tok.err != nil {
+		return AlterIndex{}, tok.err
+	}
+	add := tok.value == "ADD"
+	if !add && tok.value != "DROP" {
+		return AlterIndex{}, p.errorf("got %q, expected ADD or DROP", tok.value)
+	}
+	if err := p.expect("STORED"); err != nil {
+		return AlterIndex{}, err
+	}
+	if err := p.expect("COLUMN"); err != nil {
+		return AlterIndex{}, err
+	}
+	name, err := p.parseTableOrIndexOrColumnName()
+	if err != nil {
+		return AlterIndex{}, err
+	}
+	if add {
+		a.Alteration = AddStoredColumn{Name: name}
+	} else {
+		a.Alteration = DropStoredColumn{Name: name}
 	}
-	// TODO: "ALTER"
+	return a, nil
 }
 
 func (p *parser) parseColumnDef() (ColumnDef, error) {
@@ -1009,32 +1241,75 @@ func (p *parser) parseColumnDef() (ColumnDef, error) {
 			column_name {scalar_type | array_type} [NOT NULL] [options_def]
 	*/
 
+	pos := p.Pos()
 	name, err := p.parseTableOrIndexOrColumnName()
 	if err != nil {
 		return ColumnDef{}, err
 	}
 
-	cd := ColumnDef{Name: name}
+	cd := ColumnDef{Name: name, Position: pos}
 
 	cd.Type, err = p.parseType()
 	if err != nil {
 		return ColumnDef{}, err
 	}
 
-	tok := p.next()
-	if tok.err != nil || tok.value != "NOT" {
-		// End of the column_def.
-		p.back()
-		return cd, nil
+	if p.eat("NOT") {
+		if err := p.expect("NULL"); err != nil {
+			return ColumnDef{}, err
+		}
+		cd.NotNull = true
 	}
-	if err := p.expect("NULL"); err != nil {
-		return ColumnDef{}, err
+
+	if p.sniff("OPTIONS") {
+		cd.Options, err = p.parseColumnOptions()
+		if err != nil {
+			return ColumnDef{}, err
+		}
 	}
-	cd.NotNull = true
 
 	return cd, nil
 }
 
+func (p *parser) parseColumnOptions() (ColumnOptions, error) {
+	debugf("parseColumnOptions: %v", p)
+
+	/*
+		options_def:
+			OPTIONS ( allow_commit_timestamp = { true | null } )
+	*/
+
+	if err := p.expect("OPTIONS"); err != nil {
+		return ColumnOptions{}, err
+	}
+	if err := p.expect("("); err != nil {
+		return ColumnOptions{}, err
+	}
+
+	// TODO: Handle other options, if they are added to Spanner.
+	var co ColumnOptions
+	if p.eat("allow_commit_timestamp", "=") {
+		tok := p.next()
+		if tok.err != nil {
+			return ColumnOptions{}, tok.err
+		}
+		allowCommitTimestamp := true
+		switch strings.ToLower(tok.value) {
+		case "true":
+		case "null":
+			allowCommitTimestamp = false
+		default:
+			return ColumnOptions{}, p.errorf("got %q, want true or null", tok.value)
+		}
+		co.AllowCommitTimestamp = &allowCommitTimestamp
+	}
+
+	if err := p.expect(")"); err != nil {
+		return ColumnOptions{}, err
+	}
+	return co, nil
+}
+
 func (p *parser) parseKeyPartList() ([]KeyPart, error) {
 	var list []KeyPart
 	err := p.parseCommaList(func(p *parser) error {
@@ -1104,6 +1379,12 @@ var baseTypes = map[string]TypeBase{
 }
 
 func (p *parser) parseType() (Type, error) {
+	return p.parseTypeLen(true)
+}
+
+// parseTypeLen parses a type. The length of STRING and BYTES types is
+// only required if needLen is set; it is not given in a CAST, for instance.
+func (p *parser) parseTypeLen(needLen bool) (Type, error) {
 	debugf("parseType: %v", p)
 
 	/*
@@ -1138,7 +1419,7 @@ func (p *parser) parseType() (Type, error) {
 	}
 	t.Base = base
 
-	if t.Base == String || t.Base == Bytes {
+	if (t.Base == String || t.Base == Bytes) && (needLen || p.sniff("(")) {
 		if err := p.expect("("); err != nil {
 			return Type{}, err
 		}
@@ -1169,6 +1450,158 @@ func (p *parser) parseType() (Type, error) {
 	return t, nil
 }
 
+func (p *parser) parseDMLStmt() (DMLStmt, error) {
+	debugf("parseDMLStmt: %v", p)
+
+	/*
+		DELETE [FROM] target_name [[AS] alias]
+		WHERE condition
+
+		UPDATE target_name [[AS] alias]
+		SET update_item [, ...]
+		WHERE condition
+
+		update_item: path_expression = expression | path_expression = DEFAULT
+
+		INSERT [INTO] target_name
+		 (column_name_1 [, ..., column_name_n] )
+		 input
+
+		input:
+		 VALUES (row_1_column_1_expr [, ..., row_1_column_n_expr ] )
+		        [, ..., (row_k_column_1_expr [, ..., row_k_column_n_expr ] ) ]
+		| select_query
+
+		expr: value_expression | DEFAULT
+	*/
+
+	// TODO: support aliases.
+
+	if p.eat("DELETE") {
+		p.eat("FROM") // optional
+		tname, err := p.parseTableOrIndexOrColumnName()
+		if err != nil {
+			return nil, err
+		}
+		// The WHERE clause is required.
+		if err := p.expect("WHERE"); err != nil {
+			return nil, err
+		}
+		where, err := p.parseBoolExpr()
+		if err != nil {
+			return nil, err
+		}
+		return Delete{
+			Table: tname,
+			Where: where,
+		}, nil
+	}
+
+	if p.eat("UPDATE") {
+		tname, err := p.parseTableOrIndexOrColumnName()
+		if err != nil {
+			return nil, err
+		}
+		u := Update{Table: tname}
+		if err := p.expect("SET"); err != nil {
+			return nil, err
+		}
+		for {
+			ui, err := p.parseUpdateItem()
+			if err != nil {
+				return nil, err
+			}
+			u.Items = append(u.Items, ui)
+			if !p.eat(",") {
+				break
+			}
+		}
+		// The WHERE clause is required.
+		if err := p.expect("WHERE"); err != nil {
+			return nil, err
+		}
+		u.Where, err = p.parseBoolExpr()
+		if err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+
+	if p.eat("INSERT") {
+		p.eat("INTO") // optional
+		tname, err := p.parseTableOrIndexOrColumnName()
+		if err != nil {
+			return nil, err
+		}
+		ins := Insert{Table: tname}
+		ins.Columns, err = p.parseColumnNameList()
+		if err != nil {
+			return nil, err
+		}
+		if p.sniff("SELECT") {
+			ins.Input, err = p.parseQuery()
+			if err != nil {
+				return nil, err
+			}
+			return ins, nil
+		}
+		if err := p.expect("VALUES"); err != nil {
+			return nil, err
+		}
+		var vals Values
+		for {
+			var row []Expr
+			err := p.parseCommaList(func(p *parser) error {
+				e, err := p.parseExprOrDefault()
+				if err != nil {
+					return err
+				}
+				row = append(row, e)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			if len(row) != len(ins.Columns) {
+				return nil, p.errorf("got %d values in VALUES row, want %d", len(row), len(ins.Columns))
+			}
+			vals = append(vals, row)
+			if !p.eat(",") {
+				break
+			}
+		}
+		ins.Input = vals
+		return ins, nil
+	}
+
+	return nil, p.errorf("unknown DML statement")
+}
+
+func (p *parser) parseUpdateItem() (UpdateItem, error) {
+	col, err := p.parseTableOrIndexOrColumnName()
+	if err != nil {
+		return UpdateItem{}, err
+	}
+	ui := UpdateItem{Column: col}
+	if err := p.expect("="); err != nil {
+		return UpdateItem{}, err
+	}
+	ui.Value, err = p.parseExprOrDefault()
+	if err != nil {
+		return UpdateItem{}, err
+	}
+	return ui, nil
+}
+
+// parseExprOrDefault parses an expression in a DML statement,
+// returning a nil Expr for DEFAULT.
+func (p *parser) parseExprOrDefault() (Expr, error) {
+	if p.eat("DEFAULT") {
+		return nil, nil
+	}
+	return p.parseExpr()
+}
+
 func (p *parser) parseQuery() (Query, error) {
 	debugf("parseQuery: %v", p)
 
@@ -1183,7 +1616,7 @@ func (p *parser) parseQuery() (Query, error) {
 			[ LIMIT count [ OFFSET skip_rows ] ]
 	*/
 
-	// TODO: hints, sub-selects, etc.
+	// TODO: hints, parenthesized query expressions.
 
 	// TODO: use a case-insensitive select.
 	if err := p.expect("SELECT"); err != nil {
@@ -1196,6 +1629,20 @@ func (p *parser) parseQuery() (Query, error) {
 	}
 	q := Query{Select: sel}
 
+	for {
+		so, ok, err := p.parseSetOp()
+		if err != nil {
+			return Query{}, err
+		}
+		if !ok {
+			break
+		}
+		if len(q.SetOps) > 0 && (so.Op != q.SetOps[0].Op || so.All != q.SetOps[0].All) {
+			return Query{}, p.errorf("mixing different set operations requires parentheses, which are not supported")
+		}
+		q.SetOps = append(q.SetOps, so)
+	}
+
 	if p.eat("ORDER", "BY") {
 		for {
 			o, err := p.parseOrder()
@@ -1221,6 +1668,51 @@ func (p *parser) parseQuery() (Query, error) {
 	return q, nil
 }
 
+var setOperators = map[string]SetOperator{
+	"UNION":     Union,
+	"INTERSECT": Intersect,
+	"EXCEPT":    Except,
+}
+
+// parseSetOp parses a set operation and the SELECT that follows it,
+// reporting whether there was one.
+func (p *parser) parseSetOp() (SetOp, bool, error) {
+	/*
+		set_op:
+			UNION { ALL | DISTINCT } | INTERSECT { ALL | DISTINCT } | EXCEPT { ALL | DISTINCT }
+	*/
+
+	tok := p.next()
+	if tok.err != nil {
+		p.back()
+		return SetOp{}, false, nil
+	}
+	op, ok := setOperators[tok.value]
+	if !ok {
+		p.back()
+		return SetOp{}, false, nil
+	}
+	so := SetOp{Op: op}
+
+	tok = p.next()
+	switch {
+	case tok.err != nil:
+		return SetOp{}, false, tok.err
+	case tok.value == "ALL":
+		so.All = true
+	case tok.value == "DISTINCT":
+	default:
+		return SetOp{}, false, p.errorf("got %q, want ALL or DISTINCT", tok.value)
+	}
+
+	sel, err := p.parseSelect()
+	if err != nil {
+		return SetOp{}, false, err
+	}
+	so.Select = sel
+	return so, true, nil
+}
+
 func (p *parser) parseSelect() (Select, error) {
 	debugf("parseSelect: %v", p)
 
@@ -1261,13 +1753,6 @@ func (p *parser) parseSelect() (Select, error) {
 			if err != nil {
 				return Select{}, err
 			}
-			if p.sniff("TABLESAMPLE") {
-				ts, err := p.parseTableSample()
-				if err != nil {
-					return Select{}, err
-				}
-				from.TableSample = &ts
-			}
 			sel.From = append(sel.From, from)
 
 			if p.eat(",") {
@@ -1285,26 +1770,293 @@ func (p *parser) parseSelect() (Select, error) {
 		sel.Where = where
 	}
 
-	// TODO: GROUP BY, HAVING
+	if p.eat("GROUP", "BY") {
+		list, err := p.parseGroupByList()
+		if err != nil {
+			return Select{}, err
+		}
+		sel.GroupBy = list
+	}
 
-	return sel, nil
-}
+	if p.eat("HAVING") {
+		having, err := p.parseBoolExpr()
+		if err != nil {
+			return Select{}, err
+		}
+		sel.Having = having
+	}
 
-func (p *parser) parseSelectFrom() (SelectFrom, error) {
-	// TODO: support more than a single table name.
-	tname, err := p.parseTableOrIndexOrColumnName()
-	return SelectFrom{Table: tname}, err
+	return sel, nil
 }
 
-func (p *parser) parseTableSample() (TableSample, error) {
-	var ts TableSample
+func (p *parser) parseGroupByList() ([]Expr, error) {
+	var list []Expr
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
 
-	if err := p.expect("TABLESAMPLE"); err != nil {
-		return ts, err
+		if !p.eat(",") {
+			return list, nil
+		}
 	}
+}
 
-	tok := p.next()
-	switch {
+func (p *parser) parseSelectFrom() (SelectFrom, error) {
+	debugf("parseSelectFrom: %v", p)
+
+	/*
+		from_item: {
+			table_name [ table_hint_expr ] [ [ AS ] alias ] [ tablesample_type ] |
+			join |
+			( query_expr ) [ [ AS ] alias ] |
+			( from_item ) |
+			UNNEST( array_expression ) [ [ AS ] alias ]
+		}
+
+		join:
+			from_item [ join_type ] JOIN from_item
+			[ ON bool_expression | USING ( join_column [, ...] ) ]
+
+		join_type:
+			{ INNER | CROSS | FULL [OUTER] | LEFT [OUTER] | RIGHT [OUTER] }
+
+		table_hint_expr:
+			@{ table_hint_key = table_hint_value [, ...] }
+	*/
+
+	// TODO: join hints, UNNEST with OFFSET.
+
+	sf, err := p.parseSelectFromItem()
+	if err != nil {
+		return nil, err
+	}
+
+	// Joins are left associative.
+	for {
+		jt, ok, err := p.parseJoinType()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return sf, nil
+		}
+		rhs, err := p.parseSelectFromItem()
+		if err != nil {
+			return nil, err
+		}
+		sfj := SelectFromJoin{Type: jt, LHS: sf, RHS: rhs}
+
+		if jt != CrossJoin {
+			if p.eat("ON") {
+				on, err := p.parseBoolExpr()
+				if err != nil {
+					return nil, err
+				}
+				sfj.On = on
+			} else if p.eat("USING") {
+				using, err := p.parseColumnNameList()
+				if err != nil {
+					return nil, err
+				}
+				sfj.Using = using
+			} else if _, ok := rhs.(SelectFromUnnest); !ok {
+				// A correlated UNNEST may be joined without a condition.
+				return nil, p.errorf("join requires ON or USING")
+			}
+		}
+		sf = sfj
+	}
+}
+
+// parseSelectFromItem parses a single from_item that isn't a join.
+func (p *parser) parseSelectFromItem() (SelectFrom, error) {
+	if p.sniff("(", "SELECT") {
+		q, err := p.parseSubquery()
+		if err != nil {
+			return nil, err
+		}
+		alias, err := p.parseAlias()
+		if err != nil {
+			return nil, err
+		}
+		return SelectFromSubquery{Query: q, Alias: alias}, nil
+	}
+	if p.eat("UNNEST") {
+		e, err := p.parseUnnestArg()
+		if err != nil {
+			return nil, err
+		}
+		alias, err := p.parseAlias()
+		if err != nil {
+			return nil, err
+		}
+		return SelectFromUnnest{Expr: e, Alias: alias}, nil
+	}
+	if p.eat("(") {
+		sf, err := p.parseSelectFrom()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return sf, nil
+	}
+
+	tname, err := p.parseTableOrIndexOrColumnName()
+	if err != nil {
+		return nil, err
+	}
+	sft := SelectFromTable{Table: tname}
+	if p.eat("@") {
+		sft.Hints, err = p.parseHints()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sft.Alias, err = p.parseAlias()
+	if err != nil {
+		return nil, err
+	}
+	if p.sniff("TABLESAMPLE") {
+		ts, err := p.parseTableSample()
+		if err != nil {
+			return nil, err
+		}
+		sft.TableSample = &ts
+	}
+	return sft, nil
+}
+
+var joinKeywords = map[string]JoinType{
+	"INNER": InnerJoin,
+	"CROSS": CrossJoin,
+	"FULL":  FullJoin,
+	"LEFT":  LeftJoin,
+	"RIGHT": RightJoin,
+}
+
+// parseJoinType parses the keywords introducing a join, reporting whether there were any.
+func (p *parser) parseJoinType() (JoinType, bool, error) {
+	if p.eat("JOIN") {
+		return InnerJoin, true, nil
+	}
+	tok := p.next()
+	if tok.err != nil {
+		p.back()
+		return 0, false, nil
+	}
+	jt, ok := joinKeywords[tok.value]
+	if !ok {
+		p.back()
+		return 0, false, nil
+	}
+	if jt == FullJoin || jt == LeftJoin || jt == RightJoin {
+		p.eat("OUTER")
+	}
+	if err := p.expect("JOIN"); err != nil {
+		return 0, false, err
+	}
+	return jt, true, nil
+}
+
+// parseAlias parses an optional alias, as might follow a table name or subquery.
+// It returns the empty string if there isn't one.
+// parseHints parses the braced list of hints following an "@".
+func (p *parser) parseHints() (map[string]string, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	hints := make(map[string]string)
+	for {
+		key, err := p.parseTableOrIndexOrColumnName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		tok := p.next()
+		if tok.err != nil {
+			return nil, tok.err
+		}
+		hints[strings.ToUpper(key)] = tok.value
+		if p.eat("}") {
+			return hints, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseAlias() (string, error) {
+	/*
+		[ AS ] alias
+	*/
+
+	if p.eat("AS") {
+		tok := p.next()
+		if tok.err != nil {
+			return "", tok.err
+		}
+		if !isInitialIdentifierChar(tok.value[0]) || keywords[strings.ToUpper(tok.value)] {
+			return "", p.errorf("got %q, want alias", tok.value)
+		}
+		return tok.value, nil
+	}
+
+	// Without AS, an alias is any identifier that isn't a reserved keyword.
+	tok := p.next()
+	if tok.err != nil || tok.typ != unknownToken || !isInitialIdentifierChar(tok.value[0]) || keywords[strings.ToUpper(tok.value)] {
+		p.back()
+		return "", nil
+	}
+	return tok.value, nil
+}
+
+// parseUnnestArg parses the parenthesized array expression following UNNEST.
+func (p *parser) parseUnnestArg() (Expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseSubquery parses a parenthesized query.
+func (p *parser) parseSubquery() (Query, error) {
+	if err := p.expect("("); err != nil {
+		return Query{}, err
+	}
+	q, err := p.parseQuery()
+	if err != nil {
+		return Query{}, err
+	}
+	if err := p.expect(")"); err != nil {
+		return Query{}, err
+	}
+	return q, nil
+}
+
+func (p *parser) parseTableSample() (TableSample, error) {
+	var ts TableSample
+
+	if err := p.expect("TABLESAMPLE"); err != nil {
+		return ts, err
+	}
+
+	tok := p.next()
+	switch {
 	case tok.err != nil:
 		return ts, tok.err
 	case tok.value == "BERNOULLI":
@@ -1415,10 +2167,10 @@ ascending order of precedence:
 	andParser
 	parseIsOp
 	parseComparisonOp
-	parseArithOp
+	parseArithOp (one level per entry in arithLevels)
+	parseUnaryArithOp
+	parsePrimary
 	parseLit
-
-TODO: there are more levels to break out, esp. in parseArithOp
 */
 
 func (p *parser) parseExpr() (Expr, error) {
@@ -1569,7 +2321,7 @@ func (p *parser) parseComparisonOp() (Expr, error) {
 			break
 		}
 		var op ComparisonOperator
-		var ok, rhs2 bool
+		var ok, rhs2, in, neg bool
 		if tok.value == "NOT" {
 			tok := p.next()
 			switch {
@@ -1580,17 +2332,29 @@ func (p *parser) parseComparisonOp() (Expr, error) {
 				op, ok = NotLike, true
 			case tok.value == "BETWEEN":
 				op, ok, rhs2 = NotBetween, true, true
+			case tok.value == "IN":
+				in, neg = true, true
 			default:
 				// TODO: Does this need to push back two?
-				return nil, p.errorf("got %q, want LIKE or BETWEEN", tok.value)
+				return nil, p.errorf("got %q, want LIKE, BETWEEN or IN", tok.value)
 			}
 		} else if tok.value == "LIKE" {
 			op, ok = Like, true
 		} else if tok.value == "BETWEEN" {
 			op, ok, rhs2 = Between, true, true
+		} else if tok.value == "IN" {
+			in = true
 		} else {
 			op, ok = symbolicOperators[tok.value]
 		}
+		if in {
+			io, err := p.parseInOp(expr, neg)
+			if err != nil {
+				return nil, err
+			}
+			expr = io
+			continue
+		}
 		if !ok {
 			p.back()
 			break
@@ -1618,8 +2382,124 @@ func (p *parser) parseComparisonOp() (Expr, error) {
 	return expr, nil
 }
 
+// parseInOp parses the remainder of an IN expression after the IN keyword.
+func (p *parser) parseInOp(lhs Expr, neg bool) (InOp, error) {
+	io := InOp{LHS: lhs, Neg: neg}
+	if p.eat("UNNEST") {
+		e, err := p.parseUnnestArg()
+		if err != nil {
+			return InOp{}, err
+		}
+		io.RHS, io.Unnest = []Expr{e}, true
+		return io, nil
+	}
+	if p.sniff("(", "SELECT") {
+		q, err := p.parseSubquery()
+		if err != nil {
+			return InOp{}, err
+		}
+		io.Subquery = &q
+		return io, nil
+	}
+	err := p.parseCommaList(func(p *parser) error {
+		e, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		io.RHS = append(io.RHS, e)
+		return nil
+	})
+	if err != nil {
+		return InOp{}, err
+	}
+	if len(io.RHS) == 0 {
+		return InOp{}, p.errorf("IN requires at least one value")
+	}
+	return io, nil
+}
+
+// arithLevels lists the binary arithmetic operators at each precedence level,
+// in ascending order of precedence.
+var arithLevels = []map[string]ArithOperator{
+	{"|": BitOr},
+	{"^": BitXor},
+	{"&": BitAnd},
+	{"<<": BitShl, ">>": BitShr},
+	{"+": Add, "-": Sub},
+	{"*": Mul, "/": Div, "||": Concat},
+}
+
 func (p *parser) parseArithOp() (Expr, error) {
-	// TODO: actually parse arithmetic operations.
+	return p.parseArithLevel(0)
+}
+
+func (p *parser) parseArithLevel(level int) (Expr, error) {
+	if level == len(arithLevels) {
+		return p.parseUnaryArithOp()
+	}
+
+	// All the binary arithmetic operators are left associative.
+	expr, err := p.parseArithLevel(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.next()
+		if tok.err != nil {
+			p.back()
+			return expr, nil
+		}
+		op, ok := arithLevels[level][tok.value]
+		if !ok {
+			p.back()
+			return expr, nil
+		}
+		rhs, err := p.parseArithLevel(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		expr = ArithOp{LHS: expr, Op: op, RHS: rhs}
+	}
+}
+
+var unaryArithOperators = map[string]ArithOperator{
+	"-": Neg,
+	"+": Plus,
+	"~": BitNot,
+}
+
+func (p *parser) parseUnaryArithOp() (Expr, error) {
+	tok := p.next()
+	if tok.err == nil {
+		if op, ok := unaryArithOperators[tok.value]; ok {
+			e, err := p.parseUnaryArithOp()
+			if err != nil {
+				return nil, err
+			}
+			return ArithOp{Op: op, RHS: e}, nil
+		}
+	}
+	p.back()
+	return p.parsePrimary()
+}
+
+// parsePrimary parses an operand of the arithmetic operators:
+// a literal, identifier, parenthesized expression, function call, etc.
+func (p *parser) parsePrimary() (Expr, error) {
+	if p.sniff("(", "SELECT") {
+		q, err := p.parseSubquery()
+		if err != nil {
+			return nil, err
+		}
+		return ScalarSubquery{Query: q}, nil
+	}
+	if p.eat("EXISTS") {
+		q, err := p.parseSubquery()
+		if err != nil {
+			return nil, err
+		}
+		return ExistsOp{Query: q}, nil
+	}
 
 	if p.eat("(") {
 		e, err := p.parseExpr()
@@ -1632,6 +2512,41 @@ func (p *parser) parseArithOp() (Expr, error) {
 		return Paren{Expr: e}, nil
 	}
 
+	if p.sniff("CASE") {
+		return p.parseCase()
+	}
+	if p.sniff("[") || p.sniff("ARRAY", "[") {
+		return p.parseArrayLit()
+	}
+	if p.eat("INTERVAL") {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		part, err := p.parseDatePart()
+		if err != nil {
+			return nil, err
+		}
+		return IntervalExpr{Expr: e, DatePart: part}, nil
+	}
+
+	// Some functions have special argument syntax.
+	if p.sniff("CAST", "(") || p.sniff("SAFE_CAST", "(") {
+		return p.parseCast()
+	}
+	if p.sniff("EXTRACT", "(") {
+		return p.parseExtract()
+	}
+
+	// DATE and TIMESTAMP are also functions, so they start a literal
+	// only when followed by a string.
+	if p.sniffTypedLit("DATE") {
+		return p.parseDateLit()
+	}
+	if p.sniffTypedLit("TIMESTAMP") {
+		return p.parseTimestampLit()
+	}
+
 	lit, err := p.parseLit()
 	if err != nil {
 		return nil, err
@@ -1640,19 +2555,207 @@ func (p *parser) parseArithOp() (Expr, error) {
 	// If the literal was an identifier, and there's an open paren next,
 	// this is a function invocation.
 	if id, ok := lit.(ID); ok && p.sniff("(") {
-		list, err := p.parseExprList()
+		f := Func{Name: string(id)}
+		err := p.parseCommaList(func(p *parser) error {
+			// Aggregate functions may have DISTINCT before their argument.
+			if len(f.Args) == 0 && p.eat("DISTINCT") {
+				f.Distinct = true
+			}
+			e, err := p.parseExpr()
+			if err != nil {
+				return err
+			}
+			f.Args = append(f.Args, e)
+			return nil
+		})
 		if err != nil {
 			return nil, err
 		}
-		return Func{
-			Name: string(id),
-			Args: list,
-		}, nil
+		return f, nil
+	}
+
+	// If the literal was an identifier, and there's a dot next,
+	// this is a path expression.
+	if id, ok := lit.(ID); ok && p.sniff(".") {
+		pe := PathExp{id}
+		for p.eat(".") {
+			tok := p.next()
+			if tok.err != nil {
+				return nil, tok.err
+			}
+			if tok.typ != unknownToken || !isInitialIdentifierChar(tok.value[0]) {
+				return nil, p.errorf("got %q, want identifier after dot", tok.value)
+			}
+			pe = append(pe, ID(tok.value))
+		}
+		return pe, nil
 	}
 
 	return lit, nil
 }
 
+func (p *parser) parseCase() (Case, error) {
+	/*
+		CASE [ expr ]
+			WHEN expr THEN result
+			[ ... ]
+			[ ELSE else_result ]
+		END
+	*/
+
+	if err := p.expect("CASE"); err != nil {
+		return Case{}, err
+	}
+	var c Case
+	if !p.sniff("WHEN") {
+		e, err := p.parseExpr()
+		if err != nil {
+			return Case{}, err
+		}
+		c.Expr = e
+	}
+	for p.eat("WHEN") {
+		cond, err := p.parseExpr()
+		if err != nil {
+			return Case{}, err
+		}
+		if err := p.expect("THEN"); err != nil {
+			return Case{}, err
+		}
+		result, err := p.parseExpr()
+		if err != nil {
+			return Case{}, err
+		}
+		c.WhenClauses = append(c.WhenClauses, WhenClause{Cond: cond, Result: result})
+	}
+	if len(c.WhenClauses) == 0 {
+		return Case{}, p.errorf("CASE requires at least one WHEN clause")
+	}
+	if p.eat("ELSE") {
+		e, err := p.parseExpr()
+		if err != nil {
+			return Case{}, err
+		}
+		c.ElseResult = e
+	}
+	if err := p.expect("END"); err != nil {
+		return Case{}, err
+	}
+	return c, nil
+}
+
+func (p *parser) parseArrayLit() (Array, error) {
+	/*
+		[ ARRAY ] [ expr [, ...] ]
+	*/
+
+	// TODO: typed array literals, such as ARRAY<INT64>[].
+	p.eat("ARRAY")
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	arr := Array{}
+	if p.eat("]") {
+		return arr, nil
+	}
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, e)
+
+		tok := p.next()
+		if tok.err != nil {
+			return nil, tok.err
+		}
+		if tok.value == "]" {
+			return arr, nil
+		} else if tok.value != "," {
+			return nil, p.errorf(`got %q, want "]" or ","`, tok.value)
+		}
+	}
+}
+
+func (p *parser) parseCast() (Func, error) {
+	/*
+		{ CAST | SAFE_CAST } ( expr AS type )
+	*/
+
+	tok := p.next()
+	if tok.err != nil {
+		return Func{}, tok.err
+	}
+	f := Func{Name: tok.value}
+	if err := p.expect("("); err != nil {
+		return Func{}, err
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return Func{}, err
+	}
+	if err := p.expect("AS"); err != nil {
+		return Func{}, err
+	}
+	t, err := p.parseTypeLen(false)
+	if err != nil {
+		return Func{}, err
+	}
+	if err := p.expect(")"); err != nil {
+		return Func{}, err
+	}
+	f.Args = []Expr{TypedExpr{Expr: e, Type: t}}
+	return f, nil
+}
+
+func (p *parser) parseExtract() (Func, error) {
+	/*
+		EXTRACT ( part FROM expr [ AT TIME ZONE tz ] )
+	*/
+
+	if err := p.expect("EXTRACT"); err != nil {
+		return Func{}, err
+	}
+	if err := p.expect("("); err != nil {
+		return Func{}, err
+	}
+	part, err := p.parseDatePart()
+	if err != nil {
+		return Func{}, err
+	}
+	if err := p.expect("FROM"); err != nil {
+		return Func{}, err
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return Func{}, err
+	}
+	ee := ExtractExpr{Part: part, Expr: e}
+	if p.eat("AT", "TIME", "ZONE") {
+		tz, err := p.parseExpr()
+		if err != nil {
+			return Func{}, err
+		}
+		ee.TimeZone = tz
+	}
+	if err := p.expect(")"); err != nil {
+		return Func{}, err
+	}
+	return Func{Name: "EXTRACT", Args: []Expr{ee}}, nil
+}
+
+// parseDatePart parses the name of a date or time part, such as DAY.
+func (p *parser) parseDatePart() (string, error) {
+	tok := p.next()
+	if tok.err != nil {
+		return "", tok.err
+	}
+	if tok.typ != unknownToken || !isInitialIdentifierChar(tok.value[0]) {
+		return "", p.errorf("got %q, want date part", tok.value)
+	}
+	return strings.ToUpper(tok.value), nil
+}
+
 func (p *parser) parseLit() (Expr, error) {
 	tok := p.next()
 	if tok.err != nil {
@@ -1683,7 +2786,7 @@ func (p *parser) parseLit() (Expr, error) {
 		return Star, nil
 	}
 
-	// TODO: more types of literals (array, struct, date, timestamp).
+	// TODO: more types of literals (struct).
 
 	// Try a parameter.
 	// TODO: check character sets.
@@ -1693,6 +2796,126 @@ func (p *parser) parseLit() (Expr, error) {
 	return ID(tok.value), nil
 }
 
+// sniffTypedLit reports whether the next tokens are a literal of the named
+// type, such as DATE '2020-03-04'.
+func (p *parser) sniffTypedLit(typ string) bool {
+	// Store current parser state and restore on the way out.
+	orig := *p
+	defer func() { *p = orig }()
+
+	if tok := p.next(); tok.err != nil || tok.value != typ {
+		return false
+	}
+	tok := p.next()
+	return tok.err == nil && tok.typ == stringToken
+}
+
+func (p *parser) parseDateLit() (Expr, error) {
+	/*
+		DATE 'YYYY-[M]M-[D]D'
+	*/
+
+	if err := p.expect("DATE"); err != nil {
+		return nil, err
+	}
+	tok := p.next()
+	if tok.err != nil {
+		return nil, tok.err
+	}
+	m := dateLitRE.FindStringSubmatch(tok.string)
+	if m == nil {
+		return nil, p.errorf("invalid DATE literal %q", tok.string)
+	}
+	d, ok := civilDate(m[1], m[2], m[3])
+	if !ok {
+		return nil, p.errorf("invalid DATE literal %q", tok.string)
+	}
+	return DateLiteral(d), nil
+}
+
+var (
+	dateLitRE      = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})$`)
+	timestampLitRE = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})(?:[Tt ](\d{1,2}):(\d{1,2}):(\d{1,2})(?:\.(\d{1,9}))?)?(?: ?(\S+))?$`)
+	tzOffsetRE     = regexp.MustCompile(`^([+-])(\d{1,2})(?::(\d{2}))?$`)
+)
+
+// civilDate returns the date with the given year, month and day,
+// and whether it is valid.
+func civilDate(year, month, day string) (civil.Date, bool) {
+	y, _ := strconv.Atoi(year)
+	m, _ := strconv.Atoi(month)
+	d, _ := strconv.Atoi(day)
+	cd := civil.Date{Year: y, Month: time.Month(m), Day: d}
+	return cd, cd.IsValid()
+}
+
+// defaultTimeZone is the time zone of a timestamp literal that doesn't specify one.
+const defaultTimeZone = "America/Los_Angeles"
+
+func (p *parser) parseTimestampLit() (Expr, error) {
+	/*
+		TIMESTAMP 'YYYY-[M]M-[D]D [[H]H:[M]M:[S]S[.DDDDDDDDD]] [time zone]'
+	*/
+
+	if err := p.expect("TIMESTAMP"); err != nil {
+		return nil, err
+	}
+	tok := p.next()
+	if tok.err != nil {
+		return nil, tok.err
+	}
+	s := tok.string
+	m := timestampLitRE.FindStringSubmatch(s)
+	if m == nil {
+		return nil, p.errorf("invalid TIMESTAMP literal %q", s)
+	}
+	d, ok := civilDate(m[1], m[2], m[3])
+	if !ok {
+		return nil, p.errorf("invalid TIMESTAMP literal %q", s)
+	}
+	var clock [3]int // hour, minute, second
+	for i := range clock {
+		clock[i], _ = strconv.Atoi(m[i+4]) // may be empty
+	}
+	if clock[0] > 23 || clock[1] > 59 || clock[2] > 59 {
+		return nil, p.errorf("invalid TIMESTAMP literal %q", s)
+	}
+	var ns int
+	if m[7] != "" {
+		ns, _ = strconv.Atoi((m[7] + "00000000")[:9])
+	}
+	loc, err := timeZone(m[8])
+	if err != nil {
+		return nil, p.errorf("invalid TIMESTAMP literal %q: %v", s, err)
+	}
+	t := time.Date(d.Year, d.Month, d.Day, clock[0], clock[1], clock[2], ns, loc)
+	return TimestampLiteral(t.UTC()), nil
+}
+
+// timeZone returns the time zone of a timestamp literal, which may be a name
+// from the tz database, such as "America/New_York", or an offset from UTC,
+// such as "-08" or "+05:30".
+func timeZone(tz string) (*time.Location, error) {
+	switch tz {
+	case "":
+		tz = defaultTimeZone
+	case "Z", "z", "UTC":
+		return time.UTC, nil
+	case "Local":
+		return nil, fmt.Errorf("unknown time zone %s", tz)
+	}
+	if m := tzOffsetRE.FindStringSubmatch(tz); m != nil {
+		h, _ := strconv.Atoi(m[2])
+		min, _ := strconv.Atoi(m[3]) // may be empty
+		off := (h*60 + min) * 60
+		if m[1] == "-" {
+			off = -off
+		}
+		return time.FixedZone(tz, off), nil
+	}
+	return time.LoadLocation(tz)
+}
+
 func (p *parser) parseBoolExpr() (BoolExpr, error) {
 	expr, err := p.parseExpr()
 	if err != nil {
@@ -21,6 +21,9 @@ import (
 	"math"
 	"reflect"
 	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
 )
 
 func TestParseQuery(t *testing.T) {
@@ -33,7 +36,7 @@ func TestParseQuery(t *testing.T) {
 			Query{
 				Select: Select{
 					List: []Expr{ID("Alias")},
-					From: []SelectFrom{{
+					From: []SelectFrom{SelectFromTable{
 						Table: "Characters",
 					}},
 					Where: LogicalOp{
@@ -66,7 +69,115 @@ func TestParseQuery(t *testing.T) {
 							Args: []Expr{Star},
 						},
 					},
-					From: []SelectFrom{{Table: "Packages"}},
+					From: []SelectFrom{SelectFromTable{Table: "Packages"}},
+				},
+			},
+		},
+		{`SELECT Kind, COUNT(DISTINCT Owner), MAX(Size) FROM Packages WHERE Size > 0 GROUP BY Kind, Owner HAVING COUNT(*) > 2`,
+			Query{
+				Select: Select{
+					List: []Expr{
+						ID("Kind"),
+						Func{Name: "COUNT", Args: []Expr{ID("Owner")}, Distinct: true},
+						Func{Name: "MAX", Args: []Expr{ID("Size")}},
+					},
+					From:    []SelectFrom{SelectFromTable{Table: "Packages"}},
+					Where:   ComparisonOp{LHS: ID("Size"), Op: Gt, RHS: IntegerLiteral(0)},
+					GroupBy: []Expr{ID("Kind"), ID("Owner")},
+					Having: ComparisonOp{
+						LHS: Func{Name: "COUNT", Args: []Expr{Star}},
+						Op:  Gt,
+						RHS: IntegerLiteral(2),
+					},
+				},
+			},
+		},
+		{`SELECT s.Name, a.Title FROM Singers AS s LEFT OUTER JOIN Albums a ON s.SingerId = a.SingerId JOIN Tracks USING (AlbumId) CROSS JOIN Labels`,
+			Query{
+				Select: Select{
+					List: []Expr{PathExp{"s", "Name"}, PathExp{"a", "Title"}},
+					From: []SelectFrom{SelectFromJoin{
+						Type: CrossJoin,
+						LHS: SelectFromJoin{
+							Type: InnerJoin,
+							LHS: SelectFromJoin{
+								Type: LeftJoin,
+								LHS:  SelectFromTable{Table: "Singers", Alias: "s"},
+								RHS:  SelectFromTable{Table: "Albums", Alias: "a"},
+								On: ComparisonOp{
+									LHS: PathExp{"s", "SingerId"},
+									Op:  Eq,
+									RHS: PathExp{"a", "SingerId"},
+								},
+							},
+							RHS:   SelectFromTable{Table: "Tracks"},
+							Using: []string{"AlbumId"},
+						},
+						RHS: SelectFromTable{Table: "Labels"},
+					}},
+				},
+			},
+		},
+		{`SELECT Name FROM Singers WHERE SingerId IN (SELECT SingerId FROM Albums) UNION ALL SELECT Name FROM (SELECT Name FROM Labels) l ORDER BY Name`,
+			Query{
+				Select: Select{
+					List: []Expr{ID("Name")},
+					From: []SelectFrom{SelectFromTable{Table: "Singers"}},
+					Where: InOp{
+						LHS: ID("SingerId"),
+						Subquery: &Query{
+							Select: Select{
+								List: []Expr{ID("SingerId")},
+								From: []SelectFrom{SelectFromTable{Table: "Albums"}},
+							},
+						},
+					},
+				},
+				SetOps: []SetOp{{
+					Op:  Union,
+					All: true,
+					Select: Select{
+						List: []Expr{ID("Name")},
+						From: []SelectFrom{SelectFromSubquery{
+							Query: Query{
+								Select: Select{
+									List: []Expr{ID("Name")},
+									From: []SelectFrom{SelectFromTable{Table: "Labels"}},
+								},
+							},
+							Alias: "l",
+						}},
+					},
+				}},
+				Order: []Order{{Expr: ID("Name")}},
+			},
+		},
+		{`SELECT K, a FROM T LEFT JOIN UNNEST(T.Arr) AS a`,
+			Query{
+				Select: Select{
+					List: []Expr{ID("K"), ID("a")},
+					From: []SelectFrom{SelectFromJoin{
+						Type: LeftJoin,
+						LHS:  SelectFromTable{Table: "T"},
+						RHS:  SelectFromUnnest{Expr: PathExp{"T", "Arr"}, Alias: "a"},
+					}},
+				},
+			},
+		},
+		{`SELECT s.Name FROM Singers@{force_index=SingersByName} s WHERE s.Name = "x"`,
+			Query{
+				Select: Select{
+					List: []Expr{PathExp{"s", "Name"}},
+					From: []SelectFrom{SelectFromTable{
+						Table: "Singers",
+						Hints: map[string]string{"FORCE_INDEX": "SingersByName"},
+						Alias: "s",
+					}},
+					Where: ComparisonOp{
+						LHS: PathExp{"s", "Name"},
+						Op:  Eq,
+						RHS: StringLiteral("x"),
+					},
 				},
 			},
 		},
@@ -182,9 +293,87 @@ func TestParseExpr(t *testing.T) {
 			},
 		},
 
+		// This used to be broken because the lexer didn't record the value of string tokens.
+		{`("foo")`, Paren{Expr: StringLiteral("foo")}},
+
 		// Reserved keywords.
 		{`TRUE AND FALSE`, LogicalOp{LHS: True, Op: And, RHS: False}},
 		{`NULL`, Null},
+
+		// Path expressions and subqueries.
+		{`s.Name = "Pat"`, ComparisonOp{LHS: PathExp{"s", "Name"}, Op: Eq, RHS: StringLiteral("Pat")}},
+		{`Id NOT IN (1, 2, 3)`, InOp{LHS: ID("Id"), Neg: true, RHS: []Expr{IntegerLiteral(1), IntegerLiteral(2), IntegerLiteral(3)}}},
+		{`NOT EXISTS (SELECT 1 FROM T WHERE T.Id = Id)`,
+			LogicalOp{
+				Op: Not,
+				RHS: ExistsOp{Query: Query{Select: Select{
+					List:  []Expr{IntegerLiteral(1)},
+					From:  []SelectFrom{SelectFromTable{Table: "T"}},
+					Where: ComparisonOp{LHS: PathExp{"T", "Id"}, Op: Eq, RHS: ID("Id")},
+				}}},
+			},
+		},
+		// Arithmetic operators and precedence.
+		{`A + B * C - 1`,
+			ArithOp{
+				LHS: ArithOp{LHS: ID("A"), Op: Add, RHS: ArithOp{LHS: ID("B"), Op: Mul, RHS: ID("C")}},
+				Op:  Sub,
+				RHS: IntegerLiteral(1),
+			},
+		},
+		{`A-1`, ArithOp{LHS: ID("A"), Op: Sub, RHS: IntegerLiteral(1)}},
+		{`-A`, ArithOp{Op: Neg, RHS: ID("A")}},
+		{`-7 < A`, ComparisonOp{LHS: IntegerLiteral(-7), Op: Lt, RHS: ID("A")}},
+		{`(A)-7`, ArithOp{LHS: Paren{Expr: ID("A")}, Op: Sub, RHS: IntegerLiteral(7)}},
+		{`~A & B | C ^ D << 2`,
+			ArithOp{
+				LHS: ArithOp{LHS: ArithOp{Op: BitNot, RHS: ID("A")}, Op: BitAnd, RHS: ID("B")},
+				Op:  BitOr,
+				RHS: ArithOp{LHS: ID("C"), Op: BitXor, RHS: ArithOp{LHS: ID("D"), Op: BitShl, RHS: IntegerLiteral(2)}},
+			},
+		},
+		{`"a" || B / 2`, ArithOp{LHS: ArithOp{LHS: StringLiteral("a"), Op: Concat, RHS: ID("B")}, Op: Div, RHS: IntegerLiteral(2)}},
+
+		// Functions with special argument syntax.
+		{`CAST(A AS STRING)`, Func{Name: "CAST", Args: []Expr{TypedExpr{Expr: ID("A"), Type: Type{Base: String}}}}},
+		{`SAFE_CAST(A AS ARRAY<BYTES(10)>)`, Func{Name: "SAFE_CAST", Args: []Expr{TypedExpr{Expr: ID("A"), Type: Type{Array: true, Base: Bytes, Len: 10}}}}},
+		{`EXTRACT(day FROM T AT TIME ZONE "UTC")`, Func{Name: "EXTRACT", Args: []Expr{ExtractExpr{Part: "DAY", Expr: ID("T"), TimeZone: StringLiteral("UTC")}}}},
+		{`TIMESTAMP_ADD(T, INTERVAL 5 MINUTE)`, Func{Name: "TIMESTAMP_ADD", Args: []Expr{ID("T"), IntervalExpr{Expr: IntegerLiteral(5), DatePart: "MINUTE"}}}},
+
+		// Date and timestamp literals.
+		{`DATE '2020-03-04'`, DateLiteral(civil.Date{Year: 2020, Month: 3, Day: 4})},
+		{`DATE "2020-3-4"`, DateLiteral(civil.Date{Year: 2020, Month: 3, Day: 4})},
+		{`DATE('2020-03-04')`, Func{Name: "DATE", Args: []Expr{StringLiteral("2020-03-04")}}},
+		{`TIMESTAMP '2020-03-04 05:06:07.5 UTC'`, TimestampLiteral(time.Date(2020, 3, 4, 5, 6, 7, 5e8, time.UTC))},
+		{`TIMESTAMP '2020-03-04T05:06:07Z'`, TimestampLiteral(time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC))},
+		{`TIMESTAMP '2020-03-04 05:06:07-08'`, TimestampLiteral(time.Date(2020, 3, 4, 13, 6, 7, 0, time.UTC))},
+		{`TIMESTAMP '2020-03-04 05:06:07 +05:30'`, TimestampLiteral(time.Date(2020, 3, 3, 23, 36, 7, 0, time.UTC))},
+		// The default time zone is America/Los_Angeles, which is UTC-8 in March before DST.
+		{`TIMESTAMP '2020-03-04'`, TimestampLiteral(time.Date(2020, 3, 4, 8, 0, 0, 0, time.UTC))},
+		{`X < TIMESTAMP '2020-03-04 UTC'`, ComparisonOp{LHS: ID("X"), Op: Lt, RHS: TimestampLiteral(time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC))}},
+		// Conditional expressions and arrays.
+		{`CASE A WHEN 1 THEN "one" ELSE "many" END`,
+			Case{
+				Expr:        ID("A"),
+				WhenClauses: []WhenClause{{Cond: IntegerLiteral(1), Result: StringLiteral("one")}},
+				ElseResult:  StringLiteral("many"),
+			},
+		},
+		{`CASE WHEN A > 0 THEN B END`, Case{WhenClauses: []WhenClause{{Cond: ComparisonOp{LHS: ID("A"), Op: Gt, RHS: IntegerLiteral(0)}, Result: ID("B")}}}},
+		{`[1, A]`, Array{IntegerLiteral(1), ID("A")}},
+		{`ARRAY[]`, Array{}},
+		{`A NOT IN UNNEST(@arr)`, InOp{LHS: ID("A"), Neg: true, RHS: []Expr{Param("arr")}, Unnest: true}},
+
+		{`(SELECT MAX(Id) FROM T) > 7`,
+			ComparisonOp{
+				LHS: ScalarSubquery{Query: Query{Select: Select{
+					List: []Expr{Func{Name: "MAX", Args: []Expr{ID("Id")}}},
+					From: []SelectFrom{SelectFromTable{Table: "T"}},
+				}}},
+				Op:  Gt,
+				RHS: IntegerLiteral(7),
+			},
+		},
 	}
 	for _, test := range tests {
 		p := newParser(test.in)
@@ -203,6 +392,7 @@ func TestParseExpr(t *testing.T) {
 }
 
 func TestParseDDL(t *testing.T) {
+	line := func(n int) Position { return Position{Line: n} }
 	tests := []struct {
 		in   string
 		want DDL
@@ -239,14 +429,15 @@ func TestParseDDL(t *testing.T) {
 			CreateTable{
 				Name: "FooBar",
 				Columns: []ColumnDef{
-					{Name: "System", Type: Type{Base: String, Len: MaxLen}, NotNull: true},
-					{Name: "RepoPath", Type: Type{Base: String, Len: MaxLen}, NotNull: true},
-					{Name: "Count", Type: Type{Base: Int64}},
+					{Name: "System", Type: Type{Base: String, Len: MaxLen}, NotNull: true, Position: line(2)},
+					{Name: "RepoPath", Type: Type{Base: String, Len: MaxLen}, NotNull: true, Position: line(3)},
+					{Name: "Count", Type: Type{Base: Int64}, Position: line(4)},
 				},
 				PrimaryKey: []KeyPart{
 					{Column: "System"},
 					{Column: "RepoPath"},
 				},
+				Position: line(1),
 			},
 			CreateIndex{
 				Name:       "MyFirstIndex",
@@ -255,13 +446,14 @@ func TestParseDDL(t *testing.T) {
 				Unique:     true,
 				Storing:    []string{"Count"},
 				Interleave: "SomeTable",
+				Position:   line(7),
 			},
 			CreateTable{
 				Name: "FooBarAux",
 				Columns: []ColumnDef{
-					{Name: "System", Type: Type{Base: String, Len: MaxLen}, NotNull: true},
-					{Name: "RepoPath", Type: Type{Base: String, Len: MaxLen}, NotNull: true},
-					{Name: "Author", Type: Type{Base: String, Len: MaxLen}, NotNull: true},
+					{Name: "System", Type: Type{Base: String, Len: MaxLen}, NotNull: true, Position: line(11)},
+					{Name: "RepoPath", Type: Type{Base: String, Len: MaxLen}, NotNull: true, Position: line(12)},
+					{Name: "Author", Type: Type{Base: String, Len: MaxLen}, NotNull: true, Position: line(13)},
 				},
 				PrimaryKey: []KeyPart{
 					{Column: "System"},
@@ -272,29 +464,76 @@ func TestParseDDL(t *testing.T) {
 					Parent:   "FooBar",
 					OnDelete: CascadeOnDelete,
 				},
+				Position: line(10),
 			},
 			AlterTable{Name: "FooBar", Alteration: AddColumn{
-				Def: ColumnDef{Name: "TZ", Type: Type{Base: Bytes, Len: 20}},
-			}},
-			AlterTable{Name: "FooBar", Alteration: DropColumn{Name: "TZ"}},
-			AlterTable{Name: "FooBar", Alteration: SetOnDelete{Action: NoActionOnDelete}},
-			DropIndex{Name: "MyFirstIndex"},
-			DropTable{Name: "FooBar"},
+				Def: ColumnDef{Name: "TZ", Type: Type{Base: Bytes, Len: 20}, Position: line(17)},
+			}, Position: line(17)},
+			AlterTable{Name: "FooBar", Alteration: DropColumn{Name: "TZ"}, Position: line(18)},
+			AlterTable{Name: "FooBar", Alteration: SetOnDelete{Action: NoActionOnDelete}, Position: line(19)},
+			DropIndex{Name: "MyFirstIndex", Position: line(21)},
+			DropTable{Name: "FooBar", Position: line(22)},
 			CreateTable{
 				Name: "NonScalars",
 				Columns: []ColumnDef{
-					{Name: "Dummy", Type: Type{Base: Int64}, NotNull: true},
-					{Name: "Ids", Type: Type{Array: true, Base: Int64}},
-					{Name: "Names", Type: Type{Array: true, Base: String, Len: MaxLen}},
+					{Name: "Dummy", Type: Type{Base: Int64}, NotNull: true, Position: line(25)},
+					{Name: "Ids", Type: Type{Array: true, Base: Int64}, Position: line(26)},
+					{Name: "Names", Type: Type{Array: true, Base: String, Len: MaxLen}, Position: line(27)},
 				},
 				PrimaryKey: []KeyPart{{Column: "Dummy"}},
+				Position:   line(24),
 			},
+		}, Comments: []*Comment{
+			{Marker: "#", Start: line(2), End: line(2), Text: []string{"This is a comment."}},
+			{Marker: "--", Start: line(3), End: line(3), Text: []string{"This is another comment."}},
+			{Marker: "/*", Start: line(4), End: line(5), Text: []string{"This is a", "* multiline comment."}},
 		}}},
 		// No trailing comma:
 		{`ALTER TABLE T ADD COLUMN C2 INT64`, DDL{List: []DDLStmt{
 			AlterTable{Name: "T", Alteration: AddColumn{
-				Def: ColumnDef{Name: "C2", Type: Type{Base: Int64}},
-			}},
+				Def: ColumnDef{Name: "C2", Type: Type{Base: Int64}, Position: line(1)},
+			}, Position: line(1)},
+		}}},
+		{`-- Commit timestamps.
+		-- These are tracked in several places.
+		CREATE TABLE Ts (
+			Id INT64 NOT NULL,
+			Updated TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp = true),  # inline
+		) PRIMARY KEY (Id);
+		ALTER TABLE Ts ALTER COLUMN Updated SET OPTIONS (allow_commit_timestamp = null);
+		ALTER TABLE Ts ALTER COLUMN Updated TIMESTAMP;
+		/* Strings can be widened. */ ALTER TABLE Ts ALTER COLUMN Id STRING(MAX) NOT NULL;
+		ALTER INDEX TsByUpdated ADD STORED COLUMN Id;
+		ALTER INDEX TsByUpdated DROP STORED COLUMN Id;
+		-- Trailing comment without newline`, DDL{List: []DDLStmt{
+			CreateTable{
+				Name: "Ts",
+				Columns: []ColumnDef{
+					{Name: "Id", Type: Type{Base: Int64}, NotNull: true, Position: line(4)},
+					{Name: "Updated", Type: Type{Base: Timestamp}, NotNull: true, Options: ColumnOptions{AllowCommitTimestamp: boolAddr(true)}, Position: line(5)},
+				},
+				PrimaryKey: []KeyPart{{Column: "Id"}},
+				Position:   line(3),
+			},
+			AlterTable{Name: "Ts", Alteration: AlterColumn{
+				Name:       "Updated",
+				Alteration: SetColumnOptions{Options: ColumnOptions{AllowCommitTimestamp: boolAddr(false)}},
+			}, Position: line(7)},
+			AlterTable{Name: "Ts", Alteration: AlterColumn{
+				Name:       "Updated",
+				Alteration: SetColumnType{Type: Type{Base: Timestamp}},
+			}, Position: line(8)},
+			AlterTable{Name: "Ts", Alteration: AlterColumn{
+				Name:       "Id",
+				Alteration: SetColumnType{Type: Type{Base: String, Len: MaxLen}, NotNull: true},
+			}, Position: line(9)},
+			AlterIndex{Name: "TsByUpdated", Alteration: AddStoredColumn{Name: "Id"}, Position: line(10)},
+			AlterIndex{Name: "TsByUpdated", Alteration: DropStoredColumn{Name: "Id"}, Position: line(11)},
+		}, Comments: []*Comment{
+			{Marker: "--", Isolated: true, Start: line(1), End: line(2), Text: []string{"Commit timestamps.", "These are tracked in several places."}},
+			{Marker: "#", Start: line(5), End: line(5), Text: []string{"inline"}},
+			{Marker: "/*", Isolated: true, Start: line(9), End: line(9), Text: []string{"Strings can be widened."}},
+			{Marker: "--", Isolated: true, Start: line(12), End: line(12), Text: []string{"Trailing comment without newline"}},
 		}}},
 	}
 	for _, test := range tests {
@@ -309,11 +548,139 @@ func TestParseDDL(t *testing.T) {
 	}
 }
 
+func TestParseComments(t *testing.T) {
+	in := `
+	-- The main table.
+	-- It holds everything.
+	CREATE TABLE Everything (
+		/* not a leading comment: this is on its own line, two lines above */
+
+		Id INT64 NOT NULL,  # the identifier
+		-- Comment before Data.
+		Data BYTES(MAX),
+	) PRIMARY KEY (Id)`
+	ddl, err := ParseDDL(in)
+	if err != nil {
+		t.Fatalf("ParseDDL: %v", err)
+	}
+	ct := ddl.List[0].(CreateTable)
+
+	c := ddl.LeadingComment(ct)
+	if c == nil || !reflect.DeepEqual(c.Text, []string{"The main table.", "It holds everything."}) {
+		t.Errorf("LeadingComment(CreateTable) = %+v", c)
+	}
+	if c := ddl.InlineComment(ct); c != nil {
+		t.Errorf("InlineComment(CreateTable) = %+v, want nil", c)
+	}
+
+	id := ct.Columns[0]
+	if c := ddl.LeadingComment(id); c != nil {
+		t.Errorf("LeadingComment(Id) = %+v, want nil", c)
+	}
+	c = ddl.InlineComment(id)
+	if c == nil || !reflect.DeepEqual(c.Text, []string{"the identifier"}) {
+		t.Errorf("InlineComment(Id) = %+v", c)
+	}
+
+	data := ct.Columns[1]
+	c = ddl.LeadingComment(data)
+	if c == nil || !reflect.DeepEqual(c.Text, []string{"Comment before Data."}) {
+		t.Errorf("LeadingComment(Data) = %+v", c)
+	}
+}
+
+func TestParseDMLStmt(t *testing.T) {
+	tests := []struct {
+		in   string
+		want DMLStmt
+	}{
+		{"INSERT Singers (SingerId, FirstName, LastName) VALUES (1, 'Marc', 'Richards')",
+			Insert{
+				Table:   "Singers",
+				Columns: []string{"SingerId", "FirstName", "LastName"},
+				Input:   Values{{IntegerLiteral(1), StringLiteral("Marc"), StringLiteral("Richards")}},
+			},
+		},
+		{"INSERT INTO Singers (SingerId, FirstName) VALUES (@id, DEFAULT), (2, 'Catalina')",
+			Insert{
+				Table:   "Singers",
+				Columns: []string{"SingerId", "FirstName"},
+				Input: Values{
+					{Param("id"), nil},
+					{IntegerLiteral(2), StringLiteral("Catalina")},
+				},
+			},
+		},
+		{"INSERT INTO Singers (SingerId, FirstName) SELECT ID, Name FROM Staff WHERE Cool",
+			Insert{
+				Table:   "Singers",
+				Columns: []string{"SingerId", "FirstName"},
+				Input: Query{
+					Select: Select{
+						List:  []Expr{ID("ID"), ID("Name")},
+						From:  []SelectFrom{SelectFromTable{Table: "Staff"}},
+						Where: ID("Cool"),
+					},
+				},
+			},
+		},
+		{"UPDATE Singers SET LastName = 'Smith', Alias = DEFAULT WHERE SingerId = 1",
+			Update{
+				Table: "Singers",
+				Items: []UpdateItem{
+					{Column: "LastName", Value: StringLiteral("Smith")},
+					{Column: "Alias", Value: nil},
+				},
+				Where: ComparisonOp{LHS: ID("SingerId"), Op: Eq, RHS: IntegerLiteral(1)},
+			},
+		},
+		{"DELETE FROM Singers WHERE LastName = 'Smith'",
+			Delete{
+				Table: "Singers",
+				Where: ComparisonOp{LHS: ID("LastName"), Op: Eq, RHS: StringLiteral("Smith")},
+			},
+		},
+		{"DELETE Singers WHERE TRUE",
+			Delete{
+				Table: "Singers",
+				Where: True,
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseDMLStmt(test.in)
+		if err != nil {
+			t.Errorf("ParseDMLStmt(%q): %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseDMLStmt(%q) incorrect.\n got %#v\nwant %#v", test.in, got, test.want)
+		}
+	}
+
+	// Check the failure cases.
+	for _, in := range []string{
+		"DELETE FROM Singers",                             // missing WHERE
+		"UPDATE Singers SET LastName = 'Smith'",           // missing WHERE
+		"INSERT Singers (SingerId, FirstName) VALUES (1)", // too few values
+		"INSERT Singers (SingerId) VALUES (1) WHERE TRUE", // trailing junk
+		"SELECT * FROM Singers",                           // not DML
+	} {
+		if _, err := ParseDMLStmt(in); err == nil {
+			t.Errorf("ParseDMLStmt(%q) succeeded, want error", in)
+		}
+	}
+}
+
 func TestParseFailures(t *testing.T) {
 	expr := func(p *parser) error {
 		_, err := p.parseExpr()
 		return err
 	}
+	query := func(p *parser) error {
+		_, err := p.parseQuery()
+		return err
+	}
 
 	tests := []struct {
 		f    func(p *parser) error
@@ -347,6 +714,18 @@ func TestParseFailures(t *testing.T) {
 		{expr, `"""\"""`, "unterminated triple-quoted string by last backslash (double quote)"},
 		{expr, `'''\'''`, "unterminated triple-quoted string by last backslash (single quote)"},
 		{expr, `"foo" AND "bar"`, "logical operation on string literals"},
+		{expr, `A IN ()`, "empty IN list"},
+		{expr, `A.`, "incomplete path expression"},
+		{expr, `CASE END`, "CASE without WHEN"},
+		{expr, `CAST(A)`, "CAST without type"},
+		{expr, `[1, 2`, "unterminated array literal"},
+		{expr, `DATE '2020-02-30'`, "invalid date"},
+		{expr, `DATE '2020-03-04 05:06:07'`, "date literal with a time"},
+		{expr, `TIMESTAMP '2020-03-04 24:00:00'`, "invalid time"},
+		{expr, `TIMESTAMP '2020-03-04 05:06:07 Nowhere/Special'`, "unknown time zone"},
+		{query, `SELECT A FROM T JOIN U`, "join without condition"},
+		{query, `SELECT A FROM T UNION SELECT B FROM U`, "set operation without ALL or DISTINCT"},
+		{query, `SELECT A FROM T UNION ALL SELECT B FROM U EXCEPT DISTINCT SELECT C FROM V`, "mixed set operations"},
 	}
 	for _, test := range tests {
 		p := newParser(test.in)
@@ -355,3 +734,5 @@ func TestParseFailures(t *testing.T) {
 		}
 	}
 }
+
+func boolAddr(b bool) *bool { return &b }
@@ -20,8 +20,12 @@ package spansql
 // as the SQL dialect that this package parses.
 
 import (
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
 )
 
 func (ct CreateTable) SQL() string {
@@ -94,6 +98,34 @@ func (sod SetOnDelete) SQL() string {
 	return "SET ON DELETE " + sod.Action.SQL()
 }
 
+func (ac AlterColumn) SQL() string {
+	return "ALTER COLUMN " + ac.Name + " " + ac.Alteration.SQL()
+}
+
+func (sct SetColumnType) SQL() string {
+	str := sct.Type.SQL()
+	if sct.NotNull {
+		str += " NOT NULL"
+	}
+	return str
+}
+
+func (sco SetColumnOptions) SQL() string {
+	return "SET " + sco.Options.SQL()
+}
+
+func (ai AlterIndex) SQL() string {
+	return "ALTER INDEX " + ai.Name + " " + ai.Alteration.SQL()
+}
+
+func (asc AddStoredColumn) SQL() string {
+	return "ADD STORED COLUMN " + asc.Name
+}
+
+func (dsc DropStoredColumn) SQL() string {
+	return "DROP STORED COLUMN " + dsc.Name
+}
+
 func (od OnDelete) SQL() string {
 	switch od {
 	case NoActionOnDelete:
@@ -104,19 +136,33 @@ func (od OnDelete) SQL() string {
 	panic("unknown OnDelete")
 }
 
-// TODO func (ac AlterColumn) SQL() string { }
-
 func (cd ColumnDef) SQL() string {
 	str := cd.Name + " " + cd.Type.SQL()
 	if cd.NotNull {
 		str += " NOT NULL"
 	}
+	if cd.Options != (ColumnOptions{}) {
+		str += " " + cd.Options.SQL()
+	}
+	return str
+}
+
+func (co ColumnOptions) SQL() string {
+	str := "OPTIONS ("
+	if co.AllowCommitTimestamp != nil {
+		if *co.AllowCommitTimestamp {
+			str += "allow_commit_timestamp = true"
+		} else {
+			str += "allow_commit_timestamp = null"
+		}
+	}
+	str += ")"
 	return str
 }
 
 func (t Type) SQL() string {
 	str := t.Base.SQL()
-	if t.Base == String || t.Base == Bytes {
+	if (t.Base == String || t.Base == Bytes) && t.Len != 0 {
 		str += "("
 		if t.Len == MaxLen {
 			str += "MAX"
@@ -161,6 +207,9 @@ func (kp KeyPart) SQL() string {
 
 func (q Query) SQL() string {
 	str := q.Select.SQL()
+	for _, so := range q.SetOps {
+		str += " " + so.SQL()
+	}
 	if len(q.Order) > 0 {
 		str += " ORDER BY "
 		for i, o := range q.Order {
@@ -190,15 +239,133 @@ func (sel Select) SQL() string {
 			if i > 0 {
 				str += ", "
 			}
-			str += f.Table
+			str += f.SQL()
 		}
 	}
 	if sel.Where != nil {
 		str += " WHERE " + sel.Where.SQL()
 	}
+	if len(sel.GroupBy) > 0 {
+		str += " GROUP BY "
+		for i, e := range sel.GroupBy {
+			if i > 0 {
+				str += ", "
+			}
+			str += e.SQL()
+		}
+	}
+	if sel.Having != nil {
+		str += " HAVING " + sel.Having.SQL()
+	}
+	return str
+}
+
+var setOps = map[SetOperator]string{
+	Union:     "UNION",
+	Intersect: "INTERSECT",
+	Except:    "EXCEPT",
+}
+
+func (so SetOp) SQL() string {
+	op, ok := setOps[so.Op]
+	if !ok {
+		panic("unknown SetOp")
+	}
+	if so.All {
+		op += " ALL"
+	} else {
+		op += " DISTINCT"
+	}
+	return op + " " + so.Select.SQL()
+}
+
+func (sft SelectFromTable) SQL() string {
+	str := sft.Table
+	if len(sft.Hints) > 0 {
+		var keys []string
+		for k := range sft.Hints {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		str += "@{"
+		for i, k := range keys {
+			if i > 0 {
+				str += ", "
+			}
+			str += k + "=" + sft.Hints[k]
+		}
+		str += "}"
+	}
+	if sft.Alias != "" {
+		str += " AS " + sft.Alias
+	}
+	if sft.TableSample != nil {
+		str += " " + sft.TableSample.SQL()
+	}
 	return str
 }
 
+var joinTypes = map[JoinType]string{
+	InnerJoin: "INNER JOIN",
+	CrossJoin: "CROSS JOIN",
+	FullJoin:  "FULL JOIN",
+	LeftJoin:  "LEFT JOIN",
+	RightJoin: "RIGHT JOIN",
+}
+
+func (sfj SelectFromJoin) SQL() string {
+	jt, ok := joinTypes[sfj.Type]
+	if !ok {
+		panic("unknown JoinType")
+	}
+	rhs := sfj.RHS.SQL()
+	if _, ok := sfj.RHS.(SelectFromJoin); ok {
+		// Joins are left associative, so a join on the RHS needs parens.
+		rhs = "(" + rhs + ")"
+	}
+	str := sfj.LHS.SQL() + " " + jt + " " + rhs
+	if sfj.On != nil {
+		str += " ON " + sfj.On.SQL()
+	} else if len(sfj.Using) > 0 {
+		str += " USING (" + strings.Join(sfj.Using, ", ") + ")"
+	}
+	return str
+}
+
+func (sfs SelectFromSubquery) SQL() string {
+	str := "(" + sfs.Query.SQL() + ")"
+	if sfs.Alias != "" {
+		str += " AS " + sfs.Alias
+	}
+	return str
+}
+
+func (sfu SelectFromUnnest) SQL() string {
+	str := "UNNEST(" + sfu.Expr.SQL() + ")"
+	if sfu.Alias != "" {
+		str += " AS " + sfu.Alias
+	}
+	return str
+}
+
+func (ts TableSample) SQL() string {
+	str := "TABLESAMPLE "
+	switch ts.Method {
+	case Bernoulli:
+		str += "BERNOULLI"
+	case Reservoir:
+		str += "RESERVOIR"
+	}
+	str += " (" + ts.Size.SQL()
+	switch ts.SizeType {
+	case PercentTableSample:
+		str += " PERCENT"
+	case RowsTableSample:
+		str += " ROWS"
+	}
+	return str + ")"
+}
+
 func (o Order) SQL() string {
 	str := o.Expr.SQL()
 	if o.Desc {
@@ -254,8 +421,83 @@ func (io IsOp) SQL() string {
 	return str
 }
 
+func (io InOp) SQL() string {
+	str := io.LHS.SQL()
+	if io.Neg {
+		str += " NOT"
+	}
+	if io.Unnest {
+		return str + " IN UNNEST(" + io.RHS[0].SQL() + ")"
+	}
+	str += " IN ("
+	if io.Subquery != nil {
+		str += io.Subquery.SQL()
+	} else {
+		for i, e := range io.RHS {
+			if i > 0 {
+				str += ", "
+			}
+			str += e.SQL()
+		}
+	}
+	return str + ")"
+}
+
+func (eo ExistsOp) SQL() string       { return "EXISTS (" + eo.Query.SQL() + ")" }
+func (ss ScalarSubquery) SQL() string { return "(" + ss.Query.SQL() + ")" }
+
+var arithOps = map[ArithOperator]string{
+	Neg:    "-",
+	Plus:   "+",
+	BitNot: "~",
+	Mul:    "*",
+	Div:    "/",
+	Concat: "||",
+	Add:    "+",
+	Sub:    "-",
+	BitShl: "<<",
+	BitShr: ">>",
+	BitAnd: "&",
+	BitXor: "^",
+	BitOr:  "|",
+}
+
+func (ao ArithOp) SQL() string {
+	op, ok := arithOps[ao.Op]
+	if !ok {
+		panic("unknown ArithOp")
+	}
+	switch ao.Op {
+	case Neg, Plus, BitNot:
+		rhs := ao.RHS.SQL()
+		if strings.HasPrefix(rhs, op) {
+			// Avoid producing "--", which starts a comment.
+			rhs = " " + rhs
+		}
+		return op + rhs
+	}
+	return ao.LHS.SQL() + " " + op + " " + ao.RHS.SQL()
+}
+
+func (c Case) SQL() string {
+	str := "CASE "
+	if c.Expr != nil {
+		str += c.Expr.SQL() + " "
+	}
+	for _, w := range c.WhenClauses {
+		str += "WHEN " + w.Cond.SQL() + " THEN " + w.Result.SQL() + " "
+	}
+	if c.ElseResult != nil {
+		str += "ELSE " + c.ElseResult.SQL() + " "
+	}
+	return str + "END"
+}
+
 func (f Func) SQL() string {
 	str := f.Name + "("
+	if f.Distinct {
+		str += "DISTINCT "
+	}
 	for i, e := range f.Args {
 		if i > 0 {
 			str += ", "
@@ -266,11 +508,31 @@ func (f Func) SQL() string {
 	return str
 }
 
+func (te TypedExpr) SQL() string { return te.Expr.SQL() + " AS " + te.Type.SQL() }
+
+func (ee ExtractExpr) SQL() string {
+	str := ee.Part + " FROM " + ee.Expr.SQL()
+	if ee.TimeZone != nil {
+		str += " AT TIME ZONE " + ee.TimeZone.SQL()
+	}
+	return str
+}
+
+func (ie IntervalExpr) SQL() string { return "INTERVAL " + ie.Expr.SQL() + " " + ie.DatePart }
+
 func (p Paren) SQL() string { return "(" + p.Expr.SQL() + ")" }
 
 func (id ID) SQL() string   { return string(id) }
 func (p Param) SQL() string { return "@" + string(p) }
 
+func (pe PathExp) SQL() string {
+	var parts []string
+	for _, id := range pe {
+		parts = append(parts, id.SQL())
+	}
+	return strings.Join(parts, ".")
+}
+
 func (b BoolLiteral) SQL() string {
 	if b {
 		return "TRUE"
@@ -278,6 +540,17 @@ func (b BoolLiteral) SQL() string {
 	return "FALSE"
 }
 
+func (a Array) SQL() string {
+	str := "["
+	for i, e := range a {
+		if i > 0 {
+			str += ", "
+		}
+		str += e.SQL()
+	}
+	return str + "]"
+}
+
 func (n NullLiteral) SQL() string { return "NULL" }
 func (StarExpr) SQL() string      { return "*" }
 
@@ -288,3 +561,57 @@ func (fl FloatLiteral) SQL() string   { return strconv.FormatFloat(float64(fl),
 
 func (sl StringLiteral) SQL() string { return strconv.Quote(string(sl)) }
 func (bl BytesLiteral) SQL() string  { return "B" + strconv.Quote(string(bl)) }
+
+func (dl DateLiteral) SQL() string { return "DATE " + strconv.Quote(civil.Date(dl).String()) }
+func (tl TimestampLiteral) SQL() string {
+	return "TIMESTAMP " + strconv.Quote(time.Time(tl).UTC().Format("2006-01-02 15:04:05.999999999")+" UTC")
+}
+
+func (i Insert) SQL() string {
+	str := "INSERT INTO " + i.Table + " (" + strings.Join(i.Columns, ", ") + ") "
+	str += i.Input.SQL()
+	return str
+}
+
+func (v Values) SQL() string {
+	str := "VALUES "
+	for j, row := range v {
+		if j > 0 {
+			str += ", "
+		}
+		str += "("
+		for k, e := range row {
+			if k > 0 {
+				str += ", "
+			}
+			str += sqlOrDefault(e)
+		}
+		str += ")"
+	}
+	return str
+}
+
+func (u Update) SQL() string {
+	str := "UPDATE " + u.Table + " SET "
+	for i, item := range u.Items {
+		if i > 0 {
+			str += ", "
+		}
+		str += item.Column + " = " + sqlOrDefault(item.Value)
+	}
+	str += " WHERE " + u.Where.SQL()
+	return str
+}
+
+func (d Delete) SQL() string {
+	return "DELETE FROM " + d.Table + " WHERE " + d.Where.SQL()
+}
+
+// sqlOrDefault renders an expression in a DML statement,
+// where a nil Expr represents DEFAULT.
+func sqlOrDefault(e Expr) string {
+	if e == nil {
+		return "DEFAULT"
+	}
+	return e.SQL()
+}
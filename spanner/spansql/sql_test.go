@@ -19,6 +19,9 @@ package spansql
 import (
 	"reflect"
 	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
 )
 
 func TestSQL(t *testing.T) {
@@ -30,10 +33,15 @@ func TestSQL(t *testing.T) {
 		q, err := ParseQuery(s)
 		return q, err
 	}
+	reparseDML := func(s string) (interface{}, error) {
+		dml, err := ParseDMLStmt(s)
+		return dml, err
+	}
 	reparseExpr := func(s string) (interface{}, error) {
 		e, err := newParser(s).parseExpr()
 		return e, err
 	}
+	line := func(n int) Position { return Position{Line: n} }
 
 	tests := []struct {
 		data    interface{ SQL() string }
@@ -44,22 +52,23 @@ func TestSQL(t *testing.T) {
 			CreateTable{
 				Name: "Ta",
 				Columns: []ColumnDef{
-					{Name: "Ca", Type: Type{Base: Bool}, NotNull: true},
-					{Name: "Cb", Type: Type{Base: Int64}},
-					{Name: "Cc", Type: Type{Base: Float64}},
-					{Name: "Cd", Type: Type{Base: String, Len: 17}},
-					{Name: "Ce", Type: Type{Base: String, Len: MaxLen}},
-					{Name: "Cf", Type: Type{Base: Bytes, Len: 4711}},
-					{Name: "Cg", Type: Type{Base: Bytes, Len: MaxLen}},
-					{Name: "Ch", Type: Type{Base: Date}},
-					{Name: "Ci", Type: Type{Base: Timestamp}},
-					{Name: "Cj", Type: Type{Array: true, Base: Int64}},
-					{Name: "Ck", Type: Type{Array: true, Base: String, Len: MaxLen}},
+					{Name: "Ca", Type: Type{Base: Bool}, NotNull: true, Position: line(2)},
+					{Name: "Cb", Type: Type{Base: Int64}, Position: line(3)},
+					{Name: "Cc", Type: Type{Base: Float64}, Position: line(4)},
+					{Name: "Cd", Type: Type{Base: String, Len: 17}, Position: line(5)},
+					{Name: "Ce", Type: Type{Base: String, Len: MaxLen}, Position: line(6)},
+					{Name: "Cf", Type: Type{Base: Bytes, Len: 4711}, Position: line(7)},
+					{Name: "Cg", Type: Type{Base: Bytes, Len: MaxLen}, Position: line(8)},
+					{Name: "Ch", Type: Type{Base: Date}, Position: line(9)},
+					{Name: "Ci", Type: Type{Base: Timestamp}, Position: line(10)},
+					{Name: "Cj", Type: Type{Array: true, Base: Int64}, Position: line(11)},
+					{Name: "Ck", Type: Type{Array: true, Base: String, Len: MaxLen}, Position: line(12)},
 				},
 				PrimaryKey: []KeyPart{
 					{Column: "Ca"},
 					{Column: "Cb", Desc: true},
 				},
+				Position: line(1),
 			},
 			`CREATE TABLE Ta (
   Ca BOOL NOT NULL,
@@ -80,8 +89,8 @@ func TestSQL(t *testing.T) {
 			CreateTable{
 				Name: "Tsub",
 				Columns: []ColumnDef{
-					{Name: "SomeId", Type: Type{Base: Int64}, NotNull: true},
-					{Name: "OtherId", Type: Type{Base: Int64}, NotNull: true},
+					{Name: "SomeId", Type: Type{Base: Int64}, NotNull: true, Position: line(2)},
+					{Name: "OtherId", Type: Type{Base: Int64}, NotNull: true, Position: line(3)},
 				},
 				PrimaryKey: []KeyPart{
 					{Column: "SomeId"},
@@ -91,6 +100,7 @@ func TestSQL(t *testing.T) {
 					Parent:   "Ta",
 					OnDelete: CascadeOnDelete,
 				},
+				Position: line(1),
 			},
 			`CREATE TABLE Tsub (
   SomeId INT64 NOT NULL,
@@ -101,7 +111,8 @@ func TestSQL(t *testing.T) {
 		},
 		{
 			DropTable{
-				Name: "Ta",
+				Name:     "Ta",
+				Position: line(1),
 			},
 			"DROP TABLE Ta",
 			reparseDDL,
@@ -114,13 +125,15 @@ func TestSQL(t *testing.T) {
 					{Column: "Ca"},
 					{Column: "Cb", Desc: true},
 				},
+				Position: line(1),
 			},
 			"CREATE INDEX Ia ON Ta(Ca, Cb DESC)",
 			reparseDDL,
 		},
 		{
 			DropIndex{
-				Name: "Ia",
+				Name:     "Ia",
+				Position: line(1),
 			},
 			"DROP INDEX Ia",
 			reparseDDL,
@@ -128,7 +141,8 @@ func TestSQL(t *testing.T) {
 		{
 			AlterTable{
 				Name:       "Ta",
-				Alteration: AddColumn{Def: ColumnDef{Name: "Ca", Type: Type{Base: Bool}}},
+				Alteration: AddColumn{Def: ColumnDef{Name: "Ca", Type: Type{Base: Bool}, Position: line(1)}},
+				Position:   line(1),
 			},
 			"ALTER TABLE Ta ADD COLUMN Ca BOOL",
 			reparseDDL,
@@ -137,6 +151,7 @@ func TestSQL(t *testing.T) {
 			AlterTable{
 				Name:       "Ta",
 				Alteration: DropColumn{Name: "Ca"},
+				Position:   line(1),
 			},
 			"ALTER TABLE Ta DROP COLUMN Ca",
 			reparseDDL,
@@ -145,6 +160,7 @@ func TestSQL(t *testing.T) {
 			AlterTable{
 				Name:       "Ta",
 				Alteration: SetOnDelete{Action: NoActionOnDelete},
+				Position:   line(1),
 			},
 			"ALTER TABLE Ta SET ON DELETE NO ACTION",
 			reparseDDL,
@@ -153,15 +169,72 @@ func TestSQL(t *testing.T) {
 			AlterTable{
 				Name:       "Ta",
 				Alteration: SetOnDelete{Action: CascadeOnDelete},
+				Position:   line(1),
 			},
 			"ALTER TABLE Ta SET ON DELETE CASCADE",
 			reparseDDL,
 		},
+		{
+			AlterTable{
+				Name: "Ta",
+				Alteration: AlterColumn{
+					Name:       "Cg",
+					Alteration: SetColumnType{Type: Type{Base: String, Len: MaxLen}, NotNull: true},
+				},
+				Position: line(1),
+			},
+			"ALTER TABLE Ta ALTER COLUMN Cg STRING(MAX) NOT NULL",
+			reparseDDL,
+		},
+		{
+			AlterTable{
+				Name: "Ta",
+				Alteration: AlterColumn{
+					Name:       "Ci",
+					Alteration: SetColumnOptions{Options: ColumnOptions{AllowCommitTimestamp: boolAddr(false)}},
+				},
+				Position: line(1),
+			},
+			"ALTER TABLE Ta ALTER COLUMN Ci SET OPTIONS (allow_commit_timestamp = null)",
+			reparseDDL,
+		},
+		{
+			AlterTable{
+				Name: "Ta",
+				Alteration: AddColumn{Def: ColumnDef{
+					Name:     "Cl",
+					Type:     Type{Base: Timestamp},
+					Options:  ColumnOptions{AllowCommitTimestamp: boolAddr(true)},
+					Position: line(1),
+				}},
+				Position: line(1),
+			},
+			"ALTER TABLE Ta ADD COLUMN Cl TIMESTAMP OPTIONS (allow_commit_timestamp = true)",
+			reparseDDL,
+		},
+		{
+			AlterIndex{
+				Name:       "Ia",
+				Alteration: AddStoredColumn{Name: "Cc"},
+				Position:   line(1),
+			},
+			"ALTER INDEX Ia ADD STORED COLUMN Cc",
+			reparseDDL,
+		},
+		{
+			AlterIndex{
+				Name:       "Ia",
+				Alteration: DropStoredColumn{Name: "Cc"},
+				Position:   line(1),
+			},
+			"ALTER INDEX Ia DROP STORED COLUMN Cc",
+			reparseDDL,
+		},
 		{
 			Query{
 				Select: Select{
 					List: []Expr{ID("A"), ID("B")},
-					From: []SelectFrom{{Table: "Table"}},
+					From: []SelectFrom{SelectFromTable{Table: "Table"}},
 					Where: LogicalOp{
 						LHS: ComparisonOp{
 							LHS: ID("C"),
@@ -191,11 +264,174 @@ func TestSQL(t *testing.T) {
 			`SELECT 7`,
 			reparseQuery,
 		},
+		{
+			Query{
+				Select: Select{
+					List: []Expr{
+						ID("A"),
+						Func{Name: "COUNT", Args: []Expr{ID("B")}, Distinct: true},
+						Func{Name: "SUM", Args: []Expr{ID("C")}},
+					},
+					From:    []SelectFrom{SelectFromTable{Table: "Table"}},
+					GroupBy: []Expr{ID("A"), ID("D")},
+					Having: ComparisonOp{
+						LHS: Func{Name: "COUNT", Args: []Expr{Star}},
+						Op:  Gt,
+						RHS: IntegerLiteral(1),
+					},
+				},
+			},
+			`SELECT A, COUNT(DISTINCT B), SUM(C) FROM Table GROUP BY A, D HAVING COUNT(*) > 1`,
+			reparseQuery,
+		},
+		{
+			Query{
+				Select: Select{
+					List: []Expr{PathExp{"t", "A"}, ScalarSubquery{Query: Query{Select: Select{List: []Expr{IntegerLiteral(1)}}}}},
+					From: []SelectFrom{
+						SelectFromJoin{
+							Type: LeftJoin,
+							LHS:  SelectFromTable{Table: "Table", Alias: "t"},
+							RHS: SelectFromJoin{
+								Type:  InnerJoin,
+								LHS:   SelectFromTable{Table: "Tb"},
+								RHS:   SelectFromTable{Table: "Tc"},
+								Using: []string{"B", "C"},
+							},
+							On: ComparisonOp{LHS: PathExp{"t", "B"}, Op: Eq, RHS: PathExp{"Tb", "B"}},
+						},
+						SelectFromSubquery{
+							Query: Query{Select: Select{List: []Expr{ID("D")}, From: []SelectFrom{SelectFromTable{Table: "Td"}}}},
+							Alias: "sub",
+						},
+					},
+					Where: LogicalOp{
+						LHS: InOp{LHS: ID("D"), Neg: true, RHS: []Expr{IntegerLiteral(1), IntegerLiteral(2)}},
+						Op:  And,
+						RHS: ExistsOp{Query: Query{Select: Select{List: []Expr{ID("E")}, From: []SelectFrom{SelectFromTable{Table: "Te"}}}}},
+					},
+				},
+				SetOps: []SetOp{
+					{Op: Except, Select: Select{List: []Expr{ID("F"), ID("G")}, From: []SelectFrom{SelectFromTable{Table: "Tf"}}}},
+				},
+			},
+			`SELECT t.A, (SELECT 1) FROM Table AS t LEFT JOIN (Tb INNER JOIN Tc USING (B, C)) ON t.B = Tb.B, (SELECT D FROM Td) AS sub WHERE D NOT IN (1, 2) AND EXISTS (SELECT E FROM Te) EXCEPT DISTINCT SELECT F, G FROM Tf`,
+			reparseQuery,
+		},
+		{
+			Query{
+				Select: Select{
+					List: []Expr{ID("A")},
+					From: []SelectFrom{SelectFromTable{Table: "Ta"}},
+					Where: InOp{
+						LHS:      ID("A"),
+						Subquery: &Query{Select: Select{List: []Expr{ID("B")}, From: []SelectFrom{SelectFromTable{Table: "Tb"}}}},
+					},
+				},
+				SetOps: []SetOp{
+					{Op: Union, All: true, Select: Select{List: []Expr{ID("C")}, From: []SelectFrom{SelectFromTable{Table: "Tc"}}}},
+					{Op: Union, All: true, Select: Select{List: []Expr{ID("D")}, From: []SelectFrom{SelectFromTable{Table: "Td"}}}},
+				},
+				Order: []Order{{Expr: ID("A")}},
+			},
+			`SELECT A FROM Ta WHERE A IN (SELECT B FROM Tb) UNION ALL SELECT C FROM Tc UNION ALL SELECT D FROM Td ORDER BY A`,
+			reparseQuery,
+		},
+		{
+			Query{
+				Select: Select{
+					List: []Expr{
+						ArithOp{LHS: ArithOp{LHS: ArithOp{Op: Neg, RHS: IntegerLiteral(-1)}, Op: Mul, RHS: ID("A")}, Op: Concat, RHS: ID("B")},
+						Func{Name: "CAST", Args: []Expr{TypedExpr{Expr: ID("A"), Type: Type{Base: String}}}},
+						Func{Name: "EXTRACT", Args: []Expr{ExtractExpr{Part: "YEAR", Expr: ID("T")}}},
+						Func{Name: "TIMESTAMP_SUB", Args: []Expr{ID("T"), IntervalExpr{Expr: Param("n"), DatePart: "HOUR"}}},
+						Case{
+							WhenClauses: []WhenClause{{Cond: ID("C"), Result: Array{IntegerLiteral(1)}}},
+							ElseResult:  Array{},
+						},
+					},
+					From:  []SelectFrom{SelectFromUnnest{Expr: Param("arr"), Alias: "A"}},
+					Where: InOp{LHS: ID("A"), RHS: []Expr{ID("B")}, Unnest: true},
+				},
+			},
+			`SELECT - -1 * A || B, CAST(A AS STRING), EXTRACT(YEAR FROM T), TIMESTAMP_SUB(T, INTERVAL @n HOUR), CASE WHEN C THEN [1] ELSE [] END FROM UNNEST(@arr) AS A WHERE A IN UNNEST(B)`,
+			reparseQuery,
+		},
+		{
+			Query{
+				Select: Select{
+					List: []Expr{ID("A")},
+					From: []SelectFrom{SelectFromTable{
+						Table: "Ta",
+						Hints: map[string]string{"FORCE_INDEX": "TaByA", "GROUPBY_SCAN_OPTIMIZATION": "TRUE"},
+						Alias: "t",
+					}},
+				},
+			},
+			`SELECT A FROM Ta@{FORCE_INDEX=TaByA, GROUPBY_SCAN_OPTIMIZATION=TRUE} AS t`,
+			reparseQuery,
+		},
+		{
+			Insert{
+				Table:   "Ta",
+				Columns: []string{"Ca", "Cb"},
+				Input: Values{
+					{IntegerLiteral(1), StringLiteral("foo")},
+					{Param("p"), nil},
+				},
+			},
+			`INSERT INTO Ta (Ca, Cb) VALUES (1, "foo"), (@p, DEFAULT)`,
+			reparseDML,
+		},
+		{
+			Insert{
+				Table:   "Ta",
+				Columns: []string{"Ca"},
+				Input: Query{
+					Select: Select{
+						List: []Expr{ID("Cx")},
+						From: []SelectFrom{SelectFromTable{Table: "Tb"}},
+					},
+				},
+			},
+			`INSERT INTO Ta (Ca) SELECT Cx FROM Tb`,
+			reparseDML,
+		},
+		{
+			Update{
+				Table: "Ta",
+				Items: []UpdateItem{
+					{Column: "Cb", Value: IntegerLiteral(4)},
+					{Column: "Ce", Value: nil},
+				},
+				Where: ComparisonOp{LHS: ID("Ca"), Op: Ge, RHS: IntegerLiteral(7)},
+			},
+			`UPDATE Ta SET Cb = 4, Ce = DEFAULT WHERE Ca >= 7`,
+			reparseDML,
+		},
+		{
+			Delete{
+				Table: "Ta",
+				Where: ComparisonOp{LHS: ID("C"), Op: Gt, RHS: IntegerLiteral(2)},
+			},
+			`DELETE FROM Ta WHERE C > 2`,
+			reparseDML,
+		},
 		{
 			ComparisonOp{LHS: ID("X"), Op: NotBetween, RHS: ID("Y"), RHS2: ID("Z")},
 			`X NOT BETWEEN Y AND Z`,
 			reparseExpr,
 		},
+		{
+			DateLiteral(civil.Date{Year: 2020, Month: 3, Day: 4}),
+			`DATE "2020-03-04"`,
+			reparseExpr,
+		},
+		{
+			TimestampLiteral(time.Date(2020, 3, 4, 5, 6, 7, 1000, time.UTC)),
+			`TIMESTAMP "2020-03-04 05:06:07.000001 UTC"`,
+			reparseExpr,
+		},
 	}
 	for _, test := range tests {
 		sql := test.data.SQL()
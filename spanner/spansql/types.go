@@ -19,7 +19,11 @@ package spansql
 // This file holds the type definitions for the SQL dialect.
 
 import (
+	"fmt"
 	"math"
+	"time"
+
+	"cloud.google.com/go/civil"
 )
 
 // CreateTable represents a CREATE TABLE statement.
@@ -29,6 +33,8 @@ type CreateTable struct {
 	Columns    []ColumnDef
 	PrimaryKey []KeyPart
 	Interleave *Interleave
+
+	Position Position // position of the "CREATE" token
 }
 
 // Interleave represents an interleave clause of a CREATE TABLE statement.
@@ -49,24 +55,36 @@ type CreateIndex struct {
 
 	Storing    []string
 	Interleave string
+
+	Position Position // position of the "CREATE" token
 }
 
 // DropTable represents a DROP TABLE statement.
 // https://cloud.google.com/spanner/docs/data-definition-language#drop_table
-type DropTable struct{ Name string }
+type DropTable struct {
+	Name string
+
+	Position Position // position of the "DROP" token
+}
 
 // DropIndex represents a DROP INDEX statement.
 // https://cloud.google.com/spanner/docs/data-definition-language#drop-index
-type DropIndex struct{ Name string }
+type DropIndex struct {
+	Name string
+
+	Position Position // position of the "DROP" token
+}
 
 // AlterTable represents an ALTER TABLE statement.
 // https://cloud.google.com/spanner/docs/data-definition-language#alter_table
 type AlterTable struct {
 	Name       string
 	Alteration TableAlteration
+
+	Position Position // position of the "ALTER" token
 }
 
-// TableAlteration is satisfied by AddColumn, DropColumn and SetOnDelete.
+// TableAlteration is satisfied by AddColumn, DropColumn, SetOnDelete and AlterColumn.
 type TableAlteration interface {
 	isTableAlteration()
 	SQL() string
@@ -75,8 +93,7 @@ type TableAlteration interface {
 func (AddColumn) isTableAlteration()   {}
 func (DropColumn) isTableAlteration()  {}
 func (SetOnDelete) isTableAlteration() {}
-
-//func (AlterColumn) isTableAlteration() {}
+func (AlterColumn) isTableAlteration() {}
 
 type AddColumn struct{ Def ColumnDef }
 type DropColumn struct{ Name string }
@@ -89,10 +106,50 @@ const (
 	CascadeOnDelete
 )
 
-/* TODO
+// AlterColumn represents an ALTER COLUMN clause of an ALTER TABLE statement.
 type AlterColumn struct {
+	Name       string
+	Alteration ColumnAlteration
 }
-*/
+
+// ColumnAlteration is satisfied by SetColumnType and SetColumnOptions.
+type ColumnAlteration interface {
+	isColumnAlteration()
+	SQL() string
+}
+
+func (SetColumnType) isColumnAlteration()    {}
+func (SetColumnOptions) isColumnAlteration() {}
+
+// SetColumnType changes the type of a column, and whether it may be NULL.
+type SetColumnType struct {
+	Type    Type
+	NotNull bool
+}
+
+// SetColumnOptions changes the options of a column.
+type SetColumnOptions struct{ Options ColumnOptions }
+
+// AlterIndex represents an ALTER INDEX statement.
+// https://cloud.google.com/spanner/docs/data-definition-language#alter-index
+type AlterIndex struct {
+	Name       string
+	Alteration IndexAlteration
+
+	Position Position // position of the "ALTER" token
+}
+
+// IndexAlteration is satisfied by AddStoredColumn and DropStoredColumn.
+type IndexAlteration interface {
+	isIndexAlteration()
+	SQL() string
+}
+
+func (AddStoredColumn) isIndexAlteration()  {}
+func (DropStoredColumn) isIndexAlteration() {}
+
+type AddStoredColumn struct{ Name string }
+type DropStoredColumn struct{ Name string }
 
 // ColumnDef represents a column definition as part of a CREATE TABLE
 // or ALTER TABLE statement.
@@ -100,13 +157,25 @@ type ColumnDef struct {
 	Name    string
 	Type    Type
 	NotNull bool
+	Options ColumnOptions
+
+	Position Position // position of the column name
+}
+
+// ColumnOptions represents the options of a column.
+// https://cloud.google.com/spanner/docs/data-definition-language#column_options
+type ColumnOptions struct {
+	// AllowCommitTimestamp is nil if the option is not set,
+	// true for "allow_commit_timestamp = true",
+	// and false for "allow_commit_timestamp = null".
+	AllowCommitTimestamp *bool
 }
 
 // Type represents a column type.
 type Type struct {
 	Array bool
 	Base  TypeBase // Bool, Int64, Float64, String, Bytes, Date, Timestamp
-	Len   int64    // if Base is String or Bytes; may be MaxLen
+	Len   int64    // if Base is String or Bytes; may be MaxLen, or 0 if unspecified (as in CAST)
 }
 
 // MaxLen is a sentinel for Type's Len field, representing the MAX value.
@@ -134,25 +203,96 @@ type KeyPart struct {
 // https://cloud.google.com/spanner/docs/query-syntax#sql-syntax
 type Query struct {
 	Select Select
-	Order  []Order
-	Limit  Limit
+
+	// SetOps combine the result of Select with those of further SELECTs.
+	// They are applied in order, left to right.
+	SetOps []SetOp
+
+	Order []Order
+	Limit Limit
+}
+
+// SetOp represents a set operation that combines the result of the preceding
+// part of a query with that of another SELECT.
+// https://cloud.google.com/spanner/docs/query-syntax#set-operators
+type SetOp struct {
+	Op     SetOperator
+	All    bool // ALL rather than DISTINCT
+	Select Select
 }
 
+type SetOperator int
+
+const (
+	Union SetOperator = iota
+	Intersect
+	Except
+)
+
 // Select represents a SELECT statement.
 // https://cloud.google.com/spanner/docs/query-syntax#select-list
 type Select struct {
-	List  []Expr
-	From  []SelectFrom
-	Where BoolExpr
-	// TODO: GroupBy, Having
+	List    []Expr
+	From    []SelectFrom
+	Where   BoolExpr
+	GroupBy []Expr
+	Having  BoolExpr
 }
 
-type SelectFrom struct {
-	// This only supports a FROM clause directly from a table.
+// SelectFrom is satisfied by the types that can appear in the FROM clause of a SELECT statement.
+// https://cloud.google.com/spanner/docs/query-syntax#from-clause
+type SelectFrom interface {
+	isSelectFrom()
+	SQL() string
+}
+
+func (SelectFromTable) isSelectFrom()    {}
+func (SelectFromJoin) isSelectFrom()     {}
+func (SelectFromSubquery) isSelectFrom() {}
+func (SelectFromUnnest) isSelectFrom()   {}
+
+// SelectFromTable is a SelectFrom that reads from a table.
+type SelectFromTable struct {
 	Table       string
+	Hints       map[string]string // keyed by upper case hint key, e.g. FORCE_INDEX
+	Alias       string            // empty if not aliased
 	TableSample *TableSample
 }
 
+// SelectFromJoin is a SelectFrom that joins two other SelectFroms.
+// https://cloud.google.com/spanner/docs/query-syntax#join-types
+type SelectFromJoin struct {
+	Type     JoinType
+	LHS, RHS SelectFrom
+
+	// At most one of On or Using is set. Neither is set for a CROSS JOIN.
+	On    BoolExpr
+	Using []string
+}
+
+type JoinType int
+
+const (
+	InnerJoin JoinType = iota
+	CrossJoin
+	FullJoin
+	LeftJoin
+	RightJoin
+)
+
+// SelectFromSubquery is a SelectFrom that reads from the result of a subquery.
+type SelectFromSubquery struct {
+	Query Query
+	Alias string // empty if not aliased
+}
+
+// SelectFromUnnest is a SelectFrom that reads the elements of an array.
+// https://cloud.google.com/spanner/docs/query-syntax#unnest
+type SelectFromUnnest struct {
+	Expr  Expr
+	Alias string // empty if not aliased
+}
+
 type Order struct {
 	Expr Expr
 	Desc bool
@@ -245,23 +385,130 @@ type IsOp struct {
 func (IsOp) isBoolExpr() {}
 func (IsOp) isExpr()     {}
 
+// InOp represents an IN expression.
+// "<LHS> [NOT] IN (<RHS>, ...)" or "<LHS> [NOT] IN (<Subquery>)".
+type InOp struct {
+	LHS Expr
+	Neg bool
+
+	// Exactly one of RHS and Subquery is set.
+	RHS      []Expr
+	Subquery *Query
+
+	// Unnest is set if RHS is a single array expression, "<LHS> [NOT] IN UNNEST(<RHS>)".
+	Unnest bool
+}
+
+func (InOp) isBoolExpr() {}
+func (InOp) isExpr()     {}
+
+// ExistsOp represents an EXISTS expression, "EXISTS (<Query>)".
+type ExistsOp struct {
+	Query Query
+}
+
+func (ExistsOp) isBoolExpr() {}
+func (ExistsOp) isExpr()     {}
+
+// ScalarSubquery represents a subquery used as an expression,
+// which must produce a single column and at most one row.
+type ScalarSubquery struct {
+	Query Query
+}
+
+func (ScalarSubquery) isBoolExpr() {} // possibly bool
+func (ScalarSubquery) isExpr()     {}
+
 type IsExpr interface {
 	isIsExpr()
 	isExpr()
 	SQL() string
 }
 
+// ArithOp represents an arithmetic, concatenation or bitwise operation.
+// https://cloud.google.com/spanner/docs/functions-and-operators#arithmetic_operators
+type ArithOp struct {
+	Op       ArithOperator
+	LHS, RHS Expr // only RHS is set for Neg, Plus, BitNot
+}
+
+func (ArithOp) isExpr() {}
+
+type ArithOperator int
+
+const (
+	Neg    ArithOperator = iota // unary -
+	Plus                        // unary +
+	BitNot                      // unary ~
+	Mul                         // *
+	Div                         // /
+	Concat                      // ||
+	Add                         // +
+	Sub                         // -
+	BitShl                      // <<
+	BitShr                      // >>
+	BitAnd                      // &
+	BitXor                      // ^
+	BitOr                       // |
+)
+
+// Case represents a CASE expression.
+// https://cloud.google.com/spanner/docs/conditional_expressions#case_expr
+type Case struct {
+	Expr        Expr // nil for "CASE WHEN <cond> ..."
+	WhenClauses []WhenClause
+	ElseResult  Expr // may be nil
+}
+
+func (Case) isBoolExpr() {} // possibly bool
+func (Case) isExpr()     {}
+
+// WhenClause represents a WHEN clause of a CASE expression.
+// Cond is compared with the CASE expression if there is one,
+// and is otherwise a boolean condition.
+type WhenClause struct {
+	Cond   Expr
+	Result Expr
+}
+
 // Func represents a function call.
 type Func struct {
-	Name string
-	Args []Expr
-
-	// TODO: various functions permit as-expressions, which might warrant different types in here.
+	Name     string
+	Args     []Expr
+	Distinct bool // for aggregate functions, e.g. COUNT(DISTINCT x)
 }
 
 func (Func) isBoolExpr() {} // possibly bool
 func (Func) isExpr()     {}
 
+// TypedExpr represents an expression with a type, "<Expr> AS <Type>".
+// It is the argument of CAST and SAFE_CAST.
+type TypedExpr struct {
+	Expr Expr
+	Type Type
+}
+
+func (TypedExpr) isExpr() {}
+
+// ExtractExpr represents "<Part> FROM <Expr> [AT TIME ZONE <TimeZone>]".
+// It is the argument of EXTRACT.
+type ExtractExpr struct {
+	Part     string // e.g. "DAY"
+	Expr     Expr
+	TimeZone Expr // may be nil
+}
+
+func (ExtractExpr) isExpr() {}
+
+// IntervalExpr represents "INTERVAL <Expr> <DatePart>".
+// It is an argument of TIMESTAMP_ADD and similar functions.
+type IntervalExpr struct {
+	Expr     Expr
+	DatePart string // e.g. "DAY"
+}
+
+func (IntervalExpr) isExpr() {}
+
 // Paren represents a parenthesised expression.
 type Paren struct {
 	Expr Expr
@@ -276,6 +523,13 @@ type ID string
 func (ID) isBoolExpr() {} // possibly bool
 func (ID) isExpr()     {}
 
+// PathExp represents a path expression, such as a column name
+// qualified by a table name or alias ("Singers.FirstName").
+type PathExp []ID
+
+func (PathExp) isBoolExpr() {} // possibly bool
+func (PathExp) isExpr()     {}
+
 // Param represents a query parameter.
 type Param string
 
@@ -326,6 +580,25 @@ type BytesLiteral string
 
 func (BytesLiteral) isExpr() {}
 
+// DateLiteral represents a date literal.
+// https://cloud.google.com/spanner/docs/lexical#date_literals
+type DateLiteral civil.Date
+
+func (DateLiteral) isExpr() {}
+
+// TimestampLiteral represents a timestamp literal.
+// The time is in UTC.
+// https://cloud.google.com/spanner/docs/lexical#timestamp_literals
+type TimestampLiteral time.Time
+
+func (TimestampLiteral) isExpr() {}
+
+// Array represents an array literal.
+// https://cloud.google.com/spanner/docs/lexical#array_literals
+type Array []Expr
+
+func (Array) isExpr() {}
+
 type StarExpr int
 
 // Star represents a "*" in an expression.
@@ -339,16 +612,144 @@ func (StarExpr) isExpr() {}
 // DDL represents a Data Definition Language (DDL) file.
 type DDL struct {
 	List []DDLStmt
+
+	// Comments holds the comments in the file, in order.
+	// Use LeadingComment and InlineComment to find those attached to a Node.
+	Comments []*Comment
 }
 
 // DDLStmt is satisfied by a type that can appear in a DDL.
 type DDLStmt interface {
 	isDDLStmt()
 	SQL() string
+	Node
 }
 
 func (CreateTable) isDDLStmt() {}
 func (CreateIndex) isDDLStmt() {}
 func (AlterTable) isDDLStmt()  {}
+func (AlterIndex) isDDLStmt()  {}
 func (DropTable) isDDLStmt()   {}
 func (DropIndex) isDDLStmt()   {}
+
+// Node is satisfied by the parts of a DDL that may have comments attached.
+type Node interface {
+	Pos() Position
+}
+
+func (ct CreateTable) Pos() Position { return ct.Position }
+func (ci CreateIndex) Pos() Position { return ci.Position }
+func (at AlterTable) Pos() Position  { return at.Position }
+func (ai AlterIndex) Pos() Position  { return ai.Position }
+func (dt DropTable) Pos() Position   { return dt.Position }
+func (di DropIndex) Pos() Position   { return di.Position }
+func (cd ColumnDef) Pos() Position   { return cd.Position }
+
+// Position describes a source position in an input DDL file.
+// It is only valid if the line number is positive.
+type Position struct {
+	Line int // 1-based line number
+}
+
+func (pos Position) IsValid() bool { return pos.Line > 0 }
+
+func (pos Position) String() string {
+	if pos.Line == 0 {
+		return ":<invalid>"
+	}
+	return fmt.Sprintf(":%d", pos.Line)
+}
+
+// Comment represents a comment in a DDL file.
+// Consecutive single line comments on their own lines are joined into one Comment.
+type Comment struct {
+	Marker   string // "#" or "--" or "/*"
+	Isolated bool   // whether the comment is not preceded by anything else on its line
+	// Start and End are the positions of the text of the comment,
+	// excluding its markers.
+	Start, End Position
+	// Text is the text of the comment, one element per line,
+	// with surrounding space removed.
+	Text []string
+}
+
+// LeadingComment returns the comment that ends on the line before the node,
+// or on its line but before it, or nil if there's no such comment.
+func (ddl *DDL) LeadingComment(n Node) *Comment {
+	pos := n.Pos()
+	for _, c := range ddl.Comments {
+		// An isolated comment on the node's line must precede it.
+		if c.Isolated && (c.End.Line == pos.Line-1 || c.End.Line == pos.Line) {
+			return c
+		}
+	}
+	return nil
+}
+
+// InlineComment returns the comment that follows something else
+// on the node's line, or nil if there's no such comment.
+func (ddl *DDL) InlineComment(n Node) *Comment {
+	pos := n.Pos()
+	for _, c := range ddl.Comments {
+		if !c.Isolated && c.Start.Line == pos.Line {
+			return c
+		}
+	}
+	return nil
+}
+
+// DML
+// https://cloud.google.com/spanner/docs/dml-syntax
+
+// DMLStmt is satisfied by a type that is a DML statement.
+type DMLStmt interface {
+	isDMLStmt()
+	SQL() string
+}
+
+func (Insert) isDMLStmt() {}
+func (Update) isDMLStmt() {}
+func (Delete) isDMLStmt() {}
+
+// Insert represents an INSERT statement.
+// https://cloud.google.com/spanner/docs/dml-syntax#insert-statement
+type Insert struct {
+	Table   string
+	Columns []string
+	Input   ValuesOrSelect
+}
+
+// ValuesOrSelect is satisfied by Values and Query,
+// the two forms of input to an INSERT statement.
+type ValuesOrSelect interface {
+	isValuesOrSelect()
+	SQL() string
+}
+
+func (Values) isValuesOrSelect() {}
+func (Query) isValuesOrSelect()  {}
+
+// Values represents the rows of a VALUES clause of an INSERT statement.
+// A nil Expr represents DEFAULT.
+type Values [][]Expr
+
+// Update represents an UPDATE statement.
+// https://cloud.google.com/spanner/docs/dml-syntax#update-statement
+type Update struct {
+	Table string
+	Items []UpdateItem
+	Where BoolExpr
+}
+
+// UpdateItem represents a single assignment in the SET clause of an UPDATE statement.
+type UpdateItem struct {
+	Column string
+	Value  Expr // or nil for DEFAULT
+}
+
+// Delete represents a DELETE statement.
+// https://cloud.google.com/spanner/docs/dml-syntax#delete-statement
+type Delete struct {
+	Table string
+	Where BoolExpr
+}
@@ -41,4 +41,97 @@ var (
 		Measure:     OpenSessionCount,
 		Aggregation: view.LastValue(),
 	}
+
+	// MaxAllowedSessionsCount is a measure of the maximum number of sessions
+	// that the session pool may open.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	MaxAllowedSessionsCount = stats.Int64(statsPrefix+"max_allowed_sessions", "Maximum number of sessions allowed by the session pool",
+		stats.UnitDimensionless)
+
+	// MaxAllowedSessionsCountView is a view of the last value of
+	// MaxAllowedSessionsCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	MaxAllowedSessionsCountView = &view.View{
+		Name:        MaxAllowedSessionsCount.Name(),
+		Description: MaxAllowedSessionsCount.Description(),
+		Measure:     MaxAllowedSessionsCount,
+		Aggregation: view.LastValue(),
+	}
+
+	// IdleSessionCount is a measure of the number of idle sessions in the
+	// session pool.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	IdleSessionCount = stats.Int64(statsPrefix+"idle_session_count", "Number of idle sessions in the session pool",
+		stats.UnitDimensionless)
+
+	// IdleSessionCountView is a view of the last value of IdleSessionCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	IdleSessionCountView = &view.View{
+		Name:        IdleSessionCount.Name(),
+		Description: IdleSessionCount.Description(),
+		Measure:     IdleSessionCount,
+		Aggregation: view.LastValue(),
+	}
+
+	// InUseSessionCount is a measure of the number of sessions that are
+	// checked out of the session pool.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	InUseSessionCount = stats.Int64(statsPrefix+"in_use_session_count", "Number of sessions checked out of the session pool",
+		stats.UnitDimensionless)
+
+	// InUseSessionCountView is a view of the last value of InUseSessionCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	InUseSessionCountView = &view.View{
+		Name:        InUseSessionCount.Name(),
+		Description: InUseSessionCount.Description(),
+		Measure:     InUseSessionCount,
+		Aggregation: view.LastValue(),
+	}
+
+	// SessionWaiterCount is a measure of the number of requests that are
+	// waiting for a session to become available.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	SessionWaiterCount = stats.Int64(statsPrefix+"session_waiter_count", "Number of requests waiting for a session",
+		stats.UnitDimensionless)
+
+	// SessionWaiterCountView is a view of the last value of SessionWaiterCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	SessionWaiterCountView = &view.View{
+		Name:        SessionWaiterCount.Name(),
+		Description: SessionWaiterCount.Description(),
+		Measure:     SessionWaiterCount,
+		Aggregation: view.LastValue(),
+	}
+
+	// GetSessionTimeoutsCount is a measure of the number of requests that
+	// failed because their context was done before a session became available.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	GetSessionTimeoutsCount = stats.Int64(statsPrefix+"get_session_timeouts", "Number of requests that timed out waiting for a session",
+		stats.UnitDimensionless)
+
+	// GetSessionTimeoutsCountView is a view of the sum of
+	// GetSessionTimeoutsCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	GetSessionTimeoutsCountView = &view.View{
+		Name:        GetSessionTimeoutsCount.Name(),
+		Description: GetSessionTimeoutsCount.Description(),
+		Measure:     GetSessionTimeoutsCount,
+		Aggregation: view.Sum(),
+	}
+
+	// SessionCreationFailuresCount is a measure of the number of sessions
+	// whose creation failed.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	SessionCreationFailuresCount = stats.Int64(statsPrefix+"session_creation_failures", "Number of sessions whose creation failed",
+		stats.UnitDimensionless)
+
+	// SessionCreationFailuresCountView is a view of the sum of
+	// SessionCreationFailuresCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	SessionCreationFailuresCountView = &view.View{
+		Name:        SessionCreationFailuresCount.Name(),
+		Description: SessionCreationFailuresCount.Description(),
+		Measure:     SessionCreationFailuresCount,
+		Aggregation: view.Sum(),
+	}
 )
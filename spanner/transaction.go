@@ -180,7 +180,8 @@ func (t *txReadOnly) QueryWithStats(ctx context.Context, statement Statement) *R
 	return t.query(ctx, statement, sppb.ExecuteSqlRequest_PROFILE)
 }
 
-// AnalyzeQuery returns the query plan for statement.
+// AnalyzeQuery returns the query plan for statement. Package
+// cloud.google.com/go/spanner/queryplan can render and check the plan.
 func (t *txReadOnly) AnalyzeQuery(ctx context.Context, statement Statement) (*sppb.QueryPlan, error) {
 	iter := t.query(ctx, statement, sppb.ExecuteSqlRequest_PLAN)
 	defer iter.Stop()
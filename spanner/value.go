@@ -22,6 +22,7 @@ import (
 	"math"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/civil"
@@ -193,6 +194,121 @@ func (v GenericColumnValue) Decode(ptr interface{}) error {
 	return decodeValue(v.Value, v.Type, ptr)
 }
 
+// Encoder is the interface implemented by a custom type that can be encoded
+// to a Cloud Spanner value.
+//
+// EncodeSpanner returns a value of one of the types that the client library
+// supports, such as int64, string or NullString, which is then encoded in its
+// place. Encoders are honored for Statement parameters, mutation values
+// (including the fields of a struct passed to InsertStruct and related
+// functions), Key parts and the fields of structs used as STRUCT values.
+type Encoder interface {
+	EncodeSpanner() (interface{}, error)
+}
+
+// Decoder is the interface implemented by a custom type that can be decoded
+// from a Cloud Spanner value.
+//
+// DecodeSpanner is called with the value decoded into the natural Go type
+// for its Cloud Spanner type:
+//   - BOOL, INT64, FLOAT64, STRING, BYTES, TIMESTAMP and DATE values are
+//     passed as bool, int64, float64, string, []byte, time.Time and
+//     civil.Date respectively.
+//   - ARRAY values are passed as a slice of the matching Null type, such as
+//     []NullInt64, or as [][]byte for ARRAY<BYTES>.
+//   - STRUCT and ARRAY<STRUCT> values are passed as a GenericColumnValue.
+//   - NULL is passed as nil, except for arrays, which are passed as a nil
+//     slice of the matching type.
+//
+// Decoders are honored by Row.Column and related methods, Row.ToStruct and
+// GenericColumnValue.Decode. The Decoder is usually implemented by a pointer
+// type, which is what these methods are given.
+type Decoder interface {
+	DecodeSpanner(input interface{}) error
+}
+
+// decodableValue decodes v, of type t, into the value that is passed to
+// Decoder.DecodeSpanner.
+func decodableValue(v *proto3.Value, t *sppb.Type) (interface{}, error) {
+	var ptr interface{}
+	switch t.Code {
+	case sppb.TypeCode_BOOL:
+		ptr = new(NullBool)
+	case sppb.TypeCode_INT64:
+		ptr = new(NullInt64)
+	case sppb.TypeCode_FLOAT64:
+		ptr = new(NullFloat64)
+	case sppb.TypeCode_STRING:
+		ptr = new(NullString)
+	case sppb.TypeCode_BYTES:
+		ptr = new([]byte)
+	case sppb.TypeCode_TIMESTAMP:
+		ptr = new(NullTime)
+	case sppb.TypeCode_DATE:
+		ptr = new(NullDate)
+	case sppb.TypeCode_ARRAY:
+		if t.ArrayElementType == nil {
+			return nil, errNilArrElemType(t)
+		}
+		switch t.ArrayElementType.Code {
+		case sppb.TypeCode_BOOL:
+			ptr = new([]NullBool)
+		case sppb.TypeCode_INT64:
+			ptr = new([]NullInt64)
+		case sppb.TypeCode_FLOAT64:
+			ptr = new([]NullFloat64)
+		case sppb.TypeCode_STRING:
+			ptr = new([]NullString)
+		case sppb.TypeCode_BYTES:
+			ptr = new([][]byte)
+		case sppb.TypeCode_TIMESTAMP:
+			ptr = new([]NullTime)
+		case sppb.TypeCode_DATE:
+			ptr = new([]NullDate)
+		}
+	}
+	if ptr == nil {
+		return GenericColumnValue{Type: t, Value: v}, nil
+	}
+	if err := decodeValue(v, t, ptr); err != nil {
+		return nil, err
+	}
+	switch p := ptr.(type) {
+	case *NullBool:
+		if p.Valid {
+			return p.Bool, nil
+		}
+	case *NullInt64:
+		if p.Valid {
+			return p.Int64, nil
+		}
+	case *NullFloat64:
+		if p.Valid {
+			return p.Float64, nil
+		}
+	case *NullString:
+		if p.Valid {
+			return p.StringVal, nil
+		}
+	case *NullTime:
+		if p.Valid {
+			return p.Time, nil
+		}
+	case *NullDate:
+		if p.Valid {
+			return p.Date, nil
+		}
+	case *[]byte:
+		if *p != nil {
+			return *p, nil
+		}
+	default:
+		// An array.
+		return reflect.ValueOf(ptr).Elem().Interface(), nil
+	}
+	return nil, nil
+}
+
 // NewGenericColumnValue creates a GenericColumnValue from Go value that is
 // valid for Cloud Spanner.
 func newGenericColumnValue(v interface{}) (*GenericColumnValue, error) {
@@ -791,6 +907,14 @@ func decodeValue(v *proto3.Value, t *sppb.Type, ptr interface{}) error {
 		*p = y
 	case *GenericColumnValue:
 		*p = GenericColumnValue{Type: t, Value: v}
+	case Decoder:
+		x, err := decodableValue(v, t)
+		if err != nil {
+			return err
+		}
+		if err := p.DecodeSpanner(x); err != nil {
+			return spannerErrorf(codes.InvalidArgument, "cannot decode %T: %v", p, err)
+		}
 	default:
 		// Check if the proto encoding is for an array of structs.
 		if !(code == sppb.TypeCode_ARRAY && acode == sppb.TypeCode_STRUCT) {
@@ -1449,6 +1573,19 @@ func encodeValue(v interface{}) (*proto3.Value, *sppb.Type, error) {
 		pt = proto.Clone(v.Type).(*sppb.Type)
 	case []GenericColumnValue:
 		return nil, nil, errEncoderUnsupportedType(v)
+	case Encoder:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			// A nil pointer encodes as an untyped NULL.
+			break
+		}
+		x, err := v.EncodeSpanner()
+		if err != nil {
+			return nil, nil, spannerErrorf(codes.InvalidArgument, "cannot encode %T: %v", v, err)
+		}
+		if _, ok := x.(Encoder); ok {
+			return nil, nil, spannerErrorf(codes.InvalidArgument, "%T.EncodeSpanner returned another Encoder, %T", v, x)
+		}
+		return encodeValue(x)
 	default:
 		if !isStructOrArrayOfStructValue(v) {
 			return nil, nil, errEncoderUnsupportedType(v)
@@ -1584,7 +1721,7 @@ func isSupportedMutationType(v interface{}) bool {
 		float64, []float64, NullFloat64, []NullFloat64,
 		time.Time, []time.Time, NullTime, []NullTime,
 		civil.Date, []civil.Date, NullDate, []NullDate,
-		GenericColumnValue:
+		GenericColumnValue, Encoder:
 		return true
 	default:
 		return false
@@ -1622,12 +1759,15 @@ func encodeArray(len int, at func(int) interface{}) (*proto3.Value, error) {
 	return listProto(vs...), nil
 }
 
+// spannerTagParser parses a struct tag of the form `spanner:"name,option..."`.
+// The options, which only InferCreateTable uses, are returned as a []string.
 func spannerTagParser(t reflect.StructTag) (name string, keep bool, other interface{}, err error) {
 	if s := t.Get("spanner"); s != "" {
 		if s == "-" {
 			return "", false, nil, nil
 		}
-		return s, true, nil, nil
+		parts := strings.Split(s, ",")
+		return parts[0], true, parts[1:], nil
 	}
 	return "", true, nil, nil
 }
@@ -17,8 +17,10 @@ limitations under the License.
 package spanner
 
 import (
+	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 
@@ -1071,6 +1073,134 @@ func TestGenericColumnValue(t *testing.T) {
 	}
 }
 
+// customAmount is a custom type that is stored as an INT64 number of cents.
+type customAmount struct{ cents int64 }
+
+func (a customAmount) EncodeSpanner() (interface{}, error) { return a.cents, nil }
+
+func (a *customAmount) DecodeSpanner(input interface{}) error {
+	cents, ok := input.(int64)
+	if !ok {
+		return fmt.Errorf("got %T, want int64", input)
+	}
+	a.cents = cents
+	return nil
+}
+
+// customTags is a custom type that is stored as an ARRAY<STRING>.
+type customTags map[string]bool
+
+func (ts customTags) EncodeSpanner() (interface{}, error) {
+	var ss []string
+	for t := range ts {
+		ss = append(ss, t)
+	}
+	sort.Strings(ss)
+	return ss, nil
+}
+
+func (ts *customTags) DecodeSpanner(input interface{}) error {
+	ns, ok := input.([]NullString)
+	if !ok {
+		return fmt.Errorf("got %T, want []NullString", input)
+	}
+	*ts = nil
+	if ns != nil {
+		*ts = customTags{}
+	}
+	for _, n := range ns {
+		(*ts)[n.StringVal] = true
+	}
+	return nil
+}
+
+func TestEncodeDecodeCustomTypes(t *testing.T) {
+	// Encoding.
+	for _, test := range []struct {
+		in       interface{}
+		want     *proto3.Value
+		wantType *sppb.Type
+	}{
+		{customAmount{150}, intProto(150), intType()},
+		{customTags{"b": true, "a": true}, listProto(stringProto("a"), stringProto("b")), listType(stringType())},
+		{customTags(nil), nullProto(), listType(stringType())},
+		{(*customAmount)(nil), nullProto(), nil},
+	} {
+		got, gotType, err := encodeValue(test.in)
+		if err != nil {
+			t.Errorf("encodeValue(%#v): %v", test.in, err)
+			continue
+		}
+		if !testEqual(got, test.want) || !testEqual(gotType, test.wantType) {
+			t.Errorf("encodeValue(%#v) = %v, %v; want %v, %v", test.in, got, gotType, test.want, test.wantType)
+		}
+	}
+
+	// Decoding.
+	var a customAmount
+	if err := decodeValue(intProto(42), intType(), &a); err != nil || a.cents != 42 {
+		t.Errorf("decoding INT64 into customAmount: got %v, %v; want 42", a, err)
+	}
+	if err := decodeValue(stringProto("42"), stringType(), &a); err == nil {
+		t.Errorf("decoding STRING into customAmount succeeded, should have failed")
+	}
+	var ts customTags
+	if err := decodeValue(listProto(stringProto("x")), listType(stringType()), &ts); err != nil || !testEqual(ts, customTags{"x": true}) {
+		t.Errorf("decoding ARRAY<STRING> into customTags: got %v, %v", ts, err)
+	}
+	if err := decodeValue(nullProto(), listType(stringType()), &ts); err != nil || ts != nil {
+		t.Errorf("decoding NULL ARRAY<STRING> into customTags: got %v, %v; want nil", ts, err)
+	}
+
+	// Structs, with InsertStruct and ToStruct.
+	type account struct {
+		ID      int64
+		Balance customAmount
+		Tags    customTags
+	}
+	in := account{ID: 1, Balance: customAmount{99}, Tags: customTags{"vip": true}}
+	m, err := InsertStruct("Accounts", in)
+	if err != nil {
+		t.Fatalf("InsertStruct: %v", err)
+	}
+	mp, err := m.proto()
+	if err != nil {
+		t.Fatalf("Encoding mutation: %v", err)
+	}
+	wantVals := listValueProto(intProto(1), intProto(99), listProto(stringProto("vip")))
+	if got := mp.GetInsert().Values[0]; !testEqual(got, wantVals) {
+		t.Errorf("InsertStruct values = %v, want %v", got, wantVals)
+	}
+	row, err := NewRow([]string{"ID", "Balance", "Tags"}, []interface{}{in.ID, in.Balance, in.Tags})
+	if err != nil {
+		t.Fatalf("NewRow: %v", err)
+	}
+	var out account
+	if err := row.ToStruct(&out); err != nil {
+		t.Fatalf("ToStruct: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("ToStruct = %+v, want %+v", out, in)
+	}
+
+	// Statement parameters and keys.
+	stmt := Statement{SQL: "SELECT @amount", Params: map[string]interface{}{"amount": customAmount{5}}}
+	params, types, err := stmt.convertParams()
+	if err != nil {
+		t.Fatalf("convertParams: %v", err)
+	}
+	if !testEqual(params.Fields["amount"], intProto(5)) || !testEqual(types["amount"], intType()) {
+		t.Errorf("convertParams = %v, %v", params, types)
+	}
+	kp, err := Key{customAmount{7}}.proto()
+	if err != nil {
+		t.Fatalf("Key.proto: %v", err)
+	}
+	if !testEqual(kp, listValueProto(intProto(7))) {
+		t.Errorf("Key.proto = %v", kp)
+	}
+}
+
 func TestDecodeStruct(t *testing.T) {
 	stype := &sppb.StructType{Fields: []*sppb.StructType_Field{
 		{Name: "Id", Type: stringType()},